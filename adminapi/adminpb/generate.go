@@ -0,0 +1,9 @@
+// Package adminpb holds the gRPC bindings of the cluster management service
+// defined in admin.proto. See the adminapi package for its implementation.
+//
+// The bindings are generated with "make generate", which compiles admin.proto
+// with buf and the plugins pinned in buf.gen.yaml. Since buf has its own
+// compiler, the generated files report an unknown protoc version.
+package adminpb
+
+//go:generate go run github.com/bufbuild/buf/cmd/buf@v1.28.1 generate
@@ -0,0 +1,97 @@
+package adminapi
+
+import (
+	"context"
+
+	"github.com/canonical/go-dqlite/adminapi/adminpb"
+	"github.com/canonical/go-dqlite/client"
+	"google.golang.org/grpc"
+)
+
+// Register registers the service on the given gRPC server, as the Admin
+// service defined in adminpb/admin.proto.
+func (s *Service) Register(server *grpc.Server) {
+	adminpb.RegisterAdminServer(server, &adminServer{service: s})
+}
+
+// Implementation of the generated server interface, delegating each method to
+// the matching Service method.
+type adminServer struct {
+	adminpb.UnimplementedAdminServer
+	service *Service
+}
+
+func (s *adminServer) ListNodes(ctx context.Context, req *adminpb.ListNodesRequest) (*adminpb.ListNodesResponse, error) {
+	nodes, err := s.service.ListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &adminpb.ListNodesResponse{Nodes: encodeNodes(nodes)}, nil
+}
+
+func (s *adminServer) AddNode(ctx context.Context, req *adminpb.AddNodeRequest) (*adminpb.AddNodeResponse, error) {
+	if err := s.service.AddNode(ctx, decodeNode(req.Node)); err != nil {
+		return nil, err
+	}
+	return &adminpb.AddNodeResponse{}, nil
+}
+
+func (s *adminServer) RemoveNode(ctx context.Context, req *adminpb.RemoveNodeRequest) (*adminpb.RemoveNodeResponse, error) {
+	if err := s.service.RemoveNode(ctx, req.Id); err != nil {
+		return nil, err
+	}
+	return &adminpb.RemoveNodeResponse{}, nil
+}
+
+func (s *adminServer) AssignRole(ctx context.Context, req *adminpb.AssignRoleRequest) (*adminpb.AssignRoleResponse, error) {
+	if err := s.service.AssignRole(ctx, req.Id, client.NodeRole(req.Role)); err != nil {
+		return nil, err
+	}
+	return &adminpb.AssignRoleResponse{}, nil
+}
+
+func (s *adminServer) TransferLeadership(ctx context.Context, req *adminpb.TransferLeadershipRequest) (*adminpb.TransferLeadershipResponse, error) {
+	if err := s.service.TransferLeadership(ctx, req.Id); err != nil {
+		return nil, err
+	}
+	return &adminpb.TransferLeadershipResponse{}, nil
+}
+
+func (s *adminServer) Backup(req *adminpb.BackupRequest, stream adminpb.Admin_BackupServer) error {
+	return s.service.Backup(stream.Context(), req.Database, func(chunk BackupChunk) error {
+		return stream.Send(&adminpb.BackupChunk{
+			File:   chunk.File,
+			Offset: chunk.Offset,
+			Data:   chunk.Data,
+			Last:   chunk.Last,
+		})
+	})
+}
+
+func (s *adminServer) WatchCluster(req *adminpb.WatchClusterRequest, stream adminpb.Admin_WatchClusterServer) error {
+	return s.service.WatchCluster(stream.Context(), func(status ClusterStatus) error {
+		return stream.Send(&adminpb.ClusterStatus{
+			Leader: encodeNode(status.Leader),
+			Nodes:  encodeNodes(status.Nodes),
+			Error:  status.Error,
+		})
+	})
+}
+
+// Convert a node to its protobuf message. The values of the roles of
+// admin.proto match the ones of client.NodeRole.
+func encodeNode(node client.NodeInfo) *adminpb.Node {
+	return &adminpb.Node{Id: node.ID, Address: node.Address, Role: adminpb.Role(node.Role)}
+}
+
+func encodeNodes(nodes []client.NodeInfo) []*adminpb.Node {
+	encoded := make([]*adminpb.Node, len(nodes))
+	for i, node := range nodes {
+		encoded[i] = encodeNode(node)
+	}
+	return encoded
+}
+
+func decodeNode(node *adminpb.Node) client.NodeInfo {
+	return client.NodeInfo{ID: node.GetId(), Address: node.GetAddress(), Role: client.NodeRole(node.GetRole())}
+}
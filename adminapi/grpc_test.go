@@ -0,0 +1,131 @@
+package adminapi_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/adminapi"
+	"github.com/canonical/go-dqlite/adminapi/adminpb"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRegister_Membership(t *testing.T) {
+	server, admin := newAdminClient(t)
+
+	server.SetCluster([]protocol.NodeInfo{
+		{ID: 1, Address: server.Address(), Role: protocol.Voter},
+		{ID: 2, Address: "127.0.0.1:9002", Role: protocol.Spare},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listed, err := admin.ListNodes(ctx, &adminpb.ListNodesRequest{})
+	require.NoError(t, err)
+	require.Len(t, listed.Nodes, 2)
+	assert.Equal(t, uint64(2), listed.Nodes[1].Id)
+	assert.Equal(t, "127.0.0.1:9002", listed.Nodes[1].Address)
+	assert.Equal(t, adminpb.Role_SPARE, listed.Nodes[1].Role)
+
+	_, err = admin.AssignRole(ctx, &adminpb.AssignRoleRequest{Id: 2, Role: adminpb.Role_STAND_BY})
+	require.NoError(t, err)
+
+	// Errors of the service are returned to the caller.
+	_, err = admin.AddNode(ctx, &adminpb.AddNodeRequest{Node: &adminpb.Node{Address: "127.0.0.1:9003"}})
+	assert.EqualError(t, err, "rpc error: code = Unknown desc = node ID and address are required")
+
+	types := []uint8{}
+	for _, request := range server.Requests() {
+		types = append(types, request.Type)
+	}
+	assert.Equal(t, []uint8{protocol.RequestCluster, protocol.RequestAssign}, types)
+}
+
+func TestRegister_Backup(t *testing.T) {
+	server, admin := newAdminClient(t, adminapi.WithChunkSize(4))
+
+	server.SetDump("test", []fakeserver.File{
+		{Name: "test", Data: []byte("0123456789")},
+		{Name: "test-wal", Data: []byte("abcd")},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := admin.Backup(ctx, &adminpb.BackupRequest{Database: "test"})
+	require.NoError(t, err)
+
+	chunks := []*adminpb.BackupChunk{}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, chunk)
+	}
+
+	require.Len(t, chunks, 4)
+	assert.Equal(t, "test", chunks[2].File)
+	assert.Equal(t, int64(8), chunks[2].Offset)
+	assert.Equal(t, []byte("89"), chunks[2].Data)
+	assert.True(t, chunks[2].Last)
+	assert.Equal(t, "test-wal", chunks[3].File)
+}
+
+func TestRegister_WatchCluster(t *testing.T) {
+	server, admin := newAdminClient(t, adminapi.WithWatchInterval(10*time.Millisecond))
+
+	server.SetCluster([]protocol.NodeInfo{{ID: 1, Address: server.Address(), Role: protocol.Voter}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := admin.WatchCluster(ctx, &adminpb.WatchClusterRequest{})
+	require.NoError(t, err)
+
+	status, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), status.Leader.Id)
+	assert.Len(t, status.Nodes, 1)
+
+	server.SetCluster([]protocol.NodeInfo{
+		{ID: 1, Address: server.Address(), Role: protocol.Voter},
+		{ID: 2, Address: "127.0.0.1:9002", Role: protocol.Spare},
+	})
+
+	status, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Len(t, status.Nodes, 2)
+	assert.Empty(t, status.Error)
+}
+
+// Return a client of a service registered on a gRPC server listening in
+// memory, and backed by a fake node.
+func newAdminClient(t *testing.T, options ...adminapi.Option) (*fakeserver.Server, adminpb.AdminClient) {
+	server, service := newService(t, options...)
+	t.Cleanup(func() { server.Close() })
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	service.Register(grpcServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	dial := func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dial), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return server, adminpb.NewAdminClient(conn)
+}
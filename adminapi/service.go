@@ -0,0 +1,237 @@
+// Package adminapi implements a typed cluster management service for
+// orchestration systems, backed by client.Client.
+//
+// The service is defined in adminpb/admin.proto as a gRPC service. The
+// Service type implements its methods with plain Go types and callbacks for
+// the streaming ones, and Register exposes them on a gRPC server through the
+// bindings of the adminpb package.
+package adminapi
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// Backend provides clients connected to the leader of a cluster. It's
+// implemented by *app.App.
+type Backend interface {
+	Leader(ctx context.Context) (*client.Client, error)
+}
+
+// BackupChunk holds a portion of a database file streamed by Backup.
+type BackupChunk struct {
+	File   string // Name of the file the data belongs to.
+	Offset int64  // Offset of the data within the file.
+	Data   []byte
+	Last   bool // Whether this is the last chunk of the file.
+}
+
+// ClusterStatus is the status of a cluster streamed by WatchCluster.
+type ClusterStatus struct {
+	Leader client.NodeInfo
+	Nodes  []client.NodeInfo
+	Error  string // Set when the leader could not be reached.
+}
+
+// Option can be used to tweak service parameters.
+type Option func(*options)
+
+// WithChunkSize sets the maximum size of the chunks sent by Backup, 1MB by
+// default.
+func WithChunkSize(size int) Option {
+	return func(options *options) {
+		options.ChunkSize = size
+	}
+}
+
+// WithWatchInterval sets how often WatchCluster polls the cluster for
+// changes, 5 seconds by default.
+func WithWatchInterval(interval time.Duration) Option {
+	return func(options *options) {
+		options.WatchInterval = interval
+	}
+}
+
+type options struct {
+	ChunkSize     int
+	WatchInterval time.Duration
+}
+
+// Create a options object with sane defaults.
+func defaultOptions() *options {
+	return &options{
+		ChunkSize:     1024 * 1024,
+		WatchInterval: 5 * time.Second,
+	}
+}
+
+// Service implements the cluster management operations.
+type Service struct {
+	backend       Backend
+	chunkSize     int
+	watchInterval time.Duration
+}
+
+// New creates a new service using the given backend.
+func New(backend Backend, options ...Option) *Service {
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+
+	return &Service{
+		backend:       backend,
+		chunkSize:     o.ChunkSize,
+		watchInterval: o.WatchInterval,
+	}
+}
+
+// ListNodes returns the members of the cluster.
+func (s *Service) ListNodes(ctx context.Context) ([]client.NodeInfo, error) {
+	var nodes []client.NodeInfo
+	err := s.withLeader(ctx, func(cli *client.Client) (err error) {
+		nodes, err = cli.Cluster(ctx)
+		return err
+	})
+	return nodes, err
+}
+
+// AddNode adds a new node to the cluster.
+func (s *Service) AddNode(ctx context.Context, node client.NodeInfo) error {
+	if node.ID == 0 || node.Address == "" {
+		return fmt.Errorf("node ID and address are required")
+	}
+	return s.withLeader(ctx, func(cli *client.Client) error {
+		return cli.Add(ctx, node)
+	})
+}
+
+// RemoveNode removes a node from the cluster.
+func (s *Service) RemoveNode(ctx context.Context, id uint64) error {
+	return s.withLeader(ctx, func(cli *client.Client) error {
+		return cli.Remove(ctx, id)
+	})
+}
+
+// AssignRole changes the role of a node.
+func (s *Service) AssignRole(ctx context.Context, id uint64, role client.NodeRole) error {
+	switch role {
+	case client.Voter, client.StandBy, client.Spare:
+	default:
+		return fmt.Errorf("unknown role %d", role)
+	}
+	return s.withLeader(ctx, func(cli *client.Client) error {
+		return cli.Assign(ctx, id, role)
+	})
+}
+
+// TransferLeadership transfers leadership to the voter with the given ID.
+func (s *Service) TransferLeadership(ctx context.Context, id uint64) error {
+	return s.withLeader(ctx, func(cli *client.Client) error {
+		return cli.Transfer(ctx, id)
+	})
+}
+
+// Backup dumps the database with the given name and passes its files to the
+// given function in chunks, in order. It stops at the first error returned
+// by the function.
+func (s *Service) Backup(ctx context.Context, database string, send func(BackupChunk) error) error {
+	var files []client.File
+	err := s.withLeader(ctx, func(cli *client.Client) (err error) {
+		files, err = cli.Dump(ctx, database)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		offset := 0
+		for {
+			end := offset + s.chunkSize
+			if end > len(file.Data) {
+				end = len(file.Data)
+			}
+			chunk := BackupChunk{
+				File:   file.Name,
+				Offset: int64(offset),
+				Data:   file.Data[offset:end],
+				Last:   end == len(file.Data),
+			}
+			if err := send(chunk); err != nil {
+				return err
+			}
+			if chunk.Last {
+				break
+			}
+			offset = end
+		}
+	}
+
+	return nil
+}
+
+// WatchCluster passes the status of the cluster to the given function, first
+// right away and then each time it changes, until the context is done or the
+// function returns an error.
+//
+// Failures to reach the leader are reported through the Error field of the
+// status rather than ending the watch, since they are expected during
+// elections.
+func (s *Service) WatchCluster(ctx context.Context, send func(ClusterStatus) error) error {
+	var last *ClusterStatus
+
+	for {
+		status := s.status(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if last == nil || !reflect.DeepEqual(*last, status) {
+			if err := send(status); err != nil {
+				return err
+			}
+			last = &status
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.watchInterval):
+		}
+	}
+}
+
+// Return the current status of the cluster.
+func (s *Service) status(ctx context.Context) ClusterStatus {
+	status := ClusterStatus{}
+	err := s.withLeader(ctx, func(cli *client.Client) error {
+		leader, err := cli.Leader(ctx)
+		if err != nil {
+			return err
+		}
+		if leader != nil {
+			status.Leader = *leader
+		}
+		status.Nodes, err = cli.Cluster(ctx)
+		return err
+	})
+	if err != nil {
+		return ClusterStatus{Error: err.Error()}
+	}
+	return status
+}
+
+// Run the given function with a client connected to the leader.
+func (s *Service) withLeader(ctx context.Context, f func(*client.Client) error) error {
+	cli, err := s.backend.Leader(ctx)
+	if err != nil {
+		return fmt.Errorf("find leader: %w", err)
+	}
+	defer cli.Close()
+
+	return f(cli)
+}
@@ -0,0 +1,115 @@
+package adminapi_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/adminapi"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Membership(t *testing.T) {
+	server, service := newService(t)
+	defer server.Close()
+
+	nodes := []protocol.NodeInfo{
+		{ID: 1, Address: server.Address(), Role: protocol.Voter},
+		{ID: 2, Address: "127.0.0.1:9002", Role: protocol.Spare},
+	}
+	server.SetCluster(nodes)
+
+	ctx := context.Background()
+
+	listed, err := service.ListNodes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, nodes, listed)
+
+	require.NoError(t, service.AssignRole(ctx, 2, client.StandBy))
+	require.NoError(t, service.TransferLeadership(ctx, 2))
+	require.NoError(t, service.RemoveNode(ctx, 2))
+
+	assert.Error(t, service.AssignRole(ctx, 2, client.NodeRole(9)))
+	assert.Error(t, service.AddNode(ctx, client.NodeInfo{Address: "127.0.0.1:9003"}))
+
+	types := []uint8{}
+	for _, request := range server.Requests() {
+		types = append(types, request.Type)
+	}
+	assert.Equal(t, []uint8{protocol.RequestCluster, protocol.RequestAssign, protocol.RequestTransfer, protocol.RequestRemove}, types)
+}
+
+func TestService_Backup(t *testing.T) {
+	server, service := newService(t, adminapi.WithChunkSize(4))
+	defer server.Close()
+
+	server.SetDump("test", []fakeserver.File{
+		{Name: "test", Data: []byte("0123456789")},
+		{Name: "test-wal", Data: []byte("abcd")},
+	})
+
+	chunks := []adminapi.BackupChunk{}
+	err := service.Backup(context.Background(), "test", func(chunk adminapi.BackupChunk) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Len(t, chunks, 4)
+	assert.Equal(t, adminapi.BackupChunk{File: "test", Offset: 8, Data: []byte("89"), Last: true}, chunks[2])
+	assert.Equal(t, adminapi.BackupChunk{File: "test-wal", Offset: 0, Data: []byte("abcd"), Last: true}, chunks[3])
+
+	data := bytes.Join([][]byte{chunks[0].Data, chunks[1].Data, chunks[2].Data}, nil)
+	assert.Equal(t, []byte("0123456789"), data)
+}
+
+func TestService_WatchCluster(t *testing.T) {
+	server, service := newService(t, adminapi.WithWatchInterval(10*time.Millisecond))
+	defer server.Close()
+
+	node := protocol.NodeInfo{ID: 1, Address: server.Address(), Role: protocol.Voter}
+	server.SetCluster([]protocol.NodeInfo{node})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	statuses := []adminapi.ClusterStatus{}
+	err := service.WatchCluster(ctx, func(status adminapi.ClusterStatus) error {
+		statuses = append(statuses, status)
+		if len(statuses) == 1 {
+			spare := protocol.NodeInfo{ID: 2, Address: "127.0.0.1:9002", Role: protocol.Spare}
+			server.SetCluster([]protocol.NodeInfo{node, spare})
+			return nil
+		}
+		cancel()
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+
+	require.Len(t, statuses, 2)
+	assert.Equal(t, uint64(1), statuses[0].Leader.ID)
+	assert.Len(t, statuses[0].Nodes, 1)
+	assert.Len(t, statuses[1].Nodes, 2)
+	assert.Empty(t, statuses[1].Error)
+}
+
+// Backend connecting to a single fake node.
+type backend struct {
+	address string
+}
+
+func (b *backend) Leader(ctx context.Context) (*client.Client, error) {
+	return client.New(ctx, b.address)
+}
+
+func newService(t *testing.T, options ...adminapi.Option) (*fakeserver.Server, *adminapi.Service) {
+	server, err := fakeserver.New(1)
+	require.NoError(t, err)
+
+	return server, adminapi.New(&backend{address: server.Address()}, options...)
+}
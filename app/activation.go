@@ -0,0 +1,52 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// First file descriptor passed by systemd to socket-activated services.
+const listenFdsStart = 3
+
+// SystemdListeners returns the listeners passed by systemd to a
+// socket-activated service, in the order of the ListenStream directives of
+// the socket unit, following the LISTEN_PID and LISTEN_FDS protocol. It
+// returns no listener if the process was not socket-activated.
+//
+// The environment variables are unset, so that child processes don't
+// inherit them.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		listener, err := net.FileListener(file)
+		file.Close() // The listener holds a duplicate of the descriptor.
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, fmt.Errorf("file descriptor %d: %w", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
@@ -2,18 +2,27 @@ package app
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/canonical/go-dqlite"
 	"github.com/canonical/go-dqlite/client"
 	"github.com/canonical/go-dqlite/driver"
+	"github.com/canonical/go-dqlite/internal/clock"
+	"github.com/canonical/go-dqlite/internal/logging"
+	"github.com/canonical/go-dqlite/internal/tracing"
+	"github.com/canonical/go-dqlite/metrics"
+	"github.com/canonical/go-dqlite/migrate"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // App is a high-level helper for initializing a typical dqlite-based Go
@@ -27,18 +36,63 @@ type App struct {
 	dir             string
 	node            *dqlite.Node
 	nodeBindAddress string
-	listener        net.Listener
+	listeners       []proxyListener
 	tls             *tlsSetup
+	auth            AuthFunc        // Validates tokens of proxied connections, if set.
+	authToken       string          // Token presented to other nodes, if set.
+	authorize       AuthorizeFunc   // Filters requests of proxied connections, if set.
+	bindIdentity    bool            // Whether node certificates must match node IDs.
+	dial            client.DialFunc // Base dial function, without TLS.
 	store           client.NodeStore
 	driver          *driver.Driver
 	driverName      string
 	log             client.LogFunc
+	clock           clock.Clock
+	metrics         *metrics.Metrics
+	tracerProvider  trace.TracerProvider
+	tracer          trace.Tracer
+	proxyAccessLog  func(ProxyConnection)
+	proxyMu         sync.Mutex              // Serialize access to proxyConns.
+	proxyConns      map[*proxyConn]struct{} // Connections currently proxied.
+	events          *eventBus
 	stop            context.CancelFunc // Signal App.run() to stop.
 	proxyCh         chan struct{}      // Waits for App.proxy() to return.
+	localListener   net.Listener       // Local socket listener, if set.
+	localAccess     LocalSocketAccess  // Processes allowed on the local socket.
+	localCh         chan struct{}      // Waits for App.proxyLocal() to return.
 	runCh           chan struct{}      // Waits for App.run() to return.
 	readyCh         chan struct{}      // Waits for startup tasks
+	localSnapshotCh chan struct{}      // Waits for App.takeLocalSnapshots() to return.
+	localSnapshots  *localSnapshotSetup
+	backupCh        chan struct{} // Waits for App.backupPeriodically() to return.
+	backups         *backupSchedule
+	migrations      map[string][]migrate.Migration // Migrations to apply, by database.
+	migratedMu      sync.Mutex                     // Serialize access to migrated.
+	migrated        map[string]bool                // Databases already migrated.
+	recordedMu      sync.Mutex                     // Serialize access to recorded.
+	recorded        map[string]bool                // Databases already recorded, see BackupAll.
 	voters          int
 	standbys        int
+	rolesMu         sync.Mutex                  // Serialize roles adjustments.
+	leaderProbes    int                         // Nodes probed concurrently when looking for the leader.
+	nonVoting       bool                        // Whether this node must never be a voter.
+	leadershipFunc  func(bool, client.NodeInfo) // Called when the observed leader changes, if set.
+	leader          *client.NodeInfo            // Last leader passed to leadershipFunc.
+	quorumTimeout   time.Duration               // Time after which the quorum is considered lost.
+	quorumAlert     func(QuorumAlert)           // Called when the quorum is lost or regained, if set.
+	quorumLost      bool                        // Whether the quorum was last seen as lost.
+	lastContact     time.Time                   // Last time the leader was found.
+	config          *Config                     // Cluster-wide configuration settings.
+	joinBackoff     time.Duration               // Initial delay between join attempts.
+	joinBackoffCap  time.Duration               // Maximum delay between join attempts.
+	closeTimeout    time.Duration               // Timeout of the handover performed by Close, if set.
+	joinErr         error                       // Set if joining failed for good.
+	labels          map[string]string           // Labels of this node.
+	voterSelector   map[string]string           // Labels that voters must have.
+	preferredLabels map[string]string           // Labels of the nodes to contact first.
+	debugListener   net.Listener                // Listener of the debug endpoints, if set.
+	debugServer     *http.Server                // Serves the debug endpoints, if set.
+	debugCh         chan struct{}               // Waits for the debug server to return.
 }
 
 // New creates a new application node.
@@ -48,6 +102,32 @@ func New(dir string, options ...Option) (app *App, err error) {
 		option(o)
 	}
 
+	if o.LogRateLimit > 0 {
+		o.Log = logging.RateLimit(o.Log, o.LogRateLimit, o.Clock)
+	}
+
+	if err := setupTLS(o); err != nil {
+		return nil, err
+	}
+
+	if o.JoinBackoffFactor <= 0 || o.JoinBackoffCap < o.JoinBackoffFactor {
+		return nil, fmt.Errorf("invalid join backoff %s with cap %s", o.JoinBackoffFactor, o.JoinBackoffCap)
+	}
+
+	if o.QuorumAlert != nil && o.QuorumTimeout <= 0 {
+		return nil, fmt.Errorf("invalid quorum alert timeout %s", o.QuorumTimeout)
+	}
+
+	var certID uint64
+	if o.NodeIdentityBinding {
+		if o.TLS == nil {
+			return nil, fmt.Errorf("node identity binding set without TLS configuration")
+		}
+		if certID, err = tlsNodeID(o.TLS); err != nil {
+			return nil, fmt.Errorf("node identity binding: %w", err)
+		}
+	}
+
 	// List of cleanup functions to run in case of errors.
 	cleanups := []func(){}
 	defer func() {
@@ -70,10 +150,28 @@ func New(dir string, options ...Option) (app *App, err error) {
 		if o.Address == "" {
 			o.Address = defaultAddress()
 		}
-		if len(o.Cluster) == 0 {
+		if len(o.InitialCluster) > 0 {
+			if len(o.Cluster) > 0 {
+				return nil, fmt.Errorf("initial cluster and cluster options are mutually exclusive")
+			}
+			for _, node := range o.InitialCluster {
+				if node.Address == o.Address {
+					info.ID = node.ID
+				}
+			}
+			if info.ID == 0 {
+				return nil, fmt.Errorf("address %q not found in initial cluster", o.Address)
+			}
+			if err := dqlite.BootstrapCluster(dir, o.InitialCluster); err != nil {
+				return nil, fmt.Errorf("bootstrap initial cluster: %w", err)
+			}
+		} else if len(o.Cluster) == 0 {
 			info.ID = dqlite.BootstrapID
 		} else {
 			info.ID = dqlite.GenerateID(o.Address)
+			if o.NodeIdentityBinding {
+				info.ID = certID
+			}
 			if err := fileWrite(dir, joinFile, []byte{}); err != nil {
 				return nil, err
 			}
@@ -94,6 +192,16 @@ func New(dir string, options ...Option) (app *App, err error) {
 		}
 	}
 
+	for _, setup := range o.ListenAddresses {
+		if setup.Address == info.Address {
+			return nil, fmt.Errorf("listen address %q is the node address", setup.Address)
+		}
+	}
+
+	if o.NodeIdentityBinding && info.ID != certID {
+		return nil, fmt.Errorf("node ID %d does not match certificate node ID %d", info.ID, certID)
+	}
+
 	joinFileExists, err := fileExists(dir, joinFile)
 	if err != nil {
 		return nil, err
@@ -104,6 +212,11 @@ func New(dir string, options ...Option) (app *App, err error) {
 	}
 
 	// Open the nodes store.
+	if o.StoreRepair && infoFileExists {
+		if err := repairStore(dir, o.Cluster, o.Log); err != nil {
+			return nil, fmt.Errorf("repair cluster.yaml: %w", err)
+		}
+	}
 	storeFileExists, err := fileExists(dir, storeFile)
 	if err != nil {
 		return nil, err
@@ -124,7 +237,9 @@ func New(dir string, options ...Option) (app *App, err error) {
 		// either with the node's address (for bootstrap nodes) or with
 		// the given cluster addresses (for joining nodes).
 		nodes := []client.NodeInfo{}
-		if info.ID == dqlite.BootstrapID {
+		if len(o.InitialCluster) > 0 {
+			nodes = append(nodes, o.InitialCluster...)
+		} else if info.ID == dqlite.BootstrapID {
 			nodes = append(nodes, client.NodeInfo{Address: info.Address})
 		} else {
 			if len(o.Cluster) == 0 {
@@ -140,10 +255,26 @@ func New(dir string, options ...Option) (app *App, err error) {
 		cleanups = append(cleanups, func() { fileRemove(dir, storeFile) })
 	}
 
+	dial := client.DefaultDialFunc
+	if o.Dial != nil {
+		dial = o.Dial
+	}
+
+	auth := o.Authenticator
+	if auth == nil && o.AuthToken != "" {
+		auth = tokenAuth(o.AuthToken)
+	}
+
+	// Incoming connections go through our proxy if they need to be
+	// decrypted, authenticated or authorized, if they can also come from
+	// the local socket or from other addresses, if the listener is given,
+	// or if parameter chunks must be assembled.
+	proxied := o.TLS != nil || auth != nil || o.Authorizer != nil || o.LocalSocket != "" || len(o.ListenAddresses) > 0 || o.Listener != nil || o.ChunkSize > 0
+
 	// Start the local dqlite engine.
 	var nodeBindAddress string
 	var nodeDial client.DialFunc
-	if o.TLS != nil {
+	if proxied {
 		nodeBindAddress = fmt.Sprintf("@dqlite-%d", info.ID)
 
 		// Within a snap we need to choose a different name for the abstract unix domain
@@ -154,16 +285,39 @@ func New(dir string, options ...Option) (app *App, err error) {
 			nodeBindAddress = fmt.Sprintf("@snap.%s.dqlite-%d", snapInstanceName, info.ID)
 		}
 
-		nodeDial = makeNodeDialFunc(o.TLS.Dial)
+		var dialConfig *tls.Config
+		if o.TLS != nil {
+			dialConfig = o.TLS.Dial
+		}
+		var bindStore client.NodeStore
+		if o.NodeIdentityBinding {
+			bindStore = store
+		}
+		nodeDial = makeNodeDialFunc(dial, dialConfig, o.AuthToken, bindStore)
 	} else {
 		nodeBindAddress = info.Address
-		nodeDial = client.DefaultDialFunc
+		nodeDial = dial
+		if o.Dial != nil {
+			// Custom dial functions might return connections that
+			// the engine can't use directly.
+			nodeDial = makeNodeDialFunc(dial, nil, "", nil)
+		}
 	}
-	node, err := dqlite.New(
-		info.ID, info.Address, dir,
+	nodeOptions := []dqlite.Option{
 		dqlite.WithBindAddress(nodeBindAddress),
 		dqlite.WithDialFunc(nodeDial),
-	)
+		dqlite.WithLogFunc(o.Log),
+	}
+	if o.SnapshotCompression != nil {
+		nodeOptions = append(nodeOptions, dqlite.WithSnapshotCompression(*o.SnapshotCompression))
+	}
+	if o.WorkerThreads != 0 {
+		nodeOptions = append(nodeOptions, dqlite.WithWorkerThreads(o.WorkerThreads))
+	}
+	if o.BlockSize != 0 {
+		nodeOptions = append(nodeOptions, dqlite.WithBlockSize(o.BlockSize))
+	}
+	node, err := dqlite.New(info.ID, info.Address, dir, nodeOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("create node: %w", err)
 	}
@@ -173,18 +327,11 @@ func New(dir string, options ...Option) (app *App, err error) {
 	cleanups = append(cleanups, func() { node.Close() })
 
 	// Register the local dqlite driver.
-	driverDial := client.DefaultDialFunc
-	if o.TLS != nil {
-		driverDial = client.DialFuncWithTLS(driverDial, o.TLS.Dial)
-	}
-
-	driver, err := driver.New(store, driver.WithDialFunc(driverDial), driver.WithLogFunc(o.Log))
+	drv, driverName, err := registerDriver(o, store, dial)
 	if err != nil {
-		return nil, fmt.Errorf("create driver: %w", err)
+		return nil, err
 	}
-	driverIndex++
-	driverName := fmt.Sprintf("dqlite-%d", driverIndex)
-	sql.Register(driverName, driver)
+	cleanups = append(cleanups, func() { driver.Unregister(driverName) })
 
 	if o.Voters < 3 || o.Voters%2 == 0 {
 		return nil, fmt.Errorf("invalid voters %d: must be an odd number greater than 1", o.Voters)
@@ -194,6 +341,24 @@ func New(dir string, options ...Option) (app *App, err error) {
 		return nil, fmt.Errorf("invalid stand-bys %d: must be an even number greater than 0", o.StandBys)
 	}
 
+	if o.LocalSnapshots != nil {
+		if o.LocalSnapshots.Interval <= 0 {
+			return nil, fmt.Errorf("invalid local snapshots interval %s", o.LocalSnapshots.Interval)
+		}
+		if err := os.MkdirAll(o.LocalSnapshots.Dir, 0755); err != nil {
+			return nil, fmt.Errorf("create local snapshots directory: %w", err)
+		}
+	}
+
+	if o.Backups != nil {
+		if o.Backups.Interval <= 0 {
+			return nil, fmt.Errorf("invalid backup interval %s", o.Backups.Interval)
+		}
+		if err := os.MkdirAll(o.Backups.Dir, 0755); err != nil {
+			return nil, fmt.Errorf("create backups directory: %w", err)
+		}
+	}
+
 	ctx, stop := context.WithCancel(context.Background())
 
 	app = &App{
@@ -203,45 +368,187 @@ func New(dir string, options ...Option) (app *App, err error) {
 		node:            node,
 		nodeBindAddress: nodeBindAddress,
 		store:           store,
-		driver:          driver,
+		driver:          drv,
 		driverName:      driverName,
 		log:             o.Log,
+		clock:           o.Clock,
+		metrics:         o.Metrics,
+		tracerProvider:  o.TracerProvider,
+		tracer:          tracing.Tracer(o.TracerProvider),
+		events:          newEventBus(),
+		proxyAccessLog:  o.ProxyAccessLog,
+		proxyConns:      map[*proxyConn]struct{}{},
 		tls:             o.TLS,
+		auth:            auth,
+		authToken:       o.AuthToken,
+		authorize:       o.Authorizer,
+		bindIdentity:    o.NodeIdentityBinding,
+		localAccess:     o.LocalSocketAccess,
+		migrations:      o.Migrations,
+		migrated:        map[string]bool{},
+		recorded:        map[string]bool{},
+		dial:            dial,
 		stop:            stop,
 		runCh:           make(chan struct{}, 0),
 		readyCh:         make(chan struct{}, 0),
 		voters:          o.Voters,
 		standbys:        o.StandBys,
+		leaderProbes:    o.LeaderProbes,
+		nonVoting:       o.NonVoting,
+		leadershipFunc:  o.OnLeadershipChange,
+		quorumTimeout:   o.QuorumTimeout,
+		quorumAlert:     o.QuorumAlert,
+		localSnapshots:  o.LocalSnapshots,
+		backups:         o.Backups,
+		joinBackoff:     o.JoinBackoffFactor,
+		joinBackoffCap:  o.JoinBackoffCap,
+		closeTimeout:    o.CloseTimeout,
+		labels:          o.Labels,
+		voterSelector:   o.VoterSelector,
+		preferredLabels: o.PreferredLabels,
 	}
-
-	// Start the proxy if a TLS configuration was provided.
-	if o.TLS != nil {
-		listener, err := net.Listen("tcp", info.Address)
+	app.config = &Config{app: app}
+
+	// Start the proxy if a TLS, authentication or authorization
+	// configuration, extra listen addresses or a listener were provided.
+	if proxied {
+		var listenConfig *tls.Config
+		if o.TLS != nil {
+			listenConfig = o.TLS.Listen
+		}
+		listeners, err := listenAll(info.Address, o.Listener, listenConfig, o.ListenAddresses)
 		if err != nil {
-			return nil, fmt.Errorf("listen to %s: %w", info.Address, err)
+			return nil, err
 		}
 		proxyCh := make(chan struct{}, 0)
 
-		app.listener = listener
+		app.listeners = listeners
 		app.proxyCh = proxyCh
 
 		go app.proxy()
 
-		cleanups = append(cleanups, func() { listener.Close(); <-proxyCh })
+		cleanups = append(cleanups, func() { app.closeListeners(); <-proxyCh })
 
 	}
 
+	if o.LocalSocket != "" {
+		listener, err := listenLocalSocket(o.LocalSocket)
+		if err != nil {
+			return nil, fmt.Errorf("listen to %s: %w", o.LocalSocket, err)
+		}
+		localCh := make(chan struct{}, 0)
+
+		app.localListener = listener
+		app.localCh = localCh
+
+		go app.proxyLocal()
+
+		cleanups = append(cleanups, func() { listener.Close(); <-localCh })
+	}
+
+	if o.DebugAddress != "" {
+		if err := app.serveDebug(o.DebugAddress); err != nil {
+			return nil, err
+		}
+		cleanups = append(cleanups, app.closeDebug)
+	}
+
 	go app.run(ctx, o.RolesAdjustmentFrequency, joinFileExists)
 
+	if app.localSnapshots != nil {
+		app.localSnapshotCh = make(chan struct{}, 0)
+		go app.takeLocalSnapshots(ctx)
+	}
+
+	if app.backups != nil {
+		app.backupCh = make(chan struct{}, 0)
+		go app.backupPeriodically(ctx)
+	}
+
 	return app, nil
 }
 
+// Validate the TLS related options, and apply the TLS policy and the peer
+// verifier to the TLS configurations.
+func setupTLS(o *options) error {
+	if o.TLSPolicy != nil {
+		if o.TLS == nil {
+			return fmt.Errorf("TLS policy set without TLS configuration")
+		}
+		if err := o.TLSPolicy.validate(); err != nil {
+			return fmt.Errorf("invalid TLS policy: %w", err)
+		}
+		o.TLS = o.TLS.withPolicy(*o.TLSPolicy)
+	}
+
+	if o.PeerVerifier != nil {
+		if o.TLS == nil {
+			return fmt.Errorf("peer verifier set without TLS configuration")
+		}
+		o.TLS = o.TLS.withVerifier(o.PeerVerifier)
+	}
+
+	for i, setup := range o.ListenAddresses {
+		if setup.TLS == nil {
+			continue
+		}
+		if o.TLSPolicy != nil {
+			setup.TLS = setup.TLS.Clone()
+			o.TLSPolicy.Apply(setup.TLS)
+		}
+		if o.PeerVerifier != nil {
+			setup.TLS = client.TLSConfigWithVerifier(setup.TLS, o.PeerVerifier)
+		}
+		o.ListenAddresses[i] = setup
+	}
+
+	return nil
+}
+
+// Create the dqlite driver used by the app and register it with a unique
+// name.
+func registerDriver(o *options, store client.NodeStore, dial client.DialFunc) (*driver.Driver, string, error) {
+	if o.NodeIdentityBinding {
+		dial = dialFuncWithNodeIdentity(dial, o.TLS.Dial, store)
+	} else if o.TLS != nil {
+		dial = client.DialFuncWithTLS(dial, o.TLS.Dial)
+	}
+
+	drv, err := driver.New(
+		store,
+		driver.WithDialFunc(dial),
+		driver.WithLogFunc(o.Log),
+		driver.WithMetrics(o.Metrics),
+		driver.WithTracerProvider(o.TracerProvider),
+		driver.WithSlowQueryThreshold(o.SlowQueryThreshold),
+		driver.WithSlowQueryRedaction(o.SlowQueryRedaction),
+		driver.WithSlowQueryExplain(o.SlowQueryExplain),
+		driver.WithAuditSink(o.AuditSink),
+		driver.WithQueryStats(o.QueryStats),
+		driver.WithProfilerLabels(o.ProfilerLabels),
+		driver.WithSharedConnections(o.SharedConnections),
+		driver.WithStatementRegistry(o.StatementRegistry),
+		driver.WithLeaderProbes(o.LeaderProbes),
+		driver.WithBufferSizes(o.BufferSize, o.MaxBufferSize),
+		driver.WithChunkedParameters(o.ChunkSize),
+		driver.WithAuthToken(o.AuthToken),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("create driver: %w", err)
+	}
+
+	return drv, driver.RegisterUnique(drv), nil
+}
+
 // Handover transfers all responsibilities for this node (such has leadership
-// and voting rights) to another node, if one is available.
+// and voting rights) to another node, if one is available, and demotes this
+// node to spare. If the nodes are proxied, for example because they use TLS,
+// it then waits for the promoted node to catch up with the leader's raft log,
+// so that the cluster keeps its redundancy once this node is gone.
 //
 // This method should always be called before invoking Close(), in order to
-// gracefully shutdown a node.
-func (a *App) Handover(ctx context.Context) error {
+// gracefully shutdown a node, unless WithCloseTimeout was used.
+func (a *App) Handover(ctx context.Context) (err error) {
 	// Set a hard limit of one minute, in case the user-provided context
 	// has no expiration. That avoids the call to hang forever in case a
 	// majority of the cluster is down and no leader is available.
@@ -249,6 +556,15 @@ func (a *App) Handover(ctx context.Context) error {
 	ctx, cancel = context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
+	// Client-only apps have nothing to hand over.
+	if a.node == nil {
+		return nil
+	}
+
+	var span trace.Span
+	ctx, span = tracing.Start(ctx, a.tracer, "dqlite.app.handover")
+	defer func() { tracing.End(span, err) }()
+
 	cli, err := a.Leader(ctx)
 	if err != nil {
 		return fmt.Errorf("find leader: %w", err)
@@ -303,6 +619,14 @@ func (a *App) Handover(ctx context.Context) error {
 		// rights, and they are preferred over spares.
 		if role == client.Voter {
 			candidates = append(index[client.StandBy][online], candidates...)
+
+			// Nodes that must not be voters are never candidates.
+			nonVoters, err := a.nonVoters(ctx, candidates)
+			if err != nil {
+				a.warn("get non-voting nodes: %v", err)
+			} else {
+				candidates = excludeNodes(candidates, nonVoters)
+			}
 		}
 
 		if len(candidates) == 0 {
@@ -310,6 +634,7 @@ func (a *App) Handover(ctx context.Context) error {
 			return nil
 		}
 
+		var promoted client.NodeInfo
 		for i, node := range candidates {
 			if err := cli.Assign(ctx, node.ID, role); err != nil {
 				a.warn("promote %s from %s to %s: %v", node.Address, node.Role, role, err)
@@ -320,28 +645,81 @@ func (a *App) Handover(ctx context.Context) error {
 				continue
 			}
 			a.debug("promoted %s from %s to %s", node.Address, node.Role, role)
+			promoted = node
 			break
 		}
 
+		// Check if we'll be able to wait for the promoted node to catch
+		// up before giving up our role, since only proxied App nodes
+		// report their raft log index.
+		follower, err := a.replicationClient(ctx, promoted)
+		if err != nil {
+			a.debug("can't wait for %s to catch up: %v", promoted.Address, err)
+		} else {
+			defer follower.Close()
+		}
+
 		// Demote ourselves.
 		if err := cli.Assign(ctx, a.ID(), client.Spare); err != nil {
 			return fmt.Errorf("demote ourselves: %w", err)
 		}
+
+		// Our role is transferred at this point, so failing to wait is
+		// not an error.
+		if follower != nil {
+			if err := waitReplication(ctx, cli, follower); err != nil {
+				a.warn("wait for %s to catch up: %v", promoted.Address, err)
+			}
+		}
 	}
 
 	return nil
 }
 
 // Close the application node, releasing all resources it created.
+//
+// If WithCloseTimeout was used, the node first hands over its
+// responsibilities, as Handover does, within that timeout.
 func (a *App) Close() error {
+	if a.closeTimeout > 0 && a.ready() {
+		ctx, cancel := context.WithTimeout(context.Background(), a.closeTimeout)
+		if err := a.Handover(ctx); err != nil {
+			a.warn("handover: %v", err)
+		}
+		cancel()
+	}
+
 	// Stop the run goroutine.
 	a.stop()
 	<-a.runCh
+	a.events.close()
+	a.config.close()
+
+	if a.leader != nil && a.leader.ID == a.id {
+		a.leadershipFunc(false, client.NodeInfo{})
+	}
+
+	if a.localSnapshotCh != nil {
+		<-a.localSnapshotCh
+	}
 
-	if a.listener != nil {
-		a.listener.Close()
+	if a.backupCh != nil {
+		<-a.backupCh
+	}
+
+	if a.listeners != nil {
+		a.closeListeners()
 		<-a.proxyCh
 	}
+	if a.localListener != nil {
+		a.localListener.Close()
+		<-a.localCh
+	}
+	a.closeDebug()
+	driver.Unregister(a.driverName)
+	if a.node == nil {
+		return nil
+	}
 	if err := a.node.Close(); err != nil {
 		return err
 	}
@@ -358,11 +736,18 @@ func (a *App) Address() string {
 	return a.address
 }
 
-// Driver returns the name used to register the dqlite driver.
+// Driver returns the name used to register the dqlite driver. The name is
+// released when the application is closed.
 func (a *App) Driver() string {
 	return a.driverName
 }
 
+// QueryStats returns the statistics of the statements executed through the
+// app's driver, or nil if they were not enabled with WithQueryStats.
+func (a *App) QueryStats() []driver.QueryStat {
+	return a.driver.QueryStats()
+}
+
 // Ready can be used to wait for a node to complete some initial tasks that are
 // initiated at startup. For example a brand new node will attempt to join the
 // cluster, a restarted node will check if it should assume some particular
@@ -370,18 +755,54 @@ func (a *App) Driver() string {
 //
 // If this method returns without error it means that those initial tasks have
 // succeeded and follow-up operations like Open() are more likely to succeeed
-// quickly.
+// quickly. If the cluster rejected the node, an error wrapping
+// ErrJoinRejected is returned.
 func (a *App) Ready(ctx context.Context) error {
 	select {
 	case <-a.readyCh:
-		return nil
+		return a.joinErr
 	case <-ctx.Done():
 		return ctx.Err()
 	}
 }
 
+// OpenOption can be used to tweak the behavior of App.Open.
+type OpenOption func(*openOptions)
+
+// WithInit runs the given function the first time the database is opened
+// with this option and the given schema version, across the whole cluster.
+// It runs in a transaction, in version order with the migrations registered
+// with WithMigrations, and nodes opening the database concurrently wait for
+// it to complete instead of racing it.
+//
+// The function is recorded as a migration with the given version, see the
+// migrate package, so the version must not be used by other migrations of the
+// database. Use a new version to run a new initialization function.
+func WithInit(version int64, init func(ctx context.Context, tx *sql.Tx) error) OpenOption {
+	return func(options *openOptions) {
+		options.Inits = append(options.Inits, migrate.Migration{
+			Version: version,
+			Name:    "init",
+			Func:    init,
+		})
+	}
+}
+
+type openOptions struct {
+	Inits []migrate.Migration
+}
+
 // Open the dqlite database with the given name
-func (a *App) Open(ctx context.Context, database string) (*sql.DB, error) {
+func (a *App) Open(ctx context.Context, database string, options ...OpenOption) (_ *sql.DB, err error) {
+	o := &openOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	var span trace.Span
+	ctx, span = tracing.Start(ctx, a.tracer, "dqlite.app.open", tracing.DBName.String(database))
+	defer func() { tracing.End(span, err) }()
+
 	db, err := sql.Open(a.Driver(), database)
 	if err != nil {
 		return nil, err
@@ -396,50 +817,175 @@ func (a *App) Open(ctx context.Context, database string) (*sql.DB, error) {
 		if cause != driver.ErrNoAvailableLeader {
 			return nil, err
 		}
-		time.Sleep(time.Second)
+		a.clock.Sleep(time.Second)
 	}
 	if err != nil {
 		return nil, err
 	}
 
+	if err := a.migrate(ctx, db, database, o.Inits); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := a.recordDatabase(ctx, database); err != nil {
+		a.warn("record database %s: %v", database, err)
+	}
+
 	return db, nil
 }
 
+// Apply the migrations registered for the given database with
+// WithMigrations, the first time it's opened, along with the given
+// initialization functions.
+func (a *App) migrate(ctx context.Context, db *sql.DB, database string, inits []migrate.Migration) error {
+	migrations, ok := a.migrations[database]
+	if !ok && len(inits) == 0 {
+		return nil
+	}
+
+	a.migratedMu.Lock()
+	defer a.migratedMu.Unlock()
+
+	if a.migrated[database] && len(inits) == 0 {
+		return nil
+	}
+	migrations = append(append([]migrate.Migration{}, migrations...), inits...)
+	n, err := migrate.Apply(ctx, db, migrations)
+	if err != nil {
+		return fmt.Errorf("migrate %s: %w", database, err)
+	}
+	if n > 0 {
+		a.debug("applied %d migrations to %s", n, database)
+	}
+	a.migrated[database] = true
+
+	return nil
+}
+
 // Leader returns a client connected to the current cluster leader, if any.
 func (a *App) Leader(ctx context.Context) (*client.Client, error) {
 	return client.FindLeader(ctx, a.store, a.clientOptions()...)
 }
 
-// Proxy incoming TLS connections.
+// Proxy incoming connections, decrypting, authenticating and authorizing them
+// if needed.
 func (a *App) proxy() {
+	wg := sync.WaitGroup{}
+	for _, listener := range a.listeners {
+		wg.Add(1)
+		go func(listener proxyListener) {
+			defer wg.Done()
+			a.serve(listener, listener.tls, false)
+		}(listener)
+	}
+	wg.Wait()
+	close(a.proxyCh)
+}
+
+// Close the listeners of the proxy.
+func (a *App) closeListeners() {
+	for _, listener := range a.listeners {
+		listener.Close()
+	}
+}
+
+// Proxy connections accepted on the local socket, checking the credentials of
+// the connecting processes. These connections use neither TLS nor tokens.
+func (a *App) proxyLocal() {
+	a.serve(a.localListener, nil, true)
+	close(a.localCh)
+}
+
+// Accept connections from the given listener and proxy them to the local
+// node, until the listener is closed.
+func (a *App) serve(listener net.Listener, listenConfig *tls.Config, local bool) {
 	wg := sync.WaitGroup{}
 	ctx, cancel := context.WithCancel(context.Background())
 	for {
-		client, err := a.listener.Accept()
+		client, err := listener.Accept()
 		if err != nil {
 			cancel()
 			wg.Wait()
-			close(a.proxyCh)
 			return
 		}
 		address := client.RemoteAddr()
-		a.debug("new connection from %s", address)
+		conn := &proxyConn{peer: address.String(), start: time.Now()}
+		if local {
+			creds, err := peerCredentials(client)
+			if err != nil {
+				a.error("local socket: %v", err)
+				client.Close()
+				continue
+			}
+			if !a.localAccess.allows(creds) {
+				a.warn("local socket: reject process %d with uid %d and gid %d", creds.PID, creds.UID, creds.GID)
+				client.Close()
+				continue
+			}
+			conn.peer = fmt.Sprintf("pid:%d", creds.PID)
+			conn.identity = fmt.Sprintf("uid:%d", creds.UID)
+		}
+		a.debug("new connection from %s", conn.peer)
 		server, err := net.Dial("unix", a.nodeBindAddress)
 		if err != nil {
 			a.error("dial local node: %v", err)
 			client.Close()
 			continue
 		}
+		a.proxyMu.Lock()
+		a.proxyConns[conn] = struct{}{}
+		a.proxyMu.Unlock()
 		wg.Add(1)
+		a.metrics.ProxyConnectionOpened()
 		go func() {
 			defer wg.Done()
-			if err := proxy(ctx, client, server, a.tls.Listen); err != nil {
+			// The request filter expects the protocol handshake to
+			// be consumed already.
+			handshake := forwardHandshake
+			if a.auth != nil && !local {
+				handshake = serverAuth(a.auth, conn)
+			}
+			filter := newRequestFilter(a.authorize, a.bindIdentity && !local, a.lastLogEntry, conn)
+			err := proxy(ctx, client, server, listenConfig, true, conn, handshake, filter)
+			if err != nil {
 				a.error("proxy: %v", err)
 			}
+
+			a.proxyMu.Lock()
+			delete(a.proxyConns, conn)
+			a.proxyMu.Unlock()
+
+			info := conn.info(time.Now())
+			info.Err = err
+			a.metrics.ObserveProxyConnection(info.Duration, info.BytesIn, info.BytesOut)
+			a.metrics.ProxyConnectionClosed()
+			if a.proxyAccessLog != nil {
+				a.proxyAccessLog(info)
+			}
 		}()
 	}
 }
 
+// ProxyConnections returns information about the client connections currently
+// proxied to the local node, ordered by the time they were accepted. It's
+// always empty if the app was configured with none of WithTLS, WithAuthToken,
+// WithAuthenticator, WithAuthorizer, WithLocalSocket, WithListenAddress and
+// WithListener.
+func (a *App) ProxyConnections() []ProxyConnection {
+	a.proxyMu.Lock()
+	defer a.proxyMu.Unlock()
+
+	now := time.Now()
+	conns := make([]ProxyConnection, 0, len(a.proxyConns))
+	for conn := range a.proxyConns {
+		conns = append(conns, conn.info(now))
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Start.Before(conns[j].Start) })
+
+	return conns
+}
+
 // Run background tasks. The join flag is true if the node is a brand new one
 // and should join the cluster.
 func (a *App) run(ctx context.Context, frequency time.Duration, join bool) {
@@ -447,6 +993,7 @@ func (a *App) run(ctx context.Context, frequency time.Duration, join bool) {
 
 	delay := time.Duration(0)
 	ready := false
+	joinAttempts := 0
 	for {
 		select {
 		case <-ctx.Done():
@@ -456,19 +1003,25 @@ func (a *App) run(ctx context.Context, frequency time.Duration, join bool) {
 				close(a.readyCh)
 			}
 			return
-		case <-time.After(delay):
-			cli, err := a.Leader(ctx)
+		case <-a.clock.After(delay):
+			cli, err := a.findLeader(ctx)
 			if err != nil {
 				continue
 			}
 
 			// Attempt to join the cluster if this is a brand new node.
 			if join {
-				info := client.NodeInfo{ID: a.id, Address: a.address, Role: client.Spare}
-				if err := cli.Add(ctx, info); err != nil {
-					a.warn("join cluster: %v", err)
-					delay = time.Second
+				if err := a.join(ctx, cli); err != nil {
 					cli.Close()
+					if errors.Is(err, ErrJoinRejected) {
+						a.error("join cluster: %v", err)
+						a.joinErr = err
+						close(a.readyCh)
+						return
+					}
+					joinAttempts++
+					delay = joinDelay(a.joinBackoff, a.joinBackoffCap, joinAttempts)
+					a.warn("join cluster (attempt %d, retry in %s): %v", joinAttempts, delay, err)
 					continue
 				}
 				join = false
@@ -484,11 +1037,28 @@ func (a *App) run(ctx context.Context, frequency time.Duration, join bool) {
 				cli.Close()
 				continue
 			}
-			a.store.Set(ctx, servers)
+			a.store.Set(ctx, a.sortByPreference(ctx, servers))
+			a.observeCluster(ctx, cli, servers)
 
 			// If we are starting up, let's see if we should
 			// promote ourselves.
 			if !ready {
+				if len(a.labels) > 0 {
+					if err := a.recordLabels(ctx); err != nil {
+						a.warn("record labels: %v", err)
+						delay = time.Second
+						cli.Close()
+						continue
+					}
+				}
+				if a.nonVoting {
+					if err := a.recordNonVoting(ctx); err != nil {
+						a.warn("record non-voting preference: %v", err)
+						delay = time.Second
+						cli.Close()
+						continue
+					}
+				}
 				if err := a.maybePromoteOurselves(ctx, cli, servers); err != nil {
 					a.warn("%v", err)
 					delay = time.Second
@@ -504,7 +1074,7 @@ func (a *App) run(ctx context.Context, frequency time.Duration, join bool) {
 
 			// If we are the leader, let's see if there's any
 			// adjustment we should make to node roles.
-			if err := a.maybeAdjustRoles(ctx, cli); err != nil {
+			if err := a.adjustRoles(ctx, cli); err != nil {
 				a.warn("adjust roles: %v", err)
 			}
 			cli.Close()
@@ -553,9 +1123,14 @@ func (a *App) maybePromoteOurselves(ctx context.Context, cli *client.Client, nod
 		return nil
 	}
 
+	// Non-voting nodes can only become stand-bys.
+	if !a.canVote() && standbys >= a.standbys {
+		return nil
+	}
+
 	// Figure if we need to become stand-by or voter.
 	role = client.StandBy
-	if voters < a.voters {
+	if voters < a.voters && a.canVote() {
 		role = client.Voter
 	}
 
@@ -569,7 +1144,11 @@ func (a *App) maybePromoteOurselves(ctx context.Context, cli *client.Client, nod
 	// ignored since the leader will eventually notice that don't have
 	// enough voters and will retry.
 	if role == client.Voter && voters == 1 {
-		for _, node := range nodes {
+		nonVoters, err := a.nonVoters(ctx, nodes)
+		if err != nil {
+			return fmt.Errorf("get non-voting nodes: %w", err)
+		}
+		for _, node := range excludeNodes(nodes, nonVoters) {
 			if node.ID == a.id || node.Role == client.Voter {
 				continue
 			}
@@ -584,6 +1163,39 @@ func (a *App) maybePromoteOurselves(ctx context.Context, cli *client.Client, nod
 	return nil
 }
 
+// AdjustRoles makes the cluster leader check right away whether the roles of
+// the nodes match the number of voters and stand-bys set with WithVoters and
+// WithStandBys, promoting or demoting nodes if needed, instead of waiting for
+// the next periodic check. It can be called after adding or removing nodes.
+//
+// It's a no-op if this node is not the leader.
+func (a *App) AdjustRoles(ctx context.Context) error {
+	// Client-only apps never adjust roles.
+	if a.node == nil {
+		return nil
+	}
+
+	cli, err := client.New(ctx, a.nodeBindAddress, client.WithLogFunc(a.log))
+	if err != nil {
+		return fmt.Errorf("connect to local node: %w", err)
+	}
+	defer cli.Close()
+
+	return a.adjustRoles(ctx, cli)
+}
+
+// Adjust roles if we are the leader, making sure that the periodic check and
+// calls to AdjustRoles don't make concurrent changes.
+func (a *App) adjustRoles(ctx context.Context, cli *client.Client) (err error) {
+	a.rolesMu.Lock()
+	defer a.rolesMu.Unlock()
+
+	ctx, span := tracing.Start(ctx, a.tracer, "dqlite.app.adjust_roles")
+	defer func() { tracing.End(span, err) }()
+
+	return a.maybeAdjustRoles(ctx, cli)
+}
+
 // Check if any adjustment needs to be made to existing roles.
 func (a *App) maybeAdjustRoles(ctx context.Context, cli *client.Client) error {
 again:
@@ -619,6 +1231,35 @@ again:
 
 	index := a.probeNodes(nodes)
 
+	nonVoters, err := a.nonVoters(ctx, nodes)
+	if err != nil {
+		return fmt.Errorf("get non-voting nodes: %w", err)
+	}
+
+	// If we must not be a voter, let another voter lead, which will then
+	// replace us.
+	if nonVoters[a.id] && len(index[client.Voter][online]) > 1 {
+		if err := cli.Transfer(ctx, 0); err != nil {
+			return fmt.Errorf("transfer leadership: %w", err)
+		}
+		a.debug("transferred leadership, since we must not be a voter")
+		return nil
+	}
+
+	// Other voters that must not be voters are handled like offline ones,
+	// so they get replaced and demoted.
+	voters := index[client.Voter]
+	kept := []client.NodeInfo{}
+	for _, node := range voters[online] {
+		if nonVoters[node.ID] && node.ID != a.id {
+			voters[offline] = append(voters[offline], node)
+			continue
+		}
+		kept = append(kept, node)
+	}
+	voters[online] = kept
+	index[client.Voter] = voters
+
 	// If we have exactly the desired number of voters and stand-bys, and they are all
 	// online, we're good.
 	if len(index[client.Voter][offline]) == 0 && len(index[client.Voter][online]) == a.voters && len(index[client.StandBy][offline]) == 0 && len(index[client.StandBy][online]) == a.standbys {
@@ -630,6 +1271,7 @@ again:
 	if n := len(index[client.Voter][online]); n < a.voters {
 		candidates := index[client.StandBy][online]
 		candidates = append(candidates, index[client.Spare][online]...)
+		candidates = excludeNodes(candidates, nonVoters)
 
 		if len(candidates) == 0 {
 			return nil
@@ -810,11 +1452,81 @@ func (a *App) probeNodes(nodes []client.NodeInfo) map[client.NodeRole][2][]clien
 
 // Return the options to use for client.FindLeader() or client.New()
 func (a *App) clientOptions() []client.Option {
-	dial := client.DefaultDialFunc
-	if a.tls != nil {
+	dial := a.dial
+	if a.bindIdentity {
+		dial = dialFuncWithNodeIdentity(dial, a.tls.Dial, a.store)
+	} else if a.tls != nil {
 		dial = client.DialFuncWithTLS(dial, a.tls.Dial)
 	}
-	return []client.Option{client.WithDialFunc(dial), client.WithLogFunc(a.log)}
+	options := []client.Option{client.WithDialFunc(dial), client.WithLogFunc(a.log)}
+	if a.metrics != nil {
+		options = append(options, client.WithMetrics(a.metrics))
+	}
+	if a.tracerProvider != nil {
+		options = append(options, client.WithTracerProvider(a.tracerProvider))
+	}
+	if a.authToken != "" {
+		options = append(options, client.WithAuthToken(a.authToken))
+	}
+	if a.leaderProbes > 1 {
+		options = append(options, client.WithLeaderProbes(a.leaderProbes))
+	}
+	return options
+}
+
+// Record the current leader and cluster members in our metrics, and notify
+// event subscribers about changes since the last observation.
+func (a *App) observeCluster(ctx context.Context, cli *client.Client, nodes []client.NodeInfo) {
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		a.debug("get leader for observation: %v", err)
+		return
+	}
+	leaderID := uint64(0)
+	if leader != nil {
+		leaderID = leader.ID
+	}
+
+	if a.metrics != nil {
+		roles := map[string]int{}
+		for _, node := range nodes {
+			roles[node.Role.String()]++
+		}
+		a.metrics.ObserveCluster(a.id, leaderID, roles)
+	}
+
+	var snapshots []string
+	if a.node != nil {
+		snapshots, err = listSnapshots(a.dir)
+		if err != nil {
+			a.debug("list snapshots: %v", err)
+		}
+	}
+	a.events.observe(a.clock.Now(), nodes, leaderID, snapshots)
+
+	info := client.NodeInfo{}
+	if leader != nil {
+		info = *leader
+	}
+	for _, node := range nodes {
+		if node.ID == leaderID {
+			info = node
+		}
+	}
+	a.notifyLeadership(info)
+}
+
+// Call the leadership change function, if set, unless the given leader is the
+// same as the last one passed to it.
+func (a *App) notifyLeadership(leader client.NodeInfo) {
+	if a.leadershipFunc == nil {
+		return
+	}
+	if a.leader != nil && a.leader.ID == leader.ID {
+		return
+	}
+	a.leader = &leader
+	a.leadershipFunc(leader.ID == a.id, leader)
 }
 
 func (a *App) debug(format string, args ...interface{}) {
@@ -832,5 +1544,3 @@ func (a *App) warn(format string, args ...interface{}) {
 func (a *App) error(format string, args ...interface{}) {
 	a.log(client.LogError, format, args...)
 }
-
-var driverIndex = 0
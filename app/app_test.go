@@ -1,12 +1,19 @@
 package app_test
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"database/sql"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"testing"
@@ -14,6 +21,8 @@ import (
 
 	"github.com/canonical/go-dqlite/app"
 	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/clock"
+	"github.com/canonical/go-dqlite/migrate"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -51,6 +60,93 @@ func TestNew_PristineJoiner(t *testing.T) {
 	assert.Equal(t, client.Spare, cluster[1].Role)
 }
 
+// A joining node stops retrying if the cluster rejects it.
+func TestNew_JoinerRejected(t *testing.T) {
+	addr1 := "127.0.0.1:9001"
+	addr2 := "127.0.0.1:9002"
+
+	app1, cleanup := newApp(t, app.WithAddress(addr1))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Another node already has the address of the joining one.
+	cli, err := app1.Leader(ctx)
+	require.NoError(t, err)
+	require.NoError(t, cli.Add(ctx, client.NodeInfo{ID: 123, Address: addr2}))
+	cli.Close()
+
+	app2, cleanup := newApp(t, app.WithAddress(addr2), app.WithCluster([]string{addr1}))
+	defer cleanup()
+
+	err = app2.Ready(ctx)
+	assert.True(t, errors.Is(err, app.ErrJoinRejected))
+}
+
+// A client-only app uses the cluster without running a local node.
+func TestNewClientOnly(t *testing.T) {
+	addr1 := "127.0.0.1:9001"
+
+	_, cleanup := newApp(t, app.WithAddress(addr1))
+	defer cleanup()
+
+	store := client.NewInmemNodeStore()
+	require.NoError(t, store.Set(context.Background(), []client.NodeInfo{{Address: addr1}}))
+
+	app2, err := app.NewClientOnly(store)
+	require.NoError(t, err)
+	defer app2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, app2.Ready(ctx))
+	assert.Equal(t, uint64(0), app2.ID())
+
+	db, err := app2.Open(ctx, "test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "CREATE TABLE test (n INT)")
+	require.NoError(t, err)
+
+	nodes, err := store.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, client.Voter, nodes[0].Role)
+}
+
+// The debug listener serves health, readiness, metrics and status endpoints.
+func TestDebugAddress(t *testing.T) {
+	app1, cleanup := newApp(t, app.WithAddress("127.0.0.1:9001"), app.WithDebugAddress("127.0.0.1:0"))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, app1.Ready(ctx))
+
+	base := "http://" + app1.DebugAddress()
+
+	for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+		response, err := http.Get(base + path)
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode, path)
+	}
+
+	response, err := http.Get(base + "/status")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	status := app.DebugStatus{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&status))
+	assert.True(t, status.Ready)
+	require.NotNil(t, status.Leader)
+	assert.Equal(t, app1.ID(), status.Leader.ID)
+	assert.Equal(t, []app.DebugNode{{ID: app1.ID(), Address: "127.0.0.1:9001", Role: "voter"}}, status.Nodes)
+}
+
 // Restart a node that had previously joined the cluster successfully.
 func TestNew_JoinerRestart(t *testing.T) {
 	addr1 := "127.0.0.1:9001"
@@ -74,6 +170,33 @@ func TestNew_JoinerRestart(t *testing.T) {
 	require.NoError(t, app2.Ready(context.Background()))
 }
 
+// A corrupted node store is rebuilt from the raft data.
+func TestNew_StoreRepair(t *testing.T) {
+	addr1 := "127.0.0.1:9001"
+
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	app1, cleanup := newAppWithDir(t, dir, app.WithAddress(addr1))
+	require.NoError(t, app1.Ready(context.Background()))
+	cleanup()
+
+	path := filepath.Join(dir, "cluster.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("{garbage"), 0600))
+
+	app1, cleanup = newAppWithDir(t, dir, app.WithAddress(addr1), app.WithStoreRepair())
+	defer cleanup()
+
+	require.NoError(t, app1.Ready(context.Background()))
+
+	store, err := client.NewYamlNodeStore(path)
+	require.NoError(t, err)
+	nodes, err := store.Get(context.Background())
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	assert.Equal(t, addr1, nodes[0].Address)
+}
+
 // The second joiner promotes itself and also the first joiner.
 func TestNew_SecondJoiner(t *testing.T) {
 	addr1 := "127.0.0.1:9001"
@@ -342,8 +465,48 @@ func TestHandover_TransferLeadership(t *testing.T) {
 	assert.Equal(t, client.Voter, cluster[3].Role)
 }
 
-// If a voter goes offline, another node takes its place.
-func TestRolesAdjustment_ReplaceVoter(t *testing.T) {
+// Plain nodes, which aren't proxied and so don't report their raft log index,
+// hand over without waiting for the promoted node to catch up.
+func TestHandover_NotProxied(t *testing.T) {
+	n := 4
+	apps := make([]*app.App, n)
+
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("127.0.0.1:900%d", i+1)
+		options := []app.Option{app.WithAddress(addr)}
+		if i > 0 {
+			options = append(options, app.WithCluster([]string{"127.0.0.1:9001"}))
+		}
+
+		app, cleanup := newApp(t, options...)
+		defer cleanup()
+
+		require.NoError(t, app.Ready(context.Background()))
+
+		apps[i] = app
+	}
+
+	db, err := apps[0].Open(context.Background(), "test")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE test (n INT); INSERT INTO test(n) VALUES(1)")
+	require.NoError(t, err)
+
+	require.NoError(t, apps[2].Handover(context.Background()))
+
+	cli, err := apps[0].Leader(context.Background())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	cluster, err := cli.Cluster(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, client.Spare, cluster[2].Role)
+	assert.Equal(t, client.Voter, cluster[3].Role)
+}
+
+// Closing a node created with a close timeout hands over its voting rights.
+func TestHandover_CloseTimeout(t *testing.T) {
 	n := 4
 	apps := make([]*app.App, n)
 	cleanups := make([]func(), n)
@@ -352,7 +515,7 @@ func TestRolesAdjustment_ReplaceVoter(t *testing.T) {
 		addr := fmt.Sprintf("127.0.0.1:900%d", i+1)
 		options := []app.Option{
 			app.WithAddress(addr),
-			app.WithRolesAdjustmentFrequency(500 * time.Millisecond),
+			app.WithCloseTimeout(5 * time.Second),
 		}
 		if i > 0 {
 			options = append(options, app.WithCluster([]string{"127.0.0.1:9001"}))
@@ -370,13 +533,11 @@ func TestRolesAdjustment_ReplaceVoter(t *testing.T) {
 	defer cleanups[1]()
 	defer cleanups[3]()
 
-	// A voter goes offline.
 	cleanups[2]()
 
-	time.Sleep(2 * time.Second)
-
 	cli, err := apps[0].Leader(context.Background())
 	require.NoError(t, err)
+	defer cli.Close()
 
 	cluster, err := cli.Cluster(context.Background())
 	require.NoError(t, err)
@@ -387,9 +548,8 @@ func TestRolesAdjustment_ReplaceVoter(t *testing.T) {
 	assert.Equal(t, client.Voter, cluster[3].Role)
 }
 
-// If a voter goes offline, but no another node can its place, then nothing
-// chagnes.
-func TestRolesAdjustment_CantReplaceVoter(t *testing.T) {
+// If a voter goes offline, another node takes its place.
+func TestRolesAdjustment_ReplaceVoter(t *testing.T) {
 	n := 4
 	apps := make([]*app.App, n)
 	cleanups := make([]func(), n)
@@ -414,9 +574,9 @@ func TestRolesAdjustment_CantReplaceVoter(t *testing.T) {
 
 	defer cleanups[0]()
 	defer cleanups[1]()
+	defer cleanups[3]()
 
-	// A voter and a spare go offline.
-	cleanups[3]()
+	// A voter goes offline.
 	cleanups[2]()
 
 	time.Sleep(2 * time.Second)
@@ -429,13 +589,13 @@ func TestRolesAdjustment_CantReplaceVoter(t *testing.T) {
 
 	assert.Equal(t, client.Voter, cluster[0].Role)
 	assert.Equal(t, client.Voter, cluster[1].Role)
-	assert.Equal(t, client.Voter, cluster[2].Role)
-	assert.Equal(t, client.StandBy, cluster[3].Role)
+	assert.Equal(t, client.Spare, cluster[2].Role)
+	assert.Equal(t, client.Voter, cluster[3].Role)
 }
 
-// If a stand-by goes offline, another node takes its place.
-func TestRolesAdjustment_ReplaceStandBy(t *testing.T) {
-	n := 6
+// A non-voting node is never promoted to voter.
+func TestRolesAdjustment_NonVoting(t *testing.T) {
+	n := 4
 	apps := make([]*app.App, n)
 	cleanups := make([]func(), n)
 
@@ -448,6 +608,9 @@ func TestRolesAdjustment_ReplaceStandBy(t *testing.T) {
 		if i > 0 {
 			options = append(options, app.WithCluster([]string{"127.0.0.1:9001"}))
 		}
+		if i == 1 {
+			options = append(options, app.WithNonVoting())
+		}
 
 		app, cleanup := newApp(t, options...)
 
@@ -457,96 +620,1214 @@ func TestRolesAdjustment_ReplaceStandBy(t *testing.T) {
 		cleanups[i] = cleanup
 	}
 
-	defer cleanups[0]()
-	defer cleanups[1]()
-	defer cleanups[2]()
-	defer cleanups[3]()
-	defer cleanups[5]()
-
-	// A stand-by goes offline.
-	cleanups[4]()
+	for i := n - 1; i >= 0; i-- {
+		defer cleanups[i]()
+	}
 
 	time.Sleep(2 * time.Second)
 
 	cli, err := apps[0].Leader(context.Background())
 	require.NoError(t, err)
+	defer cli.Close()
 
 	cluster, err := cli.Cluster(context.Background())
 	require.NoError(t, err)
 
 	assert.Equal(t, client.Voter, cluster[0].Role)
-	assert.Equal(t, client.Voter, cluster[1].Role)
+	assert.Equal(t, client.StandBy, cluster[1].Role)
 	assert.Equal(t, client.Voter, cluster[2].Role)
-	assert.Equal(t, client.StandBy, cluster[3].Role)
-	assert.Equal(t, client.Spare, cluster[4].Role)
-	assert.Equal(t, client.StandBy, cluster[5].Role)
+	assert.Equal(t, client.Voter, cluster[3].Role)
 }
 
-// Open a database on a fresh one-node cluster.
-func TestOpen(t *testing.T) {
-	app, cleanup := newApp(t)
-	defer cleanup()
+// Only the nodes matching the voter selector become voters, and labels are
+// returned along with the cluster members.
+func TestRolesAdjustment_VoterSelector(t *testing.T) {
+	n := 4
+	apps := make([]*app.App, n)
+	cleanups := make([]func(), n)
 
-	db, err := app.Open(context.Background(), "test")
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("127.0.0.1:900%d", i+1)
+		tier := "core"
+		if i == 1 {
+			tier = "edge"
+		}
+		options := []app.Option{
+			app.WithAddress(addr),
+			app.WithRolesAdjustmentFrequency(500 * time.Millisecond),
+			app.WithLabels(map[string]string{"tier": tier}),
+			app.WithVoterSelector(map[string]string{"tier": "core"}),
+		}
+		if i > 0 {
+			options = append(options, app.WithCluster([]string{"127.0.0.1:9001"}))
+		}
+
+		app, cleanup := newApp(t, options...)
+
+		require.NoError(t, app.Ready(context.Background()))
+
+		apps[i] = app
+		cleanups[i] = cleanup
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		defer cleanups[i]()
+	}
+
+	time.Sleep(2 * time.Second)
+
+	cluster, err := apps[0].Cluster(context.Background())
 	require.NoError(t, err)
-	defer db.Close()
 
-	_, err = db.ExecContext(context.Background(), "CREATE TABLE foo(n INT)")
-	assert.NoError(t, err)
+	assert.Equal(t, client.Voter, cluster[0].Role)
+	assert.Equal(t, client.StandBy, cluster[1].Role)
+	assert.Equal(t, client.Voter, cluster[2].Role)
+	assert.Equal(t, client.Voter, cluster[3].Role)
+
+	assert.Equal(t, map[string]string{"tier": "core"}, cluster[0].Labels)
+	assert.Equal(t, map[string]string{"tier": "edge"}, cluster[1].Labels)
 }
 
-// Test client connections dropping uncleanly.
-func TestProxy_Error(t *testing.T) {
-	cert, pool := loadCert(t)
-	dial := client.DialFuncWithTLS(client.DefaultDialFunc, app.SimpleDialTLSConfig(cert, pool))
+// Roles adjustment is driven by the clock, so a fake clock can be used to
+// trigger it without waiting for the adjustment frequency to elapse.
+func TestRolesAdjustment_FakeClock(t *testing.T) {
+	n := 4
+	apps := make([]*app.App, n)
+	cleanups := make([]func(), n)
+	clk := clock.NewFake(time.Now())
 
-	_, cleanup := newApp(t, app.WithAddress("127.0.0.1:9000"))
-	defer cleanup()
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("127.0.0.1:900%d", i+1)
+		options := []app.Option{app.WithAddress(addr), app.WithClock(clk)}
+		if i > 0 {
+			options = append(options, app.WithCluster([]string{"127.0.0.1:9001"}))
+		}
 
-	// Simulate a client which writes the protocol header, then a Leader
-	// request and finally drops before reading the response.
-	conn, err := dial(context.Background(), "127.0.0.1:9000")
-	require.NoError(t, err)
+		app, cleanup := newApp(t, options...)
 
-	protocol := make([]byte, 8)
-	binary.LittleEndian.PutUint64(protocol, uint64(1))
+		require.NoError(t, app.Ready(context.Background()))
 
-	n, err := conn.Write(protocol)
-	require.NoError(t, err)
-	assert.Equal(t, n, 8)
+		apps[i] = app
+		cleanups[i] = cleanup
+	}
 
-	header := make([]byte, 8)
-	binary.LittleEndian.PutUint32(header[0:], 1)
-	header[4] = 0
-	header[5] = 0
-	binary.LittleEndian.PutUint16(header[6:], 0)
+	defer cleanups[0]()
+	defer cleanups[1]()
+	defer cleanups[3]()
 
-	n, err = conn.Write(header)
-	require.NoError(t, err)
-	assert.Equal(t, n, 8)
+	// A voter goes offline.
+	cleanups[2]()
 
-	body := make([]byte, 8)
-	n, err = conn.Write(body)
-	require.NoError(t, err)
-	assert.Equal(t, n, 8)
+	// Wait for the remaining nodes to be scheduled for their next round
+	// of adjustments, and trigger it.
+	clk.BlockUntil(n - 1)
+	clk.Advance(30 * time.Second)
 
-	time.Sleep(100 * time.Millisecond)
-	conn.Close()
-	time.Sleep(250 * time.Millisecond)
+	for i := 0; i < 50; i++ {
+		cli, err := apps[0].Leader(context.Background())
+		require.NoError(t, err)
+
+		cluster, err := cli.Cluster(context.Background())
+		require.NoError(t, err)
+		cli.Close()
+
+		if cluster[3].Role == client.Voter {
+			assert.Equal(t, client.Spare, cluster[2].Role)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatal("stand-by node was not promoted")
 }
 
-// If the given context is cancelled before initial tasks are completed, an
-// error is returned.
-func TestReady_Cancel(t *testing.T) {
-	app, cleanup := newApp(t, app.WithAddress("127.0.0.1:9002"), app.WithCluster([]string{"127.0.0.1:9001"}))
-	defer cleanup()
+// Roles can be adjusted on demand, without waiting for the periodic check.
+func TestRolesAdjustment_OnDemand(t *testing.T) {
+	n := 4
+	apps := make([]*app.App, n)
+	cleanups := make([]func(), n)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
-	defer cancel()
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("127.0.0.1:900%d", i+1)
+		options := []app.Option{
+			app.WithAddress(addr),
+			app.WithRolesAdjustment(3, 2, time.Hour),
+		}
+		if i > 0 {
+			options = append(options, app.WithCluster([]string{"127.0.0.1:9001"}))
+		}
 
-	err := app.Ready(ctx)
+		app, cleanup := newApp(t, options...)
 
-	assert.Equal(t, ctx.Err(), err)
+		require.NoError(t, app.Ready(context.Background()))
+
+		apps[i] = app
+		cleanups[i] = cleanup
+	}
+
+	defer cleanups[0]()
+	defer cleanups[1]()
+	defer cleanups[3]()
+
+	// A voter goes offline.
+	cleanups[2]()
+
+	// Only the leader adjusts roles.
+	require.NoError(t, apps[1].AdjustRoles(context.Background()))
+	require.NoError(t, apps[0].AdjustRoles(context.Background()))
+
+	cli, err := apps[0].Leader(context.Background())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	cluster, err := cli.Cluster(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, client.Voter, cluster[0].Role)
+	assert.Equal(t, client.Voter, cluster[1].Role)
+	assert.Equal(t, client.Spare, cluster[2].Role)
+	assert.Equal(t, client.Voter, cluster[3].Role)
+}
+
+// If a voter goes offline, but no another node can its place, then nothing
+// chagnes.
+func TestRolesAdjustment_CantReplaceVoter(t *testing.T) {
+	n := 4
+	apps := make([]*app.App, n)
+	cleanups := make([]func(), n)
+
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("127.0.0.1:900%d", i+1)
+		options := []app.Option{
+			app.WithAddress(addr),
+			app.WithRolesAdjustmentFrequency(500 * time.Millisecond),
+		}
+		if i > 0 {
+			options = append(options, app.WithCluster([]string{"127.0.0.1:9001"}))
+		}
+
+		app, cleanup := newApp(t, options...)
+
+		require.NoError(t, app.Ready(context.Background()))
+
+		apps[i] = app
+		cleanups[i] = cleanup
+	}
+
+	defer cleanups[0]()
+	defer cleanups[1]()
+
+	// A voter and a spare go offline.
+	cleanups[3]()
+	cleanups[2]()
+
+	time.Sleep(2 * time.Second)
+
+	cli, err := apps[0].Leader(context.Background())
+	require.NoError(t, err)
+
+	cluster, err := cli.Cluster(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, client.Voter, cluster[0].Role)
+	assert.Equal(t, client.Voter, cluster[1].Role)
+	assert.Equal(t, client.Voter, cluster[2].Role)
+	assert.Equal(t, client.StandBy, cluster[3].Role)
+}
+
+// Losing a majority of voters raises a quorum alert.
+func TestQuorumAlert(t *testing.T) {
+	n := 3
+	apps := make([]*app.App, n)
+	cleanups := make([]func(), n)
+	alerts := make(chan app.QuorumAlert, 16)
+
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("127.0.0.1:900%d", i+1)
+		options := []app.Option{
+			app.WithAddress(addr),
+			app.WithRolesAdjustmentFrequency(100 * time.Millisecond),
+		}
+		if i == 0 {
+			options = append(options, app.WithQuorumAlert(time.Second, func(alert app.QuorumAlert) {
+				alerts <- alert
+			}))
+		} else {
+			options = append(options, app.WithCluster([]string{"127.0.0.1:9001"}))
+		}
+
+		app, cleanup := newApp(t, options...)
+
+		require.NoError(t, app.Ready(context.Background()))
+
+		apps[i] = app
+		cleanups[i] = cleanup
+	}
+
+	defer cleanups[0]()
+
+	cleanups[2]()
+	cleanups[1]()
+
+	select {
+	case alert := <-alerts:
+		assert.True(t, alert.Lost)
+		assert.False(t, alert.LastContact.IsZero())
+		require.Len(t, alert.Nodes, n)
+		for _, node := range alert.Nodes {
+			assert.Equal(t, node.ID == apps[0].ID(), node.Reachable)
+			assert.Equal(t, client.Voter, node.Role)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no quorum alert raised")
+	}
+}
+
+// If a stand-by goes offline, another node takes its place.
+func TestRolesAdjustment_ReplaceStandBy(t *testing.T) {
+	n := 6
+	apps := make([]*app.App, n)
+	cleanups := make([]func(), n)
+
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("127.0.0.1:900%d", i+1)
+		options := []app.Option{
+			app.WithAddress(addr),
+			app.WithRolesAdjustmentFrequency(500 * time.Millisecond),
+		}
+		if i > 0 {
+			options = append(options, app.WithCluster([]string{"127.0.0.1:9001"}))
+		}
+
+		app, cleanup := newApp(t, options...)
+
+		require.NoError(t, app.Ready(context.Background()))
+
+		apps[i] = app
+		cleanups[i] = cleanup
+	}
+
+	defer cleanups[0]()
+	defer cleanups[1]()
+	defer cleanups[2]()
+	defer cleanups[3]()
+	defer cleanups[5]()
+
+	// A stand-by goes offline.
+	cleanups[4]()
+
+	time.Sleep(2 * time.Second)
+
+	cli, err := apps[0].Leader(context.Background())
+	require.NoError(t, err)
+
+	cluster, err := cli.Cluster(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, client.Voter, cluster[0].Role)
+	assert.Equal(t, client.Voter, cluster[1].Role)
+	assert.Equal(t, client.Voter, cluster[2].Role)
+	assert.Equal(t, client.StandBy, cluster[3].Role)
+	assert.Equal(t, client.Spare, cluster[4].Role)
+	assert.Equal(t, client.StandBy, cluster[5].Role)
+}
+
+// Bootstrap a three-node cluster with all members declared up front.
+func TestNew_InitialCluster(t *testing.T) {
+	nodes := []client.NodeInfo{
+		{ID: 1, Address: "127.0.0.1:9001", Role: client.Voter},
+		{ID: 2, Address: "127.0.0.1:9002", Role: client.Voter},
+		{ID: 3, Address: "127.0.0.1:9003", Role: client.StandBy},
+	}
+
+	apps := []*app.App{}
+	for _, node := range nodes {
+		options := []app.Option{app.WithAddress(node.Address), app.WithInitialCluster(nodes)}
+		app, cleanup := newApp(t, options...)
+		defer cleanup()
+
+		apps = append(apps, app)
+	}
+
+	for _, app := range apps {
+		require.NoError(t, app.Ready(context.Background()))
+	}
+
+	cli, err := apps[0].Leader(context.Background())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	cluster, err := cli.Cluster(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, cluster, 3)
+	for i, node := range nodes {
+		assert.Equal(t, node.ID, cluster[i].ID)
+		assert.Equal(t, node.Role, cluster[i].Role)
+	}
+}
+
+// Open a database on a fresh one-node cluster.
+func TestOpen(t *testing.T) {
+	app, cleanup := newApp(t)
+	defer cleanup()
+
+	db, err := app.Open(context.Background(), "test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(context.Background(), "CREATE TABLE foo(n INT)")
+	assert.NoError(t, err)
+}
+
+// Local snapshot files of the configured databases are periodically
+// refreshed.
+func TestLocalSnapshots(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	app, cleanup := newApp(t, app.WithLocalSnapshots(dir, 50*time.Millisecond, "test"))
+	defer cleanup()
+
+	db, err := app.Open(context.Background(), "test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE foo(n INT); INSERT INTO foo(n) VALUES(1)")
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+
+	snapshot, err := sql.Open("sqlite3", filepath.Join(dir, "test"))
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	var n int
+	require.NoError(t, snapshot.QueryRow("SELECT n FROM foo").Scan(&n))
+	assert.Equal(t, 1, n)
+}
+
+// The leader periodically writes a backup of every database.
+func TestBackupSchedule(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	app, cleanup := newApp(t, app.WithBackupSchedule(50*time.Millisecond, dir))
+	defer cleanup()
+
+	db, err := app.Open(context.Background(), "test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE foo(n INT); INSERT INTO foo(n) VALUES(1)")
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+
+	backup, err := sql.Open("sqlite3", filepath.Join(dir, "test"))
+	require.NoError(t, err)
+	defer backup.Close()
+
+	var n int
+	require.NoError(t, backup.QueryRow("SELECT n FROM foo").Scan(&n))
+	assert.Equal(t, 1, n)
+}
+
+// Client-only apps don't write backups, having no local node.
+func TestBackupSchedule_ClientOnly(t *testing.T) {
+	addr1 := "127.0.0.1:9001"
+
+	_, cleanup := newApp(t, app.WithAddress(addr1))
+	defer cleanup()
+
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	store := client.NewInmemNodeStore()
+	require.NoError(t, store.Set(context.Background(), []client.NodeInfo{{Address: addr1}}))
+
+	app2, err := app.NewClientOnly(store, app.WithBackupSchedule(50*time.Millisecond, dir))
+	require.NoError(t, err)
+	defer app2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, app2.Ready(ctx))
+
+	db, err := app2.Open(ctx, "test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "CREATE TABLE foo(n INT)")
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 0)
+}
+
+// Committed changes to registered tables are streamed in order.
+func TestChanges(t *testing.T) {
+	a, cleanup := newApp(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	db, err := a.Open(ctx, "test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE foo(n INT)")
+	require.NoError(t, err)
+
+	require.NoError(t, a.EnableChanges(ctx, "test", "foo"))
+
+	_, err = db.Exec("INSERT INTO foo(n) VALUES(1); UPDATE foo SET n = 2; DELETE FROM foo")
+	require.NoError(t, err)
+
+	changes, err := a.Changes(ctx, "test", 0)
+	require.NoError(t, err)
+
+	for _, op := range []app.ChangeOp{app.ChangeInsert, app.ChangeUpdate, app.ChangeDelete} {
+		change := <-changes
+		assert.Equal(t, "foo", change.Table)
+		assert.Equal(t, op, change.Op)
+		assert.Equal(t, int64(1), change.RowID)
+	}
+}
+
+// Subscribers are notified about nodes joining the cluster.
+func TestSubscribe_NodeJoined(t *testing.T) {
+	addr1 := "127.0.0.1:9001"
+	addr2 := "127.0.0.1:9002"
+
+	options := []app.Option{app.WithAddress(addr1), app.WithRolesAdjustmentFrequency(100 * time.Millisecond)}
+	app1, cleanup := newApp(t, options...)
+	defer cleanup()
+
+	require.NoError(t, app1.Ready(context.Background()))
+
+	events, unsubscribe := app1.Subscribe(16)
+	defer unsubscribe()
+
+	app2, cleanup := newApp(t, app.WithAddress(addr2), app.WithCluster([]string{addr1}))
+	defer cleanup()
+
+	require.NoError(t, app2.Ready(context.Background()))
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type != app.NodeJoined {
+				continue
+			}
+			assert.Equal(t, app2.ID(), event.Node.ID)
+			assert.Equal(t, addr2, event.Node.Address)
+			return
+		case <-timeout:
+			t.Fatal("no node joined event received")
+		}
+	}
+}
+
+// The leadership change function is called when the node becomes leader, and
+// when it's closed.
+func TestOnLeadershipChange(t *testing.T) {
+	type change struct {
+		isLeader bool
+		leader   client.NodeInfo
+	}
+	changes := make(chan change, 16)
+	f := func(isLeader bool, leader client.NodeInfo) {
+		changes <- change{isLeader: isLeader, leader: leader}
+	}
+
+	options := []app.Option{
+		app.WithAddress("127.0.0.1:9001"),
+		app.WithRolesAdjustmentFrequency(100 * time.Millisecond),
+		app.WithOnLeadershipChange(f),
+	}
+	dir, dirCleanup := newDir(t)
+	defer dirCleanup()
+
+	app1, appCleanup := newAppWithDir(t, dir, options...)
+
+	require.NoError(t, app1.Ready(context.Background()))
+
+	select {
+	case c := <-changes:
+		assert.True(t, c.isLeader)
+		assert.Equal(t, app1.ID(), c.leader.ID)
+		assert.Equal(t, "127.0.0.1:9001", c.leader.Address)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no leadership change observed")
+	}
+
+	appCleanup()
+
+	c := <-changes
+	assert.False(t, c.isLeader)
+}
+
+// Configuration settings can be set, read back and watched.
+func TestConfig(t *testing.T) {
+	app1, cleanup := newApp(t, app.WithAddress("127.0.0.1:9001"))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, app1.Ready(ctx))
+
+	config := app1.Config()
+
+	_, err := config.Get(ctx, "foo")
+	assert.Equal(t, app.ErrNoConfigKey, err)
+
+	changes, err := config.Watch(ctx, "f")
+	require.NoError(t, err)
+
+	require.NoError(t, config.Set(ctx, "bar", "1"))
+	require.NoError(t, config.Set(ctx, "foo", "2"))
+
+	value, err := config.Get(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "2", value)
+
+	change := <-changes
+	assert.Equal(t, "foo", change.Key)
+	assert.Equal(t, "2", change.Value)
+	assert.Equal(t, int64(2), change.Revision)
+}
+
+// Test client connections dropping uncleanly.
+func TestProxy_Error(t *testing.T) {
+	cert, pool := loadCert(t)
+	dial := client.DialFuncWithTLS(client.DefaultDialFunc, app.SimpleDialTLSConfig(cert, pool))
+
+	_, cleanup := newApp(t, app.WithAddress("127.0.0.1:9000"))
+	defer cleanup()
+
+	// Simulate a client which writes the protocol header, then a Leader
+	// request and finally drops before reading the response.
+	conn, err := dial(context.Background(), "127.0.0.1:9000")
+	require.NoError(t, err)
+
+	protocol := make([]byte, 8)
+	binary.LittleEndian.PutUint64(protocol, uint64(1))
+
+	n, err := conn.Write(protocol)
+	require.NoError(t, err)
+	assert.Equal(t, n, 8)
+
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header[0:], 1)
+	header[4] = 0
+	header[5] = 0
+	binary.LittleEndian.PutUint16(header[6:], 0)
+
+	n, err = conn.Write(header)
+	require.NoError(t, err)
+	assert.Equal(t, n, 8)
+
+	body := make([]byte, 8)
+	n, err = conn.Write(body)
+	require.NoError(t, err)
+	assert.Equal(t, n, 8)
+
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+	time.Sleep(250 * time.Millisecond)
+}
+
+// Closed proxied connections are reported to the access log.
+func TestProxy_AccessLog(t *testing.T) {
+	conns := make(chan app.ProxyConnection, 16)
+	accessLog := func(conn app.ProxyConnection) {
+		conns <- conn
+	}
+
+	a, cleanup := newApp(t, app.WithAddress("127.0.0.1:9000"), app.WithProxyAccessLog(accessLog))
+	defer cleanup()
+
+	require.NoError(t, a.Ready(context.Background()))
+
+	cli, err := a.Leader(context.Background())
+	require.NoError(t, err)
+	assert.NotEmpty(t, a.ProxyConnections())
+	require.NoError(t, cli.Close())
+
+	select {
+	case conn := <-conns:
+		assert.NotEmpty(t, conn.Peer)
+		assert.True(t, conn.BytesIn > 0)
+		assert.True(t, conn.BytesOut > 0)
+		assert.NotEmpty(t, conn.CipherSuite)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no access log entry")
+	}
+}
+
+// Clients must present the configured token to connect.
+func TestAuthToken(t *testing.T) {
+	addr1 := "127.0.0.1:9001"
+	addr2 := "127.0.0.1:9002"
+
+	_, cleanup := newApp(t, app.WithAddress(addr1), app.WithAuthToken("secret"))
+	defer cleanup()
+
+	app2, cleanup := newApp(t, app.WithAddress(addr2), app.WithCluster([]string{addr1}), app.WithAuthToken("secret"))
+	defer cleanup()
+
+	require.NoError(t, app2.Ready(context.Background()))
+
+	cert, pool := loadCert(t)
+	dial := client.DialFuncWithTLS(client.DefaultDialFunc, app.SimpleDialTLSConfig(cert, pool))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	cli, err := client.New(ctx, addr1, client.WithDialFunc(dial), client.WithAuthToken("secret"))
+	require.NoError(t, err)
+	_, err = cli.Leader(ctx)
+	require.NoError(t, err)
+	cli.Close()
+
+	_, err = client.New(ctx, addr1, client.WithDialFunc(dial), client.WithAuthToken("wrong"))
+	assert.Error(t, err)
+
+	cli, err = client.New(ctx, addr1, client.WithDialFunc(dial))
+	require.NoError(t, err)
+	_, err = cli.Leader(ctx)
+	assert.Error(t, err)
+	cli.Close()
+}
+
+// The proxy reports the last entry of the raft log of its node.
+func TestVerifyConsistency(t *testing.T) {
+	app1, cleanup := newApp(t, app.WithAddress("127.0.0.1:9001"))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	require.NoError(t, app1.Ready(ctx))
+
+	db, err := app1.Open(ctx, "test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "CREATE TABLE test (n INT)")
+	require.NoError(t, err)
+
+	cli, err := app1.Leader(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	report, err := cli.VerifyConsistency(ctx, "test")
+	require.NoError(t, err)
+	require.Len(t, report.Nodes, 1)
+	assert.Empty(t, report.Nodes[0].Error)
+	assert.NotZero(t, report.Nodes[0].LastIndex)
+	assert.NotZero(t, report.Nodes[0].LastTerm)
+	assert.Len(t, report.Nodes[0].Checksums["test"], 64)
+	assert.True(t, report.Consistent())
+}
+
+// A joining node can wait to catch up with the leader.
+func TestWaitIndex(t *testing.T) {
+	addr1 := "127.0.0.1:9001"
+	addr2 := "127.0.0.1:9002"
+
+	app1, cleanup := newApp(t, app.WithAddress(addr1))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, app1.Ready(ctx))
+
+	db, err := app1.Open(ctx, "test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "CREATE TABLE test (n INT)")
+	require.NoError(t, err)
+
+	app2, cleanup := newApp(t, app.WithAddress(addr2), app.WithCluster([]string{addr1}))
+	defer cleanup()
+
+	require.NoError(t, app2.Ready(ctx))
+
+	index, err := app2.LeaderIndex(ctx)
+	require.NoError(t, err)
+	last, err := app1.LastIndex()
+	require.NoError(t, err)
+	assert.Equal(t, last, index)
+
+	require.NoError(t, app2.WaitIndex(ctx, index))
+
+	last, err = app2.LastIndex()
+	require.NoError(t, err)
+	assert.True(t, last >= index)
+}
+
+// All databases can be backed up in a single archive and restored in another
+// cluster.
+func TestBackupAll(t *testing.T) {
+	app1, cleanup := newApp(t, app.WithAddress("127.0.0.1:9001"))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, app1.Ready(ctx))
+
+	for _, name := range []string{"first", "second"} {
+		db, err := app1.Open(ctx, name)
+		require.NoError(t, err)
+		_, err = db.ExecContext(ctx, "CREATE TABLE test (n INT); CREATE INDEX test_n ON test(n)")
+		require.NoError(t, err)
+		_, err = db.ExecContext(ctx, "INSERT INTO test(n) VALUES(1), (2)")
+		require.NoError(t, err)
+		db.Close()
+	}
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, app1.BackupAll(ctx, buf))
+
+	app2, cleanup := newApp(t, app.WithAddress("127.0.0.1:9002"))
+	defer cleanup()
+
+	require.NoError(t, app2.Ready(ctx))
+
+	manifest, err := app2.RestoreAll(ctx, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	assert.Equal(t, "127.0.0.1:9001", manifest.Leader.Address)
+	assert.NotZero(t, manifest.LastIndex)
+	require.Len(t, manifest.Databases, 2)
+	assert.Equal(t, "first", manifest.Databases[0].Name)
+	assert.Equal(t, "second", manifest.Databases[1].Name)
+
+	db, err := app2.Open(ctx, "second")
+	require.NoError(t, err)
+	defer db.Close()
+
+	var n int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT sum(n) FROM test").Scan(&n))
+	assert.Equal(t, 3, n)
+
+	// Databases are never overwritten.
+	_, err = app2.RestoreAll(ctx, bytes.NewReader(buf.Bytes()))
+	assert.EqualError(t, err, "database first is not empty")
+}
+
+// Requests that are not authorized fail.
+func TestAuthorizer(t *testing.T) {
+	authenticate := func(token string) (string, error) {
+		return token, nil
+	}
+	authorize := func(identity string, database string) app.Permission {
+		if identity == "reader" && database == "test" {
+			return app.PermissionRead
+		}
+		return app.PermissionNone
+	}
+
+	a, cleanup := newApp(
+		t,
+		app.WithAddress("127.0.0.1:9001"),
+		app.WithAuthToken("reader"),
+		app.WithAuthenticator(authenticate),
+		app.WithAuthorizer(authorize),
+	)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	require.NoError(t, a.Ready(ctx))
+
+	db, err := a.Open(ctx, "test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "SELECT 1")
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(ctx, "CREATE TABLE foo (n INT)")
+	assert.Error(t, err)
+
+	other, err := a.Open(ctx, "other")
+	require.NoError(t, err)
+	defer other.Close()
+
+	assert.Error(t, other.PingContext(ctx))
+}
+
+// Nodes using a custom TLS policy can talk to each other, while policies
+// allowing obsolete TLS versions are rejected.
+func TestTLSPolicy(t *testing.T) {
+	policy := app.TLSPolicy{
+		MinVersion:   tls.VersionTLS13,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+	}
+
+	addr1 := "127.0.0.1:9001"
+	addr2 := "127.0.0.1:9002"
+
+	_, cleanup := newApp(t, app.WithAddress(addr1), app.WithTLSPolicy(policy))
+	defer cleanup()
+
+	app2, cleanup := newApp(t, app.WithAddress(addr2), app.WithCluster([]string{addr1}), app.WithTLSPolicy(policy))
+	defer cleanup()
+
+	require.NoError(t, app2.Ready(context.Background()))
+
+	dir, dirCleanup := newDir(t)
+	defer dirCleanup()
+
+	cert, pool := loadCert(t)
+	_, err := app.New(
+		dir,
+		app.WithTLS(app.SimpleTLSConfig(cert, pool)),
+		app.WithTLSPolicy(app.TLSPolicy{MinVersion: tls.VersionTLS11}),
+	)
+	assert.EqualError(t, err, "invalid TLS policy: minimum TLS version 0x0302 is older than TLS 1.2")
+}
+
+// Node IDs are encoded in certificates as URI subject alternative names.
+func TestNodeIDFromCertificate(t *testing.T) {
+	uri := app.NodeIdentityURI(3)
+	assert.Equal(t, "dqlite://node/3", uri.String())
+
+	id, ok := app.NodeIDFromCertificate(&x509.Certificate{URIs: []*url.URL{uri}})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), id)
+
+	_, ok = app.NodeIDFromCertificate(&x509.Certificate{})
+	assert.False(t, ok)
+
+	_, ok = app.NodeIDFromCertificate(&x509.Certificate{URIs: []*url.URL{uri, app.NodeIdentityURI(4)}})
+	assert.False(t, ok)
+}
+
+// Node identity binding fails if the certificate carries no node ID.
+func TestNodeIdentityBinding_NoID(t *testing.T) {
+	dir, dirCleanup := newDir(t)
+	defer dirCleanup()
+
+	cert, pool := loadCert(t)
+	_, err := app.New(dir, app.WithTLS(app.SimpleTLSConfig(cert, pool)), app.WithNodeIdentityBinding())
+	assert.EqualError(t, err, "node identity binding: listen config: certificate has no node identity URI")
+}
+
+// Processes whose user is allowed can connect to the local socket.
+// Connections on extra listen addresses reach the node, which keeps
+// advertising its canonical address.
+func TestListenAddress(t *testing.T) {
+	cert, pool := loadCert(t)
+	listen := app.SimpleListenTLSConfig(cert, pool)
+	dial := app.SimpleDialTLSConfig(cert, pool)
+
+	_, cleanup := newApp(t,
+		app.WithAddress("127.0.0.1:9001"),
+		app.WithListenAddress("127.0.0.1:9002", nil),
+		app.WithListenAddress("[::1]:9003", listen))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	cli, err := client.New(ctx, "127.0.0.1:9002")
+	require.NoError(t, err)
+	leader, err := cli.Leader(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9001", leader.Address)
+	cli.Close()
+
+	dialTLS := client.DialFuncWithTLS(client.DefaultDialFunc, dial)
+	cli, err = client.New(ctx, "[::1]:9003", client.WithDialFunc(dialTLS))
+	require.NoError(t, err)
+	_, err = cli.Leader(ctx)
+	require.NoError(t, err)
+	cli.Close()
+}
+
+// A pre-opened listener is used instead of listening on the node address.
+func TestListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:9001")
+	require.NoError(t, err)
+
+	_, cleanup := newApp(t, app.WithAddress("127.0.0.1:9001"), app.WithListener(listener))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	cli, err := client.New(ctx, "127.0.0.1:9001")
+	require.NoError(t, err)
+	_, err = cli.Leader(ctx)
+	require.NoError(t, err)
+	cli.Close()
+}
+
+// No listener is returned if the process was not socket-activated.
+func TestSystemdListeners_NotActivated(t *testing.T) {
+	listeners, err := app.SystemdListeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+}
+
+func TestLocalSocket(t *testing.T) {
+	dir, dirCleanup := newDir(t)
+	defer dirCleanup()
+
+	allowed := filepath.Join(dir, "allowed.sock")
+	denied := filepath.Join(dir, "denied.sock")
+
+	uid := uint32(os.Getuid())
+	_, cleanup := newApp(t, app.WithAddress("127.0.0.1:9001"), app.WithLocalSocket(allowed, app.LocalSocketAccess{UIDs: []uint32{uid}}))
+	defer cleanup()
+
+	_, cleanup = newApp(t, app.WithAddress("127.0.0.1:9002"), app.WithLocalSocket(denied, app.LocalSocketAccess{}))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	cli, err := client.New(ctx, allowed)
+	require.NoError(t, err)
+	_, err = cli.Leader(ctx)
+	require.NoError(t, err)
+	cli.Close()
+
+	_, err = client.New(ctx, denied)
+	assert.Error(t, err)
+}
+
+// Nodes and clients sharing a cluster secret can talk to each other.
+func TestSecretTLSConfig(t *testing.T) {
+	dir, dirCleanup := newDir(t)
+	defer dirCleanup()
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	listen, dial, err := app.SecretTLSConfig(secret)
+	require.NoError(t, err)
+
+	a, err := app.New(dir, app.WithAddress("127.0.0.1:9001"), app.WithTLS(listen, dial))
+	require.NoError(t, err)
+	defer a.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	require.NoError(t, a.Ready(ctx))
+
+	_, dial, err = app.SecretTLSConfig(secret)
+	require.NoError(t, err)
+	cli, err := client.New(ctx, "127.0.0.1:9001", client.WithDialFunc(client.DialFuncWithTLS(client.DefaultDialFunc, dial)))
+	require.NoError(t, err)
+	_, err = cli.Leader(ctx)
+	require.NoError(t, err)
+	cli.Close()
+
+	_, dial, err = app.SecretTLSConfig([]byte("fedcba9876543210fedcba9876543210"))
+	require.NoError(t, err)
+	_, err = client.New(ctx, "127.0.0.1:9001", client.WithDialFunc(client.DialFuncWithTLS(client.DefaultDialFunc, dial)))
+	assert.Error(t, err)
+
+	_, _, err = app.SecretTLSConfig([]byte("short"))
+	assert.EqualError(t, err, "cluster secret must be at least 32 bytes long")
+}
+
+// TLS material can be loaded from files through a certificate source.
+func TestSourceTLSConfig(t *testing.T) {
+	dir, dirCleanup := newDir(t)
+	defer dirCleanup()
+
+	source := app.FileCertificateSource(filepath.Join("testdata", "cluster.crt"), filepath.Join("testdata", "cluster.key"), "")
+	listen, dial := app.SourceTLSConfig(source)
+
+	a, err := app.New(dir, app.WithAddress("127.0.0.1:9001"), app.WithTLS(listen, dial))
+	require.NoError(t, err)
+	defer a.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	require.NoError(t, a.Ready(ctx))
+
+	cli, err := client.New(ctx, "127.0.0.1:9001", client.WithDialFunc(client.DialFuncWithTLS(client.DefaultDialFunc, dial)))
+	require.NoError(t, err)
+	_, err = cli.Leader(ctx)
+	require.NoError(t, err)
+	cli.Close()
+}
+
+// Read-only connections can't change the cluster.
+func TestReadOnly(t *testing.T) {
+	a, cleanup := newApp(t, app.WithAddress("127.0.0.1:9001"))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	require.NoError(t, a.Ready(ctx))
+
+	cert, pool := loadCert(t)
+	dial := client.DialFuncWithTLS(client.DefaultDialFunc, app.SimpleDialTLSConfig(cert, pool))
+
+	cli, err := client.New(ctx, "127.0.0.1:9001", client.WithDialFunc(dial), client.WithReadOnly())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.Leader(ctx)
+	require.NoError(t, err)
+
+	err = cli.Assign(ctx, a.ID(), client.Spare)
+	assert.Error(t, err)
+}
+
+// Large parameter values are sent in chunks assembled by the proxy.
+func TestChunkedParameters(t *testing.T) {
+	a, cleanup := newApp(t, app.WithAddress("127.0.0.1:9001"), app.WithChunkedParameters(1024))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, a.Ready(ctx))
+
+	db, err := a.Open(ctx, "test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "CREATE TABLE test (data BLOB)")
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("x"), 10000)
+	_, err = db.ExecContext(ctx, "INSERT INTO test VALUES(?)", data)
+	require.NoError(t, err)
+
+	var stored []byte
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT data FROM test").Scan(&stored))
+	assert.Equal(t, data, stored)
+}
+
+// Migrations are applied the first time a database is opened.
+func TestMigrations(t *testing.T) {
+	migrations := []migrate.Migration{
+		{Version: 1, Name: "create", SQL: "CREATE TABLE test (n INT)"},
+		{Version: 2, Name: "insert", SQL: "INSERT INTO test VALUES (1)"},
+	}
+	app, cleanup := newApp(t, app.WithAddress("127.0.0.1:9001"), app.WithMigrations("test", migrations))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		db, err := app.Open(ctx, "test")
+		require.NoError(t, err)
+
+		var count int
+		require.NoError(t, db.QueryRowContext(ctx, "SELECT count(*) FROM test").Scan(&count))
+		assert.Equal(t, 1, count)
+
+		require.NoError(t, db.Close())
+	}
+}
+
+// Initialization functions run once per version.
+func TestOpen_Init(t *testing.T) {
+	app1, cleanup := newApp(t, app.WithAddress("127.0.0.1:9001"))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	calls := 0
+	seed := func(ctx context.Context, tx *sql.Tx) error {
+		calls++
+		_, err := tx.ExecContext(ctx, "CREATE TABLE test (n INT)")
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		db, err := app1.Open(ctx, "test", app.WithInit(1, seed))
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+	}
+
+	assert.Equal(t, 1, calls)
+}
+
+// If the given context is cancelled before initial tasks are completed, an
+// error is returned.
+func TestReady_Cancel(t *testing.T) {
+	app, cleanup := newApp(t, app.WithAddress("127.0.0.1:9002"), app.WithCluster([]string{"127.0.0.1:9001"}))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := app.Ready(ctx)
+
+	assert.Equal(t, ctx.Err(), err)
+}
+
+// Run blocks until the context is canceled, and then closes the node.
+func TestRun(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	cert, pool := loadCert(t)
+	app, err := app.New(dir, app.WithAddress("127.0.0.1:9001"), app.WithTLS(app.SimpleTLSConfig(cert, pool)))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		assert.NoError(t, app.Ready(ctx))
+		cancel()
+	}()
+
+	assert.NoError(t, app.Run(ctx))
+}
+
+// Run returns an error if the cluster rejects the node.
+func TestRun_JoinerRejected(t *testing.T) {
+	addr1 := "127.0.0.1:9001"
+	addr2 := "127.0.0.1:9002"
+
+	app1, cleanup := newApp(t, app.WithAddress(addr1))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cli, err := app1.Leader(ctx)
+	require.NoError(t, err)
+	require.NoError(t, cli.Add(ctx, client.NodeInfo{ID: 123, Address: addr2}))
+	cli.Close()
+
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	cert, pool := loadCert(t)
+	app2, err := app.New(
+		dir, app.WithAddress(addr2), app.WithCluster([]string{addr1}),
+		app.WithTLS(app.SimpleTLSConfig(cert, pool)))
+	require.NoError(t, err)
+
+	err = app2.Run(ctx)
+	assert.True(t, errors.Is(err, app.ErrJoinRejected))
 }
 
 func newApp(t *testing.T, options ...app.Option) (*app.App, func()) {
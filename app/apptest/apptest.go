@@ -0,0 +1,318 @@
+// Package apptest provides utilities for running in-process dqlite clusters
+// in tests.
+//
+// A typical usage is:
+//
+//	func TestFoo(t *testing.T) {
+//	        cluster := apptest.NewCluster(t, 3)
+//	        db := cluster.DB(0, "test")
+//	        ...
+//	}
+//
+// All nodes and their data directories are torn down automatically when the
+// test completes.
+package apptest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/app"
+	"github.com/canonical/go-dqlite/client"
+)
+
+// Cluster is a set of dqlite App nodes running in the current process and
+// listening on random loopback ports.
+type Cluster struct {
+	t       testing.TB
+	network *Network
+	timeout time.Duration
+	apps    []*app.App
+	dirs    []string
+	mu      sync.Mutex
+	dbs     []*sql.DB
+	closed  bool
+}
+
+// Option can be used to tweak cluster parameters.
+type Option func(*options)
+
+// WithAppOptions sets additional options to pass to every app.New() call.
+func WithAppOptions(appOptions ...app.Option) Option {
+	return func(o *options) {
+		o.AppOptions = append(o.AppOptions, appOptions...)
+	}
+}
+
+// WithReadyTimeout sets the maximum amount of time to wait for the cluster
+// to be ready. The default is 30 seconds.
+func WithReadyTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.ReadyTimeout = timeout
+	}
+}
+
+// WithoutLogs disables forwarding node logs to the test log.
+func WithoutLogs() Option {
+	return func(o *options) {
+		o.Logs = false
+	}
+}
+
+type options struct {
+	AppOptions   []app.Option
+	ReadyTimeout time.Duration
+	Logs         bool
+}
+
+func defaultOptions() *options {
+	return &options{
+		ReadyTimeout: 30 * time.Second,
+		Logs:         true,
+	}
+}
+
+// NewCluster starts a cluster of n nodes and waits for all of them to have
+// joined it. The first node bootstraps the cluster and the others join it.
+//
+// Roles are adjusted frequently, so the cluster quickly converges to its
+// desired number of voters and stand-bys.
+func NewCluster(t testing.TB, n int, options ...Option) *Cluster {
+	t.Helper()
+
+	if n < 1 {
+		t.Fatalf("apptest: cluster must have at least one node")
+	}
+
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+
+	c := &Cluster{t: t, network: NewNetwork(client.DefaultDialFunc), timeout: o.ReadyTimeout}
+	t.Cleanup(c.Close)
+
+	addresses := make([]string, n)
+	for i := range addresses {
+		addresses[i] = freeAddress(t)
+	}
+
+	for i, address := range addresses {
+		dir, err := ioutil.TempDir("", "dqlite-apptest-")
+		if err != nil {
+			t.Fatalf("apptest: create data dir: %v", err)
+		}
+		c.dirs = append(c.dirs, dir)
+
+		appOptions := []app.Option{
+			app.WithAddress(address),
+			app.WithRolesAdjustmentFrequency(100 * time.Millisecond),
+			app.WithDialFunc(c.network.DialFunc(address)),
+		}
+		if i > 0 {
+			appOptions = append(appOptions, app.WithCluster([]string{addresses[0]}))
+		}
+		if o.Logs {
+			appOptions = append(appOptions, app.WithLogFunc(testLogFunc(t, i)))
+		}
+		appOptions = append(appOptions, o.AppOptions...)
+
+		a, err := app.New(dir, appOptions...)
+		if err != nil {
+			t.Fatalf("apptest: start node %d: %v", i, err)
+		}
+		c.apps = append(c.apps, a)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.ReadyTimeout)
+	defer cancel()
+
+	for i, a := range c.apps {
+		if err := a.Ready(ctx); err != nil {
+			t.Fatalf("apptest: node %d not ready: %v", i, err)
+		}
+	}
+	if err := c.waitMembers(ctx, n); err != nil {
+		t.Fatalf("apptest: cluster not formed: %v", err)
+	}
+
+	return c
+}
+
+// Size returns the number of nodes in the cluster.
+func (c *Cluster) Size() int {
+	return len(c.apps)
+}
+
+// Network returns the Network connecting the nodes, which can be used to
+// inject faults.
+func (c *Cluster) Network() *Network {
+	return c.network
+}
+
+// App returns the i'th node of the cluster.
+func (c *Cluster) App(i int) *app.App {
+	return c.apps[i]
+}
+
+// Addresses returns the addresses of all nodes.
+func (c *Cluster) Addresses() []string {
+	addresses := make([]string, len(c.apps))
+	for i, a := range c.apps {
+		addresses[i] = a.Address()
+	}
+	return addresses
+}
+
+// DB opens the database with the given name using the driver of the i'th node.
+//
+// The returned handle is closed automatically when the cluster is torn down.
+func (c *Cluster) DB(i int, name string) *sql.DB {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	db, err := c.apps[i].Open(ctx, name)
+	if err != nil {
+		c.t.Fatalf("apptest: open database %q on node %d: %v", name, i, err)
+	}
+
+	c.mu.Lock()
+	c.dbs = append(c.dbs, db)
+	c.mu.Unlock()
+
+	return db
+}
+
+// Leader returns the index of the node which is currently the leader.
+func (c *Cluster) Leader(ctx context.Context) (int, error) {
+	cli, err := c.apps[0].Leader(ctx)
+	if err != nil {
+		return -1, err
+	}
+	defer cli.Close()
+
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		return -1, err
+	}
+	if leader == nil {
+		return -1, fmt.Errorf("no leader")
+	}
+
+	for i, a := range c.apps {
+		if a.ID() == leader.ID {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("unknown leader %s", leader.Address)
+}
+
+// Close stops all nodes and removes their data directories. It's called
+// automatically at the end of the test, but can be invoked earlier.
+func (c *Cluster) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	for _, db := range c.dbs {
+		db.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Stop the nodes in reverse order, so the bootstrap node goes last.
+	for i := len(c.apps) - 1; i >= 0; i-- {
+		c.apps[i].Handover(ctx)
+		if err := c.apps[i].Close(); err != nil {
+			c.t.Errorf("apptest: close node %d: %v", i, err)
+		}
+	}
+
+	for _, dir := range c.dirs {
+		os.RemoveAll(dir)
+	}
+}
+
+// WaitVoters waits until the cluster has the given number of voters, failing
+// the test if that doesn't happen within the ready timeout.
+func (c *Cluster) WaitVoters(n int) {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	for {
+		if cli, err := c.apps[0].Leader(ctx); err == nil {
+			nodes, err := cli.Cluster(ctx)
+			cli.Close()
+			voters := 0
+			for _, node := range nodes {
+				if node.Role == client.Voter {
+					voters++
+				}
+			}
+			if err == nil && voters == n {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			c.t.Fatalf("apptest: cluster doesn't have %d voters", n)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// Wait for the leader to report the given number of cluster members.
+func (c *Cluster) waitMembers(ctx context.Context, n int) error {
+	for {
+		if cli, err := c.apps[0].Leader(ctx); err == nil {
+			nodes, err := cli.Cluster(ctx)
+			cli.Close()
+			if err == nil && len(nodes) == n {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// Return a loopback address with a port which is currently free.
+func freeAddress(t testing.TB) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("apptest: find free port: %v", err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+// Forward node logs to the test log.
+func testLogFunc(t testing.TB, index int) client.LogFunc {
+	return func(l client.LogLevel, format string, a ...interface{}) {
+		format = fmt.Sprintf("%s - %d: %s: %s", time.Now().Format("15:04:05.000"), index, l.String(), format)
+		t.Logf(format, a...)
+	}
+}
@@ -0,0 +1,88 @@
+package apptest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/app/apptest"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCluster(t *testing.T) {
+	cluster := apptest.NewCluster(t, 3)
+	assert.Equal(t, 3, cluster.Size())
+
+	db := cluster.DB(0, "test")
+	_, err := db.Exec("CREATE TABLE test (n INT); INSERT INTO test(n) VALUES(1)")
+	require.NoError(t, err)
+
+	var n int
+	require.NoError(t, cluster.DB(2, "test").QueryRow("SELECT n FROM test").Scan(&n))
+	assert.Equal(t, 1, n)
+
+	ctx := context.Background()
+	index, err := cluster.Leader(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, index)
+
+	cli, err := cluster.App(1).Leader(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	nodes, err := cli.Cluster(ctx)
+	require.NoError(t, err)
+	addresses := make([]string, len(nodes))
+	for i, node := range nodes {
+		addresses[i] = node.Address
+	}
+	assert.Equal(t, cluster.Addresses(), addresses)
+	assert.Equal(t, client.Voter, nodes[0].Role)
+}
+
+// Isolating the leader makes the other nodes elect a new one.
+func TestNetwork_Partition(t *testing.T) {
+	cluster := apptest.NewCluster(t, 3)
+	cluster.WaitVoters(3)
+	addresses := cluster.Addresses()
+
+	cluster.Network().Partition(addresses[:1], addresses[1:])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for {
+		cli, err := cluster.App(1).Leader(ctx)
+		if err == nil {
+			leader, err := cli.Leader(ctx)
+			cli.Close()
+			if err == nil && leader != nil && leader.ID != cluster.App(0).ID() {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("no new leader elected")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	cluster.Network().HealAll()
+}
+
+// The cluster survives a minority partition without losing writes.
+func TestCluster_Run(t *testing.T) {
+	cluster := apptest.NewCluster(t, 3)
+	cluster.WaitVoters(3)
+
+	cluster.Run(
+		apptest.Sleep(time.Second),
+		apptest.Partition([]int{2}, []int{0, 1}),
+		apptest.ExpectLeader([]int{0, 1}, 10*time.Second),
+		apptest.Sleep(2*time.Second),
+		apptest.Heal(),
+		apptest.Sleep(time.Second),
+	)
+}
@@ -0,0 +1,210 @@
+package apptest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// Network injects faults in the connections established between nodes.
+//
+// Faults apply to links, each identified by the address of the node that
+// dials and the address it dials to. Since every node dials its peers in
+// order to send them messages, a fault on the link from A to B affects the
+// messages that A sends to B, and the replies B sends back on the same
+// connection.
+type Network struct {
+	mu    sync.Mutex
+	links map[link]*fault
+	conns map[link]map[*faultyConn]struct{}
+	dial  client.DialFunc
+}
+
+// Directed link between two nodes.
+type link struct {
+	from string
+	to   string
+}
+
+// Faults currently injected on a link.
+type fault struct {
+	drop      bool
+	delay     time.Duration
+	duplicate bool
+}
+
+// NewNetwork returns a Network with no faults, using the given function to
+// establish the actual connections.
+func NewNetwork(dial client.DialFunc) *Network {
+	return &Network{
+		links: map[link]*fault{},
+		conns: map[link]map[*faultyConn]struct{}{},
+		dial:  dial,
+	}
+}
+
+// DialFunc returns a dial function to be used by the node with the given
+// address, which honors the faults injected on its outgoing links.
+func (n *Network) DialFunc(from string) client.DialFunc {
+	return func(ctx context.Context, to string) (net.Conn, error) {
+		l := link{from: from, to: to}
+		f := n.fault(l)
+
+		if f.drop {
+			return nil, fmt.Errorf("apptest: link %s -> %s is down", from, to)
+		}
+		if f.delay > 0 {
+			select {
+			case <-time.After(f.delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		conn, err := n.dial(ctx, to)
+		if err != nil {
+			return nil, err
+		}
+
+		c := &faultyConn{Conn: conn, network: n, link: l}
+		if f.duplicate {
+			mirror, err := n.dial(ctx, to)
+			if err == nil {
+				go io.Copy(ioutil.Discard, mirror)
+				c.mirror = mirror
+			}
+		}
+
+		n.mu.Lock()
+		if n.conns[l] == nil {
+			n.conns[l] = map[*faultyConn]struct{}{}
+		}
+		n.conns[l][c] = struct{}{}
+		n.mu.Unlock()
+
+		return c, nil
+	}
+}
+
+// Drop makes the link from one node to another unavailable. New connections
+// are refused and existing ones are closed.
+func (n *Network) Drop(from, to string) {
+	n.mu.Lock()
+	l := link{from: from, to: to}
+	n.get(l).drop = true
+	conns := n.conns[l]
+	delete(n.conns, l)
+	n.mu.Unlock()
+
+	for c := range conns {
+		c.Conn.Close()
+		if c.mirror != nil {
+			c.mirror.Close()
+		}
+	}
+}
+
+// Delay makes every write on the link from one node to another, as well as
+// establishing new connections, take at least the given amount of time.
+func (n *Network) Delay(from, to string, delay time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.get(link{from: from, to: to}).delay = delay
+}
+
+// Duplicate makes new connections on the link from one node to another
+// deliver every message twice, by mirroring all writes on a second
+// connection to the same node.
+func (n *Network) Duplicate(from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.get(link{from: from, to: to}).duplicate = true
+}
+
+// Partition drops all links between the nodes of the two given groups, in
+// both directions.
+func (n *Network) Partition(group1, group2 []string) {
+	for _, a := range group1 {
+		for _, b := range group2 {
+			n.Drop(a, b)
+			n.Drop(b, a)
+		}
+	}
+}
+
+// Heal removes all faults from the link from one node to another.
+func (n *Network) Heal(from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.links, link{from: from, to: to})
+}
+
+// HealAll removes all faults from all links.
+func (n *Network) HealAll() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.links = map[link]*fault{}
+}
+
+// Return a copy of the faults injected on the given link.
+func (n *Network) fault(l link) fault {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if f, ok := n.links[l]; ok {
+		return *f
+	}
+	return fault{}
+}
+
+// Return the faults on the given link, creating an entry if needed. Must be
+// called with the lock held.
+func (n *Network) get(l link) *fault {
+	f, ok := n.links[l]
+	if !ok {
+		f = &fault{}
+		n.links[l] = f
+	}
+	return f
+}
+
+func (n *Network) forget(c *faultyConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.conns[c.link], c)
+}
+
+// Connection subject to the faults of its link.
+type faultyConn struct {
+	net.Conn
+	network *Network
+	link    link
+	mirror  net.Conn
+}
+
+func (c *faultyConn) Write(b []byte) (int, error) {
+	f := c.network.fault(c.link)
+	if f.drop {
+		return 0, fmt.Errorf("apptest: link %s -> %s is down", c.link.from, c.link.to)
+	}
+	if f.delay > 0 {
+		time.Sleep(f.delay)
+	}
+	if c.mirror != nil {
+		c.mirror.Write(b)
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *faultyConn) Close() error {
+	c.network.forget(c)
+	if c.mirror != nil {
+		c.mirror.Close()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,274 @@
+package apptest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// Step is a single action of a fault scenario.
+type Step struct {
+	desc string
+	run  func(c *Cluster) error
+}
+
+// String returns a human-readable description of the step.
+func (s Step) String() string {
+	return s.desc
+}
+
+// Partition drops all links between the nodes with the given indexes.
+func Partition(group1, group2 []int) Step {
+	return Step{
+		desc: fmt.Sprintf("partition %v from %v", group1, group2),
+		run: func(c *Cluster) error {
+			c.network.Partition(c.addresses(group1), c.addresses(group2))
+			return nil
+		},
+	}
+}
+
+// Isolate drops all links between the node with the given index and all
+// other nodes.
+func Isolate(node int) Step {
+	return Step{
+		desc: fmt.Sprintf("isolate %d", node),
+		run: func(c *Cluster) error {
+			others := []int{}
+			for i := range c.apps {
+				if i != node {
+					others = append(others, i)
+				}
+			}
+			c.network.Partition(c.addresses([]int{node}), c.addresses(others))
+			return nil
+		},
+	}
+}
+
+// Delay slows down the link from one node to another.
+func Delay(from, to int, delay time.Duration) Step {
+	return Step{
+		desc: fmt.Sprintf("delay %d -> %d by %s", from, to, delay),
+		run: func(c *Cluster) error {
+			c.network.Delay(c.apps[from].Address(), c.apps[to].Address(), delay)
+			return nil
+		},
+	}
+}
+
+// Heal removes all faults.
+func Heal() Step {
+	return Step{
+		desc: "heal",
+		run: func(c *Cluster) error {
+			c.network.HealAll()
+			return nil
+		},
+	}
+}
+
+// Sleep lets the scenario run for the given amount of time.
+func Sleep(d time.Duration) Step {
+	return Step{
+		desc: fmt.Sprintf("sleep %s", d),
+		run: func(c *Cluster) error {
+			time.Sleep(d)
+			return nil
+		},
+	}
+}
+
+// ExpectLeader waits for the nodes with the given indexes to agree on a
+// single leader, which must be one of them.
+func ExpectLeader(nodes []int, timeout time.Duration) Step {
+	return Step{
+		desc: fmt.Sprintf("expect leader among %v", nodes),
+		run: func(c *Cluster) error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			_, err := c.agreedLeader(ctx, nodes)
+			return err
+		},
+	}
+}
+
+// Run executes the given steps while a background workload keeps writing to
+// the cluster, and then checks that:
+//
+// - once all faults are healed, all nodes agree on a single leader;
+// - no write that was acknowledged as committed has been lost.
+//
+// Any violation fails the test.
+func (c *Cluster) Run(steps ...Step) {
+	c.t.Helper()
+
+	w, err := c.startWorkload()
+	if err != nil {
+		c.t.Fatalf("apptest: start workload: %v", err)
+	}
+
+	for _, step := range steps {
+		c.t.Logf("apptest: %s", step)
+		if err := step.run(c); err != nil {
+			w.stop()
+			c.t.Fatalf("apptest: step %q: %v", step, err)
+		}
+	}
+
+	w.stop()
+	c.network.HealAll()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	all := make([]int, len(c.apps))
+	for i := range all {
+		all[i] = i
+	}
+	leader, err := c.agreedLeader(ctx, all)
+	if err != nil {
+		c.t.Fatalf("apptest: invariant violated: %v", err)
+	}
+
+	if err := w.verify(ctx, c.DB(leader, workloadDatabase)); err != nil {
+		c.t.Fatalf("apptest: invariant violated: %v", err)
+	}
+}
+
+// Return the addresses of the nodes with the given indexes.
+func (c *Cluster) addresses(nodes []int) []string {
+	addresses := make([]string, len(nodes))
+	for i, node := range nodes {
+		addresses[i] = c.apps[node].Address()
+	}
+	return addresses
+}
+
+// Wait until all given nodes report the same leader, which must be one of
+// them, and return its index.
+func (c *Cluster) agreedLeader(ctx context.Context, nodes []int) (int, error) {
+	var last string
+	for {
+		leaders := map[string]bool{}
+		for _, node := range nodes {
+			leaders[c.reportedLeader(ctx, node)] = true
+		}
+		if len(leaders) == 1 {
+			for address := range leaders {
+				for _, node := range nodes {
+					if address != "" && c.apps[node].Address() == address {
+						return node, nil
+					}
+				}
+			}
+		}
+		last = fmt.Sprintf("%v", leaders)
+
+		select {
+		case <-ctx.Done():
+			return -1, fmt.Errorf("nodes %v don't agree on a single leader: %s", nodes, last)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Return the address of the leader according to the given node, or an empty
+// string if it can't be determined.
+func (c *Cluster) reportedLeader(ctx context.Context, node int) string {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	cli, err := client.New(ctx, c.apps[node].Address())
+	if err != nil {
+		return ""
+	}
+	defer cli.Close()
+
+	leader, err := cli.Leader(ctx)
+	if err != nil || leader == nil {
+		return ""
+	}
+	return leader.Address
+}
+
+const workloadDatabase = "apptest"
+
+// Background writer recording which writes were acknowledged.
+type workload struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	acked  []int64
+}
+
+func (c *Cluster) startWorkload() (*workload, error) {
+	db := c.DB(0, workloadDatabase)
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS writes (n INTEGER PRIMARY KEY)"); err != nil {
+		return nil, err
+	}
+
+	dbs := make([]*sql.DB, len(c.apps))
+	for i := range c.apps {
+		dbs[i] = c.DB(i, workloadDatabase)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &workload{cancel: cancel}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for n := int64(1); ctx.Err() == nil; n++ {
+			writeCtx, writeCancel := context.WithTimeout(ctx, 2*time.Second)
+			_, err := dbs[int(n)%len(dbs)].ExecContext(writeCtx, "INSERT INTO writes(n) VALUES(?)", n)
+			writeCancel()
+			if err == nil {
+				w.mu.Lock()
+				w.acked = append(w.acked, n)
+				w.mu.Unlock()
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	return w, nil
+}
+
+func (w *workload) stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// Check that all acknowledged writes are present.
+func (w *workload) verify(ctx context.Context, db *sql.DB) error {
+	present := map[int64]bool{}
+	rows, err := db.QueryContext(ctx, "SELECT n FROM writes")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			return err
+		}
+		present[n] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, n := range w.acked {
+		if !present[n] {
+			return fmt.Errorf("acknowledged write %d was lost", n)
+		}
+	}
+
+	return nil
+}
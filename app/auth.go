@@ -0,0 +1,132 @@
+package app
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+)
+
+// AuthFunc validates the token presented by a client connecting to the App
+// proxy. It returns an identity for the client, which is reported in the
+// ProxyConnection information, or an error if the token is not valid.
+//
+// The token can be a pre-shared secret or, for example, a signed claim that
+// the function verifies.
+type AuthFunc func(token string) (identity string, err error)
+
+// Maximum time that clients have to complete authentication.
+const authTimeout = 10 * time.Second
+
+// Maximum size of the body of an auth request.
+const maxAuthRequestSize = 64 * 1024
+
+// SQLite's SQLITE_AUTH code, returned to clients failing authentication or
+// authorization.
+const errAuth = 23
+
+// Return an AuthFunc accepting only the given pre-shared token.
+func tokenAuth(token string) AuthFunc {
+	return func(presented string) (string, error) {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			return "", fmt.Errorf("invalid token")
+		}
+		return "", nil
+	}
+}
+
+// Return a proxy handshake function performing the server side of the
+// authentication step on a connection accepted by the proxy, and then
+// forwarding the protocol handshake to the local node. The identity of the
+// client is recorded in the given connection tracker.
+func serverAuth(auth AuthFunc, conn *proxyConn) func(remote, local net.Conn) error {
+	return func(remote, local net.Conn) error {
+		remote.SetDeadline(time.Now().Add(authTimeout))
+		defer remote.SetDeadline(time.Time{})
+
+		handshake := make([]byte, 8)
+		if _, err := io.ReadFull(remote, handshake); err != nil {
+			return fmt.Errorf("read handshake: %w", err)
+		}
+
+		request := protocol.Message{}
+		request.Init(64)
+		response := protocol.Message{}
+		response.Init(64)
+
+		if err := protocol.ReadMessageLimit(remote, &request, maxAuthRequestSize); err != nil {
+			return fmt.Errorf("read auth request: %w", err)
+		}
+
+		var identity string
+		var err error
+		if request.Type() != protocol.RequestAuth {
+			err = fmt.Errorf("authentication required")
+		} else {
+			var token string
+			token, err = protocol.DecodeAuthRequest(&request)
+			if err == nil {
+				identity, err = auth(token)
+			}
+		}
+		if err != nil {
+			protocol.EncodeFailure(&response, errAuth, "authentication failed")
+			protocol.WriteMessage(remote, &response)
+			return fmt.Errorf("authenticate %s: %w", remote.RemoteAddr(), err)
+		}
+
+		protocol.EncodeEmpty(&response)
+		if err := protocol.WriteMessage(remote, &response); err != nil {
+			return fmt.Errorf("write auth response: %w", err)
+		}
+
+		conn.mu.Lock()
+		conn.identity = identity
+		conn.mu.Unlock()
+
+		if _, err := local.Write(handshake); err != nil {
+			return fmt.Errorf("forward handshake: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// Return a proxy handshake function performing the client side of the
+// authentication step on a connection established by the local node with
+// another node.
+func clientAuth(token string) func(remote, local net.Conn) error {
+	return func(remote, local net.Conn) error {
+		remote.SetDeadline(time.Now().Add(authTimeout))
+		defer remote.SetDeadline(time.Time{})
+
+		handshake := make([]byte, 8)
+		if _, err := io.ReadFull(local, handshake); err != nil {
+			return fmt.Errorf("read handshake: %w", err)
+		}
+		if _, err := remote.Write(handshake); err != nil {
+			return fmt.Errorf("forward handshake: %w", err)
+		}
+
+		request := protocol.Message{}
+		request.Init(16 + len(token))
+		response := protocol.Message{}
+		response.Init(64)
+
+		protocol.EncodeAuth(&request, token)
+		if err := protocol.WriteMessage(remote, &request); err != nil {
+			return fmt.Errorf("write auth request: %w", err)
+		}
+		if err := protocol.ReadMessage(remote, &response); err != nil {
+			return fmt.Errorf("read auth response: %w", err)
+		}
+		if err := protocol.DecodeEmpty(&response); err != nil {
+			return fmt.Errorf("authenticate: %w", err)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,372 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/canonical/go-dqlite/internal/sqlutil"
+)
+
+// Permission is the level of access granted to a client on a database.
+type Permission int
+
+// Available permissions, in increasing order of privilege.
+const (
+	PermissionNone  Permission = 0 // No access at all.
+	PermissionRead  Permission = 1 // Read-only access.
+	PermissionWrite Permission = 2 // Read-write access.
+)
+
+// String implements the Stringer interface.
+func (p Permission) String() string {
+	switch p {
+	case PermissionNone:
+		return "none"
+	case PermissionRead:
+		return "read"
+	case PermissionWrite:
+		return "write"
+	default:
+		return "unknown"
+	}
+}
+
+// AuthorizeFunc returns the permission that the client with the given
+// identity has on the database with the given name.
+//
+// The identity is the one returned by the AuthFunc if authentication is
+// enabled, or else the common name of the client TLS certificate. Cluster
+// management requests (add, assign, remove and transfer) are authorized with
+// an empty database name and require PermissionWrite.
+type AuthorizeFunc func(identity string, database string) Permission
+
+// SQLite's SQLITE_MISUSE code, returned to clients whose parameter chunks
+// can't be spliced into their statement.
+const errChunk = 21
+
+// Maximum total size of the parameter chunks of a statement, matching the
+// default maximum length of SQLite strings and blobs.
+const maxChunkedSize = 1000000000
+
+// Filter the requests sent by a proxied client, answering with a failure
+// those that are not authorized instead of forwarding them.
+type requestFilter struct {
+	authorize    AuthorizeFunc // Check access to databases, if set.
+	bindIdentity bool          // Check node IDs against the client certificate.
+	state        stateFunc     // Answer state requests.
+	conn         *proxyConn
+	identity     string
+	nodeID       uint64                // Node ID in the client certificate, or 0
+	readOnly     bool                  // Whether the client asked to reject writes
+	perms        map[string]Permission // Permissions on the databases opened so far
+	chunks       protocol.ChunkBuffer  // Parameter chunks of the next statement
+}
+
+func newRequestFilter(authorize AuthorizeFunc, bindIdentity bool, state stateFunc, conn *proxyConn) *requestFilter {
+	return &requestFilter{
+		authorize:    authorize,
+		bindIdentity: bindIdentity,
+		state:        state,
+		conn:         conn,
+		perms:        map[string]Permission{},
+	}
+}
+
+// Copy requests from src to dst, writing failure responses for unauthorized
+// requests to reply.
+//
+// Connections from other nodes carrying raft traffic are detected by their
+// first request and copied verbatim.
+func (f *requestFilter) copy(dst io.Writer, src io.Reader, reply io.Writer) error {
+	request := protocol.Message{}
+	request.Init(4096)
+	response := protocol.Message{}
+	response.Init(64)
+
+	for first := true; ; first = false {
+		if err := protocol.ReadMessage(src, &request); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		// The TLS handshake has completed by now.
+		if first {
+			f.identity = f.conn.peerIdentity()
+			if cert := f.conn.peerCertificate(); cert != nil {
+				f.nodeID, _ = NodeIDFromCertificate(cert)
+			}
+		}
+
+		if first && request.Type() == protocol.RequestConnect {
+			if err := f.checkConnect(&request); err != nil {
+				return err
+			}
+			if err := protocol.CopyMessage(dst, &request); err != nil {
+				return err
+			}
+			_, err := io.Copy(dst, src)
+			return err
+		}
+
+		if request.Type() == protocol.RequestReadOnly {
+			f.readOnly = true
+			protocol.EncodeEmpty(&response)
+			if err := writeResponse(reply, &response); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if request.Type() == protocol.RequestState {
+			if index, term, err := f.state(); err != nil {
+				protocol.EncodeFailure(&response, errState, err.Error())
+			} else {
+				protocol.EncodeLastEntry(&response, index, term)
+			}
+			if err := writeResponse(reply, &response); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if request.Type() == protocol.RequestChunk {
+			if err := f.addChunk(&request); err != nil {
+				protocol.EncodeFailure(&response, errChunk, err.Error())
+			} else {
+				protocol.EncodeEmpty(&response)
+			}
+			if err := writeResponse(reply, &response); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := f.check(&request); err != nil {
+			f.chunks.Reset()
+			protocol.EncodeFailure(&response, errAuth, err.Error())
+			if err := writeResponse(reply, &response); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := f.splice(&request); err != nil {
+			protocol.EncodeFailure(&response, errChunk, err.Error())
+			if err := writeResponse(reply, &response); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := protocol.CopyMessage(dst, &request); err != nil {
+			return err
+		}
+	}
+}
+
+// Write the given response with a single call, so it's not interleaved with
+// data sent by the node.
+func writeResponse(w io.Writer, response *protocol.Message) error {
+	buf := bytes.NewBuffer(nil)
+	protocol.WriteMessage(buf, response)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Return an error if the raft connection started by the given request comes
+// from a node whose certificate doesn't match its ID.
+func (f *requestFilter) checkConnect(request *protocol.Message) (err error) {
+	if !f.bindIdentity {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed connect request")
+		}
+	}()
+
+	id, _ := protocol.DecodeNodeRequest(request)
+	if id != f.nodeID {
+		return fmt.Errorf("raft connection from node %d with certificate for node %d", id, f.nodeID)
+	}
+
+	return nil
+}
+
+// Return an error if the given request is not authorized.
+func (f *requestFilter) check(request *protocol.Message) (err error) {
+	// Requests from untrusted clients might be malformed, making
+	// decoding panic.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed request")
+		}
+	}()
+
+	if f.bindIdentity {
+		if err := f.checkMembership(request); err != nil {
+			return err
+		}
+	}
+
+	if f.authorize == nil && !f.readOnly {
+		return nil
+	}
+
+	return f.checkPermission(request)
+}
+
+// Add the parameter chunk carried by the given request.
+func (f *requestFilter) addChunk(request *protocol.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			f.chunks.Reset()
+			err = fmt.Errorf("malformed chunk request")
+		}
+	}()
+
+	return f.chunks.Add(request, maxChunkedSize)
+}
+
+// Splice the parameter chunks received so far into the given request.
+func (f *requestFilter) splice(request *protocol.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			f.chunks.Reset()
+			err = fmt.Errorf("malformed request")
+		}
+	}()
+
+	return f.chunks.Splice(request)
+}
+
+// Return an error if the given request changes the cluster membership and
+// doesn't come from a node allowed to do so.
+func (f *requestFilter) checkMembership(request *protocol.Message) error {
+	switch request.Type() {
+	case protocol.RequestAdd:
+		id, _ := protocol.DecodeNodeRequest(request)
+		if id != f.nodeID {
+			return fmt.Errorf("only node %d can add itself", id)
+		}
+	case protocol.RequestAssign, protocol.RequestRemove, protocol.RequestTransfer:
+		if f.nodeID == 0 {
+			return fmt.Errorf("membership changes require a node certificate")
+		}
+	}
+	return nil
+}
+
+// Return an error if the given request is not allowed by the authorizer or by
+// the read-only mode.
+func (f *requestFilter) checkPermission(request *protocol.Message) error {
+	switch request.Type() {
+	case protocol.RequestLeader, protocol.RequestClient, protocol.RequestHeartbeat,
+		protocol.RequestCluster, protocol.RequestInterrupt, protocol.RequestFinalize:
+		return nil
+	case protocol.RequestOpen:
+		name, _, _ := protocol.DecodeOpenRequest(request)
+		perm := f.grant(name)
+		if perm == PermissionNone {
+			return fmt.Errorf("not authorized to access database %s", name)
+		}
+		f.perms[name] = perm
+		return nil
+	case protocol.RequestDump:
+		name := protocol.DecodeDumpRequest(request)
+		if f.grant(name) == PermissionNone {
+			return fmt.Errorf("not authorized to access database %s", name)
+		}
+		return nil
+	case protocol.RequestPrepare, protocol.RequestExecSQL, protocol.RequestQuerySQL:
+		// All these requests start with a database ID and SQL text.
+		_, sql := protocol.DecodePrepareRequest(request)
+		switch f.permission() {
+		case PermissionNone:
+			return fmt.Errorf("no database open")
+		case PermissionRead:
+			if !sqlutil.IsReadOnly(sql) {
+				return fmt.Errorf("read-only access")
+			}
+		}
+		return nil
+	case protocol.RequestExec, protocol.RequestQuery:
+		// Statements were checked when they were prepared.
+		if f.permission() == PermissionNone {
+			return fmt.Errorf("no database open")
+		}
+		return nil
+	case protocol.RequestAdd, protocol.RequestAssign, protocol.RequestRemove, protocol.RequestTransfer:
+		if f.grant("") != PermissionWrite {
+			return fmt.Errorf("not authorized to manage the cluster")
+		}
+		return nil
+	default:
+		return fmt.Errorf("request type %d not allowed", request.Type())
+	}
+}
+
+// Return the permission of the client on the database with the given name.
+func (f *requestFilter) grant(database string) Permission {
+	perm := PermissionWrite
+	if f.authorize != nil {
+		perm = f.authorize(f.identity, database)
+	}
+	if f.readOnly && perm > PermissionRead {
+		perm = PermissionRead
+	}
+	return perm
+}
+
+// Return the permission that applies to requests referencing a database.
+//
+// Since database IDs are assigned by the node, the proxy can't tell which of
+// the databases opened by the connection a request refers to, so the most
+// restrictive permission is used.
+func (f *requestFilter) permission() Permission {
+	if len(f.perms) == 0 {
+		return PermissionNone
+	}
+	perm := PermissionWrite
+	if f.readOnly {
+		perm = PermissionRead
+	}
+	for _, p := range f.perms {
+		if p < perm {
+			perm = p
+		}
+	}
+	return perm
+}
+
+// Return a proxy handshake function just forwarding the protocol handshake to
+// the local node.
+func forwardHandshake(remote, local net.Conn) error {
+	handshake := make([]byte, 8)
+	if _, err := io.ReadFull(remote, handshake); err != nil {
+		return fmt.Errorf("read handshake: %w", err)
+	}
+	if _, err := local.Write(handshake); err != nil {
+		return fmt.Errorf("forward handshake: %w", err)
+	}
+	return nil
+}
+
+// Writer serializing writes from multiple goroutines.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
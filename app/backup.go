@@ -0,0 +1,450 @@
+package app
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/dbfile"
+	_ "github.com/mattn/go-sqlite3" // Used to read the database images of an archive.
+)
+
+// Name of the table of the internal database listing the databases opened
+// with App.Open.
+const databasesTable = "_dqlite_databases"
+
+// Name of the manifest in archives written by BackupAll.
+const backupManifest = "manifest.json"
+
+// Directory of the database images in archives written by BackupAll.
+const backupDatabasesDir = "databases/"
+
+// Number of rows inserted by each transaction of RestoreAll.
+const restoreBatchSize = 1000
+
+// BackupManifest describes the content of an archive written by BackupAll.
+type BackupManifest struct {
+	Time      time.Time         `json:"time"`
+	Leader    client.NodeInfo   `json:"leader"`
+	Nodes     []client.NodeInfo `json:"nodes"`
+	LastIndex uint64            `json:"last_index,omitempty"` // Last index of the leader, if known.
+	Databases []BackupDatabase  `json:"databases"`
+}
+
+// BackupDatabase describes a database of a BackupManifest.
+type BackupDatabase struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupAll writes to the given writer a tar archive containing an image of
+// every database of the cluster, along with a manifest describing the
+// cluster and the databases, so the whole cluster can be backed up with a
+// single call and restored with RestoreAll.
+//
+// The databases are the ones opened with App.Open by any node since the
+// cluster was created, except for the internal database of the app package.
+// Each database is dumped from the leader as a standalone SQLite file, under
+// the "databases/" directory of the archive, while the manifest is written
+// last, as "manifest.json". Databases are dumped one at a time, so the
+// archive is not a point-in-time copy of the cluster if they are modified
+// meanwhile.
+func (a *App) BackupAll(ctx context.Context, w io.Writer) error {
+	databases, err := a.recordedDatabases(ctx)
+	if err != nil {
+		return fmt.Errorf("list databases: %w", err)
+	}
+
+	cli, err := a.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	nodes, err := cli.Cluster(ctx)
+	if err != nil {
+		return err
+	}
+
+	manifest := BackupManifest{Time: a.clock.Now().UTC(), Leader: *leader, Nodes: nodes}
+
+	// The last index is only known if the leader is an App node.
+	manifest.LastIndex, _ = cli.LastIndex(ctx)
+
+	tw := tar.NewWriter(w)
+
+	for _, database := range databases {
+		image, err := dumpImage(ctx, cli, database)
+		if err != nil {
+			return fmt.Errorf("dump %s: %w", database, err)
+		}
+		if err := writeTarFile(tw, backupDatabasesDir+database, image, manifest.Time); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(image)
+		manifest.Databases = append(manifest.Databases, BackupDatabase{
+			Name:   database,
+			Size:   int64(len(image)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, backupManifest, data, manifest.Time); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// RestoreAll loads the databases of an archive written by BackupAll into the
+// cluster, after checking them against the manifest, and returns the
+// manifest.
+//
+// The databases must not exist yet or be empty, typically because the cluster
+// was just created, or else RestoreAll fails before loading any data.
+func (a *App) RestoreAll(ctx context.Context, r io.Reader) (*BackupManifest, error) {
+	dir, err := ioutil.TempDir("", "dqlite-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, paths, err := extractBackup(r, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	dbs := make([]*sql.DB, len(manifest.Databases))
+	defer func() {
+		for _, db := range dbs {
+			if db != nil {
+				db.Close()
+			}
+		}
+	}()
+
+	for i, database := range manifest.Databases {
+		dbs[i], err = a.Open(ctx, database.Name)
+		if err != nil {
+			return nil, err
+		}
+		var n int
+		if err := dbs[i].QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master").Scan(&n); err != nil {
+			return nil, fmt.Errorf("check %s: %w", database.Name, err)
+		}
+		if n > 0 {
+			return nil, fmt.Errorf("database %s is not empty", database.Name)
+		}
+	}
+
+	for i, database := range manifest.Databases {
+		if err := restoreDatabase(ctx, paths[database.Name], dbs[i]); err != nil {
+			return nil, fmt.Errorf("restore %s: %w", database.Name, err)
+		}
+		a.debug("restored database %s", database.Name)
+	}
+
+	return manifest, nil
+}
+
+// Record in the cluster that the given database was opened, so that BackupAll
+// includes it.
+func (a *App) recordDatabase(ctx context.Context, database string) error {
+	if database == internalDatabase {
+		return nil
+	}
+
+	a.recordedMu.Lock()
+	defer a.recordedMu.Unlock()
+
+	if a.recorded[database] {
+		return nil
+	}
+
+	db, err := a.Open(ctx, internalDatabase)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name TEXT PRIMARY KEY)", databasesTable)
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+
+	stmt = fmt.Sprintf("INSERT OR IGNORE INTO %s(name) VALUES(?)", databasesTable)
+	if _, err := db.ExecContext(ctx, stmt, database); err != nil {
+		return err
+	}
+
+	a.recorded[database] = true
+
+	return nil
+}
+
+// Return the names of the databases recorded in the cluster, sorted.
+func (a *App) recordedDatabases(ctx context.Context) ([]string, error) {
+	db, err := a.Open(ctx, internalDatabase)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM %s ORDER BY name", databasesTable))
+	if err != nil {
+		// No database was ever opened.
+		if strings.Contains(err.Error(), "no such table") {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	databases := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		databases = append(databases, name)
+	}
+
+	return databases, rows.Err()
+}
+
+// Dump the given database as a standalone SQLite file.
+func dumpImage(ctx context.Context, cli *client.Client, database string) ([]byte, error) {
+	files, err := cli.Dump(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+
+	var db, wal []byte
+	for _, file := range files {
+		switch file.Name {
+		case database:
+			db = file.Data
+		case database + "-wal":
+			wal = file.Data
+		}
+	}
+
+	return dbfile.Checkpoint(db, wal)
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// Extract the database images of the given archive in the given directory,
+// and return the manifest of the archive along with the paths of the images,
+// by database name.
+func extractBackup(r io.Reader, dir string) (*BackupManifest, map[string]string, error) {
+	var manifest *BackupManifest
+	paths := map[string]string{}
+	sums := map[string]string{}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("read archive: %w", err)
+		}
+
+		if header.Name == backupManifest {
+			manifest = &BackupManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("decode manifest: %w", err)
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(header.Name, backupDatabasesDir) {
+			continue
+		}
+		name := strings.TrimPrefix(header.Name, backupDatabasesDir)
+
+		// Database names might not be valid file names.
+		path := filepath.Join(dir, fmt.Sprintf("%d.db", len(paths)))
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(f, h), tr)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("extract %s: %w", name, err)
+		}
+		paths[name] = path
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("archive has no %s", backupManifest)
+	}
+	for _, database := range manifest.Databases {
+		sum, ok := sums[database.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("archive has no image of database %s", database.Name)
+		}
+		if sum != database.SHA256 {
+			return nil, nil, fmt.Errorf("checksum mismatch for database %s", database.Name)
+		}
+	}
+
+	return manifest, paths, nil
+}
+
+// A schema object as found in sqlite_master.
+type schemaObject struct {
+	Type string
+	Name string
+	SQL  string
+}
+
+// Copy the schema and the rows of the SQLite file at the given path into the
+// given database.
+//
+// Tables are created first, then their rows are copied, and finally indexes,
+// views and triggers are created, which avoids updating indexes row by row
+// and firing triggers.
+func restoreDatabase(ctx context.Context, path string, dst *sql.DB) error {
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	rows, err := src.QueryContext(ctx, `
+SELECT type, name, sql FROM sqlite_master
+ WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+ ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, rowid`)
+	if err != nil {
+		return fmt.Errorf("read schema: %w", err)
+	}
+	objects := []schemaObject{}
+	for rows.Next() {
+		object := schemaObject{}
+		if err := rows.Scan(&object.Type, &object.Name, &object.SQL); err != nil {
+			rows.Close()
+			return fmt.Errorf("read schema: %w", err)
+		}
+		objects = append(objects, object)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read schema: %w", err)
+	}
+
+	for _, object := range objects {
+		if _, err := dst.ExecContext(ctx, object.SQL); err != nil {
+			return fmt.Errorf("create %s %s: %w", object.Type, object.Name, err)
+		}
+		if object.Type != "table" {
+			continue
+		}
+		if err := restoreTable(ctx, src, dst, object.Name); err != nil {
+			return fmt.Errorf("copy table %s: %w", object.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Copy all rows of the given table, in batches.
+func restoreTable(ctx context.Context, src, dst *sql.DB, table string) error {
+	quoted := `"` + strings.Replace(table, `"`, `""`, -1) + `"`
+
+	rows, err := src.QueryContext(ctx, "SELECT * FROM "+quoted)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	insert := fmt.Sprintf("INSERT INTO %s VALUES(%s)", quoted, placeholders)
+
+	var tx *sql.Tx
+	count := 0
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			if tx != nil {
+				tx.Rollback()
+			}
+			return err
+		}
+
+		if tx == nil {
+			tx, err = dst.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, insert, values...); err != nil {
+			tx.Rollback()
+			return err
+		}
+		count++
+
+		if count%restoreBatchSize == 0 {
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			tx = nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+		return err
+	}
+	if tx != nil {
+		return tx.Commit()
+	}
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertificateSource provides the TLS key pair of a node and the pool of
+// certificate authorities it trusts.
+//
+// The methods are called for every TLS handshake, so implementations can
+// return updated material when secrets are rotated, and should cache it. This
+// makes it possible to integrate with secret managers such as Vault or SPIFFE
+// agents by implementing this interface.
+type CertificateSource interface {
+	// GetCertificate returns the key pair presented to peers.
+	GetCertificate() (*tls.Certificate, error)
+
+	// GetCertPool returns the certificate authorities used to verify
+	// peers.
+	GetCertPool() (*x509.CertPool, error)
+}
+
+// SourceTLSConfig returns a pair of TLS configuration objects like
+// SimpleTLSConfig, but taking the key pair and the trusted certificate
+// authorities from the given source at each handshake, so rotated material is
+// picked up without restarting.
+//
+// Since the pool can change, peers are verified against it with custom
+// verification callbacks, which don't check host names.
+//
+// The returned configs can be used as "listen" and "dial" parameters for the
+// WithTLS option, and the dial one with client.DialFuncWithTLS.
+func SourceTLSConfig(source CertificateSource) (*tls.Config, *tls.Config) {
+	listen := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return source.GetCertificate()
+		},
+		// The client certificate is verified against the current pool
+		// by VerifyPeerCertificate. ClientCAs is only set to tell the
+		// proxy that this is a server-side config.
+		ClientCAs:  x509.NewCertPool(),
+		ClientAuth: tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			pool, err := source.GetCertPool()
+			if err != nil {
+				return err
+			}
+			return verifyPeerChain(rawCerts, pool, x509.ExtKeyUsageClientAuth)
+		},
+	}
+	DefaultTLSPolicy().Apply(listen)
+
+	dial := &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return source.GetCertificate()
+		},
+		// The server certificate is verified against the current pool
+		// by VerifyPeerCertificate.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			pool, err := source.GetCertPool()
+			if err != nil {
+				return err
+			}
+			return verifyPeerChain(rawCerts, pool, x509.ExtKeyUsageServerAuth)
+		},
+	}
+	DefaultTLSPolicy().Apply(dial)
+
+	return listen, dial
+}
+
+// Verify the certificate chain presented by a peer against the given pool.
+func verifyPeerChain(rawCerts [][]byte, pool *x509.CertPool, usage x509.ExtKeyUsage) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no certificate presented")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("parse certificate: %w", err)
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	return err
+}
+
+// FileCertificateSource returns a source loading the key pair and the
+// certificate authorities from the given PEM files, and reloading them when
+// they are modified. If caFile is empty, the certificate itself is trusted,
+// as when all nodes share the same self-signed certificate.
+//
+// If reloading fails, for example because the files are being replaced, the
+// previously loaded material is used.
+func FileCertificateSource(certFile, keyFile, caFile string) CertificateSource {
+	return &fileCertificateSource{certFile: certFile, keyFile: keyFile, caFile: caFile}
+}
+
+type fileCertificateSource struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	mu       sync.Mutex
+	modTime  time.Time // Latest modification time of the loaded files.
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+}
+
+func (s *fileCertificateSource) GetCertificate() (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s.cert, nil
+}
+
+func (s *fileCertificateSource) GetCertPool() (*x509.CertPool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s.pool, nil
+}
+
+// Load the files if they were never loaded or modified since.
+func (s *fileCertificateSource) load() error {
+	modTime, err := s.latestModTime()
+	if err != nil {
+		if s.cert != nil {
+			return nil
+		}
+		return err
+	}
+	if s.cert != nil && !modTime.After(s.modTime) {
+		return nil
+	}
+
+	certPEM, err := ioutil.ReadFile(s.certFile)
+	if err == nil {
+		var keyPEM, caPEM []byte
+		keyPEM, err = ioutil.ReadFile(s.keyFile)
+		if err == nil {
+			caPEM = certPEM
+			if s.caFile != "" {
+				caPEM, err = ioutil.ReadFile(s.caFile)
+			}
+		}
+		if err == nil {
+			err = s.parse(certPEM, keyPEM, caPEM)
+		}
+	}
+	if err != nil {
+		if s.cert != nil {
+			return nil
+		}
+		return err
+	}
+
+	s.modTime = modTime
+	return nil
+}
+
+func (s *fileCertificateSource) parse(certPEM, keyPEM, caPEM []byte) error {
+	cert, pool, err := parseCertificateMaterial(certPEM, keyPEM, caPEM)
+	if err != nil {
+		return err
+	}
+	s.cert = cert
+	s.pool = pool
+	return nil
+}
+
+// Return the latest modification time of the files of the source.
+func (s *fileCertificateSource) latestModTime() (time.Time, error) {
+	files := []string{s.certFile, s.keyFile}
+	if s.caFile != "" {
+		files = append(files, s.caFile)
+	}
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// EnvCertificateSource returns a source loading the key pair and the
+// certificate authorities from the PEM data held by the given environment
+// variables. If caVar is empty, the certificate itself is trusted.
+//
+// The variables are read only once.
+func EnvCertificateSource(certVar, keyVar, caVar string) (CertificateSource, error) {
+	certPEM := os.Getenv(certVar)
+	keyPEM := os.Getenv(keyVar)
+	caPEM := certPEM
+	if caVar != "" {
+		caPEM = os.Getenv(caVar)
+	}
+	cert, pool, err := parseCertificateMaterial([]byte(certPEM), []byte(keyPEM), []byte(caPEM))
+	if err != nil {
+		return nil, err
+	}
+	return staticCertificateSource{cert: cert, pool: pool}, nil
+}
+
+type staticCertificateSource struct {
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+func (s staticCertificateSource) GetCertificate() (*tls.Certificate, error) {
+	return s.cert, nil
+}
+
+func (s staticCertificateSource) GetCertPool() (*x509.CertPool, error) {
+	return s.pool, nil
+}
+
+// Parse a PEM-encoded key pair and certificate authorities.
+func parseCertificateMaterial(certPEM, keyPEM, caPEM []byte) (*tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load key pair: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, nil, fmt.Errorf("no certificate authority found")
+	}
+	return &cert, pool, nil
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/canonical/go-dqlite/notify"
+)
+
+// ChangeOp identifies the kind of a row-level change.
+type ChangeOp = notify.Op
+
+// Possible row-level change kinds.
+const (
+	ChangeInsert = notify.Insert
+	ChangeUpdate = notify.Update
+	ChangeDelete = notify.Delete
+)
+
+// Change holds information about a single committed row-level change.
+//
+// Its Seq field is the position of the change in the changelog of the
+// database, not the raft index of the transaction that made it: dqlite
+// doesn't expose which raft entry committed a given transaction. Since the
+// changelog is written by the transaction itself, Seq still follows commit
+// order and is the same on all nodes, which makes it usable as a cursor to
+// resume streaming after a restart.
+type Change = notify.Change
+
+// Interval between polls of the changelog table.
+const changesPollInterval = 100 * time.Millisecond
+
+// EnableChanges starts recording row-level changes to the given tables of
+// the given database, so they can be consumed with Changes().
+//
+// Changes are recorded by the triggers of the notify package into a changelog
+// table which is part of the database itself, so they are replicated and
+// committed atomically with the transaction that made them, and can be
+// consumed from any node, also with a notify.Listener. Tables created with
+// WITHOUT ROWID are not supported.
+//
+// It's safe to call this method multiple times.
+func (a *App) EnableChanges(ctx context.Context, database string, tables ...string) error {
+	db, err := a.Open(ctx, database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := notify.Install(ctx, db, tables...); err != nil {
+		return fmt.Errorf("enable changes: %w", err)
+	}
+	return nil
+}
+
+// Changes returns a channel streaming all changes committed to the given
+// database after the one with the given sequence number, in commit order.
+// Use 0 to receive all changes still present in the changelog.
+//
+// The changes are row-level, identified by their changelog sequence number
+// (see Change), and don't carry the raft commit index.
+//
+// Only changes to tables previously registered with EnableChanges() are
+// streamed. The channel is closed when the given context is done or when an
+// unrecoverable error occurs.
+func (a *App) Changes(ctx context.Context, database string, since int64) (<-chan Change, error) {
+	db, err := a.Open(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Change)
+	go func() {
+		defer close(ch)
+		defer db.Close()
+		for {
+			changes, err := notify.Since(ctx, db, since, -1)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				a.warn("query changes of %s: %v", database, err)
+			}
+			for _, change := range changes {
+				select {
+				case ch <- change:
+					since = change.Seq
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.clock.After(changesPollInterval):
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// PruneChanges deletes from the changelog of the given database all changes
+// with a sequence number lower than or equal to the given one.
+func (a *App) PruneChanges(ctx context.Context, database string, upTo int64) error {
+	db, err := a.Open(ctx, database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := notify.Prune(ctx, db, upTo); err != nil {
+		return fmt.Errorf("prune changelog: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/canonical/go-dqlite/internal/logging"
+	"github.com/canonical/go-dqlite/internal/tracing"
+)
+
+// NewClientOnly creates an application that doesn't run a local dqlite node,
+// but consumes an existing cluster, for example in stateless API servers.
+//
+// The given store must hold the addresses of some of the nodes of the
+// cluster. It's refreshed in the background with the current cluster
+// members, at the frequency set with WithRolesAdjustmentFrequency.
+//
+// As with New, a driver is registered and Open and Leader connect to the
+// cluster using the settings passed with options such as WithTLS, in which
+// case the "listen" configuration is not used and can be nil, or
+// WithAuthToken. Options about the local node, such as WithAddress or
+// WithVoters, are ignored. Since backups are dumped from the local node, so
+// is WithBackupSchedule. ID returns zero, Address returns an empty string and
+// Handover does nothing.
+func NewClientOnly(store client.NodeStore, options ...Option) (*App, error) {
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+
+	if o.LogRateLimit > 0 {
+		o.Log = logging.RateLimit(o.Log, o.LogRateLimit, o.Clock)
+	}
+
+	if err := setupTLS(o); err != nil {
+		return nil, err
+	}
+
+	if o.NodeIdentityBinding && o.TLS == nil {
+		return nil, fmt.Errorf("node identity binding set without TLS configuration")
+	}
+
+	if o.QuorumAlert != nil && o.QuorumTimeout <= 0 {
+		return nil, fmt.Errorf("invalid quorum alert timeout %s", o.QuorumTimeout)
+	}
+
+	dial := client.DefaultDialFunc
+	if o.Dial != nil {
+		dial = o.Dial
+	}
+
+	drv, driverName, err := registerDriver(o, store, dial)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+
+	app := &App{
+		store:           store,
+		driver:          drv,
+		driverName:      driverName,
+		log:             o.Log,
+		clock:           o.Clock,
+		metrics:         o.Metrics,
+		tracerProvider:  o.TracerProvider,
+		tracer:          tracing.Tracer(o.TracerProvider),
+		events:          newEventBus(),
+		proxyConns:      map[*proxyConn]struct{}{},
+		tls:             o.TLS,
+		authToken:       o.AuthToken,
+		bindIdentity:    o.NodeIdentityBinding,
+		migrations:      o.Migrations,
+		migrated:        map[string]bool{},
+		recorded:        map[string]bool{},
+		dial:            dial,
+		stop:            stop,
+		runCh:           make(chan struct{}, 0),
+		readyCh:         make(chan struct{}, 0),
+		leaderProbes:    o.LeaderProbes,
+		leadershipFunc:  o.OnLeadershipChange,
+		quorumTimeout:   o.QuorumTimeout,
+		quorumAlert:     o.QuorumAlert,
+		preferredLabels: o.PreferredLabels,
+	}
+	app.config = &Config{app: app}
+
+	if o.Backups != nil {
+		app.warn("ignore backup schedule without a local node")
+	}
+
+	if o.DebugAddress != "" {
+		if err := app.serveDebug(o.DebugAddress); err != nil {
+			stop()
+			driver.Unregister(driverName)
+			return nil, err
+		}
+	}
+
+	go app.refresh(ctx, o.RolesAdjustmentFrequency)
+
+	return app, nil
+}
+
+// Keep the node store of a client-only app up to date with the members of
+// the cluster. The app becomes ready after the first refresh.
+func (a *App) refresh(ctx context.Context, frequency time.Duration) {
+	defer close(a.runCh)
+
+	delay := time.Duration(0)
+	ready := false
+	for {
+		select {
+		case <-ctx.Done():
+			if !ready {
+				close(a.readyCh)
+			}
+			return
+		case <-a.clock.After(delay):
+			cli, err := a.findLeader(ctx)
+			if err != nil {
+				continue
+			}
+
+			servers, err := cli.Cluster(ctx)
+			if err != nil {
+				a.warn("refresh node store: %v", err)
+				delay = time.Second
+				cli.Close()
+				continue
+			}
+			a.store.Set(ctx, a.sortByPreference(ctx, servers))
+			a.observeCluster(ctx, cli, servers)
+			cli.Close()
+
+			if !ready {
+				ready = true
+				close(a.readyCh)
+			}
+			delay = frequency
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// Name of the internal database holding the replicated state of the App
+// itself, such as role preferences and cluster configuration.
+const internalDatabase = "_dqlite"
+
+// Name of the table holding the cluster configuration.
+const configTable = "_dqlite_config"
+
+// ErrNoConfigKey is returned by Config.Get when the key is not set.
+var ErrNoConfigKey = fmt.Errorf("no such configuration key")
+
+// ConfigChange holds a new value of a configuration key, as streamed by
+// Config.Watch.
+type ConfigChange struct {
+	Key      string
+	Value    string
+	Revision int64 // Increases with each change to any key.
+}
+
+// Config gives access to cluster-wide key/value settings, stored in a
+// reserved table of an internal database of the cluster.
+//
+// Reads and writes are served by the leader, so they are linearizable: a Get
+// observes the values of all the Sets that completed before it started, on
+// any node.
+type Config struct {
+	app *App
+	mu  sync.Mutex
+	db  *sql.DB // Opened on first use.
+}
+
+// Config returns the cluster-wide configuration settings.
+func (a *App) Config() *Config {
+	return a.config
+}
+
+// Get returns the value of the given key, or ErrNoConfigKey if it's not set.
+func (c *Config) Get(ctx context.Context, key string) (string, error) {
+	db, err := c.open(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	value := ""
+	query := fmt.Sprintf("SELECT value FROM %s WHERE key = ?", configTable)
+	if err := db.QueryRowContext(ctx, query, key).Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNoConfigKey
+		}
+		return "", fmt.Errorf("get %s: %w", key, err)
+	}
+
+	return value, nil
+}
+
+// Set the value of the given key.
+func (c *Config) Set(ctx context.Context, key, value string) error {
+	db, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf(
+		"INSERT OR REPLACE INTO %s(key, value, revision) VALUES(?, ?, (SELECT COALESCE(MAX(revision), 0) + 1 FROM %s))",
+		configTable, configTable)
+	if _, err := db.ExecContext(ctx, stmt, key, value); err != nil {
+		return fmt.Errorf("set %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Watch returns a channel streaming the changes to the keys starting with the
+// given prefix made after the call, in commit order. Use an empty prefix to
+// watch all keys.
+//
+// Changes are detected by polling, so a key changed more than once between
+// two polls is streamed only with its last value. The channel is closed when
+// the given context is done.
+func (c *Config) Watch(ctx context.Context, prefix string) (<-chan ConfigChange, error) {
+	if _, err := c.open(ctx); err != nil {
+		return nil, err
+	}
+
+	// Use a separate pool, which the goroutine closes when done.
+	db, err := c.app.Open(ctx, internalDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	since := int64(0)
+	query := fmt.Sprintf("SELECT COALESCE(MAX(revision), 0) FROM %s", configTable)
+	if err := db.QueryRowContext(ctx, query).Scan(&since); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("get current revision: %w", err)
+	}
+
+	ch := make(chan ConfigChange)
+	go func() {
+		defer close(ch)
+		defer db.Close()
+		for {
+			changes, err := queryConfigChanges(ctx, db, prefix, since)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				c.app.warn("query configuration changes: %v", err)
+			}
+			for _, change := range changes {
+				select {
+				case ch <- change:
+					since = change.Revision
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.app.clock.After(changesPollInterval):
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// Open the internal database and create the configuration table, unless it
+// was done already.
+func (c *Config) open(ctx context.Context) (*sql.DB, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.db != nil {
+		return c.db, nil
+	}
+
+	db, err := c.app.Open(ctx, internalDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+  key TEXT PRIMARY KEY,
+  value TEXT NOT NULL,
+  revision INTEGER NOT NULL
+)`, configTable)
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create configuration table: %w", err)
+	}
+
+	c.db = db
+
+	return db, nil
+}
+
+// Close the internal database, if it was opened.
+func (c *Config) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.db != nil {
+		c.db.Close()
+		c.db = nil
+	}
+}
+
+func queryConfigChanges(ctx context.Context, db *sql.DB, prefix string, since int64) ([]ConfigChange, error) {
+	query := fmt.Sprintf(
+		"SELECT key, value, revision FROM %s WHERE revision > ? AND substr(key, 1, length(?)) = ? ORDER BY revision",
+		configTable)
+	rows, err := db.QueryContext(ctx, query, since, prefix, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []ConfigChange{}
+	for rows.Next() {
+		change := ConfigChange{}
+		if err := rows.Scan(&change.Key, &change.Value, &change.Revision); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
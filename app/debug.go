@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Maximum time spent looking for the leader when serving the status page.
+const debugStatusTimeout = 5 * time.Second
+
+// DebugStatus is the cluster status served as JSON by the /status endpoint
+// of the debug listener set with WithDebugAddress.
+type DebugStatus struct {
+	ID        uint64      `json:"id"`
+	Address   string      `json:"address"`
+	Ready     bool        `json:"ready"`
+	LastIndex uint64      `json:"last_index,omitempty"` // See App.LastIndex.
+	Leader    *DebugNode  `json:"leader,omitempty"`
+	Nodes     []DebugNode `json:"nodes,omitempty"`
+	Error     string      `json:"error,omitempty"` // Set if the leader couldn't be reached.
+}
+
+// DebugNode describes a cluster member in a DebugStatus.
+type DebugNode struct {
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+	Role    string `json:"role,omitempty"`
+}
+
+// DebugAddress returns the address of the debug listener set with
+// WithDebugAddress, or an empty string if there's none. It's useful when
+// listening to port 0.
+func (a *App) DebugAddress() string {
+	if a.debugListener == nil {
+		return ""
+	}
+	return a.debugListener.Addr().String()
+}
+
+// Start serving the debug endpoints on the given address.
+func (a *App) serveDebug(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("listen to %s: %w", address, err)
+	}
+
+	gatherer := a.metrics.Gatherer()
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !a.ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), debugStatusTimeout)
+		defer cancel()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.debugStatus(ctx))
+	})
+
+	a.debugListener = listener
+	a.debugServer = &http.Server{Handler: mux}
+	a.debugCh = make(chan struct{}, 0)
+
+	go func() {
+		defer close(a.debugCh)
+		if err := a.debugServer.Serve(listener); err != http.ErrServerClosed {
+			a.warn("serve debug endpoints: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop serving the debug endpoints, if started.
+func (a *App) closeDebug() {
+	if a.debugServer == nil {
+		return
+	}
+	a.debugServer.Close()
+	<-a.debugCh
+}
+
+// Return true if the startup tasks completed successfully.
+func (a *App) ready() bool {
+	select {
+	case <-a.readyCh:
+		return a.joinErr == nil
+	default:
+		return false
+	}
+}
+
+// Return the status of this node and of the cluster, as seen by the leader.
+func (a *App) debugStatus(ctx context.Context) DebugStatus {
+	status := DebugStatus{ID: a.id, Address: a.address, Ready: a.ready()}
+	status.LastIndex, _ = a.LastIndex()
+
+	cli, err := a.Leader(ctx)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	defer cli.Close()
+
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	nodes, err := cli.Cluster(ctx)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	for _, node := range nodes {
+		debugNode := DebugNode{ID: node.ID, Address: node.Address, Role: node.Role.String()}
+		if node.ID == leader.ID {
+			status.Leader = &debugNode
+		}
+		status.Nodes = append(status.Nodes, debugNode)
+	}
+
+	return status
+}
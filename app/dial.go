@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"net"
+	"time"
 
 	"github.com/canonical/go-dqlite/client"
 	"github.com/pkg/errors"
@@ -11,31 +12,46 @@ import (
 
 // Like client.DialFuncWithTLS but also starts the proxy, since the raft
 // connect function only supports Unix and TCP connections.
-func makeNodeDialFunc(config *tls.Config) client.DialFunc {
-	dial := func(ctx context.Context, addr string) (net.Conn, error) {
-		clonedConfig := config.Clone()
-		if len(clonedConfig.ServerName) == 0 {
+//
+// The given dial function is used to establish the underlying network
+// connection. If config is nil, no TLS is used. If token is not empty, it's
+// presented to the remote node after the protocol handshake. If store is not
+// nil, the certificate of the remote node must match its ID, as recorded in
+// the store.
+func makeNodeDialFunc(dial client.DialFunc, config *tls.Config, token string, store client.NodeStore) client.DialFunc {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		var clonedConfig *tls.Config
+		if config != nil {
+			clonedConfig = config.Clone()
+			if len(clonedConfig.ServerName) == 0 {
 
-			remoteIP, _, err := net.SplitHostPort(addr)
-			if err != nil {
-				return nil, err
+				remoteIP, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				clonedConfig.ServerName = remoteIP
+			}
+			if store != nil {
+				bindNodeIdentity(clonedConfig, store, addr)
 			}
-			clonedConfig.ServerName = remoteIP
 		}
-		dialer := &net.Dialer{}
-		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		conn, err := dial(ctx, addr)
 		if err != nil {
 			return nil, err
 		}
 		goUnix, cUnix, err := socketpair()
 		if err != nil {
+			conn.Close()
 			return nil, errors.Wrap(err, "create pair of Unix sockets")
 		}
 
-		go proxy(context.Background(), conn, goUnix, clonedConfig)
+		var handshake func(remote, local net.Conn) error
+		if token != "" {
+			handshake = clientAuth(token)
+		}
+		tracker := &proxyConn{peer: addr, start: time.Now()}
+		go proxy(context.Background(), conn, goUnix, clonedConfig, false, tracker, handshake, nil)
 
 		return cUnix, nil
 	}
-
-	return dial
 }
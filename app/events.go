@@ -0,0 +1,229 @@
+package app
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// EventType identifies the kind of a cluster lifecycle event.
+type EventType int
+
+// Possible cluster lifecycle event kinds.
+const (
+	NodeJoined        EventType = 1 // A node was added to the cluster.
+	NodeRemoved       EventType = 2 // A node was removed from the cluster.
+	RoleChanged       EventType = 3 // The role of a node changed.
+	LeadershipChanged EventType = 4 // A different node became leader.
+	SnapshotTaken     EventType = 5 // The local node took a raft snapshot.
+	StoreRefreshed    EventType = 6 // The local node store was refreshed.
+)
+
+// String implements the Stringer interface.
+func (t EventType) String() string {
+	switch t {
+	case NodeJoined:
+		return "node-joined"
+	case NodeRemoved:
+		return "node-removed"
+	case RoleChanged:
+		return "role-changed"
+	case LeadershipChanged:
+		return "leadership-changed"
+	case SnapshotTaken:
+		return "snapshot-taken"
+	case StoreRefreshed:
+		return "store-refreshed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event holds information about a single cluster lifecycle event.
+type Event struct {
+	Type EventType // Kind of event.
+	Time time.Time // Time at which the event was observed.
+
+	// Node the event is about. For LeadershipChanged it's the new leader,
+	// for RoleChanged it holds the new role.
+	Node client.NodeInfo
+
+	// Role of the node before a RoleChanged event.
+	PreviousRole client.NodeRole
+
+	// Cluster members, set only for StoreRefreshed.
+	Nodes []client.NodeInfo
+
+	// Name of the snapshot file, set only for SnapshotTaken.
+	Snapshot string
+}
+
+// Subscribe returns a channel receiving the cluster lifecycle events observed
+// by this node, along with a function that must be called to stop receiving
+// them and release the channel.
+//
+// Events are detected by the same background task that refreshes the node
+// store, so they are delivered with a delay of up to the configured roles
+// adjustment frequency. Changes that happen and revert within that window
+// are not reported. Snapshots are detected by looking at the data
+// directory.
+//
+// Events are delivered without blocking: if the channel buffer, whose size
+// is given by the buffer parameter, is full the event is dropped for that
+// subscriber. The channel is closed when the App is closed.
+func (a *App) Subscribe(buffer int) (<-chan Event, func()) {
+	return a.events.subscribe(buffer)
+}
+
+// Dispatch cluster lifecycle events to subscribers, and keep the state
+// needed to detect them by comparing successive observations.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool                       // Whether close() was called
+	observed    bool                       // Whether observe() was called at least once
+	nodes       map[uint64]client.NodeInfo // Members seen by the last observation
+	leaderID    uint64                     // Leader seen by the last observation, or 0
+	snapshots   map[string]struct{}        // Snapshot files seen by the last observation
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: map[chan Event]struct{}{},
+		nodes:       map[uint64]client.NodeInfo{},
+		snapshots:   map[string]struct{}{},
+	}
+}
+
+func (b *eventBus) subscribe(buffer int) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, buffer)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+// Close the channels of all subscribers.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		close(ch)
+	}
+	b.subscribers = map[chan Event]struct{}{}
+	b.closed = true
+}
+
+// Compare the given observation of the cluster with the previous one and
+// notify subscribers about the differences. The first observation only
+// records the initial state, and no event other than StoreRefreshed is
+// emitted for it.
+func (b *eventBus) observe(now time.Time, nodes []client.NodeInfo, leaderID uint64, snapshots []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := []Event{}
+
+	current := make(map[uint64]client.NodeInfo, len(nodes))
+	for _, node := range nodes {
+		current[node.ID] = node
+	}
+
+	if b.observed {
+		for _, node := range nodes {
+			previous, ok := b.nodes[node.ID]
+			if !ok {
+				events = append(events, Event{Type: NodeJoined, Node: node})
+				continue
+			}
+			if previous.Role != node.Role {
+				events = append(events, Event{Type: RoleChanged, Node: node, PreviousRole: previous.Role})
+			}
+		}
+		removed := []client.NodeInfo{}
+		for id, node := range b.nodes {
+			if _, ok := current[id]; !ok {
+				removed = append(removed, node)
+			}
+		}
+		sort.Slice(removed, func(i, j int) bool { return removed[i].ID < removed[j].ID })
+		for _, node := range removed {
+			events = append(events, Event{Type: NodeRemoved, Node: node})
+		}
+		if leaderID != 0 && leaderID != b.leaderID {
+			leader, ok := current[leaderID]
+			if !ok {
+				leader = client.NodeInfo{ID: leaderID}
+			}
+			events = append(events, Event{Type: LeadershipChanged, Node: leader})
+		}
+	}
+
+	// A nil list means that snapshots could not be listed.
+	if snapshots != nil {
+		seen := make(map[string]struct{}, len(snapshots))
+		for _, snapshot := range snapshots {
+			seen[snapshot] = struct{}{}
+			if _, ok := b.snapshots[snapshot]; ok || !b.observed {
+				continue
+			}
+			events = append(events, Event{Type: SnapshotTaken, Snapshot: snapshot})
+		}
+		b.snapshots = seen
+	}
+
+	events = append(events, Event{Type: StoreRefreshed, Nodes: nodes})
+
+	b.observed = true
+	b.nodes = current
+	if leaderID != 0 {
+		b.leaderID = leaderID
+	}
+
+	for _, event := range events {
+		event.Time = now
+		for ch := range b.subscribers {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+}
+
+// Return the names of the snapshot files in the given data directory.
+func listSnapshots(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []string{}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, "snapshot-") || strings.HasSuffix(name, ".meta") {
+			continue
+		}
+		snapshots = append(snapshots, name)
+	}
+	return snapshots, nil
+}
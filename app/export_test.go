@@ -0,0 +1,10 @@
+package app
+
+import "github.com/canonical/go-dqlite/internal/clock"
+
+// WithClock sets the clock used to schedule background tasks and retries.
+func WithClock(c clock.Clock) Option {
+	return func(options *options) {
+		options.Clock = c
+	}
+}
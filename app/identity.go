@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// Scheme and host of the URIs identifying nodes in certificates.
+const (
+	nodeURIScheme = "dqlite"
+	nodeURIHost   = "node"
+)
+
+// NodeIdentityURI returns the URI identifying the node with the given ID, for
+// example "dqlite://node/1". Node certificates must carry it as URI subject
+// alternative name when WithNodeIdentityBinding is used.
+//
+// For example, a certificate for the node with ID 1 can be generated with:
+//
+//	openssl req -x509 -newkey rsa:4096 -sha256 -days 3650 \
+//	  -nodes -keyout node1.key -out node1.crt -subj "/CN=node1" \
+//	  -addext "subjectAltName=DNS:node1,URI:dqlite://node/1"
+func NodeIdentityURI(id uint64) *url.URL {
+	return &url.URL{
+		Scheme: nodeURIScheme,
+		Host:   nodeURIHost,
+		Path:   "/" + strconv.FormatUint(id, 10),
+	}
+}
+
+// NodeIDFromCertificate returns the node ID encoded in the URI subject
+// alternative names of the given certificate, as returned by
+// NodeIdentityURI. It returns false if there's no such URI, or if there's
+// more than one.
+func NodeIDFromCertificate(cert *x509.Certificate) (uint64, bool) {
+	found := false
+	var id uint64
+	for _, uri := range cert.URIs {
+		if uri.Scheme != nodeURIScheme || uri.Host != nodeURIHost || len(uri.Path) < 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(uri.Path[1:], 10, 64)
+		if err != nil || n == 0 {
+			continue
+		}
+		if found {
+			return 0, false
+		}
+		found = true
+		id = n
+	}
+	return id, found
+}
+
+// Return the certificate presented by the given config, either configured
+// statically or returned by its callbacks.
+func configCertificate(config *tls.Config) (*tls.Certificate, error) {
+	switch {
+	case len(config.Certificates) > 0:
+		return &config.Certificates[0], nil
+	case config.GetCertificate != nil:
+		return config.GetCertificate(&tls.ClientHelloInfo{})
+	case config.GetClientCertificate != nil:
+		return config.GetClientCertificate(&tls.CertificateRequestInfo{})
+	case config.GetConfigForClient != nil:
+		other, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			return nil, err
+		}
+		if other != nil {
+			return configCertificate(other)
+		}
+	}
+	return nil, fmt.Errorf("no certificate configured")
+}
+
+// Return the node ID in the first certificate of the given config.
+func configNodeID(config *tls.Config) (uint64, error) {
+	certificate, err := configCertificate(config)
+	if err != nil {
+		return 0, err
+	}
+	if certificate == nil || len(certificate.Certificate) == 0 {
+		return 0, fmt.Errorf("no certificate configured")
+	}
+	cert, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return 0, fmt.Errorf("parse certificate: %w", err)
+	}
+	id, ok := NodeIDFromCertificate(cert)
+	if !ok {
+		return 0, fmt.Errorf("certificate has no node identity URI")
+	}
+	return id, nil
+}
+
+// Return the ID of the local node according to its certificates, checking
+// that the listen and dial ones agree.
+func tlsNodeID(setup *tlsSetup) (uint64, error) {
+	listenID, err := configNodeID(setup.Listen)
+	if err != nil {
+		return 0, fmt.Errorf("listen config: %w", err)
+	}
+	dialID, err := configNodeID(setup.Dial)
+	if err != nil {
+		return 0, fmt.Errorf("dial config: %w", err)
+	}
+	if listenID != dialID {
+		return 0, fmt.Errorf("listen and dial certificates have different node IDs")
+	}
+	return listenID, nil
+}
+
+// Set the given config, which must be a copy, to check that the certificate
+// presented by the node at the given address carries the node ID recorded in
+// the given store for that address. If the address is not in the store, the
+// certificate must carry any node ID.
+func bindNodeIdentity(config *tls.Config, store client.NodeStore, addr string) {
+	verify := config.VerifyPeerCertificate
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if verify != nil {
+			if err := verify(rawCerts, chains); err != nil {
+				return err
+			}
+		}
+
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("node %s presented no certificate", addr)
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("parse certificate of node %s: %w", addr, err)
+		}
+		id, ok := NodeIDFromCertificate(cert)
+		if !ok {
+			return fmt.Errorf("certificate of node %s has no node identity URI", addr)
+		}
+
+		nodes, err := store.Get(context.Background())
+		if err != nil {
+			return fmt.Errorf("get nodes: %w", err)
+		}
+		for _, node := range nodes {
+			if node.Address == addr && node.ID != 0 && node.ID != id {
+				return fmt.Errorf("certificate of node %s has ID %d instead of %d", addr, id, node.ID)
+			}
+		}
+
+		return nil
+	}
+}
+
+// Like client.DialFuncWithTLS, but also checks that the certificate presented
+// by the remote node matches its ID, see bindNodeIdentity.
+func dialFuncWithNodeIdentity(dial client.DialFunc, config *tls.Config, store client.NodeStore) client.DialFunc {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		clonedConfig := config.Clone()
+		bindNodeIdentity(clonedConfig, store, addr)
+		return client.DialFuncWithTLS(dial, clonedConfig)(ctx, addr)
+	}
+}
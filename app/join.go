@@ -0,0 +1,65 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/protocol"
+)
+
+// ErrJoinRejected is returned by Ready when the leader rejected the request
+// of the node to join the cluster, for example because another node has the
+// same ID or address. Unlike when the cluster is unreachable, the node
+// doesn't retry joining.
+var ErrJoinRejected = fmt.Errorf("join rejected by the cluster")
+
+// Primary result code of the errors returned when the leader is lost or not
+// known, which are worth retrying.
+const errIoErr = 10
+
+// Ask the leader to add this node to the cluster, wrapping the error with
+// ErrJoinRejected if retrying is pointless.
+func (a *App) join(ctx context.Context, cli *client.Client) error {
+	info := client.NodeInfo{ID: a.id, Address: a.address, Role: client.Spare}
+	err := cli.Add(ctx, info)
+	if err == nil {
+		return nil
+	}
+
+	var failure protocol.ErrRequest
+	if !errors.As(err, &failure) || failure.Code&0xff == errIoErr {
+		return err
+	}
+
+	// A previous attempt might have succeeded without us getting the
+	// response, in which case the node is already there.
+	nodes, clusterErr := cli.Cluster(ctx)
+	if clusterErr == nil {
+		for _, node := range nodes {
+			if node.ID == a.id && node.Address == a.address {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%w: %v", ErrJoinRejected, err)
+}
+
+// Return the delay before retrying to join after the given number of failed
+// attempts. It doubles after each attempt up to the given cap, and is reduced
+// by a random amount of up to a half, so that nodes started together don't
+// retry in lockstep.
+func joinDelay(factor, cap time.Duration, attempts int) time.Duration {
+	delay := factor
+	for i := 1; i < attempts && delay < cap; i++ {
+		delay *= 2
+	}
+	if delay > cap {
+		delay = cap
+	}
+	return delay - time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// Name of the table of the internal database holding the labels of the
+// nodes.
+const labelsTable = "_dqlite_labels"
+
+// NodeMetadata holds information about a cluster member, along with the
+// labels it was started with.
+type NodeMetadata struct {
+	client.NodeInfo
+	Labels map[string]string
+}
+
+// Cluster returns the members of the cluster, along with their labels.
+func (a *App) Cluster(ctx context.Context) ([]NodeMetadata, error) {
+	cli, err := a.Leader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("find leader: %w", err)
+	}
+	defer cli.Close()
+
+	nodes, err := cli.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cluster servers: %w", err)
+	}
+
+	labels, err := a.nodeLabels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get labels: %w", err)
+	}
+
+	cluster := make([]NodeMetadata, len(nodes))
+	for i, node := range nodes {
+		cluster[i] = NodeMetadata{NodeInfo: node, Labels: labels[node.ID]}
+	}
+
+	return cluster, nil
+}
+
+// Record the labels of this node in the cluster, replacing the ones it had
+// before.
+func (a *App) recordLabels(ctx context.Context) error {
+	db, err := a.Open(ctx, internalDatabase)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stmt := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (id INTEGER, key TEXT, value TEXT NOT NULL, PRIMARY KEY (id, key))",
+		labelsTable)
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	stmt = fmt.Sprintf("DELETE FROM %s WHERE id = ?", labelsTable)
+	if _, err := tx.ExecContext(ctx, stmt, int64(a.id)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	stmt = fmt.Sprintf("INSERT INTO %s(id, key, value) VALUES(?, ?, ?)", labelsTable)
+	for key, value := range a.labels {
+		if _, err := tx.ExecContext(ctx, stmt, int64(a.id), key, value); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Return the labels of the nodes, by node ID.
+func (a *App) nodeLabels(ctx context.Context) (map[uint64]map[string]string, error) {
+	db, err := a.Open(ctx, internalDatabase)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	labels := map[uint64]map[string]string{}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT id, key, value FROM %s", labelsTable))
+	if err != nil {
+		// No node was ever started with labels.
+		if strings.Contains(err.Error(), "no such table") {
+			return labels, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var key, value string
+		if err := rows.Scan(&id, &key, &value); err != nil {
+			return nil, err
+		}
+		if labels[uint64(id)] == nil {
+			labels[uint64(id)] = map[string]string{}
+		}
+		labels[uint64(id)][key] = value
+	}
+
+	return labels, rows.Err()
+}
+
+// Return true if the given labels include all the ones of the selector.
+func matchLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the given nodes, with the ones matching the preferred labels first,
+// so that they are tried first when looking for the leader.
+func (a *App) sortByPreference(ctx context.Context, nodes []client.NodeInfo) []client.NodeInfo {
+	if len(a.preferredLabels) == 0 {
+		return nodes
+	}
+
+	labels, err := a.nodeLabels(ctx)
+	if err != nil {
+		a.warn("get labels: %v", err)
+		return nodes
+	}
+
+	sorted := make([]client.NodeInfo, len(nodes))
+	copy(sorted, nodes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return matchLabels(labels[sorted[i].ID], a.preferredLabels) && !matchLabels(labels[sorted[j].ID], a.preferredLabels)
+	})
+
+	return sorted
+}
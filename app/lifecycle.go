@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Run waits for the node to complete its initial tasks, as Ready does, and
+// then blocks until the given context is canceled or the node fails for
+// good, for example because the cluster rejected it. Before returning it
+// hands over the node's responsibilities to other nodes, as Handover does,
+// and closes the node, so Close must not be called afterwards.
+//
+// Run returns nil if the context was canceled, making it suitable as the
+// execute function of lifecycle managers such as oklog/run groups, with a
+// context cancel function as their interrupt function.
+func (a *App) Run(ctx context.Context) error {
+	// Fatal errors, such as the cluster rejecting the node, can only
+	// happen while performing the initial tasks.
+	err := a.Ready(ctx)
+	if err == nil {
+		<-ctx.Done()
+
+		// Only a ready node has responsibilities to hand over. If a
+		// close timeout was set, Close does it.
+		if a.closeTimeout == 0 {
+			if err := a.Handover(context.Background()); err != nil {
+				a.warn("handover: %v", err)
+			}
+		}
+	} else if err == ctx.Err() {
+		err = nil
+	}
+
+	if closeErr := a.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// SignalContext returns a copy of the given context which is canceled when
+// one of the given signals is received, or when the returned cancel function
+// is called, whichever happens first. If no signal is given, os.Interrupt and
+// SIGTERM are used.
+//
+// Paired with App.Run, it makes a node shutdown gracefully when the process
+// is asked to terminate.
+func SignalContext(parent context.Context, signals ...os.Signal) (context.Context, context.CancelFunc) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+
+	go func() {
+		select {
+		case <-ch:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+)
+
+// Network listener whose connections are proxied to the local node.
+type proxyListener struct {
+	net.Listener
+	tls *tls.Config // Used to accept connections, if set.
+}
+
+// Listen on the node address, unless a listener for it is given, and on the
+// extra addresses set with WithListenAddress. The given TLS configuration is
+// used for the listeners that don't have their own.
+func listenAll(address string, listener net.Listener, config *tls.Config, extra []listenSetup) ([]proxyListener, error) {
+	listeners := make([]proxyListener, 0, len(extra)+1)
+
+	setups := extra
+	if listener != nil {
+		listeners = append(listeners, proxyListener{Listener: listener, tls: config})
+	} else {
+		setups = append([]listenSetup{{Address: address}}, extra...)
+	}
+
+	for _, setup := range setups {
+		listener, err := net.Listen("tcp", setup.Address)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, fmt.Errorf("listen to %s: %w", setup.Address, err)
+		}
+		listenConfig := setup.TLS
+		if listenConfig == nil {
+			listenConfig = config
+		}
+		listeners = append(listeners, proxyListener{Listener: listener, tls: listenConfig})
+	}
+
+	return listeners, nil
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+type localSnapshotSetup struct {
+	Dir       string
+	Interval  time.Duration
+	Databases []string
+}
+
+// Periodically take a local snapshot of the configured databases.
+func (a *App) takeLocalSnapshots(ctx context.Context) {
+	defer close(a.localSnapshotCh)
+
+	for {
+		for _, database := range a.localSnapshots.Databases {
+			if err := a.takeLocalSnapshot(ctx, database); err != nil {
+				a.warn("take local snapshot of %s: %v", database, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.clock.After(a.localSnapshots.Interval):
+		}
+	}
+}
+
+// Dump the whole given database from the local node and atomically replace
+// its snapshot file.
+func (a *App) takeLocalSnapshot(ctx context.Context, database string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.localSnapshots.Interval)
+	defer cancel()
+
+	cli, err := client.New(ctx, a.nodeBindAddress, client.WithLogFunc(a.log))
+	if err != nil {
+		return fmt.Errorf("connect to local node: %w", err)
+	}
+	defer cli.Close()
+
+	image, err := dumpImage(ctx, cli, database)
+	if err != nil {
+		return err
+	}
+
+	return writeImage(a.localSnapshots.Dir, database, image)
+}
+
+// Write the given database image in the given directory, replacing any
+// previous version atomically.
+func writeImage(dir, database string, image []byte) error {
+	tmp, err := ioutil.TempFile(dir, "."+database+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(image); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, database))
+}
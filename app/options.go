@@ -8,6 +8,11 @@ import (
 	"time"
 
 	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/canonical/go-dqlite/internal/clock"
+	"github.com/canonical/go-dqlite/metrics"
+	"github.com/canonical/go-dqlite/migrate"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Option can be used to tweak app parameters.
@@ -43,6 +48,24 @@ func WithCluster(cluster []string) Option {
 	}
 }
 
+// WithInitialCluster can be used to bootstrap a brand new cluster with all its
+// members declared up front, as an alternative to starting a single bootstrap
+// node and then having the other nodes join it one by one with WithCluster.
+//
+// Every node of the new cluster must be started for the first time with the
+// same list of nodes, each with its own ID, address and role. The address of
+// the node being started must appear in the list (see WithAddress). Nodes
+// with the Voter role will elect a leader among themselves as soon as a
+// majority of them is up.
+//
+// This option is ignored if the node was already initialized, and can't be
+// used together with WithCluster.
+func WithInitialCluster(nodes []client.NodeInfo) Option {
+	return func(options *options) {
+		options.InitialCluster = nodes
+	}
+}
+
 // WithTLS enables TLS encryption of network traffic.
 //
 // The "listen" parameter must hold the TLS configuration to use when accepting
@@ -50,6 +73,13 @@ func WithCluster(cluster []string) Option {
 //
 // The "dial" parameter must hold the TLS configuration to use when
 // establishing outgoing connections to other application nodes.
+//
+// Callbacks such as GetConfigForClient or GetCertificate in the listen
+// configuration, and VerifyConnection or GetClientCertificate in the dial
+// one, are honored, so certificates can be selected per SNI or fetched from
+// an identity provider such as SPIFFE. Options that change the
+// configurations, like WithTLSPolicy or WithPeerVerifier, also apply to the
+// configurations returned by GetConfigForClient.
 func WithTLS(listen *tls.Config, dial *tls.Config) Option {
 	return func(options *options) {
 		options.TLS = &tlsSetup{
@@ -59,6 +89,174 @@ func WithTLS(listen *tls.Config, dial *tls.Config) Option {
 	}
 }
 
+// WithTLSPolicy sets the minimum TLS version, cipher suites and elliptic
+// curves allowed for the connections of the node, overriding the ones of the
+// configs passed to WithTLS. Zero-valued fields of the policy are set to the
+// values of DefaultTLSPolicy.
+//
+// It requires WithTLS, and App creation fails if the policy allows TLS
+// versions older than 1.2 or insecure cipher suites.
+func WithTLSPolicy(policy TLSPolicy) Option {
+	return func(options *options) {
+		options.TLSPolicy = &policy
+	}
+}
+
+// WithPeerVerifier sets a function performing additional checks on the
+// certificates presented by clients and other nodes, on both incoming and
+// outgoing connections, for example to reject revoked certificates with
+// client.FileRevocationVerifier. It requires WithTLS.
+func WithPeerVerifier(verify client.PeerVerifier) Option {
+	return func(options *options) {
+		options.PeerVerifier = verify
+	}
+}
+
+// WithNodeIdentityBinding pins the ID of each node to its TLS certificate,
+// which must carry the URI returned by NodeIdentityURI as subject alternative
+// name. It requires WithTLS, with a distinct certificate for each node.
+//
+// When enabled, the certificate of this node must match its ID: a node
+// joining a cluster takes the ID from its certificate, while the first node
+// of the cluster needs a certificate for dqlite.BootstrapID. Raft connections
+// from other nodes must present a certificate matching the ID they announce,
+// and outgoing connections are accepted only if the certificate of the remote
+// node matches the ID recorded for its address in the node store. Requests to
+// add, assign, remove or transfer nodes are accepted only from clients with a
+// node certificate, and nodes can only add themselves, so a stolen
+// certificate can't be used to impersonate another node.
+func WithNodeIdentityBinding() Option {
+	return func(options *options) {
+		options.NodeIdentityBinding = true
+	}
+}
+
+// WithDebugAddress makes the app serve debugging and observability endpoints
+// over plain HTTP on the given address:
+//
+//	/healthz  always returns 200, as long as the process is serving
+//	/readyz   returns 200 once Ready would return nil, 503 before
+//	/metrics  the Prometheus metrics, see below
+//	/status   a JSON DebugStatus with this node and the cluster members
+//
+// The metrics are the ones of the registry passed to metrics.New along with
+// WithMetrics, or the ones of the default Prometheus registry. The endpoints
+// have no authentication, so the address should not be reachable from
+// untrusted networks.
+func WithDebugAddress(address string) Option {
+	return func(options *options) {
+		options.DebugAddress = address
+	}
+}
+
+// WithLocalSocket makes the node also accept connections on the unix socket
+// at the given filesystem path, which clients on the same host can use by
+// passing the path as node address.
+//
+// Connections on the local socket use neither TLS nor authentication tokens.
+// Instead, the kernel reports the user and group of the connecting process,
+// which must be listed in the given access list. If an AuthorizeFunc is set,
+// local clients are identified as "uid:<user ID>".
+//
+// The socket file is created according to the process umask, so its
+// permissions might need to be adjusted to let the allowed users connect.
+func WithLocalSocket(path string, access LocalSocketAccess) Option {
+	return func(options *options) {
+		options.LocalSocket = path
+		options.LocalSocketAccess = access
+	}
+}
+
+// WithListenAddress makes the node also accept connections on the given
+// address, for example on the IPv6 address of a dual-stack host or on another
+// network interface. It can be used more than once.
+//
+// The address set with WithAddress remains the canonical one, which is
+// advertised to other nodes and stored in the cluster configuration.
+//
+// The "listen" parameter holds the TLS configuration to use for the
+// connections accepted on this address. If nil, the one passed to WithTLS is
+// used, if any.
+func WithListenAddress(address string, listen *tls.Config) Option {
+	return func(options *options) {
+		options.ListenAddresses = append(options.ListenAddresses, listenSetup{
+			Address: address,
+			TLS:     listen,
+		})
+	}
+}
+
+// WithListener makes the node accept connections for its address on the
+// given listener, instead of creating one itself. For example, it can be one
+// of the listeners returned by SystemdListeners, so that socket-activated
+// services can bind to privileged ports without running as root.
+//
+// The listener is closed when the node is closed.
+func WithListener(listener net.Listener) Option {
+	return func(options *options) {
+		options.Listener = listener
+	}
+}
+
+// WithJoinBackoff sets the delay before a brand new node retries to join the
+// cluster after a failed attempt, which doubles after each attempt up to the
+// given cap. Delays are randomly reduced by up to a half, so that nodes
+// started together don't retry in lockstep. The defaults are one second and
+// one minute.
+//
+// Retrying stops if the cluster explicitly rejects the node, see
+// ErrJoinRejected.
+func WithJoinBackoff(factor, cap time.Duration) Option {
+	return func(options *options) {
+		options.JoinBackoffFactor = factor
+		options.JoinBackoffCap = cap
+	}
+}
+
+// WithLabels attaches the given labels to the node, for example its region,
+// tier or hardware class. They are recorded in the cluster when the node
+// starts, replacing the ones it had before, and are returned by
+// App.Cluster.
+func WithLabels(labels map[string]string) Option {
+	return func(options *options) {
+		options.Labels = labels
+	}
+}
+
+// WithVoterSelector makes only the nodes having all the given labels eligible
+// for the Voter role. Other nodes can still be stand-bys.
+//
+// Roles are assigned by the leader, so all nodes should be started with the
+// same selector.
+func WithVoterSelector(selector map[string]string) Option {
+	return func(options *options) {
+		options.VoterSelector = selector
+	}
+}
+
+// WithPreferredLabels makes the node store list the nodes having all the
+// given labels first, so that they are contacted first when looking for the
+// leader, for example the ones in the same region.
+func WithPreferredLabels(selector map[string]string) Option {
+	return func(options *options) {
+		options.PreferredLabels = selector
+	}
+}
+
+// WithStoreRepair makes a node that already started once rebuild its
+// cluster.yaml node store if it's missing or can't be parsed, instead of
+// failing, and add to it the nodes it lacks.
+//
+// The nodes are taken from the most recent cluster configuration found in
+// the raft data of the node, and from the addresses passed with WithCluster,
+// which lets a node whose store only lists dead nodes reach a live peer. An
+// unparsable store is kept with the ".corrupted" suffix.
+func WithStoreRepair() Option {
+	return func(options *options) {
+		options.StoreRepair = true
+	}
+}
+
 // WithVoters sets the number of nodes in the cluster that should have the
 // Voter role.
 //
@@ -105,6 +303,58 @@ func WithStandBys(n int) Option {
 	}
 }
 
+// WithNonVoting marks this node as never to be a voter, regardless of the
+// size of the cluster: it can only have the StandBy or Spare role. This is
+// meant for nodes that should never take part in quorum, such as edge nodes,
+// read replicas or nodes in a far-away region.
+//
+// The preference is recorded in an internal database of the cluster when the
+// node starts, so that whatever node is the leader doesn't promote it to
+// voter, and replaces it if it's a voter already. Once recorded, the
+// preference is kept by the cluster, even if the node is restarted without
+// this option.
+func WithNonVoting() Option {
+	return func(options *options) {
+		options.NonVoting = true
+	}
+}
+
+// WithOnLeadershipChange sets a function to call when this node gains or
+// loses leadership, or when the observed leader changes, so that leader-only
+// background jobs can be started and stopped without polling App.Leader.
+//
+// The function is first called with the leader seen by the first observation
+// of the cluster, and then each time a different leader is observed. The
+// leader is the zero NodeInfo if it's unknown. If this node is the leader
+// when the App is closed, the function is called one last time with isLeader
+// set to false.
+//
+// Leadership is observed by the same background task that refreshes the node
+// store, so changes are detected with a delay of up to the configured roles
+// adjustment frequency. The function is called from that task, so it should
+// not block.
+func WithOnLeadershipChange(f func(isLeader bool, leader client.NodeInfo)) Option {
+	return func(options *options) {
+		options.OnLeadershipChange = f
+	}
+}
+
+// WithQuorumAlert makes the background task of the App detect when the
+// cluster can't commit anymore, and call the given function with diagnostic
+// details, instead of silently retrying.
+//
+// The quorum is considered lost when no leader can be found within the given
+// timeout, since raft leaders step down when they can't reach a majority of
+// voters. The function is called once when that happens, and once more with
+// Lost set to false when a leader is found again. It's called from the
+// background task, so it should not block.
+func WithQuorumAlert(timeout time.Duration, f func(QuorumAlert)) Option {
+	return func(options *options) {
+		options.QuorumTimeout = timeout
+		options.QuorumAlert = f
+	}
+}
+
 // WithRolesAdjustmentFrequency sets the frequency at which the current cluster
 // leader will check if the roles of the various nodes in the cluster matches
 // the desired setup and perform promotions/demotions to adjust the situation
@@ -117,6 +367,120 @@ func WithRolesAdjustmentFrequency(frequency time.Duration) Option {
 	}
 }
 
+// WithRolesAdjustment sets at once the number of voters and stand-bys that
+// the cluster should have, as WithVoters and WithStandBys do, and the
+// frequency at which the leader checks them, as WithRolesAdjustmentFrequency
+// does.
+//
+// The leader keeps maintaining these targets for as long as it runs:
+// whenever a voter or stand-by goes offline or leaves, the best online
+// candidate is promoted in its place, and nodes are demoted when there are
+// more voters or stand-bys than needed. Use App.AdjustRoles to trigger a
+// check right away after changing the membership.
+func WithRolesAdjustment(voters, standbys int, frequency time.Duration) Option {
+	return func(options *options) {
+		options.Voters = voters
+		options.StandBys = standbys
+		options.RolesAdjustmentFrequency = frequency
+	}
+}
+
+// WithCloseTimeout makes App.Close hand over the responsibilities of the node
+// before closing it, as App.Handover does, giving up after the given timeout.
+//
+// This way a node is shutdown gracefully even if the code closing it doesn't
+// call Handover first. By default Close doesn't hand over.
+func WithCloseTimeout(timeout time.Duration) Option {
+	return func(options *options) {
+		options.CloseTimeout = timeout
+	}
+}
+
+// WithSnapshotCompression enables or disables compression of the raft
+// snapshots taken by the local dqlite node.
+//
+// Compressed snapshots take less space in the data directory and are faster
+// to transfer to new members that need to catch up, at the cost of some extra
+// CPU time when snapshots are taken or installed.
+//
+// If not used, the default of the underlying dqlite engine applies.
+func WithSnapshotCompression(enabled bool) Option {
+	return func(options *options) {
+		options.SnapshotCompression = &enabled
+	}
+}
+
+// WithWorkerThreads sets the number of threads the local dqlite node uses for
+// disk I/O and other blocking work. See dqlite.WithWorkerThreads.
+func WithWorkerThreads(n int) Option {
+	return func(options *options) {
+		options.WorkerThreads = n
+	}
+}
+
+// WithBlockSize sets the size of the blocks the local dqlite node uses to
+// write the raft log. See dqlite.WithBlockSize.
+func WithBlockSize(size uint64) Option {
+	return func(options *options) {
+		options.BlockSize = size
+	}
+}
+
+// WithLocalSnapshots makes the application node periodically write a plain
+// SQLite file for each of the given databases in the given directory, which
+// read-only local consumers (reporting tools, the sqlite3 CLI, etc) can open
+// directly without going through the dqlite protocol.
+//
+// The files are full snapshots, not incrementally updated replicas: at the
+// given interval each database is dumped from the local dqlite node as a
+// whole, so the cost of a refresh grows with the size of the database, and
+// the files might lag behind the cluster by up to the interval. Each
+// snapshot atomically replaces the previous file, so readers should re-open
+// it to observe new data.
+func WithLocalSnapshots(dir string, interval time.Duration, databases ...string) Option {
+	return func(options *options) {
+		options.LocalSnapshots = &localSnapshotSetup{
+			Dir:       dir,
+			Interval:  interval,
+			Databases: databases,
+		}
+	}
+}
+
+// WithBackupSchedule makes the application node write a backup of every
+// database of the cluster in the given directory at the given interval, while
+// it's the leader.
+//
+// The databases are the ones included by BackupAll. Each one is dumped from
+// the node as a standalone SQLite file named after the database, atomically
+// replacing the previous backup, so it can be copied elsewhere or opened with
+// any SQLite tool at any time. Since backups are only written by the leader,
+// the directory of a node stops being refreshed when it loses leadership,
+// and the most recent backups are the ones of the current leader.
+//
+// Client-only applications have no local node to dump databases from, and
+// ignore this option.
+func WithBackupSchedule(interval time.Duration, dir string) Option {
+	return func(options *options) {
+		options.Backups = &backupSchedule{
+			Interval: interval,
+			Dir:      dir,
+		}
+	}
+}
+
+// WithDialFunc sets a custom dial function for establishing the network
+// connections to other nodes, both for replication and for client requests.
+//
+// The returned connections don't need to be TCP connections, which makes it
+// possible to wrap them, for example to inject faults in tests. If TLS is
+// enabled, encryption is layered on top of the returned connections.
+func WithDialFunc(dial client.DialFunc) Option {
+	return func(options *options) {
+		options.Dial = dial
+	}
+}
+
 // WithLogFunc sets a custom log function.
 func WithLogFunc(log client.LogFunc) Option {
 	return func(options *options) {
@@ -124,19 +488,273 @@ func WithLogFunc(log client.LogFunc) Option {
 	}
 }
 
+// WithMetrics sets the collectors used to record the raft state observed by
+// this node, the latency of requests and the number of queries performed by
+// its clients and driver, and the connections it proxies.
+//
+// Raft state is refreshed at the frequency set by WithRolesAdjustmentFrequency.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(options *options) {
+		options.Metrics = m
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry provider used to create spans for
+// the requests performed by this node's clients and driver, and for its
+// internal tasks like roles adjustment and handover.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(options *options) {
+		options.TracerProvider = provider
+	}
+}
+
+// WithSlowQueryThreshold enables logging of any statement executed through
+// the app's driver whose execution takes at least the given duration.
+func WithSlowQueryThreshold(threshold time.Duration) Option {
+	return func(options *options) {
+		options.SlowQueryThreshold = threshold
+	}
+}
+
+// WithSlowQueryRedaction sets whether literals should be redacted from the
+// SQL text of logged slow queries.
+func WithSlowQueryRedaction(enabled bool) Option {
+	return func(options *options) {
+		options.SlowQueryRedaction = enabled
+	}
+}
+
+// WithSlowQueryExplain sets whether the plan of logged slow queries should be
+// captured on a follower and logged as well.
+func WithSlowQueryExplain(enabled bool) Option {
+	return func(options *options) {
+		options.SlowQueryExplain = enabled
+	}
+}
+
+// WithAuditSink sets a sink receiving a record for every DDL or write
+// statement executed through the app's driver. See driver.WithAuditSink.
+func WithAuditSink(sink driver.AuditSink) Option {
+	return func(options *options) {
+		options.AuditSink = sink
+	}
+}
+
+// WithQueryStats enables the aggregation of per-statement statistics for the
+// statements executed through the app's driver, which can be retrieved with
+// App.QueryStats.
+func WithQueryStats(enabled bool) Option {
+	return func(options *options) {
+		options.QueryStats = enabled
+	}
+}
+
+// WithProxyAccessLog sets a function called every time a client connection
+// proxied to the local node is closed, with its peer address, duration,
+// traffic and TLS cipher suite. It's called synchronously from the goroutine
+// serving the connection, so it should not block.
+//
+// Connections are proxied only when using WithTLS.
+func WithProxyAccessLog(log func(ProxyConnection)) Option {
+	return func(options *options) {
+		options.ProxyAccessLog = log
+	}
+}
+
+// WithProfilerLabels sets whether statements executed through the app's
+// driver should carry pprof labels. See driver.WithProfilerLabels.
+func WithProfilerLabels(enabled bool) Option {
+	return func(options *options) {
+		options.ProfilerLabels = enabled
+	}
+}
+
+// WithSharedConnections makes the connections of the pools returned by
+// App.Open share at most n physical connections to the leader for each
+// database. See driver.WithSharedConnections.
+func WithSharedConnections(n int) Option {
+	return func(options *options) {
+		options.SharedConnections = n
+	}
+}
+
+// WithStatementRegistry keeps up to the given number of prepared statements
+// open on each physical connection of the pools returned by App.Open, so that
+// statements with the same SQL text are prepared once. See
+// driver.WithStatementRegistry.
+func WithStatementRegistry(size int) Option {
+	return func(options *options) {
+		options.StatementRegistry = size
+	}
+}
+
+// WithLeaderProbes sets how many nodes are probed concurrently when looking
+// for the leader, both by App.Leader and by the connections of the pools
+// returned by App.Open. See client.WithLeaderProbes.
+func WithLeaderProbes(n int) Option {
+	return func(options *options) {
+		options.LeaderProbes = n
+	}
+}
+
+// WithBufferSizes sets the initial and maximum sizes of the message buffers
+// of the connections of the pools returned by App.Open. See
+// driver.WithBufferSizes.
+func WithBufferSizes(initial, max int) Option {
+	return func(options *options) {
+		options.BufferSize = initial
+		options.MaxBufferSize = max
+	}
+}
+
+// WithChunkedParameters makes the connections of the pools returned by
+// App.Open send blob and text values larger than the given size in chunks,
+// which the proxy of the node assembles. See driver.WithChunkedParameters.
+//
+// It also starts the proxy if no other option does, so all the nodes of the
+// cluster must be configured with it.
+func WithChunkedParameters(size int) Option {
+	return func(options *options) {
+		options.ChunkSize = size
+	}
+}
+
+// WithLogRateLimit sets an interval during which repetitive log messages are
+// suppressed, such as the warnings emitted every second while the cluster has
+// no leader. Suppressed messages are reported with a periodic summary. See
+// client.RateLimitLogFunc.
+//
+// If not used, or set to zero, all messages are emitted.
+func WithLogRateLimit(interval time.Duration) Option {
+	return func(options *options) {
+		options.LogRateLimit = interval
+	}
+}
+
+// WithAuthToken sets a pre-shared token that clients must present right after
+// the protocol handshake, before the proxy forwards their connection to the
+// local node. The token is also presented by this node when connecting to
+// other nodes, and by its own clients and driver, so all nodes of the cluster
+// must be configured with the same token.
+//
+// Clients pass the token with the WithAuthToken options of the client and
+// driver packages. Unless TLS is also used the token is sent in clear text.
+func WithAuthToken(token string) Option {
+	return func(options *options) {
+		options.AuthToken = token
+	}
+}
+
+// WithAuthenticator sets a custom function to validate the tokens presented
+// by clients, for example to verify signed claims. It takes precedence over
+// the pre-shared token set with WithAuthToken for incoming connections, but
+// WithAuthToken should still be used to set the token that this node presents
+// to other nodes, which the function must accept.
+func WithAuthenticator(auth AuthFunc) Option {
+	return func(options *options) {
+		options.Authenticator = auth
+	}
+}
+
+// WithAuthorizer sets a function deciding which databases the clients of the
+// App proxy can access, and whether they can write to them. Requests that are
+// not authorized fail with SQLITE_AUTH, without closing the connection.
+//
+// Clients are identified by the identity returned by the AuthFunc or, if
+// authentication is not enabled, by the common name of their TLS
+// certificate. Other nodes go through the same checks, so the function must
+// grant them PermissionWrite on the empty database name, which is used for
+// cluster management requests, to let roles be adjusted.
+//
+// Read-only access is enforced by rejecting SQL text containing keywords that
+// might modify the database, such as INSERT or PRAGMA. Since the proxy can't
+// tell which database a statement targets, connections that opened several
+// databases get the most restrictive of their permissions.
+func WithAuthorizer(authorize AuthorizeFunc) Option {
+	return func(options *options) {
+		options.Authorizer = authorize
+	}
+}
+
+// WithMigrations sets schema migrations to apply to the database with the
+// given name, the first time App.Open is called for it. Migrations are applied
+// exactly once across the cluster, see the migrate package.
+//
+// This option can be passed several times, for different databases.
+func WithMigrations(database string, migrations []migrate.Migration) Option {
+	return func(options *options) {
+		if options.Migrations == nil {
+			options.Migrations = map[string][]migrate.Migration{}
+		}
+		options.Migrations[database] = migrations
+	}
+}
+
 type tlsSetup struct {
 	Listen *tls.Config
 	Dial   *tls.Config
 }
 
+type listenSetup struct {
+	Address string
+	TLS     *tls.Config
+}
+
 type options struct {
 	Address                  string
 	Cluster                  []string
+	InitialCluster           []client.NodeInfo
 	Log                      client.LogFunc
+	Dial                     client.DialFunc
 	TLS                      *tlsSetup
+	TLSPolicy                *TLSPolicy
+	NodeIdentityBinding      bool
+	PeerVerifier             client.PeerVerifier
+	LocalSocket              string
+	LocalSocketAccess        LocalSocketAccess
+	DebugAddress             string
+	StoreRepair              bool
+	ListenAddresses          []listenSetup
+	Listener                 net.Listener
 	Voters                   int
 	StandBys                 int
+	NonVoting                bool
+	Labels                   map[string]string
+	VoterSelector            map[string]string
+	PreferredLabels          map[string]string
+	OnLeadershipChange       func(bool, client.NodeInfo)
+	QuorumTimeout            time.Duration
+	QuorumAlert              func(QuorumAlert)
 	RolesAdjustmentFrequency time.Duration
+	JoinBackoffFactor        time.Duration
+	JoinBackoffCap           time.Duration
+	CloseTimeout             time.Duration
+	SnapshotCompression      *bool
+	WorkerThreads            int
+	BlockSize                uint64
+	LocalSnapshots           *localSnapshotSetup
+	Backups                  *backupSchedule
+	Clock                    clock.Clock
+	Metrics                  *metrics.Metrics
+	TracerProvider           trace.TracerProvider
+	SlowQueryThreshold       time.Duration
+	SlowQueryRedaction       bool
+	SlowQueryExplain         bool
+	AuditSink                driver.AuditSink
+	QueryStats               bool
+	ProxyAccessLog           func(ProxyConnection)
+	ProfilerLabels           bool
+	SharedConnections        int
+	StatementRegistry        int
+	LeaderProbes             int
+	BufferSize               int
+	MaxBufferSize            int
+	ChunkSize                int
+	LogRateLimit             time.Duration
+	AuthToken                string
+	Authenticator            AuthFunc
+	Authorizer               AuthorizeFunc
+	Migrations               map[string][]migrate.Migration
 }
 
 // Create a options object with sane defaults.
@@ -146,6 +764,9 @@ func defaultOptions() *options {
 		Voters:                   3,
 		StandBys:                 2,
 		RolesAdjustmentFrequency: 30 * time.Second,
+		JoinBackoffFactor:        time.Second,
+		JoinBackoffCap:           time.Minute,
+		Clock:                    clock.Real,
 	}
 }
 
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// PeerCredentials holds the credentials of a process connected to the local
+// socket, as reported by the kernel.
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// LocalSocketAccess lists the users and groups allowed to connect to the local
+// socket. A process is allowed if either its user ID or its primary group ID
+// is listed.
+type LocalSocketAccess struct {
+	UIDs []uint32
+	GIDs []uint32
+}
+
+// Return true if a process with the given credentials is allowed.
+func (a LocalSocketAccess) allows(creds PeerCredentials) bool {
+	for _, uid := range a.UIDs {
+		if uid == creds.UID {
+			return true
+		}
+	}
+	for _, gid := range a.GIDs {
+		if gid == creds.GID {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the credentials of the process at the other end of the given unix
+// socket connection.
+func peerCredentials(conn net.Conn) (PeerCredentials, error) {
+	unix, ok := conn.(*net.UnixConn)
+	if !ok {
+		return PeerCredentials{}, fmt.Errorf("not a unix socket connection")
+	}
+
+	raw, err := unix.SyscallConn()
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+	if credErr != nil {
+		return PeerCredentials{}, fmt.Errorf("get SO_PEERCRED: %w", credErr)
+	}
+
+	return PeerCredentials{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
+
+// Listen to the unix socket at the given filesystem path, removing a stale
+// socket file left by a previous run if needed.
+func listenLocalSocket(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+	return net.Listen("unix", path)
+}
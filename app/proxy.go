@@ -3,14 +3,139 @@ package app
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// ProxyConnection holds information about a client connection proxied to the
+// local node.
+type ProxyConnection struct {
+	Peer        string        // Remote address of the client.
+	Start       time.Time     // Time at which the connection was accepted.
+	Duration    time.Duration // Time elapsed since the connection was accepted.
+	BytesIn     int64         // Bytes received from the client.
+	BytesOut    int64         // Bytes sent to the client.
+	CipherSuite string        // Negotiated TLS cipher suite, if the handshake completed.
+	Identity    string        // Identity returned by the AuthFunc, if any.
+	Err         error         // Error that terminated the connection, if any.
+}
+
+// Track the traffic of a proxied connection.
+type proxyConn struct {
+	in       int64 // Bytes received from the client, accessed atomically.
+	out      int64 // Bytes sent to the client, accessed atomically.
+	peer     string
+	start    time.Time
+	mu       sync.Mutex
+	tls      *tls.Conn // TLS wrapper of the client connection, if set.
+	identity string    // Identity of the authenticated client, if any.
+}
+
+// Return information about the connection as of the given time.
+func (c *proxyConn) info(now time.Time) ProxyConnection {
+	info := ProxyConnection{
+		Peer:     c.peer,
+		Start:    c.start,
+		Duration: now.Sub(c.start),
+		BytesIn:  atomic.LoadInt64(&c.in),
+		BytesOut: atomic.LoadInt64(&c.out),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info.Identity = c.identity
+
+	if c.tls != nil {
+		state := c.tls.ConnectionState()
+		if state.HandshakeComplete {
+			info.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+		}
+	}
+
+	return info
+}
+
+// Return the identity of the client, which is the one returned by the
+// AuthFunc if set, or else the common name of the client TLS certificate.
+func (c *proxyConn) peerIdentity() string {
+	c.mu.Lock()
+	identity := c.identity
+	c.mu.Unlock()
+
+	if identity != "" {
+		return identity
+	}
+	if cert := c.peerCertificate(); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// Return the certificate presented by the client, if any.
+func (c *proxyConn) peerCertificate() *x509.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tls == nil {
+		return nil
+	}
+	state := c.tls.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// Writer adding the number of bytes written to a counter.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
+
+// ReadFrom copies data from the given reader with splice(2) if both ends are
+// plain sockets, or else with a pooled buffer.
+func (w countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	if src, dst := rawSocket(r), rawSocket(w.w); src != nil && dst != nil {
+		return spliceCopy(dst, src, w.n)
+	}
+	return copyBuffered(w, r)
+}
+
+// Reader adding the number of bytes read to a counter.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+// WriteTo copies data to the given writer with splice(2) if both ends are
+// plain sockets, or else with a pooled buffer.
+func (r countingReader) WriteTo(w io.Writer) (int64, error) {
+	if src, dst := rawSocket(r.r), rawSocket(w); src != nil && dst != nil {
+		return spliceCopy(dst, src, r.n)
+	}
+	return copyBuffered(w, r)
+}
+
 // Copies data between a remote TCP network connection (possibly with TLS) and
 // a local unix socket.
 //
@@ -21,19 +146,40 @@ import (
 // - the context is cancelled
 // - an error occurs when writing or reading data
 //
-// In case of errors, details are returned.
-func proxy(ctx context.Context, remote net.Conn, local net.Conn, config *tls.Config) error {
-	tcp := remote.(*net.TCPConn)
-
-	if err := setKeepalive(tcp); err != nil {
-		return err
+// In case of errors, details are returned. If config is not nil, the remote
+// connection uses TLS, as server if the server flag is set or as client
+// otherwise. The traffic is recorded in the given connection tracker. If
+// handshake is not nil, it's called before starting to copy data, for
+// example to perform authentication. If filter is not nil, requests from the
+// remote connection go through it instead of being copied verbatim.
+func proxy(ctx context.Context, remote net.Conn, local net.Conn, config *tls.Config, server bool, conn *proxyConn, handshake func(remote, local net.Conn) error, filter *requestFilter) error {
+	// The remote connection is normally a TCP one, but it might be wrapped
+	// when using a custom dial function.
+	raw := remote
+	if tcp, ok := raw.(*net.TCPConn); ok {
+		if err := setKeepalive(tcp); err != nil {
+			return err
+		}
 	}
 
 	if config != nil {
-		if config.ClientCAs != nil {
-			remote = tls.Server(remote, config)
+		var wrapped *tls.Conn
+		if server {
+			wrapped = tls.Server(remote, config)
 		} else {
-			remote = tls.Client(remote, config)
+			wrapped = tls.Client(remote, config)
+		}
+		conn.mu.Lock()
+		conn.tls = wrapped
+		conn.mu.Unlock()
+		remote = wrapped
+	}
+
+	if handshake != nil {
+		if err := handshake(remote, local); err != nil {
+			remote.Close()
+			local.Close()
+			return err
 		}
 	}
 
@@ -42,13 +188,36 @@ func proxy(ctx context.Context, remote net.Conn, local net.Conn, config *tls.Con
 
 	// Start copying data back and forth until either the client or the
 	// server get closed or hit an error.
+	//
+	// Without TLS, data is spliced between the sockets, without copying it
+	// to user space. That's always the case for the raft traffic of
+	// outgoing connections, while for incoming ones it happens once the
+	// filter detects a raft connection. The filter might reply to requests
+	// itself, so in that case writes to the remote connection must be
+	// serialized and can't be spliced.
+	var out io.Writer = countingWriter{w: remote, n: &conn.out}
+	if filter != nil {
+		out = &lockedWriter{w: out}
+	}
+
 	go func() {
-		_, err := io.Copy(local, remote)
+		in := countingReader{r: remote, n: &conn.in}
+		var err error
+		if filter != nil {
+			err = filter.copy(local, in, out)
+		} else {
+			_, err = io.Copy(local, in)
+		}
 		remoteToLocal <- err
 	}()
 
 	go func() {
-		_, err := io.Copy(remote, local)
+		var err error
+		if w, ok := out.(io.ReaderFrom); ok {
+			_, err = w.ReadFrom(local)
+		} else {
+			_, err = copyBuffered(out, local)
+		}
 		localToRemote <- err
 	}()
 
@@ -75,7 +244,9 @@ func proxy(ctx context.Context, remote net.Conn, local net.Conn, config *tls.Con
 		if err != nil {
 			errs[0] = fmt.Errorf("local -> remote: %v", err)
 		}
-		tcp.CloseRead()
+		if conn, ok := raw.(interface{ CloseRead() error }); ok {
+			conn.CloseRead()
+		}
 		if err := <-remoteToLocal; err != nil {
 			errs[1] = fmt.Errorf("remote -> local: %v", err)
 		}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// QuorumAlert holds diagnostic details about a loss of quorum, passed to the
+// function set with WithQuorumAlert.
+type QuorumAlert struct {
+	// Whether the quorum is lost. It's false for the alert sent when a
+	// leader is found again after a loss.
+	Lost bool
+
+	// Last time a leader was found, or the zero time if it never was.
+	LastContact time.Time
+
+	// Members of the cluster as last seen by this node, along with their
+	// roles and whether they could be reached when the alert was raised.
+	Nodes []QuorumNode
+}
+
+// QuorumNode holds the state of a cluster member when a QuorumAlert was
+// raised.
+type QuorumNode struct {
+	client.NodeInfo
+	Reachable bool
+}
+
+// Find the leader like Leader does, but give up after the quorum timeout if
+// a quorum alert function is set, raising the alert if it was not raised yet.
+func (a *App) findLeader(ctx context.Context) (*client.Client, error) {
+	if a.quorumAlert == nil {
+		return a.Leader(ctx)
+	}
+
+	leaderCtx, cancel := context.WithTimeout(ctx, a.quorumTimeout)
+	defer cancel()
+
+	cli, err := a.Leader(leaderCtx)
+	if err != nil {
+		// Don't report a loss if we are just shutting down.
+		if ctx.Err() == nil && !a.quorumLost {
+			a.quorumLost = true
+			a.raiseQuorumAlert(ctx, true)
+		}
+		return nil, err
+	}
+
+	if a.quorumLost {
+		a.quorumLost = false
+		a.raiseQuorumAlert(ctx, false)
+	}
+	a.lastContact = a.clock.Now()
+
+	return cli, nil
+}
+
+// Call the quorum alert function with the current state of the nodes in the
+// store.
+func (a *App) raiseQuorumAlert(ctx context.Context, lost bool) {
+	nodes, err := a.store.Get(ctx)
+	if err != nil {
+		a.warn("get nodes for quorum alert: %v", err)
+	}
+
+	alert := QuorumAlert{Lost: lost, LastContact: a.lastContact}
+
+	index := a.probeNodes(nodes)
+	for _, node := range nodes {
+		reachable := false
+		for _, other := range index[node.Role][online] {
+			if other.ID == node.ID {
+				reachable = true
+			}
+		}
+		alert.Nodes = append(alert.Nodes, QuorumNode{NodeInfo: node, Reachable: reachable})
+	}
+
+	if lost {
+		a.warn("quorum lost, no leader found within %s", a.quorumTimeout)
+	} else {
+		a.info("quorum regained")
+	}
+
+	a.quorumAlert(alert)
+}
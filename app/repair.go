@@ -0,0 +1,70 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/go-dqlite"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/ghodss/yaml"
+)
+
+// Rebuild the node store file of a node that already started once, if it's
+// missing, can't be parsed or lacks nodes found in the cluster configuration
+// stored in the raft data or in the given cluster addresses.
+func repairStore(dir string, cluster []string, log client.LogFunc) error {
+	path := filepath.Join(dir, storeFile)
+
+	nodes := []client.NodeInfo{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read %s: %w", storeFile, err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(data, &nodes); err != nil {
+			log(client.LogWarn, "discard unparsable %s: %v", storeFile, err)
+			nodes = []client.NodeInfo{}
+			if err := os.Rename(path, path+".corrupted"); err != nil {
+				return fmt.Errorf("back up %s: %w", storeFile, err)
+			}
+		}
+	}
+
+	configuration, err := dqlite.ReadConfiguration(dir)
+	if err != nil {
+		return fmt.Errorf("read raft configuration: %w", err)
+	}
+
+	// Nodes in the raft configuration come first, since they are the most
+	// likely to be current members.
+	repaired := append([]client.NodeInfo{}, configuration...)
+	addresses := map[string]bool{}
+	for _, node := range configuration {
+		addresses[node.Address] = true
+	}
+	for _, node := range nodes {
+		if !addresses[node.Address] {
+			repaired = append(repaired, node)
+			addresses[node.Address] = true
+		}
+	}
+	for _, address := range cluster {
+		if !addresses[address] {
+			repaired = append(repaired, client.NodeInfo{Address: address})
+			addresses[address] = true
+		}
+	}
+
+	if len(repaired) == 0 {
+		return fmt.Errorf("no node found in raft data or cluster addresses")
+	}
+	if len(repaired) == len(nodes) {
+		return nil
+	}
+
+	log(client.LogInfo, "repair %s with %d nodes", storeFile, len(repaired))
+
+	return fileMarshal(dir, storeFile, repaired)
+}
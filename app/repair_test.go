@@ -0,0 +1,42 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The node store is rebuilt from a data directory as written by raft, with a
+// pre-allocated open segment following the closed ones.
+func TestRepairStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dqlite-app-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files, err := ioutil.ReadDir(filepath.Join("testdata", "raft"))
+	require.NoError(t, err)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "raft", file.Name()))
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file.Name()), data, 0600))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, storeFile), []byte("{garbage"), 0600))
+
+	log := func(client.LogLevel, string, ...interface{}) {}
+	require.NoError(t, repairStore(dir, []string{"127.0.0.1:9002"}, log))
+
+	store, err := client.NewYamlNodeStore(filepath.Join(dir, storeFile))
+	require.NoError(t, err)
+	nodes, err := store.Get(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []client.NodeInfo{
+		{ID: 1, Address: "127.0.0.1:9001", Role: client.Voter},
+		{Address: "127.0.0.1:9002"},
+	}, nodes)
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// Name of the table of the internal database listing the nodes that must
+// never be voters.
+const nonVotersTable = "_dqlite_non_voters"
+
+// Record in the cluster that this node must never be a voter, so that the
+// leader doesn't promote it, whatever node is the leader.
+func (a *App) recordNonVoting(ctx context.Context) error {
+	db, err := a.Open(ctx, internalDatabase)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY)", nonVotersTable)
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+
+	// Node IDs are stored as signed integers, as SQLite requires.
+	stmt = fmt.Sprintf("INSERT OR IGNORE INTO %s(id) VALUES(?)", nonVotersTable)
+	if _, err := db.ExecContext(ctx, stmt, int64(a.id)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Return the IDs of the given nodes that must not be voters, either because
+// they are recorded as non-voting or because their labels don't match the
+// voter selector.
+func (a *App) nonVoters(ctx context.Context, nodes []client.NodeInfo) (map[uint64]bool, error) {
+	db, err := a.Open(ctx, internalDatabase)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ids := map[uint64]bool{}
+
+	if len(a.voterSelector) > 0 {
+		labels, err := a.nodeLabels(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			if !matchLabels(labels[node.ID], a.voterSelector) {
+				ids[node.ID] = true
+			}
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT id FROM %s", nonVotersTable))
+	if err != nil {
+		// No node was ever configured as non-voting.
+		if strings.Contains(err.Error(), "no such table") {
+			return ids, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[uint64(id)] = true
+	}
+
+	return ids, rows.Err()
+}
+
+// Return true if this node may be a voter.
+func (a *App) canVote() bool {
+	return !a.nonVoting && matchLabels(a.labels, a.voterSelector)
+}
+
+// Return the given nodes, except the ones in the given set.
+func excludeNodes(nodes []client.NodeInfo, excluded map[uint64]bool) []client.NodeInfo {
+	included := make([]client.NodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		if !excluded[node.ID] {
+			included = append(included, node)
+		}
+	}
+	return included
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+type backupSchedule struct {
+	Interval time.Duration
+	Dir      string
+}
+
+// Periodically write a backup of all databases, if this node is the leader.
+func (a *App) backupPeriodically(ctx context.Context) {
+	defer close(a.backupCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.clock.After(a.backups.Interval):
+		}
+		if err := a.backupDatabases(ctx); err != nil {
+			a.warn("scheduled backup: %v", err)
+		}
+	}
+}
+
+// Dump all databases from the local node, if it's the leader, and atomically
+// replace their backup files.
+func (a *App) backupDatabases(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, a.backups.Interval)
+	defer cancel()
+
+	cli, err := client.New(ctx, a.nodeBindAddress, client.WithLogFunc(a.log))
+	if err != nil {
+		return fmt.Errorf("connect to local node: %w", err)
+	}
+	defer cli.Close()
+
+	// Only the leader writes backups, so that there's a single fresh copy
+	// in the cluster, which moves along with leadership.
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		return fmt.Errorf("get leader: %w", err)
+	}
+	if leader == nil || leader.ID != a.id {
+		return nil
+	}
+
+	databases, err := a.recordedDatabases(ctx)
+	if err != nil {
+		return fmt.Errorf("list databases: %w", err)
+	}
+
+	for _, database := range databases {
+		image, err := dumpImage(ctx, cli, database)
+		if err != nil {
+			return fmt.Errorf("dump %s: %w", database, err)
+		}
+		if err := writeImage(a.backups.Dir, database, image); err != nil {
+			return fmt.Errorf("write %s: %w", database, err)
+		}
+	}
+
+	a.debug("backed up %d databases", len(databases))
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Minimum length of a cluster secret, in bytes.
+const minSecretLength = 32
+
+// Server name of the certificate derived from a cluster secret.
+const secretServerName = "dqlite"
+
+// SecretTLSConfig returns a pair of TLS configuration objects that encrypt and
+// mutually authenticate traffic between nodes and clients knowing the given
+// cluster secret, without the need for a certificate authority.
+//
+// A key pair and a self-signed certificate are derived deterministically from
+// the secret, so all nodes and clients using the same secret trust each
+// other. The secret must be at least 32 bytes long and randomly generated,
+// for example with:
+//
+//	head -c 32 /dev/urandom | base64
+//
+// since its public derivation could otherwise be brute-forced. Anyone knowing
+// the secret can join the cluster or impersonate any node, and changing it
+// requires restarting all nodes and clients.
+//
+// The returned configs can be used as "listen" and "dial" parameters for the
+// WithTLS option, and the dial one with client.DialFuncWithTLS.
+func SecretTLSConfig(secret []byte) (*tls.Config, *tls.Config, error) {
+	cert, err := secretCertificate(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+
+	listen, dial := SimpleTLSConfig(cert, pool)
+
+	return listen, dial, nil
+}
+
+// Derive a key pair and a self-signed certificate from the given secret.
+func secretCertificate(secret []byte) (tls.Certificate, error) {
+	if len(secret) < minSecretLength {
+		return tls.Certificate{}, fmt.Errorf("cluster secret must be at least %d bytes long", minSecretLength)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("go-dqlite cluster secret key"))
+	key := ed25519.NewKeyFromSeed(mac.Sum(nil))
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: secretServerName},
+		DNSNames:              []string{secretServerName},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	// Ed25519 signatures are deterministic, so no randomness is needed.
+	der, err := x509.CreateCertificate(nil, template, template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("create certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("parse certificate: %w", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// Flags of splice(2), not exposed by the syscall package.
+const (
+	spliceMove     = 0x1
+	spliceNonblock = 0x2
+)
+
+// Maximum amount of data moved by a single splice(2) call, matching the
+// default capacity of a pipe.
+const spliceChunk = 64 * 1024
+
+// Buffers used to copy data that can't be spliced, for example because it
+// goes through TLS.
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
+// Copy data from src to dst using a pooled buffer.
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	// Hide any ReaderFrom or WriterTo implementation, which would make
+	// io.CopyBuffer ignore the buffer, or recurse.
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
+
+// Return the raw socket of the given connection, if it's a plain TCP or Unix
+// one whose data can be spliced.
+func rawSocket(conn interface{}) syscall.RawConn {
+	var sc syscall.Conn
+	switch conn := conn.(type) {
+	case *net.TCPConn:
+		sc = conn
+	case *net.UnixConn:
+		sc = conn
+	default:
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// Copy data from the src socket to the dst socket until EOF with splice(2),
+// moving it through a pipe without copying it to user space. The number of
+// bytes copied is added to the given counter as data flows.
+func spliceCopy(dst, src syscall.RawConn, counter *int64) (int64, error) {
+	var pipe [2]int
+	if err := syscall.Pipe2(pipe[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		return 0, err
+	}
+	defer syscall.Close(pipe[0])
+	defer syscall.Close(pipe[1])
+
+	total := int64(0)
+	for {
+		// The pipe is always drained before filling it again, so EAGAIN
+		// can only mean that the source socket has no data yet.
+		var n int64
+		var serr error
+		err := src.Read(func(fd uintptr) bool {
+			for {
+				n, serr = syscall.Splice(int(fd), nil, pipe[1], nil, spliceChunk, spliceMove|spliceNonblock)
+				if serr != syscall.EINTR {
+					break
+				}
+			}
+			return serr != syscall.EAGAIN
+		})
+		if err == nil {
+			err = serr
+		}
+		if err != nil {
+			return total, err
+		}
+		if n == 0 {
+			return total, nil // EOF
+		}
+
+		for n > 0 {
+			var written int64
+			err := dst.Write(func(fd uintptr) bool {
+				for {
+					written, serr = syscall.Splice(pipe[0], nil, int(fd), nil, int(n), spliceMove|spliceNonblock)
+					if serr != syscall.EINTR {
+						break
+					}
+				}
+				return serr != syscall.EAGAIN
+			})
+			if err == nil {
+				err = serr
+			}
+			if err != nil {
+				return total, err
+			}
+			if written == 0 {
+				return total, io.ErrShortWrite
+			}
+			n -= written
+			total += written
+			atomic.AddInt64(counter, written)
+		}
+	}
+}
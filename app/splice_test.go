@@ -0,0 +1,66 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Plain TCP traffic is spliced to the local socket and back, and counted.
+func TestProxy_Splice(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	remote, err := listener.Accept()
+	require.NoError(t, err)
+
+	local, node, err := socketpair()
+	require.NoError(t, err)
+	defer node.Close()
+
+	conn := &proxyConn{}
+	done := make(chan error)
+	go func() {
+		done <- proxy(context.Background(), remote, local, nil, false, conn, nil, nil)
+	}()
+
+	request := bytes.Repeat([]byte("abcdefgh"), 100000)
+	go client.Write(request)
+	received := make([]byte, len(request))
+	_, err = io.ReadFull(node, received)
+	require.NoError(t, err)
+	assert.Equal(t, request, received)
+
+	response := bytes.Repeat([]byte("12345678"), 50000)
+	go func() {
+		node.Write(response)
+		node.Close()
+	}()
+	received = make([]byte, len(response))
+	_, err = io.ReadFull(client, received)
+	require.NoError(t, err)
+	assert.Equal(t, response, received)
+
+	require.NoError(t, <-done)
+	assert.Equal(t, int64(len(request)), conn.in)
+	assert.Equal(t, int64(len(response)), conn.out)
+}
+
+func TestCopyBuffered(t *testing.T) {
+	src := bytes.NewReader(bytes.Repeat([]byte("x"), 100000))
+	dst := &bytes.Buffer{}
+
+	n, err := copyBuffered(dst, io.LimitReader(src, 70000))
+	require.NoError(t, err)
+	assert.Equal(t, int64(70000), n)
+	assert.Equal(t, 70000, dst.Len())
+}
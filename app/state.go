@@ -0,0 +1,105 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/canonical/go-dqlite"
+	"github.com/canonical/go-dqlite/client"
+)
+
+// SQLite's SQLITE_IOERR code, returned to clients when the state of the node
+// can't be read.
+const errState = 10
+
+// Maximum number of times the raft log is read, when segment files are
+// removed or renamed while doing so.
+const stateAttempts = 3
+
+// Interval between the checks of WaitIndex.
+const waitIndexInterval = 100 * time.Millisecond
+
+// Return the index and term of the last entry of the raft log of the node.
+type stateFunc func() (uint64, uint64, error)
+
+// Return the index and term of the last entry of the raft log of this node.
+func (a *App) lastLogEntry() (index uint64, term uint64, err error) {
+	if a.node == nil {
+		return 0, 0, errors.New("client-only apps have no raft log")
+	}
+	for i := 0; i < stateAttempts; i++ {
+		index, term, err = dqlite.LastLogEntry(a.dir)
+		if !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+	return index, term, err
+}
+
+// LastIndex returns the index of the last entry of the raft log of this node.
+// See client.Client.LastIndex for how it relates to the commit and applied
+// indexes.
+func (a *App) LastIndex() (uint64, error) {
+	index, _, err := a.lastLogEntry()
+	return index, err
+}
+
+// LeaderIndex returns the index of the last entry of the raft log of the
+// current leader, which must be an App node as well.
+//
+// Deployment tooling can pass it to WaitIndex to wait for this node to catch
+// up with the cluster before routing traffic to it.
+func (a *App) LeaderIndex(ctx context.Context) (uint64, error) {
+	cli, err := a.Leader(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cli.Close()
+
+	return cli.LastIndex(ctx)
+}
+
+// WaitIndex blocks until the raft log of this node reaches the given index.
+func (a *App) WaitIndex(ctx context.Context, index uint64) error {
+	for {
+		last, err := a.LastIndex()
+		if err != nil {
+			return err
+		}
+		if last >= index {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(waitIndexInterval):
+		}
+	}
+}
+
+// Return a client connected to the given node, checking that it reports the
+// index of its raft log, which only proxied App nodes do.
+func (a *App) replicationClient(ctx context.Context, node client.NodeInfo) (*client.Client, error) {
+	cli, err := client.New(ctx, node.Address, a.clientOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cli.LastIndex(ctx); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	return cli, nil
+}
+
+// Wait for the raft log of the node the given follower client is connected
+// to to reach the last index of the leader the given leader client is
+// connected to.
+func waitReplication(ctx context.Context, leader *client.Client, follower *client.Client) error {
+	index, err := leader.LastIndex(ctx)
+	if err != nil {
+		return err
+	}
+	return follower.WaitIndex(ctx, index)
+}
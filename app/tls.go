@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"fmt"
 
+	"github.com/canonical/go-dqlite/client"
 	"github.com/canonical/go-dqlite/internal/protocol"
 )
 
@@ -46,7 +47,8 @@ func SimpleTLSConfig(cert tls.Certificate, pool *x509.CertPool) (*tls.Config, *t
 }
 
 // SimpleListenTLSConfig returns a server-side TLS configuration with sane
-// defaults (e.g. TLS version, ciphers and mutual authentication).
+// defaults (e.g. TLS version, ciphers and mutual authentication). The TLS
+// parameters are the ones of DefaultTLSPolicy.
 //
 // The cert parameter must be a public/private key pair, typically loaded from
 // disk using tls.LoadX509KeyPair().
@@ -63,22 +65,21 @@ func SimpleTLSConfig(cert tls.Certificate, pool *x509.CertPool) (*tls.Config, *t
 func SimpleListenTLSConfig(cert tls.Certificate, pool *x509.CertPool) *tls.Config {
 	// See https://github.com/denji/golang-tls
 	config := &tls.Config{
-		MinVersion:               tls.VersionTLS12,
-		CipherSuites:             protocol.TLSCipherSuites,
 		PreferServerCipherSuites: true,
-		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 		Certificates:             []tls.Certificate{cert},
 		RootCAs:                  pool,
 		ClientCAs:                pool,
 		ClientAuth:               tls.RequireAndVerifyClientCert,
 	}
+	DefaultTLSPolicy().Apply(config)
 	config.BuildNameToCertificate()
 
 	return config
 }
 
 // SimpleDialTLSConfig returns a client-side TLS configuration with sane
-// defaults (e.g. TLS version, ciphers and mutual authentication).
+// defaults (e.g. TLS version, ciphers and mutual authentication). The TLS
+// parameters are the ones of DefaultTLSPolicy.
 //
 // The cert parameter must be a public/private key pair, typically loaded from
 // disk using tls.LoadX509KeyPair().
@@ -94,12 +95,11 @@ func SimpleListenTLSConfig(cert tls.Certificate, pool *x509.CertPool) *tls.Confi
 // option, or as "config" parameter for the client.DialFuncWithTLS() helper.
 func SimpleDialTLSConfig(cert tls.Certificate, pool *x509.CertPool) *tls.Config {
 	config := &tls.Config{
-		MinVersion:               tls.VersionTLS12,
-		CipherSuites:             protocol.TLSCipherSuites,
 		PreferServerCipherSuites: true,
 		RootCAs:                  pool,
 		Certificates:             []tls.Certificate{cert},
 	}
+	DefaultTLSPolicy().Apply(config)
 
 	x509cert, err := x509.ParseCertificate(cert.Certificate[0])
 	if err != nil {
@@ -112,3 +112,114 @@ func SimpleDialTLSConfig(cert tls.Certificate, pool *x509.CertPool) *tls.Config
 
 	return config
 }
+
+// TLSPolicy holds the TLS parameters negotiated by nodes and clients.
+//
+// Zero-valued fields are set to the values of DefaultTLSPolicy.
+type TLSPolicy struct {
+	// Minimum TLS version to accept, such as tls.VersionTLS12. Versions
+	// older than TLS 1.2 are not allowed.
+	MinVersion uint16
+
+	// Cipher suites to offer or accept, in order of preference. They only
+	// apply to TLS 1.2, since TLS 1.3 suites are not configurable. Suites
+	// that crypto/tls considers insecure are not allowed.
+	CipherSuites []uint16
+
+	// Elliptic curves to use in ECDHE key exchanges, in order of
+	// preference.
+	CurvePreferences []tls.CurveID
+}
+
+// DefaultTLSPolicy returns the TLS policy used by SimpleTLSConfig: at least
+// TLS 1.2, only ECDHE cipher suites with AEAD ciphers, and X25519, P-256 and
+// P-384 curves.
+func DefaultTLSPolicy() TLSPolicy {
+	suites := make([]uint16, len(protocol.TLSCipherSuites))
+	copy(suites, protocol.TLSCipherSuites)
+	return TLSPolicy{
+		MinVersion:       tls.VersionTLS12,
+		CipherSuites:     suites,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	}
+}
+
+// Apply sets the TLS parameters of the given config according to the policy.
+// If the config has a GetConfigForClient callback, the policy is also applied
+// to copies of the configs it returns.
+func (p TLSPolicy) Apply(config *tls.Config) {
+	p = p.withDefaults()
+	config.MinVersion = p.MinVersion
+	config.CipherSuites = p.CipherSuites
+	config.CurvePreferences = p.CurvePreferences
+
+	if get := config.GetConfigForClient; get != nil {
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			config, err := get(hello)
+			if err != nil || config == nil {
+				return config, err
+			}
+			config = config.Clone()
+			p.Apply(config)
+			return config, nil
+		}
+	}
+}
+
+// Return a copy of the policy with zero-valued fields set to the defaults.
+func (p TLSPolicy) withDefaults() TLSPolicy {
+	defaults := DefaultTLSPolicy()
+	if p.MinVersion == 0 {
+		p.MinVersion = defaults.MinVersion
+	}
+	if len(p.CipherSuites) == 0 {
+		p.CipherSuites = defaults.CipherSuites
+	}
+	if len(p.CurvePreferences) == 0 {
+		p.CurvePreferences = defaults.CurvePreferences
+	}
+	return p
+}
+
+// Check that the policy doesn't allow obsolete or insecure parameters.
+func (p TLSPolicy) validate() error {
+	if p.MinVersion != 0 && p.MinVersion < tls.VersionTLS12 {
+		return fmt.Errorf("minimum TLS version %#04x is older than TLS 1.2", p.MinVersion)
+	}
+
+	secure := map[uint16]bool{}
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.ID] = true
+	}
+	for _, id := range p.CipherSuites {
+		if !secure[id] {
+			return fmt.Errorf("cipher suite %s is insecure or unsupported", tls.CipherSuiteName(id))
+		}
+	}
+
+	return nil
+}
+
+// Return copies of the configs in the given setup, with the given policy
+// applied.
+func (s *tlsSetup) withPolicy(policy TLSPolicy) *tlsSetup {
+	listen := s.Listen.Clone()
+	dial := s.Dial.Clone()
+	// The listen configuration is nil for client-only apps.
+	if listen != nil {
+		policy.Apply(listen)
+	}
+	policy.Apply(dial)
+	return &tlsSetup{Listen: listen, Dial: dial}
+}
+
+// Return a copy of the setup whose configurations also check peers with the
+// given verifier.
+func (s *tlsSetup) withVerifier(verify client.PeerVerifier) *tlsSetup {
+	listen := s.Listen
+	if listen != nil {
+		listen = client.TLSConfigWithVerifier(listen, verify)
+	}
+	dial := client.TLSConfigWithVerifier(s.Dial, verify)
+	return &tlsSetup{Listen: listen, Dial: dial}
+}
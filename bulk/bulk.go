@@ -0,0 +1,71 @@
+// Package bulk streams CSV or newline-delimited JSON data into dqlite tables,
+// and query results out of them in the same formats.
+package bulk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Format of bulk data.
+type Format string
+
+// Supported formats.
+const (
+	// CSV data with a header line naming the columns.
+	CSV Format = "csv"
+
+	// NDJSON data holding one JSON object per line, with keys naming the
+	// columns.
+	NDJSON Format = "ndjson"
+)
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case CSV:
+		return CSV, nil
+	case NDJSON, "jsonl":
+		return NDJSON, nil
+	}
+	return "", fmt.Errorf("unknown format %q (use csv or ndjson)", name)
+}
+
+// ProgressFunc is called with the total number of rows processed so far.
+type ProgressFunc func(rows int64)
+
+// Option can be used to tweak import and export parameters.
+type Option func(*options)
+
+// WithBatchSize sets the number of rows inserted in each transaction when
+// importing, 1000 by default.
+func WithBatchSize(size int) Option {
+	return func(options *options) {
+		options.BatchSize = size
+	}
+}
+
+// WithProgress sets a function called after each imported batch, or every
+// batch size exported rows.
+func WithProgress(progress ProgressFunc) Option {
+	return func(options *options) {
+		options.Progress = progress
+	}
+}
+
+type options struct {
+	BatchSize int
+	Progress  ProgressFunc
+}
+
+// Create a options object with sane defaults.
+func defaultOptions() *options {
+	return &options{
+		BatchSize: 1000,
+		Progress:  func(int64) {},
+	}
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
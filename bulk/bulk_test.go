@@ -0,0 +1,173 @@
+package bulk_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/canonical/go-dqlite/bulk"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImport_CSV(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	data := `name,age,score,note
+alice,30,1.5,
+bob,,2,"hello, world"
+carol,x,,
+`
+	progress := []int64{}
+	n, err := bulk.Import(context.Background(), db, "people", strings.NewReader(data), bulk.CSV,
+		bulk.WithBatchSize(2), bulk.WithProgress(func(rows int64) { progress = append(progress, rows) }))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, []int64{2, 3}, progress)
+
+	assert.Equal(t, [][]interface{}{
+		{"alice", int64(30), 1.5, ""},
+		{"bob", nil, 2.0, "hello, world"},
+		{"carol", "x", nil, ""},
+	}, dump(t, db))
+}
+
+func TestImport_NDJSON(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	data := `{"name": "alice", "age": 30, "score": 1.5, "note": null}
+{"name": "bob", "note": {"tags": ["a"]}}
+`
+	n, err := bulk.Import(context.Background(), db, "people", strings.NewReader(data), bulk.NDJSON)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	assert.Equal(t, [][]interface{}{
+		{"alice", int64(30), 1.5, nil},
+		{"bob", nil, nil, `{"tags":["a"]}`},
+	}, dump(t, db))
+}
+
+func TestImport_Errors(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	_, err := bulk.Import(ctx, db, "missing", strings.NewReader("a\n1\n"), bulk.CSV)
+	assert.EqualError(t, err, "no such table: missing")
+
+	_, err = bulk.Import(ctx, db, "people", strings.NewReader("name,height\nalice,1\n"), bulk.CSV)
+	assert.EqualError(t, err, "table people has no column named height")
+
+	n, err := bulk.Import(ctx, db, "people", strings.NewReader(`{"name": "a"}`+"\n"+`{"name": "b", "x": 1}`), bulk.NDJSON)
+	assert.EqualError(t, err, `record 2: unexpected key "x" not present in the first record`)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestExport(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	_, err := db.Exec("INSERT INTO people VALUES ('alice', 30, 1.5, NULL), ('bob', NULL, 2, 'a \"b\"')")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	query := "SELECT name, age, score, note FROM people ORDER BY name"
+
+	buf := &bytes.Buffer{}
+	n, err := bulk.Export(ctx, db, query, nil, buf, bulk.CSV)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, "name,age,score,note\nalice,30,1.5,\nbob,,2,\"a \"\"b\"\"\"\n", buf.String())
+
+	buf.Reset()
+	n, err = bulk.Export(ctx, db, query+" LIMIT ?", []interface{}{1}, buf, bulk.NDJSON)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, `{"name":"alice","age":30,"score":1.5,"note":null}`+"\n", buf.String())
+}
+
+// Exported data can be imported back.
+func TestExportImport(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	insert := "INSERT INTO people VALUES ('alice', 30, 1.5, 'x'), ('bob', NULL, 2, NULL)"
+	_, err := db.Exec(insert)
+	require.NoError(t, err)
+	expected := dump(t, db)
+
+	for _, format := range []bulk.Format{bulk.CSV, bulk.NDJSON} {
+		_, err = db.Exec("DELETE FROM people")
+		require.NoError(t, err)
+		_, err = db.Exec(insert)
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		_, err := bulk.Export(ctx, db, "SELECT * FROM people", nil, buf, format)
+		require.NoError(t, err)
+
+		_, err = db.Exec("DELETE FROM people")
+		require.NoError(t, err)
+
+		_, err = bulk.Import(ctx, db, "people", buf, format)
+		require.NoError(t, err)
+
+		rows := dump(t, db)
+		if format == bulk.CSV {
+			// NULL text values can't be told apart from empty
+			// strings in CSV.
+			rows[1][3] = nil
+		}
+		assert.Equal(t, expected, rows, format)
+	}
+}
+
+func dump(t *testing.T, db *sql.DB) [][]interface{} {
+	rows, err := db.Query("SELECT name, age, score, note FROM people ORDER BY rowid")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	result := [][]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, 4)
+		require.NoError(t, rows.Scan(&values[0], &values[1], &values[2], &values[3]))
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result = append(result, values)
+	}
+	require.NoError(t, rows.Err())
+
+	return result
+}
+
+func newDB(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "dqlite-bulk-test-")
+	require.NoError(t, err)
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+
+	_, err = db.Exec("CREATE TABLE people (name TEXT, age INTEGER, score REAL, note TEXT)")
+	require.NoError(t, err)
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	return db, cleanup
+}
@@ -0,0 +1,187 @@
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Export runs the given query and writes its results to the given writer,
+// returning the number of exported rows.
+//
+// CSV output starts with a header line naming the columns, and NULL values
+// are written as empty fields. NDJSON output holds one JSON object per row.
+// In both formats times are written in RFC 3339 format.
+func Export(ctx context.Context, db *sql.DB, query string, args []interface{}, w io.Writer, format Format, options ...Option) (int64, error) {
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+	if o.BatchSize < 1 {
+		return 0, fmt.Errorf("invalid batch size %d", o.BatchSize)
+	}
+
+	var records recordWriter
+	switch format {
+	case CSV:
+		records = newCSVWriter(w)
+	case NDJSON:
+		records = newNDJSONWriter(w)
+	default:
+		_, err := ParseFormat(string(format))
+		return 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if err := records.Header(columns); err != nil {
+		return 0, err
+	}
+
+	n := int64(0)
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return n, err
+		}
+		if err := records.Write(values); err != nil {
+			return n, err
+		}
+		n++
+		if n%int64(o.BatchSize) == 0 {
+			o.Progress(n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if err := records.Flush(); err != nil {
+		return n, err
+	}
+	if n%int64(o.BatchSize) != 0 {
+		o.Progress(n)
+	}
+
+	return n, nil
+}
+
+// Destination of exported records.
+type recordWriter interface {
+	Header(columns []string) error
+	Write(values []interface{}) error
+	Flush() error
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{writer: csv.NewWriter(w)}
+}
+
+func (w *csvWriter) Header(columns []string) error {
+	w.record = make([]string, len(columns))
+	return w.writer.Write(columns)
+}
+
+func (w *csvWriter) Write(values []interface{}) error {
+	for i, value := range values {
+		w.record[i] = formatCSV(value)
+	}
+	return w.writer.Write(w.record)
+}
+
+func (w *csvWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func formatCSV(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(value)
+	case string:
+		return value
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+type ndjsonWriter struct {
+	writer  *bufio.Writer
+	columns [][]byte // JSON-encoded column names.
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{writer: bufio.NewWriter(w)}
+}
+
+func (w *ndjsonWriter) Header(columns []string) error {
+	w.columns = make([][]byte, len(columns))
+	for i, column := range columns {
+		data, err := json.Marshal(column)
+		if err != nil {
+			return err
+		}
+		w.columns[i] = data
+	}
+	return nil
+}
+
+// Write an object with keys in column order.
+func (w *ndjsonWriter) Write(values []interface{}) error {
+	w.writer.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			w.writer.WriteByte(',')
+		}
+		w.writer.Write(w.columns[i])
+		w.writer.WriteByte(':')
+
+		// Text stored in BLOB columns is written as a string, other
+		// binary data is base64-encoded.
+		if b, ok := value.([]byte); ok && utf8.Valid(b) {
+			value = string(b)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		w.writer.Write(data)
+	}
+	w.writer.WriteByte('}')
+	_, err := w.writer.WriteString("\n")
+	return err
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return w.writer.Flush()
+}
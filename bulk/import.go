@@ -0,0 +1,336 @@
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Import inserts the records read from the given reader into the given table,
+// returning the number of inserted rows.
+//
+// Records are inserted in batches, each in its own transaction, so if an
+// error occurs the rows of the previous batches stay inserted, and the
+// returned count tells how many.
+//
+// CSV values are coerced according to the type affinity of their column:
+// values of INTEGER, REAL and NUMERIC columns are inserted as numbers when
+// they parse as such, and empty values are inserted as NULL in all but TEXT
+// columns. JSON numbers, strings, booleans and nulls are inserted as is,
+// while nested objects and arrays are inserted as JSON text.
+func Import(ctx context.Context, db *sql.DB, table string, r io.Reader, format Format, options ...Option) (int64, error) {
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+	if o.BatchSize < 1 {
+		return 0, fmt.Errorf("invalid batch size %d", o.BatchSize)
+	}
+
+	affinities, err := tableAffinities(ctx, db, table)
+	if err != nil {
+		return 0, err
+	}
+
+	var records recordReader
+	switch format {
+	case CSV:
+		records, err = newCSVReader(r, affinities)
+	case NDJSON:
+		records, err = newNDJSONReader(r)
+	default:
+		_, err = ParseFormat(string(format))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	columns := records.Columns()
+	for _, column := range columns {
+		if _, ok := affinities[strings.ToLower(column)]; !ok {
+			return 0, fmt.Errorf("table %s has no column named %s", table, column)
+		}
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quoteIdent(column)
+	}
+	stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdent(table), strings.Join(quoted, ", "), placeholders)
+
+	n := int64(0)
+	batch := [][]interface{}{}
+	for {
+		values, err := records.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("record %d: %w", n+int64(len(batch))+1, err)
+		}
+		batch = append(batch, values)
+		if len(batch) < o.BatchSize {
+			continue
+		}
+		if err := insertBatch(ctx, db, stmt, batch); err != nil {
+			return n, fmt.Errorf("insert records %d-%d: %w", n+1, n+int64(len(batch)), err)
+		}
+		n += int64(len(batch))
+		batch = batch[:0]
+		o.Progress(n)
+	}
+	if len(batch) > 0 {
+		if err := insertBatch(ctx, db, stmt, batch); err != nil {
+			return n, fmt.Errorf("insert records %d-%d: %w", n+1, n+int64(len(batch)), err)
+		}
+		n += int64(len(batch))
+		o.Progress(n)
+	}
+
+	return n, nil
+}
+
+// Insert the given rows in a single transaction.
+func insertBatch(ctx context.Context, db *sql.DB, stmt string, rows [][]interface{}) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	prepared, err := tx.PrepareContext(ctx, stmt)
+	if err != nil {
+		return err
+	}
+	defer prepared.Close()
+
+	for _, values := range rows {
+		if _, err := prepared.ExecContext(ctx, values...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Type affinity of a column, see https://www.sqlite.org/datatype3.html.
+type affinity int
+
+const (
+	affinityBlob affinity = iota
+	affinityText
+	affinityInteger
+	affinityReal
+	affinityNumeric
+)
+
+// Return the affinity of each column of the given table, keyed by lowercase
+// column name.
+func tableAffinities(ctx context.Context, db *sql.DB, table string) (map[string]affinity, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", quoteIdent(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	affinities := map[string]affinity{}
+	for rows.Next() {
+		var cid int
+		var name, typ string
+		var notNull, pk int
+		var dflt interface{}
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		affinities[strings.ToLower(name)] = columnAffinity(typ)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(affinities) == 0 {
+		return nil, fmt.Errorf("no such table: %s", table)
+	}
+
+	return affinities, nil
+}
+
+// Return the affinity of a column with the given declared type.
+func columnAffinity(typ string) affinity {
+	typ = strings.ToUpper(typ)
+	switch {
+	case strings.Contains(typ, "INT"):
+		return affinityInteger
+	case strings.Contains(typ, "CHAR"), strings.Contains(typ, "CLOB"), strings.Contains(typ, "TEXT"):
+		return affinityText
+	case typ == "" || strings.Contains(typ, "BLOB"):
+		return affinityBlob
+	case strings.Contains(typ, "REAL"), strings.Contains(typ, "FLOA"), strings.Contains(typ, "DOUB"):
+		return affinityReal
+	}
+	return affinityNumeric
+}
+
+// Convert a CSV value according to the given column affinity.
+func coerce(value string, affinity affinity) interface{} {
+	if affinity == affinityText {
+		return value
+	}
+	if value == "" {
+		return nil
+	}
+	switch affinity {
+	case affinityInteger, affinityNumeric:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+		fallthrough
+	case affinityReal:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// Source of records to import.
+type recordReader interface {
+	Columns() []string
+	Next() ([]interface{}, error)
+}
+
+type csvReader struct {
+	reader     *csv.Reader
+	columns    []string
+	affinities []affinity
+}
+
+func newCSVReader(r io.Reader, affinities map[string]affinity) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("missing CSV header")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := append([]string{}, header...)
+	types := make([]affinity, len(columns))
+	for i, column := range columns {
+		types[i] = affinities[strings.ToLower(column)]
+	}
+
+	return &csvReader{reader: reader, columns: columns, affinities: types}, nil
+}
+
+func (r *csvReader) Columns() []string {
+	return r.columns
+}
+
+func (r *csvReader) Next() ([]interface{}, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(record))
+	for i, value := range record {
+		values[i] = coerce(value, r.affinities[i])
+	}
+	return values, nil
+}
+
+type ndjsonReader struct {
+	decoder *json.Decoder
+	columns []string
+	index   map[string]int
+	first   map[string]interface{} // First object, read to find the columns.
+}
+
+func newNDJSONReader(r io.Reader) (*ndjsonReader, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	decoder.UseNumber()
+
+	first := map[string]interface{}{}
+	if err := decoder.Decode(&first); err != nil {
+		if err == io.EOF {
+			return &ndjsonReader{decoder: decoder}, nil
+		}
+		return nil, fmt.Errorf("record 1: %w", err)
+	}
+
+	columns := make([]string, 0, len(first))
+	for column := range first {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	index := map[string]int{}
+	for i, column := range columns {
+		index[column] = i
+	}
+
+	return &ndjsonReader{decoder: decoder, columns: columns, index: index, first: first}, nil
+}
+
+func (r *ndjsonReader) Columns() []string {
+	return r.columns
+}
+
+// Return the values of the next object, in column order. Keys missing from
+// the object are set to NULL.
+func (r *ndjsonReader) Next() ([]interface{}, error) {
+	object := r.first
+	r.first = nil
+	if object == nil {
+		if r.columns == nil {
+			return nil, io.EOF
+		}
+		object = map[string]interface{}{}
+		if err := r.decoder.Decode(&object); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([]interface{}, len(r.columns))
+	for key, value := range object {
+		i, ok := r.index[key]
+		if !ok {
+			return nil, fmt.Errorf("unexpected key %q not present in the first record", key)
+		}
+		converted, err := convertJSON(value)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = converted
+	}
+
+	return values, nil
+}
+
+// Convert a decoded JSON value to a value that can be inserted.
+func convertJSON(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n, nil
+		}
+		return value.Float64()
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+	return value, nil
+}
@@ -2,9 +2,14 @@ package client
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/canonical/go-dqlite/internal/tracing"
+	"github.com/canonical/go-dqlite/metrics"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // DialFunc is a function that can be used to establish a network connection.
@@ -12,15 +17,30 @@ type DialFunc = protocol.DialFunc
 
 // Client speaks the dqlite wire protocol.
 type Client struct {
-	protocol *protocol.Protocol
+	protocol      *protocol.Protocol
+	database      string // Name of the database opened by Query, if any.
+	db            uint32 // ID of the database opened by Query.
+	bufferSize    int    // Initial size of the message buffers of cursors.
+	maxBufferSize int    // Maximum size of the message buffers of cursors.
+	limits        protocol.ResultLimits
+	options       []Option // Used to connect to other nodes.
 }
 
 // Option that can be used to tweak client parameters.
 type Option func(*options)
 
 type options struct {
-	DialFunc DialFunc
-	LogFunc  LogFunc
+	DialFunc          DialFunc
+	LogFunc           LogFunc
+	Metrics           *metrics.Metrics
+	Tracer            trace.Tracer
+	AuthToken         string
+	ReadOnly          bool
+	BufferSize        int
+	MaxBufferSize     int
+	LeaderProbes      int
+	ResultLimits      protocol.ResultLimits
+	HeartbeatInterval time.Duration
 }
 
 // WithDialFunc sets a custom dial function for creating the client network
@@ -39,6 +59,72 @@ func WithLogFunc(log LogFunc) Option {
 	}
 }
 
+// WithMetrics sets the collectors used to record the latency of requests.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(options *options) {
+		options.Metrics = m
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry provider used to create a span
+// for each request.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(options *options) {
+		options.Tracer = tracing.Tracer(provider)
+	}
+}
+
+// WithAuthToken sets a token to present to nodes right after the protocol
+// handshake, as required by App nodes configured with app.WithAuthToken or
+// app.WithAuthenticator.
+func WithAuthToken(token string) Option {
+	return func(options *options) {
+		options.AuthToken = token
+	}
+}
+
+// WithReadOnly makes the client ask the node to reject any request that
+// might modify data. It requires App nodes, whose proxy enforces it:
+// connecting to other nodes fails.
+func WithReadOnly() Option {
+	return func(options *options) {
+		options.ReadOnly = true
+	}
+}
+
+// WithBufferSizes sets the initial size of the buffers used by cursors
+// returned by Client.Query to encode requests and decode responses, which by
+// default is 4096 bytes, and the maximum size they can grow to, which by
+// default is unlimited. Fetching rows whose response exceeds the maximum size
+// fails with ErrMessageTooLarge and closes the connection.
+func WithBufferSizes(initial, max int) Option {
+	return func(options *options) {
+		options.BufferSize = initial
+		options.MaxBufferSize = max
+	}
+}
+
+// WithLeaderProbes makes FindLeader probe up to n nodes of the store
+// concurrently, in the order of the store, and cancel the other probes as
+// soon as one of them connects to the leader. This way the time to find the
+// leader depends on the fastest healthy node rather than on the timeouts of
+// the unreachable ones. By default, nodes are probed one at a time.
+func WithLeaderProbes(n int) Option {
+	return func(options *options) {
+		options.LeaderProbes = n
+	}
+}
+
+// WithResultLimits sets the maximum number of rows and of bytes of the result
+// of a query run with Query or Resume. Zero means no limit, which is the
+// default. When a limit is exceeded, Cursor.Fetch fails with a
+// ResultLimitError.
+func WithResultLimits(rows, bytes int64) Option {
+	return func(options *options) {
+		options.ResultLimits = protocol.ResultLimits{Rows: rows, Bytes: bytes}
+	}
+}
+
 // New creates a new client connected to the dqlite node with the given
 // address.
 func New(ctx context.Context, address string, options ...Option) (*Client, error) {
@@ -53,13 +139,38 @@ func New(ctx context.Context, address string, options ...Option) (*Client, error
 		return nil, errors.Wrap(err, "failed to establish network connection")
 	}
 
-	protocol, err := protocol.Handshake(ctx, conn, protocol.VersionOne)
+	p, err := protocol.Handshake(ctx, conn, protocol.VersionOne)
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
 
-	client := &Client{protocol: protocol}
+	if o.AuthToken != "" {
+		if err := protocol.Authenticate(ctx, p, o.AuthToken); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+
+	if o.ReadOnly {
+		if err := protocol.SetReadOnly(ctx, p); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+
+	if o.Metrics != nil {
+		p.SetObserver(o.Metrics.ObserveRequest)
+	}
+	p.SetTracer(o.Tracer)
+
+	client := &Client{
+		protocol:      p,
+		bufferSize:    protocol.BufferSize(o.BufferSize),
+		maxBufferSize: o.MaxBufferSize,
+		limits:        o.ResultLimits,
+		options:       options,
+	}
 
 	return client, nil
 }
@@ -119,31 +230,20 @@ type File struct {
 // the database), the second is the WAL file (which has the same name as the
 // database plus the suffix "-wal").
 func (c *Client) Dump(ctx context.Context, dbname string) ([]File, error) {
-	request := protocol.Message{}
-	request.Init(16)
-	response := protocol.Message{}
-	response.Init(512)
-
-	protocol.EncodeDump(&request, dbname)
-
-	if err := c.protocol.Call(ctx, &request, &response); err != nil {
-		return nil, errors.Wrap(err, "failed to send dump request")
-	}
-
-	files, err := protocol.DecodeFiles(&response)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse files response")
-	}
-	defer files.Close()
-
 	dump := make([]File, 0)
 
-	for {
-		name, data := files.Next()
-		if name == "" {
-			break
+	// Stream the files rather than decoding them from a response buffer,
+	// so that their content is held in memory only once.
+	err := c.StreamDump(ctx, dbname, func(name string, offset, size uint64, r io.Reader) error {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
 		}
 		dump = append(dump, File{Name: name, Data: data})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return dump, nil
@@ -257,7 +357,8 @@ func (c *Client) Close() error {
 // Create a client options object with sane defaults.
 func defaultOptions() *options {
 	return &options{
-		DialFunc: DefaultDialFunc,
-		LogFunc:  DefaultLogFunc,
+		DialFunc:          DefaultDialFunc,
+		LogFunc:           DefaultLogFunc,
+		HeartbeatInterval: 250 * time.Millisecond,
 	}
 }
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/canonical/go-dqlite/internal/dbfile"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+// Maximum number of times the databases of a node are dumped, when its raft
+// log grows while doing so.
+const consistencyAttempts = 3
+
+// NodeConsistency is the state of a node, as checked by VerifyConsistency.
+type NodeConsistency struct {
+	Node      NodeInfo
+	LastIndex uint64            // Index of the last raft log entry, or 0 if unknown.
+	LastTerm  uint64            // Term of the last raft log entry, or 0 if unknown.
+	Checksums map[string]string // SHA-256 of the content of each database, in hex.
+	Error     string            // Set if the node could not be checked.
+}
+
+// ConsistencyReport is the outcome of VerifyConsistency.
+type ConsistencyReport struct {
+	Nodes       []NodeConsistency
+	Divergences []string // Description of each inconsistency found.
+}
+
+// Consistent returns true if no divergence was found between the nodes that
+// could be checked.
+func (r *ConsistencyReport) Consistent() bool {
+	return len(r.Divergences) == 0
+}
+
+// VerifyConsistency checks that all the nodes of the cluster hold the same
+// data, for troubleshooting suspected replication issues. It must be called
+// on a client connected to the leader.
+//
+// Each node reports the index and term of the last entry of its raft log, and
+// the given databases are dumped from it in order to compute checksums of
+// their content. Nodes with the same last index must have the same last term
+// and the same checksums, or else a divergence is reported. Nodes behind the
+// others are not reported as divergent, so checks are more thorough when
+// writes are paused.
+//
+// The last log entry is reported by the proxy of App nodes. Other nodes have
+// an unknown index, and differences between them might be due to
+// replication lag.
+func (c *Client) VerifyConsistency(ctx context.Context, databases ...string) (*ConsistencyReport, error) {
+	nodes, err := c.Cluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ConsistencyReport{Nodes: make([]NodeConsistency, len(nodes))}
+
+	wg := sync.WaitGroup{}
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node NodeInfo) {
+			defer wg.Done()
+			report.Nodes[i] = c.checkNode(ctx, node, databases)
+		}(i, node)
+	}
+	wg.Wait()
+
+	report.Divergences = compareNodes(report.Nodes, databases)
+
+	return report, nil
+}
+
+// Return the state of the given node.
+func (c *Client) checkNode(ctx context.Context, node NodeInfo, databases []string) NodeConsistency {
+	state := NodeConsistency{Node: node}
+
+	cli, err := New(ctx, node.Address, c.options...)
+	if err != nil {
+		state.Error = err.Error()
+		return state
+	}
+	defer cli.Close()
+
+	for i := 0; i < consistencyAttempts; i++ {
+		index, term, err := cli.lastLogEntry(ctx)
+		known := err == nil
+
+		state.Checksums, err = cli.checksums(ctx, databases)
+		if err != nil {
+			state.Error = err.Error()
+			return state
+		}
+		if !known {
+			return state
+		}
+
+		// Make sure the checksums match the index.
+		after, _, err := cli.lastLogEntry(ctx)
+		if err == nil && after == index {
+			state.LastIndex = index
+			state.LastTerm = term
+			return state
+		}
+	}
+
+	state.Error = "raft log kept growing while dumping databases"
+
+	return state
+}
+
+// Return the index and term of the last entry in the raft log of the node, as
+// reported by the proxy of App nodes.
+func (c *Client) lastLogEntry(ctx context.Context) (uint64, uint64, error) {
+	request := protocol.Message{}
+	request.Init(16)
+	response := protocol.Message{}
+	response.Init(64)
+
+	protocol.EncodeState(&request)
+
+	if err := c.protocol.Call(ctx, &request, &response); err != nil {
+		return 0, 0, errors.Wrap(err, "failed to send State request")
+	}
+
+	index, term, err := protocol.DecodeLastEntry(&response)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to parse LastEntry response")
+	}
+
+	return index, term, nil
+}
+
+// Return the checksums of the content of the given databases.
+func (c *Client) checksums(ctx context.Context, databases []string) (map[string]string, error) {
+	checksums := map[string]string{}
+
+	for _, database := range databases {
+		files, err := c.Dump(ctx, database)
+		if err != nil {
+			return nil, fmt.Errorf("dump %s: %w", database, err)
+		}
+
+		var db, wal []byte
+		for _, file := range files {
+			switch file.Name {
+			case database:
+				db = file.Data
+			case database + "-wal":
+				wal = file.Data
+			}
+		}
+
+		// Nodes checkpoint their WAL independently, so compare the
+		// checkpointed content.
+		image, err := dbfile.Checkpoint(db, wal)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %s: %w", database, err)
+		}
+
+		sum := sha256.Sum256(image)
+		checksums[database] = hex.EncodeToString(sum[:])
+	}
+
+	return checksums, nil
+}
+
+// Compare the nodes having the same last log index, ignoring the ones that
+// could not be checked.
+func compareNodes(nodes []NodeConsistency, databases []string) []string {
+	groups := map[uint64][]NodeConsistency{}
+	indexes := []uint64{}
+	for _, node := range nodes {
+		if node.Error != "" {
+			continue
+		}
+		if _, ok := groups[node.LastIndex]; !ok {
+			indexes = append(indexes, node.LastIndex)
+		}
+		groups[node.LastIndex] = append(groups[node.LastIndex], node)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	divergences := []string{}
+	for _, index := range indexes {
+		at := fmt.Sprintf("at index %d", index)
+		if index == 0 {
+			at = "at unknown index"
+		}
+		first := groups[index][0]
+		for _, node := range groups[index][1:] {
+			if node.LastTerm != first.LastTerm {
+				divergences = append(divergences, fmt.Sprintf(
+					"raft log differs between nodes %d and %d %s: term %d vs %d",
+					first.Node.ID, node.Node.ID, at, first.LastTerm, node.LastTerm))
+			}
+			for _, database := range databases {
+				if node.Checksums[database] != first.Checksums[database] {
+					divergences = append(divergences, fmt.Sprintf(
+						"database %s differs between nodes %d and %d %s",
+						database, first.Node.ID, node.Node.ID, at))
+				}
+			}
+		}
+	}
+
+	return divergences
+}
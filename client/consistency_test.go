@@ -0,0 +1,83 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyConsistency(t *testing.T) {
+	servers := make([]*fakeserver.Server, 4)
+	nodes := make([]protocol.NodeInfo, len(servers))
+	for i := range servers {
+		servers[i] = newNearestServer(t, uint64(i+1))
+		nodes[i] = protocol.NodeInfo{ID: uint64(i + 1), Address: servers[i].Address()}
+	}
+	for _, server := range servers {
+		server.SetCluster(nodes)
+	}
+
+	image := make([]byte, 512)
+	corrupted := make([]byte, 512)
+	corrupted[300] = 1
+
+	// Nodes 1 and 2 are in sync, node 3 diverged and node 4 lags behind.
+	servers[0].SetLastLogEntry(10, 2)
+	servers[0].SetDump("test", []fakeserver.File{{Name: "test", Data: image}})
+	servers[1].SetLastLogEntry(10, 2)
+	servers[1].SetDump("test", []fakeserver.File{{Name: "test", Data: image}})
+	servers[2].SetLastLogEntry(10, 2)
+	servers[2].SetDump("test", []fakeserver.File{{Name: "test", Data: corrupted}})
+	servers[3].SetLastLogEntry(8, 2)
+	servers[3].SetDump("test", []fakeserver.File{{Name: "test", Data: corrupted}})
+
+	ctx := context.Background()
+
+	cli, err := client.New(ctx, servers[0].Address())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	report, err := cli.VerifyConsistency(ctx, "test")
+	require.NoError(t, err)
+
+	require.Len(t, report.Nodes, 4)
+	for i, node := range report.Nodes {
+		assert.Equal(t, nodes[i].ID, node.Node.ID)
+		assert.Empty(t, node.Error)
+	}
+	assert.Equal(t, uint64(8), report.Nodes[3].LastIndex)
+	assert.Equal(t, report.Nodes[0].Checksums, report.Nodes[1].Checksums)
+	assert.False(t, report.Consistent())
+	assert.Equal(t, []string{"database test differs between nodes 1 and 3 at index 10"}, report.Divergences)
+
+	// A diverged raft log is reported even if the content matches.
+	servers[2].SetDump("test", []fakeserver.File{{Name: "test", Data: image}})
+	servers[2].SetLastLogEntry(10, 3)
+
+	report, err = cli.VerifyConsistency(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"raft log differs between nodes 1 and 3 at index 10: term 2 vs 3"}, report.Divergences)
+}
+
+func TestVerifyConsistency_UnknownIndex(t *testing.T) {
+	server := newNearestServer(t, 1)
+	server.SetDump("test", []fakeserver.File{{Name: "test", Data: make([]byte, 512)}})
+
+	ctx := context.Background()
+
+	cli, err := client.New(ctx, server.Address())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	report, err := cli.VerifyConsistency(ctx, "test")
+	require.NoError(t, err)
+	require.Len(t, report.Nodes, 1)
+	assert.Equal(t, uint64(0), report.Nodes[0].LastIndex)
+	assert.Len(t, report.Nodes[0].Checksums["test"], 64)
+	assert.True(t, report.Consistent())
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+)
+
+// ErrBadPageToken is returned when resuming a query from a page token that
+// is malformed or was issued for a different query.
+var ErrBadPageToken = protocol.ErrBadPageToken
+
+// ErrMessageTooLarge is returned when a request or response doesn't fit in the
+// maximum buffer size set with WithBufferSizes.
+var ErrMessageTooLarge = protocol.ErrMessageTooLarge
+
+// ResultLimitError is returned by Cursor.Fetch when the result set exceeds
+// the limits set with WithResultLimits or ContextWithResultLimits.
+type ResultLimitError = protocol.ResultLimitError
+
+// ContextWithResultLimits returns a copy of the given context that overrides
+// the limits set with WithResultLimits for the queries started with it. Zero
+// means no limit.
+func ContextWithResultLimits(ctx context.Context, rows, bytes int64) context.Context {
+	return protocol.WithResultLimits(ctx, protocol.ResultLimits{Rows: rows, Bytes: bytes})
+}
+
+// Cursor walks the result set of a query a page at a time.
+//
+// The node streams large result sets in multiple responses, and sends the
+// next one only when the client asks for it, so memory usage is bounded by the
+// size of a page, independently of the size of the result set. The client
+// connection is dedicated to the cursor until it's closed.
+type Cursor struct {
+	protocol *protocol.Protocol
+	request  protocol.Message
+	response protocol.Message
+	rows     protocol.Rows
+	query    string // Query as given by the user, used for tokens.
+	offset   uint64 // Number of rows preceding the next one.
+	done     bool   // Whether the whole result set was consumed.
+	closed   bool
+	counter  protocol.ResultCounter
+}
+
+// Query runs the given query against the given database on the node the
+// client is connected to, which must be the leader, returning a cursor over
+// its result set.
+//
+// The connection can only be used to open a single database, so all queries
+// made by a client must target the same one.
+func (c *Client) Query(ctx context.Context, database string, query string, args ...interface{}) (*Cursor, error) {
+	return c.Resume(ctx, database, "", query, args...)
+}
+
+// Resume is like Query, but it skips the rows preceding the position
+// identified by the given token, as returned by Cursor.Token, typically by a
+// cursor of a previous client connection. An empty token starts from the
+// first row.
+//
+// Rows are skipped by re-running the query, so the query should have an
+// ORDER BY clause, and rows inserted or deleted in the meantime shift the
+// position.
+func (c *Client) Resume(ctx context.Context, database string, token string, query string, args ...interface{}) (*Cursor, error) {
+	offset := uint64(0)
+	sql := query
+	if token != "" {
+		var err error
+		offset, err = protocol.DecodePageToken(query, token)
+		if err != nil {
+			return nil, err
+		}
+		sql = protocol.PageQuery(query, offset, -1)
+	}
+
+	db, err := c.open(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(protocol.NamedValues, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+
+	cursor := &Cursor{
+		protocol: c.protocol,
+		query:    query,
+		offset:   offset,
+		counter:  protocol.ResultCounter{Limits: protocol.ResultLimitsFromContext(ctx, c.limits)},
+	}
+	cursor.request.Init(c.bufferSize)
+	cursor.request.SetMaxBufferSize(c.maxBufferSize)
+	cursor.response.Init(c.bufferSize)
+	cursor.response.SetMaxBufferSize(c.maxBufferSize)
+
+	protocol.EncodeQuerySQL(&cursor.request, uint64(db), sql, values)
+
+	if err := c.protocol.Call(ctx, &cursor.request, &cursor.response); err != nil {
+		return nil, err
+	}
+
+	cursor.rows, err = protocol.DecodeRows(&cursor.response)
+	if err != nil {
+		return nil, err
+	}
+
+	return cursor, nil
+}
+
+// Open the given database, unless it was already opened.
+func (c *Client) open(ctx context.Context, database string) (uint32, error) {
+	if c.database != "" {
+		if database != c.database {
+			return 0, fmt.Errorf("database %q already open", c.database)
+		}
+		return c.db, nil
+	}
+
+	request := protocol.Message{}
+	request.Init(64)
+	response := protocol.Message{}
+	response.Init(64)
+
+	protocol.EncodeOpen(&request, database, 0, "volatile")
+
+	if err := c.protocol.Call(ctx, &request, &response); err != nil {
+		return 0, err
+	}
+
+	db, err := protocol.DecodeDb(&response)
+	if err != nil {
+		return 0, err
+	}
+
+	c.database = database
+	c.db = db
+
+	return db, nil
+}
+
+// Columns returns the names of the columns of the result set.
+func (c *Cursor) Columns() []string {
+	return c.rows.Columns
+}
+
+// Fetch returns the next n rows of the result set, or less if it has fewer
+// rows left. It returns io.EOF if no row is left.
+func (c *Cursor) Fetch(ctx context.Context, n int) ([][]interface{}, error) {
+	if c.closed {
+		return nil, fmt.Errorf("cursor is closed")
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("invalid page size %d", n)
+	}
+	if c.done {
+		return nil, io.EOF
+	}
+
+	page := [][]interface{}{}
+	dest := make([]driver.Value, len(c.rows.Columns))
+
+	for len(page) < n {
+		err := c.rows.Next(dest)
+		if err == protocol.ErrRowsPart {
+			c.rows.Close()
+			if err := c.protocol.More(ctx, &c.response); err != nil {
+				return nil, err
+			}
+			rows, err := protocol.DecodeRows(&c.response)
+			if err != nil {
+				return nil, err
+			}
+			rows.Reuse(&c.rows)
+			c.rows = rows
+			continue
+		}
+		if err == io.EOF {
+			c.done = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := c.counter.Add(dest); err != nil {
+			return nil, err
+		}
+
+		// Blobs point into the response buffer, which gets reused.
+		row := make([]interface{}, len(dest))
+		for i, value := range dest {
+			if blob, ok := value.([]byte); ok {
+				copied := make([]byte, len(blob))
+				copy(copied, blob)
+				value = copied
+			}
+			row[i] = value
+		}
+		page = append(page, row)
+		c.offset++
+	}
+
+	if len(page) == 0 {
+		return nil, io.EOF
+	}
+
+	return page, nil
+}
+
+// Token returns an opaque token identifying the position of the cursor in the
+// result set, which can be passed to Client.Resume to get a cursor starting
+// from the same position.
+func (c *Cursor) Token() string {
+	return protocol.EncodePageToken(c.query, c.offset)
+}
+
+// Close the cursor, interrupting the query if rows are left, so that the
+// client can be used again.
+func (c *Cursor) Close(ctx context.Context) error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	err := c.rows.Close()
+
+	// There's no pending response if the whole result set was consumed,
+	// or if it fit in a single response.
+	if c.done || err == io.EOF {
+		return nil
+	}
+
+	return c.protocol.Interrupt(ctx, &c.request, &c.response)
+}
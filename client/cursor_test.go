@@ -0,0 +1,102 @@
+package client_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Fetch a result set a page at a time, then resume it with a new client.
+func TestCursor(t *testing.T) {
+	server, err := fakeserver.New(1)
+	require.NoError(t, err)
+	defer server.Close()
+
+	query := "SELECT n FROM t ORDER BY n"
+	values := [][]driver.Value{}
+	for i := int64(0); i < 5; i++ {
+		values = append(values, []driver.Value{i})
+	}
+	server.SetQuery(query, fakeserver.Rows{Columns: []string{"n"}, Values: values})
+	server.SetQuery(protocol.PageQuery(query, 2, -1), fakeserver.Rows{Columns: []string{"n"}, Values: values[2:]})
+
+	ctx := context.Background()
+
+	cli, err := client.New(ctx, server.Address())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	cursor, err := cli.Query(ctx, "test", query)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"n"}, cursor.Columns())
+
+	page, err := cursor.Fetch(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{int64(0)}, {int64(1)}}, page)
+
+	token := cursor.Token()
+	require.NoError(t, cursor.Close(ctx))
+
+	cli, err = client.New(ctx, server.Address())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	cursor, err = cli.Resume(ctx, "test", token, query)
+	require.NoError(t, err)
+
+	page, err = cursor.Fetch(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{int64(2)}, {int64(3)}}, page)
+
+	page, err = cursor.Fetch(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{int64(4)}}, page)
+
+	_, err = cursor.Fetch(ctx, 2)
+	assert.Equal(t, io.EOF, err)
+
+	require.NoError(t, cursor.Close(ctx))
+
+	_, err = cli.Query(ctx, "other", query)
+	assert.EqualError(t, err, `database "test" already open`)
+}
+
+// Fetching fails once the result set exceeds the configured limits, which a
+// context can override.
+func TestCursor_ResultLimits(t *testing.T) {
+	server, err := fakeserver.New(1)
+	require.NoError(t, err)
+	defer server.Close()
+
+	query := "SELECT n FROM t"
+	values := [][]driver.Value{{int64(0)}, {int64(1)}, {int64(2)}}
+	server.SetQuery(query, fakeserver.Rows{Columns: []string{"n"}, Values: values})
+
+	ctx := context.Background()
+
+	cli, err := client.New(ctx, server.Address(), client.WithResultLimits(2, 0))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	cursor, err := cli.Query(ctx, "test", query)
+	require.NoError(t, err)
+
+	_, err = cursor.Fetch(ctx, 3)
+	assert.Equal(t, client.ResultLimitError{Rows: 2}, err)
+	require.NoError(t, cursor.Close(ctx))
+
+	cursor, err = cli.Query(client.ContextWithResultLimits(ctx, 0, 0), "test", query)
+	require.NoError(t, err)
+
+	page, err := cursor.Fetch(ctx, 3)
+	require.NoError(t, err)
+	assert.Len(t, page, 3)
+	require.NoError(t, cursor.Close(ctx))
+}
@@ -0,0 +1,271 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+// ErrDumpChanged is returned when resuming a dump whose content changed since
+// the checkpoint it's resumed from was taken.
+var ErrDumpChanged = fmt.Errorf("database changed since the dump checkpoint")
+
+// DumpFunc is called by StreamDump for each file of a database dump, with a
+// reader of its content starting at the given offset, which is zero unless
+// the dump is resumed from a checkpoint.
+type DumpFunc func(name string, offset, size uint64, r io.Reader) error
+
+// DumpCheckpoint identifies the position of a streamed dump, in terms of the
+// number of bytes of file content read by the DumpFunc.
+type DumpCheckpoint struct {
+	Offset uint64 // Bytes of file content read so far.
+	Sum    []byte // SHA-256 of the names and content read so far.
+}
+
+// DumpOption can be used to tweak StreamDump parameters.
+type DumpOption func(*dumpOptions)
+
+type dumpOptions struct {
+	ChunkSize int
+	Chunks    int
+	Resume    *DumpCheckpoint
+	Progress  func(DumpCheckpoint)
+}
+
+// WithDumpChunks sets the size of the chunks in which the dump is read from the
+// network, and how many of them can be buffered waiting for the DumpFunc to
+// consume them. By default, 4 chunks of 64 KiB.
+func WithDumpChunks(n, size int) DumpOption {
+	return func(options *dumpOptions) {
+		options.Chunks = n
+		options.ChunkSize = size
+	}
+}
+
+// WithDumpResume makes StreamDump skip the content preceding the given
+// checkpoint, typically reported by the progress function of a previous
+// dump interrupted by a network failure. The skipped content is checked
+// against the checkpoint, and ErrDumpChanged is returned if it differs.
+func WithDumpResume(checkpoint DumpCheckpoint) DumpOption {
+	return func(options *dumpOptions) {
+		options.Resume = &checkpoint
+	}
+}
+
+// WithDumpProgress sets a function called with the current checkpoint each
+// time the DumpFunc reads a chunk of data.
+func WithDumpProgress(progress func(DumpCheckpoint)) DumpOption {
+	return func(options *dumpOptions) {
+		options.Progress = progress
+	}
+}
+
+// StreamDump is like Dump, but passes the content of each file to the given
+// function as it's received, instead of loading the whole dump in memory.
+//
+// Data is read from the network by a separate goroutine, with a bounded
+// number of chunks buffered. When the function doesn't keep up, the node is
+// slowed down by TCP flow control instead of data piling up in memory.
+func (c *Client) StreamDump(ctx context.Context, dbname string, fn DumpFunc, options ...DumpOption) error {
+	o := defaultDumpOptions()
+	for _, option := range options {
+		option(o)
+	}
+	if o.Chunks < 1 || o.ChunkSize < 1 {
+		return fmt.Errorf("invalid dump chunks %d of size %d", o.Chunks, o.ChunkSize)
+	}
+
+	request := protocol.Message{}
+	request.Init(16)
+	response := protocol.Message{}
+	response.Init(512)
+
+	protocol.EncodeDump(&request, dbname)
+
+	err := c.protocol.CallStream(ctx, &request, &response, func(body io.Reader) error {
+		chunks := newChunkReader(body, o.Chunks, o.ChunkSize)
+		defer chunks.Close()
+
+		stream := newDumpStream(o)
+		if err := protocol.StreamFiles(chunks, stream.file(fn)); err != nil {
+			return err
+		}
+		if err := stream.finish(); err != nil {
+			return err
+		}
+
+		// Consume the trailing padding, so that no read is pending.
+		_, err := io.Copy(ioutil.Discard, chunks)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to stream dump")
+	}
+
+	return nil
+}
+
+// Create a dump options object with sane defaults.
+func defaultDumpOptions() *dumpOptions {
+	return &dumpOptions{
+		ChunkSize: 64 * 1024,
+		Chunks:    4,
+		Progress:  func(DumpCheckpoint) {},
+	}
+}
+
+// Track the position of a streamed dump, skipping the content preceding the
+// checkpoint to resume from, if any.
+type dumpStream struct {
+	options *dumpOptions
+	hash    hash.Hash
+	offset  uint64
+}
+
+func newDumpStream(options *dumpOptions) *dumpStream {
+	return &dumpStream{options: options, hash: sha256.New()}
+}
+
+// Return a function handling a single file of the dump with the given
+// DumpFunc.
+func (s *dumpStream) file(fn DumpFunc) func(string, uint64, io.Reader) error {
+	return func(name string, size uint64, data io.Reader) error {
+		s.hash.Write([]byte(name))
+
+		// Skip the content already read before the checkpoint.
+		skipped := uint64(0)
+		if resume := s.options.Resume; resume != nil && s.offset < resume.Offset {
+			skipped = resume.Offset - s.offset
+			if skipped > size {
+				skipped = size
+			}
+			if _, err := io.CopyN(s.hash, data, int64(skipped)); err != nil {
+				return err
+			}
+			s.offset += skipped
+			if s.offset == resume.Offset && !bytes.Equal(s.hash.Sum(nil), resume.Sum) {
+				return ErrDumpChanged
+			}
+			if skipped == size {
+				return nil
+			}
+		}
+
+		return fn(name, skipped, size, &dumpReader{stream: s, r: data})
+	}
+}
+
+// Check that the dump reached the checkpoint it was resumed from.
+func (s *dumpStream) finish() error {
+	if resume := s.options.Resume; resume != nil && s.offset < resume.Offset {
+		return ErrDumpChanged
+	}
+	return nil
+}
+
+// Reader of the content of a file, updating the position of the stream.
+type dumpReader struct {
+	stream *dumpStream
+	r      io.Reader
+}
+
+func (r *dumpReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.stream.hash.Write(p[:n])
+		r.stream.offset += uint64(n)
+		r.stream.options.Progress(DumpCheckpoint{
+			Offset: r.stream.offset,
+			Sum:    r.stream.hash.Sum(nil),
+		})
+	}
+	return n, err
+}
+
+// Reader of data read ahead from another reader by a separate goroutine, in
+// chunks of bounded number and size.
+type chunkReader struct {
+	chunks  chan []byte
+	free    chan []byte
+	stop    chan struct{}
+	err     error // Error that stopped the goroutine, set before closing chunks.
+	current []byte
+	offset  int
+}
+
+func newChunkReader(r io.Reader, n, size int) *chunkReader {
+	c := &chunkReader{
+		chunks: make(chan []byte, n),
+		free:   make(chan []byte, n+1),
+		stop:   make(chan struct{}),
+	}
+	for i := 0; i < n+1; i++ {
+		c.free <- make([]byte, size)
+	}
+	go c.fill(r)
+	return c
+}
+
+// Read chunks until EOF or an error, waiting for a free buffer each time.
+func (c *chunkReader) fill(r io.Reader) {
+	defer close(c.chunks)
+	for {
+		var buf []byte
+		select {
+		case buf = <-c.free:
+		case <-c.stop:
+			return
+		}
+
+		n, err := io.ReadFull(r, buf[:cap(buf)])
+		if n > 0 {
+			select {
+			case c.chunks <- buf[:n]:
+			case <-c.stop:
+				return
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+		if err != nil {
+			c.err = err
+			return
+		}
+	}
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.offset == len(c.current) {
+		if c.current != nil {
+			c.free <- c.current
+			c.current = nil
+		}
+		chunk, ok := <-c.chunks
+		if !ok {
+			if c.err != nil {
+				return 0, c.err
+			}
+			return 0, io.EOF
+		}
+		c.current = chunk
+		c.offset = 0
+	}
+
+	n := copy(p, c.current[c.offset:])
+	c.offset += n
+
+	return n, nil
+}
+
+// Close stops the goroutine reading chunks, if it's still running.
+func (c *chunkReader) Close() {
+	close(c.stop)
+}
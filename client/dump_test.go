@@ -0,0 +1,192 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Files are streamed in small chunks and match the ones returned by Dump.
+func TestStreamDump(t *testing.T) {
+	server, cli := newDumpClient(t)
+	files := newDumpFiles(server)
+
+	ctx := context.Background()
+	dumped, err := cli.Dump(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, files, dumped)
+
+	streamed := []client.File{}
+	err = cli.StreamDump(ctx, "test", func(name string, offset, size uint64, r io.Reader) error {
+		assert.Equal(t, uint64(0), offset)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Len(t, data, int(size))
+		streamed = append(streamed, client.File{Name: name, Data: data})
+		return nil
+	}, client.WithDumpChunks(2, 100))
+	require.NoError(t, err)
+	assert.Equal(t, files, streamed)
+
+	// The connection can still be used.
+	_, err = cli.Dump(ctx, "test")
+	assert.NoError(t, err)
+}
+
+// A dump interrupted by a failure can be resumed from its last checkpoint.
+func TestStreamDump_Resume(t *testing.T) {
+	server, cli := newDumpClient(t)
+	files := newDumpFiles(server)
+
+	ctx := context.Background()
+
+	// Fail after reading the first file and part of the second one.
+	var checkpoint client.DumpCheckpoint
+	failure := errors.New("boom")
+	buf := &bytes.Buffer{}
+	err := cli.StreamDump(ctx, "test", func(name string, offset, size uint64, r io.Reader) error {
+		if name == "test-wal" {
+			io.CopyN(buf, r, 1000)
+			return failure
+		}
+		_, err := io.Copy(buf, r)
+		return err
+	}, client.WithDumpProgress(func(c client.DumpCheckpoint) { checkpoint = c }))
+	assert.True(t, errors.Is(err, failure))
+	assert.Equal(t, uint64(5000), checkpoint.Offset)
+
+	// Resume with a new client.
+	cli, err = client.New(ctx, server.Address())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	err = cli.StreamDump(ctx, "test", func(name string, offset, size uint64, r io.Reader) error {
+		assert.Equal(t, "test-wal", name)
+		assert.Equal(t, uint64(1000), offset)
+		_, err := io.Copy(buf, r)
+		return err
+	}, client.WithDumpResume(checkpoint))
+	require.NoError(t, err)
+	assert.Equal(t, append(append([]byte{}, files[0].Data...), files[1].Data...), buf.Bytes())
+
+	// Resuming fails if the database changed in the meantime.
+	files[0].Data[0]++
+	server.SetDump("test", []fakeserver.File{{Name: "test", Data: files[0].Data}, {Name: "test-wal", Data: files[1].Data}})
+	err = cli.StreamDump(ctx, "test", func(name string, offset, size uint64, r io.Reader) error {
+		return nil
+	}, client.WithDumpResume(checkpoint))
+	assert.True(t, errors.Is(err, client.ErrDumpChanged))
+}
+
+// The goroutine reading the dump ahead exits when the function fails, even
+// if the node stopped sending data.
+func TestStreamDump_StalledFailure(t *testing.T) {
+	failure := errors.New("boom")
+	for i := 0; i < 20; i++ {
+		cli := newStalledDumpClient(t)
+
+		err := cli.StreamDump(context.Background(), "test", func(name string, offset, size uint64, r io.Reader) error {
+			return failure
+		}, client.WithDumpChunks(4, 8))
+		assert.True(t, errors.Is(err, failure))
+
+		for start := time.Now(); strings.Contains(goroutines(), "chunkReader).fill"); {
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("chunk reader goroutine did not exit")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func newDumpClient(t *testing.T) (*fakeserver.Server, *client.Client) {
+	server, err := fakeserver.New(1)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	cli, err := client.New(context.Background(), server.Address())
+	require.NoError(t, err)
+	t.Cleanup(func() { cli.Close() })
+
+	return server, cli
+}
+
+// Set a dump with a 4000 bytes database file and a 3000 bytes WAL.
+func newDumpFiles(server *fakeserver.Server) []client.File {
+	files := []client.File{
+		{Name: "test", Data: make([]byte, 4000)},
+		{Name: "test-wal", Data: make([]byte, 3000)},
+	}
+	for _, file := range files {
+		for i := range file.Data {
+			file.Data[i] = byte(i * 7)
+		}
+	}
+	server.SetDump("test", []fakeserver.File{
+		{Name: files[0].Name, Data: files[0].Data},
+		{Name: files[1].Name, Data: files[1].Data},
+	})
+	return files
+}
+
+// Return a client connected to a peer replying to a dump request with the
+// beginning of a 4000 bytes file, and then stalling.
+func newStalledDumpClient(t *testing.T) *client.Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		handshake := make([]byte, 8)
+		if _, err := io.ReadFull(conn, handshake); err != nil {
+			return
+		}
+		request := protocol.Message{}
+		request.Init(4096)
+		if err := protocol.ReadMessage(conn, &request); err != nil {
+			return
+		}
+
+		data := make([]byte, 8*1024)
+		binary.LittleEndian.PutUint32(data[0:], 1024)          // Words of the body.
+		data[4] = protocol.ResponseFiles                       // Type.
+		binary.LittleEndian.PutUint64(data[8:], 1)             // Number of files.
+		copy(data[16:], "test")                                // Name.
+		binary.LittleEndian.PutUint64(data[24:], uint64(4000)) // Size.
+		conn.Write(data[:32+64])
+
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	cli, err := client.New(context.Background(), listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { cli.Close() })
+
+	return cli
+}
+
+// Return the stacks of all goroutines.
+func goroutines() string {
+	buf := make([]byte, 1024*1024)
+	return string(buf[:runtime.Stack(buf, true)])
+}
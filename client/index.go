@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// Interval between the checks of WaitIndex.
+const waitIndexInterval = 100 * time.Millisecond
+
+// LastIndex returns the index of the last entry of the raft log of the node
+// the client is connected to. It requires App nodes, whose proxy reports it.
+//
+// The dqlite engine doesn't expose its commit and applied indexes, but they
+// closely follow the last index: on the leader, the last index matches the
+// commit index as soon as pending writes are committed, and followers apply
+// entries as soon as the leader notifies them that they are committed.
+func (c *Client) LastIndex(ctx context.Context) (uint64, error) {
+	index, _, err := c.lastLogEntry(ctx)
+	return index, err
+}
+
+// WaitIndex blocks until the raft log of the node the client is connected to
+// reaches the given index, for example the LastIndex of the leader, so that
+// traffic can be routed to the node once it has caught up.
+func (c *Client) WaitIndex(ctx context.Context, index uint64) error {
+	for {
+		last, err := c.LastIndex(ctx)
+		if err != nil {
+			return err
+		}
+		if last >= index {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(waitIndexInterval):
+		}
+	}
+}
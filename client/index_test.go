@@ -0,0 +1,52 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLastIndex(t *testing.T) {
+	server := newNearestServer(t, 1)
+	server.SetLastLogEntry(10, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	cli, err := client.New(ctx, server.Address())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	index, err := cli.LastIndex(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), index)
+
+	require.NoError(t, cli.WaitIndex(ctx, 10))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.SetLastLogEntry(12, 2)
+	}()
+	require.NoError(t, cli.WaitIndex(ctx, 12))
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+	assert.Equal(t, context.DeadlineExceeded, cli.WaitIndex(shortCtx, 20))
+}
+
+func TestLastIndex_NotSupported(t *testing.T) {
+	server := newNearestServer(t, 1)
+
+	ctx := context.Background()
+
+	cli, err := client.New(ctx, server.Address())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.LastIndex(ctx)
+	assert.Error(t, err)
+}
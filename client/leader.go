@@ -20,15 +20,28 @@ func FindLeader(ctx context.Context, store NodeStore, options ...Option) (*Clien
 	}
 
 	config := protocol.Config{
-		Dial: o.DialFunc,
+		Dial:      o.DialFunc,
+		Tracer:    o.Tracer,
+		AuthToken: o.AuthToken,
+		ReadOnly:  o.ReadOnly,
+		Probes:    o.LeaderProbes,
+	}
+	if o.Metrics != nil {
+		config.Observer = o.Metrics.ObserveRequest
 	}
 	connector := protocol.NewConnector(0, store, config, o.LogFunc)
-	protocol, err := connector.Connect(ctx)
+	p, err := connector.Connect(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	client := &Client{protocol: protocol}
+	client := &Client{
+		protocol:      p,
+		bufferSize:    protocol.BufferSize(o.BufferSize),
+		maxBufferSize: o.MaxBufferSize,
+		limits:        o.ResultLimits,
+		options:       options,
+	}
 
 	return client, nil
 }
@@ -1,6 +1,9 @@
 package client
 
 import (
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/clock"
 	"github.com/canonical/go-dqlite/internal/logging"
 )
 
@@ -21,3 +24,14 @@ const (
 
 // DefaultLogFunc doesn't emit any message.
 func DefaultLogFunc(l LogLevel, format string, a ...interface{}) {}
+
+// RateLimitLogFunc wraps the given log function so that repetitive messages,
+// i.e. messages with the same level and format string, are emitted at most
+// once per interval. Suppressed messages are reported with a summary at the
+// end of each interval, including the arguments of the last one.
+//
+// This is useful to avoid flooding the logs with the same warning while, for
+// example, the cluster has no leader for an extended period.
+func RateLimitLogFunc(log LogFunc, interval time.Duration) LogFunc {
+	return logging.RateLimit(log, interval, clock.Real)
+}
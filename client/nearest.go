@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyMonitor periodically measures the round-trip time to each node of a
+// store, in order to connect to the nearest healthy one for operations that
+// don't require the leader, such as Client.Leader and Client.Cluster.
+//
+// A node is healthy if it replied to the last measurement and knew the
+// current leader. Round-trip times are smoothed over several measurements, so
+// a single slow reply doesn't make the monitor switch node.
+type LatencyMonitor struct {
+	store    NodeStore
+	interval time.Duration
+	options  []Option
+	mu       sync.Mutex
+	nodes    map[string]*nodeLatency // Latencies by node address.
+}
+
+// Latency of a node, as last measured by a LatencyMonitor.
+type nodeLatency struct {
+	rtt     time.Duration // Smoothed round-trip time.
+	healthy bool          // Whether the last measurement succeeded.
+}
+
+// NewLatencyMonitor returns a monitor measuring the round-trip time to the
+// nodes of the given store every given interval, once Run is called. The
+// given options are used to connect to the nodes.
+func NewLatencyMonitor(store NodeStore, interval time.Duration, options ...Option) *LatencyMonitor {
+	return &LatencyMonitor{
+		store:    store,
+		interval: interval,
+		options:  options,
+		nodes:    map[string]*nodeLatency{},
+	}
+}
+
+// Run measures the round-trip times right away and then every interval, until
+// the given context is done.
+func (m *LatencyMonitor) Run(ctx context.Context) {
+	for {
+		m.Measure(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.interval):
+		}
+	}
+}
+
+// Measure the round-trip time to all the nodes of the store concurrently,
+// giving up on the ones that don't reply within the interval.
+func (m *LatencyMonitor) Measure(ctx context.Context) error {
+	nodes, err := m.store.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.interval)
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			rtt, err := m.ping(ctx, address)
+			m.record(address, rtt, err == nil)
+		}(node.Address)
+	}
+	wg.Wait()
+
+	// Forget the nodes removed from the store.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for address := range m.nodes {
+		if !containsAddress(nodes, address) {
+			delete(m.nodes, address)
+		}
+	}
+
+	return nil
+}
+
+// Latency returns the smoothed round-trip time to the node with the given
+// address, and whether it's healthy.
+func (m *LatencyMonitor) Latency(address string) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latency, ok := m.nodes[address]
+	if !ok {
+		return 0, false
+	}
+
+	return latency.rtt, latency.healthy
+}
+
+// Nearest returns a client connected to the healthy node with the lowest
+// round-trip time. Nodes that were not measured yet are tried next, and
+// unhealthy nodes last.
+func (m *LatencyMonitor) Nearest(ctx context.Context) (*Client, error) {
+	nodes, err := m.store.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes in store")
+	}
+
+	addresses := m.sort(nodes)
+
+	for _, address := range addresses {
+		var cli *Client
+		cli, err = New(ctx, address, m.options...)
+		if err == nil {
+			return cli, nil
+		}
+		m.record(address, 0, false)
+	}
+
+	return nil, err
+}
+
+// Return the addresses of the given nodes, sorted by preference.
+func (m *LatencyMonitor) sort(nodes []NodeInfo) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Rank healthy nodes first, then unmeasured ones, then unhealthy ones.
+	rank := func(address string) int {
+		latency, ok := m.nodes[address]
+		switch {
+		case !ok:
+			return 1
+		case latency.healthy:
+			return 0
+		default:
+			return 2
+		}
+	}
+
+	addresses := make([]string, len(nodes))
+	for i, node := range nodes {
+		addresses[i] = node.Address
+	}
+
+	sort.SliceStable(addresses, func(i, j int) bool {
+		ri, rj := rank(addresses[i]), rank(addresses[j])
+		if ri != rj {
+			return ri < rj
+		}
+		if ri != 0 {
+			return false
+		}
+		return m.nodes[addresses[i]].rtt < m.nodes[addresses[j]].rtt
+	})
+
+	return addresses
+}
+
+// Measure the round-trip time of a leader request to the node with the given
+// address, failing if it doesn't know the leader.
+func (m *LatencyMonitor) ping(ctx context.Context, address string) (time.Duration, error) {
+	cli, err := New(ctx, address, m.options...)
+	if err != nil {
+		return 0, err
+	}
+	defer cli.Close()
+
+	start := time.Now()
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+
+	if leader.Address == "" {
+		return 0, fmt.Errorf("no known leader")
+	}
+
+	return rtt, nil
+}
+
+// Record the outcome of a measurement.
+func (m *LatencyMonitor) record(address string, rtt time.Duration, healthy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latency, ok := m.nodes[address]
+	if !ok {
+		m.nodes[address] = &nodeLatency{rtt: rtt, healthy: healthy}
+		return
+	}
+
+	latency.healthy = healthy
+	if !healthy {
+		latency.rtt = 0
+		return
+	}
+
+	// Exponentially weighted moving average, giving each new measurement a
+	// weight of 1/4. A node recovering from a failure starts afresh.
+	if latency.rtt == 0 {
+		latency.rtt = rtt
+	} else {
+		latency.rtt = (3*latency.rtt + rtt) / 4
+	}
+}
+
+func containsAddress(nodes []NodeInfo, address string) bool {
+	for _, node := range nodes {
+		if node.Address == address {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package client_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The monitor connects to the healthy node with the lowest round-trip time.
+func TestLatencyMonitor(t *testing.T) {
+	far := newNearestServer(t, 1)
+	near := newNearestServer(t, 2)
+	isolated := newNearestServer(t, 3)
+	isolated.SetLeader(&client.NodeInfo{})
+
+	// Delay the replies of the far node.
+	dial := func(ctx context.Context, address string) (net.Conn, error) {
+		conn, err := client.DefaultDialFunc(ctx, address)
+		if err != nil || address != far.Address() {
+			return conn, err
+		}
+		return &slowConn{Conn: conn, delay: 50 * time.Millisecond}, nil
+	}
+
+	store := client.NewInmemNodeStore()
+	store.Set(context.Background(), []client.NodeInfo{
+		{ID: 1, Address: far.Address()},
+		{ID: 3, Address: isolated.Address()},
+		{ID: 2, Address: near.Address()},
+	})
+
+	monitor := client.NewLatencyMonitor(store, time.Second, client.WithDialFunc(dial))
+
+	ctx := context.Background()
+	require.NoError(t, monitor.Measure(ctx))
+
+	rttFar, healthy := monitor.Latency(far.Address())
+	assert.True(t, healthy)
+	rttNear, healthy := monitor.Latency(near.Address())
+	assert.True(t, healthy)
+	assert.Less(t, int64(rttNear), int64(rttFar))
+	_, healthy = monitor.Latency(isolated.Address())
+	assert.False(t, healthy)
+
+	cli, err := monitor.Nearest(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.Cluster(ctx)
+	require.NoError(t, err)
+
+	for _, server := range []*fakeserver.Server{far, near, isolated} {
+		n := 0
+		for _, request := range server.Requests() {
+			if request.Type == protocol.RequestCluster {
+				n++
+			}
+		}
+		if server == near {
+			assert.Equal(t, 1, n)
+		} else {
+			assert.Equal(t, 0, n)
+		}
+	}
+}
+
+func newNearestServer(t *testing.T, id uint64) *fakeserver.Server {
+	t.Helper()
+	server, err := fakeserver.New(id)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// Connection delaying every read.
+type slowConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *slowConn) Read(b []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Read(b)
+}
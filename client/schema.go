@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+)
+
+// Query listing the schema objects of a database, tables first, in a stable
+// order so that dumps of the same schema are identical.
+const schemaQuery = `SELECT name, type, sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY CASE type WHEN 'table' THEN 0 ELSE 1 END, name`
+
+// DumpSchema returns the schema of the given database as SQL text, followed
+// by INSERT statements for all the rows of the given tables, if any.
+//
+// Unlike Dump, which returns the binary database and WAL files, the result is
+// meant to be reviewed or compared across environments, and can be replayed
+// with the dqlite shell to recreate the database.
+func (c *Client) DumpSchema(ctx context.Context, database string, tables ...string) (string, error) {
+	objects, err := c.queryAll(ctx, database, schemaQuery)
+	if err != nil {
+		return "", fmt.Errorf("query schema: %w", err)
+	}
+
+	known := map[string]bool{}
+	for _, object := range objects {
+		if object[1] == "table" {
+			known[fmt.Sprintf("%v", object[0])] = true
+		}
+	}
+	for _, table := range tables {
+		if !known[table] {
+			return "", fmt.Errorf("no such table: %s", table)
+		}
+	}
+
+	lines := []string{"BEGIN TRANSACTION;"}
+	for _, object := range objects {
+		lines = append(lines, fmt.Sprintf("%v;", object[2]))
+	}
+
+	for _, table := range tables {
+		rows, err := c.queryAll(ctx, database, "SELECT * FROM "+quoteIdentifier(table))
+		if err != nil {
+			return "", fmt.Errorf("query %s: %w", table, err)
+		}
+		for _, row := range rows {
+			values := make([]string, len(row))
+			for i, value := range row {
+				values[i] = quoteValue(value)
+			}
+			lines = append(lines, fmt.Sprintf(
+				"INSERT INTO %s VALUES(%s);", quoteIdentifier(table), strings.Join(values, ",")))
+		}
+	}
+	lines = append(lines, "COMMIT;")
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// Restore replays the given SQL text, typically returned by DumpSchema, on
+// the given database, which must be empty, for example because the cluster
+// was just created.
+//
+// The text is executed as a whole in a single request, so the transaction
+// statements written by DumpSchema make the restore atomic. The client must
+// be connected to the leader.
+func (c *Client) Restore(ctx context.Context, database string, sql string) error {
+	count, err := c.queryAll(ctx, database, "SELECT count(*) FROM sqlite_master")
+	if err != nil {
+		return fmt.Errorf("check database: %w", err)
+	}
+	if len(count) != 1 || count[0][0] != int64(0) {
+		return fmt.Errorf("database %s is not empty", database)
+	}
+
+	db, err := c.open(ctx, database)
+	if err != nil {
+		return err
+	}
+
+	request := protocol.Message{}
+	request.Init(protocol.BufferSize(len(sql) + 64))
+	response := protocol.Message{}
+	response.Init(64)
+
+	protocol.EncodeExecSQL(&request, uint64(db), sql, nil)
+
+	if err := c.protocol.Call(ctx, &request, &response); err != nil {
+		return fmt.Errorf("restore %s: %w", database, err)
+	}
+
+	if _, err := protocol.DecodeResult(&response); err != nil {
+		return fmt.Errorf("restore %s: %w", database, err)
+	}
+
+	return nil
+}
+
+// Run the given query and return all its rows.
+func (c *Client) queryAll(ctx context.Context, database string, query string) ([][]interface{}, error) {
+	cursor, err := c.Query(ctx, database, query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rows := [][]interface{}{}
+	for {
+		page, err := cursor.Fetch(ctx, 256)
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, page...)
+	}
+}
+
+// Quote an SQL identifier, doubling any embedded quote.
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// Render a value as a SQL literal.
+func quoteValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		return fmt.Sprintf("X'%X'", v)
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05.999999999-07:00") + "'"
+	default:
+		return "'" + strings.Replace(fmt.Sprintf("%v", v), "'", "''", -1) + "'"
+	}
+}
@@ -0,0 +1,90 @@
+package client_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpSchema(t *testing.T) {
+	server := newNearestServer(t, 1)
+	server.SetQuery(
+		"SELECT name, type, sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY CASE type WHEN 'table' THEN 0 ELSE 1 END, name",
+		fakeserver.Rows{
+			Columns: []string{"name", "type", "sql"},
+			Values: [][]driver.Value{
+				{"items", "table", "CREATE TABLE items (n INT)"},
+				{"kinds", "table", "CREATE TABLE kinds (id INT, name TEXT)"},
+				{"items_n", "index", "CREATE INDEX items_n ON items(n)"},
+			},
+		})
+	server.SetQuery(`SELECT * FROM "kinds"`, fakeserver.Rows{
+		Columns: []string{"id", "name"},
+		Values:  [][]driver.Value{{int64(1), "it's"}, {int64(2), nil}},
+	})
+
+	ctx := context.Background()
+
+	cli, err := client.New(ctx, server.Address())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	schema, err := cli.DumpSchema(ctx, "test", "kinds")
+	require.NoError(t, err)
+	assert.Equal(t, `BEGIN TRANSACTION;
+CREATE TABLE items (n INT);
+CREATE TABLE kinds (id INT, name TEXT);
+CREATE INDEX items_n ON items(n);
+INSERT INTO "kinds" VALUES(1,'it''s');
+INSERT INTO "kinds" VALUES(2,NULL);
+COMMIT;
+`, schema)
+
+	_, err = cli.DumpSchema(ctx, "test", "missing")
+	assert.EqualError(t, err, "no such table: missing")
+}
+
+func TestRestore(t *testing.T) {
+	server := newNearestServer(t, 1)
+	server.SetQuery("SELECT count(*) FROM sqlite_master", fakeserver.Rows{
+		Columns: []string{"count(*)"},
+		Values:  [][]driver.Value{{int64(0)}},
+	})
+
+	ctx := context.Background()
+
+	cli, err := client.New(ctx, server.Address())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	script := "BEGIN TRANSACTION;\nCREATE TABLE items (n INT);\nCOMMIT;\n"
+	require.NoError(t, cli.Restore(ctx, "test", script))
+
+	requests := server.Requests()
+	last := requests[len(requests)-1]
+	assert.Equal(t, uint8(protocol.RequestExecSQL), last.Type)
+	assert.Equal(t, script, last.SQL)
+}
+
+func TestRestore_NotEmpty(t *testing.T) {
+	server := newNearestServer(t, 1)
+	server.SetQuery("SELECT count(*) FROM sqlite_master", fakeserver.Rows{
+		Columns: []string{"count(*)"},
+		Values:  [][]driver.Value{{int64(1)}},
+	})
+
+	ctx := context.Background()
+
+	cli, err := client.New(ctx, server.Address())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	err = cli.Restore(ctx, "test", "CREATE TABLE items (n INT);")
+	assert.EqualError(t, err, "database test is not empty")
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
@@ -177,16 +178,46 @@ func (d *DatabaseNodeStore) Set(ctx context.Context, servers []NodeInfo) error {
 
 // Persists a list addresses of dqlite nodes in a YAML file.
 type YamlNodeStore struct {
-	path    string
-	servers []NodeInfo
-	mu      sync.RWMutex
+	path       string
+	servers    []NodeInfo
+	mu         sync.RWMutex
+	debounce   time.Duration // Delay of writes, if any.
+	pending    *time.Timer   // Scheduled write, if any.
+	err        error         // Error of the last scheduled write.
+	dirty      bool          // Whether servers differ from the file.
+	generation uint64        // Generation of the file.
+}
+
+// YamlNodeStoreOption can be used to tweak YamlNodeStore parameters.
+type YamlNodeStoreOption func(*yamlNodeStoreOptions)
+
+type yamlNodeStoreOptions struct {
+	Debounce time.Duration
+}
+
+// WithDebounce makes the store write the file at most once within the given
+// delay, with the latest servers set during it, instead of once for each
+// change. Pending changes can be written right away with Flush.
+//
+// Errors hit by delayed writes are returned by the next call to Set, and
+// failed writes are retried by the next call to Set or Flush.
+func WithDebounce(delay time.Duration) YamlNodeStoreOption {
+	return func(options *yamlNodeStoreOptions) {
+		options.Debounce = delay
+	}
 }
 
 // NewYamlNodeStore creates a new YamlNodeStore backed by the given YAML file.
-func NewYamlNodeStore(path string) (*YamlNodeStore, error) {
+func NewYamlNodeStore(path string, options ...YamlNodeStoreOption) (*YamlNodeStore, error) {
+	o := &yamlNodeStoreOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
 	servers := []NodeInfo{}
 
 	_, err := os.Stat(path)
+	exists := err == nil
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return nil, err
@@ -203,8 +234,10 @@ func NewYamlNodeStore(path string) (*YamlNodeStore, error) {
 	}
 
 	store := &YamlNodeStore{
-		path:    path,
-		servers: servers,
+		path:     path,
+		servers:  servers,
+		debounce: o.Debounce,
+		dirty:    !exists,
 	}
 
 	return store, nil
@@ -219,11 +252,79 @@ func (s *YamlNodeStore) Get(ctx context.Context) ([]NodeInfo, error) {
 }
 
 // Set the servers addresses.
+//
+// The file is not written if it already holds the given servers, and writes
+// are delayed if the store was created with WithDebounce.
 func (s *YamlNodeStore) Set(ctx context.Context, servers []NodeInfo) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	data, err := yaml.Marshal(servers)
+	if !s.dirty && sameNodes(s.servers, servers) {
+		return nil
+	}
+
+	s.servers = servers
+	s.dirty = true
+
+	if s.debounce == 0 {
+		return s.write()
+	}
+
+	if s.pending == nil {
+		s.pending = time.AfterFunc(s.debounce, s.flushPending)
+	}
+
+	// Report the failure of the last delayed write, if any.
+	err := s.err
+	s.err = nil
+
+	return err
+}
+
+// Flush writes any change delayed because of WithDebounce.
+func (s *YamlNodeStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending != nil {
+		s.pending.Stop()
+		s.pending = nil
+	}
+
+	// A failed delayed write left the store dirty, so it's retried.
+	s.err = nil
+
+	return s.write()
+}
+
+// Generation returns the generation of the servers last written to the file,
+// which is incremented each time a different list is written. It's zero if
+// the file has not been written since the store was created.
+func (s *YamlNodeStore) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.generation
+}
+
+// Write a change scheduled because of WithDebounce.
+func (s *YamlNodeStore) flushPending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = nil
+	if err := s.write(); err != nil {
+		s.err = err
+	}
+}
+
+// Write the current servers, if they differ from the file.
+func (s *YamlNodeStore) write() error {
+	if !s.dirty {
+		return nil
+	}
+
+	data, err := yaml.Marshal(s.servers)
 	if err != nil {
 		return err
 	}
@@ -232,7 +333,21 @@ func (s *YamlNodeStore) Set(ctx context.Context, servers []NodeInfo) error {
 		return err
 	}
 
-	s.servers = servers
+	s.dirty = false
+	s.generation++
 
 	return nil
 }
+
+// Return true if the given lists hold the same servers in the same order.
+func sameNodes(a, b []NodeInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -2,7 +2,11 @@ package client_test
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/canonical/go-dqlite/client"
 	"github.com/stretchr/testify/assert"
@@ -53,3 +57,70 @@ func TestDefaultNodeStore(t *testing.T) {
 		{ID: uint64(1), Address: "9.9.9.9:666"}},
 		servers)
 }
+
+// Setting the same servers again doesn't rewrite the YAML file.
+func TestYamlNodeStore_NoOpSet(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "cluster.yaml")
+	store, err := client.NewYamlNodeStore(path)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	servers := []client.NodeInfo{{ID: 1, Address: "1.2.3.4:666"}}
+
+	require.NoError(t, store.Set(ctx, servers))
+	assert.Equal(t, uint64(1), store.Generation())
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, store.Set(ctx, []client.NodeInfo{{ID: 1, Address: "1.2.3.4:666"}}))
+	assert.Equal(t, uint64(1), store.Generation())
+	assert.NoFileExists(t, path)
+
+	require.NoError(t, store.Set(ctx, []client.NodeInfo{{ID: 2, Address: "5.6.7.8:666"}}))
+	assert.Equal(t, uint64(2), store.Generation())
+
+	store, err = client.NewYamlNodeStore(path)
+	require.NoError(t, err)
+	servers, err = store.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []client.NodeInfo{{ID: 2, Address: "5.6.7.8:666"}}, servers)
+}
+
+// Bursts of changes are written at once when debouncing.
+func TestYamlNodeStore_Debounce(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "cluster.yaml")
+	store, err := client.NewYamlNodeStore(path, client.WithDebounce(50*time.Millisecond))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for i := uint64(1); i <= 5; i++ {
+		require.NoError(t, store.Set(ctx, []client.NodeInfo{{ID: i, Address: "1.2.3.4:666"}}))
+	}
+
+	// Changes are visible right away, but not written yet.
+	servers, err := store.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []client.NodeInfo{{ID: 5, Address: "1.2.3.4:666"}}, servers)
+	assert.Equal(t, uint64(0), store.Generation())
+	assert.NoFileExists(t, path)
+
+	assert.Eventually(t, func() bool { return store.Generation() == 1 }, time.Second, 10*time.Millisecond)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "ID: 5")
+
+	// Flush writes pending changes right away.
+	require.NoError(t, store.Set(ctx, []client.NodeInfo{{ID: 6, Address: "1.2.3.4:666"}}))
+	require.NoError(t, store.Flush())
+	assert.Equal(t, uint64(2), store.Generation())
+
+	// Nothing is left to write.
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, uint64(2), store.Generation())
+}
@@ -0,0 +1,166 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// PeerVerifier performs additional checks on the certificate presented by a
+// peer, after the standard TLS verification succeeded. The chains are the
+// verified certificate chains, if any, starting with the peer certificate.
+//
+// It can be used to implement certificate revocation, for example by
+// querying an OCSP responder, or any custom policy.
+type PeerVerifier func(cert *x509.Certificate, chains [][]*x509.Certificate) error
+
+// TLSConfigWithVerifier returns a copy of the given TLS config that also runs
+// the given verifier on the peer certificate. The config can be either a
+// server-side one, as used by the App proxy, or a client-side one, as passed
+// to DialFuncWithTLS. If the config has a GetConfigForClient callback, the
+// configs it returns also run the verifier.
+func TLSConfigWithVerifier(config *tls.Config, verify PeerVerifier) *tls.Config {
+	config = config.Clone()
+	if get := config.GetConfigForClient; get != nil {
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			config, err := get(hello)
+			if err != nil || config == nil {
+				return config, err
+			}
+			return TLSConfigWithVerifier(config, verify), nil
+		}
+	}
+	previous := config.VerifyPeerCertificate
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if previous != nil {
+			if err := previous(rawCerts, chains); err != nil {
+				return err
+			}
+		}
+		if len(rawCerts) == 0 {
+			// Client certificates are optional for this config.
+			return nil
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("parse peer certificate: %w", err)
+		}
+		return verify(cert, chains)
+	}
+	return config
+}
+
+// RevocationVerifier returns a PeerVerifier rejecting the certificates listed
+// in the given certificate revocation lists. The lists are trusted as given:
+// their signatures and expiration dates are not checked.
+func RevocationVerifier(crls ...*pkix.CertificateList) PeerVerifier {
+	return func(cert *x509.Certificate, chains [][]*x509.Certificate) error {
+		return checkRevoked(cert, crls)
+	}
+}
+
+// FileRevocationVerifier returns a PeerVerifier rejecting the certificates
+// listed in the certificate revocation lists in the given file, either PEM or
+// DER encoded. The file is read again whenever it's modified, so revoking a
+// certificate doesn't require restarting. If the file can't be loaded, all
+// certificates are rejected.
+func FileRevocationVerifier(path string) PeerVerifier {
+	f := &crlFile{path: path}
+	return func(cert *x509.Certificate, chains [][]*x509.Certificate) error {
+		crls, err := f.load()
+		if err != nil {
+			return fmt.Errorf("load revocation list: %w", err)
+		}
+		return checkRevoked(cert, crls)
+	}
+}
+
+// Return an error if the given certificate is listed in one of the given
+// revocation lists of its issuer.
+func checkRevoked(cert *x509.Certificate, crls []*pkix.CertificateList) error {
+	issuer := cert.Issuer.String()
+	for _, crl := range crls {
+		var name pkix.Name
+		name.FillFromRDNSequence(&crl.TBSCertList.Issuer)
+		if name.String() != issuer {
+			continue
+		}
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("certificate %s with serial %s is revoked", cert.Subject, cert.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
+
+// Revocation lists loaded from a file, reloaded when it changes.
+type crlFile struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	crls    []*pkix.CertificateList
+}
+
+func (f *crlFile) load() ([]*pkix.CertificateList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if f.crls != nil && info.ModTime().Equal(f.modTime) {
+		return f.crls, nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	crls, err := parseCRLs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	f.crls = crls
+	f.modTime = info.ModTime()
+
+	return crls, nil
+}
+
+// Parse the given PEM-encoded revocation lists, or a single DER-encoded one.
+func parseCRLs(data []byte) ([]*pkix.CertificateList, error) {
+	crls := []*pkix.CertificateList{}
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseDERCRL(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) > 0 {
+		return crls, nil
+	}
+
+	crl, err := x509.ParseDERCRL(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(crls, crl), nil
+}
@@ -0,0 +1,100 @@
+package client_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Certificates listed in a revocation list are rejected.
+func TestFileRevocationVerifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	newCert := func(serial int64) *x509.Certificate {
+		return &x509.Certificate{SerialNumber: big.NewInt(serial), Issuer: ca.Subject}
+	}
+
+	revoked := []pkix.RevokedCertificate{{SerialNumber: big.NewInt(2), RevocationTime: time.Now()}}
+	crl, err := ca.CreateCRL(rand.Reader, key, revoked, time.Now(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "dqlite-client-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "crl.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	verify := client.FileRevocationVerifier(path)
+
+	assert.NoError(t, verify(newCert(3), nil))
+	assert.Error(t, verify(newCert(2), nil))
+}
+
+// Configs returned by GetConfigForClient also run the verifier.
+func TestTLSConfigWithVerifier_GetConfigForClient(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "peer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+
+	inner := &tls.Config{ServerName: "inner"}
+	config := &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return inner, nil
+		},
+	}
+
+	verified := ""
+	verify := func(cert *x509.Certificate, chains [][]*x509.Certificate) error {
+		verified = cert.Subject.CommonName
+		return nil
+	}
+
+	config = client.TLSConfigWithVerifier(config, verify)
+
+	returned, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "inner", returned.ServerName)
+	assert.Nil(t, inner.VerifyPeerCertificate)
+
+	require.NoError(t, returned.VerifyPeerCertificate([][]byte{der}, nil))
+	assert.Equal(t, "peer", verified)
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// LeadershipEvent is emitted by WatchLeadership when the leader changes.
+type LeadershipEvent struct {
+	// The new leader, or the zero value if the connection to the leader
+	// was lost and no new leader was found yet.
+	Leader NodeInfo
+}
+
+// WithHeartbeatInterval sets how often WatchLeadership checks that the node
+// it's connected to is still the leader. A check taking longer than the
+// interval counts as a loss of the leader. The default is 250 milliseconds.
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(options *options) {
+		options.HeartbeatInterval = interval
+	}
+}
+
+// WatchLeadership keeps a connection to the leader of the cluster whose nodes
+// are in the given store, and returns a channel emitting an event with the
+// current leader, then one whenever the leader changes, so that load
+// balancers and proxies can quickly repoint write traffic after a failover.
+//
+// A change is detected either when the node stops reporting itself as leader
+// or when a heartbeat fails, in which case an event with no leader is emitted
+// before looking for the new one. The channel is closed when the given
+// context is done.
+func WatchLeadership(ctx context.Context, store NodeStore, options ...Option) (<-chan LeadershipEvent, error) {
+	o := defaultOptions()
+
+	for _, option := range options {
+		option(o)
+	}
+
+	cli, leader, err := connectLeader(ctx, store, options)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan LeadershipEvent, 1)
+	ch <- LeadershipEvent{Leader: leader}
+
+	go func() {
+		defer close(ch)
+
+		emit := func(event LeadershipEvent) bool {
+			select {
+			case ch <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				cli.Close()
+				return
+			case <-time.After(o.HeartbeatInterval):
+			}
+
+			heartbeatCtx, cancel := context.WithTimeout(ctx, o.HeartbeatInterval)
+			info, err := cli.Leader(heartbeatCtx)
+			cancel()
+			if err == nil && info.ID == leader.ID && info.Address == leader.Address {
+				continue
+			}
+
+			cli.Close()
+
+			if err != nil && ctx.Err() == nil {
+				o.LogFunc(LogDebug, "leadership heartbeat to %s failed: %v", leader.Address, err)
+				leader = NodeInfo{}
+				if !emit(LeadershipEvent{}) {
+					return
+				}
+			}
+
+			for {
+				var next NodeInfo
+				cli, next, err = connectLeader(ctx, store, options)
+				if err == nil {
+					if next != leader {
+						leader = next
+						if !emit(LeadershipEvent{Leader: leader}) {
+							cli.Close()
+							return
+						}
+					}
+					break
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(o.HeartbeatInterval):
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// Connect to the leader and return a client along with its information.
+func connectLeader(ctx context.Context, store NodeStore, options []Option) (*Client, NodeInfo, error) {
+	cli, err := FindLeader(ctx, store, options...)
+	if err != nil {
+		return nil, NodeInfo{}, err
+	}
+
+	info, err := cli.Leader(ctx)
+	if err != nil {
+		cli.Close()
+		return nil, NodeInfo{}, err
+	}
+
+	return cli, *info, nil
+}
@@ -0,0 +1,59 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An event is emitted for the current leader, then for the new one after a
+// failover.
+func TestWatchLeadership(t *testing.T) {
+	server1 := newNearestServer(t, 1)
+	server2 := newNearestServer(t, 2)
+	leader1 := client.NodeInfo{ID: 1, Address: server1.Address()}
+	leader2 := client.NodeInfo{ID: 2, Address: server2.Address()}
+	server2.SetLeader(&leader1)
+
+	store := client.NewInmemNodeStore()
+	store.Set(context.Background(), []client.NodeInfo{leader1, leader2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := client.WatchLeadership(ctx, store, client.WithHeartbeatInterval(10*time.Millisecond))
+	require.NoError(t, err)
+
+	assert.Equal(t, client.LeadershipEvent{Leader: leader1}, <-events)
+
+	server1.SetLeader(&leader2)
+	server2.SetLeader(nil)
+
+	select {
+	case event := <-events:
+		assert.Equal(t, client.LeadershipEvent{Leader: leader2}, event)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no leadership event")
+	}
+
+	// A lost connection is reported as a loss of the leader.
+	server2.Close()
+	server1.SetLeader(nil)
+
+	for _, expected := range []client.NodeInfo{{}, leader1} {
+		select {
+		case event := <-events:
+			assert.Equal(t, client.LeadershipEvent{Leader: expected}, event)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no leadership event")
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
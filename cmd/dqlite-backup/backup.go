@@ -0,0 +1,375 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/dbfile"
+)
+
+// Name of the checksums manifest included in every backup.
+const manifestName = "SHA256SUMS"
+
+// Layout of the timestamp in backup names, which sorts chronologically.
+const timestampLayout = "20060102T150405Z"
+
+const archiveExt = ".tar"
+
+// A single file of a backup, along with its checksum.
+type backupFile struct {
+	Name string
+	Data []byte
+	Sum  string
+}
+
+// Dump the given databases, checking that their WAL is consistent.
+func dump(ctx context.Context, cli *client.Client, databases []string) ([]backupFile, error) {
+	files := []backupFile{}
+	for _, database := range databases {
+		dumped, err := cli.Dump(ctx, database)
+		if err != nil {
+			return nil, fmt.Errorf("dump %s: %w", database, err)
+		}
+
+		var db, wal []byte
+		for _, file := range dumped {
+			switch file.Name {
+			case database:
+				db = file.Data
+			case database + "-wal":
+				wal = file.Data
+			}
+			files = append(files, backupFile{Name: file.Name, Data: file.Data, Sum: checksum(file.Data)})
+		}
+
+		if _, err := dbfile.Checkpoint(db, wal); err != nil {
+			return nil, fmt.Errorf("verify %s: %w", database, err)
+		}
+	}
+
+	return files, nil
+}
+
+// Dump the schema of the given databases as SQL text, along with the data of
+// the given tables, in a file named after each database with a .sql
+// extension.
+func dumpSchema(ctx context.Context, cli *client.Client, databases []string, tables []string) ([]backupFile, error) {
+	files := []backupFile{}
+	for _, database := range databases {
+		schema, err := cli.DumpSchema(ctx, database, tables...)
+		if err != nil {
+			return nil, fmt.Errorf("dump schema of %s: %w", database, err)
+		}
+		data := []byte(schema)
+		files = append(files, backupFile{Name: database + ".sql", Data: data, Sum: checksum(data)})
+	}
+
+	return files, nil
+}
+
+// Return the name of a new backup taken at the given time.
+func backupName(prefix string, now time.Time, archive bool) string {
+	name := prefix + "-" + now.UTC().Format(timestampLayout)
+	if archive {
+		name += archiveExt
+	}
+	return name
+}
+
+// Write a new backup in the given directory, verifying it once written. The
+// backup is first written under a temporary name and then renamed, so
+// partial backups are never visible. If c is not nil, all files are
+// encrypted.
+func writeBackup(dir string, name string, files []backupFile, archive bool, c *backupCipher) (string, error) {
+	path := filepath.Join(dir, name)
+	tmp := filepath.Join(dir, "."+name+".tmp")
+
+	entries, err := backupEntries(files, c)
+	if err != nil {
+		return "", err
+	}
+
+	if archive {
+		err = writeArchive(tmp, entries)
+	} else {
+		err = writeDir(tmp, entries)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+
+	sums, err := readManifest(tmp, c)
+	if err == nil {
+		err = verify(tmp, sums, c)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("verify backup: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// Return the entries of a backup of the given files: the files themselves
+// followed by the manifest, all encrypted if c is not nil.
+func backupEntries(files []backupFile, c *backupCipher) ([]backupFile, error) {
+	entries := make([]backupFile, 0, len(files)+1)
+	entries = append(entries, files...)
+	entries = append(entries, backupFile{Name: manifestName, Data: manifest(files)})
+	for i, entry := range entries {
+		data, err := c.seal(entry.Name, entry.Data)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt %s: %w", entry.Name, err)
+		}
+		entries[i].Data = data
+	}
+	return entries, nil
+}
+
+// Write the given entries, including the manifest, as files of a directory.
+func writeDir(path string, entries []backupFile) error {
+	if err := os.Mkdir(path, 0755); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := ioutil.WriteFile(filepath.Join(path, entry.Name), entry.Data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the given entries, including the manifest, to a tar archive.
+func writeArchive(path string, entries []backupFile) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeTar(f, entries); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// Write the given entries as a tar stream.
+func writeTar(out io.Writer, entries []backupFile) error {
+	w := tar.NewWriter(out)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:    entry.Name,
+			Mode:    0600,
+			Size:    int64(len(entry.Data)),
+			ModTime: time.Now(),
+		}
+		if err := w.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(entry.Data); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// Render the checksums manifest, in the format used by sha256sum.
+func manifest(files []backupFile) []byte {
+	lines := make([]string, len(files))
+	for i, file := range files {
+		lines[i] = fmt.Sprintf("%s  %s\n", file.Sum, file.Name)
+	}
+	return []byte(strings.Join(lines, ""))
+}
+
+// Read the checksums manifest of the backup at the given path.
+func readManifest(path string, c *backupCipher) (map[string]string, error) {
+	data, err := readBackupFile(path, manifestName, c)
+	if err != nil {
+		return nil, err
+	}
+
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed manifest line %q", scanner.Text())
+		}
+		sums[fields[1]] = fields[0]
+	}
+
+	return sums, nil
+}
+
+// Check that the files of the backup at the given path match the manifest.
+func verify(path string, sums map[string]string, c *backupCipher) error {
+	for name, sum := range sums {
+		data, err := readBackupFile(path, name, c)
+		if err != nil {
+			return err
+		}
+		if checksum(data) != sum {
+			return fmt.Errorf("checksum mismatch for %s", name)
+		}
+	}
+	return nil
+}
+
+// Read a single file from a backup, either a directory or an archive,
+// decrypting it if c is not nil.
+func readBackupFile(path string, name string, c *backupCipher) ([]byte, error) {
+	data, err := readBackupEntry(path, name)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(name, data)
+}
+
+// Read the raw content of a single file from a backup.
+func readBackupEntry(path string, name string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return ioutil.ReadFile(filepath.Join(path, name))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := tar.NewReader(f)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in %s", name, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == name {
+			return ioutil.ReadAll(r)
+		}
+	}
+}
+
+// Decrypt the backup at the given path into a new directory, verifying it
+// against its manifest.
+func decryptBackup(path string, dir string, c *backupCipher) error {
+	sums, err := readManifest(path, c)
+	if err != nil {
+		return err
+	}
+
+	entries := []backupFile{}
+	for name, sum := range sums {
+		data, err := readBackupFile(path, name, c)
+		if err != nil {
+			return err
+		}
+		if checksum(data) != sum {
+			return fmt.Errorf("checksum mismatch for %s", name)
+		}
+		entries = append(entries, backupFile{Name: name, Data: data, Sum: sum})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	entries = append(entries, backupFile{Name: manifestName, Data: manifest(entries)})
+
+	return writeDir(dir, entries)
+}
+
+// Return true if the given files match the given checksums exactly.
+func sameContent(files []backupFile, sums map[string]string) bool {
+	if len(files) != len(sums) {
+		return false
+	}
+	for _, file := range files {
+		if sums[file.Name] != file.Sum {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the paths of all backups with the given prefix, oldest first.
+func listBackups(dir string, prefix string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), archiveExt)
+		if !strings.HasPrefix(name, prefix+"-") {
+			continue
+		}
+		timestamp := strings.TrimPrefix(name, prefix+"-")
+		if _, err := time.Parse(timestampLayout, timestamp); err != nil {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.TrimSuffix(names[i], archiveExt) < strings.TrimSuffix(names[j], archiveExt)
+	})
+
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(dir, name)
+	}
+
+	return paths, nil
+}
+
+// Return the path of the most recent backup, or an empty string.
+func latestBackup(dir string, prefix string) (string, error) {
+	backups, err := listBackups(dir, prefix)
+	if err != nil || len(backups) == 0 {
+		return "", err
+	}
+	return backups[len(backups)-1], nil
+}
+
+// Remove all but the given number of most recent backups.
+func prune(dir string, prefix string, keep int) error {
+	backups, err := listBackups(dir, prefix)
+	if err != nil {
+		return err
+	}
+	for len(backups) > keep {
+		if err := os.RemoveAll(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Header of encrypted backup files, followed by the nonce and the
+// ciphertext.
+var encryptedMagic = []byte("DQLITE-AES256GCM-1\n")
+
+// Encrypt and decrypt backup files with AES-256-GCM.
+//
+// The name of each file is used as additional authenticated data, so files
+// can't be swapped or renamed without detection. Since the manifest is
+// encrypted as well and holds the checksums of all other files, a backup
+// can't be tampered with as a whole either.
+type backupCipher struct {
+	aead cipher.AEAD
+}
+
+// Load a key from the given file, which must hold either the hex encoding of
+// 32 bytes, optionally surrounded by whitespace, or 32 raw bytes.
+//
+// Files holding only hex digits are always decoded, so that a short hex key
+// followed by a newline is rejected instead of being used as a raw key.
+func loadBackupCipher(path string) (*backupCipher, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := data
+	if text := bytes.TrimSpace(data); isHex(text) {
+		key, err = hex.DecodeString(string(text))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("hex-encoded key must be 64 digits long")
+		}
+	} else if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, either raw or hex-encoded")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backupCipher{aead: aead}, nil
+}
+
+// Encrypt the content of the file with the given name. It's a no-op if
+// encryption is disabled.
+func (c *backupCipher) seal(name string, data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, 0, len(encryptedMagic)+len(nonce)+len(data)+c.aead.Overhead())
+	sealed = append(sealed, encryptedMagic...)
+	sealed = append(sealed, nonce...)
+	sealed = c.aead.Seal(sealed, nonce, data, []byte(name))
+
+	return sealed, nil
+}
+
+// Decrypt the content of the file with the given name. It's a no-op if
+// encryption is disabled, and it fails if the file is not encrypted.
+func (c *backupCipher) open(name string, data []byte) ([]byte, error) {
+	encrypted := bytes.HasPrefix(data, encryptedMagic)
+	if c == nil {
+		if encrypted {
+			return nil, fmt.Errorf("%s is encrypted and no key was given", name)
+		}
+		return data, nil
+	}
+	if !encrypted {
+		return nil, fmt.Errorf("%s is not encrypted", name)
+	}
+
+	data = data[len(encryptedMagic):]
+	if len(data) < c.aead.NonceSize() {
+		return nil, fmt.Errorf("%s is truncated", name)
+	}
+	nonce := data[:c.aead.NonceSize()]
+
+	plain, err := c.aead.Open(nil, nonce, data[len(nonce):], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: wrong key or tampered file", name)
+	}
+
+	return plain, nil
+}
+
+// Return true if the given text is not empty and only holds hex digits.
+func isHex(text []byte) bool {
+	if len(text) == 0 {
+		return false
+	}
+	for _, c := range text {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupCipher_RoundTrip(t *testing.T) {
+	c := newBackupCipher(t, bytes.Repeat([]byte{1}, 32))
+
+	sealed, err := c.seal("test", []byte("hello"))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(sealed, encryptedMagic))
+	assert.NotContains(t, string(sealed), "hello")
+
+	plain, err := c.open("test", sealed)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), plain)
+}
+
+func TestBackupCipher_WrongKey(t *testing.T) {
+	sealed, err := newBackupCipher(t, bytes.Repeat([]byte{1}, 32)).seal("test", []byte("hello"))
+	require.NoError(t, err)
+
+	_, err = newBackupCipher(t, bytes.Repeat([]byte{2}, 32)).open("test", sealed)
+	assert.EqualError(t, err, "decrypt test: wrong key or tampered file")
+}
+
+// The name of the file is authenticated, so renamed files are detected.
+func TestBackupCipher_Renamed(t *testing.T) {
+	c := newBackupCipher(t, bytes.Repeat([]byte{1}, 32))
+
+	sealed, err := c.seal("test", []byte("hello"))
+	require.NoError(t, err)
+
+	_, err = c.open("other", sealed)
+	assert.EqualError(t, err, "decrypt other: wrong key or tampered file")
+}
+
+func TestBackupCipher_Truncated(t *testing.T) {
+	c := newBackupCipher(t, bytes.Repeat([]byte{1}, 32))
+
+	sealed, err := c.seal("test", []byte("hello"))
+	require.NoError(t, err)
+
+	_, err = c.open("test", sealed[:len(encryptedMagic)+4])
+	assert.EqualError(t, err, "test is truncated")
+
+	_, err = c.open("test", sealed[:len(sealed)-1])
+	assert.EqualError(t, err, "decrypt test: wrong key or tampered file")
+}
+
+func TestBackupCipher_MissingMagic(t *testing.T) {
+	c := newBackupCipher(t, bytes.Repeat([]byte{1}, 32))
+
+	_, err := c.open("test", []byte("hello"))
+	assert.EqualError(t, err, "test is not encrypted")
+
+	sealed, err := c.seal("test", []byte("hello"))
+	require.NoError(t, err)
+
+	var none *backupCipher
+	_, err = none.open("test", sealed)
+	assert.EqualError(t, err, "test is encrypted and no key was given")
+}
+
+func TestLoadBackupCipher(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, 32)
+	sealed, err := newBackupCipher(t, key).seal("test", []byte("hello"))
+	require.NoError(t, err)
+
+	cases := map[string][]byte{
+		"raw":              key,
+		"hex":              []byte(hex.EncodeToString(key)),
+		"hex with newline": []byte(hex.EncodeToString(key) + "\n"),
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			c, err := loadBackupCipher(writeKeyFile(t, data))
+			require.NoError(t, err)
+			plain, err := c.open("test", sealed)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("hello"), plain)
+		})
+	}
+}
+
+// A short hex key is rejected, even if its size with a trailing newline
+// happens to be 32 bytes.
+func TestLoadBackupCipher_ShortHex(t *testing.T) {
+	data := []byte(strings.Repeat("ab", 15) + "a\n")
+	require.Len(t, data, 32)
+
+	_, err := loadBackupCipher(writeKeyFile(t, data))
+	assert.EqualError(t, err, "hex-encoded key must be 64 digits long")
+
+	_, err = loadBackupCipher(writeKeyFile(t, []byte("short")))
+	assert.EqualError(t, err, "key must be 32 bytes, either raw or hex-encoded")
+}
+
+// Return a cipher using the given key.
+func newBackupCipher(t *testing.T, key []byte) *backupCipher {
+	t.Helper()
+	c, err := loadBackupCipher(writeKeyFile(t, key))
+	require.NoError(t, err)
+	return c
+}
+
+// Write the given key to a temporary file and return its path.
+func writeKeyFile(t *testing.T, data []byte) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "dqlite-backup-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "key")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	return path
+}
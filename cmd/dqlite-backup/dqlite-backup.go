@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/cli"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	var servers *[]string
+	var databases *[]string
+	var dir string
+	var prefix string
+	var archive bool
+	var incremental bool
+	var keep int
+	var keyFile string
+	var schemaOnly bool
+	var tables []string
+	var tlsFlags cli.TLS
+	var s3 s3Flags
+
+	cmd := &cobra.Command{
+		Use:   "dqlite-backup -s <servers> -d <databases> [dir]",
+		Short: "Back up dqlite databases",
+		Long: `Connect to the cluster leader and dump the given databases, along with their
+WAL files, to a new backup named <prefix>-<UTC timestamp> inside the target
+directory, either as a plain directory or as a tar archive.
+
+Every backup contains a SHA256SUMS manifest, which is verified after writing.
+With --incremental no new backup is created if the data didn't change since
+the most recent one, and with --keep only the given number of most recent
+backups is retained.
+
+With --key-file all files, including the manifest, are encrypted with
+AES-256-GCM using the 32-byte key in the given file, either raw or
+hex-encoded. Encrypted backups can be turned back into plain ones with the
+decrypt command.
+
+With --s3-endpoint the backup is uploaded as a tar archive to the given
+S3-compatible object store instead, using a multipart upload and optionally
+server-side encryption. Credentials are taken from the AWS_ACCESS_KEY_ID,
+AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables, and
+--incremental and --keep apply to the backups in the bucket.
+
+With --schema-only the backup holds a <database>.sql file for each database
+instead, with the SQL text of its schema and of the rows of the tables given
+with --tables, which is suitable for code review and for comparing
+environments.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			if keep < 0 {
+				return fmt.Errorf("keep must be a non-negative number")
+			}
+			if len(tables) > 0 && !schemaOnly {
+				return fmt.Errorf("tables can only be selected with --schema-only")
+			}
+
+			infos := make([]client.NodeInfo, len(*servers))
+			for i, address := range *servers {
+				infos[i].Address = address
+			}
+			store := client.NewInmemNodeStore()
+			store.Set(context.Background(), infos)
+
+			dial, err := tlsFlags.DialFunc()
+			if err != nil {
+				return err
+			}
+
+			var c *backupCipher
+			if keyFile != "" {
+				c, err = loadBackupCipher(keyFile)
+				if err != nil {
+					return err
+				}
+			}
+
+			var target *s3Target
+			if s3.Enabled() {
+				target, err = s3.Target()
+				if err != nil {
+					return err
+				}
+			}
+
+			cmd.SilenceUsage = true
+
+			ctx := context.Background()
+			leader, err := client.FindLeader(ctx, store, client.WithDialFunc(dial))
+			if err != nil {
+				return err
+			}
+			defer leader.Close()
+
+			var files []backupFile
+			if schemaOnly {
+				files, err = dumpSchema(ctx, leader, *databases, tables)
+			} else {
+				files, err = dump(ctx, leader, *databases)
+			}
+			if err != nil {
+				return err
+			}
+
+			if s3.Enabled() {
+				return backupToS3(ctx, target, prefix, files, c, incremental, keep)
+			}
+
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+
+			if incremental {
+				previous, err := latestBackup(dir, prefix)
+				if err != nil {
+					return err
+				}
+				if previous != "" {
+					sums, err := readManifest(previous, c)
+					if err != nil {
+						return err
+					}
+					if sameContent(files, sums) {
+						fmt.Printf("unchanged since %s\n", previous)
+						return nil
+					}
+				}
+			}
+
+			name := backupName(prefix, time.Now(), archive)
+			path, err := writeBackup(dir, name, files, archive, c)
+			if err != nil {
+				return err
+			}
+			fmt.Println(path)
+
+			if keep > 0 {
+				if err := prune(dir, prefix, keep); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	servers = flags.StringSliceP("servers", "s", nil, "comma-separated list of db servers")
+	databases = flags.StringSliceP("databases", "d", nil, "comma-separated list of databases to back up")
+	flags.StringVarP(&dir, "dir", "o", ".", "directory where to store backups")
+	flags.StringVarP(&prefix, "prefix", "p", "dqlite-backup", "prefix of backup names")
+	flags.BoolVarP(&archive, "tar", "t", false, "store the backup as a tar archive")
+	flags.BoolVarP(&incremental, "incremental", "i", false, "skip the backup if nothing changed since the last one")
+	flags.IntVarP(&keep, "keep", "k", 0, "number of most recent backups to retain (0 means all)")
+
+	flags.StringVarP(&keyFile, "key-file", "K", "", "file holding the key used to encrypt the backup")
+	flags.BoolVar(&schemaOnly, "schema-only", false, "back up the schema as SQL text instead of the database files")
+	flags.StringSliceVar(&tables, "tables", nil, "comma-separated list of tables whose rows to include with --schema-only")
+
+	s3.AddFlags(flags)
+	tlsFlags.AddFlags(flags, false)
+
+	cmd.MarkFlagRequired("servers")
+	cmd.MarkFlagRequired("databases")
+
+	decrypt := &cobra.Command{
+		Use:   "decrypt <backup> <dir>",
+		Short: "Decrypt a backup",
+		Long: `Decrypt the given backup, either a directory or a tar archive, into a new
+plain directory, checking that no file was tampered with.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := loadBackupCipher(keyFile)
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			return decryptBackup(args[0], args[1], c)
+		},
+	}
+	decrypt.Flags().StringVarP(&keyFile, "key-file", "K", "", "file holding the key used to encrypt the backup")
+	decrypt.MarkFlagRequired("key-file")
+	cmd.AddCommand(decrypt)
+
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// Upload a new backup to S3, skipping it if incremental is true and the data
+// didn't change, and pruning old backups if keep is not zero.
+func backupToS3(ctx context.Context, target *s3Target, prefix string, files []backupFile, c *backupCipher, incremental bool, keep int) error {
+	if incremental {
+		sums, previous, err := latestS3Manifest(ctx, target, prefix, c)
+		if err != nil {
+			return err
+		}
+		if sums != nil && sameContent(files, sums) {
+			fmt.Printf("unchanged since %s\n", previous)
+			return nil
+		}
+	}
+
+	name := backupName(prefix, time.Now(), true)
+	if err := uploadBackup(ctx, target, name, files, c); err != nil {
+		return err
+	}
+	fmt.Printf("s3://%s/%s%s\n", target.bucket, target.prefix, name)
+
+	if keep > 0 {
+		if err := target.prune(ctx, prefix, keep); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
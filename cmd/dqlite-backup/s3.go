@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Environment variables holding the S3 credentials.
+const (
+	envAccessKeyID     = "AWS_ACCESS_KEY_ID"
+	envSecretAccessKey = "AWS_SECRET_ACCESS_KEY"
+	envSessionToken    = "AWS_SESSION_TOKEN"
+)
+
+// Minimum size of all parts of a multipart upload but the last one.
+const minPartSize = 5 * 1024 * 1024
+
+// Client for the subset of the S3 API needed to store backups: multipart
+// uploads, listing, downloading and deleting objects. Requests are signed
+// with AWS Signature Version 4 and use path-style URLs, which all
+// S3-compatible stores support.
+type s3Target struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string // Prepended to the names of backups.
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	sse          string // Server-side encryption, either AES256 or aws:kms.
+	kmsKeyID     string
+	partSize     int
+	client       *http.Client
+}
+
+// Create a new S3 target, taking credentials from the environment.
+func newS3Target(endpoint, bucket, prefix, region, sse, kmsKeyID string, partSize int) (*s3Target, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket given")
+	}
+	switch sse {
+	case "", "AES256":
+		if kmsKeyID != "" {
+			return nil, fmt.Errorf("a KMS key requires aws:kms server-side encryption")
+		}
+	case "aws:kms":
+	default:
+		return nil, fmt.Errorf("unknown server-side encryption %q", sse)
+	}
+	if partSize < minPartSize {
+		return nil, fmt.Errorf("part size must be at least %d bytes", minPartSize)
+	}
+
+	t := &s3Target{
+		endpoint:     u,
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       region,
+		accessKey:    os.Getenv(envAccessKeyID),
+		secretKey:    os.Getenv(envSecretAccessKey),
+		sessionToken: os.Getenv(envSessionToken),
+		sse:          sse,
+		kmsKeyID:     kmsKeyID,
+		partSize:     partSize,
+		client:       http.DefaultClient,
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, fmt.Errorf("%s and %s must be set", envAccessKeyID, envSecretAccessKey)
+	}
+
+	return t, nil
+}
+
+// Values of the S3 command line flags.
+type s3Flags struct {
+	Endpoint string
+	Bucket   string
+	Prefix   string
+	Region   string
+	SSE      string
+	KMSKeyID string
+	PartSize int // In megabytes.
+}
+
+// Register the S3 flags.
+func (f *s3Flags) AddFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&f.Endpoint, "s3-endpoint", "", "URL of an S3-compatible object store to upload the backup to")
+	flags.StringVar(&f.Bucket, "s3-bucket", "", "S3 bucket to upload the backup to")
+	flags.StringVar(&f.Prefix, "s3-prefix", "", "prefix of the S3 object names, for example a folder")
+	flags.StringVar(&f.Region, "s3-region", "us-east-1", "S3 region")
+	flags.StringVar(&f.SSE, "s3-sse", "", "S3 server-side encryption, either AES256 or aws:kms")
+	flags.StringVar(&f.KMSKeyID, "s3-kms-key-id", "", "KMS key used with aws:kms server-side encryption")
+	flags.IntVar(&f.PartSize, "s3-part-size", 8, "size in megabytes of the parts of multipart uploads")
+}
+
+// Enabled returns true if uploading to S3 was requested.
+func (f *s3Flags) Enabled() bool {
+	return f.Endpoint != ""
+}
+
+// Target returns the S3 target configured by the flags.
+func (f *s3Flags) Target() (*s3Target, error) {
+	return newS3Target(f.Endpoint, f.Bucket, f.Prefix, f.Region, f.SSE, f.KMSKeyID, f.PartSize*1024*1024)
+}
+
+// Upload a new backup of the given files as a tar archive. The archive is
+// streamed to the store while being generated.
+func uploadBackup(ctx context.Context, t *s3Target, name string, files []backupFile, c *backupCipher) error {
+	entries, err := backupEntries(files, c)
+	if err != nil {
+		return err
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeTar(w, entries))
+	}()
+	defer r.Close()
+
+	return t.upload(ctx, name, r)
+}
+
+// Return the checksums manifest of the most recent backup in the store, or
+// nil if there's none.
+func latestS3Manifest(ctx context.Context, t *s3Target, prefix string, c *backupCipher) (map[string]string, string, error) {
+	backups, err := t.listBackups(ctx, prefix)
+	if err != nil || len(backups) == 0 {
+		return nil, "", err
+	}
+	latest := backups[len(backups)-1]
+
+	path, err := t.download(ctx, latest)
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.Remove(path)
+
+	sums, err := readManifest(path, c)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return sums, latest, nil
+}
+
+// Upload the content of the given reader to the object with the given name,
+// in parts, so the whole content never needs to be held in memory. The
+// upload is aborted if any part fails.
+func (t *s3Target) upload(ctx context.Context, name string, r io.Reader) error {
+	key := t.prefix + name
+
+	header := http.Header{}
+	if t.sse != "" {
+		header.Set("x-amz-server-side-encryption", t.sse)
+	}
+	if t.kmsKeyID != "" {
+		header.Set("x-amz-server-side-encryption-aws-kms-key-id", t.kmsKeyID)
+	}
+	initiated := struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+	if err := t.do(ctx, "POST", key, url.Values{"uploads": {""}}, header, nil, &initiated); err != nil {
+		return fmt.Errorf("create multipart upload: %w", err)
+	}
+
+	err := t.uploadParts(ctx, key, initiated.UploadID, r)
+	if err != nil {
+		query := url.Values{"uploadId": {initiated.UploadID}}
+		t.do(ctx, "DELETE", key, query, nil, nil, nil)
+		return err
+	}
+
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (t *s3Target) uploadParts(ctx context.Context, key, uploadID string, r io.Reader) error {
+	parts := []completedPart{}
+	buf := make([]byte, t.partSize)
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF && number > 1 {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+
+		query := url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}
+		header := http.Header{}
+		if err := t.do(ctx, "PUT", key, query, nil, buf[:n], &header); err != nil {
+			return fmt.Errorf("upload part %d: %w", number, err)
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: header.Get("ETag")})
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	complete := struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"uploadId": {uploadID}}
+	if err := t.do(ctx, "POST", key, query, nil, body, nil); err != nil {
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+// Return the names of all objects starting with the given prefix, relative
+// to the prefix of the target.
+func (t *s3Target) list(ctx context.Context, prefix string) ([]string, error) {
+	names := []string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {t.prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		result := struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}{}
+		if err := t.do(ctx, "GET", "", query, nil, nil, &result); err != nil {
+			return nil, fmt.Errorf("list objects: %w", err)
+		}
+		for _, object := range result.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, t.prefix))
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return names, nil
+}
+
+// Download the object with the given name to a temporary file, returning
+// its path.
+func (t *s3Target) download(ctx context.Context, name string) (string, error) {
+	f, err := ioutil.TempFile("", "dqlite-backup-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := t.do(ctx, "GET", t.prefix+name, nil, nil, nil, f); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("download %s: %w", name, err)
+	}
+
+	return f.Name(), nil
+}
+
+// Delete the object with the given name.
+func (t *s3Target) delete(ctx context.Context, name string) error {
+	if err := t.do(ctx, "DELETE", t.prefix+name, nil, nil, nil, nil); err != nil {
+		return fmt.Errorf("delete %s: %w", name, err)
+	}
+	return nil
+}
+
+// Return the names of all backups with the given prefix, oldest first.
+func (t *s3Target) listBackups(ctx context.Context, prefix string) ([]string, error) {
+	objects, err := t.list(ctx, prefix+"-")
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, name := range objects {
+		timestamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix+"-"), archiveExt)
+		if !strings.HasSuffix(name, archiveExt) {
+			continue
+		}
+		if _, err := time.Parse(timestampLayout, timestamp); err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Remove all but the given number of most recent backups.
+func (t *s3Target) prune(ctx context.Context, prefix string, keep int) error {
+	backups, err := t.listBackups(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for len(backups) > keep {
+		if err := t.delete(ctx, backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Perform a signed request on the object with the given key, or on the
+// bucket if the key is empty.
+//
+// The response is handled according to the type of out: XML bodies are
+// decoded into structs, bodies are copied to writers, and headers are copied
+// to a *http.Header.
+func (t *s3Target) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte, out interface{}) error {
+	u := *t.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + t.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	t.sign(req, body, time.Now())
+
+	response, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return s3Error(response)
+	}
+
+	switch out := out.(type) {
+	case nil:
+		_, err = io.Copy(ioutil.Discard, response.Body)
+	case *http.Header:
+		*out = response.Header
+	case io.Writer:
+		_, err = io.Copy(out, response.Body)
+	default:
+		// Some operations report errors with a 200 status code.
+		var data []byte
+		data, err = ioutil.ReadAll(response.Body)
+		if err == nil && bytes.Contains(data, []byte("<Error>")) {
+			return s3ErrorBody(response.Status, data)
+		}
+		if err == nil {
+			err = xml.Unmarshal(data, out)
+		}
+	}
+
+	return err
+}
+
+// Sign the given request with AWS Signature Version 4.
+func (t *s3Target) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := checksum(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if t.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", t.sessionToken)
+	}
+
+	request, signedHeaders := canonicalRequest(req, payloadHash)
+
+	scope := date + "/" + t.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + checksum([]byte(request))
+
+	key := hmacSHA256([]byte("AWS4"+t.secretKey), date)
+	key = hmacSHA256(key, t.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.accessKey, scope, signedHeaders, signature))
+}
+
+// Return the canonical form of the given request, as defined by AWS
+// Signature Version 4, along with the list of signed headers. All headers of
+// the request are signed.
+func canonicalRequest(req *http.Request, payloadHash string) (string, string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	return request, signedHeaders
+}
+
+// Encode the given query parameters sorted by name, with spaces encoded as
+// %20, as required by signatures.
+func canonicalQuery(query url.Values) string {
+	return strings.Replace(query.Encode(), "+", "%20", -1)
+}
+
+func s3Error(response *http.Response) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64*1024))
+	return s3ErrorBody(response.Status, data)
+}
+
+func s3ErrorBody(status string, data []byte) error {
+	details := struct {
+		Code    string
+		Message string
+	}{}
+	if err := xml.Unmarshal(data, &details); err != nil || details.Code == "" {
+		return fmt.Errorf("%s", status)
+	}
+	return fmt.Errorf("%s: %s: %s", status, details.Code, details.Message)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/canonical/go-dqlite/internal/cli"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	var servers *[]string
+	var database string
+	var config workload
+	var tlsFlags cli.TLS
+
+	cmd := &cobra.Command{
+		Use:   "dqlite-benchmark -s <servers>",
+		Short: "Run read/write workloads against a dqlite cluster",
+		Long: `Run configurable read/write workloads against a dqlite cluster and report
+throughput and latency percentiles per operation and per node.
+
+Each operation is attributed to the node that was the leader when it was
+issued.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if config.Clients < 1 {
+				return fmt.Errorf("at least one client is required")
+			}
+			if config.ReadRatio < 0 || config.ReadRatio > 1 {
+				return fmt.Errorf("read ratio must be between 0 and 1")
+			}
+
+			infos := make([]client.NodeInfo, len(*servers))
+			for i, address := range *servers {
+				infos[i].Address = address
+			}
+			store := client.NewInmemNodeStore()
+			store.Set(context.Background(), infos)
+
+			dial, err := tlsFlags.DialFunc()
+			if err != nil {
+				return err
+			}
+
+			drv, err := driver.New(store, driver.WithDialFunc(dial))
+			if err != nil {
+				return err
+			}
+			connector, err := drv.OpenConnector(database)
+			if err != nil {
+				return err
+			}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+			db.SetMaxOpenConns(config.Clients)
+			db.SetMaxIdleConns(config.Clients)
+
+			cmd.SilenceUsage = true
+
+			ctx := context.Background()
+			report, err := run(ctx, db, store, dial, config)
+			if err != nil {
+				return err
+			}
+
+			report.Print(os.Stdout)
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	servers = flags.StringSliceP("servers", "s", nil, "comma-separated list of db servers")
+	flags.StringVarP(&database, "database", "d", "benchmark", "name of the database to use")
+	flags.IntVarP(&config.Clients, "clients", "c", 4, "number of concurrent clients")
+	flags.Float64VarP(&config.ReadRatio, "read-ratio", "r", 0.8, "fraction of operations that are reads")
+	flags.IntVarP(&config.PayloadSize, "payload", "p", 128, "size in bytes of written values")
+	flags.DurationVarP(&config.Duration, "duration", "t", 10*time.Second, "duration of the benchmark")
+	flags.IntVar(&config.Rows, "rows", 1000, "number of rows to pre-populate and read from")
+
+	tlsFlags.AddFlags(flags, false)
+
+	cmd.MarkFlagRequired("servers")
+
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
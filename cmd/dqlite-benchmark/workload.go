@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// Parameters of a benchmark run.
+type workload struct {
+	Clients     int
+	ReadRatio   float64
+	PayloadSize int
+	Duration    time.Duration
+	Rows        int
+}
+
+const (
+	opRead  = "read"
+	opWrite = "write"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS benchmark (id INTEGER PRIMARY KEY, value BLOB);
+DELETE FROM benchmark;
+`
+
+// Latencies of a single kind of operation, plus failures.
+type samples struct {
+	latencies []time.Duration
+	errors    int
+}
+
+// Key used to group samples by operation and node.
+type sampleKey struct {
+	op   string
+	node string
+}
+
+// Report holds the results of a benchmark run.
+type report struct {
+	elapsed time.Duration
+	samples map[sampleKey]*samples
+}
+
+// Run the given workload against the database, until its duration expires.
+func run(ctx context.Context, db *sql.DB, store client.NodeStore, dial client.DialFunc, w workload) (*report, error) {
+	if err := populate(ctx, db, w); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.Duration)
+	defer cancel()
+
+	// Track the current leader, so operations can be attributed to it.
+	var leader atomic.Value
+	leader.Store("unknown")
+	go trackLeader(ctx, store, dial, &leader)
+
+	results := make([]map[sampleKey]*samples, w.Clients)
+	wg := sync.WaitGroup{}
+	start := time.Now()
+
+	for i := 0; i < w.Clients; i++ {
+		results[i] = map[sampleKey]*samples{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			worker(ctx, db, w, &leader, rand.New(rand.NewSource(int64(i))), results[i])
+		}(i)
+	}
+	wg.Wait()
+
+	r := &report{elapsed: time.Since(start), samples: map[sampleKey]*samples{}}
+	for _, result := range results {
+		for key, s := range result {
+			total, ok := r.samples[key]
+			if !ok {
+				total = &samples{}
+				r.samples[key] = total
+			}
+			total.latencies = append(total.latencies, s.latencies...)
+			total.errors += s.errors
+		}
+	}
+
+	return r, nil
+}
+
+// Create the benchmark table and fill it with initial rows.
+func populate(ctx context.Context, db *sql.DB, w workload) error {
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("create schema: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	payload := make([]byte, w.PayloadSize)
+	for i := 1; i <= w.Rows; i++ {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO benchmark(id, value) VALUES(?, ?)", i, payload); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("populate: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("populate: %w", err)
+	}
+
+	return nil
+}
+
+// Issue operations until the context is done.
+func worker(ctx context.Context, db *sql.DB, w workload, leader *atomic.Value, rnd *rand.Rand, result map[sampleKey]*samples) {
+	payload := make([]byte, w.PayloadSize)
+
+	for ctx.Err() == nil {
+		op := opWrite
+		if rnd.Float64() < w.ReadRatio {
+			op = opRead
+		}
+		id := rnd.Intn(w.Rows) + 1
+		key := sampleKey{op: op, node: leader.Load().(string)}
+
+		start := time.Now()
+		var err error
+		switch op {
+		case opRead:
+			var value []byte
+			err = db.QueryRowContext(ctx, "SELECT value FROM benchmark WHERE id = ?", id).Scan(&value)
+		case opWrite:
+			rnd.Read(payload)
+			_, err = db.ExecContext(ctx, "UPDATE benchmark SET value = ? WHERE id = ?", payload, id)
+		}
+		latency := time.Since(start)
+
+		if ctx.Err() != nil {
+			// Don't account for operations interrupted by the deadline.
+			break
+		}
+
+		s, ok := result[key]
+		if !ok {
+			s = &samples{}
+			result[key] = s
+		}
+		if err != nil {
+			s.errors++
+			continue
+		}
+		s.latencies = append(s.latencies, latency)
+	}
+}
+
+// Periodically update the address of the current leader.
+func trackLeader(ctx context.Context, store client.NodeStore, dial client.DialFunc, leader *atomic.Value) {
+	for {
+		cli, err := client.FindLeader(ctx, store, client.WithDialFunc(dial))
+		if err == nil {
+			info, err := cli.Leader(ctx)
+			if err == nil && info != nil {
+				leader.Store(info.Address)
+			}
+			cli.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Print writes a human-readable summary of the report.
+func (r *report) Print(w io.Writer) {
+	keys := make([]sampleKey, 0, len(r.samples))
+	for key := range r.samples {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].op != keys[j].op {
+			return keys[i].op < keys[j].op
+		}
+		return keys[i].node < keys[j].node
+	})
+
+	// Aggregate per operation across all nodes.
+	totals := map[string]*samples{}
+	for _, key := range keys {
+		total, ok := totals[key.op]
+		if !ok {
+			total = &samples{}
+			totals[key.op] = total
+		}
+		total.latencies = append(total.latencies, r.samples[key].latencies...)
+		total.errors += r.samples[key].errors
+	}
+
+	fmt.Fprintf(w, "elapsed: %s\n\n", r.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "%-6s %-24s %10s %10s %8s %10s %10s %10s %10s\n",
+		"op", "node", "ops", "ops/s", "errors", "p50", "p95", "p99", "max")
+	fmt.Fprintln(w, strings.Repeat("-", 106))
+
+	for _, op := range []string{opRead, opWrite} {
+		if total, ok := totals[op]; ok {
+			r.printLine(w, op, "all", total)
+		}
+		for _, key := range keys {
+			if key.op == op {
+				r.printLine(w, op, key.node, r.samples[key])
+			}
+		}
+	}
+}
+
+func (r *report) printLine(w io.Writer, op, node string, s *samples) {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	n := len(s.latencies)
+	throughput := float64(n) / r.elapsed.Seconds()
+	fmt.Fprintf(w, "%-6s %-24s %10d %10.1f %8d %10s %10s %10s %10s\n",
+		op, node, n, throughput, s.errors,
+		percentile(s.latencies, 0.50), percentile(s.latencies, 0.95),
+		percentile(s.latencies, 0.99), percentile(s.latencies, 1))
+}
+
+// Return the given percentile of the given sorted latencies.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(latencies))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(latencies) {
+		i = len(latencies) - 1
+	}
+	return latencies[i].Round(time.Microsecond)
+}
@@ -8,12 +8,12 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"path/filepath"
 	"strings"
 
 	"github.com/canonical/go-dqlite/app"
 	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/cli"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"golang.org/x/sys/unix"
@@ -25,6 +25,7 @@ func main() {
 	var join *[]string
 	var dir string
 	var verbose bool
+	var tlsFlags cli.TLS
 
 	cmd := &cobra.Command{
 		Use:   "dqlite-demo",
@@ -43,12 +44,22 @@ Complete documentation is available at https://github.com/canonical/go-dqlite`,
 				}
 				log.Printf(fmt.Sprintf("%s: %s: %s\n", api, l.String(), format), a...)
 			}
-			app, err := app.New(dir, app.WithAddress(db), app.WithCluster(*join), app.WithLogFunc(logFunc))
+			options := []app.Option{app.WithAddress(db), app.WithCluster(*join), app.WithLogFunc(logFunc)}
+			tlsOptions, err := tlsFlags.AppOptions()
 			if err != nil {
 				return err
 			}
+			options = append(options, tlsOptions...)
 
-			if err := app.Ready(context.Background()); err != nil {
+			ctx, cancel := app.SignalContext(context.Background(), unix.SIGPWR, unix.SIGINT, unix.SIGQUIT, unix.SIGTERM)
+			defer cancel()
+
+			app, err := app.New(dir, options...)
+			if err != nil {
+				return err
+			}
+
+			if err := app.Ready(ctx); err != nil {
 				return err
 			}
 
@@ -91,21 +102,12 @@ Complete documentation is available at https://github.com/canonical/go-dqlite`,
 
 			go http.Serve(listener, nil)
 
-			ch := make(chan os.Signal)
-			signal.Notify(ch, unix.SIGPWR)
-			signal.Notify(ch, unix.SIGINT)
-			signal.Notify(ch, unix.SIGQUIT)
-			signal.Notify(ch, unix.SIGTERM)
-
-			<-ch
+			err = app.Run(ctx)
 
 			listener.Close()
 			db.Close()
 
-			app.Handover(context.Background())
-			app.Close()
-
-			return nil
+			return err
 		},
 	}
 
@@ -115,6 +117,7 @@ Complete documentation is available at https://github.com/canonical/go-dqlite`,
 	join = flags.StringSliceP("join", "j", nil, "database addresses of existing nodes")
 	flags.StringVarP(&dir, "dir", "D", "/tmp/dqlite-demo", "data directory")
 	flags.BoolVarP(&verbose, "verbose", "v", false, "verbose logging")
+	tlsFlags.AddFlags(flags, false)
 
 	cmd.MarkFlagRequired("api")
 	cmd.MarkFlagRequired("db")
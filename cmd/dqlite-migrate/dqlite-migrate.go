@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/canonical/go-dqlite/internal/cli"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	var servers *[]string
+	var batch int
+	var quiet bool
+	var tlsFlags cli.TLS
+
+	cmd := &cobra.Command{
+		Use:   "dqlite-migrate",
+		Short: "Move data between standalone SQLite files and dqlite",
+	}
+
+	flags := cmd.PersistentFlags()
+	servers = flags.StringSliceP("servers", "s", nil, "comma-separated list of db servers")
+	tlsFlags.AddFlags(flags, false)
+	cmd.MarkPersistentFlagRequired("servers")
+
+	store := func() client.NodeStore {
+		infos := make([]client.NodeInfo, len(*servers))
+		for i, address := range *servers {
+			infos[i].Address = address
+		}
+		store := client.NewInmemNodeStore()
+		store.Set(context.Background(), infos)
+		return store
+	}
+
+	importCmd := &cobra.Command{
+		Use:   "import <file.db> <database>",
+		Short: "Import a standalone SQLite file into a dqlite database",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if batch < 1 {
+				return fmt.Errorf("batch size must be positive")
+			}
+			if _, err := os.Stat(args[0]); err != nil {
+				return err
+			}
+
+			dial, err := tlsFlags.DialFunc()
+			if err != nil {
+				return err
+			}
+
+			drv, err := driver.New(store(), driver.WithDialFunc(dial))
+			if err != nil {
+				return err
+			}
+			connector, err := drv.OpenConnector(args[1])
+			if err != nil {
+				return err
+			}
+			dst := sql.OpenDB(connector)
+			defer dst.Close()
+
+			src, err := sql.Open("sqlite3", "file:"+args[0]+"?mode=ro")
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+
+			cmd.SilenceUsage = true
+
+			progress := func(format string, a ...interface{}) {
+				if !quiet {
+					fmt.Printf(format+"\n", a...)
+				}
+			}
+
+			return importDatabase(context.Background(), src, dst, batch, progress)
+		},
+	}
+	importCmd.Flags().IntVarP(&batch, "batch", "b", 1000, "number of rows inserted per transaction")
+	importCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "don't report progress")
+
+	exportCmd := &cobra.Command{
+		Use:   "export <database> <file.db>",
+		Short: "Export a dqlite database to a standalone SQLite file",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dial, err := tlsFlags.DialFunc()
+			if err != nil {
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			ctx := context.Background()
+			leader, err := client.FindLeader(ctx, store(), client.WithDialFunc(dial))
+			if err != nil {
+				return err
+			}
+			defer leader.Close()
+
+			return exportDatabase(ctx, leader, args[0], args[1])
+		},
+	}
+
+	cmd.AddCommand(importCmd)
+	cmd.AddCommand(exportCmd)
+
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/dbfile"
+	_ "github.com/mattn/go-sqlite3" // Used to read standalone SQLite files.
+)
+
+// A schema object as found in sqlite_master.
+type schemaObject struct {
+	Type string
+	Name string
+	SQL  string
+}
+
+// Copy schema and data of the src database into the dst one.
+//
+// Tables are created first, then their rows are copied in batches of the
+// given size, and finally indexes, views and triggers are created, which is
+// faster than updating indexes row by row and avoids firing triggers.
+func importDatabase(ctx context.Context, src, dst *sql.DB, batch int, progress func(string, ...interface{})) error {
+	rows, err := src.QueryContext(ctx, `
+SELECT type, name, sql FROM sqlite_master
+ WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+ ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, rowid`)
+	if err != nil {
+		return fmt.Errorf("read schema: %w", err)
+	}
+	objects := []schemaObject{}
+	for rows.Next() {
+		object := schemaObject{}
+		if err := rows.Scan(&object.Type, &object.Name, &object.SQL); err != nil {
+			rows.Close()
+			return fmt.Errorf("read schema: %w", err)
+		}
+		objects = append(objects, object)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read schema: %w", err)
+	}
+
+	for _, object := range objects {
+		if object.Type != "table" {
+			continue
+		}
+		if _, err := dst.ExecContext(ctx, object.SQL); err != nil {
+			return fmt.Errorf("create table %s: %w", object.Name, err)
+		}
+		if err := importTable(ctx, src, dst, object.Name, batch, progress); err != nil {
+			return fmt.Errorf("import table %s: %w", object.Name, err)
+		}
+	}
+
+	for _, object := range objects {
+		if object.Type == "table" {
+			continue
+		}
+		if _, err := dst.ExecContext(ctx, object.SQL); err != nil {
+			return fmt.Errorf("create %s %s: %w", object.Type, object.Name, err)
+		}
+		progress("created %s %s", object.Type, object.Name)
+	}
+
+	return nil
+}
+
+// Copy all rows of the given table.
+func importTable(ctx context.Context, src, dst *sql.DB, table string, batch int, progress func(string, ...interface{})) error {
+	var total int64
+	quoted := quoteIdentifier(table)
+	if err := src.QueryRowContext(ctx, "SELECT count(*) FROM "+quoted).Scan(&total); err != nil {
+		return err
+	}
+
+	rows, err := src.QueryContext(ctx, "SELECT * FROM "+quoted)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	insert := fmt.Sprintf("INSERT INTO %s VALUES(%s)", quoted, placeholders)
+
+	var tx *sql.Tx
+	var count int64
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		if tx == nil {
+			tx, err = dst.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, insert, values...); err != nil {
+			tx.Rollback()
+			return err
+		}
+		count++
+
+		if count%int64(batch) == 0 {
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			tx = nil
+			progress("table %s: %d/%d rows", table, count, total)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+		return err
+	}
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	progress("table %s: %d/%d rows", table, count, total)
+
+	return nil
+}
+
+// Dump the given database and write it to the given path as a standalone
+// SQLite file, with the WAL content already checkpointed.
+func exportDatabase(ctx context.Context, cli *client.Client, database string, path string) error {
+	files, err := cli.Dump(ctx, database)
+	if err != nil {
+		return err
+	}
+
+	var db, wal []byte
+	for _, file := range files {
+		switch file.Name {
+		case database:
+			db = file.Data
+		case database + "-wal":
+			wal = file.Data
+		}
+	}
+
+	image, err := dbfile.Checkpoint(db, wal)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(image); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
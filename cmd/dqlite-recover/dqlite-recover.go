@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	dqlite "github.com/canonical/go-dqlite"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
+)
+
+// Files written by App nodes in their data directory.
+const (
+	infoFile  = "info.yaml"
+	storeFile = "cluster.yaml"
+)
+
+func main() {
+	var nodes []string
+	var dryRun bool
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "dqlite-recover <dir>",
+		Short: "Recover a cluster that permanently lost quorum",
+		Long: `Recover a cluster that permanently lost quorum, by replacing the raft
+configuration stored in the data directory of a surviving node with the given
+nodes.
+
+Pick the surviving node with the most recent raft log, stop it, and run this
+command against its data directory. The plan is shown before anything is
+changed. Then copy the data directory to the other nodes of the new
+configuration, keeping their own info.yaml if they are App nodes, and start
+them all.
+
+The command must not be run against the data directory of a running node.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := args[0]
+
+			cluster := make([]client.NodeInfo, len(nodes))
+			for i, node := range nodes {
+				info, err := parseNode(node)
+				if err != nil {
+					return err
+				}
+				cluster[i] = info
+			}
+
+			cmd.SilenceUsage = true
+
+			plan, err := newPlan(dir, cluster)
+			if err != nil {
+				return err
+			}
+			plan.print()
+
+			if dryRun {
+				return nil
+			}
+			if !yes && !confirm("Rewrite the raft configuration?") {
+				return fmt.Errorf("aborted")
+			}
+
+			if err := plan.apply(); err != nil {
+				return err
+			}
+
+			fmt.Println("\nRaft configuration rewritten. Copy the data directory to the other nodes")
+			fmt.Println("of the new configuration before starting them.")
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringArrayVarP(&nodes, "node", "n", nil, "node of the new configuration, as <id>,<address>[,<role>]")
+	flags.BoolVar(&dryRun, "dry-run", false, "only show the plan")
+	flags.BoolVarP(&yes, "yes", "y", false, "don't ask for confirmation")
+	cmd.MarkFlagRequired("node")
+
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// Plan of a recovery.
+type plan struct {
+	dir       string
+	self      *client.NodeInfo // Node owning the data directory, if it's an App node.
+	index     uint64           // Index of the last raft log entry.
+	term      uint64           // Term of the last raft log entry.
+	current   []client.NodeInfo
+	target    []client.NodeInfo
+	storeFile bool // Whether the node store of an App node must be rewritten.
+}
+
+// Check the state of the given data directory and the given target
+// configuration, and return the plan to recover the node.
+func newPlan(dir string, target []client.NodeInfo) (*plan, error) {
+	if err := validateTarget(target); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	p := &plan{dir: dir, target: target}
+
+	p.current, err = dqlite.ReadConfiguration(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read raft configuration: %w", err)
+	}
+	if p.current == nil {
+		return nil, fmt.Errorf("no raft configuration found in %s", dir)
+	}
+
+	p.index, p.term, err = dqlite.LastLogEntry(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read raft log: %w", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, infoFile))
+	if err == nil {
+		self := client.NodeInfo{}
+		if err := yaml.Unmarshal(data, &self); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", infoFile, err)
+		}
+		p.self = &self
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if p.self != nil {
+		found := false
+		for _, node := range target {
+			if node.ID == p.self.ID && node.Address == p.self.Address {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("node %d at %s owning %s is not in the new configuration", p.self.ID, p.self.Address, dir)
+		}
+		if _, err := os.Stat(filepath.Join(dir, storeFile)); err == nil {
+			p.storeFile = true
+		}
+	}
+
+	return p, nil
+}
+
+// Print the plan.
+func (p *plan) print() {
+	fmt.Printf("Data directory: %s\n", p.dir)
+	if p.self != nil {
+		fmt.Printf("Node: %d at %s\n", p.self.ID, p.self.Address)
+	}
+	fmt.Printf("Last log entry: index %d, term %d\n", p.index, p.term)
+
+	fmt.Println("\nCurrent configuration:")
+	printNodes(p.current)
+	fmt.Println("\nNew configuration:")
+	printNodes(p.target)
+
+	fmt.Println("\nChanges:")
+	changes := p.changes()
+	if len(changes) == 0 {
+		fmt.Println("  none")
+	}
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+	if p.storeFile {
+		fmt.Printf("  rewrite %s\n", storeFile)
+	}
+	fmt.Println()
+}
+
+// Return the differences between the current and the target configurations.
+func (p *plan) changes() []string {
+	current := map[uint64]client.NodeInfo{}
+	for _, node := range p.current {
+		current[node.ID] = node
+	}
+	target := map[uint64]bool{}
+
+	changes := []string{}
+	for _, node := range p.target {
+		target[node.ID] = true
+		old, ok := current[node.ID]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("add node %d at %s as %s", node.ID, node.Address, node.Role))
+		case old.Address != node.Address:
+			changes = append(changes, fmt.Sprintf("move node %d from %s to %s", node.ID, old.Address, node.Address))
+		}
+		if ok && old.Role != node.Role {
+			changes = append(changes, fmt.Sprintf("change role of node %d from %s to %s", node.ID, old.Role, node.Role))
+		}
+	}
+	for _, node := range p.current {
+		if !target[node.ID] {
+			changes = append(changes, fmt.Sprintf("remove node %d at %s", node.ID, node.Address))
+		}
+	}
+
+	return changes
+}
+
+// Rewrite the raft configuration and the node store.
+func (p *plan) apply() error {
+	if err := dqlite.ReconfigureMembershipExt(p.dir, p.target); err != nil {
+		return fmt.Errorf("reconfigure membership: %w", err)
+	}
+
+	if !p.storeFile {
+		return nil
+	}
+
+	store, err := client.NewYamlNodeStore(filepath.Join(p.dir, storeFile))
+	if err != nil {
+		return fmt.Errorf("open %s: %w", storeFile, err)
+	}
+	if err := store.Set(context.Background(), p.target); err != nil {
+		return fmt.Errorf("write %s: %w", storeFile, err)
+	}
+
+	return nil
+}
+
+// Check that the target configuration is usable.
+func validateTarget(nodes []client.NodeInfo) error {
+	ids := map[uint64]bool{}
+	addresses := map[string]bool{}
+	voters := 0
+	for _, node := range nodes {
+		if ids[node.ID] {
+			return fmt.Errorf("duplicate node ID %d", node.ID)
+		}
+		if addresses[node.Address] {
+			return fmt.Errorf("duplicate node address %s", node.Address)
+		}
+		ids[node.ID] = true
+		addresses[node.Address] = true
+		if node.Role == client.Voter {
+			voters++
+		}
+	}
+	if voters == 0 {
+		return fmt.Errorf("the new configuration has no voters")
+	}
+	return nil
+}
+
+// Print the given nodes, one per line.
+func printNodes(nodes []client.NodeInfo) {
+	for _, node := range nodes {
+		fmt.Printf("  %d\t%s\t%s\n", node.ID, node.Address, node.Role)
+	}
+}
+
+// Ask the user for confirmation on the standard input.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Parse a node in the <id>,<address>[,<role>] format, with the voter role by
+// default.
+func parseNode(s string) (client.NodeInfo, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return client.NodeInfo{}, fmt.Errorf("invalid node %q", s)
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || id == 0 {
+		return client.NodeInfo{}, fmt.Errorf("invalid node ID %q", parts[0])
+	}
+	node := client.NodeInfo{ID: id, Address: parts[1], Role: client.Voter}
+	if len(parts) == 3 {
+		switch strings.ToLower(parts[2]) {
+		case "voter":
+		case "stand-by", "standby":
+			node.Role = client.StandBy
+		case "spare":
+			node.Role = client.Spare
+		default:
+			return client.NodeInfo{}, fmt.Errorf("invalid role %q", parts[2])
+		}
+	}
+	return node, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The plan is built from a data directory as written by raft, with a
+// pre-allocated open segment following the closed ones.
+func TestNewPlan(t *testing.T) {
+	dir := newDataDir(t)
+	info := []byte("id: 1\naddress: 127.0.0.1:9001\nrole: 0\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, infoFile), info, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, storeFile), []byte("[]\n"), 0600))
+
+	target := []client.NodeInfo{
+		{ID: 1, Address: "127.0.0.1:9001", Role: client.Voter},
+		{ID: 2, Address: "127.0.0.1:9002", Role: client.Voter},
+	}
+	p, err := newPlan(dir, target)
+	require.NoError(t, err)
+
+	assert.Equal(t, []client.NodeInfo{{ID: 1, Address: "127.0.0.1:9001", Role: client.Voter}}, p.current)
+	assert.Equal(t, uint64(2), p.index)
+	assert.Equal(t, uint64(1), p.term)
+	require.NotNil(t, p.self)
+	assert.Equal(t, uint64(1), p.self.ID)
+	assert.True(t, p.storeFile)
+	assert.Equal(t, []string{"add node 2 at 127.0.0.1:9002 as voter"}, p.changes())
+}
+
+// The node owning the data directory must be part of the new configuration.
+func TestNewPlan_SelfNotInTarget(t *testing.T) {
+	dir := newDataDir(t)
+	info := []byte("id: 1\naddress: 127.0.0.1:9001\nrole: 0\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, infoFile), info, 0600))
+
+	_, err := newPlan(dir, []client.NodeInfo{{ID: 2, Address: "127.0.0.1:9002", Role: client.Voter}})
+	assert.EqualError(t, err, "node 1 at 127.0.0.1:9001 owning "+dir+" is not in the new configuration")
+}
+
+// A data directory with only a pre-allocated open segment has no
+// configuration to recover.
+func TestNewPlan_NoConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dqlite-recover-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "open-1"), make([]byte, 8192), 0600))
+
+	_, err = newPlan(dir, []client.NodeInfo{{ID: 1, Address: "127.0.0.1:9001", Role: client.Voter}})
+	assert.EqualError(t, err, "no raft configuration found in "+dir)
+}
+
+func TestNewPlan_InvalidTarget(t *testing.T) {
+	dir := newDataDir(t)
+
+	_, err := newPlan(dir, []client.NodeInfo{{ID: 1, Address: "127.0.0.1:9001", Role: client.Spare}})
+	assert.EqualError(t, err, "the new configuration has no voters")
+}
+
+func TestParseNode(t *testing.T) {
+	node, err := parseNode("2,127.0.0.1:9002,stand-by")
+	require.NoError(t, err)
+	assert.Equal(t, client.NodeInfo{ID: 2, Address: "127.0.0.1:9002", Role: client.StandBy}, node)
+
+	_, err = parseNode("0,127.0.0.1:9002")
+	assert.EqualError(t, err, `invalid node ID "0"`)
+}
+
+// Return a copy of the data directory in testdata, removed at the end of the
+// test.
+func newDataDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "dqlite-recover-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	files, err := ioutil.ReadDir(filepath.Join("testdata", "raft"))
+	require.NoError(t, err)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "raft", file.Name()))
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file.Name()), data, 0600))
+	}
+
+	return dir
+}
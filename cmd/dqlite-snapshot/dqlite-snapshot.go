@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	dqlite "github.com/canonical/go-dqlite"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	var nodes []string
+
+	cmd := &cobra.Command{
+		Use:   "dqlite-snapshot",
+		Short: "Export and import raft snapshots of dqlite nodes",
+		Long: `Export the most recent raft snapshot of a node to a portable archive, and
+seed the data directory of a brand-new node from it, for cloning environments
+or recovering from a cold standby.
+
+Both commands operate directly on data directories and must not be run
+against directories of running nodes.`,
+	}
+
+	exportCmd := &cobra.Command{
+		Use:   "export <dir> <archive>",
+		Short: "Export the latest snapshot of the given data directory",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			f, err := os.OpenFile(args[1], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			info, err := dqlite.ExportSnapshot(args[0], f)
+			if err == nil {
+				err = f.Sync()
+			}
+			f.Close()
+			if err != nil {
+				os.Remove(args[1])
+				return err
+			}
+
+			fmt.Printf("exported snapshot at term %d and index %d\n", info.Term, info.Index)
+			return nil
+		},
+	}
+
+	importCmd := &cobra.Command{
+		Use:   "import <archive> <dir>",
+		Short: "Seed the given pristine data directory from a snapshot archive",
+		Long: `Seed the given pristine data directory from a snapshot archive.
+
+Without --node flags the node keeps the cluster configuration stored in the
+snapshot. Otherwise the configuration is replaced by the given nodes, and the
+same command must be run for the data directory of each of them.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cluster := make([]client.NodeInfo, len(nodes))
+			for i, node := range nodes {
+				info, err := parseNode(node)
+				if err != nil {
+					return err
+				}
+				cluster[i] = info
+			}
+
+			cmd.SilenceUsage = true
+
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			info, err := dqlite.ImportSnapshot(args[1], f, cluster)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("imported snapshot at term %d and index %d\n", info.Term, info.Index)
+			return nil
+		},
+	}
+	importCmd.Flags().StringArrayVarP(&nodes, "node", "n", nil, "node of the new configuration, as <id>,<address>[,<role>]")
+
+	cmd.AddCommand(exportCmd)
+	cmd.AddCommand(importCmd)
+
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// Parse a node in the <id>,<address>[,<role>] format, with the voter role by
+// default.
+func parseNode(s string) (client.NodeInfo, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return client.NodeInfo{}, fmt.Errorf("invalid node %q", s)
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return client.NodeInfo{}, fmt.Errorf("invalid node ID %q", parts[0])
+	}
+	node := client.NodeInfo{ID: id, Address: parts[1], Role: client.Voter}
+	if len(parts) == 3 {
+		switch strings.ToLower(parts[2]) {
+		case "voter":
+		case "stand-by", "standby":
+			node.Role = client.StandBy
+		case "spare":
+			node.Role = client.Spare
+		default:
+			return client.NodeInfo{}, fmt.Errorf("invalid role %q", parts[2])
+		}
+	}
+	return node, nil
+}
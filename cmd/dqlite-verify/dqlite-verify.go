@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	dqlite "github.com/canonical/go-dqlite"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	var format string
+	var strict bool
+
+	cmd := &cobra.Command{
+		Use:   "dqlite-verify <dir>...",
+		Short: "Check the integrity of dqlite data directories",
+		Long: `Check the integrity of the given data directories offline: raft metadata,
+log segments and their continuity with snapshots, snapshot metadata and free
+disk space.
+
+A report is printed for each directory, as a JSON array by default. The
+command exits with status 1 if any error is found, or also any warning with
+--strict, so it can gate the start of a node in an init container.
+
+The directories must not belong to running nodes.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "json" && format != "text" {
+				return fmt.Errorf("invalid format %q", format)
+			}
+
+			cmd.SilenceUsage = true
+
+			reports := []*dqlite.DataDirReport{}
+			for _, dir := range args {
+				report, err := dqlite.CheckDataDir(dir)
+				if err != nil {
+					return fmt.Errorf("%s: %w", dir, err)
+				}
+				reports = append(reports, report)
+			}
+
+			if format == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(reports); err != nil {
+					return err
+				}
+			} else {
+				for _, report := range reports {
+					printReport(report)
+				}
+			}
+
+			for _, report := range reports {
+				if !report.OK() || (strict && len(report.Problems) > 0) {
+					cmd.SilenceErrors = true
+					return fmt.Errorf("problems found")
+				}
+			}
+
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&format, "format", "f", "json", "output format, either json or text")
+	flags.BoolVar(&strict, "strict", false, "fail on warnings too")
+
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// Print a report in human-readable form.
+func printReport(report *dqlite.DataDirReport) {
+	fmt.Printf("%s:\n", report.Dir)
+	fmt.Printf("  term %d, voted for %d\n", report.Term, report.VotedFor)
+	fmt.Printf("  log from index %d to %d (term %d)\n", report.FirstIndex, report.LastIndex, report.LastTerm)
+	fmt.Printf("  %d segments, %d snapshots, %d bytes, %d bytes free\n",
+		report.Segments, report.Snapshots, report.Size, report.FreeSpace)
+	for _, problem := range report.Problems {
+		if problem.File != "" {
+			fmt.Printf("  %s: %s: %s\n", problem.Severity, problem.File, problem.Message)
+		} else {
+			fmt.Printf("  %s: %s\n", problem.Severity, problem.Message)
+		}
+	}
+	if len(report.Problems) == 0 {
+		fmt.Println("  no problems found")
+	}
+}
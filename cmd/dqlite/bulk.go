@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/canonical/go-dqlite/bulk"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/spf13/cobra"
+)
+
+// Open the given database with a dqlite driver honoring the global flags.
+func openDB(globals *globalFlags, database string) (*sql.DB, error) {
+	dial, err := globals.dial()
+	if err != nil {
+		return nil, err
+	}
+	drv, err := driver.New(globals.store(), driver.WithDialFunc(dial))
+	if err != nil {
+		return nil, err
+	}
+	connector, err := drv.OpenConnector(database)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// Return bulk options for the given batch size, reporting progress on stderr
+// unless quiet.
+func bulkOptions(batchSize int, quiet bool, verb string) []bulk.Option {
+	options := []bulk.Option{bulk.WithBatchSize(batchSize)}
+	if !quiet {
+		options = append(options, bulk.WithProgress(func(rows int64) {
+			fmt.Fprintf(os.Stderr, "\r%s %d rows", verb, rows)
+		}))
+	}
+	return options
+}
+
+func newImportCmd(globals *globalFlags) *cobra.Command {
+	var format string
+	var batchSize int
+	var quiet bool
+
+	cmd := &cobra.Command{
+		Use:   "import <database> <table> [file]",
+		Short: "Load CSV or NDJSON data into a table",
+		Long: `Load CSV or NDJSON data into a table, reading it from the given file or
+from stdin.
+
+CSV data must start with a header line naming the columns, while NDJSON
+objects must have keys naming the columns. Rows are inserted in batches, each
+in its own transaction.`,
+		Args: cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := bulk.ParseFormat(format)
+			if err != nil {
+				return err
+			}
+
+			var r io.Reader = os.Stdin
+			if len(args) == 3 && args[2] != "-" {
+				file, err := os.Open(args[2])
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				r = file
+			}
+
+			db, err := openDB(globals, args[0])
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			cmd.SilenceUsage = true
+
+			n, err := bulk.Import(context.Background(), db, args[1], r, f, bulkOptions(batchSize, quiet, "imported")...)
+			if !quiet && n > 0 {
+				fmt.Fprintln(os.Stderr)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", "csv", "input format (csv or ndjson)")
+	cmd.Flags().IntVar(&batchSize, "batch-size", 1000, "number of rows inserted in each transaction")
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "don't report progress")
+
+	return cmd
+}
+
+func newExportCmd(globals *globalFlags) *cobra.Command {
+	var format string
+	var quiet bool
+
+	cmd := &cobra.Command{
+		Use:   "export <database> <query> [file]",
+		Short: "Write the results of a query as CSV or NDJSON",
+		Long: `Write the results of a query as CSV or NDJSON, to the given file or to
+stdout.`,
+		Args: cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := bulk.ParseFormat(format)
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = os.Stdout
+			if len(args) == 3 && args[2] != "-" {
+				file, err := os.OpenFile(args[2], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+
+			db, err := openDB(globals, args[0])
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			cmd.SilenceUsage = true
+
+			n, err := bulk.Export(context.Background(), db, args[1], nil, w, f, bulkOptions(1000, quiet, "exported")...)
+			if !quiet && n > 0 {
+				fmt.Fprintln(os.Stderr)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", "csv", "output format (csv or ndjson)")
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "don't report progress")
+
+	return cmd
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/spf13/cobra"
+)
+
+// Kinds of faults that the chaos command can inject.
+const (
+	faultRestart  = "restart"
+	faultTransfer = "transfer"
+	faultRole     = "role"
+	faultLatency  = "latency"
+)
+
+// Parameters of a chaos run.
+type chaosConfig struct {
+	Database       string
+	Duration       time.Duration
+	Interval       time.Duration
+	Faults         []string
+	RestartCommand string
+	Latency        time.Duration
+	Clients        int
+	Seed           int64
+}
+
+func newChaosCmd(globals *globalFlags) *cobra.Command {
+	var config chaosConfig
+
+	cmd := &cobra.Command{
+		Use:   "chaos",
+		Short: "Inject faults into a cluster while verifying its consistency",
+		Long: `Run a verification workload against the cluster while periodically injecting
+a random fault, and report any consistency violation observed.
+
+The supported faults are:
+
+  restart   run the --restart-command for a random node
+  transfer  transfer leadership to a random voter
+  role      toggle the role of a random non-leader node
+  latency   delay all workload traffic by --latency until the next fault
+
+The restart command is run with "sh -c" after replacing the {id} and
+{address} placeholders with the ones of the chosen node, for example:
+
+  --restart-command "ssh {address} sudo systemctl restart my-app"
+
+Writes are tracked as acknowledged when the driver reports success. Every
+acknowledged write must be readable at any later point, and no row that was
+never written may appear. Roles changed by the run are restored at the end.
+
+This command is destructive for the availability of the target cluster and
+must not be pointed at production.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, fault := range config.Faults {
+				switch fault {
+				case faultRestart:
+					if config.RestartCommand == "" {
+						return fmt.Errorf("the restart fault requires --restart-command")
+					}
+				case faultTransfer, faultRole, faultLatency:
+				default:
+					return fmt.Errorf("unknown fault %q", fault)
+				}
+			}
+			if len(config.Faults) == 0 {
+				return fmt.Errorf("at least one fault is required")
+			}
+			if config.Clients < 1 {
+				return fmt.Errorf("at least one client is required")
+			}
+
+			dial, err := globals.dial()
+			if err != nil {
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			ctx, cancel := context.WithTimeout(context.Background(), config.Duration)
+			defer cancel()
+
+			ch := make(chan os.Signal, 1)
+			signal.Notify(ch, os.Interrupt)
+			defer signal.Stop(ch)
+			go func() {
+				<-ch
+				cancel()
+			}()
+
+			violations, err := runChaos(ctx, globals.store(), dial, config)
+			if err != nil {
+				return err
+			}
+			if violations > 0 {
+				return fmt.Errorf("%d consistency violations detected", violations)
+			}
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&config.Database, "database", "d", "chaos", "name of the database used by the workload")
+	flags.DurationVarP(&config.Duration, "duration", "t", time.Minute, "duration of the run")
+	flags.DurationVar(&config.Interval, "interval", 5*time.Second, "interval between faults")
+	flags.StringSliceVar(&config.Faults, "faults", []string{faultTransfer, faultRole, faultLatency}, "comma-separated list of faults to inject")
+	flags.StringVar(&config.RestartCommand, "restart-command", "", "shell command restarting a node, with {id} and {address} placeholders")
+	flags.DurationVar(&config.Latency, "latency", 200*time.Millisecond, "delay injected by the latency fault")
+	flags.IntVarP(&config.Clients, "clients", "c", 4, "number of concurrent workload clients")
+	flags.Int64Var(&config.Seed, "seed", 0, "seed for fault selection (default is time-based)")
+
+	return cmd
+}
+
+// Run the workload and inject faults until the context is done, then verify
+// the final state of the database. Return the number of violations found.
+func runChaos(ctx context.Context, store client.NodeStore, dial client.DialFunc, config chaosConfig) (int, error) {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fmt.Printf("seed: %d\n", seed)
+	rnd := rand.New(rand.NewSource(seed))
+
+	latency := &latencyInjector{}
+
+	drv, err := driver.New(store, driver.WithDialFunc(latency.Wrap(dial)))
+	if err != nil {
+		return 0, err
+	}
+	connector, err := drv.OpenConnector(config.Database)
+	if err != nil {
+		return 0, err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS chaos (n INTEGER PRIMARY KEY)"); err != nil {
+		return 0, fmt.Errorf("create table: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM chaos"); err != nil {
+		return 0, fmt.Errorf("clear table: %w", err)
+	}
+
+	h := &history{status: map[int64]bool{}}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < config.Clients; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			h.Run(ctx, db, rand.New(rand.NewSource(seed)))
+		}(rnd.Int63())
+	}
+
+	injector := &faultInjector{
+		store:   store,
+		dial:    dial,
+		config:  config,
+		rnd:     rnd,
+		latency: latency,
+		roles:   map[uint64]client.NodeRole{},
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+		case <-time.After(config.Interval):
+			injector.Inject(ctx)
+			continue
+		}
+		break
+	}
+
+	wg.Wait()
+	latency.Set(0)
+
+	// Give the cluster some time to recover before cleaning up and
+	// verifying.
+	recoverCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	injector.Restore(recoverCtx)
+
+	return h.Verify(recoverCtx, db)
+}
+
+// Keep track of the writes issued by the workload.
+type history struct {
+	next int64
+
+	mu         sync.Mutex
+	status     map[int64]bool // Whether a write was acknowledged.
+	acked      []int64
+	failed     int
+	reads      int
+	violations int
+}
+
+// Issue a mix of writes and reads of previously acknowledged writes.
+func (h *history) Run(ctx context.Context, db *sql.DB, rnd *rand.Rand) {
+	for ctx.Err() == nil {
+		if rnd.Intn(2) == 0 {
+			h.write(ctx, db)
+		} else {
+			h.read(ctx, db, rnd)
+		}
+	}
+}
+
+func (h *history) write(ctx context.Context, db *sql.DB) {
+	n := atomic.AddInt64(&h.next, 1)
+	_, err := db.ExecContext(ctx, "INSERT INTO chaos(n) VALUES(?)", n)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// A failed write might or might not have been committed.
+	h.status[n] = err == nil
+	if err != nil {
+		h.failed++
+		return
+	}
+	h.acked = append(h.acked, n)
+}
+
+// Check that a write acknowledged before the read started is visible.
+func (h *history) read(ctx context.Context, db *sql.DB, rnd *rand.Rand) {
+	h.mu.Lock()
+	if len(h.acked) == 0 {
+		h.mu.Unlock()
+		return
+	}
+	n := h.acked[rnd.Intn(len(h.acked))]
+	h.mu.Unlock()
+
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM chaos WHERE n = ?", n).Scan(&count)
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.reads++
+	if count != 1 {
+		h.violations++
+		h.report("stale read: acknowledged write %d not found", n)
+	}
+}
+
+// Check the final content of the table against the history.
+func (h *history) Verify(ctx context.Context, db *sql.DB) (int, error) {
+	var rows *sql.Rows
+	var err error
+	for {
+		rows, err = db.QueryContext(ctx, "SELECT n FROM chaos")
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read final state: %w", err)
+	}
+	defer rows.Close()
+
+	present := map[int64]bool{}
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			return 0, err
+		}
+		present[n] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("read final state: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, n := range h.acked {
+		if !present[n] {
+			h.violations++
+			h.report("lost write: acknowledged write %d not found", n)
+		}
+	}
+	committed := 0
+	for n := range present {
+		acked, ok := h.status[n]
+		if !ok {
+			h.violations++
+			h.report("phantom write: row %d was never written", n)
+			continue
+		}
+		if !acked {
+			committed++
+		}
+	}
+
+	fmt.Printf("\nwrites: %d acknowledged, %d failed (%d of which committed)\n",
+		len(h.acked), h.failed, committed)
+	fmt.Printf("reads: %d\n", h.reads)
+	fmt.Printf("violations: %d\n", h.violations)
+
+	return h.violations, nil
+}
+
+func (h *history) report(format string, a ...interface{}) {
+	fmt.Printf("%s VIOLATION %s\n", time.Now().Format("15:04:05.000"), fmt.Sprintf(format, a...))
+}
+
+// Pick and inject faults into the cluster.
+type faultInjector struct {
+	store   client.NodeStore
+	dial    client.DialFunc
+	config  chaosConfig
+	rnd     *rand.Rand
+	latency *latencyInjector
+	roles   map[uint64]client.NodeRole // Original roles of changed nodes.
+}
+
+// Inject a random fault, logging what was done.
+func (f *faultInjector) Inject(ctx context.Context) {
+	fault := f.config.Faults[f.rnd.Intn(len(f.config.Faults))]
+
+	// Latency is only kept until the next fault.
+	f.latency.Set(0)
+
+	description, err := f.inject(ctx, fault)
+	if err != nil {
+		description = fmt.Sprintf("%s failed: %v", fault, err)
+	}
+	fmt.Printf("%s %s\n", time.Now().Format("15:04:05.000"), description)
+}
+
+func (f *faultInjector) inject(ctx context.Context, fault string) (string, error) {
+	if fault == faultLatency {
+		f.latency.Set(f.config.Latency)
+		return fmt.Sprintf("latency: delaying workload traffic by %s", f.config.Latency), nil
+	}
+
+	cli, err := client.FindLeader(ctx, f.store, client.WithDialFunc(f.dial))
+	if err != nil {
+		return "", err
+	}
+	defer cli.Close()
+
+	nodes, err := describeNodes(ctx, cli)
+	if err != nil {
+		return "", err
+	}
+
+	switch fault {
+	case faultRestart:
+		node := nodes[f.rnd.Intn(len(nodes))]
+		command := strings.NewReplacer(
+			"{id}", strconv.FormatUint(node.ID, 10),
+			"{address}", node.Address,
+		).Replace(f.config.RestartCommand)
+		output, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("restart node %d: %w: %s", node.ID, err, strings.TrimSpace(string(output)))
+		}
+		return fmt.Sprintf("restart: restarted node %d (%s)", node.ID, node.Address), nil
+
+	case faultTransfer:
+		candidates := filterNodes(nodes, func(node nodeJSON) bool {
+			return !node.Leader && node.Role == client.Voter.String()
+		})
+		if len(candidates) == 0 {
+			return "transfer: no other voter available", nil
+		}
+		node := candidates[f.rnd.Intn(len(candidates))]
+		if err := cli.Transfer(ctx, node.ID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("transfer: leadership transferred to node %d (%s)", node.ID, node.Address), nil
+
+	case faultRole:
+		candidates := filterNodes(nodes, func(node nodeJSON) bool { return !node.Leader })
+		if len(candidates) == 0 {
+			return "role: no non-leader node available", nil
+		}
+		node := candidates[f.rnd.Intn(len(candidates))]
+		current, err := parseRole(node.Role)
+		if err != nil {
+			return "", err
+		}
+		role, ok := f.roles[node.ID]
+		if ok {
+			delete(f.roles, node.ID)
+		} else {
+			f.roles[node.ID] = current
+			role = client.StandBy
+			if current == client.StandBy {
+				role = client.Voter
+			}
+		}
+		if err := cli.Assign(ctx, node.ID, role); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("role: node %d (%s) changed from %s to %s", node.ID, node.Address, current, role), nil
+	}
+
+	return "", fmt.Errorf("unknown fault %q", fault)
+}
+
+// Restore the original roles of all nodes changed by the role fault.
+func (f *faultInjector) Restore(ctx context.Context) {
+	for id, role := range f.roles {
+		err := f.assign(ctx, id, role)
+		if err != nil {
+			fmt.Printf("restore role of node %d failed: %v\n", id, err)
+			continue
+		}
+		delete(f.roles, id)
+	}
+}
+
+func (f *faultInjector) assign(ctx context.Context, id uint64, role client.NodeRole) error {
+	for {
+		cli, err := client.FindLeader(ctx, f.store, client.WithDialFunc(f.dial))
+		if err == nil {
+			err = cli.Assign(ctx, id, role)
+			cli.Close()
+		}
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func filterNodes(nodes []nodeJSON, f func(nodeJSON) bool) []nodeJSON {
+	filtered := []nodeJSON{}
+	for _, node := range nodes {
+		if f(node) {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered
+}
+
+// Delay writes on the connections it wraps, when enabled.
+type latencyInjector struct {
+	delay int64 // Nanoseconds, accessed atomically.
+}
+
+func (l *latencyInjector) Set(delay time.Duration) {
+	atomic.StoreInt64(&l.delay, int64(delay))
+}
+
+func (l *latencyInjector) Wrap(dial client.DialFunc) client.DialFunc {
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		conn, err := dial(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		return &delayedConn{Conn: conn, delay: &l.delay}, nil
+	}
+}
+
+type delayedConn struct {
+	net.Conn
+	delay *int64
+}
+
+func (c *delayedConn) Write(b []byte) (int, error) {
+	if delay := atomic.LoadInt64(c.delay); delay > 0 {
+		time.Sleep(time.Duration(delay))
+	}
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/spf13/cobra"
+)
+
+// JSON representation of a cluster member.
+type nodeJSON struct {
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+	Role    string `json:"role"`
+	Leader  bool   `json:"leader"`
+}
+
+func newClusterCmd(globals *globalFlags) *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "cluster",
+		Short: "Manage cluster membership",
+	}
+
+	cmd.PersistentFlags().BoolVar(&asJSON, "json", false, "print output in JSON format")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the nodes in the cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withLeader(globals, func(ctx context.Context, cli *client.Client) error {
+				nodes, err := describeNodes(ctx, cli)
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					return printJSON(nodes)
+				}
+				for _, node := range nodes {
+					fmt.Println(formatNode(node))
+				}
+				return nil
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "describe <id|address>",
+		Short: "Show information about a single node",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withLeader(globals, func(ctx context.Context, cli *client.Client) error {
+				nodes, err := describeNodes(ctx, cli)
+				if err != nil {
+					return err
+				}
+				node, err := findNode(nodes, args[0])
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					return printJSON(node)
+				}
+				fmt.Printf("id: %d\naddress: %s\nrole: %s\nleader: %t\n",
+					node.ID, node.Address, node.Role, node.Leader)
+				return nil
+			})
+		},
+	})
+
+	var role string
+	add := &cobra.Command{
+		Use:   "add <id> <address>",
+		Short: "Add a node to the cluster",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			r, err := parseRole(role)
+			if err != nil {
+				return err
+			}
+			node := client.NodeInfo{ID: id, Address: args[1], Role: r}
+			return withLeader(globals, func(ctx context.Context, cli *client.Client) error {
+				return cli.Add(ctx, node)
+			})
+		},
+	}
+	add.Flags().StringVar(&role, "role", "voter", "role of the new node (voter, stand-by or spare)")
+	cmd.AddCommand(add)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove <id|address>",
+		Short: "Remove a node from the cluster",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withLeader(globals, func(ctx context.Context, cli *client.Client) error {
+				id, err := resolveID(ctx, cli, args[0])
+				if err != nil {
+					return err
+				}
+				return cli.Remove(ctx, id)
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "assign <id|address> <role>",
+		Short: "Assign a role to a node (voter, stand-by or spare)",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, err := parseRole(args[1])
+			if err != nil {
+				return err
+			}
+			return withLeader(globals, func(ctx context.Context, cli *client.Client) error {
+				id, err := resolveID(ctx, cli, args[0])
+				if err != nil {
+					return err
+				}
+				return cli.Assign(ctx, id, r)
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "transfer <id|address>",
+		Short: "Transfer leadership to another node",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withLeader(globals, func(ctx context.Context, cli *client.Client) error {
+				id, err := resolveID(ctx, cli, args[0])
+				if err != nil {
+					return err
+				}
+				return cli.Transfer(ctx, id)
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "verify [database...]",
+		Short: "Check that all nodes hold the same data",
+		Long: `Compare the last raft log index and term of all nodes, along with checksums
+of the content of the given databases, reporting any divergence between nodes
+having the same last index.
+
+The last index is only known for nodes started with the app package. Nodes
+lagging behind are not compared, so checks are more thorough when writes are
+paused.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withLeader(globals, func(ctx context.Context, cli *client.Client) error {
+				report, err := cli.VerifyConsistency(ctx, args...)
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					if err := printJSON(consistencyToJSON(report)); err != nil {
+						return err
+					}
+				} else {
+					printConsistency(report, args)
+				}
+				if !report.Consistent() {
+					cmd.SilenceUsage = true
+					return fmt.Errorf("found %d divergences", len(report.Divergences))
+				}
+				return nil
+			})
+		},
+	})
+
+	cmd.AddCommand(newWatchCmd(globals))
+
+	return cmd
+}
+
+// Connect to the cluster leader and invoke the given function.
+func withLeader(globals *globalFlags, f func(context.Context, *client.Client) error) error {
+	dial, err := globals.dial()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	cli, err := client.FindLeader(ctx, globals.store(), client.WithDialFunc(dial))
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	return f(ctx, cli)
+}
+
+// Return the cluster members, flagging the current leader.
+func describeNodes(ctx context.Context, cli *client.Client) ([]nodeJSON, error) {
+	cluster, err := cli.Cluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]nodeJSON, len(cluster))
+	for i, server := range cluster {
+		nodes[i] = nodeJSON{
+			ID:      server.ID,
+			Address: server.Address,
+			Role:    server.Role.String(),
+			Leader:  leader != nil && leader.ID == server.ID,
+		}
+	}
+
+	return nodes, nil
+}
+
+// Find the node matching the given ID or address.
+func findNode(nodes []nodeJSON, target string) (nodeJSON, error) {
+	id, idErr := parseID(target)
+	for _, node := range nodes {
+		if (idErr == nil && node.ID == id) || node.Address == target {
+			return node, nil
+		}
+	}
+	return nodeJSON{}, fmt.Errorf("no node matching %q", target)
+}
+
+// Resolve the given ID or address to a node ID.
+func resolveID(ctx context.Context, cli *client.Client, target string) (uint64, error) {
+	nodes, err := describeNodes(ctx, cli)
+	if err != nil {
+		return 0, err
+	}
+	node, err := findNode(nodes, target)
+	if err != nil {
+		return 0, err
+	}
+	return node.ID, nil
+}
+
+func parseID(s string) (uint64, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid node ID %q", s)
+	}
+	return id, nil
+}
+
+func parseRole(s string) (client.NodeRole, error) {
+	switch strings.ToLower(s) {
+	case "voter":
+		return client.Voter, nil
+	case "stand-by", "standby":
+		return client.StandBy, nil
+	case "spare":
+		return client.Spare, nil
+	}
+	return 0, fmt.Errorf("invalid role %q", s)
+}
+
+func formatNode(node nodeJSON) string {
+	s := fmt.Sprintf("%d|%s|%s", node.ID, node.Address, node.Role)
+	if node.Leader {
+		s += "|leader"
+	}
+	return s
+}
+
+// JSON representation of the state of a node checked by the verify command.
+type consistencyJSON struct {
+	ID        uint64            `json:"id"`
+	Address   string            `json:"address"`
+	LastIndex uint64            `json:"last_index"`
+	LastTerm  uint64            `json:"last_term"`
+	Checksums map[string]string `json:"checksums,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+func consistencyToJSON(report *client.ConsistencyReport) interface{} {
+	nodes := make([]consistencyJSON, len(report.Nodes))
+	for i, node := range report.Nodes {
+		nodes[i] = consistencyJSON{
+			ID:        node.Node.ID,
+			Address:   node.Node.Address,
+			LastIndex: node.LastIndex,
+			LastTerm:  node.LastTerm,
+			Checksums: node.Checksums,
+			Error:     node.Error,
+		}
+	}
+	return struct {
+		Nodes       []consistencyJSON `json:"nodes"`
+		Divergences []string          `json:"divergences"`
+	}{nodes, report.Divergences}
+}
+
+func printConsistency(report *client.ConsistencyReport, databases []string) {
+	for _, node := range report.Nodes {
+		if node.Error != "" {
+			fmt.Printf("%d|%s|error: %s\n", node.Node.ID, node.Node.Address, node.Error)
+			continue
+		}
+		s := fmt.Sprintf("%d|%s|%d|%d", node.Node.ID, node.Node.Address, node.LastIndex, node.LastTerm)
+		for _, database := range databases {
+			s += fmt.Sprintf("|%s=%s", database, node.Checksums[database])
+		}
+		fmt.Println(s)
+	}
+	for _, divergence := range report.Divergences {
+		fmt.Println("divergence:", divergence)
+	}
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -2,86 +2,165 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
-	"github.com/canonical/go-dqlite/app"
 	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/cli"
 	"github.com/canonical/go-dqlite/internal/shell"
 	"github.com/peterh/liner"
 	"github.com/spf13/cobra"
 )
 
-func main() {
-	var crt string
-	var key string
-	var servers *[]string
+// Flags shared by the shell and all subcommands.
+type globalFlags struct {
+	tls     cli.TLS
+	servers []string
+}
 
-	cmd := &cobra.Command{
-		Use:   "dqlite -s <servers> <database> [command]",
-		Short: "Standard dqlite shell",
-		Args:  cobra.RangeArgs(1, 2),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			infos := make([]client.NodeInfo, len(*servers))
-			for i, address := range *servers {
-				infos[i].Address = address
-			}
+// Return a node store populated with the servers given on the command line.
+func (g *globalFlags) store() client.NodeStore {
+	infos := make([]client.NodeInfo, len(g.servers))
+	for i, address := range g.servers {
+		infos[i].Address = address
+	}
+
+	store := client.NewInmemNodeStore()
+	store.Set(context.Background(), infos)
+
+	return store
+}
 
-			store := client.NewInmemNodeStore()
-			store.Set(context.Background(), infos)
+// Return a dial function honoring the TLS flags given on the command line.
+func (g *globalFlags) dial() (client.DialFunc, error) {
+	return g.tls.DialFunc()
+}
+
+// Execute all the statements in the given text, stopping at the first error.
+func runBatch(ctx context.Context, sh *shell.Shell, text string, singleTx bool) error {
+	if singleTx {
+		if err := sh.Begin(ctx); err != nil {
+			return err
+		}
+	}
 
-			if (crt != "" && key == "") || (key != "" && crt == "") {
-				return fmt.Errorf("both TLS certificate and key must be given")
+	for i, statement := range shell.SplitStatements(text) {
+		result, err := sh.Process(ctx, statement)
+		if err != nil {
+			if singleTx {
+				sh.Rollback()
 			}
+			return fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		if result != "" {
+			fmt.Println(result)
+		}
+	}
 
-			dial := client.DefaultDialFunc
+	if singleTx {
+		if err := sh.Commit(); err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+	}
 
-			if crt != "" {
-				cert, err := tls.LoadX509KeyPair(crt, key)
-				if err != nil {
-					return err
-				}
+	return nil
+}
 
-				data, err := ioutil.ReadFile(crt)
-				if err != nil {
-					return err
-				}
+// Return true if the given file is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
 
-				pool := x509.NewCertPool()
-				if !pool.AppendCertsFromPEM(data) {
-					return fmt.Errorf("bad certificate")
-				}
+// Return the path of the file holding the shell history, either from the
+// DQLITE_HISTORY environment variable or in the user's home directory.
+func historyFile() string {
+	if path := os.Getenv("DQLITE_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".dqlite_history")
+}
+
+// Persist the shell history, ignoring failures.
+func saveHistory(line *liner.State, path string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	line.WriteHistory(f)
+}
 
-				config := app.SimpleDialTLSConfig(cert, pool)
-				dial = client.DialFuncWithTLS(dial, config)
+func main() {
+	globals := &globalFlags{}
+	var format string
+	var file string
+	var singleTx bool
+
+	cmd := &cobra.Command{
+		Use:   "dqlite -s <servers> <database> [command]",
+		Short: "Standard dqlite shell",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := globals.store()
 
+			dial, err := globals.dial()
+			if err != nil {
+				return err
 			}
 
-			sh, err := shell.New(args[0], store, shell.WithDialFunc(dial))
+			sh, err := shell.New(args[0], store, shell.WithDialFunc(dial), shell.WithFormat(format))
 			if err != nil {
 				return err
 			}
+			defer sh.Close()
+
+			// Errors from now on are about the SQL being executed, not
+			// about command line usage.
+			cmd.SilenceUsage = true
 
 			if len(args) > 1 {
-				for _, input := range strings.Split(args[1], ";") {
-					result, err := sh.Process(context.Background(), input)
-					if err != nil {
-						return err
-					} else if result != "" {
-						fmt.Println(result)
-					}
+				return runBatch(context.Background(), sh, args[1], singleTx)
+			}
+
+			if file != "" || !isTerminal(os.Stdin) {
+				var data []byte
+				if file == "" || file == "-" {
+					data, err = ioutil.ReadAll(os.Stdin)
+				} else {
+					data, err = ioutil.ReadFile(file)
 				}
-				return nil
+				if err != nil {
+					return err
+				}
+				return runBatch(context.Background(), sh, string(data), singleTx)
 			}
 
 			line := liner.NewLiner()
 			defer line.Close()
 
+			line.SetCompleter(sh.Complete)
+
+			history := historyFile()
+			if history != "" {
+				if f, err := os.Open(history); err == nil {
+					line.ReadHistory(f)
+					f.Close()
+				}
+				defer saveHistory(line, history)
+			}
+
 			for {
 				input, err := line.Prompt("dqlite> ")
 				if err != nil {
@@ -91,6 +170,10 @@ func main() {
 					return err
 				}
 
+				if strings.TrimSpace(input) != "" {
+					line.AppendHistory(input)
+				}
+
 				result, err := sh.Process(context.Background(), input)
 				if err != nil {
 					fmt.Println("Error: ", err)
@@ -103,12 +186,21 @@ func main() {
 		},
 	}
 
-	flags := cmd.Flags()
-	servers = flags.StringSliceP("servers", "s", nil, "comma-separated list of db servers")
-	flags.StringVarP(&crt, "cert", "c", "", "public TLS cert")
-	flags.StringVarP(&key, "key", "k", "", "private TLS key")
+	flags := cmd.PersistentFlags()
+	flags.StringSliceVarP(&globals.servers, "servers", "s", nil, "comma-separated list of db servers")
+	globals.tls.AddFlags(flags, true)
+
+	cmd.MarkPersistentFlagRequired("servers")
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "execute the SQL statements in the given file (- for stdin)")
+	cmd.Flags().BoolVar(&singleTx, "single-transaction", false, "execute all statements in a single transaction")
+	cmd.Flags().StringVar(&format, "format", "list", "output format (list, table, csv, tsv or json)")
 
-	cmd.MarkFlagRequired("servers")
+	cmd.AddCommand(newClusterCmd(globals))
+	cmd.AddCommand(newTopCmd(globals))
+	cmd.AddCommand(newChaosCmd(globals))
+	cmd.AddCommand(newImportCmd(globals))
+	cmd.AddCommand(newExportCmd(globals))
 
 	if err := cmd.Execute(); err != nil {
 		os.Exit(1)
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/spf13/cobra"
+)
+
+// Suffixes of the names of the metrics read by the top command, which don't
+// depend on the namespace of the metrics of the nodes.
+const (
+	metricQueries     = "_driver_queries_total"
+	metricDurationSum = "_protocol_request_duration_seconds_sum"
+	metricDurationN   = "_protocol_request_duration_seconds_count"
+	metricConnections = "_proxy_connections"
+)
+
+// Names of the memory metrics exposed by the Go client of Prometheus, in
+// order of preference.
+var memoryMetrics = []string{"process_resident_memory_bytes", "go_memstats_alloc_bytes"}
+
+// Sample of the state of a node taken by the top command.
+type topSample struct {
+	nodeJSON
+	Time    time.Time
+	RTT     time.Duration
+	Index   uint64             // Last raft log index, 0 if unknown.
+	Metrics map[string]float64 // Metrics scraped from the node, if any.
+	Err     error
+}
+
+func newTopCmd(globals *globalFlags) *cobra.Command {
+	var interval time.Duration
+	var timeout time.Duration
+	var metricsPort int
+
+	cmd := &cobra.Command{
+		Use:   "top",
+		Short: "Continuously display activity and lag of each node",
+		Long: `Continuously display, for each node of the cluster, the round-trip time
+of a request to it, the index of its last raft log entry, how far it lags
+behind the leader and the rate at which its log grows, refreshing the view in
+place. The index is only reported by App nodes.
+
+With --metrics-port, the /metrics endpoint served on that port by the debug
+listener of App nodes (see app.WithDebugAddress) is scraped as well, to show
+the rate of queries run by the node's application, the average latency of its
+requests, the number of connections to its proxy and its memory usage.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dial, err := globals.dial()
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ch := make(chan os.Signal, 1)
+			signal.Notify(ch, os.Interrupt)
+			defer signal.Stop(ch)
+			go func() {
+				<-ch
+				cancel()
+			}()
+
+			store := globals.store()
+			previous := map[uint64]topSample{}
+			for {
+				samples, err := sample(ctx, store, dial, timeout, metricsPort)
+
+				fmt.Print("\033[H\033[2J")
+				renderTop(os.Stdout, time.Now(), samples, previous, err)
+
+				for _, s := range samples {
+					if s.Err == nil {
+						previous[s.ID] = s
+					}
+				}
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(interval):
+				}
+			}
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.DurationVarP(&interval, "interval", "n", 2*time.Second, "refresh interval")
+	flags.DurationVar(&timeout, "timeout", time.Second, "timeout for requests to each node")
+	flags.IntVar(&metricsPort, "metrics-port", 0, "port of the debug listener of the nodes, to scrape their metrics")
+
+	return cmd
+}
+
+// Query the leader for membership, and then sample each member.
+func sample(ctx context.Context, store client.NodeStore, dial client.DialFunc, timeout time.Duration, metricsPort int) ([]topSample, error) {
+	findCtx, cancel := context.WithTimeout(ctx, timeout*5)
+	defer cancel()
+
+	cli, err := client.FindLeader(findCtx, store, client.WithDialFunc(dial))
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := describeNodes(findCtx, cli)
+	cli.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]topSample, len(nodes))
+	wg := sync.WaitGroup{}
+	for i, node := range nodes {
+		samples[i].nodeJSON = node
+		wg.Add(1)
+		go func(s *topSample) {
+			defer wg.Done()
+			sampleNode(ctx, s, dial, timeout, metricsPort)
+		}(&samples[i])
+	}
+	wg.Wait()
+
+	return samples, nil
+}
+
+// Sample a single node.
+func sampleNode(ctx context.Context, s *topSample, dial client.DialFunc, timeout time.Duration, metricsPort int) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	s.Time = time.Now()
+	cli, err := client.New(ctx, s.Address, client.WithDialFunc(dial))
+	if err != nil {
+		s.Err = err
+		return
+	}
+	defer cli.Close()
+
+	if _, err := cli.Leader(ctx); err != nil {
+		s.Err = err
+		return
+	}
+	s.RTT = time.Since(s.Time)
+
+	// Plain dqlite nodes don't report their index.
+	s.Index, _ = cli.LastIndex(ctx)
+
+	if metricsPort == 0 {
+		return
+	}
+	host, _, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		return
+	}
+	url := fmt.Sprintf("http://%s/metrics", net.JoinHostPort(host, strconv.Itoa(metricsPort)))
+	s.Metrics, _ = scrapeMetrics(ctx, url)
+}
+
+// Fetch the metrics in the Prometheus text format at the given URL, summing
+// the values of the samples of each metric across all labels.
+func scrapeMetrics(ctx context.Context, url string) (map[string]float64, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+
+	metrics := map[string]float64{}
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, rest := line, ""
+		if i := strings.IndexAny(line, "{ "); i >= 0 {
+			name, rest = line[:i], line[i:]
+		}
+		if i := strings.LastIndex(rest, "}"); i >= 0 {
+			rest = rest[i+1:]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		metrics[name] += value
+	}
+
+	return metrics, scanner.Err()
+}
+
+// Return the value of the metric whose name has the given suffix.
+func metricValue(metrics map[string]float64, suffix string) (float64, bool) {
+	for name, value := range metrics {
+		if strings.HasSuffix(name, suffix) {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+func renderTop(w io.Writer, now time.Time, samples []topSample, previous map[uint64]topSample, err error) {
+	fmt.Fprintf(w, "%s\n\n", now.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		return
+	}
+
+	leaderIndex := uint64(0)
+	for _, s := range samples {
+		if s.Leader && s.Err == nil {
+			leaderIndex = s.Index
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tADDRESS\tROLE\tLEADER\tRTT\tINDEX\tLAG\tENTRIES/S\tQPS\tLATENCY\tCONNS\tMEMORY")
+	for _, s := range samples {
+		leader := ""
+		if s.Leader {
+			leader = "*"
+		}
+		if s.Err != nil {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", s.ID, s.Address, s.Role, leader,
+				strings.SplitN(s.Err.Error(), "\n", 2)[0])
+			continue
+		}
+
+		index, lag, entries := "-", "-", "-"
+		qps, latency, conns, memory := "-", "-", "-", "-"
+		prev, ok := previous[s.ID]
+		elapsed := s.Time.Sub(prev.Time).Seconds()
+		ok = ok && elapsed > 0
+
+		if s.Index > 0 {
+			index = strconv.FormatUint(s.Index, 10)
+			if leaderIndex >= s.Index {
+				lag = strconv.FormatUint(leaderIndex-s.Index, 10)
+			}
+			if ok && prev.Index > 0 && s.Index >= prev.Index {
+				entries = fmt.Sprintf("%.1f", float64(s.Index-prev.Index)/elapsed)
+			}
+		}
+
+		if s.Metrics != nil {
+			if ok && prev.Metrics != nil {
+				if rate, ok := metricDelta(s.Metrics, prev.Metrics, metricQueries); ok {
+					qps = fmt.Sprintf("%.1f", rate/elapsed)
+				}
+				sum, ok1 := metricDelta(s.Metrics, prev.Metrics, metricDurationSum)
+				n, ok2 := metricDelta(s.Metrics, prev.Metrics, metricDurationN)
+				if ok1 && ok2 && n > 0 {
+					latency = time.Duration(sum / n * float64(time.Second)).Round(time.Microsecond).String()
+				}
+			}
+			if value, ok := metricValue(s.Metrics, metricConnections); ok {
+				conns = strconv.Itoa(int(value))
+			}
+			for _, name := range memoryMetrics {
+				if value, ok := s.Metrics[name]; ok {
+					memory = fmt.Sprintf("%.1fM", value/(1<<20))
+					break
+				}
+			}
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.ID, s.Address, s.Role, leader, s.RTT.Round(time.Microsecond), index, lag, entries,
+			qps, latency, conns, memory)
+	}
+	tw.Flush()
+}
+
+// Return the increase of the metric whose name has the given suffix between
+// two scrapes.
+func metricDelta(current, previous map[string]float64, suffix string) (float64, bool) {
+	value, ok1 := metricValue(current, suffix)
+	before, ok2 := metricValue(previous, suffix)
+	if !ok1 || !ok2 || value < before {
+		return 0, false
+	}
+	return value - before, true
+}
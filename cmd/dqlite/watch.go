@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/spf13/cobra"
+)
+
+// Status of a single node as observed by the watch command.
+type nodeStatus struct {
+	nodeJSON
+	Reachable  bool
+	RTT        time.Duration
+	SeenLeader string // Leader address as reported by the node itself.
+	Err        error
+}
+
+func newWatchCmd(globals *globalFlags) *cobra.Command {
+	var interval time.Duration
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Continuously display cluster membership and node health",
+		Long: `Continuously display membership, roles and leader of the cluster, along
+with the reachability of each node, the round-trip time of a request to it
+and the leader it reports, refreshing the view in place.
+
+A node reporting a different leader than the others is typically lagging
+behind, for example during a failover.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dial, err := globals.dial()
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ch := make(chan os.Signal, 1)
+			signal.Notify(ch, os.Interrupt)
+			defer signal.Stop(ch)
+			go func() {
+				<-ch
+				cancel()
+			}()
+
+			store := globals.store()
+			for {
+				fmt.Print("\033[H\033[2J")
+				renderWatch(os.Stdout, observe(ctx, store, dial, timeout))
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(interval):
+				}
+			}
+		},
+	}
+
+	cmd.Flags().DurationVarP(&interval, "interval", "n", 2*time.Second, "refresh interval")
+	cmd.Flags().DurationVar(&timeout, "timeout", time.Second, "timeout for requests to each node")
+
+	return cmd
+}
+
+// Snapshot of the cluster state.
+type clusterStatus struct {
+	Time  time.Time
+	Nodes []nodeStatus
+	Err   error
+}
+
+// Query the leader for membership, and then each member for its health.
+func observe(ctx context.Context, store client.NodeStore, dial client.DialFunc, timeout time.Duration) clusterStatus {
+	status := clusterStatus{Time: time.Now()}
+
+	findCtx, cancel := context.WithTimeout(ctx, timeout*5)
+	defer cancel()
+
+	cli, err := client.FindLeader(findCtx, store, client.WithDialFunc(dial))
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	nodes, err := describeNodes(findCtx, cli)
+	cli.Close()
+	if err != nil {
+		status.Err = err
+		return status
+	}
+
+	status.Nodes = make([]nodeStatus, len(nodes))
+	wg := sync.WaitGroup{}
+	for i, node := range nodes {
+		status.Nodes[i].nodeJSON = node
+		wg.Add(1)
+		go func(s *nodeStatus) {
+			defer wg.Done()
+			probe(ctx, s, dial, timeout)
+		}(&status.Nodes[i])
+	}
+	wg.Wait()
+
+	return status
+}
+
+// Connect to a single node and ask it who the leader is.
+func probe(ctx context.Context, s *nodeStatus, dial client.DialFunc, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	cli, err := client.New(ctx, s.Address, client.WithDialFunc(dial))
+	if err != nil {
+		s.Err = err
+		return
+	}
+	defer cli.Close()
+
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		s.Err = err
+		return
+	}
+
+	s.Reachable = true
+	s.RTT = time.Since(start)
+	if leader != nil {
+		s.SeenLeader = leader.Address
+	}
+}
+
+func renderWatch(w io.Writer, status clusterStatus) {
+	fmt.Fprintf(w, "%s\n\n", status.Time.Format("2006-01-02 15:04:05"))
+	if status.Err != nil {
+		fmt.Fprintf(w, "error: %v\n", status.Err)
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tADDRESS\tROLE\tLEADER\tREACHABLE\tRTT\tSEES LEADER")
+	for _, node := range status.Nodes {
+		leader := ""
+		if node.Leader {
+			leader = "*"
+		}
+		reachable := "yes"
+		rtt := node.RTT.Round(time.Microsecond).String()
+		seen := node.SeenLeader
+		if !node.Reachable {
+			reachable = "no"
+			rtt = "-"
+			seen = strings.SplitN(node.Err.Error(), "\n", 2)[0]
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			node.ID, node.Address, node.Role, leader, reachable, rtt, seen)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,90 @@
+package coordination
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RunAsLeader campaigns for the leadership of the given name and runs fn
+// while holding it.
+//
+// Leadership is a lock refreshed in the background every third of its TTL.
+// If it's lost, for example because this node couldn't reach the cluster for
+// too long, the context passed to fn is canceled, and once fn returns the
+// campaign starts over. RunAsLeader returns when fn returns while still
+// leader, releasing the leadership, or when the given context is done.
+func RunAsLeader(ctx context.Context, db *sql.DB, name string, fn func(context.Context) error, options ...Option) error {
+	lock, err := NewLock(db, name, options...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err := lock.Lock(ctx); err != nil {
+			return err
+		}
+
+		lost, err := lead(ctx, lock, fn)
+		if lost {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		release, cancel := context.WithTimeout(context.Background(), lock.ttl)
+		unlockErr := lock.Unlock(release)
+		cancel()
+		if err == nil && !errors.Is(unlockErr, ErrLockLost) {
+			err = unlockErr
+		}
+
+		return err
+	}
+}
+
+// Run fn while refreshing the given lock, returning whether leadership was
+// lost while it was running and the error of fn.
+func lead(ctx context.Context, lock *Lock, fn func(context.Context) error) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lost := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		refreshed := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(lock.ttl / 3):
+			}
+			err := lock.Refresh(ctx)
+			if err == nil {
+				refreshed = time.Now()
+				continue
+			}
+			// Give up if the lock was taken over, or if it might
+			// expire before the next attempt.
+			if errors.Is(err, ErrLockLost) || time.Since(refreshed) > lock.ttl*2/3 {
+				close(lost)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	err := fn(ctx)
+	cancel()
+	<-done
+
+	select {
+	case <-lost:
+		return true, err
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,255 @@
+// Package coordination provides named distributed locks and leader election
+// backed by a dqlite table.
+//
+// Locks are rows of the coordination_locks table, holding the ID of the
+// holder and an expiration time. Since all writes go through the cluster
+// leader, taking a lock is atomic cluster-wide. Holders must refresh their
+// locks before they expire, otherwise other candidates can take them over,
+// which protects against holders that crash or get partitioned away.
+//
+// Expiration times are computed with the local clock of each node, so node
+// clocks are expected to be synchronized with an error much smaller than the
+// lock TTL.
+package coordination
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrLockLost is returned when refreshing or releasing a lock that expired
+// and was possibly taken by another holder.
+var ErrLockLost = fmt.Errorf("lock lost")
+
+const schema = `
+CREATE TABLE IF NOT EXISTS coordination_locks (
+    name       TEXT PRIMARY KEY,
+    holder     TEXT NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+`
+
+// Option can be used to tweak lock parameters.
+type Option func(*options)
+
+// WithTTL sets how long a lock is held without being refreshed before other
+// candidates can take it over, 15 seconds by default.
+func WithTTL(ttl time.Duration) Option {
+	return func(options *options) {
+		options.TTL = ttl
+	}
+}
+
+// WithRetryInterval sets how often a candidate waiting for a lock tries to
+// take it, 1 second by default.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(options *options) {
+		options.RetryInterval = interval
+	}
+}
+
+// WithHolder sets the ID identifying the holder of the lock, which must be
+// unique among candidates. By default a random ID including hostname and PID
+// is generated.
+func WithHolder(holder string) Option {
+	return func(options *options) {
+		options.Holder = holder
+	}
+}
+
+type options struct {
+	TTL           time.Duration
+	RetryInterval time.Duration
+	Holder        string
+}
+
+// Create a options object with sane defaults.
+func defaultOptions() *options {
+	return &options{
+		TTL:           15 * time.Second,
+		RetryInterval: time.Second,
+	}
+}
+
+// Lock is a named distributed lock.
+type Lock struct {
+	db            *sql.DB
+	name          string
+	holder        string
+	ttl           time.Duration
+	retryInterval time.Duration
+
+	mu    sync.Mutex
+	setup bool // Whether the locks table was created.
+}
+
+// NewLock returns a handle to the distributed lock with the given name.
+func NewLock(db *sql.DB, name string, options ...Option) (*Lock, error) {
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+
+	if o.Holder == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		hostname, _ := os.Hostname()
+		o.Holder = fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), hex.EncodeToString(id))
+	}
+
+	l := &Lock{
+		db:            db,
+		name:          name,
+		holder:        o.Holder,
+		ttl:           o.TTL,
+		retryInterval: o.RetryInterval,
+	}
+
+	return l, nil
+}
+
+// Name returns the name of the lock.
+func (l *Lock) Name() string {
+	return l.name
+}
+
+// Holder returns the ID this handle takes the lock with.
+func (l *Lock) Holder() string {
+	return l.holder
+}
+
+// TTL returns how long the lock is held without being refreshed.
+func (l *Lock) TTL() time.Duration {
+	return l.ttl
+}
+
+// TryLock takes the lock if it's free or expired, returning whether it
+// succeeded. Taking a lock already held by this handle refreshes it.
+func (l *Lock) TryLock(ctx context.Context) (bool, error) {
+	if err := l.ensureSchema(ctx); err != nil {
+		return false, err
+	}
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx, `
+INSERT INTO coordination_locks (name, holder, expires_at) VALUES (?, ?, ?)
+ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+WHERE coordination_locks.holder = excluded.holder OR coordination_locks.expires_at < ?`,
+		l.name, l.holder, now.Add(l.ttl).UnixNano(), now.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// Lock waits until the lock is taken, or the given context is done.
+func (l *Lock) Lock(ctx context.Context) error {
+	for {
+		ok, err := l.TryLock(ctx)
+		if err != nil {
+			return fmt.Errorf("acquire lock %s: %w", l.name, err)
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("acquire lock %s: %w", l.name, ctx.Err())
+		case <-time.After(l.retryInterval):
+		}
+	}
+}
+
+// Refresh extends the expiration of the lock, returning ErrLockLost if it's
+// not held by this handle anymore.
+func (l *Lock) Refresh(ctx context.Context) error {
+	result, err := l.db.ExecContext(ctx,
+		"UPDATE coordination_locks SET expires_at = ? WHERE name = ? AND holder = ? AND expires_at >= ?",
+		time.Now().Add(l.ttl).UnixNano(), l.name, l.holder, time.Now().UnixNano())
+	if err != nil {
+		return fmt.Errorf("refresh lock %s: %w", l.name, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("refresh lock %s: %w", l.name, err)
+	}
+	if n != 1 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Unlock releases the lock, returning ErrLockLost if it was not held by this
+// handle anymore.
+func (l *Lock) Unlock(ctx context.Context) error {
+	result, err := l.db.ExecContext(ctx,
+		"DELETE FROM coordination_locks WHERE name = ? AND holder = ?", l.name, l.holder)
+	if err != nil {
+		return fmt.Errorf("release lock %s: %w", l.name, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("release lock %s: %w", l.name, err)
+	}
+	if n != 1 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Holder returns the ID of the current holder of the lock with the given
+// name, or an empty string if the lock is free.
+func Holder(ctx context.Context, db *sql.DB, name string) (string, error) {
+	// The table doesn't exist until a lock is first taken.
+	var n int
+	err := db.QueryRowContext(ctx,
+		"SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'coordination_locks'").Scan(&n)
+	if err != nil || n == 0 {
+		return "", err
+	}
+
+	holder := ""
+	err = db.QueryRowContext(ctx,
+		"SELECT holder FROM coordination_locks WHERE name = ? AND expires_at >= ?",
+		name, time.Now().UnixNano()).Scan(&holder)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+
+	return holder, nil
+}
+
+func (l *Lock) ensureSchema(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.setup {
+		return nil
+	}
+	if _, err := l.db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("create locks table: %w", err)
+	}
+	l.setup = true
+	return nil
+}
@@ -0,0 +1,159 @@
+package coordination_test
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/coordination"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	holder, err := coordination.Holder(ctx, db, "jobs")
+	require.NoError(t, err)
+	assert.Equal(t, "", holder)
+
+	a, err := coordination.NewLock(db, "jobs", coordination.WithHolder("a"))
+	require.NoError(t, err)
+	b, err := coordination.NewLock(db, "jobs", coordination.WithHolder("b"))
+	require.NoError(t, err)
+
+	ok, err := a.TryLock(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = b.TryLock(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	holder, err = coordination.Holder(ctx, db, "jobs")
+	require.NoError(t, err)
+	assert.Equal(t, "a", holder)
+
+	require.NoError(t, a.Refresh(ctx))
+	require.NoError(t, a.Unlock(ctx))
+
+	ok, err = b.TryLock(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Equal(t, coordination.ErrLockLost, a.Refresh(ctx))
+}
+
+// An expired lock can be taken over, and the previous holder loses it.
+func TestLock_Expired(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	a, err := coordination.NewLock(db, "jobs", coordination.WithTTL(10*time.Millisecond))
+	require.NoError(t, err)
+	b, err := coordination.NewLock(db, "jobs", coordination.WithRetryInterval(5*time.Millisecond))
+	require.NoError(t, err)
+
+	ok, err := a.TryLock(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, b.Lock(ctx))
+
+	assert.Equal(t, coordination.ErrLockLost, a.Refresh(ctx))
+	assert.Equal(t, coordination.ErrLockLost, a.Unlock(ctx))
+}
+
+func TestRunAsLeader(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	options := []coordination.Option{
+		coordination.WithTTL(100 * time.Millisecond),
+		coordination.WithRetryInterval(5 * time.Millisecond),
+	}
+
+	// The first candidate leads until its context is canceled.
+	leading := make(chan struct{})
+	ctx1, cancel1 := context.WithCancel(ctx)
+	done1 := make(chan error)
+	go func() {
+		done1 <- coordination.RunAsLeader(ctx1, db, "scheduler", func(ctx context.Context) error {
+			close(leading)
+			<-ctx.Done()
+			return ctx.Err()
+		}, options...)
+	}()
+	<-leading
+
+	// The second one waits for the leadership.
+	done2 := make(chan error)
+	go func() {
+		done2 <- coordination.RunAsLeader(ctx, db, "scheduler", func(ctx context.Context) error {
+			return nil
+		}, options...)
+	}()
+
+	select {
+	case <-done2:
+		t.Fatal("second candidate ran while the first was leader")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	cancel1()
+	assert.Equal(t, context.Canceled, <-done1)
+	assert.NoError(t, <-done2)
+}
+
+// The function's context is canceled if leadership is lost.
+func TestRunAsLeader_Lost(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	runs := 0
+	err := coordination.RunAsLeader(ctx, db, "scheduler", func(ctx context.Context) error {
+		runs++
+		if runs == 2 {
+			return nil
+		}
+		_, err := db.Exec("DELETE FROM coordination_locks")
+		require.NoError(t, err)
+		<-ctx.Done()
+		return ctx.Err()
+	}, coordination.WithTTL(30*time.Millisecond), coordination.WithRetryInterval(5*time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, 2, runs)
+}
+
+func newDB(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "dqlite-coordination-test-")
+	require.NoError(t, err)
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db")+"?_busy_timeout=5000")
+	require.NoError(t, err)
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	return db, cleanup
+}
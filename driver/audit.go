@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// AuditRecord holds information about a DDL or write statement executed
+// through the driver.
+type AuditRecord struct {
+	Time      time.Time // Time at which the statement completed.
+	Node      string    // Address of the node that executed the statement.
+	Database  string    // Name of the database.
+	Principal string    // Principal attached to the context, if any.
+	SQL       string    // SQL text of the statement.
+	Err       error     // Error returned by the statement, or nil.
+}
+
+// AuditSink receives audit records. It's called synchronously from the
+// goroutine executing the statement, so implementations should not block.
+type AuditSink interface {
+	Audit(record AuditRecord)
+}
+
+// AuditFunc adapts a plain function to the AuditSink interface.
+type AuditFunc func(record AuditRecord)
+
+// Audit implements AuditSink.
+func (f AuditFunc) Audit(record AuditRecord) {
+	f(record)
+}
+
+// WithAuditSink sets a sink receiving a record for every DDL or write
+// statement executed through the driver, whether it succeeds or fails.
+//
+// Statements are recognized by their leading keyword (INSERT, UPDATE,
+// DELETE, REPLACE, CREATE, DROP, ALTER), and statements starting with WITH
+// are always recorded, since they might modify data. A successful
+// statement is recorded even if the enclosing transaction is later rolled
+// back.
+func WithAuditSink(sink AuditSink) Option {
+	return func(options *options) {
+		options.AuditSink = sink
+	}
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the given context carrying the given
+// principal, which is included in the audit records of statements executed
+// with that context.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal attached to the given context
+// with WithPrincipal, or an empty string.
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// Report auditable statements to a sink.
+type auditLog struct {
+	sink AuditSink
+}
+
+// Send an audit record for the given statement if it's auditable. It's a
+// no-op if auditing is disabled.
+func (l *auditLog) record(ctx context.Context, node string, database string, sql string, err error) {
+	if l == nil || !isAuditable(sql) {
+		return
+	}
+	l.sink.Audit(AuditRecord{
+		Time:      time.Now(),
+		Node:      node,
+		Database:  database,
+		Principal: PrincipalFromContext(ctx),
+		SQL:       sql,
+		Err:       err,
+	})
+}
+
+// Leading keywords of the statements to audit.
+var auditKeywords = map[string]bool{
+	"INSERT":  true,
+	"UPDATE":  true,
+	"DELETE":  true,
+	"REPLACE": true,
+	"CREATE":  true,
+	"DROP":    true,
+	"ALTER":   true,
+	"WITH":    true,
+}
+
+// Return true if the given SQL text starts with a DDL or write statement.
+func isAuditable(sql string) bool {
+	sql = skipComments(sql)
+	end := 0
+	for end < len(sql) && isIdentifier(sql[end]) {
+		end++
+	}
+	return auditKeywords[strings.ToUpper(sql[:end])]
+}
+
+// Skip leading whitespace and comments in the given SQL text.
+func skipComments(sql string) string {
+	for {
+		sql = strings.TrimLeft(sql, " \t\r\n")
+		switch {
+		case strings.HasPrefix(sql, "--"):
+			i := strings.IndexByte(sql, '\n')
+			if i == -1 {
+				return ""
+			}
+			sql = sql[i+1:]
+		case strings.HasPrefix(sql, "/*"):
+			i := strings.Index(sql, "*/")
+			if i == -1 {
+				return ""
+			}
+			sql = sql[i+2:]
+		default:
+			return sql
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAuditable(t *testing.T) {
+	cases := []struct {
+		sql       string
+		auditable bool
+	}{
+		{"INSERT INTO t VALUES(1)", true},
+		{"  update t SET n = 1", true},
+		{"-- comment\nDELETE FROM t", true},
+		{"/* comment */ CREATE TABLE t (n INT)", true},
+		{"SELECT * FROM t", false},
+		{"BEGIN", false},
+		{"COMMIT", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			assert.Equal(t, c.auditable, isAuditable(c.sql))
+		})
+	}
+}
+
+func TestAuditSink(t *testing.T) {
+	records := []AuditRecord{}
+	sink := AuditFunc(func(record AuditRecord) {
+		records = append(records, record)
+	})
+
+	server, db := newFakeDB(t, WithAuditSink(sink))
+	server.SetExec("INSERT INTO t VALUES(1)", 0, 1)
+	server.SetQuery("SELECT n FROM t", fakeserver.Rows{Columns: []string{"n"}})
+
+	ctx := WithPrincipal(context.Background(), "alice")
+
+	_, err := db.ExecContext(ctx, "INSERT INTO t VALUES(1)")
+	require.NoError(t, err)
+
+	rows, err := db.QueryContext(ctx, "SELECT n FROM t")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	require.Len(t, records, 1)
+	assert.Equal(t, server.Address(), records[0].Node)
+	assert.Equal(t, "test", records[0].Database)
+	assert.Equal(t, "alice", records[0].Principal)
+	assert.Equal(t, "INSERT INTO t VALUES(1)", records[0].SQL)
+	assert.NoError(t, records[0].Err)
+}
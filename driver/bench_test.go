@@ -0,0 +1,256 @@
+package driver_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dqlite "github.com/canonical/go-dqlite"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The benchmarks below run the same workload through the dqlite driver
+// against a single in-process node, and through go-sqlite3 against a local
+// database file in WAL mode, as a baseline for the overhead of the client
+// path. Run them with:
+//
+//   make bench
+
+const benchSchema = "CREATE TABLE bench (id INTEGER PRIMARY KEY, value TEXT)"
+
+// Rows populated before running read benchmarks.
+const benchRows = 1000
+
+// A database under benchmark, along with its name.
+type benchDB struct {
+	name string
+	open func(b *testing.B) *sql.DB
+}
+
+var benchDBs = []benchDB{
+	{"dqlite", openBenchDqlite},
+	{"sqlite", openBenchSQLite},
+}
+
+func BenchmarkInsert(b *testing.B) {
+	for _, bdb := range benchDBs {
+		b.Run(bdb.name, func(b *testing.B) {
+			db := bdb.open(b)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Exec("INSERT INTO bench(value) VALUES(?)", "hello"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSelect(b *testing.B) {
+	for _, bdb := range benchDBs {
+		b.Run(bdb.name, func(b *testing.B) {
+			db := bdb.open(b)
+			populateBench(b, db)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			var value string
+			for i := 0; i < b.N; i++ {
+				row := db.QueryRow("SELECT value FROM bench WHERE id = ?", i%benchRows+1)
+				if err := row.Scan(&value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSelectPrepared(b *testing.B) {
+	for _, bdb := range benchDBs {
+		b.Run(bdb.name, func(b *testing.B) {
+			db := bdb.open(b)
+			populateBench(b, db)
+
+			stmt, err := db.Prepare("SELECT value FROM bench WHERE id = ?")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer stmt.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			var value string
+			for i := 0; i < b.N; i++ {
+				if err := stmt.QueryRow(i%benchRows + 1).Scan(&value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	for _, bdb := range benchDBs {
+		b.Run(bdb.name, func(b *testing.B) {
+			db := bdb.open(b)
+			populateBench(b, db)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				rows, err := db.Query("SELECT id, value FROM bench")
+				if err != nil {
+					b.Fatal(err)
+				}
+				var id int64
+				var value string
+				for rows.Next() {
+					if err := rows.Scan(&id, &value); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := rows.Err(); err != nil {
+					b.Fatal(err)
+				}
+				rows.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkTransaction(b *testing.B) {
+	for _, size := range []int{1, 10, 100} {
+		for _, bdb := range benchDBs {
+			b.Run(fmt.Sprintf("%s/%d", bdb.name, size), func(b *testing.B) {
+				db := bdb.open(b)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					tx, err := db.Begin()
+					if err != nil {
+						b.Fatal(err)
+					}
+					for j := 0; j < size; j++ {
+						if _, err := tx.Exec("INSERT INTO bench(value) VALUES(?)", "hello"); err != nil {
+							b.Fatal(err)
+						}
+					}
+					if err := tx.Commit(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Insert benchRows rows in a single transaction.
+func populateBench(b *testing.B, db *sql.DB) {
+	b.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchRows; i++ {
+		if _, err := tx.Exec("INSERT INTO bench(value) VALUES(?)", fmt.Sprintf("value %d", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// Count the nodes started by benchmarks, to give each a unique address.
+var benchNodes = 0
+
+// Start a single dqlite node and return a database connected to it, with the
+// bench schema created.
+func openBenchDqlite(b *testing.B) *sql.DB {
+	b.Helper()
+
+	dir := benchDir(b)
+
+	benchNodes++
+	address := fmt.Sprintf("@dqlite-bench-%d-%d", os.Getpid(), benchNodes)
+
+	node, err := dqlite.New(1, address, dir, dqlite.WithBindAddress(address))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := node.Start(); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { node.Close() })
+
+	store := client.NewInmemNodeStore()
+	store.Set(context.Background(), []client.NodeInfo{{Address: address}})
+
+	drv, err := driver.New(store)
+	if err != nil {
+		b.Fatal(err)
+	}
+	connector, err := drv.OpenConnector("bench.db")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return setupBenchDB(b, sql.OpenDB(connector))
+}
+
+// Return a go-sqlite3 database backed by a local file in WAL mode, with the
+// bench schema created.
+func openBenchSQLite(b *testing.B) *sql.DB {
+	b.Helper()
+
+	path := filepath.Join(benchDir(b), "bench.db")
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return setupBenchDB(b, db)
+}
+
+func setupBenchDB(b *testing.B, db *sql.DB) *sql.DB {
+	b.Helper()
+
+	b.Cleanup(func() { db.Close() })
+
+	// Use a single connection, like the dqlite driver effectively does for
+	// writes.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(benchSchema); err != nil {
+		b.Fatal(err)
+	}
+
+	return db
+}
+
+func benchDir(b *testing.B) string {
+	b.Helper()
+
+	dir, err := ioutil.TempDir("", "dqlite-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Requests and responses larger than the maximum buffer size fail.
+func TestWithBufferSizes(t *testing.T) {
+	server, db := newFakeDB(t, WithBufferSizes(64, 1024))
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	server.SetQuery("SELECT small", fakeserver.Rows{
+		Columns: []string{"s"},
+		Values:  [][]driver.Value{{strings.Repeat("x", 500)}},
+	})
+	server.SetQuery("SELECT large", fakeserver.Rows{
+		Columns: []string{"s"},
+		Values:  [][]driver.Value{{strings.Repeat("x", 2000)}},
+	})
+
+	var s string
+	require.NoError(t, conn.QueryRowContext(ctx, "SELECT small").Scan(&s))
+	assert.Len(t, s, 500)
+
+	// A request too large fails without affecting the connection.
+	_, err = conn.ExecContext(ctx, "INSERT INTO t VALUES('"+strings.Repeat("x", 2000)+"')")
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+	require.NoError(t, conn.QueryRowContext(ctx, "SELECT small").Scan(&s))
+
+	// A response too large fails and breaks the connection.
+	err = conn.QueryRowContext(ctx, "SELECT large").Scan(&s)
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+	_, err = conn.ExecContext(ctx, "INSERT INTO t VALUES(1)")
+	assert.Equal(t, driver.ErrBadConn, err)
+}
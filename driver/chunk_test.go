@@ -0,0 +1,50 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Parameter values larger than the maximum buffer size can be sent in chunks.
+func TestWithChunkedParameters(t *testing.T) {
+	server, db := newFakeDB(t, WithBufferSizes(64, 1024), WithChunkedParameters(256))
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	blob := bytes.Repeat([]byte("x"), 2000)
+	text := strings.Repeat("y", 1500)
+
+	_, err = conn.ExecContext(ctx, "INSERT INTO t VALUES(?, ?, ?)", 1, blob, text)
+	require.NoError(t, err)
+
+	server.SetQuery("SELECT length(?)", fakeserver.Rows{
+		Columns: []string{"n"},
+		Values:  [][]driver.Value{{int64(1500)}},
+	})
+	var n int
+	require.NoError(t, conn.QueryRowContext(ctx, "SELECT length(?)", text).Scan(&n))
+
+	// The values were assembled by the server.
+	values := [][]interface{}{}
+	for _, request := range server.Requests() {
+		switch request.Type {
+		case protocol.RequestExecSQL, protocol.RequestQuerySQL:
+			values = append(values, request.Values)
+		}
+	}
+	assert.Equal(t, [][]interface{}{
+		{int64(1), blob, text},
+		{text},
+	}, values)
+}
@@ -0,0 +1,98 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type compatID string
+
+func (id compatID) Value() (driver.Value, error) {
+	return "id-" + string(id), nil
+}
+
+type compatLevel uint8
+
+// Arguments of types not natively supported by the wire protocol are
+// converted, like ORMs and query builders expect.
+func TestCheckNamedValue(t *testing.T) {
+	server, db := newFakeDB(t)
+	server.SetExec("INSERT INTO t VALUES(?, ?, ?, ?)", 1, 1)
+
+	_, err := db.Exec("INSERT INTO t VALUES(?, ?, ?, ?)", compatID("x"), compatLevel(3), uint32(7), float32(1.5))
+	require.NoError(t, err)
+
+	values := execValues(server, "INSERT INTO t VALUES(?, ?, ?, ?)")
+	require.Len(t, values, 1)
+	assert.Equal(t, []interface{}{"id-x", int64(3), int64(7), float64(1.5)}, values[0])
+
+	_, err = db.Exec("INSERT INTO t VALUES(?, ?, ?, ?)", uint64(math.MaxUint64), 0, 0, 0)
+	assert.Error(t, err)
+}
+
+// A pooled connection closed by the server while idle is transparently
+// replaced.
+func TestStaleConnRetried(t *testing.T) {
+	server, db := newFakeDB(t)
+	server.SetExec("INSERT INTO t VALUES(1)", 1, 1)
+
+	_, err := db.Exec("INSERT INTO t VALUES(1)")
+	require.NoError(t, err)
+
+	server.DropConnections()
+	time.Sleep(50 * time.Millisecond) // Let the client notice.
+
+	_, err = db.Exec("INSERT INTO t VALUES(1)")
+	require.NoError(t, err)
+
+	assert.Len(t, execValues(server, "INSERT INTO t VALUES(1)"), 2)
+}
+
+// A statement whose connection is lost after it was sent is not retried,
+// since it might have been executed.
+func TestUnknownOutcomeNotRetried(t *testing.T) {
+	server, db := newFakeDB(t)
+	server.SetExec("INSERT INTO t VALUES(1)", 1, 1)
+	server.Fail(fakeserver.Failure{
+		Type:  protocol.RequestExecSQL,
+		SQL:   "INSERT INTO t VALUES(1)",
+		Close: true,
+		Times: 1,
+	})
+
+	_, err := db.Exec("INSERT INTO t VALUES(1)")
+	require.Error(t, err)
+	assert.NotEqual(t, driver.ErrBadConn, err)
+	assert.Len(t, execValues(server, "INSERT INTO t VALUES(1)"), 1)
+
+	// The broken connection is discarded.
+	_, err = db.Exec("INSERT INTO t VALUES(1)")
+	require.NoError(t, err)
+}
+
+func TestBeginTxIsolation(t *testing.T) {
+	_, db := newFakeDB(t)
+
+	_, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	assert.EqualError(t, err, "unsupported isolation level: Read Committed")
+}
+
+// Return the parameters of all exec requests with the given SQL text.
+func execValues(server *fakeserver.Server, sql string) [][]interface{} {
+	values := [][]interface{}{}
+	for _, request := range server.Requests() {
+		if request.Type == protocol.RequestExecSQL && request.SQL == sql {
+			values = append(values, request.Values)
+		}
+	}
+	return values
+}
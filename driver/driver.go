@@ -16,10 +16,13 @@ package driver
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
 	"database/sql/driver"
 	"io"
 	"net"
 	"reflect"
+	"sync"
 	"syscall"
 	"time"
 
@@ -27,6 +30,9 @@ import (
 
 	"github.com/canonical/go-dqlite/client"
 	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/canonical/go-dqlite/internal/tracing"
+	"github.com/canonical/go-dqlite/metrics"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Driver perform queries against a dqlite server.
@@ -38,6 +44,21 @@ type Driver struct {
 	contextTimeout    time.Duration    // Default client context timeout.
 	clientConfig      protocol.Config  // Configuration for dqlite client instances
 	tracing           client.LogLevel  // Whether to trace statements
+	metrics           *metrics.Metrics // Collectors for query counts, if any
+	tracer            trace.Tracer     // Used to create spans, if set
+	slow              *slowQueryLog    // Used to log slow statements, if set
+	audit             *auditLog        // Used to audit statements, if set
+	stats             *queryStats      // Aggregated statement statistics, if enabled
+	labels            bool             // Whether to set profiler labels
+	sharedSize        int              // Physical connections shared per database, if not zero
+	sharedMu          sync.Mutex
+	shared            map[string]*sharedPool // Pools of shared connections by database
+	bufferSize        int                    // Initial size of message buffers
+	maxBufferSize     int                    // Maximum size of message buffers, if not zero
+	registrySize      int                    // Statements kept prepared per physical connection, if not zero
+	limits            protocol.ResultLimits  // Default limits of query results
+	chunkSize         int                    // Size of parameter chunks, if not zero
+	hedgeDelay        time.Duration          // Delay before hedging queries, if not zero
 }
 
 // Error is returned in case of database errors.
@@ -171,6 +192,115 @@ func WithTracing(level client.LogLevel) Option {
 	}
 }
 
+// WithMetrics sets the collectors used to record request latencies and query
+// counts.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(options *options) {
+		options.Metrics = m
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry provider used to create spans for
+// driver operations and for the underlying protocol requests.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(options *options) {
+		options.Tracer = tracing.Tracer(provider)
+	}
+}
+
+// WithAuthToken sets a token to present to nodes right after the protocol
+// handshake, as required by App nodes configured with app.WithAuthToken or
+// app.WithAuthenticator.
+func WithAuthToken(token string) Option {
+	return func(options *options) {
+		options.AuthToken = token
+	}
+}
+
+// WithReadOnly makes the driver ask nodes to reject any statement that might
+// modify data, so the resulting connections can't be used to mutate the
+// database even by mistake. It requires App nodes, whose proxy enforces it:
+// connecting to other nodes fails.
+func WithReadOnly() Option {
+	return func(options *options) {
+		options.ReadOnly = true
+	}
+}
+
+// WithBufferSizes sets the initial size of the buffers each connection uses
+// to encode requests and decode responses, which by default is 4096 bytes,
+// and the maximum size they can grow to, which by default is unlimited.
+//
+// Workloads with consistently large rows can avoid repeated buffer growth by
+// raising the initial size, while small-footprint deployments can cap the
+// memory used by each connection. Statements whose request or response
+// exceeds the maximum size fail with ErrMessageTooLarge, and a response too
+// large also closes the connection.
+func WithBufferSizes(initial, max int) Option {
+	return func(options *options) {
+		options.BufferSize = initial
+		options.MaxBufferSize = max
+	}
+}
+
+// WithLeaderProbes makes new connections probe up to n nodes of the store
+// concurrently when looking for the leader, cancelling the other probes as
+// soon as one of them connects to it, so that unreachable nodes don't delay
+// the discovery by their attempt timeout. By default, nodes are probed one at
+// a time.
+func WithLeaderProbes(n int) Option {
+	return func(options *options) {
+		options.LeaderProbes = n
+	}
+}
+
+// WithSlowQueryThreshold enables logging, with the configured log function,
+// of any statement whose execution takes at least the given duration. For
+// queries, the duration includes fetching all rows.
+//
+// If not used, or set to zero, slow queries are not logged.
+func WithSlowQueryThreshold(threshold time.Duration) Option {
+	return func(options *options) {
+		options.SlowQueryThreshold = threshold
+	}
+}
+
+// WithSlowQueryRedaction sets whether string, blob and numeric literals should
+// be replaced with a question mark in the SQL text of logged slow queries.
+func WithSlowQueryRedaction(enabled bool) Option {
+	return func(options *options) {
+		options.SlowQueryRedaction = enabled
+	}
+}
+
+// WithSlowQueryExplain sets whether the plan of logged slow queries should be
+// captured with EXPLAIN QUERY PLAN and logged as well, which helps spotting
+// missing indexes.
+//
+// The plan is captured in the background on a follower, so that the leader
+// isn't loaded further, falling back to the node that ran the statement if no
+// follower can run it. At most one plan is captured at a time, and slow
+// queries completing in the meantime are logged without their plan.
+func WithSlowQueryExplain(enabled bool) Option {
+	return func(options *options) {
+		options.SlowQueryExplain = enabled
+	}
+}
+
+// WithChunkedParameters makes the driver send the values of blob and text
+// parameters larger than the given size in chunks of that size, ahead of the
+// statement, so that values close to or above the maximum buffer size set
+// with WithBufferSizes can be inserted.
+//
+// Chunks are assembled by the proxy of App nodes, which splices them into the
+// statement before forwarding it to the node: using chunked parameters with
+// other nodes makes the statements with large values fail.
+func WithChunkedParameters(size int) Option {
+	return func(options *options) {
+		options.ChunkSize = size
+	}
+}
+
 // NewDriver creates a new dqlite driver, which also implements the
 // driver.Driver interface.
 func New(store client.NodeStore, options ...Option) (*Driver, error) {
@@ -180,6 +310,12 @@ func New(store client.NodeStore, options ...Option) (*Driver, error) {
 		option(o)
 	}
 
+	// Prepared statements of shared connections must be registered, or
+	// they would hold a physical connection until they're closed.
+	if o.SharedConnections > 0 && o.StatementRegistry == 0 {
+		o.StatementRegistry = sharedRegistrySize
+	}
+
 	driver := &Driver{
 		log:               o.Log,
 		store:             store,
@@ -187,15 +323,59 @@ func New(store client.NodeStore, options ...Option) (*Driver, error) {
 		connectionTimeout: o.ConnectionTimeout,
 		contextTimeout:    o.ContextTimeout,
 		tracing:           o.Tracing,
+		metrics:           o.Metrics,
+		tracer:            o.Tracer,
+		labels:            o.ProfilerLabels,
+		sharedSize:        o.SharedConnections,
+		shared:            map[string]*sharedPool{},
+		bufferSize:        protocol.BufferSize(o.BufferSize),
+		maxBufferSize:     o.MaxBufferSize,
+		registrySize:      o.StatementRegistry,
+		limits:            o.ResultLimits,
+		chunkSize:         o.ChunkSize,
+		hedgeDelay:        o.HedgingDelay,
 		clientConfig: protocol.Config{
 			Dial:           o.Dial,
 			AttemptTimeout: o.AttemptTimeout,
 			BackoffFactor:  o.ConnectionBackoffFactor,
 			BackoffCap:     o.ConnectionBackoffCap,
 			RetryLimit:     o.RetryLimit,
+			Tracer:         o.Tracer,
+			AuthToken:      o.AuthToken,
+			ReadOnly:       o.ReadOnly,
+			LeaderTracker:  protocol.NewLeaderTracker(),
+			Probes:         o.LeaderProbes,
 		},
 	}
 
+	if driver.maxBufferSize > 0 && driver.bufferSize > driver.maxBufferSize {
+		driver.bufferSize = protocol.BufferSize(driver.maxBufferSize)
+	}
+
+	if o.Metrics != nil {
+		driver.clientConfig.Observer = o.Metrics.ObserveRequest
+	}
+
+	if o.QueryStats {
+		driver.stats = newQueryStats()
+	}
+
+	if o.AuditSink != nil {
+		driver.audit = &auditLog{sink: o.AuditSink}
+	}
+
+	if o.SlowQueryThreshold > 0 {
+		driver.slow = &slowQueryLog{
+			log:       o.Log,
+			threshold: o.SlowQueryThreshold,
+			redact:    o.SlowQueryRedaction,
+		}
+		if o.SlowQueryExplain {
+			driver.slow.explain = driver.explain
+			driver.slow.explaining = make(chan struct{}, 1)
+		}
+	}
+
 	return driver, nil
 }
 
@@ -211,14 +391,33 @@ type options struct {
 	RetryLimit              uint
 	Context                 context.Context
 	Tracing                 client.LogLevel
+	Metrics                 *metrics.Metrics
+	Tracer                  trace.Tracer
+	SlowQueryThreshold      time.Duration
+	SlowQueryRedaction      bool
+	SlowQueryExplain        bool
+	AuditSink               AuditSink
+	QueryStats              bool
+	ProfilerLabels          bool
+	AuthToken               string
+	ReadOnly                bool
+	SharedConnections       int
+	BufferSize              int
+	MaxBufferSize           int
+	StatementRegistry       int
+	LeaderProbes            int
+	ResultLimits            protocol.ResultLimits
+	ChunkSize               int
+	HedgingDelay            time.Duration
 }
 
 // Create a options object with sane defaults.
 func defaultOptions() *options {
 	return &options{
-		Log:     client.DefaultLogFunc,
-		Dial:    client.DefaultDialFunc,
-		Tracing: client.LogNone,
+		Log:        client.DefaultLogFunc,
+		Dial:       client.DefaultDialFunc,
+		Tracing:    client.LogNone,
+		BufferSize: 4096,
 	}
 }
 
@@ -230,7 +429,7 @@ type Connector struct {
 }
 
 // Connect returns a connection to the database.
-func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+func (c *Connector) Connect(ctx context.Context) (_ driver.Conn, err error) {
 	if c.driver.context != nil {
 		ctx = c.driver.context
 	}
@@ -241,36 +440,56 @@ func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
 		defer cancel()
 	}
 
-	// TODO: generate a client ID.
-	connector := protocol.NewConnector(0, c.driver.store, c.driver.clientConfig, c.driver.log)
+	var span trace.Span
+	ctx, span = tracing.Start(ctx, c.driver.tracer, "dqlite.driver.connect", tracing.DBName.String(c.uri))
+	defer func() { tracing.End(span, err) }()
 
 	conn := &Conn{
 		log:            c.driver.log,
 		contextTimeout: c.driver.contextTimeout,
 		tracing:        c.driver.tracing,
+		metrics:        c.driver.metrics,
+		tracer:         c.driver.tracer,
+		slow:           c.driver.slow,
+		audit:          c.driver.audit,
+		stats:          c.driver.stats,
+		labels:         c.driver.labels,
+		database:       c.uri,
+		registry:       c.driver.registrySize > 0,
+		limits:         c.driver.limits,
+		chunkSize:      c.driver.chunkSize,
 	}
 
-	var err error
-	conn.protocol, err = connector.Connect(ctx)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create dqlite connection")
+	if c.driver.hedgeDelay > 0 {
+		conn.hedgeDelay = c.driver.hedgeDelay
+		conn.hedge = c.driver.hedgedQuery
 	}
 
-	conn.request.Init(4096)
-	conn.response.Init(4096)
+	conn.request.Init(c.driver.bufferSize)
+	conn.request.SetMaxBufferSize(c.driver.maxBufferSize)
+	conn.response.Init(c.driver.bufferSize)
+	conn.response.SetMaxBufferSize(c.driver.maxBufferSize)
+
+	if pool := c.driver.sharedPool(c.uri); pool != nil {
+		conn.pool = pool
 
-	protocol.EncodeOpen(&conn.request, c.uri, 0, "volatile")
+		// Make sure the database is reachable.
+		if err := conn.pin(ctx); err != nil {
+			return nil, err
+		}
+		conn.unpin(false)
 
-	if err := conn.protocol.Call(ctx, &conn.request, &conn.response); err != nil {
-		conn.protocol.Close()
-		return nil, errors.Wrap(err, "failed to open database")
+		return conn, nil
 	}
 
-	conn.id, err = protocol.DecodeDb(&conn.response)
+	physical, err := c.driver.open(ctx, c.uri)
 	if err != nil {
-		conn.protocol.Close()
-		return nil, errors.Wrap(err, "failed to open database")
+		return nil, err
 	}
+	conn.protocol = physical.protocol
+	conn.id = physical.id
+	conn.node = physical.node
+	conn.stmts = physical.stmts
 
 	return conn, nil
 }
@@ -319,6 +538,10 @@ func (d *Driver) SetContextTimeout(timeout time.Duration) {}
 // leader available in the cluster.
 var ErrNoAvailableLeader = protocol.ErrNoAvailableLeader
 
+// ErrMessageTooLarge is returned when a request or response doesn't fit in the
+// maximum buffer size set with WithBufferSizes.
+var ErrMessageTooLarge = protocol.ErrMessageTooLarge
+
 // Conn implements the sql.Conn interface.
 type Conn struct {
 	log            client.LogFunc
@@ -328,36 +551,93 @@ type Conn struct {
 	id             uint32 // Database ID.
 	contextTimeout time.Duration
 	tracing        client.LogLevel
+	metrics        *metrics.Metrics
+	tracer         trace.Tracer
+	slow           *slowQueryLog
+	audit          *auditLog
+	stats          *queryStats
+	labels         bool
+	node           string        // Address of the node we are connected to.
+	database       string        // Name of the open database.
+	pool           *sharedPool   // Pool of shared physical connections, if enabled.
+	lease          *physicalConn // Physical connection leased from the pool, if any.
+	pins           int           // Number of operations using the leased connection.
+	discard        bool          // Whether the leased connection must be discarded.
+	registry       bool          // Whether prepared statements are registered.
+	stmts          *stmtRegistry // Statements of the physical connection, if registered.
+	limits         protocol.ResultLimits
+	chunkSize      int              // Size of parameter chunks, if not zero.
+	chunks         []protocol.Chunk // Parameter chunks to send before the next statement.
+	tx             bool             // Whether a transaction is in progress.
+	hedgeDelay     time.Duration    // Delay before hedging queries.
+	hedge          func(context.Context, string, string, []driver.NamedValue) (*hedgedRows, error)
 }
 
 // PrepareContext returns a prepared statement, bound to this connection.
 // context is for the preparation of the statement, it must not store the
 // context within the statement itself.
-func (c *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+func (c *Conn) PrepareContext(ctx context.Context, query string) (_ driver.Stmt, err error) {
+	var span trace.Span
+	ctx, span = tracing.Start(ctx, c.tracer, "dqlite.driver.prepare", tracing.DBStatement.String(query))
+	defer func() { tracing.End(span, err) }()
+
+	// On success, the connection stays pinned until the statement is
+	// closed, unless the statement is registered, in which case it's
+	// looked up again on each execution. Statements of shared connections
+	// are always registered.
+	if err := c.pin(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil || c.registry {
+			c.unpin(err == driver.ErrBadConn)
+		}
+	}()
+
 	stmt := &Stmt{
+		conn:     c,
 		protocol: c.protocol,
 		request:  &c.request,
 		response: &c.response,
 		log:      c.log,
 		tracing:  c.tracing,
+		metrics:  c.metrics,
+		tracer:   c.tracer,
+		slow:     c.slow,
+		audit:    c.audit,
+		stats:    c.stats,
+		node:     c.node,
+		database: c.database,
+		names:    parameterIndexes(query),
 	}
 
-	protocol.EncodePrepare(&c.request, uint64(c.id), query)
+	if c.registry {
+		stmt.query = query
+		stmt.hash = stmtHash(query)
+		if err := stmt.resolve(ctx); err != nil {
+			return nil, driverError(c.log, err)
+		}
+	} else {
+		protocol.EncodePrepare(&c.request, uint64(c.id), query)
 
-	if err := c.protocol.Call(ctx, &c.request, &c.response); err != nil {
-		return nil, driverError(c.log, err)
-	}
+		if err := c.protocol.Call(ctx, &c.request, &c.response); err != nil {
+			return nil, driverError(c.log, err)
+		}
 
-	var err error
-	stmt.db, stmt.id, stmt.params, err = protocol.DecodeStmt(&c.response)
-	if err != nil {
-		return nil, driverError(c.log, err)
+		stmt.db, stmt.id, stmt.params, err = protocol.DecodeStmt(&c.response)
+		if err != nil {
+			return nil, driverError(c.log, err)
+		}
 	}
 
-	if c.tracing != client.LogNone {
+	if c.tracing != client.LogNone || c.tracer != nil || c.slow != nil || c.audit != nil || c.stats != nil {
 		stmt.sql = query
 	}
 
+	if c.labels {
+		stmt.digest = StatementDigest(query)
+	}
+
 	return stmt, nil
 }
 
@@ -367,10 +647,35 @@ func (c *Conn) Prepare(query string) (driver.Stmt, error) {
 }
 
 // ExecContext is an optional interface that may be implemented by a Conn.
-func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (_ driver.Result, err error) {
+	defer func() { c.metrics.ObserveQuery("exec", err) }()
+
+	var span trace.Span
+	ctx, span = tracing.Start(ctx, c.tracer, "dqlite.driver.exec", tracing.DBStatement.String(query))
+	defer func() { tracing.End(span, err) }()
+
+	if hasNames(args) {
+		if args, err = bindNamed(parameterIndexes(query), args); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.pin(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { c.unpin(err == driver.ErrBadConn) }()
+
+	tracked := trackQuery(c.slow, c.stats, query, len(args), c.node, c.database)
+	var affected int64
+	defer func() { tracked.done(affected, err) }()
+	defer func() { c.audit.record(ctx, c.node, c.database, query, err) }()
+
+	args = c.split(args)
+	defer func() { c.chunks = nil }()
+
 	protocol.EncodeExecSQL(&c.request, uint64(c.id), query, args)
 
-	if err := c.protocol.Call(ctx, &c.request, &c.response); err != nil {
+	if err := withLabels(ctx, c.database, c.digest(query), c.call); err != nil {
 		return nil, driverError(c.log, err)
 	}
 
@@ -378,6 +683,7 @@ func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.Name
 	if err != nil {
 		return nil, driverError(c.log, err)
 	}
+	affected = int64(result.RowsAffected)
 
 	if c.tracing != client.LogNone {
 		c.log(c.tracing, "exec: %s", query)
@@ -392,13 +698,62 @@ func (c *Conn) Query(query string, args []driver.Value) (driver.Rows, error) {
 }
 
 // QueryContext is an optional interface that may be implemented by a Conn.
-func (c *Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+func (c *Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (_ driver.Rows, err error) {
+	defer func() { c.metrics.ObserveQuery("query", err) }()
+
+	var span trace.Span
+	ctx, span = tracing.Start(ctx, c.tracer, "dqlite.driver.query", tracing.DBStatement.String(query))
+	defer func() { tracing.End(span, err) }()
+
+	if hasNames(args) {
+		if args, err = bindNamed(parameterIndexes(query), args); err != nil {
+			return nil, err
+		}
+	}
+
+	// On success, the connection stays pinned until the rows are closed.
+	if err := c.pin(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			c.unpin(err == driver.ErrBadConn)
+		}
+	}()
+
+	// On success, the query is tracked until the rows are closed.
+	tracked := trackQuery(c.slow, c.stats, query, len(args), c.node, c.database)
+	defer func() {
+		if err != nil {
+			tracked.done(0, err)
+		}
+	}()
+	defer func() { c.audit.record(ctx, c.node, c.database, query, err) }()
+
+	args = c.split(args)
+	defer func() { c.chunks = nil }()
+
 	protocol.EncodeQuerySQL(&c.request, uint64(c.id), query, args)
 
-	if err := c.protocol.Call(ctx, &c.request, &c.response); err != nil {
+	var hedged *hedgedRows
+	if c.hedging(ctx) {
+		hedged, err = c.hedgedCall(ctx, query, args)
+	} else {
+		err = withLabels(ctx, c.database, c.digest(query), c.call)
+	}
+	if err != nil {
 		return nil, driverError(c.log, err)
 	}
 
+	if hedged != nil {
+		if c.tracing != client.LogNone {
+			c.log(c.tracing, "query (hedged): %s", query)
+		}
+		hedged.conn = c
+		hedged.tracked = tracked
+		return hedged, nil
+	}
+
 	rows, err := protocol.DecodeRows(&c.response)
 	if err != nil {
 		return nil, driverError(c.log, err)
@@ -409,15 +764,46 @@ func (c *Conn) QueryContext(ctx context.Context, query string, args []driver.Nam
 	}
 
 	return &Rows{
+		conn:     c,
 		ctx:      ctx,
 		request:  &c.request,
 		response: &c.response,
 		protocol: c.protocol,
 		rows:     rows,
 		log:      c.log,
+		tracked:  tracked,
+		counter:  protocol.ResultCounter{Limits: protocol.ResultLimitsFromContext(ctx, c.limits)},
 	}, nil
 }
 
+// Return the digest of the given statement if profiler labels are enabled, or
+// an empty string.
+func (c *Conn) digest(query string) string {
+	if !c.labels {
+		return ""
+	}
+	return StatementDigest(query)
+}
+
+// Replace the parameter values larger than the chunk size, if set, with empty
+// values, holding their content in chunks to send ahead of the statement.
+func (c *Conn) split(args []driver.NamedValue) []driver.NamedValue {
+	if c.chunkSize <= 0 {
+		return args
+	}
+	args, c.chunks = protocol.SplitValues(args, c.chunkSize)
+	return args
+}
+
+// Send the encoded request, preceded by any pending parameter chunk, and wait
+// for the response.
+func (c *Conn) call(ctx context.Context) error {
+	if err := protocol.SendChunks(ctx, c.protocol, c.chunks); err != nil {
+		return err
+	}
+	return c.protocol.Call(ctx, &c.request, &c.response)
+}
+
 // Exec is an optional interface that may be implemented by a Conn.
 func (c *Conn) Exec(query string, args []driver.Value) (driver.Result, error) {
 	return c.ExecContext(context.Background(), query, valuesToNamedValues(args))
@@ -430,9 +816,64 @@ func (c *Conn) Exec(query string, args []driver.Value) (driver.Result, error) {
 // Close when there's a surplus of idle connections, it shouldn't be necessary
 // for drivers to do their own connection caching.
 func (c *Conn) Close() error {
+	if c.pool != nil {
+		// Statements and rows are closed before the connection, so
+		// the physical connection is normally not leased anymore.
+		if c.lease != nil {
+			c.pins = 1
+			c.unpin(true)
+		}
+		return nil
+	}
 	return c.protocol.Close()
 }
 
+// ResetSession is called by database/sql before reusing a connection from the
+// pool, and returns driver.ErrBadConn if the connection was lost, for example
+// because the node it's connected to was restarted while it was idle. In that
+// case database/sql discards it and opens a new one.
+func (c *Conn) ResetSession(ctx context.Context) error {
+	// Shared physical connections are checked when leased.
+	if c.pool != nil {
+		return nil
+	}
+	if !c.protocol.Alive() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// IsValid is called by database/sql before putting a connection back into the
+// pool, and returns false if a network error made the connection unusable.
+func (c *Conn) IsValid() bool {
+	if c.protocol == nil {
+		return true // Not holding a shared physical connection.
+	}
+	return !c.protocol.Broken()
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, converting arguments to
+// the types supported by the wire protocol.
+//
+// Arguments passed with sql.Named are accepted, and bound to the parameter of
+// the statement with the same name, such as ":id", "@id" or "$id".
+//
+// Besides the default driver.Value types, it accepts driver.Valuer
+// implementations and all the integer and float types (uint64 values larger
+// than math.MaxInt64 are rejected), as well as named types based on them.
+func (c *Conn) CheckNamedValue(nv *driver.NamedValue) error {
+	switch nv.Value.(type) {
+	case nil, int64, float64, bool, []byte, string, time.Time:
+		return nil
+	}
+	value, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	nv.Value = value
+	return nil
+}
+
 // BeginTx starts and returns a new transaction.  If the context is canceled by
 // the user the sql package will call Tx.Rollback before discarding and closing
 // the connection.
@@ -446,9 +887,23 @@ func (c *Conn) Close() error {
 // true to either set the read-only transaction property if supported or return
 // an error if it is not supported.
 func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	// Transactions are always serializable.
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelSerializable:
+	default:
+		return nil, errors.Errorf("unsupported isolation level: %s", sql.IsolationLevel(opts.Isolation))
+	}
+
+	// The connection stays pinned until the transaction ends.
+	if err := c.pin(ctx); err != nil {
+		return nil, err
+	}
+
 	if _, err := c.ExecContext(ctx, "BEGIN", nil); err != nil {
+		c.unpin(true)
 		return nil, err
 	}
+	c.tx = true
 
 	tx := &Tx{
 		conn: c,
@@ -483,7 +938,14 @@ type Tx struct {
 func (tx *Tx) Commit() error {
 	ctx := context.Background()
 
-	if _, err := tx.conn.ExecContext(ctx, "COMMIT", nil); err != nil {
+	_, err := tx.conn.ExecContext(ctx, "COMMIT", nil)
+	tx.conn.tx = false
+
+	// A failed commit might leave the transaction open, so a shared
+	// physical connection can't be reused.
+	tx.conn.unpin(err != nil)
+
+	if err != nil {
 		return driverError(tx.log, err)
 	}
 
@@ -494,7 +956,11 @@ func (tx *Tx) Commit() error {
 func (tx *Tx) Rollback() error {
 	ctx := context.Background()
 
-	if _, err := tx.conn.ExecContext(ctx, "ROLLBACK", nil); err != nil {
+	_, err := tx.conn.ExecContext(ctx, "ROLLBACK", nil)
+	tx.conn.tx = false
+	tx.conn.unpin(err != nil)
+
+	if err != nil {
 		return driverError(tx.log, err)
 	}
 
@@ -504,6 +970,7 @@ func (tx *Tx) Rollback() error {
 // Stmt is a prepared statement. It is bound to a Conn and not
 // used by multiple goroutines concurrently.
 type Stmt struct {
+	conn     *Conn
 	protocol *protocol.Protocol
 	request  *protocol.Message
 	response *protocol.Message
@@ -511,12 +978,30 @@ type Stmt struct {
 	id       uint32
 	params   uint64
 	log      client.LogFunc
-	sql      string // Prepared SQL, only set when tracing
+	sql      string // Prepared SQL, only set when tracing or tracking
 	tracing  client.LogLevel
+	metrics  *metrics.Metrics
+	tracer   trace.Tracer
+	slow     *slowQueryLog
+	audit    *auditLog
+	stats    *queryStats
+	digest   string // Statement digest, only set when using profiler labels
+	node     string
+	database string
+	query    string            // SQL text, only set when the statement is registered
+	hash     [sha256.Size]byte // Hash of the SQL text, only set when registered
+	names    map[string]int    // Indexes of the named parameters, if any
 }
 
 // Close closes the statement.
-func (s *Stmt) Close() error {
+func (s *Stmt) Close() (err error) {
+	// Registered statements stay prepared, to be reused.
+	if s.query != "" {
+		return nil
+	}
+
+	defer func() { s.conn.unpin(err != nil) }()
+
 	protocol.EncodeFinalize(s.request, s.db, s.id)
 
 	ctx := context.Background()
@@ -532,6 +1017,15 @@ func (s *Stmt) Close() error {
 	return nil
 }
 
+// Send the encoded request, preceded by any pending parameter chunk, and wait
+// for the response.
+func (s *Stmt) call(ctx context.Context) error {
+	if err := protocol.SendChunks(ctx, s.protocol, s.conn.chunks); err != nil {
+		return err
+	}
+	return s.protocol.Call(ctx, s.request, s.response)
+}
+
 // NumInput returns the number of placeholder parameters.
 func (s *Stmt) NumInput() int {
 	return int(s.params)
@@ -541,17 +1035,45 @@ func (s *Stmt) NumInput() int {
 // as an INSERT or UPDATE.
 //
 // ExecContext must honor the context timeout and return when it is canceled.
-func (s *Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
-	protocol.EncodeExec(s.request, s.db, s.id, args)
+func (s *Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (_ driver.Result, err error) {
+	defer func() { s.metrics.ObserveQuery("exec", err) }()
 
-	if err := s.protocol.Call(ctx, s.request, s.response); err != nil {
+	var span trace.Span
+	ctx, span = tracing.Start(ctx, s.tracer, "dqlite.driver.exec", tracing.DBStatement.String(s.sql))
+	defer func() { tracing.End(span, err) }()
+
+	if args, err = bindNamed(s.names, args); err != nil {
+		return nil, err
+	}
+
+	if err := s.conn.pin(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { s.conn.unpin(err == driver.ErrBadConn) }()
+
+	if err := s.resolve(ctx); err != nil {
 		return nil, driverError(s.log, err)
 	}
 
-	result, err := protocol.DecodeResult(s.response)
-	if err != nil {
+	tracked := trackQuery(s.slow, s.stats, s.sql, len(args), s.node, s.database)
+	var affected int64
+	defer func() { tracked.done(affected, err) }()
+	defer func() { s.audit.record(ctx, s.node, s.database, s.sql, err) }()
+
+	args = s.conn.split(args)
+	defer func() { s.conn.chunks = nil }()
+
+	var result protocol.Result
+	encode := func() { protocol.EncodeExec(s.request, s.db, s.id, args) }
+	decode := func() (err error) {
+		result, err = protocol.DecodeResult(s.response)
+		return err
+	}
+
+	if err := s.run(ctx, encode, decode); err != nil {
 		return nil, driverError(s.log, err)
 	}
+	affected = int64(result.RowsAffected)
 
 	if s.tracing != client.LogNone {
 		s.log(s.tracing, "exec prepared: %s", s.sql)
@@ -569,15 +1091,51 @@ func (s *Stmt) Exec(args []driver.Value) (driver.Result, error) {
 // SELECT.
 //
 // QueryContext must honor the context timeout and return when it is canceled.
-func (s *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
-	protocol.EncodeQuery(s.request, s.db, s.id, args)
+func (s *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (_ driver.Rows, err error) {
+	defer func() { s.metrics.ObserveQuery("query", err) }()
 
-	if err := s.protocol.Call(ctx, s.request, s.response); err != nil {
+	var span trace.Span
+	ctx, span = tracing.Start(ctx, s.tracer, "dqlite.driver.query", tracing.DBStatement.String(s.sql))
+	defer func() { tracing.End(span, err) }()
+
+	if args, err = bindNamed(s.names, args); err != nil {
+		return nil, err
+	}
+
+	// On success, the connection stays pinned until the rows are closed.
+	if err := s.conn.pin(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			s.conn.unpin(err == driver.ErrBadConn)
+		}
+	}()
+
+	if err := s.resolve(ctx); err != nil {
 		return nil, driverError(s.log, err)
 	}
 
-	rows, err := protocol.DecodeRows(s.response)
-	if err != nil {
+	// On success, the query is tracked until the rows are closed.
+	tracked := trackQuery(s.slow, s.stats, s.sql, len(args), s.node, s.database)
+	defer func() {
+		if err != nil {
+			tracked.done(0, err)
+		}
+	}()
+	defer func() { s.audit.record(ctx, s.node, s.database, s.sql, err) }()
+
+	args = s.conn.split(args)
+	defer func() { s.conn.chunks = nil }()
+
+	var rows protocol.Rows
+	encode := func() { protocol.EncodeQuery(s.request, s.db, s.id, args) }
+	decode := func() (err error) {
+		rows, err = protocol.DecodeRows(s.response)
+		return err
+	}
+
+	if err := s.run(ctx, encode, decode); err != nil {
 		return nil, driverError(s.log, err)
 	}
 
@@ -585,7 +1143,9 @@ func (s *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driv
 		s.log(s.tracing, "query prepared: %s", s.sql)
 	}
 
-	return &Rows{ctx: ctx, request: s.request, response: s.response, protocol: s.protocol, rows: rows}, nil
+	counter := protocol.ResultCounter{Limits: protocol.ResultLimitsFromContext(ctx, s.conn.limits)}
+
+	return &Rows{conn: s.conn, ctx: ctx, request: s.request, response: s.response, protocol: s.protocol, rows: rows, tracked: tracked, counter: counter}, nil
 }
 
 // Query executes a query that may return rows, such as a
@@ -613,6 +1173,7 @@ func (r *Result) RowsAffected() (int64, error) {
 
 // Rows is an iterator over an executed query's results.
 type Rows struct {
+	conn     *Conn
 	ctx      context.Context
 	protocol *protocol.Protocol
 	request  *protocol.Message
@@ -621,6 +1182,9 @@ type Rows struct {
 	consumed bool
 	types    []string
 	log      client.LogFunc
+	tracked  *trackedQuery // Completed when the rows are closed, if set.
+	count    int64         // Number of rows returned so far.
+	counter  protocol.ResultCounter
 }
 
 // Columns returns the names of the columns. The number of
@@ -633,6 +1197,18 @@ func (r *Rows) Columns() []string {
 
 // Close closes the rows iterator.
 func (r *Rows) Close() error {
+	err := r.close()
+	if r.conn != nil {
+		r.conn.unpin(err != nil)
+		r.conn = nil
+	}
+	return err
+}
+
+func (r *Rows) close() error {
+	r.tracked.done(r.count, nil)
+	r.tracked = nil
+
 	err := r.rows.Close()
 
 	// If we consumed the whole result set, there's nothing to do as
@@ -661,6 +1237,15 @@ func (r *Rows) Close() error {
 //
 // Next should return io.EOF when there are no more rows.
 func (r *Rows) Next(dest []driver.Value) error {
+	err := r.next(dest)
+	if err == nil {
+		r.count++
+		err = r.counter.Add(dest)
+	}
+	return err
+}
+
+func (r *Rows) next(dest []driver.Value) error {
 	err := r.rows.Next(dest)
 
 	if err == protocol.ErrRowsPart {
@@ -672,6 +1257,7 @@ func (r *Rows) Next(dest []driver.Value) error {
 		if err != nil {
 			return driverError(r.log, err)
 		}
+		rows.Reuse(&r.rows)
 		r.rows = rows
 		return r.rows.Next(dest)
 	}
@@ -732,7 +1318,20 @@ type unwrappable interface {
 }
 
 func driverError(log client.LogFunc, err error) error {
+	// The request was not sent, because the connection was already broken,
+	// so it's safe for database/sql to retry it on another connection.
+	if errors.Cause(err) == protocol.ErrBrokenConn {
+		log(client.LogDebug, "network connection already lost")
+		return driver.ErrBadConn
+	}
 	switch err := errors.Cause(err).(type) {
+	case protocol.ErrUnknownOutcome:
+		// The request was sent but the connection was lost before
+		// getting a response, so the server might have processed
+		// it. Returning ErrBadConn would make database/sql retry it,
+		// possibly executing it twice.
+		log(client.LogDebug, "network connection lost after sending request: %v", err)
+		return err
 	case syscall.Errno:
 		log(client.LogDebug, "network connection lost: %v", err)
 		return driver.ErrBadConn
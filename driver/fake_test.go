@@ -0,0 +1,34 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/stretchr/testify/require"
+)
+
+// Return a database handle backed by a fake server, opened by a driver
+// created with the given options. Both are closed when the test ends.
+func newFakeDB(t *testing.T, options ...Option) (*fakeserver.Server, *sql.DB) {
+	t.Helper()
+
+	server, err := fakeserver.New(1)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	store := client.NewInmemNodeStore()
+	store.Set(context.Background(), []client.NodeInfo{{Address: server.Address()}})
+
+	drv, err := New(store, options...)
+	require.NoError(t, err)
+	connector, err := drv.OpenConnector("test")
+	require.NoError(t, err)
+
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+
+	return server, db
+}
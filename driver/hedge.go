@@ -0,0 +1,216 @@
+package driver
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+)
+
+// WithHedgedReads makes the queries started with a context returned by
+// ContextWithHedging hedged: if no response arrives within the given delay,
+// the query is sent again over a new connection to the leader, found through
+// the node store like any new connection, and the first successful response
+// is used, the other request being canceled.
+//
+// This tames tail latency when a connection or the path to a node is
+// intermittently slow, at the cost of running some queries twice, so it must
+// only be used for read-only queries. Queries within transactions, with
+// chunked parameters or using prepared statements are never hedged. The rows
+// of a hedged query that got its response over the new connection are read
+// in full before being returned, and the original connection is discarded.
+func WithHedgedReads(delay time.Duration) Option {
+	return func(options *options) {
+		options.HedgingDelay = delay
+	}
+}
+
+type hedgingKey struct{}
+
+// ContextWithHedging returns a copy of the given context that makes the
+// queries using it hedged, if enabled with WithHedgedReads.
+func ContextWithHedging(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hedgingKey{}, true)
+}
+
+// Return true if the given query should be hedged.
+func (c *Conn) hedging(ctx context.Context) bool {
+	if c.hedge == nil || c.tx || len(c.chunks) > 0 {
+		return false
+	}
+	hedged, _ := ctx.Value(hedgingKey{}).(bool)
+	return hedged
+}
+
+// Send the encoded query and wait for the response, sending the query again
+// with the driver's hedge function if it doesn't arrive within the hedging
+// delay. If the hedged request wins, its result set is returned, the other
+// request is canceled and the connection is left broken. Otherwise, nil is
+// returned and the response is available as usual.
+func (c *Conn) hedgedCall(ctx context.Context, query string, args []driver.NamedValue) (*hedgedRows, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	primary := make(chan error, 1)
+	go func() {
+		primary <- withLabels(ctx, c.database, c.digest(query), c.call)
+	}()
+
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+
+	select {
+	case err := <-primary:
+		return nil, err
+	case <-timer.C:
+	}
+
+	// The hedged request is canceled as soon as we return, if still
+	// pending.
+	type result struct {
+		rows *hedgedRows
+		err  error
+	}
+	hedged := make(chan result, 1)
+	go func() {
+		rows, err := c.hedge(ctx, c.database, query, args)
+		hedged <- result{rows: rows, err: err}
+	}()
+
+	select {
+	case err := <-primary:
+		if err == nil {
+			return nil, nil
+		}
+		if result := <-hedged; result.err == nil {
+			return result.rows, nil
+		}
+		return nil, err
+	case result := <-hedged:
+		if result.err != nil {
+			return nil, <-primary
+		}
+		// Wait for the primary request to stop using the connection.
+		cancel()
+		<-primary
+		return result.rows, nil
+	}
+}
+
+// Run the given query over a new connection, reading its whole result set.
+func (d *Driver) hedgedQuery(ctx context.Context, database string, query string, args []driver.NamedValue) (*hedgedRows, error) {
+	conn, err := d.open(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.protocol.Close()
+
+	request := protocol.Message{}
+	request.Init(d.bufferSize)
+	request.SetMaxBufferSize(d.maxBufferSize)
+	response := protocol.Message{}
+	response.Init(d.bufferSize)
+	response.SetMaxBufferSize(d.maxBufferSize)
+
+	protocol.EncodeQuerySQL(&request, uint64(conn.id), query, args)
+
+	if err := conn.protocol.Call(ctx, &request, &response); err != nil {
+		return nil, err
+	}
+
+	rows, err := protocol.DecodeRows(&response)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &hedgedRows{columns: rows.Columns}
+	result.types, _ = rows.ColumnTypes()
+
+	counter := protocol.ResultCounter{Limits: protocol.ResultLimitsFromContext(ctx, d.limits)}
+	for {
+		row := make([]driver.Value, len(rows.Columns))
+		err := rows.Next(row)
+		if err == protocol.ErrRowsPart {
+			rows.Close()
+			if err := conn.protocol.More(ctx, &response); err != nil {
+				return nil, err
+			}
+			next, err := protocol.DecodeRows(&response)
+			if err != nil {
+				return nil, err
+			}
+			next.Reuse(&rows)
+			rows = next
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Blobs point into the response buffer.
+		for i, value := range row {
+			if blob, ok := value.([]byte); ok {
+				row[i] = append([]byte(nil), blob...)
+			}
+		}
+
+		if err := counter.Add(row); err != nil {
+			return nil, err
+		}
+		result.rows = append(result.rows, row)
+	}
+
+	return result, nil
+}
+
+// hedgedRows holds the result set of a hedged query that got its response
+// over a new connection.
+type hedgedRows struct {
+	conn    *Conn
+	columns []string
+	types   []string
+	rows    [][]driver.Value
+	tracked *trackedQuery // Completed when the rows are closed, if set.
+	count   int64         // Number of rows returned so far.
+}
+
+// Columns returns the names of the columns.
+func (r *hedgedRows) Columns() []string {
+	return r.columns
+}
+
+// Close closes the rows iterator, discarding the connection, which was left
+// broken by canceling its request.
+func (r *hedgedRows) Close() error {
+	r.tracked.done(r.count, nil)
+	r.tracked = nil
+	if r.conn != nil {
+		r.conn.unpin(true)
+		r.conn = nil
+	}
+	return nil
+}
+
+// Next populates the next row of data into the provided slice.
+func (r *hedgedRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	r.count++
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName implements RowsColumnTypeDatabaseTypeName.
+func (r *hedgedRows) ColumnTypeDatabaseTypeName(i int) string {
+	if i >= len(r.types) {
+		return ""
+	}
+	return r.types[i]
+}
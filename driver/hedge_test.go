@@ -0,0 +1,62 @@
+package driver
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A hedged query whose response is slow gets its rows over a new connection.
+func TestHedgedReads(t *testing.T) {
+	server, db := newFakeDB(t, WithHedgedReads(50*time.Millisecond))
+	values := [][]driver.Value{{int64(1)}, {int64(2)}}
+	server.SetQuery("SELECT n FROM t", fakeserver.Rows{Columns: []string{"n"}, Values: values})
+	server.Fail(fakeserver.Failure{
+		Type:  protocol.RequestQuerySQL,
+		SQL:   "SELECT n FROM t",
+		Delay: 2 * time.Second,
+		Times: 1,
+	})
+
+	ctx, cancel := context.WithTimeout(ContextWithHedging(context.Background()), time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT n FROM t")
+	require.NoError(t, err)
+	ns := []int64{}
+	for rows.Next() {
+		var n int64
+		require.NoError(t, rows.Scan(&n))
+		ns = append(ns, n)
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+
+	assert.Equal(t, []int64{1, 2}, ns)
+	assert.Equal(t, 2, countRequests(server, protocol.RequestQuerySQL))
+}
+
+// Queries are not hedged unless their context asks for it.
+func TestHedgedReads_NotRequested(t *testing.T) {
+	server, db := newFakeDB(t, WithHedgedReads(time.Millisecond))
+	values := [][]driver.Value{{int64(1)}}
+	server.SetQuery("SELECT n FROM t", fakeserver.Rows{Columns: []string{"n"}, Values: values})
+	server.Fail(fakeserver.Failure{
+		Type:  protocol.RequestQuerySQL,
+		SQL:   "SELECT n FROM t",
+		Delay: 100 * time.Millisecond,
+		Times: 1,
+	})
+
+	var n int64
+	require.NoError(t, db.QueryRowContext(context.Background(), "SELECT n FROM t").Scan(&n))
+
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, 1, countRequests(server, protocol.RequestQuerySQL))
+}
@@ -0,0 +1,51 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"runtime/pprof"
+)
+
+// Names of the profiler labels attached while executing statements.
+const (
+	labelDatabase  = "dqlite_database"
+	labelStatement = "dqlite_statement"
+)
+
+// WithProfilerLabels sets whether the goroutines executing statements should
+// carry pprof labels, so CPU, goroutine and block profiles of the application
+// show which database and statement are responsible.
+//
+// The "dqlite_database" label holds the database name and the
+// "dqlite_statement" label holds the digest of the statement, as returned by
+// StatementDigest.
+func WithProfilerLabels(enabled bool) Option {
+	return func(options *options) {
+		options.ProfilerLabels = enabled
+	}
+}
+
+// StatementDigest returns a short identifier of the given SQL statement,
+// which is the same for statements differing only in literal values and
+// whitespace.
+func StatementDigest(sql string) string {
+	h := fnv.New64a()
+	h.Write([]byte(normalizeSQL(sql)))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// Run the given function with profiler labels identifying the given database
+// and statement digest, or without labels if the digest is empty.
+func withLabels(ctx context.Context, database string, digest string, f func(context.Context) error) error {
+	if digest == "" {
+		return f(ctx)
+	}
+
+	var err error
+	pprof.Do(ctx, pprof.Labels(labelDatabase, database, labelStatement, digest), func(ctx context.Context) {
+		err = f(ctx)
+	})
+
+	return err
+}
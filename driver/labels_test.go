@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatementDigest(t *testing.T) {
+	digest := StatementDigest("SELECT * FROM t WHERE n = 1")
+	assert.Len(t, digest, 16)
+	assert.Equal(t, digest, StatementDigest("SELECT *  FROM t\nWHERE n = 2"))
+	assert.NotEqual(t, digest, StatementDigest("SELECT * FROM u WHERE n = 1"))
+}
+
+func TestWithLabels(t *testing.T) {
+	digest := StatementDigest("SELECT 1")
+	err := withLabels(context.Background(), "test", digest, func(ctx context.Context) error {
+		database, ok := pprof.Label(ctx, labelDatabase)
+		require.True(t, ok)
+		assert.Equal(t, "test", database)
+		statement, ok := pprof.Label(ctx, labelStatement)
+		require.True(t, ok)
+		assert.Equal(t, digest, statement)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = withLabels(context.Background(), "test", "", func(ctx context.Context) error {
+		_, ok := pprof.Label(ctx, labelDatabase)
+		assert.False(t, ok)
+		return nil
+	})
+	require.NoError(t, err)
+}
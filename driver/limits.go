@@ -0,0 +1,31 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+)
+
+// ResultLimitError is returned by the rows of a query whose result exceeds
+// the limits set with WithResultLimits or ContextWithResultLimits.
+type ResultLimitError = protocol.ResultLimitError
+
+// WithResultLimits sets the maximum number of rows and of bytes of the result
+// of a query. Zero means no limit, which is the default.
+//
+// Bytes are counted as the length of text and blob values, plus 8 for numbers
+// and timestamps. When a limit is exceeded, fetching the next row fails with a
+// ResultLimitError and the query is interrupted on the node, so that a
+// runaway query can't exhaust the memory of the application.
+func WithResultLimits(rows, bytes int64) Option {
+	return func(options *options) {
+		options.ResultLimits = protocol.ResultLimits{Rows: rows, Bytes: bytes}
+	}
+}
+
+// ContextWithResultLimits returns a copy of the given context that overrides
+// the limits set with WithResultLimits for the queries using it. Zero means no
+// limit.
+func ContextWithResultLimits(ctx context.Context, rows, bytes int64) context.Context {
+	return protocol.WithResultLimits(ctx, protocol.ResultLimits{Rows: rows, Bytes: bytes})
+}
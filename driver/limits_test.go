@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Iterating over the rows of a query fails once the result exceeds the
+// configured limits, which a context can override.
+func TestResultLimits(t *testing.T) {
+	server, db := newFakeDB(t, WithResultLimits(0, 10))
+	values := [][]driver.Value{{"hello"}, {"world"}, {"again"}}
+	server.SetQuery("SELECT s FROM t", fakeserver.Rows{Columns: []string{"s"}, Values: values})
+
+	ctx := context.Background()
+
+	rows, err := db.QueryContext(ctx, "SELECT s FROM t")
+	require.NoError(t, err)
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	assert.Equal(t, 2, n)
+	assert.Equal(t, ResultLimitError{Bytes: 10}, rows.Err())
+	require.NoError(t, rows.Close())
+
+	rows, err = db.QueryContext(ContextWithResultLimits(ctx, 0, 0), "SELECT s FROM t")
+	require.NoError(t, err)
+	n = 0
+	for rows.Next() {
+		n++
+	}
+	assert.Equal(t, 3, n)
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+}
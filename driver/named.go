@@ -0,0 +1,146 @@
+package driver
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+)
+
+// Return the index of each named parameter of the given SQL text, keyed by
+// its name including the prefix character, numbering parameters the way
+// SQLite does: each "?" takes the next index, "?NNN" takes index NNN, and a
+// named parameter takes the next index the first time it appears, and the
+// same index afterwards. It returns nil if there's no named parameter.
+func parameterIndexes(sql string) map[string]int {
+	var indexes map[string]int
+	n := 0
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			// Skip to the closing quote. Escaped quotes are just two
+			// quoted strings in a row, which makes no difference.
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			for i++; i < len(sql) && sql[i] != end; i++ {
+			}
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i++; i < len(sql) && sql[i] != '\n'; i++ {
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			for i += 2; i < len(sql) && !(sql[i] == '*' && i+1 < len(sql) && sql[i+1] == '/'); i++ {
+			}
+			i++
+		case c == '?':
+			j := i + 1
+			for j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+				j++
+			}
+			if j == i+1 {
+				n++
+				break
+			}
+			index, err := strconv.Atoi(sql[i+1 : j])
+			if err == nil && index > n {
+				n = index
+			}
+			i = j - 1
+		case (c == ':' || c == '@' || c == '$') && (i == 0 || !isIdentifier(sql[i-1])):
+			j := i + 1
+			for j < len(sql) && (isIdentifier(sql[j]) || sql[j] >= 0x80) {
+				j++
+			}
+			if j == i+1 {
+				break
+			}
+			name := sql[i:j]
+			if indexes == nil {
+				indexes = map[string]int{}
+			}
+			if _, ok := indexes[name]; !ok {
+				n++
+				indexes[name] = n
+			}
+			i = j - 1
+		case isIdentifier(c):
+			// Skip the rest of the word, so that "$" within
+			// identifiers isn't taken as a parameter prefix.
+			for i+1 < len(sql) && (isIdentifier(sql[i+1]) || sql[i+1] >= 0x80) {
+				i++
+			}
+		}
+	}
+
+	return indexes
+}
+
+// Return true if any of the given arguments was passed with sql.Named.
+func hasNames(args []driver.NamedValue) bool {
+	for _, arg := range args {
+		if arg.Name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the given arguments sorted by the index of the parameter they are
+// bound to, since the wire protocol only supports positional parameters.
+//
+// Arguments passed with sql.Named are bound to the parameter of the same
+// name with any of the ":", "@" or "$" prefixes, using the given indexes as
+// returned by parameterIndexes. Other arguments keep their position. It's an
+// error to bind two arguments to the same parameter. If no argument has a
+// name, the arguments are returned as they are.
+func bindNamed(indexes map[string]int, args []driver.NamedValue) ([]driver.NamedValue, error) {
+	if !hasNames(args) {
+		return args, nil
+	}
+
+	n := 0
+	for _, arg := range args {
+		if arg.Name == "" && arg.Ordinal > n {
+			n = arg.Ordinal
+		}
+	}
+
+	positions := make([]int, len(args))
+	for i, arg := range args {
+		positions[i] = arg.Ordinal
+		if arg.Name == "" {
+			continue
+		}
+		index := 0
+		for _, prefix := range []string{":", "@", "$"} {
+			if index = indexes[prefix+arg.Name]; index > 0 {
+				break
+			}
+		}
+		if index == 0 {
+			return nil, fmt.Errorf("no parameter named %q", arg.Name)
+		}
+		positions[i] = index
+		if index > n {
+			n = index
+		}
+	}
+
+	// Parameters without an argument are bound to NULL, as SQLite does.
+	bound := make([]driver.NamedValue, n)
+	for i := range bound {
+		bound[i].Ordinal = i + 1
+	}
+	assigned := make([]bool, n)
+	for i, arg := range args {
+		if assigned[positions[i]-1] {
+			return nil, fmt.Errorf("parameter %d bound twice", positions[i])
+		}
+		assigned[positions[i]-1] = true
+		bound[positions[i]-1].Value = arg.Value
+	}
+
+	return bound, nil
+}
@@ -0,0 +1,78 @@
+package driver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParameterIndexes(t *testing.T) {
+	cases := []struct {
+		sql     string
+		indexes map[string]int
+	}{
+		{"SELECT ?", nil},
+		{"SELECT :a, @b, $c", map[string]int{":a": 1, "@b": 2, "$c": 3}},
+		{"SELECT ?, :a, ?, :a", map[string]int{":a": 2}},
+		{"SELECT ?5, :a", map[string]int{":a": 6}},
+		{"SELECT ':a', \":b\", [:c], `:d` -- :e\n, /* :f */ :g", map[string]int{":g": 1}},
+		{"SELECT a$b, :x", map[string]int{":x": 1}},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			assert.Equal(t, c.indexes, parameterIndexes(c.sql))
+		})
+	}
+}
+
+func TestBindNamed(t *testing.T) {
+	indexes := parameterIndexes("SELECT ?, :b, @a")
+	args := []driver.NamedValue{
+		{Ordinal: 1, Value: "x"},
+		{Name: "a", Ordinal: 2, Value: int64(1)},
+		{Name: "b", Ordinal: 3, Value: int64(2)},
+	}
+
+	bound, err := bindNamed(indexes, args)
+	require.NoError(t, err)
+	assert.Equal(t, []driver.NamedValue{
+		{Ordinal: 1, Value: "x"},
+		{Ordinal: 2, Value: int64(2)},
+		{Ordinal: 3, Value: int64(1)},
+	}, bound)
+
+	_, err = bindNamed(indexes, []driver.NamedValue{{Name: "c", Ordinal: 1}})
+	assert.EqualError(t, err, `no parameter named "c"`)
+
+	// A positional argument can't take the place of a named one.
+	indexes = parameterIndexes("SELECT :a, ?")
+	_, err = bindNamed(indexes, []driver.NamedValue{
+		{Ordinal: 1, Value: int64(5)},
+		{Name: "a", Ordinal: 2, Value: int64(6)},
+	})
+	assert.EqualError(t, err, "parameter 1 bound twice")
+}
+
+// Named arguments are sent in the order of the parameters they are bound to.
+func TestNamedParameters(t *testing.T) {
+	server, db := newFakeDB(t)
+
+	query := "INSERT INTO test(a, b) VALUES(:a, :b)"
+	_, err := db.Exec(query, sql.Named("b", int64(2)), sql.Named("a", int64(1)))
+	require.NoError(t, err)
+
+	values := [][]interface{}{}
+	for _, request := range server.Requests() {
+		if request.Type == protocol.RequestExecSQL {
+			values = append(values, request.Values)
+		}
+	}
+	assert.Equal(t, [][]interface{}{{int64(1), int64(2)}}, values)
+
+	_, err = db.Exec(query, sql.Named("c", int64(1)))
+	assert.EqualError(t, err, `no parameter named "c"`)
+}
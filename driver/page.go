@@ -0,0 +1,45 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+)
+
+// ErrBadPageToken is returned when resuming a query from a page token that
+// is malformed or was issued for a different query.
+var ErrBadPageToken = protocol.ErrBadPageToken
+
+// QueryPage runs the given query returning at most n rows, starting from the
+// position identified by the given token, and returns them along with the
+// token of the next page. An empty token starts from the first row. Tokens
+// are interchangeable with the ones of client.Cursor.
+//
+// Each page is a separate query, so the connection is returned to the pool
+// as soon as the rows of a page are closed, rather than being held for the
+// whole scan. The query should have an ORDER BY clause, and rows inserted or
+// deleted between pages shift the position. A page with less than n rows is
+// the last one.
+func QueryPage(ctx context.Context, db *sql.DB, token string, n int, query string, args ...interface{}) (*sql.Rows, string, error) {
+	if n < 1 {
+		return nil, "", fmt.Errorf("invalid page size %d", n)
+	}
+
+	offset := uint64(0)
+	if token != "" {
+		var err error
+		offset, err = protocol.DecodePageToken(query, token)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, protocol.PageQuery(query, offset, int64(n)), args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return rows, protocol.EncodePageToken(query, offset+uint64(n)), nil
+}
@@ -0,0 +1,52 @@
+package driver_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Walk a result set a page at a time.
+func TestQueryPage(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	_, err = db.Exec("CREATE TABLE t (n INT)")
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = db.Exec("INSERT INTO t VALUES(?)", i)
+		require.NoError(t, err)
+	}
+
+	query := "SELECT n FROM t WHERE n >= ? ORDER BY n"
+	token := ""
+	pages := [][]int{}
+	for {
+		rows, next, err := driver.QueryPage(ctx, db, token, 3, query, 1)
+		require.NoError(t, err)
+		page := []int{}
+		for rows.Next() {
+			var n int
+			require.NoError(t, rows.Scan(&n))
+			page = append(page, n)
+		}
+		require.NoError(t, rows.Err())
+		require.NoError(t, rows.Close())
+		pages = append(pages, page)
+		if len(page) < 3 {
+			break
+		}
+		token = next
+	}
+
+	assert.Equal(t, [][]int{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}, {}}, pages)
+
+	_, _, err = driver.QueryPage(ctx, db, token, 3, "SELECT n FROM t")
+	assert.Equal(t, driver.ErrBadPageToken, err)
+}
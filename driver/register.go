@@ -0,0 +1,137 @@
+package driver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// The database/sql package does not support unregistering drivers, so each
+// name is registered there only once, with a proxy that looks up the dqlite
+// driver currently bound to that name in this registry.
+var registry = struct {
+	mu      sync.Mutex
+	drivers map[string]*Driver // Drivers currently bound, by name
+	proxies map[string]bool    // Names registered with database/sql
+	index   int                // Counter used to generate unique names
+}{
+	drivers: map[string]*Driver{},
+	proxies: map[string]bool{},
+}
+
+// Register makes the given dqlite driver available to database/sql under the
+// given name.
+//
+// Unlike sql.Register, it does not panic if the name is already in use, but
+// returns an error instead. A name can be registered again after calling
+// Unregister, for example when re-creating a driver in the same process.
+func Register(name string, driver *Driver) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.drivers[name]; ok {
+		return errors.Errorf("driver %q is already registered", name)
+	}
+
+	if !registry.proxies[name] {
+		if isRegistered(name) {
+			return errors.Errorf("driver %q is already registered by another package", name)
+		}
+		sql.Register(name, &proxyDriver{name: name})
+		registry.proxies[name] = true
+	}
+
+	registry.drivers[name] = driver
+
+	return nil
+}
+
+// RegisterUnique makes the given dqlite driver available to database/sql under
+// a name that does not collide with any other registered driver, and returns
+// that name. Names released with Unregister are reused.
+func RegisterUnique(driver *Driver) string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	// Prefer names that were registered with database/sql and then
+	// released, so no new driver is leaked there.
+	for name := range registry.proxies {
+		if _, ok := registry.drivers[name]; !ok {
+			registry.drivers[name] = driver
+			return name
+		}
+	}
+
+	for {
+		registry.index++
+		name := fmt.Sprintf("dqlite-%d", registry.index)
+		if isRegistered(name) {
+			continue
+		}
+		sql.Register(name, &proxyDriver{name: name})
+		registry.proxies[name] = true
+		registry.drivers[name] = driver
+		return name
+	}
+}
+
+// Unregister releases the given name, so it can be registered again. Opening
+// a database with that name fails until then, while databases already opened
+// keep working.
+func Unregister(name string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	delete(registry.drivers, name)
+}
+
+// Return the dqlite driver currently bound to the given name.
+func lookup(name string) (*Driver, error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	driver, ok := registry.drivers[name]
+	if !ok {
+		return nil, errors.Errorf("driver %q was unregistered", name)
+	}
+
+	return driver, nil
+}
+
+// Return true if a driver with the given name is registered with
+// database/sql.
+func isRegistered(name string) bool {
+	for _, registered := range sql.Drivers() {
+		if registered == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Driver registered with database/sql, forwarding to the dqlite driver bound
+// to its name at the time a database is opened.
+type proxyDriver struct {
+	name string
+}
+
+func (p *proxyDriver) Open(uri string) (driver.Conn, error) {
+	d, err := lookup(p.name)
+	if err != nil {
+		return nil, err
+	}
+	return d.Open(uri)
+}
+
+func (p *proxyDriver) OpenConnector(uri string) (driver.Connector, error) {
+	d, err := lookup(p.name)
+	if err != nil {
+		return nil, err
+	}
+	return d.OpenConnector(uri)
+}
+
+var _ driver.DriverContext = (*proxyDriver)(nil)
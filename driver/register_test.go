@@ -0,0 +1,62 @@
+package driver_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	drv, err := driver.New(client.NewInmemNodeStore())
+	require.NoError(t, err)
+
+	require.NoError(t, driver.Register("dqlite-register-test", drv))
+	assert.Contains(t, sql.Drivers(), "dqlite-register-test")
+
+	// Registering the same name twice fails without panicking.
+	err = driver.Register("dqlite-register-test", drv)
+	assert.EqualError(t, err, `driver "dqlite-register-test" is already registered`)
+
+	// After unregistering, the name can be used again.
+	driver.Unregister("dqlite-register-test")
+	_, err = sql.Open("dqlite-register-test", "test.db")
+	assert.EqualError(t, err, `driver "dqlite-register-test" was unregistered`)
+
+	require.NoError(t, driver.Register("dqlite-register-test", drv))
+	db, err := sql.Open("dqlite-register-test", "test.db")
+	require.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	driver.Unregister("dqlite-register-test")
+}
+
+func TestRegister_NameTakenByOtherPackage(t *testing.T) {
+	drv, err := driver.New(client.NewInmemNodeStore())
+	require.NoError(t, err)
+
+	err = driver.Register("sqlite3", drv)
+	assert.EqualError(t, err, `driver "sqlite3" is already registered by another package`)
+}
+
+func TestRegisterUnique(t *testing.T) {
+	drv, err := driver.New(client.NewInmemNodeStore())
+	require.NoError(t, err)
+
+	name1 := driver.RegisterUnique(drv)
+	name2 := driver.RegisterUnique(drv)
+	assert.NotEqual(t, name1, name2)
+
+	// Released names are reused instead of registering new ones.
+	n := len(sql.Drivers())
+	driver.Unregister(name1)
+	name3 := driver.RegisterUnique(drv)
+	assert.Equal(t, name1, name3)
+	assert.Len(t, sql.Drivers(), n)
+
+	driver.Unregister(name2)
+	driver.Unregister(name3)
+}
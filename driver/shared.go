@@ -0,0 +1,222 @@
+package driver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+// WithSharedConnections makes the connections of a database/sql pool share
+// at most the given number of physical connections to the cluster, for each
+// database. Zero, the default, disables sharing, so that each connection of
+// the pool has its own physical connection.
+//
+// The dqlite wire protocol handles one request at a time per connection, so
+// a physical connection is assigned to a connection of the pool only while
+// it's running a statement, or while it has an open transaction or result
+// set, and is then returned to the shared pool. This cuts the number of TLS
+// handshakes and file descriptors of applications with large but mostly
+// idle pools, at the cost of making at most n statements run concurrently.
+//
+// Prepared statements don't hold a physical connection either, so sharing
+// turns on the statement registry (see WithStatementRegistry), with 64
+// entries unless a size is given.
+func WithSharedConnections(n int) Option {
+	return func(options *options) {
+		options.SharedConnections = n
+	}
+}
+
+// Size of the statement registry of shared physical connections, if not set
+// with WithStatementRegistry.
+const sharedRegistrySize = 64
+
+// Physical connection to the leader, with an open database.
+type physicalConn struct {
+	protocol *protocol.Protocol
+	id       uint32        // Database ID.
+	node     string        // Address of the node.
+	stmts    *stmtRegistry // Registered statements, if enabled.
+}
+
+// Pool of physical connections to the leader shared by the connections of a
+// database/sql pool.
+type sharedPool struct {
+	connect func(ctx context.Context) (*physicalConn, error)
+	slots   chan struct{} // Holds a token for each leased connection.
+	mu      sync.Mutex
+	idle    []*physicalConn
+}
+
+func newSharedPool(size int, connect func(ctx context.Context) (*physicalConn, error)) *sharedPool {
+	return &sharedPool{
+		connect: connect,
+		slots:   make(chan struct{}, size),
+	}
+}
+
+// Lease a physical connection, waiting for one to be released if all of them
+// are in use.
+func (p *sharedPool) acquire(ctx context.Context) (*physicalConn, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		p.mu.Lock()
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			break
+		}
+		conn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		if conn.protocol.Alive() {
+			return conn, nil
+		}
+		conn.protocol.Close()
+	}
+
+	conn, err := p.connect(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Return a leased connection to the pool. If discard is true, or if the
+// connection is broken, it's closed instead, along with the idle connections
+// to the same node, which most likely suffer from the same problem, for
+// example because the node lost leadership.
+func (p *sharedPool) release(conn *physicalConn, discard bool) {
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !discard && !conn.protocol.Broken() {
+		p.idle = append(p.idle, conn)
+		return
+	}
+
+	conn.protocol.Close()
+	idle := p.idle[:0]
+	for _, other := range p.idle {
+		if other.node == conn.node {
+			other.protocol.Close()
+			continue
+		}
+		idle = append(idle, other)
+	}
+	p.idle = idle
+}
+
+// Return the pool of shared connections for the given database, creating it
+// if needed, or nil if sharing is disabled.
+func (d *Driver) sharedPool(uri string) *sharedPool {
+	if d.sharedSize == 0 {
+		return nil
+	}
+
+	d.sharedMu.Lock()
+	defer d.sharedMu.Unlock()
+
+	pool, ok := d.shared[uri]
+	if !ok {
+		pool = newSharedPool(d.sharedSize, func(ctx context.Context) (*physicalConn, error) {
+			if d.connectionTimeout != 0 {
+				var cancel func()
+				ctx, cancel = context.WithTimeout(ctx, d.connectionTimeout)
+				defer cancel()
+			}
+			return d.open(ctx, uri)
+		})
+		d.shared[uri] = pool
+	}
+
+	return pool
+}
+
+// Connect to the leader and open the given database.
+func (d *Driver) open(ctx context.Context, uri string) (*physicalConn, error) {
+	// TODO: generate a client ID.
+	connector := protocol.NewConnector(0, d.store, d.clientConfig, d.log)
+
+	p, err := connector.Connect(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create dqlite connection")
+	}
+
+	request := protocol.Message{}
+	request.Init(64)
+	response := protocol.Message{}
+	response.Init(64)
+
+	protocol.EncodeOpen(&request, uri, 0, "volatile")
+
+	if err := p.Call(ctx, &request, &response); err != nil {
+		p.Close()
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+
+	id, err := protocol.DecodeDb(&response)
+	if err != nil {
+		p.Close()
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+
+	conn := &physicalConn{protocol: p, id: id, node: p.RemoteAddr()}
+	if d.registrySize > 0 {
+		conn.stmts = newStmtRegistry(d.registrySize)
+	}
+
+	return conn, nil
+}
+
+// Make sure the connection has a physical connection until the matching
+// unpin call, leasing one from the shared pool if needed.
+func (c *Conn) pin(ctx context.Context) error {
+	if c.pool == nil {
+		return nil
+	}
+	if c.pins == 0 {
+		conn, err := c.pool.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		c.lease = conn
+		c.protocol = conn.protocol
+		c.id = conn.id
+		c.node = conn.node
+		c.stmts = conn.stmts
+	}
+	c.pins++
+	return nil
+}
+
+// Release a pin taken with pin, returning the physical connection to the
+// shared pool when no pin is left. If discard is true, the physical
+// connection might be in an unknown state and is closed.
+func (c *Conn) unpin(discard bool) {
+	if c.pool == nil {
+		return
+	}
+	c.discard = c.discard || discard
+	c.pins--
+	if c.pins > 0 {
+		return
+	}
+	c.pool.release(c.lease, c.discard)
+	c.lease = nil
+	c.protocol = nil
+	c.stmts = nil
+	c.discard = false
+}
@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// All connections of the pool share a single physical connection.
+func TestSharedConnections(t *testing.T) {
+	server, db := newFakeDB(t, WithSharedConnections(1))
+	server.SetQuery("SELECT n FROM t", fakeserver.Rows{Columns: []string{"n"}})
+
+	ctx := context.Background()
+
+	conns := make([]*sql.Conn, 5)
+	for i := range conns {
+		conn, err := db.Conn(ctx)
+		require.NoError(t, err)
+		defer conn.Close()
+		conns[i] = conn
+	}
+
+	wg := sync.WaitGroup{}
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *sql.Conn) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				_, err := conn.ExecContext(ctx, "INSERT INTO t VALUES(1)")
+				assert.NoError(t, err)
+				rows, err := conn.QueryContext(ctx, "SELECT n FROM t")
+				assert.NoError(t, err)
+				assert.NoError(t, rows.Close())
+			}
+		}(conn)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, server.Accepted())
+}
+
+// A transaction holds its physical connection until it ends.
+func TestSharedConnections_Tx(t *testing.T) {
+	_, db := newFakeDB(t, WithSharedConnections(1))
+
+	ctx := context.Background()
+
+	conn1, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn1.Close()
+	conn2, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn2.Close()
+
+	tx, err := conn1.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = tx.Exec("INSERT INTO t VALUES(1)")
+	require.NoError(t, err)
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = conn2.ExecContext(short, "INSERT INTO t VALUES(2)")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	require.NoError(t, tx.Commit())
+
+	_, err = conn2.ExecContext(ctx, "INSERT INTO t VALUES(2)")
+	assert.NoError(t, err)
+}
+
+// Prepared statements don't hold a physical connection, so a pool can have
+// more of them open than there are physical connections.
+func TestSharedConnections_Stmt(t *testing.T) {
+	_, db := newFakeDB(t, WithSharedConnections(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn1, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn1.Close()
+	conn2, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn2.Close()
+
+	stmts := []*sql.Stmt{}
+	for i, conn := range []*sql.Conn{conn1, conn2, conn1} {
+		stmt, err := conn.PrepareContext(ctx, fmt.Sprintf("INSERT INTO t VALUES(%d)", i))
+		require.NoError(t, err)
+		defer stmt.Close()
+		stmts = append(stmts, stmt)
+	}
+
+	for _, stmt := range stmts {
+		_, err := stmt.ExecContext(ctx)
+		require.NoError(t, err)
+	}
+
+	_, err = conn2.ExecContext(ctx, "INSERT INTO t VALUES(3)")
+	assert.NoError(t, err)
+}
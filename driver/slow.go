@@ -0,0 +1,223 @@
+package driver
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// Parameters for logging statements slower than a threshold.
+type slowQueryLog struct {
+	log        client.LogFunc
+	threshold  time.Duration
+	redact     bool
+	explain    explainFunc   // Used to log query plans, if set.
+	explaining chan struct{} // Holds a token while a plan is being captured.
+}
+
+// Return the query plan of the given statement, run against the given
+// database and node.
+type explainFunc func(ctx context.Context, node, database, sql string) (string, error)
+
+// A statement whose duration is being tracked, for slow query logging and
+// query statistics.
+type trackedQuery struct {
+	slow     *slowQueryLog
+	stats    *queryStats
+	start    time.Time
+	sql      string
+	params   int
+	node     string
+	database string
+}
+
+// Start tracking the duration of a statement. Return nil if both slow query
+// logging and query statistics are disabled.
+func trackQuery(slow *slowQueryLog, stats *queryStats, sql string, params int, node, database string) *trackedQuery {
+	if slow == nil && stats == nil {
+		return nil
+	}
+	return &trackedQuery{slow: slow, stats: stats, start: time.Now(), sql: sql, params: params, node: node, database: database}
+}
+
+// Record the statement in the query statistics and log it if it took longer
+// than the slow query threshold. It's a no-op if tracking is disabled.
+func (q *trackedQuery) done(rows int64, err error) {
+	if q == nil {
+		return
+	}
+
+	elapsed := time.Since(q.start)
+	q.stats.record(q.sql, elapsed, rows, err)
+	q.slow.report(q, elapsed, rows, err)
+}
+
+// Log the given statement if it took longer than the threshold. It's a no-op
+// if slow query logging is disabled.
+func (l *slowQueryLog) report(q *trackedQuery, elapsed time.Duration, rows int64, err error) {
+	if l == nil || elapsed < l.threshold {
+		return
+	}
+
+	sql := q.sql
+	if l.redact {
+		sql = redactSQL(sql)
+	}
+
+	format := "slow query took %s on %s (%d params, %d rows): %s"
+	args := []interface{}{elapsed, q.node, q.params, rows, sql}
+	if err != nil {
+		format += ": %v"
+		args = append(args, err)
+	}
+
+	l.log(client.LogWarn, format, args...)
+
+	if l.explain == nil {
+		return
+	}
+
+	// Capture one plan at a time, so a burst of slow statements doesn't
+	// make a burst of connections.
+	select {
+	case l.explaining <- struct{}{}:
+	default:
+		return
+	}
+
+	go func() {
+		defer func() { <-l.explaining }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+		defer cancel()
+
+		plan, err := l.explain(ctx, q.node, q.database, q.sql)
+		if err != nil {
+			l.log(client.LogDebug, "explain slow query: %v: %s", err, sql)
+			return
+		}
+		l.log(client.LogWarn, "slow query plan: %s: %s", plan, sql)
+	}()
+}
+
+// Replace string, blob and numeric literals in the given SQL text with a
+// question mark, so it can be logged without leaking data.
+func redactSQL(sql string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'':
+			// Skip to the closing quote, honoring escaped quotes.
+			for i++; i < len(sql); i++ {
+				if sql[i] == '\'' {
+					if i+1 < len(sql) && sql[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case (c == 'x' || c == 'X') && i+1 < len(sql) && sql[i+1] == '\'' && (i == 0 || !isIdentifier(sql[i-1])):
+			// Blob literal, the quoted part is handled by the next
+			// iteration.
+		case c >= '0' && c <= '9' && (i == 0 || !isIdentifier(sql[i-1])):
+			for i+1 < len(sql) && (isIdentifier(sql[i+1]) || sql[i+1] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		case c == '"' || c == '`' || c == '[':
+			// Quoted identifiers are kept as they are.
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			b.WriteByte(c)
+			for i++; i < len(sql); i++ {
+				b.WriteByte(sql[i])
+				if sql[i] == end {
+					break
+				}
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Maximum time to wait for the plan of a slow query.
+const explainTimeout = 5 * time.Second
+
+// Return the plan of the given statement, as reported by EXPLAIN QUERY PLAN
+// on a follower, or on the given node if no follower can run it.
+func (d *Driver) explain(ctx context.Context, node, database, sql string) (string, error) {
+	nodes, err := d.store.Get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	addresses := []string{}
+	for _, info := range nodes {
+		if info.Address != node {
+			addresses = append(addresses, info.Address)
+		}
+	}
+	addresses = append(addresses, node)
+
+	for _, address := range addresses {
+		var plan string
+		plan, err = d.explainOn(ctx, address, database, sql)
+		if err == nil {
+			return plan, nil
+		}
+	}
+
+	return "", err
+}
+
+// Run EXPLAIN QUERY PLAN for the given statement on the node with the given
+// address, returning the details of the plan steps.
+func (d *Driver) explainOn(ctx context.Context, address, database, sql string) (string, error) {
+	cli, err := client.New(ctx, address,
+		client.WithDialFunc(d.clientConfig.Dial), client.WithAuthToken(d.clientConfig.AuthToken))
+	if err != nil {
+		return "", err
+	}
+	defer cli.Close()
+
+	cursor, err := cli.Query(ctx, database, "EXPLAIN QUERY PLAN "+sql)
+	if err != nil {
+		return "", err
+	}
+	defer cursor.Close(ctx)
+
+	details := []string{}
+	for {
+		page, err := cursor.Fetch(ctx, 64)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		// The detail is the last column.
+		for _, row := range page {
+			if detail, ok := row[len(row)-1].(string); ok {
+				details = append(details, detail)
+			}
+		}
+	}
+
+	return strings.Join(details, "; "), nil
+}
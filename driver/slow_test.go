@@ -0,0 +1,118 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactSQL(t *testing.T) {
+	cases := []struct {
+		sql      string
+		redacted string
+	}{
+		{"SELECT 1", "SELECT ?"},
+		{"SELECT * FROM t WHERE s = 'it''s' AND n > 12.5", "SELECT * FROM t WHERE s = ? AND n > ?"},
+		{"INSERT INTO t2 VALUES(x'00ff', ?)", "INSERT INTO t2 VALUES(?, ?)"},
+		{`SELECT "col 1", [a'b] FROM t`, `SELECT "col 1", [a'b] FROM t`},
+		{"SELECT a1 FROM t WHERE b = ?", "SELECT a1 FROM t WHERE b = ?"},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			assert.Equal(t, c.redacted, redactSQL(c.sql))
+		})
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	logs := []string{}
+	log := func(l client.LogLevel, format string, a ...interface{}) {
+		if l == client.LogWarn {
+			logs = append(logs, fmt.Sprintf(format, a...))
+		}
+	}
+
+	server, db := newFakeDB(t, WithLogFunc(log), WithSlowQueryThreshold(time.Nanosecond), WithSlowQueryRedaction(true))
+	server.SetExec("INSERT INTO t VALUES('secret')", 0, 1)
+	server.SetQuery("SELECT n FROM t", fakeserver.Rows{
+		Columns: []string{"n"},
+		Values:  [][]driver.Value{{int64(1)}, {int64(2)}},
+	})
+
+	_, err := db.Exec("INSERT INTO t VALUES('secret')")
+	require.NoError(t, err)
+
+	rows, err := db.Query("SELECT n FROM t")
+	require.NoError(t, err)
+	for rows.Next() {
+	}
+	require.NoError(t, rows.Close())
+
+	require.Len(t, logs, 2)
+	node := "on " + server.Address()
+	assert.Contains(t, logs[0], node)
+	assert.Contains(t, logs[0], "(0 params, 1 rows): INSERT INTO t VALUES(?)")
+	assert.Contains(t, logs[1], "(0 params, 2 rows): SELECT n FROM t")
+}
+
+func TestSlowQueryLog_Disabled(t *testing.T) {
+	drv, err := New(client.NewInmemNodeStore())
+	require.NoError(t, err)
+	assert.Nil(t, drv.slow)
+}
+
+// The plan of a slow query is captured on a follower and logged.
+func TestSlowQueryLog_Explain(t *testing.T) {
+	leader, err := fakeserver.New(1)
+	require.NoError(t, err)
+	defer leader.Close()
+	leader.SetExec("INSERT INTO t VALUES(1)", 0, 1)
+
+	follower, err := fakeserver.New(2)
+	require.NoError(t, err)
+	defer follower.Close()
+	follower.SetLeader(&client.NodeInfo{ID: 1, Address: leader.Address()})
+	follower.SetQuery("EXPLAIN QUERY PLAN INSERT INTO t VALUES(1)", fakeserver.Rows{
+		Columns: []string{"id", "parent", "notused", "detail"},
+		Values:  [][]driver.Value{{int64(2), int64(0), int64(0), "SCAN t"}},
+	})
+
+	plans := make(chan string, 1)
+	log := func(l client.LogLevel, format string, a ...interface{}) {
+		if msg := fmt.Sprintf(format, a...); strings.HasPrefix(msg, "slow query plan") {
+			plans <- msg
+		}
+	}
+
+	store := client.NewInmemNodeStore()
+	store.Set(context.Background(), []client.NodeInfo{
+		{ID: 1, Address: leader.Address()},
+		{ID: 2, Address: follower.Address()},
+	})
+
+	drv, err := New(store, WithLogFunc(log), WithSlowQueryThreshold(time.Nanosecond), WithSlowQueryExplain(true))
+	require.NoError(t, err)
+	connector, err := drv.OpenConnector("test")
+	require.NoError(t, err)
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO t VALUES(1)")
+	require.NoError(t, err)
+
+	select {
+	case plan := <-plans:
+		assert.Equal(t, "slow query plan: SCAN t: INSERT INTO t VALUES(1)", plan)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no plan logged")
+	}
+}
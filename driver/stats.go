@@ -0,0 +1,195 @@
+package driver
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryStat holds statistics about all executions of a statement through the
+// driver, aggregated by normalized SQL text.
+type QueryStat struct {
+	SQL    string        // Normalized SQL text, with literals replaced by a question mark.
+	Calls  int64         // Number of executions.
+	Errors int64         // Number of executions that failed.
+	Rows   int64         // Rows returned by queries or affected by writes.
+	Total  time.Duration // Total execution time.
+	Min    time.Duration // Fastest execution.
+	Max    time.Duration // Slowest execution.
+	P50    time.Duration // Median execution time, over the most recent executions.
+	P95    time.Duration // 95th percentile, over the most recent executions.
+	P99    time.Duration // 99th percentile, over the most recent executions.
+}
+
+// Mean returns the average execution time of the statement.
+func (s QueryStat) Mean() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Calls)
+}
+
+// WithQueryStats enables the aggregation of per-statement statistics, which
+// can be retrieved with Driver.QueryStats. As with slow query logging, the
+// duration of queries includes fetching all rows.
+//
+// Statements are grouped by their SQL text with literals replaced by a
+// question mark and whitespace collapsed. At most 5000 distinct statements
+// are tracked: when the limit is reached the least executed one is evicted.
+func WithQueryStats(enabled bool) Option {
+	return func(options *options) {
+		options.QueryStats = enabled
+	}
+}
+
+// QueryStats returns the statistics of the statements executed through this
+// driver, sorted by decreasing total execution time. It returns nil if query
+// statistics are not enabled.
+func (d *Driver) QueryStats() []QueryStat {
+	return d.stats.snapshot()
+}
+
+// ResetQueryStats discards all query statistics collected so far.
+func (d *Driver) ResetQueryStats() {
+	d.stats.reset()
+}
+
+const (
+	queryStatsMax     = 5000 // Max number of distinct statements
+	queryStatsSamples = 1000 // Latencies kept per statement for percentiles
+)
+
+// Aggregate statistics by normalized statement.
+type queryStats struct {
+	mu      sync.Mutex
+	entries map[string]*queryStatsEntry
+}
+
+type queryStatsEntry struct {
+	stat    QueryStat
+	samples []time.Duration // Ring buffer of the most recent latencies
+	next    int             // Next position to write in samples
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{entries: map[string]*queryStatsEntry{}}
+}
+
+// Record an execution of the given statement. It's a no-op if query
+// statistics are disabled.
+func (s *queryStats) record(sql string, elapsed time.Duration, rows int64, err error) {
+	if s == nil {
+		return
+	}
+
+	sql = normalizeSQL(sql)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[sql]
+	if !ok {
+		if len(s.entries) >= queryStatsMax {
+			s.evict()
+		}
+		entry = &queryStatsEntry{stat: QueryStat{SQL: sql, Min: elapsed}}
+		s.entries[sql] = entry
+	}
+
+	stat := &entry.stat
+	stat.Calls++
+	if err != nil {
+		stat.Errors++
+	}
+	stat.Rows += rows
+	stat.Total += elapsed
+	if elapsed < stat.Min {
+		stat.Min = elapsed
+	}
+	if elapsed > stat.Max {
+		stat.Max = elapsed
+	}
+
+	if len(entry.samples) < queryStatsSamples {
+		entry.samples = append(entry.samples, elapsed)
+	} else {
+		entry.samples[entry.next] = elapsed
+	}
+	entry.next = (entry.next + 1) % queryStatsSamples
+}
+
+// Remove the least executed statement.
+func (s *queryStats) evict() {
+	var victim *queryStatsEntry
+	for _, entry := range s.entries {
+		if victim == nil || entry.stat.Calls < victim.stat.Calls {
+			victim = entry
+		}
+	}
+	if victim != nil {
+		delete(s.entries, victim.stat.SQL)
+	}
+}
+
+// Return a copy of the current statistics, with percentiles computed.
+func (s *queryStats) snapshot() []QueryStat {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]QueryStat, 0, len(s.entries))
+	for _, entry := range s.entries {
+		stat := entry.stat
+		samples := make([]time.Duration, len(entry.samples))
+		copy(samples, entry.samples)
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		stat.P50 = percentile(samples, 0.50)
+		stat.P95 = percentile(samples, 0.95)
+		stat.P99 = percentile(samples, 0.99)
+		stats = append(stats, stat)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Total != stats[j].Total {
+			return stats[i].Total > stats[j].Total
+		}
+		return stats[i].SQL < stats[j].SQL
+	})
+
+	return stats
+}
+
+func (s *queryStats) reset() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = map[string]*queryStatsEntry{}
+}
+
+// Return the given percentile of the given sorted samples, using the nearest
+// rank method.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(samples)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return samples[rank]
+}
+
+// Normalize the given SQL text, replacing literals with a question mark and
+// collapsing whitespace.
+func normalizeSQL(sql string) string {
+	return strings.Join(strings.Fields(redactSQL(sql)), " ")
+}
@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	assert.Equal(t, "SELECT * FROM t WHERE n = ? AND s = ?", normalizeSQL("SELECT *\n  FROM t WHERE n = 12 AND s = 'x'"))
+}
+
+func TestQueryStats(t *testing.T) {
+	stats := newQueryStats()
+
+	for i := 1; i <= 100; i++ {
+		stats.record("SELECT * FROM t WHERE n = 1", time.Duration(i)*time.Millisecond, 2, nil)
+	}
+	stats.record("INSERT INTO t VALUES(1)", time.Second, 1, errors.New("boom"))
+
+	snapshot := stats.snapshot()
+	require.Len(t, snapshot, 2)
+
+	insert := snapshot[1]
+	assert.Equal(t, "INSERT INTO t VALUES(?)", insert.SQL)
+	assert.Equal(t, int64(1), insert.Calls)
+	assert.Equal(t, int64(1), insert.Errors)
+	assert.Equal(t, time.Second, insert.P99)
+
+	sel := snapshot[0]
+	assert.Equal(t, "SELECT * FROM t WHERE n = ?", sel.SQL)
+	assert.Equal(t, int64(100), sel.Calls)
+	assert.Equal(t, int64(0), sel.Errors)
+	assert.Equal(t, int64(200), sel.Rows)
+	assert.Equal(t, time.Millisecond, sel.Min)
+	assert.Equal(t, 100*time.Millisecond, sel.Max)
+	assert.Equal(t, 50*time.Millisecond, sel.P50)
+	assert.Equal(t, 95*time.Millisecond, sel.P95)
+	assert.Equal(t, 99*time.Millisecond, sel.P99)
+	assert.Equal(t, 50500*time.Microsecond, sel.Mean())
+
+	stats.reset()
+	assert.Empty(t, stats.snapshot())
+}
+
+func TestQueryStats_Disabled(t *testing.T) {
+	drv, err := New(nil)
+	require.NoError(t, err)
+	assert.Nil(t, drv.QueryStats())
+	drv.ResetQueryStats()
+}
@@ -0,0 +1,172 @@
+package driver
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/pkg/errors"
+)
+
+// WithStatementRegistry keeps up to the given number of prepared statements
+// open on each physical connection, identified by a hash of their SQL text.
+// Zero, the default, disables the registry, so that each prepared statement
+// is finalized when it's closed.
+//
+// With the registry, preparing a statement whose SQL text was already
+// prepared on the physical connection reuses the statement on the node, and
+// the least recently used statements are finalized when the registry is full.
+// Prepared statements don't hold a physical connection until they're closed:
+// each execution looks the statement up in the registry of the physical
+// connection it runs on, preparing it again if it's not there. Combined with
+// WithSharedConnections, this makes the connections of a large pool share the
+// statements prepared on a few physical connections, instead of each
+// preparing its own copy of the same hot statements.
+func WithStatementRegistry(size int) Option {
+	return func(options *options) {
+		options.StatementRegistry = size
+	}
+}
+
+// Error code returned by the node when executing a statement it doesn't know.
+const errNotFound = 12
+
+// Statements prepared on a physical connection, identified by the hash of
+// their SQL text.
+//
+// A physical connection is used by a single connection at a time, so no
+// locking is needed.
+type stmtRegistry struct {
+	size  int
+	stmts map[[sha256.Size]byte]*list.Element
+	lru   *list.List // Of *registeredStmt, most recently used first.
+}
+
+// Statement prepared on the node.
+type registeredStmt struct {
+	hash   [sha256.Size]byte
+	id     uint32
+	params uint64
+}
+
+func newStmtRegistry(size int) *stmtRegistry {
+	return &stmtRegistry{
+		size:  size,
+		stmts: map[[sha256.Size]byte]*list.Element{},
+		lru:   list.New(),
+	}
+}
+
+// Return the hash identifying the given statement in a registry.
+func stmtHash(query string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(query))
+}
+
+// Return the statement with the given hash, preparing it with the given SQL
+// text if it's not registered. If the registry is full, the least recently
+// used statement is finalized.
+func (r *stmtRegistry) get(ctx context.Context, p *protocol.Protocol, request, response *protocol.Message, db uint32, hash [sha256.Size]byte, query string) (*registeredStmt, error) {
+	if elem, ok := r.stmts[hash]; ok {
+		r.lru.MoveToFront(elem)
+		return elem.Value.(*registeredStmt), nil
+	}
+
+	protocol.EncodePrepare(request, uint64(db), query)
+
+	if err := p.Call(ctx, request, response); err != nil {
+		return nil, err
+	}
+
+	_, id, params, err := protocol.DecodeStmt(response)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &registeredStmt{hash: hash, id: id, params: params}
+	r.stmts[hash] = r.lru.PushFront(stmt)
+
+	if r.lru.Len() <= r.size {
+		return stmt, nil
+	}
+
+	evicted := r.lru.Remove(r.lru.Back()).(*registeredStmt)
+	delete(r.stmts, evicted.hash)
+
+	protocol.EncodeFinalize(request, db, evicted.id)
+
+	if err := p.Call(ctx, request, response); err != nil {
+		return nil, errors.Wrap(err, "finalize evicted statement")
+	}
+
+	if err := protocol.DecodeEmpty(response); err != nil {
+		return nil, errors.Wrap(err, "finalize evicted statement")
+	}
+
+	return stmt, nil
+}
+
+// Remove the statement with the given hash, which the node doesn't know.
+func (r *stmtRegistry) forget(hash [sha256.Size]byte) {
+	if elem, ok := r.stmts[hash]; ok {
+		r.lru.Remove(elem)
+		delete(r.stmts, hash)
+	}
+}
+
+// Look up the statement in the registry of the physical connection currently
+// used by its connection, preparing it if needed. It's a no-op if the
+// statement registry is disabled.
+func (s *Stmt) resolve(ctx context.Context) error {
+	if s.query == "" {
+		return nil
+	}
+
+	c := s.conn
+	stmt, err := c.stmts.get(ctx, c.protocol, &c.request, &c.response, c.id, s.hash, s.query)
+	if err != nil {
+		return err
+	}
+
+	s.protocol = c.protocol
+	s.node = c.node
+	s.db = c.id
+	s.id = stmt.id
+	s.params = stmt.params
+
+	return nil
+}
+
+// Encode a request for the statement with the given function, send it and
+// decode the response with the given function. If the statement is
+// registered and the node doesn't know it, it's prepared again and the
+// request is retried once.
+func (s *Stmt) run(ctx context.Context, encode func(), decode func() error) error {
+	encode()
+	if err := withLabels(ctx, s.database, s.digest, s.call); err != nil {
+		return err
+	}
+	err := decode()
+	if s.query == "" || !isNotFound(err) {
+		return err
+	}
+
+	s.conn.stmts.forget(s.hash)
+	if err := s.resolve(ctx); err != nil {
+		return err
+	}
+
+	encode()
+	if err := withLabels(ctx, s.database, s.digest, s.call); err != nil {
+		return err
+	}
+	return decode()
+}
+
+// Whether the given error is a failure response for an unknown statement.
+func isNotFound(err error) bool {
+	if err, ok := errors.Cause(err).(protocol.ErrRequest); ok {
+		return err.Code == errNotFound
+	}
+	return false
+}
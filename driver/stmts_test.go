@@ -0,0 +1,118 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Connections sharing a physical connection reuse the statements prepared on
+// it.
+func TestStatementRegistry(t *testing.T) {
+	server, db := newFakeDB(t, WithSharedConnections(1), WithStatementRegistry(8))
+
+	ctx := context.Background()
+
+	conns := make([]*sql.Conn, 3)
+	for i := range conns {
+		conn, err := db.Conn(ctx)
+		require.NoError(t, err)
+		defer conn.Close()
+		conns[i] = conn
+	}
+
+	for _, conn := range conns {
+		stmt, err := conn.PrepareContext(ctx, "INSERT INTO t VALUES(1)")
+		require.NoError(t, err)
+		_, err = stmt.Exec()
+		require.NoError(t, err)
+		require.NoError(t, stmt.Close())
+	}
+
+	assert.Equal(t, 1, countRequests(server, protocol.RequestPrepare))
+	assert.Equal(t, 3, countRequests(server, protocol.RequestExec))
+	assert.Equal(t, 0, countRequests(server, protocol.RequestFinalize))
+}
+
+// Registered statements don't hold the physical connection between
+// executions.
+func TestStatementRegistry_Unpinned(t *testing.T) {
+	server, db := newFakeDB(t, WithSharedConnections(1), WithStatementRegistry(8))
+	server.SetQuery("SELECT n FROM t", fakeserver.Rows{Columns: []string{"n"}})
+
+	ctx := context.Background()
+
+	conn1, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn1.Close()
+	conn2, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn2.Close()
+
+	stmt, err := conn1.PrepareContext(ctx, "SELECT n FROM t")
+	require.NoError(t, err)
+	defer stmt.Close()
+
+	_, err = conn2.ExecContext(ctx, "INSERT INTO t VALUES(1)")
+	require.NoError(t, err)
+
+	rows, err := stmt.Query()
+	require.NoError(t, err)
+	assert.NoError(t, rows.Close())
+}
+
+// The least recently used statement is finalized when the registry is full.
+func TestStatementRegistry_Evict(t *testing.T) {
+	server, db := newFakeDB(t, WithStatementRegistry(1))
+
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, query := range []string{"INSERT INTO t VALUES(1)", "INSERT INTO t VALUES(2)", "INSERT INTO t VALUES(1)"} {
+		stmt, err := conn.PrepareContext(ctx, query)
+		require.NoError(t, err)
+		_, err = stmt.Exec()
+		require.NoError(t, err)
+		require.NoError(t, stmt.Close())
+	}
+
+	assert.Equal(t, 3, countRequests(server, protocol.RequestPrepare))
+	assert.Equal(t, 2, countRequests(server, protocol.RequestFinalize))
+}
+
+// A statement unknown to the node is transparently prepared again.
+func TestStatementRegistry_NotFound(t *testing.T) {
+	server, db := newFakeDB(t, WithStatementRegistry(8))
+
+	ctx := context.Background()
+
+	stmt, err := db.PrepareContext(ctx, "INSERT INTO t VALUES(1)")
+	require.NoError(t, err)
+	defer stmt.Close()
+
+	server.Fail(fakeserver.Failure{Type: protocol.RequestExec, Code: 12, Message: "no statement with the given id", Times: 1})
+
+	_, err = stmt.Exec()
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, countRequests(server, protocol.RequestPrepare))
+	assert.Equal(t, 2, countRequests(server, protocol.RequestExec))
+}
+
+func countRequests(server *fakeserver.Server, mtype uint8) int {
+	n := 0
+	for _, request := range server.Requests() {
+		if request.Type == mtype {
+			n++
+		}
+	}
+	return n
+}
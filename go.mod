@@ -8,8 +8,15 @@ require (
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	github.com/peterh/liner v1.2.0
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.7.1
 	github.com/spf13/cobra v1.0.0
-	github.com/stretchr/testify v1.6.0
-	golang.org/x/sys v0.0.0-20200602225109-6fdc65e7d980
+	github.com/spf13/pflag v1.0.3
+	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/otel v0.20.0
+	go.opentelemetry.io/otel/sdk v0.20.0
+	go.opentelemetry.io/otel/trace v0.20.0
+	golang.org/x/sys v0.0.0-20200615200032-f1bc736245b1
+	google.golang.org/grpc v1.36.0
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
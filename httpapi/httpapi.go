@@ -0,0 +1,478 @@
+// Package httpapi exposes the SQL and cluster management operations of a
+// dqlite application over HTTP, with JSON request and response bodies, so
+// that services not written in Go and scripts can use the cluster without a
+// dqlite client.
+//
+// The handler serves the following endpoints:
+//
+//	POST   /v1/databases/<name>/query  {"sql": "...", "params": [...]}
+//	POST   /v1/databases/<name>/exec   {"sql": "...", "params": [...]}
+//	GET    /v1/leader
+//	GET    /v1/cluster
+//	POST   /v1/cluster/transfer        {"id": <id>}
+//	POST   /v1/nodes/<id>/role         {"role": "voter|stand-by|spare"}
+//	DELETE /v1/nodes/<id>
+//
+// The query endpoint only accepts statements that can't modify the database,
+// so that OperationQuery can be granted for read-only access. Queries return
+// {"columns": [...], "rows": [[...], ...]}, executions return
+// {"rows_affected": n, "last_insert_id": n}, and failures return
+// {"error": "..."} with a non-2xx status code.
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/sqlutil"
+)
+
+// Backend provides access to the databases and the leader of a cluster. It's
+// implemented by *app.App.
+type Backend interface {
+	Open(ctx context.Context, database string) (*sql.DB, error)
+	Leader(ctx context.Context) (*client.Client, error)
+}
+
+// Operation identifies the kind of an HTTP request, for authorization.
+type Operation string
+
+// Possible operations.
+const (
+	OperationQuery   Operation = "query"   // Run a read-only query on a database.
+	OperationExec    Operation = "exec"    // Execute a statement on a database.
+	OperationCluster Operation = "cluster" // Get the leader or the cluster members.
+	OperationAdmin   Operation = "admin"   // Change roles, remove nodes or transfer leadership.
+)
+
+// AuthFunc decides whether the given HTTP request can perform the given
+// operation. The database name is empty for cluster operations. If it
+// returns an error the request is rejected with status 403.
+type AuthFunc func(r *http.Request, op Operation, database string) error
+
+// Option can be used to tweak handler parameters.
+type Option func(*options)
+
+// WithAuth sets the function used to authorize requests. By default all
+// requests are allowed, so exposing the handler to untrusted networks
+// without setting one is unsafe.
+func WithAuth(auth AuthFunc) Option {
+	return func(options *options) {
+		options.Auth = auth
+	}
+}
+
+// WithMaxBodySize sets the maximum size of request bodies, 1MB by default.
+func WithMaxBodySize(size int64) Option {
+	return func(options *options) {
+		options.MaxBodySize = size
+	}
+}
+
+// WithTimeout sets the maximum duration of each request, 30 seconds by
+// default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(options *options) {
+		options.Timeout = timeout
+	}
+}
+
+type options struct {
+	Auth        AuthFunc
+	MaxBodySize int64
+	Timeout     time.Duration
+}
+
+// Create a options object with sane defaults.
+func defaultOptions() *options {
+	return &options{
+		MaxBodySize: 1024 * 1024,
+		Timeout:     30 * time.Second,
+	}
+}
+
+// Handler serves the HTTP API.
+type Handler struct {
+	backend     Backend
+	auth        AuthFunc
+	maxBodySize int64
+	timeout     time.Duration
+	mu          sync.Mutex
+	dbs         map[string]*sql.DB // Cached database handles.
+}
+
+// New creates a new handler using the given backend.
+func New(backend Backend, options ...Option) *Handler {
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+
+	return &Handler{
+		backend:     backend,
+		auth:        o.Auth,
+		maxBodySize: o.MaxBodySize,
+		timeout:     o.Timeout,
+		dbs:         map[string]*sql.DB{},
+	}
+}
+
+// Close releases the database handles opened by the handler.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for name, db := range h.dbs {
+		db.Close()
+		delete(h.dbs, name)
+	}
+
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	switch {
+	case len(parts) == 4 && parts[1] == "databases" && parts[3] == "query":
+		h.handle(w, r, http.MethodPost, OperationQuery, parts[2], func(r *http.Request) (interface{}, int, error) {
+			return h.query(r, parts[2])
+		})
+	case len(parts) == 4 && parts[1] == "databases" && parts[3] == "exec":
+		h.handle(w, r, http.MethodPost, OperationExec, parts[2], func(r *http.Request) (interface{}, int, error) {
+			return h.exec(r, parts[2])
+		})
+	case len(parts) == 2 && parts[1] == "leader":
+		h.handle(w, r, http.MethodGet, OperationCluster, "", h.leader)
+	case len(parts) == 2 && parts[1] == "cluster":
+		h.handle(w, r, http.MethodGet, OperationCluster, "", h.cluster)
+	case len(parts) == 3 && parts[1] == "cluster" && parts[2] == "transfer":
+		h.handle(w, r, http.MethodPost, OperationAdmin, "", h.transfer)
+	case len(parts) == 4 && parts[1] == "nodes" && parts[3] == "role":
+		h.handle(w, r, http.MethodPost, OperationAdmin, "", func(r *http.Request) (interface{}, int, error) {
+			return h.assign(r, parts[2])
+		})
+	case len(parts) == 3 && parts[1] == "nodes":
+		h.handle(w, r, http.MethodDelete, OperationAdmin, "", func(r *http.Request) (interface{}, int, error) {
+			return h.remove(r, parts[2])
+		})
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+	}
+}
+
+// Check the method and authorization of the given request, then run the
+// given function and write its result.
+func (h *Handler) handle(
+	w http.ResponseWriter, r *http.Request, method string, op Operation, database string,
+	f func(r *http.Request) (interface{}, int, error)) {
+
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	if h.auth != nil {
+		if err := h.auth(r, op, database); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+	}
+
+	result, status, err := f(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Body of query and exec requests.
+type statement struct {
+	SQL    string        `json:"sql"`
+	Params []interface{} `json:"params"`
+}
+
+// Result of a query.
+type rowsResult struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// Result of an exec.
+type execResult struct {
+	RowsAffected int64 `json:"rows_affected"`
+	LastInsertID int64 `json:"last_insert_id"`
+}
+
+// Member of the cluster.
+type node struct {
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+	Role    string `json:"role"`
+}
+
+func (h *Handler) query(r *http.Request, database string) (interface{}, int, error) {
+	stmt, err := decodeStatement(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if !sqlutil.IsReadOnly(stmt.SQL) {
+		return nil, http.StatusForbidden, fmt.Errorf("query might modify the database, use exec")
+	}
+
+	db, err := h.open(r.Context(), database)
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+
+	rows, err := db.QueryContext(r.Context(), stmt.SQL, stmt.Params...)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	result := rowsResult{Columns: columns, Rows: [][]interface{}{}}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return result, http.StatusOK, nil
+}
+
+func (h *Handler) exec(r *http.Request, database string) (interface{}, int, error) {
+	stmt, err := decodeStatement(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	db, err := h.open(r.Context(), database)
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+
+	res, err := db.ExecContext(r.Context(), stmt.SQL, stmt.Params...)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	result := execResult{}
+	result.RowsAffected, _ = res.RowsAffected()
+	result.LastInsertID, _ = res.LastInsertId()
+
+	return result, http.StatusOK, nil
+}
+
+func (h *Handler) leader(r *http.Request) (interface{}, int, error) {
+	cli, err := h.backend.Leader(r.Context())
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+	defer cli.Close()
+
+	info, err := cli.Leader(r.Context())
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+
+	return node{ID: info.ID, Address: info.Address, Role: info.Role.String()}, http.StatusOK, nil
+}
+
+func (h *Handler) cluster(r *http.Request) (interface{}, int, error) {
+	cli, err := h.backend.Leader(r.Context())
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+	defer cli.Close()
+
+	nodes, err := cli.Cluster(r.Context())
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+
+	result := struct {
+		Nodes []node `json:"nodes"`
+	}{Nodes: make([]node, len(nodes))}
+	for i, info := range nodes {
+		result.Nodes[i] = node{ID: info.ID, Address: info.Address, Role: info.Role.String()}
+	}
+
+	return result, http.StatusOK, nil
+}
+
+func (h *Handler) transfer(r *http.Request) (interface{}, int, error) {
+	body := struct {
+		ID uint64 `json:"id"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("decode body: %w", err)
+	}
+
+	return h.withLeader(r.Context(), func(ctx context.Context, cli *client.Client) error {
+		return cli.Transfer(ctx, body.ID)
+	})
+}
+
+func (h *Handler) assign(r *http.Request, id string) (interface{}, int, error) {
+	nodeID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid node ID %q", id)
+	}
+
+	body := struct {
+		Role string `json:"role"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("decode body: %w", err)
+	}
+
+	var role client.NodeRole
+	switch body.Role {
+	case "voter":
+		role = client.Voter
+	case "stand-by", "standby":
+		role = client.StandBy
+	case "spare":
+		role = client.Spare
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("unknown role %q", body.Role)
+	}
+
+	return h.withLeader(r.Context(), func(ctx context.Context, cli *client.Client) error {
+		return cli.Assign(ctx, nodeID, role)
+	})
+}
+
+func (h *Handler) remove(r *http.Request, id string) (interface{}, int, error) {
+	nodeID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid node ID %q", id)
+	}
+
+	return h.withLeader(r.Context(), func(ctx context.Context, cli *client.Client) error {
+		return cli.Remove(ctx, nodeID)
+	})
+}
+
+// Run the given function with a client connected to the leader.
+func (h *Handler) withLeader(ctx context.Context, f func(context.Context, *client.Client) error) (interface{}, int, error) {
+	cli, err := h.backend.Leader(ctx)
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+	defer cli.Close()
+
+	if err := f(ctx, cli); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	return struct{}{}, http.StatusOK, nil
+}
+
+// Return a cached handle for the database with the given name, opening it if
+// needed.
+func (h *Handler) open(ctx context.Context, database string) (*sql.DB, error) {
+	h.mu.Lock()
+	db, ok := h.dbs[database]
+	h.mu.Unlock()
+	if ok {
+		return db, nil
+	}
+
+	db, err := h.backend.Open(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Another request might have opened the database in the meantime.
+	if existing, ok := h.dbs[database]; ok {
+		db.Close()
+		return existing, nil
+	}
+	h.dbs[database] = db
+
+	return db, nil
+}
+
+// Decode a statement, converting numeric parameters to integers when
+// possible.
+func decodeStatement(r io.Reader) (statement, error) {
+	stmt := statement{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&stmt); err != nil {
+		return stmt, fmt.Errorf("decode body: %w", err)
+	}
+	if stmt.SQL == "" {
+		return stmt, fmt.Errorf("no SQL text given")
+	}
+
+	for i, param := range stmt.Params {
+		number, ok := param.(json.Number)
+		if !ok {
+			continue
+		}
+		if n, err := number.Int64(); err == nil {
+			stmt.Params[i] = n
+		} else if f, err := number.Float64(); err == nil {
+			stmt.Params[i] = f
+		} else {
+			return stmt, fmt.Errorf("invalid number %s", number)
+		}
+	}
+
+	return stmt, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
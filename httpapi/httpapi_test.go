@@ -0,0 +1,156 @@
+package httpapi_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/httpapi"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_QueryAndExec(t *testing.T) {
+	handler, cleanup := newHandler(t)
+	defer cleanup()
+
+	status, body := do(t, handler, "POST", "/v1/databases/test/exec", `{"sql": "CREATE TABLE test (n INT, s TEXT)"}`)
+	assert.Equal(t, http.StatusOK, status, body)
+
+	status, body = do(t, handler, "POST", "/v1/databases/test/exec", `{"sql": "INSERT INTO test VALUES (?, ?)", "params": [123, "foo"]}`)
+	assert.Equal(t, http.StatusOK, status, body)
+	assert.JSONEq(t, `{"rows_affected": 1, "last_insert_id": 1}`, body)
+
+	status, body = do(t, handler, "POST", "/v1/databases/test/query", `{"sql": "SELECT n, s FROM test WHERE n = ?", "params": [123]}`)
+	assert.Equal(t, http.StatusOK, status, body)
+	assert.JSONEq(t, `{"columns": ["n", "s"], "rows": [[123, "foo"]]}`, body)
+}
+
+func TestHandler_Errors(t *testing.T) {
+	handler, cleanup := newHandler(t)
+	defer cleanup()
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"GET", "/v1/databases/test/query", "", http.StatusMethodNotAllowed},
+		{"POST", "/v1/databases/test/query", `{}`, http.StatusBadRequest},
+		{"POST", "/v1/databases/test/query", `{"sql": "SELECT * FROM missing"}`, http.StatusBadRequest},
+		{"POST", "/v1/nodes/abc/role", `{"role": "voter"}`, http.StatusBadRequest},
+		{"POST", "/v1/nodes/2/role", `{"role": "boss"}`, http.StatusBadRequest},
+		{"GET", "/v1/cluster", "", http.StatusServiceUnavailable},
+		{"GET", "/v2/cluster", "", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s %s", c.method, c.path), func(t *testing.T) {
+			status, body := do(t, handler, c.method, c.path, c.body)
+			assert.Equal(t, c.status, status)
+
+			result := map[string]string{}
+			require.NoError(t, json.Unmarshal([]byte(body), &result))
+			assert.NotEmpty(t, result["error"])
+		})
+	}
+}
+
+func TestHandler_Auth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dqlite-httpapi-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	auth := func(r *http.Request, op httpapi.Operation, database string) error {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			return fmt.Errorf("not authenticated")
+		}
+		if op != httpapi.OperationQuery || database != "test" {
+			return fmt.Errorf("%s not allowed on %q", op, database)
+		}
+		return nil
+	}
+	handler := httpapi.New(&backend{dir: dir}, httpapi.WithAuth(auth))
+	defer handler.Close()
+
+	query := `{"sql": "SELECT 1"}`
+
+	status, _ := do(t, handler, "POST", "/v1/databases/test/query", query)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	request := httptest.NewRequest("POST", "/v1/databases/test/query", strings.NewReader(query))
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	request = httptest.NewRequest("POST", "/v1/databases/other/query", strings.NewReader(query))
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	// Being allowed to query doesn't allow to write.
+	for _, write := range []string{
+		`{"sql": "CREATE TABLE test (n INT)"}`,
+		`{"sql": "SELECT 1; DROP TABLE test"}`,
+	} {
+		request = httptest.NewRequest("POST", "/v1/databases/test/query", strings.NewReader(write))
+		request.Header.Set("Authorization", "Bearer secret")
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusForbidden, recorder.Code, write)
+	}
+
+	request = httptest.NewRequest("POST", "/v1/databases/test/query", strings.NewReader(`{"sql": "SELECT count(*) FROM sqlite_master"}`))
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.JSONEq(t, `{"columns": ["count(*)"], "rows": [[0]]}`, recorder.Body.String())
+}
+
+// Backend using plain SQLite databases and no cluster.
+type backend struct {
+	dir string
+}
+
+func (b *backend) Open(ctx context.Context, database string) (*sql.DB, error) {
+	return sql.Open("sqlite3", filepath.Join(b.dir, database))
+}
+
+func (b *backend) Leader(ctx context.Context) (*client.Client, error) {
+	return nil, fmt.Errorf("no leader")
+}
+
+func newHandler(t *testing.T) (*httpapi.Handler, func()) {
+	dir, err := ioutil.TempDir("", "dqlite-httpapi-test-")
+	require.NoError(t, err)
+
+	handler := httpapi.New(&backend{dir: dir})
+
+	cleanup := func() {
+		handler.Close()
+		os.RemoveAll(dir)
+	}
+
+	return handler, cleanup
+}
+
+func do(t *testing.T, handler http.Handler, method, path, body string) (int, string) {
+	t.Helper()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code, recorder.Body.String()
+}
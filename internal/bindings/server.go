@@ -56,6 +56,18 @@ static void setInfo(dqlite_node_info *infos, unsigned i, dqlite_node_id id, cons
 	info->address = address;
 }
 
+static dqlite_node_info_ext *makeInfosExt(int n) {
+	return calloc(n, sizeof(dqlite_node_info_ext));
+}
+
+static void setInfoExt(dqlite_node_info_ext *infos, unsigned i, dqlite_node_id id, const char *address, int role) {
+	dqlite_node_info_ext *info = &infos[i];
+	info->size = sizeof(dqlite_node_info_ext);
+	info->id = id;
+	info->address = (uint64_t)(uintptr_t)address;
+	info->dqlite_role = role;
+}
+
 static int sqlite3ConfigSingleThread()
 {
 	return sqlite3_config(SQLITE_CONFIG_SINGLETHREAD);
@@ -152,6 +164,22 @@ func (s *Node) SetNetworkLatency(nanoseconds uint64) error {
 	return nil
 }
 
+func (s *Node) SetSnapshotCompression(enabled bool) error {
+	server := (*C.dqlite_node)(unsafe.Pointer(s))
+	if rc := C.dqlite_node_set_snapshot_compression(server, C.bool(enabled)); rc != 0 {
+		return fmt.Errorf("failed to set snapshot compression")
+	}
+	return nil
+}
+
+func (s *Node) SetBlockSize(size uint64) error {
+	server := (*C.dqlite_node)(unsafe.Pointer(s))
+	if rc := C.dqlite_node_set_block_size(server, C.size_t(size)); rc != 0 {
+		return fmt.Errorf("failed to set block size %d: %d", size, rc)
+	}
+	return nil
+}
+
 func (s *Node) GetBindAddress() string {
 	server := (*C.dqlite_node)(unsafe.Pointer(s))
 	return C.GoString(C.dqlite_node_get_bind_address(server))
@@ -197,6 +225,24 @@ func (s *Node) Recover(cluster []protocol.NodeInfo) error {
 	return nil
 }
 
+// RecoverExt is like Recover, but also sets the role of each node.
+func (s *Node) RecoverExt(cluster []protocol.NodeInfo) error {
+	server := (*C.dqlite_node)(unsafe.Pointer(s))
+	n := C.int(len(cluster))
+	infos := C.makeInfosExt(n)
+	defer C.free(unsafe.Pointer(infos))
+	for i, info := range cluster {
+		cid := C.dqlite_node_id(info.ID)
+		caddress := C.CString(info.Address)
+		defer C.free(unsafe.Pointer(caddress))
+		C.setInfoExt(infos, C.unsigned(i), cid, caddress, C.int(info.Role))
+	}
+	if rc := C.dqlite_node_recover_ext(server, infos, n); rc != 0 {
+		return fmt.Errorf("recover failed with error code %d", rc)
+	}
+	return nil
+}
+
 // GenerateID generates a unique ID for a server.
 func GenerateID(address string) uint64 {
 	caddress := C.CString(address)
@@ -0,0 +1,105 @@
+// Package cli contains helpers shared by the dqlite command line tools.
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/canonical/go-dqlite/app"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/spf13/pflag"
+)
+
+// Environment variables providing defaults for the TLS flags.
+const (
+	EnvCert   = "DQLITE_CERT"
+	EnvKey    = "DQLITE_KEY"
+	EnvCACert = "DQLITE_CACERT"
+)
+
+// TLS holds the values of the TLS command line flags.
+type TLS struct {
+	Cert   string // Path to the public TLS certificate.
+	Key    string // Path to the private TLS key.
+	CACert string // Path to the CA certificate, defaults to Cert.
+}
+
+// AddFlags registers the --cert, --key and --cacert flags, using the
+// DQLITE_CERT, DQLITE_KEY and DQLITE_CACERT environment variables as
+// defaults. If shorthands is true, -c and -k are registered as well.
+func (t *TLS) AddFlags(flags *pflag.FlagSet, shorthands bool) {
+	cert, key := "", ""
+	if shorthands {
+		cert, key = "c", "k"
+	}
+	flags.StringVarP(&t.Cert, "cert", cert, os.Getenv(EnvCert), "public TLS cert (env "+EnvCert+")")
+	flags.StringVarP(&t.Key, "key", key, os.Getenv(EnvKey), "private TLS key (env "+EnvKey+")")
+	flags.StringVar(&t.CACert, "cacert", os.Getenv(EnvCACert), "TLS CA cert, defaults to the public cert (env "+EnvCACert+")")
+}
+
+// Enabled returns true if TLS was requested.
+func (t *TLS) Enabled() bool {
+	return t.Cert != "" || t.Key != ""
+}
+
+// Load the key pair and the pool of trusted CAs.
+func (t *TLS) Load() (tls.Certificate, *x509.CertPool, error) {
+	if t.Cert == "" || t.Key == "" {
+		return tls.Certificate{}, nil, fmt.Errorf("both TLS certificate and key must be given")
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	ca := t.CACert
+	if ca == "" {
+		ca = t.Cert
+	}
+	data, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return tls.Certificate{}, nil, fmt.Errorf("bad certificate")
+	}
+
+	return cert, pool, nil
+}
+
+// DialFunc returns a dial function for connecting to dqlite nodes, using TLS
+// if it was requested.
+func (t *TLS) DialFunc() (client.DialFunc, error) {
+	if !t.Enabled() {
+		return client.DefaultDialFunc, nil
+	}
+
+	cert, pool, err := t.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	config := app.SimpleDialTLSConfig(cert, pool)
+	return client.DialFuncWithTLS(client.DefaultDialFunc, config), nil
+}
+
+// AppOptions returns the options configuring TLS for an App, if it was
+// requested.
+func (t *TLS) AppOptions() ([]app.Option, error) {
+	if !t.Enabled() {
+		return nil, nil
+	}
+
+	cert, pool, err := t.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return []app.Option{app.WithTLS(app.SimpleTLSConfig(cert, pool))}, nil
+}
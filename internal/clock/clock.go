@@ -0,0 +1,109 @@
+// Package clock abstracts the passage of time, so that time-dependent
+// behavior can be tested without actually waiting.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the given duration to elapse and then sends the
+	// current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+
+	// Sleep blocks until the given duration has elapsed.
+	Sleep(d time.Duration)
+}
+
+// Real is a Clock backed by the time package.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// Fake is a Clock whose time only moves forward when Advance is called.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewFake returns a Fake clock set at the given time.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now returns the current fake time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After returns a channel that fires once the fake time has been advanced by
+// at least the given duration.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, waiter{deadline: f.now.Add(d), ch: ch})
+	f.cond.Broadcast()
+
+	return ch
+}
+
+// Sleep blocks until the fake time has been advanced by at least the given
+// duration.
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// Advance moves the fake time forward, firing all timers that expire.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.deadline.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- f.now
+	}
+	f.waiters = pending
+}
+
+// BlockUntil blocks until at least n goroutines are waiting on the clock,
+// either with After or with Sleep.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
@@ -0,0 +1,45 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake_After(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := clock.NewFake(start)
+
+	ch := c.After(time.Second)
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Fatal("timer fired too early")
+	default:
+	}
+
+	c.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(time.Second), <-ch)
+}
+
+func TestFake_Sleep(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+
+	done := make(chan struct{})
+	go func() {
+		c.Sleep(time.Minute)
+		close(done)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	<-done
+}
+
+func TestFake_AfterZero(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	assert.Equal(t, time.Unix(0, 0), <-c.After(0))
+}
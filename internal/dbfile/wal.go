@@ -0,0 +1,135 @@
+// Package dbfile implements helpers to manipulate SQLite database and WAL
+// files as returned by a dqlite dump.
+package dbfile
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+	walMagicLE         = 0x377f0682
+	walMagicBE         = 0x377f0683
+	dbHeaderSize       = 100
+)
+
+// Checkpoint applies all committed frames contained in the given WAL file to
+// the given database file and returns the resulting database image.
+//
+// The returned image is a self-contained SQLite database file in rollback
+// journal mode, which can be opened by any SQLite reader without the need of
+// the WAL file. Uncommitted or invalid trailing frames in the WAL are ignored,
+// like SQLite itself would do.
+func Checkpoint(db []byte, wal []byte) ([]byte, error) {
+	image := make([]byte, len(db))
+	copy(image, db)
+
+	if len(wal) >= walHeaderSize {
+		var err error
+		image, err = applyWAL(image, wal)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(image) < dbHeaderSize {
+		if len(image) == 0 {
+			return image, nil
+		}
+		return nil, fmt.Errorf("database file too short (%d bytes)", len(image))
+	}
+
+	// Switch the file format from WAL to legacy rollback journal, so
+	// readers won't look for a WAL file.
+	image[18] = 1
+	image[19] = 1
+
+	return image, nil
+}
+
+func applyWAL(image []byte, wal []byte) ([]byte, error) {
+	magic := binary.BigEndian.Uint32(wal[0:])
+	if magic != walMagicLE && magic != walMagicBE {
+		return nil, fmt.Errorf("bad WAL magic %x", magic)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if magic == walMagicBE {
+		order = binary.BigEndian
+	}
+
+	pageSize := int(binary.BigEndian.Uint32(wal[8:]))
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("bad WAL page size %d", pageSize)
+	}
+	salt1 := binary.BigEndian.Uint32(wal[16:])
+	salt2 := binary.BigEndian.Uint32(wal[20:])
+
+	s0, s1 := walChecksum(order, wal[:24], 0, 0)
+	if s0 != binary.BigEndian.Uint32(wal[24:]) || s1 != binary.BigEndian.Uint32(wal[28:]) {
+		return nil, fmt.Errorf("bad WAL header checksum")
+	}
+
+	// Collect frames, applying them only once a commit frame is found.
+	pending := map[uint32][]byte{}
+	size := uint32(len(image) / pageSize)
+	frameSize := walFrameHeaderSize + pageSize
+
+	for offset := walHeaderSize; offset+frameSize <= len(wal); offset += frameSize {
+		header := wal[offset : offset+walFrameHeaderSize]
+		page := wal[offset+walFrameHeaderSize : offset+frameSize]
+
+		if binary.BigEndian.Uint32(header[8:]) != salt1 || binary.BigEndian.Uint32(header[12:]) != salt2 {
+			break
+		}
+		s0, s1 = walChecksum(order, header[:8], s0, s1)
+		s0, s1 = walChecksum(order, page, s0, s1)
+		if s0 != binary.BigEndian.Uint32(header[16:]) || s1 != binary.BigEndian.Uint32(header[20:]) {
+			break
+		}
+
+		pgno := binary.BigEndian.Uint32(header[0:])
+		if pgno == 0 {
+			return nil, fmt.Errorf("bad WAL frame page number")
+		}
+		pending[pgno] = page
+
+		commit := binary.BigEndian.Uint32(header[4:])
+		if commit == 0 {
+			continue
+		}
+
+		// This is a commit frame, apply all pending pages.
+		if need := int(commit) * pageSize; len(image) < need {
+			image = append(image, make([]byte, need-len(image))...)
+		}
+		for pgno, page := range pending {
+			if pgno > commit {
+				continue
+			}
+			copy(image[int(pgno-1)*pageSize:], page)
+		}
+		image = image[:int(commit)*pageSize]
+		size = commit
+		pending = map[uint32][]byte{}
+	}
+
+	if len(image) >= dbHeaderSize {
+		// Keep the in-header database size consistent.
+		binary.BigEndian.PutUint32(image[28:], size)
+		copy(image[92:96], image[24:28])
+	}
+
+	return image, nil
+}
+
+// Compute the WAL checksum of the given data, continuing from the given
+// initial values.
+func walChecksum(order binary.ByteOrder, data []byte, s0, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(data); i += 8 {
+		s0 += order.Uint32(data[i:]) + s1
+		s1 += order.Uint32(data[i+4:]) + s0
+	}
+	return s0, s1
+}
@@ -0,0 +1,74 @@
+package dbfile_test
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/dbfile"
+	_ "github.com/mattn/go-sqlite3" // Go SQLite bindings
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "test.db")
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec("PRAGMA journal_mode=WAL")
+	require.NoError(t, err)
+	_, err = db.Exec("PRAGMA wal_autocheckpoint=0")
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE test (n INT)")
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		_, err = db.Exec("INSERT INTO test(n) VALUES(?)", i)
+		require.NoError(t, err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	wal, err := ioutil.ReadFile(path + "-wal")
+	require.NoError(t, err)
+	require.NotEmpty(t, wal)
+
+	image, err := dbfile.Checkpoint(data, wal)
+	require.NoError(t, err)
+
+	copyPath := filepath.Join(dir, "copy.db")
+	require.NoError(t, ioutil.WriteFile(copyPath, image, 0600))
+
+	copyDB, err := sql.Open("sqlite3", copyPath)
+	require.NoError(t, err)
+	defer copyDB.Close()
+
+	var count int
+	require.NoError(t, copyDB.QueryRow("SELECT count(*) FROM test").Scan(&count))
+	assert.Equal(t, 100, count)
+
+	var mode string
+	require.NoError(t, copyDB.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "delete", mode)
+}
+
+func newDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "dqlite-dbfile-test-")
+	assert.NoError(t, err)
+
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	return dir, cleanup
+}
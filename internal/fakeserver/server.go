@@ -0,0 +1,399 @@
+// Package fakeserver implements a test double of a dqlite node, speaking the
+// wire protocol in pure Go.
+//
+// A Server accepts connections on a loopback address and answers requests
+// with canned responses, which can be configured per SQL text. It can be
+// instructed to redirect clients to another leader, and to fail specific
+// requests, making it possible to unit-test client and driver behavior
+// without starting real nodes or requiring libdqlite.
+package fakeserver
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+)
+
+// Server is a fake dqlite node.
+type Server struct {
+	id       uint64
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	leader   *protocol.NodeInfo // Leader to report, nil means ourselves.
+	cluster  []protocol.NodeInfo
+	queries  map[string]Rows
+	execs    map[string]protocol.Result
+	files    map[string][]File
+	failures []*Failure
+	requests []Request
+	conns    map[net.Conn]struct{}
+	accepted int
+	stmts    map[uint32]string
+	nextStmt uint32
+	state    *[2]uint64 // Index and term of the last log entry, if set.
+}
+
+// Rows holds a canned result set.
+type Rows struct {
+	Columns []string
+	Values  [][]driver.Value
+}
+
+// File holds a canned database file returned by a dump request.
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Request holds information about a request received by the server.
+type Request struct {
+	Type   uint8         // Request type code, see the protocol.Request* constants.
+	SQL    string        // SQL text, for exec and query requests.
+	Values []interface{} // Statement parameters, for exec and query requests.
+}
+
+// Failure describes a scripted failure.
+type Failure struct {
+	Type    uint8         // Request type code to fail.
+	SQL     string        // If not empty, only fail requests with this SQL text.
+	Code    uint64        // Error code of the failure response.
+	Message string        // Error message of the failure response.
+	Close   bool          // Close the connection instead of responding.
+	Delay   time.Duration // If set, respond normally after this delay instead.
+	Times   int           // Number of times to fail, 0 means forever.
+}
+
+// New creates a new fake server with the given ID, listening on a random
+// loopback port.
+func New(id uint64) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		id:       id,
+		listener: listener,
+		queries:  map[string]Rows{},
+		execs:    map[string]protocol.Result{},
+		files:    map[string][]File{},
+		conns:    map[net.Conn]struct{}{},
+		stmts:    map[uint32]string{},
+	}
+	s.cluster = []protocol.NodeInfo{{ID: id, Address: s.Address(), Role: protocol.Voter}}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Address returns the address the server is listening to.
+func (s *Server) Address() string {
+	return s.listener.Addr().String()
+}
+
+// ID returns the ID of the server.
+func (s *Server) ID() uint64 {
+	return s.id
+}
+
+// SetLeader makes the server report the given node as leader, redirecting
+// clients to it. Passing nil makes the server report itself as leader, which
+// is the default, while passing a NodeInfo with an empty address makes it
+// report that no leader is known.
+func (s *Server) SetLeader(leader *protocol.NodeInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leader = leader
+}
+
+// SetCluster sets the nodes returned by cluster requests.
+func (s *Server) SetCluster(nodes []protocol.NodeInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cluster = nodes
+}
+
+// SetLastLogEntry makes the server answer state requests with the given index
+// and term, like the proxy of an App node. By default they fail like with a
+// plain dqlite node.
+func (s *Server) SetLastLogEntry(index, term uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = &[2]uint64{index, term}
+}
+
+// SetQuery sets the result set returned by queries with the given SQL text.
+func (s *Server) SetQuery(sql string, rows Rows) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries[sql] = rows
+}
+
+// SetExec sets the result returned by statements with the given SQL text.
+// Statements without a canned result succeed with a zero result.
+func (s *Server) SetExec(sql string, lastInsertID, rowsAffected uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.execs[sql] = protocol.Result{LastInsertID: lastInsertID, RowsAffected: rowsAffected}
+}
+
+// SetDump sets the files returned when dumping the given database.
+func (s *Server) SetDump(database string, files []File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[database] = files
+}
+
+// Fail scripts a failure for matching requests.
+func (s *Server) Fail(failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := failure
+	s.failures = append(s.failures, &f)
+}
+
+// Requests returns all requests received so far, except leader, client
+// and heartbeat requests issued as part of the connection handshake.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// Accepted returns the number of client connections accepted so far.
+func (s *Server) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// DropConnections closes all client connections, as a node restart would,
+// while still accepting new ones.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Close stops the server and closes all connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.accepted++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// Handle a single client connection.
+func (s *Server) handle(conn net.Conn) {
+	handshake := make([]byte, 8)
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return
+	}
+	if binary.LittleEndian.Uint64(handshake) != protocol.VersionOne {
+		return
+	}
+
+	request := protocol.Message{}
+	request.Init(4096)
+	response := protocol.Message{}
+	response.Init(4096)
+
+	// Parameter chunks are assembled like the proxy of an App node does.
+	chunks := protocol.ChunkBuffer{}
+
+	for {
+		if err := protocol.ReadMessage(conn, &request); err != nil {
+			return
+		}
+		if request.Type() == protocol.RequestChunk {
+			if err := chunks.Add(&request, 0); err != nil {
+				return
+			}
+			protocol.EncodeEmpty(&response)
+		} else if err := chunks.Splice(&request); err != nil {
+			protocol.EncodeFailure(&response, 1, err.Error())
+		} else if delay, ok := s.respond(&request, &response); !ok {
+			return
+		} else {
+			time.Sleep(delay)
+		}
+		if err := protocol.WriteMessage(conn, &response); err != nil {
+			return
+		}
+	}
+}
+
+// Fill the response for the given request, returning the delay to wait before
+// sending it. Return false if the connection should be closed.
+func (s *Server) respond(request, response *protocol.Message) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mtype := request.Type()
+	req := Request{Type: mtype}
+
+	switch mtype {
+	case protocol.RequestExecSQL, protocol.RequestQuerySQL:
+		_, req.SQL, req.Values = decodeValues(protocol.DecodeExecSQLRequest(request))
+	case protocol.RequestExec, protocol.RequestQuery:
+		_, stmt, values := protocol.DecodeExecRequest(request)
+		req.SQL = s.stmts[stmt]
+		req.Values = namedValues(values)
+	case protocol.RequestPrepare:
+		_, req.SQL = protocol.DecodePrepareRequest(request)
+	}
+
+	switch mtype {
+	case protocol.RequestLeader, protocol.RequestClient, protocol.RequestHeartbeat:
+	default:
+		s.requests = append(s.requests, req)
+	}
+
+	delay := time.Duration(0)
+	if failure := s.failure(req); failure != nil {
+		if failure.Close {
+			return 0, false
+		}
+		if failure.Delay == 0 {
+			protocol.EncodeFailure(response, failure.Code, failure.Message)
+			return 0, true
+		}
+		delay = failure.Delay
+	}
+
+	switch mtype {
+	case protocol.RequestLeader:
+		if s.leader == nil {
+			protocol.EncodeNode(response, s.id, s.Address())
+		} else {
+			protocol.EncodeNode(response, s.leader.ID, s.leader.Address)
+		}
+	case protocol.RequestClient:
+		protocol.EncodeWelcome(response, 15000)
+	case protocol.RequestOpen:
+		protocol.EncodeDb(response, 0)
+	case protocol.RequestPrepare:
+		s.nextStmt++
+		s.stmts[s.nextStmt] = req.SQL
+		protocol.EncodeStmt(response, 0, s.nextStmt, 0)
+	case protocol.RequestExec, protocol.RequestExecSQL:
+		protocol.EncodeResult(response, s.execs[req.SQL])
+	case protocol.RequestQuery, protocol.RequestQuerySQL:
+		rows, ok := s.queries[req.SQL]
+		if !ok {
+			protocol.EncodeFailure(response, 1, fmt.Sprintf("no canned result for %q", req.SQL))
+			break
+		}
+		if err := protocol.EncodeRows(response, rows.Columns, rows.Values); err != nil {
+			protocol.EncodeFailure(response, 1, err.Error())
+		}
+	case protocol.RequestFinalize:
+		_, stmt := protocol.DecodeFinalizeRequest(request)
+		delete(s.stmts, stmt)
+		protocol.EncodeEmpty(response)
+	case protocol.RequestCluster:
+		protocol.EncodeNodes(response, s.cluster)
+	case protocol.RequestDump:
+		name := protocol.DecodeDumpRequest(request)
+		files := map[string][]byte{}
+		order := []string{}
+		for _, file := range s.files[name] {
+			files[file.Name] = file.Data
+			order = append(order, file.Name)
+		}
+		protocol.EncodeFiles(response, files, order)
+	case protocol.RequestState:
+		if s.state == nil {
+			protocol.EncodeFailure(response, 1, fmt.Sprintf("unsupported request type %d", mtype))
+			break
+		}
+		protocol.EncodeLastEntry(response, s.state[0], s.state[1])
+	case protocol.RequestInterrupt, protocol.RequestAdd, protocol.RequestAssign,
+		protocol.RequestRemove, protocol.RequestTransfer:
+		protocol.EncodeEmpty(response)
+	default:
+		protocol.EncodeFailure(response, 1, fmt.Sprintf("unsupported request type %d", mtype))
+	}
+
+	return delay, true
+}
+
+// Return the scripted failure matching the given request, if any.
+func (s *Server) failure(req Request) *Failure {
+	for i, failure := range s.failures {
+		if failure.Type != req.Type {
+			continue
+		}
+		if failure.SQL != "" && failure.SQL != req.SQL {
+			continue
+		}
+		if failure.Times > 0 {
+			failure.Times--
+			if failure.Times == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		return failure
+	}
+	return nil
+}
+
+func decodeValues(db uint64, sql string, values protocol.NamedValues) (uint64, string, []interface{}) {
+	return db, sql, namedValues(values)
+}
+
+func namedValues(values protocol.NamedValues) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		result[i] = value.Value
+	}
+	return result
+}
@@ -0,0 +1,135 @@
+package fakeserver_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	dqlitedriver "github.com/canonical/go-dqlite/driver"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Query(t *testing.T) {
+	server := newServer(t, 1)
+	server.SetQuery("SELECT n, s FROM test WHERE n > ?", fakeserver.Rows{
+		Columns: []string{"n", "s"},
+		Values: [][]driver.Value{
+			{int64(1), "foo"},
+			{int64(2), nil},
+		},
+	})
+
+	db := openDB(t, server.Address())
+
+	rows, err := db.Query("SELECT n, s FROM test WHERE n > ?", 0)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var n int
+	var s sql.NullString
+
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&n, &s))
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "foo", s.String)
+
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&n, &s))
+	assert.Equal(t, 2, n)
+	assert.False(t, s.Valid)
+
+	assert.False(t, rows.Next())
+	require.NoError(t, rows.Err())
+}
+
+func TestServer_Exec(t *testing.T) {
+	server := newServer(t, 1)
+	server.SetExec("INSERT INTO test VALUES(?)", 5, 1)
+
+	db := openDB(t, server.Address())
+
+	result, err := db.Exec("INSERT INTO test VALUES(?)", "hello")
+	require.NoError(t, err)
+
+	id, err := result.LastInsertId()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), id)
+
+	var values []interface{}
+	for _, request := range server.Requests() {
+		if request.SQL == "INSERT INTO test VALUES(?)" && request.Values != nil {
+			values = request.Values
+		}
+	}
+	assert.Equal(t, []interface{}{"hello"}, values)
+}
+
+func TestServer_Failure(t *testing.T) {
+	server := newServer(t, 1)
+	server.Fail(fakeserver.Failure{
+		Type:    protocol.RequestExecSQL,
+		Code:    1,
+		Message: "boom",
+		Times:   1,
+	})
+
+	db := openDB(t, server.Address())
+
+	_, err := db.Exec("DELETE FROM test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "boom")
+
+	_, err = db.Exec("DELETE FROM test")
+	assert.NoError(t, err)
+}
+
+// Clients are redirected to the leader reported by a follower.
+func TestServer_LeaderRedirect(t *testing.T) {
+	leader := newServer(t, 1)
+	follower := newServer(t, 2)
+	follower.SetLeader(&protocol.NodeInfo{ID: leader.ID(), Address: leader.Address()})
+
+	store := client.NewInmemNodeStore()
+	store.Set(context.Background(), []client.NodeInfo{{Address: follower.Address()}})
+
+	cli, err := client.FindLeader(context.Background(), store)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	info, err := cli.Leader(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, leader.Address(), info.Address)
+}
+
+func newServer(t *testing.T, id uint64) *fakeserver.Server {
+	t.Helper()
+
+	server, err := fakeserver.New(id)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	return server
+}
+
+func openDB(t *testing.T, address string) *sql.DB {
+	t.Helper()
+
+	store := client.NewInmemNodeStore()
+	store.Set(context.Background(), []client.NodeInfo{{Address: address}})
+
+	drv, err := dqlitedriver.New(store)
+	require.NoError(t, err)
+
+	connector, err := drv.OpenConnector("test")
+	require.NoError(t, err)
+
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"sync"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/clock"
+)
+
+// RateLimit returns a logging function that forwards messages to the given
+// one, emitting at most one message per interval for each combination of
+// level and format string.
+//
+// The first message of a kind is emitted immediately. Further messages of
+// the same kind within the interval are suppressed and, when the interval
+// elapses, a single summary message is emitted with the number of suppressed
+// messages and the arguments of the last one. Summaries keep being emitted
+// once per interval for as long as messages of that kind keep coming.
+func RateLimit(f Func, interval time.Duration, clk clock.Clock) Func {
+	l := &rateLimiter{
+		f:        f,
+		interval: interval,
+		clock:    clk,
+		windows:  map[rateLimitKey]*rateLimitWindow{},
+	}
+	return l.log
+}
+
+type rateLimiter struct {
+	f        Func
+	interval time.Duration
+	clock    clock.Clock
+	mu       sync.Mutex
+	windows  map[rateLimitKey]*rateLimitWindow // Kinds of messages seen in the current interval.
+}
+
+type rateLimitKey struct {
+	level  Level
+	format string
+}
+
+type rateLimitWindow struct {
+	suppressed int           // Messages suppressed in the current interval.
+	args       []interface{} // Arguments of the last suppressed message.
+}
+
+func (l *rateLimiter) log(level Level, format string, a ...interface{}) {
+	key := rateLimitKey{level: level, format: format}
+
+	l.mu.Lock()
+	window, ok := l.windows[key]
+	if ok {
+		window.suppressed++
+		window.args = a
+		l.mu.Unlock()
+		return
+	}
+	l.windows[key] = &rateLimitWindow{}
+	l.mu.Unlock()
+
+	go l.summarize(key)
+
+	l.f(level, format, a...)
+}
+
+// Emit a summary at the end of each interval in which messages of the given
+// kind were suppressed, and stop once an interval passes without any.
+func (l *rateLimiter) summarize(key rateLimitKey) {
+	for {
+		<-l.clock.After(l.interval)
+
+		l.mu.Lock()
+		window := l.windows[key]
+		if window.suppressed == 0 {
+			delete(l.windows, key)
+			l.mu.Unlock()
+			return
+		}
+		suppressed, args := window.suppressed, window.args
+		window.suppressed = 0
+		window.args = nil
+		l.mu.Unlock()
+
+		format := "%d similar messages suppressed in the last %s, last one: " + key.format
+		l.f(key.level, format, append([]interface{}{suppressed, l.interval}, args...)...)
+	}
+}
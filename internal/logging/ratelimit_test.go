@@ -0,0 +1,54 @@
+package logging_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/clock"
+	"github.com/canonical/go-dqlite/internal/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+
+	mu := sync.Mutex{}
+	messages := []string{}
+	f := func(l logging.Level, format string, a ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		messages = append(messages, fmt.Sprintf(l.String()+": "+format, a...))
+	}
+	received := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, messages...)
+	}
+
+	log := logging.RateLimit(f, time.Second, clk)
+
+	log(logging.Warn, "join cluster: %v", "error 1")
+	log(logging.Warn, "join cluster: %v", "error 2")
+	log(logging.Warn, "join cluster: %v", "error 3")
+	log(logging.Info, "other")
+
+	assert.Equal(t, []string{"WARN: join cluster: error 1", "INFO: other"}, received())
+
+	// Wait for both summary goroutines to be waiting.
+	clk.BlockUntil(2)
+	clk.Advance(time.Second)
+
+	assert.Eventually(t, func() bool { return len(received()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, "WARN: 2 similar messages suppressed in the last 1s, last one: join cluster: error 3", received()[2])
+
+	// After a quiet interval, messages are emitted right away again.
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	assert.Eventually(t, func() bool {
+		log(logging.Warn, "join cluster: %v", "error 4")
+		return len(received()) == 4
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "WARN: join cluster: error 4", received()[3])
+}
@@ -0,0 +1,199 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Chunk holds a piece of the value of a statement parameter, sent ahead of
+// the statement with a Chunk request.
+type Chunk struct {
+	Ordinal int // Ordinal of the parameter, starting from 1.
+	Data    []byte
+}
+
+// EncodeChunk encodes a Chunk request.
+func EncodeChunk(request *Message, ordinal uint64, data []byte) {
+	request.reset()
+	request.putUint64(ordinal)
+	request.putBlob(data)
+
+	request.putHeader(RequestChunk)
+}
+
+// DecodeChunkRequest decodes a Chunk request.
+func DecodeChunkRequest(request *Message) (ordinal uint64, data []byte) {
+	ordinal = request.getUint64()
+	data = request.getBlobShared()
+	return
+}
+
+// SplitValues replaces the blob and text values larger than the given size
+// with empty values of the same type, and returns their content split in
+// chunks of that size, to be sent with SendChunks before the statement.
+//
+// The given values are not modified. If no value is larger than the given
+// size, they are returned as they are, along with no chunks.
+func SplitValues(values NamedValues, size int) (NamedValues, []Chunk) {
+	var split NamedValues
+	var chunks []Chunk
+
+	for i, value := range values {
+		var data []byte
+		var placeholder interface{}
+		switch v := value.Value.(type) {
+		case []byte:
+			data, placeholder = v, []byte{}
+		case string:
+			data, placeholder = []byte(v), ""
+		}
+		if len(data) <= size {
+			continue
+		}
+
+		if split == nil {
+			split = append(NamedValues{}, values...)
+		}
+		split[i].Value = placeholder
+
+		for len(data) > 0 {
+			n := size
+			if n > len(data) {
+				n = len(data)
+			}
+			chunks = append(chunks, Chunk{Ordinal: value.Ordinal, Data: data[:n]})
+			data = data[n:]
+		}
+	}
+
+	if split == nil {
+		return values, nil
+	}
+
+	return split, chunks
+}
+
+// SendChunks sends the given chunks to the App proxy at the other end of the
+// given connection, which assembles them and splices the resulting values
+// into the next statement. It fails if the node doesn't support chunks.
+func SendChunks(ctx context.Context, protocol *Protocol, chunks []Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	request := Message{}
+	request.Init(len(chunks[0].Data) + 64)
+	response := Message{}
+	response.Init(64)
+
+	for _, chunk := range chunks {
+		EncodeChunk(&request, uint64(chunk.Ordinal), chunk.Data)
+
+		if err := protocol.Call(ctx, &request, &response); err != nil {
+			return errors.Wrap(err, "send parameter chunk")
+		}
+
+		if err := DecodeEmpty(&response); err != nil {
+			return errors.Wrap(err, "send parameter chunk")
+		}
+	}
+
+	return nil
+}
+
+// ChunkBuffer assembles the chunks received by a server, and splices them
+// into the next statement.
+type ChunkBuffer struct {
+	values map[int][]byte // Assembled values by parameter ordinal.
+	size   int            // Total size of the assembled values.
+}
+
+// Add the chunk carried by the given Chunk request, failing if the total size
+// of the assembled values exceeds the given maximum, unless it's zero.
+func (b *ChunkBuffer) Add(request *Message, max int) error {
+	ordinal, data := DecodeChunkRequest(request)
+
+	if max > 0 && b.size+len(data) > max {
+		b.Reset()
+		return fmt.Errorf("parameter chunks exceed %d bytes", max)
+	}
+
+	if b.values == nil {
+		b.values = map[int][]byte{}
+	}
+	b.values[int(ordinal)] = append(b.values[int(ordinal)], data...)
+	b.size += len(data)
+
+	return nil
+}
+
+// Splice replaces the parameters of the given Exec, Query, ExecSQL or
+// QuerySQL request with the assembled values, re-encoding it so it can be
+// decoded or forwarded with CopyMessage, and then empties the buffer.
+//
+// It does nothing if the buffer is empty, and fails if the request is of
+// another type or has no blob or text parameter matching an assembled value.
+func (b *ChunkBuffer) Splice(request *Message) (err error) {
+	if len(b.values) == 0 {
+		return nil
+	}
+	defer b.Reset()
+
+	// The request might have been decoded already.
+	request.body.Offset = 0
+
+	var db uint64
+	var stmt uint32
+	var sql string
+	var values NamedValues
+
+	mtype := request.Type()
+	switch mtype {
+	case RequestExec, RequestQuery:
+		var db32 uint32
+		db32, stmt, values = DecodeExecRequest(request)
+		db = uint64(db32)
+	case RequestExecSQL, RequestQuerySQL:
+		db, sql, values = DecodeExecSQLRequest(request)
+	default:
+		return fmt.Errorf("parameter chunks not followed by a statement")
+	}
+
+	for ordinal, data := range b.values {
+		if ordinal < 1 || ordinal > len(values) {
+			return fmt.Errorf("no parameter %d for chunks", ordinal)
+		}
+		switch values[ordinal-1].Value.(type) {
+		case []byte:
+			values[ordinal-1].Value = data
+		case string:
+			values[ordinal-1].Value = string(data)
+		default:
+			return fmt.Errorf("parameter %d is not a blob or text", ordinal)
+		}
+	}
+
+	switch mtype {
+	case RequestExec:
+		EncodeExec(request, uint32(db), stmt, values)
+	case RequestQuery:
+		EncodeQuery(request, uint32(db), stmt, values)
+	case RequestExecSQL:
+		EncodeExecSQL(request, db, sql, values)
+	case RequestQuerySQL:
+		EncodeQuerySQL(request, db, sql, values)
+	}
+
+	// Leave the request as if it was just read.
+	request.body.Offset = 0
+
+	return nil
+}
+
+// Reset discards the assembled values.
+func (b *ChunkBuffer) Reset() {
+	b.values = nil
+	b.size = 0
+}
@@ -0,0 +1,130 @@
+package protocol_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitValues(t *testing.T) {
+	values := protocol.NamedValues{
+		{Ordinal: 1, Value: int64(1)},
+		{Ordinal: 2, Value: []byte("hello world")},
+		{Ordinal: 3, Value: "abc"},
+		{Ordinal: 4, Value: "abcdefgh"},
+	}
+
+	split, chunks := protocol.SplitValues(values, 4)
+
+	assert.Equal(t, protocol.NamedValues{
+		{Ordinal: 1, Value: int64(1)},
+		{Ordinal: 2, Value: []byte{}},
+		{Ordinal: 3, Value: "abc"},
+		{Ordinal: 4, Value: ""},
+	}, split)
+	assert.Equal(t, []protocol.Chunk{
+		{Ordinal: 2, Data: []byte("hell")},
+		{Ordinal: 2, Data: []byte("o wo")},
+		{Ordinal: 2, Data: []byte("rld")},
+		{Ordinal: 4, Data: []byte("abcd")},
+		{Ordinal: 4, Data: []byte("efgh")},
+	}, chunks)
+
+	// The original values are untouched.
+	assert.Equal(t, []byte("hello world"), values[1].Value)
+}
+
+func TestSplitValues_Small(t *testing.T) {
+	values := protocol.NamedValues{{Ordinal: 1, Value: "abc"}}
+
+	split, chunks := protocol.SplitValues(values, 4)
+
+	assert.Equal(t, values, split)
+	assert.Nil(t, chunks)
+}
+
+func TestChunkBuffer_Splice(t *testing.T) {
+	values := protocol.NamedValues{
+		{Ordinal: 1, Value: int64(1)},
+		{Ordinal: 2, Value: []byte("hello world")},
+		{Ordinal: 3, Value: "abcdefgh"},
+	}
+	split, chunks := protocol.SplitValues(values, 4)
+
+	buffer := protocol.ChunkBuffer{}
+	for _, chunk := range chunks {
+		request := roundTrip(t, func(m *protocol.Message) {
+			protocol.EncodeChunk(m, uint64(chunk.Ordinal), chunk.Data)
+		})
+		require.NoError(t, buffer.Add(request, 0))
+	}
+
+	request := roundTrip(t, func(m *protocol.Message) {
+		protocol.EncodeExecSQL(m, 1, "INSERT INTO test VALUES(?, ?, ?)", split)
+	})
+	require.NoError(t, buffer.Splice(request))
+
+	// Forward the request like the App proxy does.
+	var buf bytes.Buffer
+	require.NoError(t, protocol.CopyMessage(&buf, request))
+	request.Init(64)
+	require.NoError(t, protocol.ReadMessage(&buf, request))
+
+	db, sql, spliced := protocol.DecodeExecSQLRequest(request)
+	assert.Equal(t, uint64(1), db)
+	assert.Equal(t, "INSERT INTO test VALUES(?, ?, ?)", sql)
+	assert.Equal(t, values, spliced)
+
+	// The buffer is now empty, so other requests are left alone.
+	assert.NoError(t, buffer.Splice(request))
+}
+
+func TestChunkBuffer_Errors(t *testing.T) {
+	chunk := func(ordinal uint64, data string) *protocol.Message {
+		return roundTrip(t, func(m *protocol.Message) {
+			protocol.EncodeChunk(m, ordinal, []byte(data))
+		})
+	}
+
+	buffer := protocol.ChunkBuffer{}
+	require.NoError(t, buffer.Add(chunk(1, "abcd"), 6))
+	assert.EqualError(t, buffer.Add(chunk(1, "efgh"), 6), "parameter chunks exceed 6 bytes")
+
+	require.NoError(t, buffer.Add(chunk(1, "abcd"), 0))
+	request := roundTrip(t, func(m *protocol.Message) { protocol.EncodeFinalize(m, 0, 1) })
+	assert.EqualError(t, buffer.Splice(request), "parameter chunks not followed by a statement")
+
+	require.NoError(t, buffer.Add(chunk(2, "abcd"), 0))
+	request = roundTrip(t, func(m *protocol.Message) {
+		protocol.EncodeExec(m, 0, 1, protocol.NamedValues{{Ordinal: 1, Value: ""}})
+	})
+	assert.EqualError(t, buffer.Splice(request), "no parameter 2 for chunks")
+
+	require.NoError(t, buffer.Add(chunk(1, "abcd"), 0))
+	request = roundTrip(t, func(m *protocol.Message) {
+		protocol.EncodeExec(m, 0, 1, protocol.NamedValues{{Ordinal: 1, Value: int64(1)}})
+	})
+	assert.EqualError(t, buffer.Splice(request), "parameter 1 is not a blob or text")
+}
+
+// Encode a message with the given function and read it back, like a server
+// does.
+func roundTrip(t *testing.T, encode func(*protocol.Message)) *protocol.Message {
+	t.Helper()
+
+	message := protocol.Message{}
+	message.Init(64)
+	encode(&message)
+
+	var buf bytes.Buffer
+	require.NoError(t, protocol.WriteMessage(&buf, &message))
+
+	read := protocol.Message{}
+	read.Init(64)
+	require.NoError(t, protocol.ReadMessage(&buf, &read))
+
+	return &read
+}
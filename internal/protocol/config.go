@@ -2,14 +2,22 @@ package protocol
 
 import (
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Config holds various configuration parameters for a dqlite client.
 type Config struct {
-	Dial           DialFunc      // Network dialer.
-	DialTimeout    time.Duration // Timeout for establishing a network connection .
-	AttemptTimeout time.Duration // Timeout for each individual attempt to probe a server's leadership.
-	BackoffFactor  time.Duration // Exponential backoff factor for retries.
-	BackoffCap     time.Duration // Maximum connection retry backoff value,
-	RetryLimit     uint          // Maximum number of retries, or 0 for unlimited.
+	Dial           DialFunc        // Network dialer.
+	DialTimeout    time.Duration   // Timeout for establishing a network connection .
+	AttemptTimeout time.Duration   // Timeout for each individual attempt to probe a server's leadership.
+	BackoffFactor  time.Duration   // Exponential backoff factor for retries.
+	BackoffCap     time.Duration   // Maximum connection retry backoff value,
+	RetryLimit     uint            // Maximum number of retries, or 0 for unlimited.
+	Observer       RequestObserver // Notified about requests performed by connected clients.
+	Tracer         trace.Tracer    // Used to create spans for connections and requests.
+	AuthToken      string          // Token presented after the handshake, if set.
+	ReadOnly       bool            // Whether to ask the node to reject writes.
+	LeaderTracker  *LeaderTracker  // Shared record of the current leader, if set.
+	Probes         int             // Maximum number of servers probed concurrently, if more than one.
 }
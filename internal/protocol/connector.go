@@ -12,6 +12,7 @@ import (
 	"github.com/Rican7/retry/backoff"
 	"github.com/Rican7/retry/strategy"
 	"github.com/canonical/go-dqlite/internal/logging"
+	"github.com/canonical/go-dqlite/internal/tracing"
 	"github.com/pkg/errors"
 )
 
@@ -64,6 +65,21 @@ func NewConnector(id uint64, store NodeStore, config Config, log logging.Func) *
 //
 // If the connector is stopped before a leader is found, nil is returned.
 func (c *Connector) Connect(ctx context.Context) (*Protocol, error) {
+	ctx, span := tracing.Start(ctx, c.config.Tracer, "dqlite.connect")
+
+	attempts := uint(0)
+	protocol, err := c.connect(ctx, &attempts)
+
+	if span != nil {
+		span.SetAttributes(tracing.Attempts.Int64(int64(attempts)))
+	}
+	tracing.End(span, err)
+
+	return protocol, err
+}
+
+// Retry connecting to the leader, counting the attempts made.
+func (c *Connector) connect(ctx context.Context, attempts *uint) (*Protocol, error) {
 	var protocol *Protocol
 
 	strategies := makeRetryStrategies(c.config.BackoffFactor, c.config.BackoffCap, c.config.RetryLimit)
@@ -76,6 +92,8 @@ func (c *Connector) Connect(ctx context.Context) (*Protocol, error) {
 			c.log(l, format, a...)
 		}
 
+		*attempts = attempt + 1
+
 		select {
 		case <-ctx.Done():
 			// Stop retrying
@@ -109,15 +127,66 @@ func (c *Connector) Connect(ctx context.Context) (*Protocol, error) {
 		panic("no protocol object")
 	}
 
+	protocol.SetObserver(c.config.Observer)
+	protocol.SetTracer(c.config.Tracer)
+
 	return protocol, nil
 }
 
 // Make a single attempt to establish a connection to the leader server trying
 // all addresses available in the store.
+//
+// If the config has a leader tracker, the tracked leader is tried first, and
+// the store is walked only if it's gone and no other connector is already
+// looking for the new one.
 func (c *Connector) connectAttemptAll(ctx context.Context, log logging.Func) (*Protocol, error) {
+	tracker := c.config.LeaderTracker
+	if tracker == nil {
+		protocol, _, err := c.connectAttemptWalk(ctx, log)
+		return protocol, err
+	}
+
+	stale := ""
+	for {
+		leader, done, err := tracker.discover(ctx, stale)
+		if err != nil {
+			return nil, err
+		}
+		if done != nil {
+			protocol, address, err := c.connectAttemptWalk(ctx, log)
+			done(address)
+			return protocol, err
+		}
+
+		log := func(l logging.Level, format string, a ...interface{}) {
+			format = fmt.Sprintf("tracked leader %s: ", leader) + format
+			log(l, format, a...)
+		}
+
+		protocol, address := c.connectAttemptServer(ctx, leader, log)
+		if protocol != nil {
+			if address != leader {
+				tracker.Set(address)
+			}
+			return protocol, nil
+		}
+
+		// The tracked leader is gone, look for the new one.
+		tracker.Forget(leader)
+		stale = leader
+	}
+}
+
+// Try all addresses available in the store until the leader is found,
+// returning its address along with the connection to it.
+func (c *Connector) connectAttemptWalk(ctx context.Context, log logging.Func) (*Protocol, string, error) {
 	servers, err := c.store.Get(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "get servers")
+		return nil, "", errors.Wrap(err, "get servers")
+	}
+
+	if c.config.Probes > 1 {
+		return c.connectAttemptProbe(ctx, servers, log)
 	}
 
 	// Make an attempt for each address until we find the leader.
@@ -127,59 +196,133 @@ func (c *Connector) connectAttemptAll(ctx context.Context, log logging.Func) (*P
 			log(l, format, a...)
 		}
 
-		ctx, cancel := context.WithTimeout(ctx, c.config.AttemptTimeout)
-		defer cancel()
-
-		version := VersionOne
-		protocol, leader, err := c.connectAttemptOne(ctx, server.Address, version)
-		if err == errBadProtocol {
-			log(logging.Warn, "unsupported protocol %d, attempt with legacy", version)
-			version = VersionLegacy
-			protocol, leader, err = c.connectAttemptOne(ctx, server.Address, version)
-		}
-		if err != nil {
-			// This server is unavailable, try with the next target.
-			log(logging.Warn, err.Error())
-			continue
-		}
+		protocol, address := c.connectAttemptServer(ctx, server.Address, log)
 		if protocol != nil {
-			// We found the leader
-			log(logging.Debug, "connected")
-			return protocol, nil
-		}
-		if leader == "" {
-			// This server does not know who the current leader is,
-			// try with the next target.
-			log(logging.Warn, "no known leader")
-			continue
+			return protocol, address, nil
 		}
+	}
+
+	return nil, "", ErrNoAvailableLeader
+}
 
-		// If we get here, it means this server reported that another
-		// server is the leader, let's close the connection to this
-		// server and try with the suggested one.
-		log(logging.Debug, "connect to reported leader %s", leader)
+// Like connectAttemptWalk, but probe up to config.Probes servers concurrently,
+// starting in the order of the store, and return as soon as one of the probes
+// connects to the leader, cancelling the others.
+func (c *Connector) connectAttemptProbe(ctx context.Context, servers []NodeInfo, log logging.Func) (*Protocol, string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		ctx, cancel = context.WithTimeout(ctx, c.config.AttemptTimeout)
-		defer cancel()
+	type result struct {
+		protocol *Protocol
+		address  string
+	}
 
-		protocol, leader, err = c.connectAttemptOne(ctx, leader, version)
-		if err != nil {
-			// The leader reported by the previous server is
-			// unavailable, try with the next target.
-			log(logging.Warn, "reported leader unavailable err=%v", err)
-			continue
+	// Each server yields exactly one result, so sends never block.
+	results := make(chan result, len(servers))
+	slots := make(chan struct{}, c.config.Probes)
+
+	go func() {
+		for _, server := range servers {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results <- result{}
+				continue
+			}
+			go func(address string) {
+				defer func() { <-slots }()
+
+				// Failures of cancelled probes are expected.
+				log := func(l logging.Level, format string, a ...interface{}) {
+					if ctx.Err() != nil {
+						return
+					}
+					format = fmt.Sprintf("server %s: ", address) + format
+					log(l, format, a...)
+				}
+
+				protocol, leader := c.connectAttemptServer(ctx, address, log)
+				results <- result{protocol: protocol, address: leader}
+			}(server.Address)
 		}
-		if protocol == nil {
-			// The leader reported by the target server does not consider itself
-			// the leader, try with the next target.
-			log(logging.Warn, "reported leader server is not the leader")
+	}()
+
+	for i := range servers {
+		r := <-results
+		if r.protocol == nil {
 			continue
 		}
+
+		// Close the connections of the probes that also reached the
+		// leader before being cancelled.
+		go func(n int) {
+			for ; n > 0; n-- {
+				if r := <-results; r.protocol != nil {
+					r.protocol.Close()
+				}
+			}
+		}(len(servers) - i - 1)
+
+		return r.protocol, r.address, nil
+	}
+
+	return nil, "", ErrNoAvailableLeader
+}
+
+// Connect to the server with the given address if it's the leader, or else to
+// the leader it reports, if any. Return the connection along with the address
+// of the leader, or nil if the leader could not be reached.
+func (c *Connector) connectAttemptServer(ctx context.Context, address string, log logging.Func) (*Protocol, string) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.AttemptTimeout)
+	defer cancel()
+
+	version := VersionOne
+	protocol, leader, err := c.connectAttemptOne(ctx, address, version)
+	if err == errBadProtocol {
+		log(logging.Warn, "unsupported protocol %d, attempt with legacy", version)
+		version = VersionLegacy
+		protocol, leader, err = c.connectAttemptOne(ctx, address, version)
+	}
+	if err != nil {
+		// This server is unavailable, try with the next target.
+		log(logging.Warn, err.Error())
+		return nil, ""
+	}
+	if protocol != nil {
+		// We found the leader
 		log(logging.Debug, "connected")
-		return protocol, nil
+		return protocol, address
+	}
+	if leader == "" {
+		// This server does not know who the current leader is,
+		// try with the next target.
+		log(logging.Warn, "no known leader")
+		return nil, ""
 	}
 
-	return nil, ErrNoAvailableLeader
+	// If we get here, it means this server reported that another
+	// server is the leader, let's close the connection to this
+	// server and try with the suggested one.
+	log(logging.Debug, "connect to reported leader %s", leader)
+
+	ctx, cancel = context.WithTimeout(ctx, c.config.AttemptTimeout)
+	defer cancel()
+
+	protocol, _, err = c.connectAttemptOne(ctx, leader, version)
+	if err != nil {
+		// The leader reported by the previous server is
+		// unavailable, try with the next target.
+		log(logging.Warn, "reported leader unavailable err=%v", err)
+		return nil, ""
+	}
+	if protocol == nil {
+		// The leader reported by the target server does not consider itself
+		// the leader, try with the next target.
+		log(logging.Warn, "reported leader server is not the leader")
+		return nil, ""
+	}
+	log(logging.Debug, "connected")
+	return protocol, leader
 }
 
 // Perform the initial handshake using the given protocol version.
@@ -206,6 +349,49 @@ func Handshake(ctx context.Context, conn net.Conn, version uint64) (*Protocol, e
 	return newProtocol(version, conn), nil
 }
 
+// Authenticate sends the given token to the node at the other end of the
+// given connection, which must have just completed the handshake.
+func Authenticate(ctx context.Context, protocol *Protocol, token string) error {
+	request := Message{}
+	request.Init(16 + len(token))
+	response := Message{}
+	response.Init(64)
+
+	EncodeAuth(&request, token)
+
+	if err := protocol.Call(ctx, &request, &response); err != nil {
+		return errors.Wrap(err, "authenticate")
+	}
+
+	if err := DecodeEmpty(&response); err != nil {
+		return errors.Wrap(err, "authenticate")
+	}
+
+	return nil
+}
+
+// SetReadOnly asks the App proxy at the other end of the given connection to
+// reject any later request that might modify data. It fails if the node
+// doesn't support read-only connections.
+func SetReadOnly(ctx context.Context, protocol *Protocol) error {
+	request := Message{}
+	request.Init(16)
+	response := Message{}
+	response.Init(64)
+
+	EncodeReadOnly(&request)
+
+	if err := protocol.Call(ctx, &request, &response); err != nil {
+		return errors.Wrap(err, "set read-only")
+	}
+
+	if err := DecodeEmpty(&response); err != nil {
+		return errors.Wrap(err, "set read-only")
+	}
+
+	return nil
+}
+
 // Connect to the given dqlite server and check if it's the leader.
 //
 // Return values:
@@ -231,6 +417,20 @@ func (c *Connector) connectAttemptOne(ctx context.Context, address string, versi
 		return nil, "", err
 	}
 
+	if c.config.AuthToken != "" {
+		if err := Authenticate(ctx, protocol, c.config.AuthToken); err != nil {
+			protocol.Close()
+			return nil, "", err
+		}
+	}
+
+	if c.config.ReadOnly {
+		if err := SetReadOnly(ctx, protocol); err != nil {
+			protocol.Close()
+			return nil, "", err
+		}
+	}
+
 	// Send the initial Leader request.
 	request := Message{}
 	request.Init(16)
@@ -242,6 +442,9 @@ func (c *Connector) connectAttemptOne(ctx context.Context, address string, versi
 	if err := protocol.Call(ctx, &request, &response); err != nil {
 		protocol.Close()
 		cause := errors.Cause(err)
+		if outcome, ok := cause.(ErrUnknownOutcome); ok {
+			cause = errors.Cause(outcome.Err)
+		}
 		// Best-effort detection of a pre-1.0 dqlite node: when sent
 		// version 1 it should close the connection immediately.
 		if err, ok := cause.(*net.OpError); ok && !err.Timeout() || cause == io.EOF {
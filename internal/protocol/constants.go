@@ -48,12 +48,33 @@ const (
 	RequestExecSQL   = 8
 	RequestQuerySQL  = 9
 	RequestInterrupt = 10
+	RequestConnect   = 11 // Used by raft connections between nodes.
 	RequestAdd       = 12
 	RequestAssign    = 13
 	RequestRemove    = 14
 	RequestDump      = 15
 	RequestCluster   = 16
 	RequestTransfer  = 17
+
+	// RequestAuth is not understood by the dqlite engine: it's handled
+	// by the go-dqlite App proxy, which requires it as the first
+	// request of a connection when authentication is enabled.
+	RequestAuth = 128
+
+	// RequestReadOnly is not understood by the dqlite engine either: the
+	// go-dqlite App proxy handles it by rejecting any later request
+	// that might modify data on the connection.
+	RequestReadOnly = 129
+
+	// RequestState is handled by the go-dqlite App proxy too, which
+	// replies with the index and term of the last entry of the raft log
+	// of its node.
+	RequestState = 130
+
+	// RequestChunk is handled by the go-dqlite App proxy as well, which
+	// assembles the parameter values it carries and splices them into the
+	// next statement of the connection.
+	RequestChunk = 131
 )
 
 // Response types.
@@ -69,6 +90,10 @@ const (
 	ResponseRows       = 7
 	ResponseEmpty      = 8
 	ResponseFiles      = 9
+
+	// ResponseLastEntry is only sent by the go-dqlite App proxy, in reply
+	// to RequestState.
+	ResponseLastEntry = 128
 )
 
 // Human-readable description of a request type.
@@ -97,6 +122,8 @@ func requestDesc(code uint8) string {
 		return "query-sql"
 	case RequestInterrupt:
 		return "interrupt"
+	case RequestConnect:
+		return "connect"
 	case RequestAdd:
 		return "add"
 	case RequestAssign:
@@ -109,6 +136,14 @@ func requestDesc(code uint8) string {
 		return "cluster"
 	case RequestTransfer:
 		return "transfer"
+	case RequestAuth:
+		return "auth"
+	case RequestReadOnly:
+		return "read-only"
+	case RequestState:
+		return "state"
+	case RequestChunk:
+		return "chunk"
 	}
 	return "unknown"
 }
@@ -136,6 +171,8 @@ func responseDesc(code uint8) string {
 		return "empty"
 	case ResponseFiles:
 		return "files"
+	case ResponseLastEntry:
+		return "last-entry"
 	}
 	return "unknown"
 }
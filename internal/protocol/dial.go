@@ -7,24 +7,25 @@ import (
 	"strings"
 )
 
-// Dial function handling plain TCP and Unix socket endpoints.
+// Dial function handling plain TCP and Unix socket endpoints. Addresses
+// starting with "@" are abstract Unix sockets, and addresses starting with "/"
+// are Unix sockets in the filesystem.
 func Dial(ctx context.Context, address string) (net.Conn, error) {
 	family := "tcp"
-	if strings.HasPrefix(address, "@") {
+	if strings.HasPrefix(address, "@") || strings.HasPrefix(address, "/") {
 		family = "unix"
 	}
 	dialer := net.Dialer{}
 	return dialer.DialContext(ctx, family, address)
 }
 
-// TLSCipherSuites are the cipher suites by the go-dqlite TLS helpers.
+// TLSCipherSuites are the cipher suites by the go-dqlite TLS helpers. Only
+// suites providing forward secrecy and authenticated encryption are included.
 var TLSCipherSuites = []uint16{
 	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
-	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
-	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
 }
@@ -12,8 +12,29 @@ var (
 	errNotClustered      = fmt.Errorf("server is not clustered")
 	errNegativeRead      = fmt.Errorf("reader returned negative count from Read")
 	errMessageEOF        = fmt.Errorf("message eof")
+	errUnexpectedData    = fmt.Errorf("unexpected data from server")
 )
 
+// ErrBrokenConn is returned when trying to use a connection after a network
+// error. The request was not sent, so it's safe to retry it on another
+// connection.
+var ErrBrokenConn = fmt.Errorf("connection is broken")
+
+// ErrUnknownOutcome is returned when the connection fails after a request was
+// sent, so it's unknown whether the server processed it.
+type ErrUnknownOutcome struct {
+	Err error
+}
+
+func (e ErrUnknownOutcome) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying network error.
+func (e ErrUnknownOutcome) Unwrap() error {
+	return e.Err
+}
+
 // ErrRequest is returned in case of request failure.
 type ErrRequest struct {
 	Code        uint64
@@ -24,6 +45,10 @@ func (e ErrRequest) Error() string {
 	return fmt.Sprintf("%s (%d)", e.Description, e.Code)
 }
 
+// ErrMessageTooLarge is returned when a request or response doesn't fit in the
+// maximum message buffer size.
+var ErrMessageTooLarge = fmt.Errorf("message exceeds maximum buffer size")
+
 // ErrRowsPart is returned when the first batch of a multi-response result
 // batch is done.
 var ErrRowsPart = fmt.Errorf("not all rows were returned in this response")
@@ -37,3 +62,24 @@ type Error struct {
 func (e Error) Error() string {
 	return e.Message
 }
+
+// errMalformed is raised as a panic by the message getters when a body is
+// truncated or inconsistent, and turned back into a regular error by the
+// decoders, so a misbehaving peer can't crash the process.
+type errMalformed struct {
+	error
+}
+
+// Convert a panic raised while decoding a malformed message into an error,
+// re-raising any other panic.
+func recoverMalformed(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	e, ok := r.(errMalformed)
+	if !ok {
+		panic(r)
+	}
+	*err = e.error
+}
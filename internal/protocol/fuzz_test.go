@@ -0,0 +1,138 @@
+//go:build go1.18
+// +build go1.18
+
+package protocol
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+// The fuzz targets below are seeded with the golden wire-format vectors and
+// can be run with, for example:
+//
+//	go test ./internal/protocol -run '^$' -fuzz FuzzDecodeResponse
+
+// Add all golden vectors to the seed corpus.
+func addGoldenCorpus(f *testing.F) {
+	data, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	golden := map[string]string{}
+	if err := json.Unmarshal(data, &golden); err != nil {
+		f.Fatal(err)
+	}
+
+	for _, encoded := range golden {
+		raw, err := hex.DecodeString(encoded)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(raw)
+	}
+}
+
+// Read a message from the fuzzer input, skipping inputs that aren't a full
+// message or are too large to be worth decoding.
+func fuzzMessage(t *testing.T, data []byte) *Message {
+	m := &Message{}
+	m.Init(16)
+	if err := ReadMessageLimit(bytes.NewReader(data), m, 1<<16); err != nil {
+		t.Skip()
+	}
+	return m
+}
+
+// Response decoders must return an error, and never panic, whatever the
+// server sends.
+func FuzzDecodeResponse(f *testing.F) {
+	addGoldenCorpus(f)
+
+	decoders := []func(m *Message) error{
+		func(m *Message) error { _, _, err := DecodeFailure(m); return err },
+		func(m *Message) error { _, err := DecodeWelcome(m); return err },
+		func(m *Message) error { _, err := DecodeNodeLegacy(m); return err },
+		func(m *Message) error { _, _, err := DecodeNode(m); return err },
+		func(m *Message) error { _, err := DecodeNodes(m); return err },
+		func(m *Message) error { _, err := DecodeDb(m); return err },
+		func(m *Message) error { _, _, _, err := DecodeStmt(m); return err },
+		func(m *Message) error { return DecodeEmpty(m) },
+		func(m *Message) error { _, err := DecodeResult(m); return err },
+		func(m *Message) error { _, _, err := DecodeLastEntry(m); return err },
+		func(m *Message) error {
+			files, err := DecodeFiles(m)
+			if err != nil {
+				return err
+			}
+			for name, _ := files.Next(); name != ""; name, _ = files.Next() {
+			}
+			return nil
+		},
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := fuzzMessage(t, data)
+		for _, decode := range decoders {
+			m.Rewind()
+			decode(m)
+		}
+	})
+}
+
+// Row parsing must return an error, and never panic, whatever the server
+// sends.
+func FuzzDecodeRows(f *testing.F) {
+	addGoldenCorpus(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := fuzzMessage(t, data)
+		rows, err := DecodeRows(m)
+		if err != nil {
+			return
+		}
+		rows.ColumnTypes()
+		dest := make([]driver.Value, len(rows.Columns))
+		for rows.Next(dest) == nil {
+		}
+		rows.Close()
+	})
+}
+
+// Request decoders, used by the pure-Go test doubles of a dqlite node, may
+// only fail with a malformed message error.
+func FuzzDecodeRequest(f *testing.F) {
+	addGoldenCorpus(f)
+
+	decoders := []func(m *Message){
+		func(m *Message) { DecodeClientRequest(m) },
+		func(m *Message) { DecodeOpenRequest(m) },
+		func(m *Message) { DecodePrepareRequest(m) },
+		func(m *Message) { DecodeExecRequest(m) },
+		func(m *Message) { DecodeFinalizeRequest(m) },
+		func(m *Message) { DecodeExecSQLRequest(m) },
+		func(m *Message) { DecodeNodeRequest(m) },
+		func(m *Message) { DecodeUint64Request(m) },
+		func(m *Message) { DecodeAssignRequest(m) },
+		func(m *Message) { DecodeDumpRequest(m) },
+		func(m *Message) { DecodeAuthRequest(m) },
+		func(m *Message) { DecodeChunkRequest(m) },
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := fuzzMessage(t, data)
+		for _, decode := range decoders {
+			m.Rewind()
+			func() (err error) {
+				defer recoverMalformed(&err)
+				decode(m)
+				return nil
+			}()
+		}
+	})
+}
@@ -0,0 +1,344 @@
+package protocol
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Regenerate the golden file with:
+//
+//	go test ./internal/protocol -run Golden -update
+var update = flag.Bool("update", false, "update golden wire-format vectors")
+
+var goldenFile = filepath.Join("testdata", "wire.golden.json")
+
+// A golden vector encodes a message and then checks that the decoded values
+// match the ones that were encoded.
+type goldenVector struct {
+	encode func(m *Message)
+	decode func(t *testing.T, m *Message)
+}
+
+var goldenTimestamp = time.Date(2020, 6, 2, 10, 30, 0, 0, time.UTC)
+
+var goldenValues = NamedValues{
+	{Ordinal: 1, Value: int64(-42)},
+	{Ordinal: 2, Value: 3.5},
+	{Ordinal: 3, Value: true},
+	{Ordinal: 4, Value: []byte{0xde, 0xad, 0xbe, 0xef, 0x01}},
+	{Ordinal: 5, Value: "hello"},
+	{Ordinal: 6, Value: nil},
+	{Ordinal: 7, Value: goldenTimestamp},
+}
+
+var goldenVectors = map[string]goldenVector{
+	"request-leader": {
+		func(m *Message) { EncodeLeader(m) },
+		func(t *testing.T, m *Message) {
+			assert.Equal(t, uint8(RequestLeader), m.Type())
+		},
+	},
+	"request-client": {
+		func(m *Message) { EncodeClient(m, 0x0102030405060708) },
+		func(t *testing.T, m *Message) {
+			assert.Equal(t, uint64(0x0102030405060708), DecodeClientRequest(m))
+		},
+	},
+	"request-open": {
+		func(m *Message) { EncodeOpen(m, "test.db", 6, "volatile") },
+		func(t *testing.T, m *Message) {
+			name, flags, vfs := DecodeOpenRequest(m)
+			assert.Equal(t, "test.db", name)
+			assert.Equal(t, uint64(6), flags)
+			assert.Equal(t, "volatile", vfs)
+		},
+	},
+	"request-prepare": {
+		func(m *Message) { EncodePrepare(m, 1, "SELECT 1") },
+		func(t *testing.T, m *Message) {
+			db, sql := DecodePrepareRequest(m)
+			assert.Equal(t, uint64(1), db)
+			assert.Equal(t, "SELECT 1", sql)
+		},
+	},
+	"request-exec": {
+		func(m *Message) { EncodeExec(m, 1, 2, goldenValues) },
+		func(t *testing.T, m *Message) {
+			db, stmt, values := DecodeExecRequest(m)
+			assert.Equal(t, uint32(1), db)
+			assert.Equal(t, uint32(2), stmt)
+			assertGoldenValues(t, values)
+		},
+	},
+	"request-exec-no-values": {
+		func(m *Message) { EncodeExec(m, 1, 2, nil) },
+		func(t *testing.T, m *Message) {
+			_, _, values := DecodeExecRequest(m)
+			assert.Len(t, values, 0)
+		},
+	},
+	"request-query-sql": {
+		func(m *Message) { EncodeQuerySQL(m, 3, "SELECT * FROM test WHERE n = ?", goldenValues[:1]) },
+		func(t *testing.T, m *Message) {
+			db, sql, values := DecodeExecSQLRequest(m)
+			assert.Equal(t, uint64(3), db)
+			assert.Equal(t, "SELECT * FROM test WHERE n = ?", sql)
+			assert.Equal(t, goldenValues[:1], values)
+		},
+	},
+	"request-finalize": {
+		func(m *Message) { EncodeFinalize(m, 1, 2) },
+		func(t *testing.T, m *Message) {
+			db, stmt := DecodeFinalizeRequest(m)
+			assert.Equal(t, uint32(1), db)
+			assert.Equal(t, uint32(2), stmt)
+		},
+	},
+	"request-add": {
+		func(m *Message) { EncodeAdd(m, 2, "127.0.0.1:9002") },
+		func(t *testing.T, m *Message) {
+			id, address := DecodeNodeRequest(m)
+			assert.Equal(t, uint64(2), id)
+			assert.Equal(t, "127.0.0.1:9002", address)
+		},
+	},
+	"request-assign": {
+		func(m *Message) { EncodeAssign(m, 2, uint64(StandBy)) },
+		func(t *testing.T, m *Message) {
+			id, role := DecodeAssignRequest(m)
+			assert.Equal(t, uint64(2), id)
+			assert.Equal(t, uint64(StandBy), role)
+		},
+	},
+	"request-dump": {
+		func(m *Message) { EncodeDump(m, "test.db") },
+		func(t *testing.T, m *Message) {
+			assert.Equal(t, "test.db", DecodeDumpRequest(m))
+		},
+	},
+	"request-cluster": {
+		func(m *Message) { EncodeCluster(m, ClusterFormatV1) },
+		func(t *testing.T, m *Message) {
+			assert.Equal(t, uint64(ClusterFormatV1), DecodeUint64Request(m))
+		},
+	},
+	"request-auth": {
+		func(m *Message) { EncodeAuth(m, "secret") },
+		func(t *testing.T, m *Message) {
+			token, err := DecodeAuthRequest(m)
+			require.NoError(t, err)
+			assert.Equal(t, "secret", token)
+		},
+	},
+	"request-read-only": {
+		func(m *Message) { EncodeReadOnly(m) },
+		func(t *testing.T, m *Message) {
+			assert.Equal(t, uint8(RequestReadOnly), m.Type())
+		},
+	},
+	"response-failure": {
+		func(m *Message) { EncodeFailure(m, 5, "database is locked") },
+		func(t *testing.T, m *Message) {
+			err := DecodeEmpty(m)
+			assert.Equal(t, ErrRequest{Code: 5, Description: "database is locked"}, err)
+		},
+	},
+	"response-welcome": {
+		func(m *Message) { EncodeWelcome(m, 15000) },
+		func(t *testing.T, m *Message) {
+			timeout, err := DecodeWelcome(m)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(15000), timeout)
+		},
+	},
+	"response-node": {
+		func(m *Message) { EncodeNode(m, 1, "127.0.0.1:9001") },
+		func(t *testing.T, m *Message) {
+			id, address, err := DecodeNode(m)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), id)
+			assert.Equal(t, "127.0.0.1:9001", address)
+		},
+	},
+	"response-nodes": {
+		func(m *Message) {
+			EncodeNodes(m, Nodes{
+				{ID: 1, Address: "127.0.0.1:9001", Role: Voter},
+				{ID: 2, Address: "127.0.0.1:9002", Role: StandBy},
+				{ID: 3, Address: "127.0.0.1:9003", Role: Spare},
+			})
+		},
+		func(t *testing.T, m *Message) {
+			nodes, err := DecodeNodes(m)
+			require.NoError(t, err)
+			assert.Equal(t, Nodes{
+				{ID: 1, Address: "127.0.0.1:9001", Role: Voter},
+				{ID: 2, Address: "127.0.0.1:9002", Role: StandBy},
+				{ID: 3, Address: "127.0.0.1:9003", Role: Spare},
+			}, nodes)
+		},
+	},
+	"response-db": {
+		func(m *Message) { EncodeDb(m, 7) },
+		func(t *testing.T, m *Message) {
+			id, err := DecodeDb(m)
+			require.NoError(t, err)
+			assert.Equal(t, uint32(7), id)
+		},
+	},
+	"response-stmt": {
+		func(m *Message) { EncodeStmt(m, 1, 2, 3) },
+		func(t *testing.T, m *Message) {
+			db, id, params, err := DecodeStmt(m)
+			require.NoError(t, err)
+			assert.Equal(t, uint32(1), db)
+			assert.Equal(t, uint32(2), id)
+			assert.Equal(t, uint64(3), params)
+		},
+	},
+	"response-empty": {
+		func(m *Message) { EncodeEmpty(m) },
+		func(t *testing.T, m *Message) {
+			assert.NoError(t, DecodeEmpty(m))
+		},
+	},
+	"response-result": {
+		func(m *Message) { EncodeResult(m, Result{LastInsertID: 10, RowsAffected: 2}) },
+		func(t *testing.T, m *Message) {
+			result, err := DecodeResult(m)
+			require.NoError(t, err)
+			assert.Equal(t, Result{LastInsertID: 10, RowsAffected: 2}, result)
+		},
+	},
+	"response-rows": {
+		func(m *Message) {
+			row := make([]driver.Value, len(goldenValues))
+			for i, value := range goldenValues {
+				row[i] = value.Value
+			}
+			columns := []string{"i", "f", "b", "blob", "s", "null", "t"}
+			if err := EncodeRows(m, columns, [][]driver.Value{row}); err != nil {
+				panic(err)
+			}
+		},
+		func(t *testing.T, m *Message) {
+			rows, err := DecodeRows(m)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"i", "f", "b", "blob", "s", "null", "t"}, rows.Columns)
+
+			dest := make([]driver.Value, len(rows.Columns))
+			require.NoError(t, rows.Next(dest))
+			values := make(NamedValues, len(dest))
+			for i, value := range dest {
+				values[i] = driver.NamedValue{Ordinal: i + 1, Value: value}
+			}
+			assertGoldenValues(t, values)
+
+			assert.Error(t, rows.Next(dest))
+		},
+	},
+	"response-files": {
+		func(m *Message) {
+			EncodeFiles(m, map[string][]byte{
+				"test.db":     {1, 2, 3},
+				"test.db-wal": {4, 5, 6, 7, 8, 9, 10, 11, 12},
+			}, []string{"test.db", "test.db-wal"})
+		},
+		func(t *testing.T, m *Message) {
+			files, err := DecodeFiles(m)
+			require.NoError(t, err)
+			name, data := files.Next()
+			assert.Equal(t, "test.db", name)
+			assert.Equal(t, []byte{1, 2, 3}, data)
+			name, data = files.Next()
+			assert.Equal(t, "test.db-wal", name)
+			assert.Equal(t, []byte{4, 5, 6, 7, 8, 9, 10, 11, 12}, data)
+			name, _ = files.Next()
+			assert.Equal(t, "", name)
+		},
+	},
+}
+
+// Check that all values match goldenValues, allowing for time zone
+// conversions of timestamps.
+func assertGoldenValues(t *testing.T, values NamedValues) {
+	t.Helper()
+
+	require.Len(t, values, len(goldenValues))
+	for i, value := range values {
+		if timestamp, ok := value.Value.(time.Time); ok {
+			assert.True(t, goldenTimestamp.Equal(timestamp), "timestamp %s", timestamp)
+			continue
+		}
+		assert.Equal(t, goldenValues[i], value)
+	}
+}
+
+// Encode the message produced by the given function as hex.
+func goldenEncode(encode func(m *Message)) string {
+	m := Message{}
+	m.Init(16)
+	encode(&m)
+	buf := &bytes.Buffer{}
+	if err := WriteMessage(buf, &m); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf.Bytes())
+}
+
+// Every encoder produces exactly the bytes recorded in the golden file, and
+// every decoder returns the expected values from them.
+func TestGolden(t *testing.T) {
+	if *update {
+		golden := map[string]string{}
+		for name, vector := range goldenVectors {
+			golden[name] = goldenEncode(vector.encode)
+		}
+		data, err := json.MarshalIndent(golden, "", "  ")
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(goldenFile, append(data, '\n'), 0644))
+	}
+
+	data, err := ioutil.ReadFile(goldenFile)
+	require.NoError(t, err)
+
+	golden := map[string]string{}
+	require.NoError(t, json.Unmarshal(data, &golden))
+
+	names := []string{}
+	for name := range goldenVectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Len(t, golden, len(names), "golden file out of date, run with -update")
+
+	for _, name := range names {
+		vector := goldenVectors[name]
+		t.Run(name, func(t *testing.T) {
+			expected, ok := golden[name]
+			require.True(t, ok, "missing golden vector, run with -update")
+
+			// Encoding.
+			assert.Equal(t, expected, goldenEncode(vector.encode))
+
+			// Decoding.
+			raw, err := hex.DecodeString(expected)
+			require.NoError(t, err)
+			m := Message{}
+			m.Init(16)
+			require.NoError(t, ReadMessage(bytes.NewReader(raw), &m))
+			vector.decode(t, &m)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"context"
+	"sync"
+)
+
+// LeaderTracker records the address of the current leader, as last found by
+// the connectors sharing it.
+//
+// Connectors try the tracked leader first, and when it's gone only one of
+// them walks the nodes in the store to find the new one, while the others
+// wait for it to finish and then connect to the leader it found.
+type LeaderTracker struct {
+	mu        sync.Mutex
+	address   string           // Address of the last known leader, if any.
+	discovery *leaderDiscovery // Ongoing discovery, if any.
+}
+
+// Discovery of the leader performed by a single connector.
+type leaderDiscovery struct {
+	done   chan struct{} // Closed when the discovery ends.
+	failed bool          // Whether no leader was found, set before done is closed.
+}
+
+// NewLeaderTracker creates a new tracker with no known leader.
+func NewLeaderTracker() *LeaderTracker {
+	return &LeaderTracker{}
+}
+
+// Leader returns the address of the last known leader, or an empty string if
+// it's not known.
+func (t *LeaderTracker) Leader() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.address
+}
+
+// Set records the address of the current leader.
+func (t *LeaderTracker) Set(address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.address = address
+}
+
+// Forget clears the given leader address, unless another one has been
+// recorded in the meantime.
+func (t *LeaderTracker) Forget(address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.address == address {
+		t.address = ""
+	}
+}
+
+// Return the address of the leader to connect to, as long as it's not the
+// given stale one. If no such leader is known, either wait for an ongoing
+// discovery to end, or start a new one, in which case the returned function
+// must be called with the address of the leader that was found, or with an
+// empty string if none was found.
+//
+// If an ongoing discovery fails, ErrNoAvailableLeader is returned.
+func (t *LeaderTracker) discover(ctx context.Context, stale string) (string, func(string), error) {
+	t.mu.Lock()
+	for {
+		if t.address != "" && t.address != stale {
+			address := t.address
+			t.mu.Unlock()
+			return address, nil, nil
+		}
+
+		discovery := t.discovery
+		if discovery == nil {
+			discovery = &leaderDiscovery{done: make(chan struct{})}
+			t.discovery = discovery
+			t.mu.Unlock()
+			return "", func(address string) { t.finish(discovery, address) }, nil
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-discovery.done:
+		case <-ctx.Done():
+			return "", nil, ctx.Err()
+		}
+		if discovery.failed {
+			return "", nil, ErrNoAvailableLeader
+		}
+
+		t.mu.Lock()
+	}
+}
+
+// End the given discovery, recording the leader it found, if any.
+func (t *LeaderTracker) finish(discovery *leaderDiscovery, address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if address != "" {
+		t.address = address
+	}
+	discovery.failed = address == ""
+	t.discovery = nil
+	close(discovery.done)
+}
@@ -0,0 +1,149 @@
+package protocol_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/logging"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Connectors sharing a leader tracker connect straight to the tracked leader.
+func TestLeaderTracker_Connect(t *testing.T) {
+	follower, leader := newFakeServer(t, 1), newFakeServer(t, 2)
+	follower.SetLeader(&protocol.NodeInfo{ID: leader.ID(), Address: leader.Address()})
+
+	tracker := protocol.NewLeaderTracker()
+	store := newFakeStore(t, follower, leader)
+
+	connectWithTracker(t, store, tracker)
+	assert.Equal(t, leader.Address(), tracker.Leader())
+	assert.Equal(t, 1, follower.Accepted())
+
+	for i := 0; i < 5; i++ {
+		connectWithTracker(t, store, tracker)
+	}
+	assert.Equal(t, 1, follower.Accepted())
+	assert.Equal(t, 6, leader.Accepted())
+}
+
+// After a failover only one of the connectors sharing a leader tracker walks
+// the store to find the new leader.
+func TestLeaderTracker_Failover(t *testing.T) {
+	follower, leader1, leader2 := newFakeServer(t, 1), newFakeServer(t, 2), newFakeServer(t, 3)
+	follower.SetLeader(&protocol.NodeInfo{ID: leader1.ID(), Address: leader1.Address()})
+	leader2.SetLeader(&protocol.NodeInfo{})
+
+	tracker := protocol.NewLeaderTracker()
+	store := newFakeStore(t, follower, leader2, leader1)
+
+	connectWithTracker(t, store, tracker)
+	assert.Equal(t, leader1.Address(), tracker.Leader())
+
+	leader1.Close()
+	follower.SetLeader(&protocol.NodeInfo{ID: leader2.ID(), Address: leader2.Address()})
+	leader2.SetLeader(nil)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			connectWithTracker(t, store, tracker)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, leader2.Address(), tracker.Leader())
+	assert.Equal(t, 2, follower.Accepted())
+	assert.Equal(t, 10, leader2.Accepted())
+}
+
+// A leader reported by the tracked one replaces it.
+func TestLeaderTracker_Redirect(t *testing.T) {
+	leader1, leader2 := newFakeServer(t, 1), newFakeServer(t, 2)
+
+	tracker := protocol.NewLeaderTracker()
+	store := newFakeStore(t, leader1, leader2)
+
+	connectWithTracker(t, store, tracker)
+	assert.Equal(t, leader1.Address(), tracker.Leader())
+
+	leader1.SetLeader(&protocol.NodeInfo{ID: leader2.ID(), Address: leader2.Address()})
+
+	connectWithTracker(t, store, tracker)
+	assert.Equal(t, leader2.Address(), tracker.Leader())
+	assert.Equal(t, 1, leader2.Accepted())
+}
+
+// Probing nodes concurrently finds the leader without waiting for the attempt
+// timeout of unresponsive nodes.
+func TestConnector_Probes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// Accept connections without ever replying.
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	follower, leader := newFakeServer(t, 2), newFakeServer(t, 3)
+	follower.SetLeader(&protocol.NodeInfo{ID: leader.ID(), Address: leader.Address()})
+
+	store := protocol.NewInmemNodeStore()
+	require.NoError(t, store.Set(context.Background(), []protocol.NodeInfo{
+		{ID: 1, Address: listener.Addr().String()},
+		{ID: follower.ID(), Address: follower.Address()},
+		{ID: leader.ID(), Address: leader.Address()},
+	}))
+
+	config := protocol.Config{AttemptTimeout: 5 * time.Second, RetryLimit: 1, Probes: 2}
+	connector := protocol.NewConnector(0, store, config, logging.Test(t))
+
+	start := time.Now()
+	p, err := connector.Connect(context.Background())
+	require.NoError(t, err)
+	defer p.Close()
+
+	assert.Equal(t, leader.Address(), p.RemoteAddr())
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func newFakeServer(t *testing.T, id uint64) *fakeserver.Server {
+	server, err := fakeserver.New(id)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func newFakeStore(t *testing.T, servers ...*fakeserver.Server) protocol.NodeStore {
+	nodes := make([]protocol.NodeInfo, len(servers))
+	for i, server := range servers {
+		nodes[i] = protocol.NodeInfo{ID: server.ID(), Address: server.Address()}
+	}
+	store := protocol.NewInmemNodeStore()
+	require.NoError(t, store.Set(context.Background(), nodes))
+	return store
+}
+
+func connectWithTracker(t *testing.T, store protocol.NodeStore, tracker *protocol.LeaderTracker) {
+	config := protocol.Config{RetryLimit: 3, LeaderTracker: tracker}
+	connector := protocol.NewConnector(0, store, config, logging.Test(t))
+	p, err := connector.Connect(context.Background())
+	if assert.NoError(t, err) {
+		p.Close()
+	}
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// ResultLimits holds the maximum number of rows and of bytes of a query
+// result. Zero means no limit.
+type ResultLimits struct {
+	Rows  int64
+	Bytes int64
+}
+
+// ResultLimitError is returned when a query result exceeds its limits.
+type ResultLimitError struct {
+	Rows  int64 // Row limit that was exceeded, if not zero.
+	Bytes int64 // Byte limit that was exceeded, if not zero.
+}
+
+func (e ResultLimitError) Error() string {
+	if e.Rows > 0 {
+		return fmt.Sprintf("query result exceeds %d rows", e.Rows)
+	}
+	return fmt.Sprintf("query result exceeds %d bytes", e.Bytes)
+}
+
+type resultLimitsKey struct{}
+
+// WithResultLimits returns a copy of the given context carrying the given
+// limits, which override the default ones.
+func WithResultLimits(ctx context.Context, limits ResultLimits) context.Context {
+	return context.WithValue(ctx, resultLimitsKey{}, limits)
+}
+
+// ResultLimitsFromContext returns the limits attached to the given context,
+// or the given default ones.
+func ResultLimitsFromContext(ctx context.Context, defaults ResultLimits) ResultLimits {
+	if limits, ok := ctx.Value(resultLimitsKey{}).(ResultLimits); ok {
+		return limits
+	}
+	return defaults
+}
+
+// ResultCounter tracks the size of a query result against its limits.
+type ResultCounter struct {
+	Limits ResultLimits
+	rows   int64
+	bytes  int64
+}
+
+// Add the given row to the result size, returning a ResultLimitError if a
+// limit is exceeded.
+func (c *ResultCounter) Add(row []driver.Value) error {
+	c.rows++
+	if c.Limits.Rows > 0 && c.rows > c.Limits.Rows {
+		return ResultLimitError{Rows: c.Limits.Rows}
+	}
+	if c.Limits.Bytes == 0 {
+		return nil
+	}
+	for _, value := range row {
+		c.bytes += valueSize(value)
+	}
+	if c.bytes > c.Limits.Bytes {
+		return ResultLimitError{Bytes: c.Limits.Bytes}
+	}
+	return nil
+}
+
+// Return the number of bytes taken by the given value in a result.
+func valueSize(value driver.Value) int64 {
+	switch value := value.(type) {
+	case string:
+		return int64(len(value))
+	case []byte:
+		return int64(len(value))
+	case int64, float64, time.Time:
+		return 8
+	case bool:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,38 @@
+package protocol_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultCounter_Rows(t *testing.T) {
+	counter := protocol.ResultCounter{Limits: protocol.ResultLimits{Rows: 2}}
+
+	row := []driver.Value{int64(1)}
+	assert.NoError(t, counter.Add(row))
+	assert.NoError(t, counter.Add(row))
+	assert.Equal(t, protocol.ResultLimitError{Rows: 2}, counter.Add(row))
+}
+
+func TestResultCounter_Bytes(t *testing.T) {
+	counter := protocol.ResultCounter{Limits: protocol.ResultLimits{Bytes: 10}}
+
+	assert.NoError(t, counter.Add([]driver.Value{"hello", nil}))
+	err := counter.Add([]driver.Value{[]byte("world!")})
+	assert.EqualError(t, err, "query result exceeds 10 bytes")
+}
+
+func TestResultLimitsFromContext(t *testing.T) {
+	defaults := protocol.ResultLimits{Rows: 10}
+	ctx := context.Background()
+
+	assert.Equal(t, defaults, protocol.ResultLimitsFromContext(ctx, defaults))
+
+	limits := protocol.ResultLimits{Bytes: 100}
+	ctx = protocol.WithResultLimits(ctx, limits)
+	assert.Equal(t, limits, protocol.ResultLimitsFromContext(ctx, defaults))
+}
@@ -27,6 +27,7 @@ type Message struct {
 	extra  uint16
 	header []byte // Statically allocated header buffer
 	body   buffer // Message body data.
+	max    int    // Maximum size of the body buffer, or zero for no limit.
 }
 
 // Init initializes the message using the given initial size for the data
@@ -41,6 +42,38 @@ func (m *Message) Init(initialBufferSize int) {
 	m.reset()
 }
 
+// BufferSize returns the given buffer size rounded up to a word boundary, as
+// required by Init, or a default size if it's not positive.
+func BufferSize(size int) int {
+	if size <= 0 {
+		return 4096
+	}
+	if size%messageWordSize != 0 {
+		size += messageWordSize - size%messageWordSize
+	}
+	return size
+}
+
+// SetMaxBufferSize limits the size that the data buffer can grow to. Sending
+// or receiving a larger message fails with ErrMessageTooLarge. Zero means no
+// limit.
+func (m *Message) SetMaxBufferSize(size int) {
+	m.max = size
+}
+
+// Return an error if the message body exceeds the maximum buffer size,
+// releasing the memory it uses.
+func (m *Message) checkSize(size int) error {
+	if m.max == 0 || size <= m.max {
+		return nil
+	}
+	if len(m.body.Bytes) > m.max {
+		m.body.Bytes = make([]byte, m.max-m.max%messageWordSize)
+		m.body.Offset = 0
+	}
+	return ErrMessageTooLarge
+}
+
 // Reset the state of the message so it can be used to encode or decode again.
 func (m *Message) reset() {
 	m.words = 0
@@ -258,9 +291,9 @@ func (m *Message) finalize() {
 }
 
 func (m *Message) bufferForPut(size int) *buffer {
-	for (m.body.Offset + size) > len(m.body.Bytes) {
+	if (m.body.Offset + size) > len(m.body.Bytes) {
 		// Grow message buffer.
-		bytes := make([]byte, len(m.body.Bytes)*2)
+		bytes := make([]byte, m.grownSize(m.body.Offset+size))
 		copy(bytes, m.body.Bytes)
 		m.body.Bytes = bytes
 	}
@@ -268,6 +301,23 @@ func (m *Message) bufferForPut(size int) *buffer {
 	return &m.body
 }
 
+// Return the size the body buffer should grow to in order to hold the given
+// number of bytes, doubling it as many times as needed, but without
+// exceeding the maximum buffer size unless that's not enough.
+func (m *Message) grownSize(size int) int {
+	n := len(m.body.Bytes)
+	if n == 0 {
+		n = messageWordSize
+	}
+	for n < size {
+		n *= 2
+	}
+	if m.max > 0 && n > m.max && size <= m.max {
+		n = m.max
+	}
+	return n
+}
+
 // Return the message type and its flags.
 func (m *Message) getHeader() (uint8, uint8) {
 	return m.mtype, m.flags
@@ -275,11 +325,11 @@ func (m *Message) getHeader() (uint8, uint8) {
 
 // Read a string from the message body.
 func (m *Message) getString() string {
-	b := m.bufferForGet()
+	b := m.bufferForGet(1)
 
-	index := bytes.IndexByte(b.Bytes[b.Offset:], 0)
+	index := bytes.IndexByte(b.Bytes[b.Offset:m.end()], 0)
 	if index == -1 {
-		panic("no string found")
+		panic(errMalformed{fmt.Errorf("no string found")})
 	}
 	s := string(b.Bytes[b.Offset : b.Offset+index])
 
@@ -295,27 +345,43 @@ func (m *Message) getString() string {
 	return s
 }
 
+// Read a byte slice from the message body.
 func (m *Message) getBlob() []byte {
-	size := m.getUint64()
-	data := make([]byte, size)
-	for i := range data {
-		data[i] = m.getUint8()
+	data := m.getBlobShared()
+	blob := make([]byte, len(data))
+	copy(blob, data)
+	return blob
+}
+
+// Read a byte slice from the message body, without copying it: the returned
+// slice is only valid until the message buffer is reused.
+func (m *Message) getBlobShared() []byte {
+	n := m.getUint64()
+	if n > uint64(m.remaining()) {
+		m.short()
 	}
-	pad := 0
+	size := int(n)
+
+	padded := size
 	if (size % messageWordSize) != 0 {
 		// Account for padding
-		pad = int(messageWordSize - (size % messageWordSize))
+		padded += messageWordSize - (size % messageWordSize)
 	}
-	// Consume padding
-	for i := 0; i < pad; i++ {
-		m.getUint8()
+
+	b := &m.body
+	if b.Offset+padded > m.end() {
+		m.short()
 	}
+
+	data := b.Bytes[b.Offset : b.Offset+size : b.Offset+size]
+	b.Advance(padded)
+
 	return data
 }
 
 // Read a byte from the message body.
 func (m *Message) getUint8() uint8 {
-	b := m.bufferForGet()
+	b := m.bufferForGet(1)
 	defer b.Advance(1)
 
 	return b.Bytes[b.Offset]
@@ -323,7 +389,7 @@ func (m *Message) getUint8() uint8 {
 
 // Read a 2-byte word from the message body.
 func (m *Message) getUint16() uint16 {
-	b := m.bufferForGet()
+	b := m.bufferForGet(2)
 	defer b.Advance(2)
 
 	return binary.LittleEndian.Uint16(b.Bytes[b.Offset:])
@@ -331,7 +397,7 @@ func (m *Message) getUint16() uint16 {
 
 // Read a 4-byte word from the message body.
 func (m *Message) getUint32() uint32 {
-	b := m.bufferForGet()
+	b := m.bufferForGet(4)
 	defer b.Advance(4)
 
 	return binary.LittleEndian.Uint32(b.Bytes[b.Offset:])
@@ -339,7 +405,7 @@ func (m *Message) getUint32() uint32 {
 
 // Read reads an 8-byte word from the message body.
 func (m *Message) getUint64() uint64 {
-	b := m.bufferForGet()
+	b := m.bufferForGet(8)
 	defer b.Advance(8)
 
 	return binary.LittleEndian.Uint64(b.Bytes[b.Offset:])
@@ -347,7 +413,7 @@ func (m *Message) getUint64() uint64 {
 
 // Read a signed 8-byte word from the message body.
 func (m *Message) getInt64() int64 {
-	b := m.bufferForGet()
+	b := m.bufferForGet(8)
 	defer b.Advance(8)
 
 	return int64(binary.LittleEndian.Uint64(b.Bytes[b.Offset:]))
@@ -355,7 +421,7 @@ func (m *Message) getInt64() int64 {
 
 // Read a floating point number from the message body.
 func (m *Message) getFloat64() float64 {
-	b := m.bufferForGet()
+	b := m.bufferForGet(8)
 	defer b.Advance(8)
 
 	return math.Float64frombits(binary.LittleEndian.Uint64(b.Bytes[b.Offset:]))
@@ -364,6 +430,12 @@ func (m *Message) getFloat64() float64 {
 // Decode a list of server objects from the message body.
 func (m *Message) getNodes() Nodes {
 	n := m.getUint64()
+
+	// Each node takes at least three words, reject bogus counts before
+	// allocating.
+	if n > uint64(m.remaining()/(3*messageWordSize)) {
+		m.short()
+	}
 	servers := make(Nodes, n)
 
 	for i := 0; i < int(n); i++ {
@@ -385,8 +457,12 @@ func (m *Message) getResult() Result {
 
 // Decode a query result set object from the message body.
 func (m *Message) getRows() Rows {
-	// Read the column count and column names.
-	columns := make([]string, m.getUint64())
+	// Read the column count and column names, each taking at least a word.
+	n := m.getUint64()
+	if n > uint64(m.remaining()/messageWordSize) {
+		m.short()
+	}
+	columns := make([]string, n)
 
 	for i := range columns {
 		columns[i] = m.getString()
@@ -404,6 +480,20 @@ func (m *Message) getFiles() Files {
 		n:       m.getUint64(),
 		message: m,
 	}
+
+	// Walk through the files once, so a malformed body is detected here
+	// rather than while iterating.
+	offset := m.body.Offset
+	for i := uint64(0); i < files.n; i++ {
+		m.getString()
+		length := m.getUint64()
+		if length > uint64(m.remaining()) {
+			m.short()
+		}
+		m.body.Advance(int(length))
+	}
+	m.body.Offset = offset
+
 	return files
 }
 
@@ -417,17 +507,35 @@ func (m *Message) lastByte() byte {
 	return m.body.Bytes[size-1]
 }
 
-func (m *Message) bufferForGet() *buffer {
-	size := int(m.words * messageWordSize)
-	// The static body has been exahusted, use the dynamic one.
-	if m.body.Offset == size {
-		err := fmt.Errorf("short message: type=%d words=%d off=%d", m.mtype, m.words, m.body.Offset)
-		panic(err)
+// Return the body buffer, checking that it holds at least the given number of
+// bytes that haven't been read yet.
+func (m *Message) bufferForGet(size int) *buffer {
+	if m.body.Offset+size > m.end() {
+		m.short()
 	}
 
 	return &m.body
 }
 
+// Return the size of the message body.
+func (m *Message) end() int {
+	return int(m.words) * messageWordSize
+}
+
+// Return the number of bytes of the message body that haven't been read yet.
+func (m *Message) remaining() int {
+	if n := m.end() - m.body.Offset; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// Abort decoding a message whose body is shorter than its content requires.
+func (m *Message) short() {
+	err := fmt.Errorf("short message: type=%d words=%d off=%d", m.mtype, m.words, m.body.Offset)
+	panic(errMalformed{err})
+}
+
 // Result holds the result of a statement.
 type Result struct {
 	LastInsertID uint64
@@ -470,7 +578,7 @@ func (r *Rows) columnTypes(save bool) ([]uint8, error) {
 		if slot == 0xee {
 			// More rows are available.
 			if save {
-				r.message.bufferForGet().Advance(-(i + 1))
+				r.message.body.Advance(-(i + 1))
 			}
 			return r.types, ErrRowsPart
 		}
@@ -478,7 +586,7 @@ func (r *Rows) columnTypes(save bool) ([]uint8, error) {
 		if slot == 0xff {
 			// Rows EOF marker
 			if save {
-				r.message.bufferForGet().Advance(-(i + 1))
+				r.message.body.Advance(-(i + 1))
 			}
 			return r.types, io.EOF
 		}
@@ -500,13 +608,24 @@ func (r *Rows) columnTypes(save bool) ([]uint8, error) {
 		r.types[index] = slot >> 4
 	}
 	if save {
-		r.message.bufferForGet().Advance(-headerSize)
+		r.message.body.Advance(-headerSize)
 	}
 	return r.types, nil
 }
 
 // Next returns the next row in the result set.
-func (r *Rows) Next(dest []driver.Value) error {
+//
+// As allowed by the database/sql/driver contract, blob values point into the
+// message buffer, so they are only valid until the next call to Next or
+// Close, and must be copied to be retained.
+func (r *Rows) Next(dest []driver.Value) (err error) {
+	defer recoverMalformed(&err)
+
+	// Rows without columns take no space, so there can't be any.
+	if len(r.Columns) == 0 {
+		return io.EOF
+	}
+
 	types, err := r.columnTypes(false)
 	if err != nil {
 		return err
@@ -519,7 +638,7 @@ func (r *Rows) Next(dest []driver.Value) error {
 		case Float:
 			dest[i] = r.message.getFloat64()
 		case Blob:
-			dest[i] = r.message.getBlob()
+			dest[i] = r.message.getBlobShared()
 		case Text:
 			dest[i] = r.message.getString()
 		case Null:
@@ -552,13 +671,22 @@ func (r *Rows) Next(dest []driver.Value) error {
 		case Boolean:
 			dest[i] = r.message.getInt64() != 0
 		default:
-			panic("unknown data type")
+			return fmt.Errorf("unknown data type: %d", types[i])
 		}
 	}
 
 	return nil
 }
 
+// Reuse makes the result set reuse the scratch buffers of the given one,
+// typically decoded from the previous response of the same result set, rather
+// than allocating new ones.
+func (r *Rows) Reuse(prev *Rows) {
+	if r.types == nil && len(prev.types) == len(r.Columns) {
+		r.types = prev.types
+	}
+}
+
 // Close the result set and reset the underlying message.
 func (r *Rows) Close() error {
 	// If we didn't go through all rows, let's look at the last byte.
@@ -625,9 +753,11 @@ var iso8601Formats = []string{
 }
 
 // ColumnTypes returns the column types for the the result set.
-func (r *Rows) ColumnTypes() ([]string, error) {
+func (r *Rows) ColumnTypes() (kinds []string, err error) {
+	defer recoverMalformed(&err)
+
 	types, err := r.columnTypes(true)
-	kinds := make([]string, len(types))
+	kinds = make([]string, len(types))
 
 	for i, t := range types {
 		switch t {
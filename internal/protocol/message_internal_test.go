@@ -1,6 +1,8 @@
 package protocol
 
 import (
+	"bytes"
+	"database/sql/driver"
 	"fmt"
 	"testing"
 	"time"
@@ -287,3 +289,82 @@ func TestMessage_getString_Overflow_WordBoundary(t *testing.T) {
 
 	assert.Equal(t, 32, message.body.Offset)
 }
+
+func TestMessage_MaxBufferSize(t *testing.T) {
+	message := Message{}
+	message.Init(16)
+	message.SetMaxBufferSize(100)
+
+	// The buffer grows by doubling, up to the maximum size.
+	message.putBlob(make([]byte, 40))
+	assert.Len(t, message.body.Bytes, 64)
+	message.putBlob(make([]byte, 16))
+	assert.Len(t, message.body.Bytes, 100)
+	assert.NoError(t, message.checkSize(message.body.Offset))
+
+	// Exceeding the maximum releases the buffer.
+	message.putBlob(make([]byte, 40))
+	assert.Equal(t, ErrMessageTooLarge, message.checkSize(message.body.Offset))
+	assert.Len(t, message.body.Bytes, 96)
+}
+
+func TestBufferSize(t *testing.T) {
+	assert.Equal(t, 4096, BufferSize(0))
+	assert.Equal(t, 1024, BufferSize(1024))
+	assert.Equal(t, 1032, BufferSize(1025))
+}
+
+// Blob values of rows point into the message buffer.
+func TestRows_NextSharesBlobs(t *testing.T) {
+	rows := newTestRows(t, 3)
+
+	dest := make([]driver.Value, 2)
+	require.NoError(t, rows.Next(dest))
+	assert.Equal(t, int64(0), dest[0])
+	assert.Equal(t, []byte("blob-0"), dest[1])
+
+	blob := dest[1].([]byte)
+	assert.Equal(t, &rows.message.body.Bytes[rows.message.body.Offset-8], &blob[0])
+
+	// Appending to a blob can't overwrite the following data.
+	_ = append(blob, 'x')
+	require.NoError(t, rows.Next(dest))
+	assert.Equal(t, int64(1), dest[0])
+	assert.Equal(t, []byte("blob-1"), dest[1])
+}
+
+func BenchmarkRows_Next(b *testing.B) {
+	dest := make([]driver.Value, 2)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		rows := newTestRows(b, 100)
+		b.StartTimer()
+		for rows.Next(dest) == nil {
+		}
+	}
+}
+
+// Return a result set with the given number of rows, each holding an integer
+// and a blob.
+func newTestRows(t testing.TB, n int) Rows {
+	values := make([][]driver.Value, n)
+	for i := range values {
+		values[i] = []driver.Value{int64(i), []byte(fmt.Sprintf("blob-%d", i))}
+	}
+
+	message := Message{}
+	message.Init(4096)
+	require.NoError(t, EncodeRows(&message, []string{"n", "b"}, values))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteMessage(buf, &message))
+	response := Message{}
+	response.Init(4096)
+	require.NoError(t, ReadMessage(buf, &response))
+
+	rows, err := DecodeRows(&response)
+	require.NoError(t, err)
+
+	return rows
+}
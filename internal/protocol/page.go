@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// ErrBadPageToken is returned when resuming a query from a page token that
+// is malformed or was issued for a different query.
+var ErrBadPageToken = fmt.Errorf("bad page token")
+
+// EncodePageToken returns an opaque token identifying the position of the
+// given query's result set after the given number of rows.
+func EncodePageToken(query string, offset uint64) string {
+	token := fmt.Sprintf("%x:%d", pageQueryDigest(query), offset)
+	return base64.RawURLEncoding.EncodeToString([]byte(token))
+}
+
+// DecodePageToken returns the number of rows of the given query's result set
+// that precede the position identified by the given token.
+func DecodePageToken(query string, token string) (uint64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, ErrBadPageToken
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 || parts[0] != fmt.Sprintf("%x", pageQueryDigest(query)) {
+		return 0, ErrBadPageToken
+	}
+	offset, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, ErrBadPageToken
+	}
+	return offset, nil
+}
+
+// PageQuery wraps the given query so that it returns at most limit rows,
+// skipping the given number of rows. A negative limit means no limit.
+func PageQuery(query string, offset uint64, limit int64) string {
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\n")
+	return fmt.Sprintf("SELECT * FROM (%s) LIMIT %d OFFSET %d", query, limit, offset)
+}
+
+func pageQueryDigest(query string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.TrimSpace(query)))
+	return h.Sum64()
+}
@@ -0,0 +1,33 @@
+package protocol_test
+
+import (
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageToken(t *testing.T) {
+	query := "SELECT n FROM t ORDER BY n"
+
+	token := protocol.EncodePageToken(query, 1000)
+	offset, err := protocol.DecodePageToken(query, token)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), offset)
+
+	_, err = protocol.DecodePageToken("SELECT n FROM u ORDER BY n", token)
+	assert.Equal(t, protocol.ErrBadPageToken, err)
+
+	_, err = protocol.DecodePageToken(query, "garbage")
+	assert.Equal(t, protocol.ErrBadPageToken, err)
+}
+
+func TestPageQuery(t *testing.T) {
+	assert.Equal(t,
+		"SELECT * FROM (SELECT n FROM t ORDER BY n) LIMIT 10 OFFSET 20",
+		protocol.PageQuery("SELECT n FROM t ORDER BY n;\n", 20, 10))
+	assert.Equal(t,
+		"SELECT * FROM (SELECT n FROM t) LIMIT -1 OFFSET 5",
+		protocol.PageQuery("SELECT n FROM t", 5, -1))
+}
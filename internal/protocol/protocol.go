@@ -8,23 +8,36 @@ import (
 	"sync"
 	"time"
 
+	"github.com/canonical/go-dqlite/internal/tracing"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Protocol sends and receive the dqlite message on the wire.
 type Protocol struct {
-	version uint64        // Protocol version
-	conn    net.Conn      // Underlying network connection.
-	closeCh chan struct{} // Stops the heartbeat when the connection gets closed
-	mu      sync.Mutex    // Serialize requests
-	netErr  error         // A network error occurred
+	version     uint64               // Protocol version
+	conn        net.Conn             // Underlying network connection.
+	closeCh     chan struct{}        // Stops the heartbeat when the connection gets closed
+	mu          sync.Mutex           // Serialize requests
+	netErr      error                // A network error occurred
+	observer    RequestObserver      // Notified about completed requests, if set
+	tracer      trace.Tracer         // Used to create a span for each request, if set
+	watcherOnce sync.Once            // Starts the cancellation watcher
+	watchCh     chan context.Context // Contexts of requests to watch
+	unwatchCh   chan struct{}        // Stops watching the current context
 }
 
+// RequestObserver is invoked after each request performed with Call, with a
+// description of the request type, the time it took and its error, if any.
+type RequestObserver func(request string, duration time.Duration, err error)
+
 func newProtocol(version uint64, conn net.Conn) *Protocol {
 	protocol := &Protocol{
-		version: version,
-		conn:    conn,
-		closeCh: make(chan struct{}),
+		version:   version,
+		conn:      conn,
+		closeCh:   make(chan struct{}),
+		watchCh:   make(chan context.Context),
+		unwatchCh: make(chan struct{}),
 	}
 
 	return protocol
@@ -39,44 +52,122 @@ func (p *Protocol) Call(ctx context.Context, request, response *Message) (err er
 	defer p.mu.Unlock()
 
 	if p.netErr != nil {
-		return p.netErr
+		return ErrBrokenConn
 	}
 
-	defer func() {
-		if err == nil {
-			return
-		}
-		switch errors.Cause(err).(type) {
-		case *net.OpError:
-			p.netErr = err
-		}
-	}()
+	// Don't break the connection for a request that can't be sent anyway.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	var budget time.Duration
-
-	// Honor the ctx deadline, if present.
 	if deadline, ok := ctx.Deadline(); ok {
-		p.conn.SetDeadline(deadline)
 		budget = time.Until(deadline)
-		defer p.conn.SetDeadline(time.Time{})
 	}
 
+	// Honor the ctx deadline and cancellation, if present.
+	defer p.watch(ctx)()
+
 	desc := requestDesc(request.mtype)
 
-	if err = p.send(request); err != nil {
+	if p.tracer != nil {
+		var span trace.Span
+		ctx, span = tracing.Start(ctx, p.tracer, "dqlite."+desc,
+			tracing.Request.String(desc),
+			tracing.PeerName.String(p.RemoteAddr()))
+		defer func() { tracing.End(span, err) }()
+	}
+
+	if p.observer != nil {
+		start := time.Now()
+		defer func() { p.observer(desc, time.Since(start), err) }()
+	}
+
+	// A request too large to be sent is rejected upfront.
+	if err = request.checkSize(request.body.Offset); err != nil {
+		return errors.Wrapf(err, "call %s", desc)
+	}
+
+	// Any failure leaves the stream in an unknown state, so the connection
+	// can't be used anymore. If sending fails the server can't have
+	// received a complete request, while if receiving fails it might have
+	// processed it.
+	if err = p.send(ctx, request); err != nil {
+		p.netErr = err
 		return errors.Wrapf(err, "call %s (budget %s): send", desc, budget)
 	}
 
-	if err = p.recv(response); err != nil {
-		return errors.Wrapf(err, "call %s (budget %s): receive", desc, budget)
+	if err = p.recv(ctx, response); err != nil {
+		p.netErr = err
+		return ErrUnknownOutcome{Err: errors.Wrapf(err, "call %s (budget %s): receive", desc, budget)}
 	}
 
 	return
 }
 
+// Broken returns true if a previous request failed because of a network
+// error, leaving the connection unusable.
+func (p *Protocol) Broken() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.netErr != nil
+}
+
+// Alive checks, without blocking, that the connection was not closed by the
+// server while idle, for example because the node was restarted. It returns
+// false if the connection is broken.
+func (p *Protocol) Alive() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.netErr != nil {
+		return false
+	}
+
+	// The server never sends unsolicited data, so a read should time out
+	// right away, unless the connection was closed.
+	p.conn.SetReadDeadline(time.Now())
+	defer p.conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1)
+	n, err := p.conn.Read(buf)
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return true
+	}
+	if n > 0 {
+		err = errUnexpectedData
+	}
+	p.netErr = err
+
+	return false
+}
+
+// SetObserver sets a function to be notified about completed requests.
+func (p *Protocol) SetObserver(observer RequestObserver) {
+	p.observer = observer
+}
+
+// SetTracer sets the tracer used to create a span for each request.
+func (p *Protocol) SetTracer(tracer trace.Tracer) {
+	p.tracer = tracer
+}
+
+// RemoteAddr returns the address of the node this protocol is connected to.
+func (p *Protocol) RemoteAddr() string {
+	return p.conn.RemoteAddr().String()
+}
+
 // More is used when a request maps to multiple responses.
 func (p *Protocol) More(ctx context.Context, response *Message) error {
-	return p.recv(response)
+	defer p.watch(ctx)()
+
+	if err := p.recv(ctx, response); err != nil {
+		p.mu.Lock()
+		p.netErr = err
+		p.mu.Unlock()
+		return err
+	}
+	return nil
 }
 
 // Interrupt sends an interrupt request and awaits for the server's empty
@@ -87,20 +178,17 @@ func (p *Protocol) Interrupt(ctx context.Context, request *Message, response *Me
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Honor the ctx deadline, if present.
-	if deadline, ok := ctx.Deadline(); ok {
-		p.conn.SetDeadline(deadline)
-		defer p.conn.SetDeadline(time.Time{})
-	}
+	// Honor the ctx deadline and cancellation, if present.
+	defer p.watch(ctx)()
 
 	EncodeInterrupt(request, 0)
 
-	if err := p.send(request); err != nil {
+	if err := p.send(ctx, request); err != nil {
 		return errors.Wrap(err, "failed to send interrupt request")
 	}
 
 	for {
-		if err := p.recv(response); err != nil {
+		if err := p.recv(ctx, response); err != nil {
 			return errors.Wrap(err, "failed to receive response")
 		}
 
@@ -120,19 +208,23 @@ func (p *Protocol) Close() error {
 	return p.conn.Close()
 }
 
-func (p *Protocol) send(req *Message) error {
-	if err := p.sendHeader(req); err != nil {
+func (p *Protocol) send(ctx context.Context, req *Message) error {
+	if err := p.sendHeader(ctx, req); err != nil {
 		return errors.Wrap(err, "header")
 	}
 
-	if err := p.sendBody(req); err != nil {
+	if err := p.sendBody(ctx, req); err != nil {
 		return errors.Wrap(err, "body")
 	}
 
 	return nil
 }
 
-func (p *Protocol) sendHeader(req *Message) error {
+func (p *Protocol) sendHeader(ctx context.Context, req *Message) error {
+	if err := p.deadline(ctx); err != nil {
+		return err
+	}
+
 	n, err := p.conn.Write(req.header[:])
 	if err != nil {
 		return err
@@ -145,7 +237,11 @@ func (p *Protocol) sendHeader(req *Message) error {
 	return nil
 }
 
-func (p *Protocol) sendBody(req *Message) error {
+func (p *Protocol) sendBody(ctx context.Context, req *Message) error {
+	if err := p.deadline(ctx); err != nil {
+		return err
+	}
+
 	buf := req.body.Bytes[:req.body.Offset]
 	n, err := p.conn.Write(buf)
 	if err != nil {
@@ -159,22 +255,22 @@ func (p *Protocol) sendBody(req *Message) error {
 	return nil
 }
 
-func (p *Protocol) recv(res *Message) error {
+func (p *Protocol) recv(ctx context.Context, res *Message) error {
 	res.reset()
 
-	if err := p.recvHeader(res); err != nil {
+	if err := p.recvHeader(ctx, res); err != nil {
 		return errors.Wrap(err, "header")
 	}
 
-	if err := p.recvBody(res); err != nil {
+	if err := p.recvBody(ctx, res); err != nil {
 		return errors.Wrap(err, "body")
 	}
 
 	return nil
 }
 
-func (p *Protocol) recvHeader(res *Message) error {
-	if err := p.recvPeek(res.header); err != nil {
+func (p *Protocol) recvHeader(ctx context.Context, res *Message) error {
+	if err := p.recvPeek(ctx, res.header); err != nil {
 		return err
 	}
 
@@ -186,18 +282,23 @@ func (p *Protocol) recvHeader(res *Message) error {
 	return nil
 }
 
-func (p *Protocol) recvBody(res *Message) error {
+func (p *Protocol) recvBody(ctx context.Context, res *Message) error {
 	n := int(res.words) * messageWordSize
 
-	for n > len(res.body.Bytes) {
+	// The body of a response too large is not read, so the connection
+	// can't be used anymore.
+	if err := res.checkSize(n); err != nil {
+		return err
+	}
+
+	if n > len(res.body.Bytes) {
 		// Grow message buffer.
-		bytes := make([]byte, len(res.body.Bytes)*2)
-		res.body.Bytes = bytes
+		res.body.Bytes = make([]byte, res.grownSize(n))
 	}
 
 	buf := res.body.Bytes[:n]
 
-	if err := p.recvPeek(buf); err != nil {
+	if err := p.recvPeek(ctx, buf); err != nil {
 		return err
 	}
 
@@ -205,9 +306,9 @@ func (p *Protocol) recvBody(res *Message) error {
 }
 
 // Read until buf is full.
-func (p *Protocol) recvPeek(buf []byte) error {
+func (p *Protocol) recvPeek(ctx context.Context, buf []byte) error {
 	for offset := 0; offset < len(buf); {
-		n, err := p.recvFill(buf[offset:])
+		n, err := p.recvFill(ctx, buf[offset:])
 		if err != nil {
 			return err
 		}
@@ -217,8 +318,83 @@ func (p *Protocol) recvPeek(buf []byte) error {
 	return nil
 }
 
+// Set the deadline of the network connection for the next read or write to
+// the deadline of the given context, or clear it if there's none, failing if
+// the context is done already.
+//
+// Setting it before each read and write, rather than once per request, makes
+// sure no read or write can block past the deadline, whatever the number of
+// round trips of the request.
+func (p *Protocol) deadline(ctx context.Context) error {
+	deadline, _ := ctx.Deadline()
+	p.conn.SetDeadline(deadline)
+	return ctx.Err()
+}
+
+// Interrupt any pending read or write as soon as the given context is done,
+// until the returned function is called.
+//
+// The context is handed to a single watcher goroutine per connection, rather
+// than starting one for each request. The deadline set by a cancellation is
+// overridden by the next call to deadline, which then notices that the
+// context is done, so it can't leak to later requests.
+func (p *Protocol) watch(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	p.watcherOnce.Do(func() { go p.watcher() })
+
+	select {
+	case p.watchCh <- ctx:
+	case <-p.closeCh:
+		return func() {}
+	}
+
+	return func() { p.unwatchCh <- struct{}{} }
+}
+
+// Wait for the contexts of requests passed to watch, and interrupt pending
+// reads and writes when the context of the current request is done, until
+// the connection is closed.
+func (p *Protocol) watcher() {
+	for {
+		var ctx context.Context
+		select {
+		case ctx = <-p.watchCh:
+		case <-p.closeCh:
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			p.conn.SetDeadline(time.Now())
+			<-p.unwatchCh
+		case <-p.unwatchCh:
+		}
+	}
+}
+
+// Reader of the network connection honoring the deadline and cancellation of
+// a context, like the reads of a request.
+type contextReader struct {
+	protocol *Protocol
+	ctx      context.Context
+}
+
+func (r contextReader) Read(buf []byte) (int, error) {
+	if err := r.protocol.deadline(r.ctx); err != nil {
+		return 0, err
+	}
+	return r.protocol.conn.Read(buf)
+}
+
 // Try to fill buf, but perform at most one read.
-func (p *Protocol) recvFill(buf []byte) (int, error) {
+func (p *Protocol) recvFill(ctx context.Context, buf []byte) (int, error) {
+	if err := p.deadline(ctx); err != nil {
+		return -1, err
+	}
+
 	// Read new data: try a limited number of times.
 	//
 	// This technique is copied from bufio.Reader.
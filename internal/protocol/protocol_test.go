@@ -2,6 +2,11 @@ package protocol_test
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
 	"testing"
 	"time"
 
@@ -141,6 +146,119 @@ func TestProtocol_Query(t *testing.T) {
 }
 */
 
+// A call to a peer that never replies returns as soon as its context is
+// canceled, even without a deadline.
+func TestProtocol_StalledCanceled(t *testing.T) {
+	p := newStalledProtocol(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	request, response := newMessagePair(512, 512)
+	protocol.EncodeLeader(&request)
+
+	done := make(chan error, 1)
+	go func() { done <- p.Call(ctx, &request, &response) }()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not return")
+	}
+
+	// The call was interrupted in the middle, so the connection can't be
+	// used anymore.
+	assert.True(t, p.Broken())
+
+	// A context canceled upfront doesn't break the connection.
+	p = newStalledProtocol(t)
+	assert.Equal(t, context.Canceled, p.Call(ctx, &request, &response))
+	assert.False(t, p.Broken())
+}
+
+// Reading more responses honors the context deadline.
+func TestProtocol_StalledMore(t *testing.T) {
+	p := newStalledProtocol(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, response := newMessagePair(512, 512)
+
+	done := make(chan error, 1)
+	go func() { done <- p.More(ctx, &response) }()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("more did not return")
+	}
+}
+
+// Reads of a streamed body made after the function failed don't block, even
+// if the peer stopped sending data.
+func TestProtocol_CallStreamReadAfterFailure(t *testing.T) {
+	p := newStalledStreamProtocol(t)
+
+	request, response := newMessagePair(512, 512)
+	protocol.EncodeDump(&request, "test")
+
+	failure := errors.New("boom")
+	var body io.Reader
+	err := p.CallStream(context.Background(), &request, &response, func(r io.Reader) error {
+		body = r
+		return failure
+	})
+	assert.Equal(t, failure, err)
+	assert.True(t, p.Broken())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(body, make([]byte, 16))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read did not return")
+	}
+}
+
+// Return a protocol connected to a peer that reads requests but never
+// replies.
+func newStalledProtocol(t *testing.T) *protocol.Protocol {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	p, err := protocol.Handshake(context.Background(), conn, protocol.VersionOne)
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+
+	return p
+}
+
 func newProtocol(t *testing.T) (*protocol.Protocol, func()) {
 	t.Helper()
 
@@ -187,3 +305,38 @@ func newMessagePair(size1, size2 int) (protocol.Message, protocol.Message) {
 
 	return message1, message2
 }
+
+// Return a protocol connected to a peer that replies to the first request with
+// the header of a 16 bytes body, followed by only 8 bytes of it, and then
+// stalls.
+func newStalledStreamProtocol(t *testing.T) *protocol.Protocol {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		data := make([]byte, 16)
+		binary.LittleEndian.PutUint32(data, 2) // Words of the body.
+		data[4] = protocol.ResponseFiles
+		conn.Write(data)
+
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	p, err := protocol.Handshake(context.Background(), conn, protocol.VersionOne)
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+
+	return p
+}
@@ -154,3 +154,27 @@ func EncodeTransfer(request *Message, id uint64) {
 
 	request.putHeader(RequestTransfer)
 }
+
+// EncodeAuth encodes a Auth request.
+func EncodeAuth(request *Message, token string) {
+	request.reset()
+	request.putString(token)
+
+	request.putHeader(RequestAuth)
+}
+
+// EncodeReadOnly encodes a ReadOnly request.
+func EncodeReadOnly(request *Message) {
+	request.reset()
+	request.putUint64(0)
+
+	request.putHeader(RequestReadOnly)
+}
+
+// EncodeState encodes a State request.
+func EncodeState(request *Message) {
+	request.reset()
+	request.putUint64(0)
+
+	request.putHeader(RequestState)
+}
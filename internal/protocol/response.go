@@ -9,6 +9,8 @@ import "fmt"
 
 // DecodeFailure decodes a Failure response.
 func DecodeFailure(response *Message) (code uint64, message string, err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -32,6 +34,8 @@ func DecodeFailure(response *Message) (code uint64, message string, err error) {
 
 // DecodeWelcome decodes a Welcome response.
 func DecodeWelcome(response *Message) (heartbeatTimeout uint64, err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -54,6 +58,8 @@ func DecodeWelcome(response *Message) (heartbeatTimeout uint64, err error) {
 
 // DecodeNodeLegacy decodes a NodeLegacy response.
 func DecodeNodeLegacy(response *Message) (address string, err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -76,6 +82,8 @@ func DecodeNodeLegacy(response *Message) (address string, err error) {
 
 // DecodeNode decodes a Node response.
 func DecodeNode(response *Message) (id uint64, address string, err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -99,6 +107,8 @@ func DecodeNode(response *Message) (id uint64, address string, err error) {
 
 // DecodeNodes decodes a Nodes response.
 func DecodeNodes(response *Message) (servers Nodes, err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -121,6 +131,8 @@ func DecodeNodes(response *Message) (servers Nodes, err error) {
 
 // DecodeDb decodes a Db response.
 func DecodeDb(response *Message) (id uint32, err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -144,6 +156,8 @@ func DecodeDb(response *Message) (id uint32, err error) {
 
 // DecodeStmt decodes a Stmt response.
 func DecodeStmt(response *Message) (db uint32, id uint32, params uint64, err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -168,6 +182,8 @@ func DecodeStmt(response *Message) (db uint32, id uint32, params uint64, err err
 
 // DecodeEmpty decodes a Empty response.
 func DecodeEmpty(response *Message) (err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -190,6 +206,8 @@ func DecodeEmpty(response *Message) (err error) {
 
 // DecodeResult decodes a Result response.
 func DecodeResult(response *Message) (result Result, err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -212,6 +230,8 @@ func DecodeResult(response *Message) (result Result, err error) {
 
 // DecodeRows decodes a Rows response.
 func DecodeRows(response *Message) (rows Rows, err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -234,6 +254,8 @@ func DecodeRows(response *Message) (rows Rows, err error) {
 
 // DecodeFiles decodes a Files response.
 func DecodeFiles(response *Message) (files Files, err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
@@ -253,3 +275,28 @@ func DecodeFiles(response *Message) (files Files, err error) {
 
 	return
 }
+
+// DecodeLastEntry decodes a LastEntry response.
+func DecodeLastEntry(response *Message) (index uint64, term uint64, err error) {
+	defer recoverMalformed(&err)
+
+	mtype, _ := response.getHeader()
+
+	if mtype == ResponseFailure {
+		e := ErrRequest{}
+		e.Code = response.getUint64()
+		e.Description = response.getString()
+                err = e
+                return
+	}
+
+	if mtype != ResponseLastEntry {
+		err = fmt.Errorf("decode %s: unexpected type %d", responseDesc(ResponseLastEntry), mtype)
+                return
+	}
+
+	index = response.getUint64()
+	term = response.getUint64()
+
+	return
+}
@@ -19,6 +19,9 @@ package protocol
 //go:generate ./schema.sh --request Dump      name:string
 //go:generate ./schema.sh --request Cluster   format:uint64
 //go:generate ./schema.sh --request Transfer   id:uint64
+//go:generate ./schema.sh --request Auth      token:string
+//go:generate ./schema.sh --request ReadOnly  unused:uint64
+//go:generate ./schema.sh --request State     unused:uint64
 
 //go:generate ./schema.sh --response init
 //go:generate ./schema.sh --response Failure  code:uint64 message:string
@@ -32,3 +35,4 @@ package protocol
 //go:generate ./schema.sh --response Result   result:Result
 //go:generate ./schema.sh --response Rows     rows:Rows
 //go:generate ./schema.sh --response Files    files:Files
+//go:generate ./schema.sh --response LastEntry index:uint64 term:uint64
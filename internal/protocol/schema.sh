@@ -103,6 +103,8 @@ if [ "$entity" = "--response" ]; then
 
 // Decode${cmd} decodes a $cmd response.
 func Decode${cmd}(response *Message) (${returns}err error) {
+	defer recoverMalformed(&err)
+
 	mtype, _ := response.getHeader()
 
 	if mtype == ResponseFailure {
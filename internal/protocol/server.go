@@ -0,0 +1,384 @@
+package protocol
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// This file contains the server side of the wire protocol, which is used to
+// implement test doubles of a dqlite node in pure Go.
+
+// Type returns the type code of the message.
+func (m *Message) Type() uint8 {
+	return m.mtype
+}
+
+// ReadMessage reads a full message from the given reader.
+func ReadMessage(r io.Reader, m *Message) error {
+	return ReadMessageLimit(r, m, 0)
+}
+
+// ReadMessageLimit reads a full message from the given reader, failing if
+// its body is larger than the given number of bytes, unless it's zero.
+func ReadMessageLimit(r io.Reader, m *Message, max int) error {
+	m.reset()
+
+	if _, err := io.ReadFull(r, m.header); err != nil {
+		return err
+	}
+
+	m.words = binary.LittleEndian.Uint32(m.header[0:])
+	m.mtype = m.header[4]
+	m.flags = m.header[5]
+	m.extra = binary.LittleEndian.Uint16(m.header[6:])
+
+	n := int(m.words) * messageWordSize
+	if max > 0 && n > max {
+		return fmt.Errorf("message too large: %d bytes", n)
+	}
+	for n > len(m.body.Bytes) {
+		m.body.Bytes = make([]byte, len(m.body.Bytes)*2)
+	}
+
+	if _, err := io.ReadFull(r, m.body.Bytes[:n]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WriteMessage writes a full message, previously encoded, to the given
+// writer.
+func WriteMessage(w io.Writer, m *Message) error {
+	if _, err := w.Write(m.header); err != nil {
+		return err
+	}
+	if _, err := w.Write(m.body.Bytes[:m.body.Offset]); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CopyMessage writes to the given writer a message read with ReadMessage,
+// unchanged.
+func CopyMessage(w io.Writer, m *Message) error {
+	if _, err := w.Write(m.header); err != nil {
+		return err
+	}
+	if _, err := w.Write(m.body.Bytes[:int(m.words)*messageWordSize]); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DecodeClientRequest decodes a Client request.
+func DecodeClientRequest(request *Message) (id uint64) {
+	return request.getUint64()
+}
+
+// DecodeOpenRequest decodes an Open request.
+func DecodeOpenRequest(request *Message) (name string, flags uint64, vfs string) {
+	name = request.getString()
+	flags = request.getUint64()
+	vfs = request.getString()
+	return
+}
+
+// DecodePrepareRequest decodes a Prepare request.
+func DecodePrepareRequest(request *Message) (db uint64, sql string) {
+	db = request.getUint64()
+	sql = request.getString()
+	return
+}
+
+// DecodeExecRequest decodes an Exec or Query request.
+func DecodeExecRequest(request *Message) (db uint32, stmt uint32, values NamedValues) {
+	db = request.getUint32()
+	stmt = request.getUint32()
+	values = request.getNamedValues()
+	return
+}
+
+// DecodeFinalizeRequest decodes a Finalize request.
+func DecodeFinalizeRequest(request *Message) (db uint32, stmt uint32) {
+	db = request.getUint32()
+	stmt = request.getUint32()
+	return
+}
+
+// DecodeExecSQLRequest decodes an ExecSQL or QuerySQL request.
+func DecodeExecSQLRequest(request *Message) (db uint64, sql string, values NamedValues) {
+	db = request.getUint64()
+	sql = request.getString()
+	values = request.getNamedValues()
+	return
+}
+
+// DecodeNodeRequest decodes an Add request.
+func DecodeNodeRequest(request *Message) (id uint64, address string) {
+	id = request.getUint64()
+	address = request.getString()
+	return
+}
+
+// DecodeUint64Request decodes a request whose body is a single 64-bit
+// integer, such as Leader, Heartbeat, Interrupt, Remove, Cluster and
+// Transfer.
+func DecodeUint64Request(request *Message) uint64 {
+	return request.getUint64()
+}
+
+// DecodeAssignRequest decodes an Assign request.
+func DecodeAssignRequest(request *Message) (id uint64, role uint64) {
+	id = request.getUint64()
+	role = request.getUint64()
+	return
+}
+
+// DecodeDumpRequest decodes a Dump request.
+func DecodeDumpRequest(request *Message) (name string) {
+	return request.getString()
+}
+
+// DecodeAuthRequest decodes an Auth request. Since auth requests come from
+// untrusted peers, malformed ones are reported as errors.
+func DecodeAuthRequest(request *Message) (token string, err error) {
+	body := request.body.Bytes[:int(request.words)*messageWordSize]
+	index := bytes.IndexByte(body, 0)
+	if index == -1 {
+		return "", fmt.Errorf("malformed auth request")
+	}
+	return string(body[:index]), nil
+}
+
+// EncodeFailure encodes a Failure response.
+func EncodeFailure(response *Message, code uint64, message string) {
+	response.reset()
+	response.putUint64(code)
+	response.putString(message)
+	response.putHeader(ResponseFailure)
+}
+
+// EncodeWelcome encodes a Welcome response.
+func EncodeWelcome(response *Message, heartbeatTimeout uint64) {
+	response.reset()
+	response.putUint64(heartbeatTimeout)
+	response.putHeader(ResponseWelcome)
+}
+
+// EncodeNode encodes a Node response.
+func EncodeNode(response *Message, id uint64, address string) {
+	response.reset()
+	response.putUint64(id)
+	response.putString(address)
+	response.putHeader(ResponseNode)
+}
+
+// EncodeNodes encodes a Nodes response, in the ClusterFormatV1 format.
+func EncodeNodes(response *Message, nodes Nodes) {
+	response.reset()
+	response.putUint64(uint64(len(nodes)))
+	for _, node := range nodes {
+		response.putUint64(node.ID)
+		response.putString(node.Address)
+		response.putUint64(uint64(node.Role))
+	}
+	response.putHeader(ResponseNodes)
+}
+
+// EncodeDb encodes a Db response.
+func EncodeDb(response *Message, id uint32) {
+	response.reset()
+	response.putUint32(id)
+	response.putUint32(0)
+	response.putHeader(ResponseDb)
+}
+
+// EncodeStmt encodes a Stmt response.
+func EncodeStmt(response *Message, db uint32, id uint32, params uint64) {
+	response.reset()
+	response.putUint32(db)
+	response.putUint32(id)
+	response.putUint64(params)
+	response.putHeader(ResponseStmt)
+}
+
+// EncodeEmpty encodes an Empty response.
+func EncodeEmpty(response *Message) {
+	response.reset()
+	response.putUint64(0)
+	response.putHeader(ResponseEmpty)
+}
+
+// EncodeResult encodes a Result response.
+func EncodeResult(response *Message, result Result) {
+	response.reset()
+	response.putUint64(result.LastInsertID)
+	response.putUint64(result.RowsAffected)
+	response.putHeader(ResponseResult)
+}
+
+// EncodeRows encodes a Rows response containing all the given rows.
+//
+// Supported value types are int64, float64, bool, []byte, string, time.Time
+// and nil.
+func EncodeRows(response *Message, columns []string, rows [][]driver.Value) error {
+	response.reset()
+	response.putUint64(uint64(len(columns)))
+	for _, column := range columns {
+		response.putString(column)
+	}
+
+	for _, row := range rows {
+		if len(row) != len(columns) {
+			return fmt.Errorf("row has %d values instead of %d", len(row), len(columns))
+		}
+
+		// Row header, with a 4-bit type slot per column, padded to a
+		// word boundary.
+		header := make([]byte, (len(row)+15)/16*messageWordSize)
+		for i, value := range row {
+			code, err := valueType(value)
+			if err != nil {
+				return err
+			}
+			header[i/2] |= code << (4 * uint(i%2))
+		}
+		for _, slot := range header {
+			response.putUint8(slot)
+		}
+
+		for _, value := range row {
+			switch v := value.(type) {
+			case int64:
+				response.putInt64(v)
+			case float64:
+				response.putFloat64(v)
+			case bool:
+				if v {
+					response.putInt64(1)
+				} else {
+					response.putInt64(0)
+				}
+			case []byte:
+				response.putBlob(v)
+			case string:
+				response.putString(v)
+			case time.Time:
+				response.putString(v.Format(iso8601Formats[0]))
+			case nil:
+				response.putInt64(0)
+			}
+		}
+	}
+
+	// End of rows marker.
+	for i := 0; i < messageWordSize; i++ {
+		response.putUint8(0xff)
+	}
+
+	response.putHeader(ResponseRows)
+
+	return nil
+}
+
+// EncodeFiles encodes a Files response.
+func EncodeFiles(response *Message, files map[string][]byte, order []string) {
+	response.reset()
+	response.putUint64(uint64(len(order)))
+	for _, name := range order {
+		data := files[name]
+		response.putString(name)
+		response.putUint64(uint64(len(data)))
+		for _, b := range data {
+			response.putUint8(b)
+		}
+	}
+	if trailing := response.body.Offset % messageWordSize; trailing != 0 {
+		for i := 0; i < messageWordSize-trailing; i++ {
+			response.putUint8(0)
+		}
+	}
+	response.putHeader(ResponseFiles)
+}
+
+// EncodeLastEntry encodes a LastEntry response.
+func EncodeLastEntry(response *Message, index uint64, term uint64) {
+	response.reset()
+	response.putUint64(index)
+	response.putUint64(term)
+	response.putHeader(ResponseLastEntry)
+}
+
+// Return the type code of the given value.
+func valueType(value driver.Value) (uint8, error) {
+	switch value.(type) {
+	case int64:
+		return Integer, nil
+	case float64:
+		return Float, nil
+	case bool:
+		return Boolean, nil
+	case []byte:
+		return Blob, nil
+	case string:
+		return Text, nil
+	case time.Time:
+		return ISO8601, nil
+	case nil:
+		return Null, nil
+	}
+	return 0, fmt.Errorf("unsupported value type %T", value)
+}
+
+// Decode statement parameters, as encoded by putNamedValues.
+func (m *Message) getNamedValues() NamedValues {
+	if m.hasBeenConsumed() {
+		return nil
+	}
+
+	n := int(m.getUint8())
+	types := make([]uint8, n)
+	for i := range types {
+		types[i] = m.getUint8()
+	}
+
+	b := &m.body
+	if trailing := b.Offset % messageWordSize; trailing != 0 {
+		b.Advance(messageWordSize - trailing)
+	}
+
+	values := make(NamedValues, n)
+	for i, t := range types {
+		values[i].Ordinal = i + 1
+		switch t {
+		case Integer:
+			values[i].Value = m.getInt64()
+		case Float:
+			values[i].Value = m.getFloat64()
+		case Boolean:
+			values[i].Value = m.getUint64() != 0
+		case Blob:
+			values[i].Value = m.getBlob()
+		case Text:
+			values[i].Value = m.getString()
+		case Null:
+			m.getUint64()
+			values[i].Value = nil
+		case ISO8601:
+			value := m.getString()
+			for _, format := range iso8601Formats {
+				if timestamp, err := time.Parse(format, value); err == nil {
+					values[i].Value = timestamp
+					break
+				}
+			}
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,138 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CallStream is like Call, but instead of reading the whole response body in
+// the given message, it passes a reader of the body to the given function,
+// so that large responses can be consumed with bounded memory.
+//
+// Failure responses are read in the given message as usual, and returned as
+// ErrRequest without calling the function. If the function fails, the rest of
+// the response is not read, so the connection can't be used anymore, and any
+// read of the body still pending or made afterwards, for example by a
+// goroutine started by the function, fails.
+func (p *Protocol) CallStream(ctx context.Context, request, response *Message, fn func(body io.Reader) error) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.netErr != nil {
+		return ErrBrokenConn
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Honor the ctx deadline and cancellation, if present.
+	defer p.watch(ctx)()
+
+	desc := requestDesc(request.mtype)
+
+	if p.observer != nil {
+		start := time.Now()
+		defer func() { p.observer(desc, time.Since(start), err) }()
+	}
+
+	if err = p.send(ctx, request); err != nil {
+		p.netErr = err
+		return errors.Wrapf(err, "call %s: send", desc)
+	}
+
+	response.reset()
+	if err = p.recvHeader(ctx, response); err != nil {
+		p.netErr = err
+		return ErrUnknownOutcome{Err: errors.Wrapf(err, "call %s: receive header", desc)}
+	}
+
+	if response.mtype == ResponseFailure {
+		if err = p.recvBody(ctx, response); err != nil {
+			p.netErr = err
+			return ErrUnknownOutcome{Err: errors.Wrapf(err, "call %s: receive body", desc)}
+		}
+		_, _, err = DecodeFailure(response)
+		return err
+	}
+
+	// The body is read with its own context, canceled if the function
+	// fails, so that later reads don't reset the deadline set below.
+	bodyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	body := &io.LimitedReader{R: contextReader{protocol: p, ctx: bodyCtx}, N: int64(response.words) * messageWordSize}
+	if err = fn(body); err != nil {
+		// Unblock any pending read of the body.
+		cancel()
+		p.conn.SetReadDeadline(time.Now())
+		p.netErr = err
+		return err
+	}
+
+	// Skip whatever the function didn't consume, such as padding.
+	if _, err = io.Copy(ioutil.Discard, body); err != nil {
+		p.netErr = err
+		return ErrUnknownOutcome{Err: errors.Wrapf(err, "call %s: receive body", desc)}
+	}
+
+	return nil
+}
+
+// StreamFiles decodes the body of a Files response from the given reader,
+// calling the given function for each file with a reader of its content. The
+// part of the content not consumed by the function is skipped.
+func StreamFiles(body io.Reader, fn func(name string, size uint64, data io.Reader) error) error {
+	word := make([]byte, messageWordSize)
+
+	readUint64 := func() (uint64, error) {
+		if _, err := io.ReadFull(body, word); err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint64(word), nil
+	}
+
+	n, err := readUint64()
+	if err != nil {
+		return errors.Wrap(err, "read number of files")
+	}
+
+	for i := uint64(0); i < n; i++ {
+		// The name is nul-terminated and padded to a word boundary.
+		name := []byte{}
+		for {
+			if _, err := io.ReadFull(body, word); err != nil {
+				return errors.Wrap(err, "read file name")
+			}
+			if end := bytes.IndexByte(word, 0); end >= 0 {
+				name = append(name, word[:end]...)
+				break
+			}
+			name = append(name, word...)
+		}
+
+		size, err := readUint64()
+		if err != nil {
+			return errors.Wrapf(err, "read size of %s", name)
+		}
+
+		data := &io.LimitedReader{R: body, N: int64(size)}
+		if err := fn(string(name), size, data); err != nil {
+			return err
+		}
+		if _, err := io.Copy(ioutil.Discard, data); err != nil {
+			return errors.Wrapf(err, "read content of %s", name)
+		}
+		if data.N > 0 {
+			return errors.Wrapf(io.ErrUnexpectedEOF, "read content of %s", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package raft
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// Severities of the problems found by Check.
+const (
+	SeverityError   = "error"   // The node won't start or will misbehave.
+	SeverityWarning = "warning" // The node can start, but attention is needed.
+)
+
+// Problem describes an issue found by Check.
+type Problem struct {
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"` // Base name of the offending file, if any.
+	Message  string `json:"message"`
+}
+
+// Report is the outcome of Check.
+type Report struct {
+	Dir        string    `json:"dir"`
+	Term       uint64    `json:"term"`        // Current term, from the metadata.
+	VotedFor   uint64    `json:"voted_for"`   // Vote in the current term, from the metadata.
+	FirstIndex uint64    `json:"first_index"` // Index of the first log entry, or 0 if none.
+	LastIndex  uint64    `json:"last_index"`  // Index of the last log entry or snapshot.
+	LastTerm   uint64    `json:"last_term"`   // Term of the last log entry or snapshot.
+	Segments   int       `json:"segments"`
+	Snapshots  int       `json:"snapshots"`
+	Size       uint64    `json:"size"`       // Total size of the files in the directory.
+	FreeSpace  uint64    `json:"free_space"` // Space available to the node on its filesystem.
+	Problems   []Problem `json:"problems"`
+}
+
+// OK returns true if no error was found.
+func (r *Report) OK() bool {
+	for _, problem := range r.Problems {
+		if problem.Severity == SeverityError {
+			return false
+		}
+	}
+	return true
+}
+
+// Add a problem to the report.
+func (r *Report) add(severity, file, format string, a ...interface{}) {
+	r.Problems = append(r.Problems, Problem{Severity: severity, File: file, Message: fmt.Sprintf(format, a...)})
+}
+
+// Check verifies the integrity of the raft data stored in the given
+// directory: the metadata files, the log segments and their continuity with
+// the snapshots, the snapshot metadata files and the free disk space.
+//
+// It should be run against the data directory of a stopped node. An error is
+// returned only if the directory can't be read at all, problems are listed in
+// the report.
+func Check(dir string) (*Report, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	report := &Report{Dir: dir, Problems: []Problem{}}
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			report.Size += uint64(file.Size())
+		}
+	}
+
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+	report.Snapshots = len(snapshots)
+	checkSnapshots(dir, snapshots, report)
+
+	segments, err := ListSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	report.Segments = len(segments)
+	checkSegments(dir, segments, snapshots, report)
+
+	metadata, err := ReadMetadata(dir)
+	if err != nil {
+		report.add(SeverityError, "", "read metadata: %v", err)
+	} else if metadata.Version == 0 {
+		if len(segments) > 0 || len(snapshots) > 0 {
+			report.add(SeverityError, "", "no metadata file, but the log is not empty")
+		}
+	} else {
+		report.Term = metadata.Term
+		report.VotedFor = metadata.VotedFor
+		if report.LastTerm > metadata.Term {
+			report.add(SeverityError, "", "log has entries at term %d, after the current term %d", report.LastTerm, metadata.Term)
+		}
+	}
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		report.add(SeverityWarning, "", "get free space: %v", err)
+	} else {
+		report.FreeSpace = stat.Bavail * uint64(stat.Bsize)
+		// Taking a snapshot can temporarily double the space used.
+		if report.FreeSpace < report.Size {
+			report.add(SeverityWarning, "", "free space (%d bytes) is less than the size of the directory (%d bytes)",
+				report.FreeSpace, report.Size)
+		}
+	}
+
+	return report, nil
+}
+
+// Check that each snapshot has a valid metadata file, and record the most
+// recent one as the end of the log.
+func checkSnapshots(dir string, snapshots []Snapshot, report *Report) {
+	for _, snapshot := range snapshots {
+		if _, err := ReadSnapshotMeta(filepath.Join(dir, snapshot.Meta())); err != nil {
+			if os.IsNotExist(err) {
+				err = fmt.Errorf("missing metadata file")
+			}
+			report.add(SeverityError, snapshot.Filename, "%v", err)
+		}
+	}
+
+	if len(snapshots) > 0 {
+		snapshot := snapshots[len(snapshots)-1]
+		report.LastIndex, report.LastTerm = snapshot.Index, snapshot.Term
+	}
+}
+
+// Check that the segments can be decoded and follow each other and the most
+// recent snapshot without gaps, with non-decreasing terms.
+func checkSegments(dir string, segments []Segment, snapshots []Snapshot, report *Report) {
+	after := uint64(0) // Index of the most recent snapshot.
+	if len(snapshots) > 0 {
+		after = snapshots[len(snapshots)-1].Index
+	}
+
+	next := uint64(0)
+	var previous *Segment
+	for i, segment := range segments {
+		entries, _, err := ReadSegment(filepath.Join(dir, segment.Filename))
+		if err != nil {
+			last := i == len(segments)-1
+			if segment.Open && last {
+				report.add(SeverityWarning, segment.Filename, "torn tail, the log will be truncated at start: %v", err)
+			} else {
+				report.add(SeverityError, segment.Filename, "%v", err)
+			}
+		}
+
+		if !segment.Open {
+			if n := uint64(len(entries)); err == nil && n != segment.LastIndex-segment.FirstIndex+1 {
+				report.add(SeverityError, segment.Filename, "found %d entries", n)
+			}
+			if previous != nil && segment.FirstIndex != previous.LastIndex+1 {
+				report.add(SeverityError, segment.Filename, "gap in the log after segment %s", previous.Filename)
+			}
+			if previous == nil && segment.FirstIndex > after+1 {
+				report.add(SeverityError, segment.Filename, "log starts at index %d, but the latest snapshot is at index %d",
+					segment.FirstIndex, after)
+			}
+			previous = &segments[i]
+			next = segment.FirstIndex
+		} else if next == 0 {
+			next = after + 1
+		}
+
+		for _, entry := range entries {
+			if report.FirstIndex == 0 {
+				report.FirstIndex = next
+			}
+			if entry.Term < report.LastTerm && next > after {
+				report.add(SeverityError, segment.Filename, "entry %d has term %d, lower than the previous term %d",
+					next, entry.Term, report.LastTerm)
+			}
+			if entry.Type == Change {
+				if _, err := DecodeConfiguration(entry.Data); err != nil {
+					report.add(SeverityError, segment.Filename, "entry %d: %v", next, err)
+				}
+			}
+			if next > report.LastIndex {
+				report.LastIndex, report.LastTerm = next, entry.Term
+			}
+			next++
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package raft
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	command := Entry{Term: 1, Type: Command, Data: make([]byte, 8)}
+	writeSegment(t, dir, "0000000000000001-0000000000000002", encodeBatch(command, command))
+	writeSegment(t, dir, "open-1", encodeBatch(Entry{Term: 2, Type: Command, Data: make([]byte, 8)}))
+	require.NoError(t, WriteMetadata(dir, Metadata{Version: 1, Term: 2, VotedFor: 1}))
+
+	report, err := Check(dir)
+	require.NoError(t, err)
+
+	assert.True(t, report.OK())
+	assert.Empty(t, report.Problems)
+	assert.Equal(t, uint64(2), report.Term)
+	assert.Equal(t, uint64(1), report.FirstIndex)
+	assert.Equal(t, uint64(3), report.LastIndex)
+	assert.Equal(t, uint64(2), report.LastTerm)
+	assert.Equal(t, 2, report.Segments)
+	assert.NotZero(t, report.Size)
+}
+
+func TestCheck_Problems(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	command := Entry{Term: 3, Type: Command, Data: make([]byte, 8)}
+	writeSegment(t, dir, "0000000000000001-0000000000000002", encodeBatch(command, command))
+	writeSegment(t, dir, "0000000000000004-0000000000000004", encodeBatch(Entry{Term: 2, Type: Command, Data: make([]byte, 8)}))
+	torn := encodeBatch(command)
+	writeSegment(t, dir, "open-1", torn[:len(torn)-3])
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "snapshot-1-1-100"), nil, 0600))
+	require.NoError(t, WriteMetadata(dir, Metadata{Version: 1, Term: 1}))
+
+	report, err := Check(dir)
+	require.NoError(t, err)
+
+	assert.False(t, report.OK())
+	assert.Equal(t, []Problem{
+		{Severity: SeverityError, File: "snapshot-1-1-100", Message: "missing metadata file"},
+		{Severity: SeverityError, File: "0000000000000004-0000000000000004", Message: "gap in the log after segment 0000000000000001-0000000000000002"},
+		{Severity: SeverityError, File: "0000000000000004-0000000000000004", Message: "entry 4 has term 2, lower than the previous term 3"},
+		{Severity: SeverityWarning, File: "open-1", Message: "torn tail, the log will be truncated at start: batch at offset 8: truncated data"},
+		{Severity: SeverityError, Message: "log has entries at term 2, after the current term 1"},
+	}, report.Problems)
+}
@@ -0,0 +1,88 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Role identifies the role of a server in a raft configuration.
+type Role uint8
+
+// Raft server roles, as encoded on disk.
+const (
+	StandBy Role = 0
+	Voter   Role = 1
+	Spare   Role = 2
+)
+
+// Server holds information about a single server in a raft configuration.
+type Server struct {
+	ID      uint64
+	Address string
+	Role    Role
+}
+
+// Format version of an encoded configuration.
+const configurationFormat = 1
+
+// DecodeConfiguration decodes the payload of a configuration change entry.
+func DecodeConfiguration(data []byte) (servers []Server, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed configuration")
+		}
+	}()
+
+	if len(data) < 1+wordSize {
+		return nil, fmt.Errorf("configuration too short (%d bytes)", len(data))
+	}
+	if format := data[0]; format != configurationFormat {
+		return nil, fmt.Errorf("unexpected configuration format %d", format)
+	}
+	n := binary.LittleEndian.Uint64(data[1:])
+	cursor := 1 + wordSize
+
+	if n > uint64(len(data)) {
+		return nil, fmt.Errorf("bad number of servers %d", n)
+	}
+
+	servers = make([]Server, n)
+	for i := range servers {
+		servers[i].ID = binary.LittleEndian.Uint64(data[cursor:])
+		cursor += wordSize
+		end := bytes.IndexByte(data[cursor:], 0)
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated address")
+		}
+		servers[i].Address = string(data[cursor : cursor+end])
+		cursor += end + 1
+		servers[i].Role = Role(data[cursor])
+		cursor++
+	}
+
+	return servers, nil
+}
+
+// EncodeConfiguration encodes the given servers using the same format used by
+// configuration change entries.
+func EncodeConfiguration(servers []Server) []byte {
+	size := 1 + wordSize
+	for _, server := range servers {
+		size += wordSize + len(server.Address) + 1 + 1
+	}
+	data := make([]byte, pad(size))
+	data[0] = configurationFormat
+	binary.LittleEndian.PutUint64(data[1:], uint64(len(servers)))
+	cursor := 1 + wordSize
+	for _, server := range servers {
+		binary.LittleEndian.PutUint64(data[cursor:], server.ID)
+		cursor += wordSize
+		cursor += copy(data[cursor:], server.Address)
+		data[cursor] = 0
+		cursor++
+		data[cursor] = byte(server.Role)
+		cursor++
+	}
+	return data
+}
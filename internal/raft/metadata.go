@@ -0,0 +1,167 @@
+package raft
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metadata holds the content of a raft metadata file.
+type Metadata struct {
+	Version  uint64
+	Term     uint64
+	VotedFor uint64
+}
+
+const metadataSize = 4 * wordSize
+
+// ReadMetadata returns the most recent raft metadata stored in the given
+// directory, picking the file with the highest version between the two
+// metadata files. If no metadata file exists, a zero value is returned.
+func ReadMetadata(dir string) (Metadata, error) {
+	metadata := Metadata{}
+	for _, name := range []string{"metadata1", "metadata2"} {
+		path := filepath.Join(dir, name)
+		if !fileExists(path) {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Metadata{}, err
+		}
+		if len(data) != metadataSize {
+			return Metadata{}, fmt.Errorf("%s: unexpected size %d", name, len(data))
+		}
+		if format := binary.LittleEndian.Uint64(data); format != diskFormat {
+			return Metadata{}, fmt.Errorf("%s: unexpected format version %d", name, format)
+		}
+		version := binary.LittleEndian.Uint64(data[8:])
+		if version <= metadata.Version {
+			continue
+		}
+		metadata.Version = version
+		metadata.Term = binary.LittleEndian.Uint64(data[16:])
+		metadata.VotedFor = binary.LittleEndian.Uint64(data[24:])
+	}
+	return metadata, nil
+}
+
+// WriteMetadata writes the given metadata to the first metadata file in the
+// given directory. The version must be higher than the one of the second
+// metadata file, if any, for the new metadata to take effect.
+func WriteMetadata(dir string, metadata Metadata) error {
+	data := make([]byte, metadataSize)
+	binary.LittleEndian.PutUint64(data, diskFormat)
+	binary.LittleEndian.PutUint64(data[8:], metadata.Version)
+	binary.LittleEndian.PutUint64(data[16:], metadata.Term)
+	binary.LittleEndian.PutUint64(data[24:], metadata.VotedFor)
+	return writeFileSync(filepath.Join(dir, "metadata1"), data)
+}
+
+// SnapshotMeta holds the content of a snapshot metadata file.
+type SnapshotMeta struct {
+	ConfigurationIndex uint64
+	Configuration      []Server
+}
+
+const snapshotMetaHeaderSize = 4 * wordSize
+
+// ReadSnapshotMeta decodes the snapshot metadata file at the given path,
+// verifying its checksum.
+func ReadSnapshotMeta(path string) (SnapshotMeta, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return SnapshotMeta{}, err
+	}
+	if len(data) < snapshotMetaHeaderSize {
+		return SnapshotMeta{}, fmt.Errorf("snapshot metadata too short (%d bytes)", len(data))
+	}
+	if format := binary.LittleEndian.Uint64(data); format != diskFormat {
+		return SnapshotMeta{}, fmt.Errorf("unexpected snapshot metadata format version %d", format)
+	}
+	crc := binary.LittleEndian.Uint64(data[8:])
+	index := binary.LittleEndian.Uint64(data[16:])
+	size := binary.LittleEndian.Uint64(data[24:])
+	if size != uint64(len(data)-snapshotMetaHeaderSize) {
+		return SnapshotMeta{}, fmt.Errorf("snapshot metadata has %d configuration bytes, expected %d",
+			len(data)-snapshotMetaHeaderSize, size)
+	}
+	configuration := data[snapshotMetaHeaderSize:]
+
+	checksum := crc32.ChecksumIEEE(data[16:snapshotMetaHeaderSize])
+	checksum = crc32.Update(checksum, crc32.IEEETable, configuration)
+	if uint64(checksum) != crc {
+		return SnapshotMeta{}, fmt.Errorf("snapshot metadata checksum mismatch")
+	}
+
+	servers, err := DecodeConfiguration(configuration)
+	if err != nil {
+		return SnapshotMeta{}, err
+	}
+
+	return SnapshotMeta{ConfigurationIndex: index, Configuration: servers}, nil
+}
+
+// Snapshot holds information about a single snapshot file.
+type Snapshot struct {
+	Filename  string // Base name of the snapshot data file.
+	Term      uint64
+	Index     uint64
+	Timestamp uint64
+}
+
+// Meta returns the name of the metadata file associated with the snapshot.
+func (s Snapshot) Meta() string {
+	return s.Filename + ".meta"
+}
+
+// ListSnapshots returns all snapshots in the given directory, ordered by
+// term and index.
+func ListSnapshots(dir string) ([]Snapshot, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	snapshots := []Snapshot{}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, "snapshot-") || strings.HasSuffix(name, ".meta") {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(name, "snapshot-"), "-")
+		if len(parts) != 3 {
+			continue
+		}
+		values := make([]uint64, 3)
+		for i, part := range parts {
+			values[i], err = strconv.ParseUint(part, 10, 64)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Filename:  name,
+			Term:      values[0],
+			Index:     values[1],
+			Timestamp: values[2],
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Term != snapshots[j].Term {
+			return snapshots[i].Term < snapshots[j].Term
+		}
+		return snapshots[i].Index < snapshots[j].Index
+	})
+
+	return snapshots, nil
+}
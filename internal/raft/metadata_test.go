@@ -0,0 +1,54 @@
+package raft
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteMetadata(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	metadata := Metadata{Version: 1, Term: 3, VotedFor: 2}
+	require.NoError(t, WriteMetadata(dir, metadata))
+
+	read, err := ReadMetadata(dir)
+	require.NoError(t, err)
+	assert.Equal(t, metadata, read)
+}
+
+func TestReadSnapshotMeta(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	servers := []Server{{ID: 1, Address: "1.2.3.4:666", Role: Voter}}
+	configuration := EncodeConfiguration(servers)
+
+	data := make([]byte, snapshotMetaHeaderSize+len(configuration))
+	binary.LittleEndian.PutUint64(data, diskFormat)
+	binary.LittleEndian.PutUint64(data[16:], 5)
+	binary.LittleEndian.PutUint64(data[24:], uint64(len(configuration)))
+	copy(data[snapshotMetaHeaderSize:], configuration)
+	crc := crc32.Update(crc32.ChecksumIEEE(data[16:32]), crc32.IEEETable, configuration)
+	binary.LittleEndian.PutUint64(data[8:], uint64(crc))
+
+	path := filepath.Join(dir, "snapshot-1-8-100.meta")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	meta, err := ReadSnapshotMeta(path)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), meta.ConfigurationIndex)
+	assert.Equal(t, servers, meta.Configuration)
+
+	data[len(data)-1] ^= 1
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	_, err = ReadSnapshotMeta(path)
+	assert.EqualError(t, err, "snapshot metadata checksum mismatch")
+}
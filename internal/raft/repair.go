@@ -0,0 +1,129 @@
+package raft
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RepairTornSegment checks if the last open segment with data in the given
+// directory ends with a partially written batch, which typically happens when
+// the process crashes in the middle of a write. Open segments pre-allocated by
+// raft and not written yet, which are all zeros, are skipped.
+//
+// Only an invalid batch followed by nothing but zeros, up to the end of the
+// segment, is considered torn. Any other damage, such as a checksum mismatch
+// followed by more data, can't be caused by an interrupted write and is
+// returned as an error, leaving the segment untouched.
+//
+// If the segment is torn, a copy of the original file is saved with a "torn-" prefix and the
+// invalid tail of the segment is zeroed, effectively truncating the log to the
+// last valid entry. The name of the backup file is returned, or an empty
+// string if no repair was needed.
+func RepairTornSegment(dir string) (string, error) {
+	segments, err := ListSegments(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// Open segments come last, find the last one that was written to.
+	var last Segment
+	var offset int64
+	for i := len(segments) - 1; i >= 0 && segments[i].Open; i-- {
+		_, offset, err = ReadSegment(filepath.Join(dir, segments[i].Filename))
+		if err == nil && offset == 0 {
+			continue
+		}
+		last = segments[i]
+		break
+	}
+	if last.Filename == "" || err == nil {
+		return "", nil
+	}
+	if offset == 0 {
+		// The segment header itself is not valid, there's nothing we
+		// can safely salvage.
+		return "", fmt.Errorf("segment %s: %w", last.Filename, err)
+	}
+
+	path := filepath.Join(dir, last.Filename)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	end := offset + batchExtent(data[offset:])
+	if !isZero(data[end:]) {
+		return "", fmt.Errorf("segment %s: invalid batch at offset %d is followed by data", last.Filename, offset)
+	}
+
+	backup := fmt.Sprintf("torn-%s-%d", last.Filename, time.Now().Unix())
+	if err := ioutil.WriteFile(filepath.Join(dir, backup), data, 0600); err != nil {
+		return "", fmt.Errorf("backup segment %s: %w", last.Filename, err)
+	}
+
+	for i := offset; i < int64(len(data)); i++ {
+		data[i] = 0
+	}
+
+	if err := writeFileSync(path, data); err != nil {
+		return "", fmt.Errorf("truncate segment %s: %w", last.Filename, err)
+	}
+
+	return backup, nil
+}
+
+// Return the number of bytes that the batch at the start of the given data
+// occupies according to its header, or all of the data if the header doesn't
+// fit in it. If the header itself is damaged, the entries data can't have
+// been written yet, so only the header counts.
+func batchExtent(data []byte) int64 {
+	if len(data) < 2*wordSize {
+		return int64(len(data))
+	}
+
+	n := binary.LittleEndian.Uint64(data[wordSize:])
+	if n > uint64(len(data))/16 {
+		return int64(len(data))
+	}
+	headerSize := wordSize + int(n)*16
+	if len(data) < wordSize+headerSize {
+		return int64(len(data))
+	}
+
+	header := data[wordSize : wordSize+headerSize]
+	if crc32.ChecksumIEEE(header) != binary.LittleEndian.Uint32(data) {
+		return int64(wordSize + headerSize)
+	}
+
+	size := wordSize + headerSize
+	for i := 0; i < int(n); i++ {
+		size += pad(int(binary.LittleEndian.Uint32(header[wordSize+i*16+12:])))
+	}
+	if size > len(data) {
+		size = len(data)
+	}
+
+	return int64(size)
+}
+
+// Write the given data to a file and flush it to disk.
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
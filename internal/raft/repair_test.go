@@ -0,0 +1,118 @@
+package raft
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairTornSegment(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	first := encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)})
+	second := encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)})
+	writeSegment(t, dir, "open-1", first, second[:len(second)-3])
+
+	backup, err := RepairTornSegment(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, "", backup)
+
+	entries, _, err := ReadSegment(filepath.Join(dir, "open-1"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, backup))
+	require.NoError(t, err)
+	assert.Len(t, data, 8+len(first)+len(second)-3)
+}
+
+func TestRepairTornSegment_Intact(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	writeSegment(t, dir, "open-1", encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)}))
+
+	backup, err := RepairTornSegment(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "", backup)
+}
+
+// Pre-allocated open segments following the torn one are skipped.
+func TestRepairTornSegment_Preallocated(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	first := encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)})
+	second := encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)})
+	writeSegment(t, dir, "open-1", first, second[:len(second)-3])
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "open-2"), make([]byte, 4096), 0600))
+
+	backup, err := RepairTornSegment(dir)
+	require.NoError(t, err)
+	assert.Contains(t, backup, "torn-open-1-")
+
+	entries, _, err := ReadSegment(filepath.Join(dir, "open-1"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// Nothing to repair after a restart.
+	backup, err = RepairTornSegment(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "", backup)
+}
+
+// A healthy directory with only a pre-allocated open segment needs no repair.
+func TestRepairTornSegment_OnlyPreallocated(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	writeSegment(t, dir, "0000000000000001-0000000000000001", encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)}))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "open-1"), make([]byte, 4096), 0600))
+
+	backup, err := RepairTornSegment(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "", backup)
+}
+
+// A torn batch followed by zeros, as in a pre-allocated segment, is repaired.
+func TestRepairTornSegment_Zeros(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	first := encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)})
+	second := encodeBatch(Entry{Term: 1, Type: Command, Data: []byte("12345678")})
+	writeSegment(t, dir, "open-1", first, second[:len(second)-4], make([]byte, 4096))
+
+	backup, err := RepairTornSegment(dir)
+	require.NoError(t, err)
+	assert.Contains(t, backup, "torn-open-1-")
+
+	entries, _, err := ReadSegment(filepath.Join(dir, "open-1"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// A damaged batch followed by valid ones is not a torn write, and is left
+// alone.
+func TestRepairTornSegment_Corrupted(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	first := encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)})
+	second := encodeBatch(Entry{Term: 1, Type: Command, Data: []byte("12345678")})
+	third := encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)})
+	second[len(second)-1] ^= 0xff
+	writeSegment(t, dir, "open-1", first, second, third)
+
+	backup, err := RepairTornSegment(dir)
+	assert.EqualError(t, err, "segment open-1: invalid batch at offset 48 is followed by data")
+	assert.Equal(t, "", backup)
+
+	entries, _, err := ReadSegment(filepath.Join(dir, "open-1"))
+	assert.EqualError(t, err, "batch at offset 48: data checksum mismatch")
+	assert.Len(t, entries, 1)
+}
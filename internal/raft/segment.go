@@ -0,0 +1,338 @@
+package raft
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EntryType identifies the type of a raft log entry.
+type EntryType uint8
+
+// Raft log entry types.
+const (
+	Command EntryType = 1
+	Barrier EntryType = 2
+	Change  EntryType = 3
+)
+
+// String implements the Stringer interface.
+func (t EntryType) String() string {
+	switch t {
+	case Command:
+		return "command"
+	case Barrier:
+		return "barrier"
+	case Change:
+		return "change"
+	default:
+		return "unknown"
+	}
+}
+
+// Entry holds information about a single entry in a raft log segment.
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Type  EntryType
+	Data  []byte
+}
+
+// Segment holds information about a single raft log segment file.
+type Segment struct {
+	Filename   string // Base name of the segment file.
+	Open       bool   // Whether this is an open segment.
+	FirstIndex uint64 // Index of the first entry, if known from the filename.
+	LastIndex  uint64 // Index of the last entry, if known from the filename.
+	Counter    uint64 // Counter of an open segment.
+}
+
+// Format version of segment, metadata and snapshot files.
+const diskFormat = 1
+
+const wordSize = 8
+
+// ListSegments returns all raft segments in the given directory, closed
+// segments first in index order followed by open segments in counter order.
+func ListSegments(dir string) ([]Segment, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	closed := []Segment{}
+	open := []Segment{}
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		segment, ok := parseSegmentFilename(file.Name())
+		if !ok {
+			continue
+		}
+		if segment.Open {
+			open = append(open, segment)
+		} else {
+			closed = append(closed, segment)
+		}
+	}
+
+	sort.Slice(closed, func(i, j int) bool { return closed[i].FirstIndex < closed[j].FirstIndex })
+	sort.Slice(open, func(i, j int) bool { return open[i].Counter < open[j].Counter })
+
+	return append(closed, open...), nil
+}
+
+// Parse a segment filename, either "open-<counter>" or "<first>-<last>".
+func parseSegmentFilename(name string) (Segment, bool) {
+	segment := Segment{Filename: name}
+	if strings.HasPrefix(name, "open-") {
+		counter, err := strconv.ParseUint(strings.TrimPrefix(name, "open-"), 10, 64)
+		if err != nil {
+			return segment, false
+		}
+		segment.Open = true
+		segment.Counter = counter
+		return segment, true
+	}
+	parts := strings.Split(name, "-")
+	if len(parts) != 2 || len(parts[0]) != 16 || len(parts[1]) != 16 {
+		return segment, false
+	}
+	first, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return segment, false
+	}
+	last, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return segment, false
+	}
+	segment.FirstIndex = first
+	segment.LastIndex = last
+	return segment, true
+}
+
+// ReadSegment reads all entries in the given segment file.
+//
+// The returned offset is the size of the valid prefix of the file, i.e. the
+// offset right after the last batch that could be fully decoded. If the file
+// contains a partially written or corrupted batch, the entries decoded so far
+// are returned along with a non-nil error.
+//
+// An all-zero file, such as an open segment pre-allocated by raft and not
+// written yet, has no entries and a zero offset.
+func ReadSegment(path string) (entries []Entry, offset int64, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if isZero(data) {
+		return []Entry{}, 0, nil
+	}
+
+	if len(data) < wordSize {
+		return nil, 0, fmt.Errorf("segment too short (%d bytes)", len(data))
+	}
+
+	if format := binary.LittleEndian.Uint64(data); format != diskFormat {
+		return nil, 0, fmt.Errorf("unexpected format version %d", format)
+	}
+
+	offset = wordSize
+	entries = []Entry{}
+	for offset < int64(len(data)) {
+		batch, n, err := decodeBatch(data[offset:])
+		if err != nil {
+			return entries, offset, fmt.Errorf("batch at offset %d: %w", offset, err)
+		}
+		if batch == nil {
+			// Zeroed trailing space, as pre-allocated by open
+			// segments.
+			break
+		}
+		entries = append(entries, batch...)
+		offset += int64(n)
+	}
+
+	return entries, offset, nil
+}
+
+// Decode a single batch of entries, returning the entries and the number of
+// bytes consumed. If the batch header is all zeros, nil is returned.
+func decodeBatch(data []byte) ([]Entry, int, error) {
+	// Checksums plus number of entries.
+	if len(data) < 2*wordSize {
+		if isZero(data) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("truncated preamble")
+	}
+
+	crc1 := binary.LittleEndian.Uint32(data[0:])
+	crc2 := binary.LittleEndian.Uint32(data[4:])
+	n := binary.LittleEndian.Uint64(data[8:])
+
+	if crc1 == 0 && crc2 == 0 && n == 0 {
+		return nil, 0, nil
+	}
+	if n == 0 {
+		return nil, 0, fmt.Errorf("empty batch")
+	}
+
+	// Each entry header is 16 bytes.
+	if n > uint64(len(data))/16 {
+		return nil, 0, fmt.Errorf("truncated header (%d entries)", n)
+	}
+	headerSize := wordSize + int(n)*16
+	if len(data) < wordSize+headerSize {
+		return nil, 0, fmt.Errorf("truncated header (%d entries)", n)
+	}
+	header := data[wordSize : wordSize+headerSize]
+	if crc32.ChecksumIEEE(header) != crc1 {
+		return nil, 0, fmt.Errorf("header checksum mismatch")
+	}
+
+	entries := make([]Entry, n)
+	dataSize := 0
+	for i := range entries {
+		h := header[wordSize+i*16:]
+		entries[i].Term = binary.LittleEndian.Uint64(h[0:])
+		entries[i].Type = EntryType(h[8])
+		size := int(binary.LittleEndian.Uint32(h[12:]))
+		entries[i].Data = make([]byte, size)
+		dataSize += pad(size)
+	}
+
+	start := wordSize + headerSize
+	if len(data) < start+dataSize {
+		return nil, 0, fmt.Errorf("truncated data")
+	}
+	body := data[start : start+dataSize]
+	if crc32.ChecksumIEEE(body) != crc2 {
+		return nil, 0, fmt.Errorf("data checksum mismatch")
+	}
+
+	cursor := 0
+	for i := range entries {
+		copy(entries[i].Data, body[cursor:])
+		cursor += pad(len(entries[i].Data))
+	}
+
+	return entries, start + dataSize, nil
+}
+
+// ReadLog reads all entries from all segments in the given directory,
+// assigning indexes to them.
+//
+// Open segments are assumed to contain entries that follow the ones in the
+// last closed segment.
+func ReadLog(dir string) ([]Entry, error) {
+	segments, err := ListSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	next := uint64(0)
+	for _, segment := range segments {
+		batch, _, err := ReadSegment(filepath.Join(dir, segment.Filename))
+		if err != nil {
+			return nil, fmt.Errorf("segment %s: %w", segment.Filename, err)
+		}
+		if !segment.Open {
+			next = segment.FirstIndex
+			if n := uint64(len(batch)); n != segment.LastIndex-segment.FirstIndex+1 {
+				return nil, fmt.Errorf("segment %s: found %d entries", segment.Filename, n)
+			}
+		} else if next == 0 {
+			next = firstIndexAfterSnapshot(dir)
+		}
+		for i := range batch {
+			batch[i].Index = next
+			next++
+		}
+		entries = append(entries, batch...)
+	}
+
+	return entries, nil
+}
+
+// LastEntry returns the index and term of the last entry in the raft log in
+// the given directory, or of the most recent snapshot if the log is empty.
+//
+// It can be called while the node is running: a batch being written to an
+// open segment is ignored, as if it wasn't appended yet.
+func LastEntry(dir string) (index uint64, term uint64, err error) {
+	segments, err := ListSegments(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if snapshots, err := ListSnapshots(dir); err == nil && len(snapshots) > 0 {
+		snapshot := snapshots[len(snapshots)-1]
+		index, term = snapshot.Index, snapshot.Term
+	}
+
+	// Only the last closed segment and the open ones need to be read.
+	next := uint64(0)
+	for i, segment := range segments {
+		if !segment.Open && i+1 < len(segments) && !segments[i+1].Open {
+			continue
+		}
+		batch, _, err := ReadSegment(filepath.Join(dir, segment.Filename))
+		if err != nil && !segment.Open {
+			return 0, 0, fmt.Errorf("segment %s: %w", segment.Filename, err)
+		}
+		if !segment.Open {
+			next = segment.FirstIndex
+		} else if next == 0 {
+			next = index + 1
+		}
+		for _, entry := range batch {
+			index, term = next, entry.Term
+			next++
+		}
+	}
+
+	return index, term, nil
+}
+
+// Return the index of the first entry that follows the most recent snapshot,
+// or 1 if there's no snapshot.
+func firstIndexAfterSnapshot(dir string) uint64 {
+	snapshots, err := ListSnapshots(dir)
+	if err != nil || len(snapshots) == 0 {
+		return 1
+	}
+	return snapshots[len(snapshots)-1].Index + 1
+}
+
+// Round the given size up to the next word boundary.
+func pad(size int) int {
+	if trailing := size % wordSize; trailing != 0 {
+		size += wordSize - trailing
+	}
+	return size
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Return true if the given file exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,159 @@
+package raft
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLog(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	servers := []Server{{ID: 1, Address: "1.2.3.4:666", Role: Voter}}
+	writeSegment(t, dir, "0000000000000001-0000000000000002",
+		encodeBatch(Entry{Term: 1, Type: Change, Data: EncodeConfiguration(servers)}),
+		encodeBatch(Entry{Term: 1, Type: Barrier, Data: make([]byte, 8)}),
+	)
+	writeSegment(t, dir, "open-1",
+		encodeBatch(Entry{Term: 2, Type: Command, Data: make([]byte, 16)}),
+		make([]byte, 64),
+	)
+
+	entries, err := ReadLog(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, uint64(1), entries[0].Index)
+	assert.Equal(t, Change, entries[0].Type)
+	assert.Equal(t, uint64(3), entries[2].Index)
+	assert.Equal(t, uint64(2), entries[2].Term)
+	assert.Len(t, entries[2].Data, 16)
+
+	decoded, err := DecodeConfiguration(entries[0].Data)
+	require.NoError(t, err)
+	assert.Equal(t, servers, decoded)
+}
+
+// Open segments pre-allocated by raft are all zeros, including the format
+// version, until the first batch is written.
+func TestReadLog_PreallocatedOpenSegment(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	command := Entry{Term: 1, Type: Command, Data: make([]byte, 8)}
+	writeSegment(t, dir, "0000000000000001-0000000000000002", encodeBatch(command, command))
+	writeSegment(t, dir, "open-1", encodeBatch(command))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "open-2"), make([]byte, 4096), 0600))
+
+	entries, offset, err := ReadSegment(filepath.Join(dir, "open-2"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+	assert.Equal(t, int64(0), offset)
+
+	entries, err = ReadLog(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, uint64(3), entries[2].Index)
+
+	index, _, err := LastEntry(dir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), index)
+}
+
+func TestReadSegment_Torn(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	first := encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)})
+	second := encodeBatch(Entry{Term: 1, Type: Command, Data: make([]byte, 8)})
+	writeSegment(t, dir, "open-1", first, second[:len(second)-3])
+
+	entries, offset, err := ReadSegment(filepath.Join(dir, "open-1"))
+	assert.EqualError(t, err, "batch at offset 48: truncated data")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, int64(8+len(first)), offset)
+}
+
+func TestLastEntry(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	command := Entry{Term: 1, Type: Command, Data: make([]byte, 8)}
+	writeSegment(t, dir, "0000000000000001-0000000000000002", encodeBatch(command, command))
+	writeSegment(t, dir, "0000000000000003-0000000000000003", encodeBatch(command))
+
+	index, term, err := LastEntry(dir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), index)
+	assert.Equal(t, uint64(1), term)
+
+	// A batch being written to an open segment is ignored.
+	torn := encodeBatch(Entry{Term: 2, Type: Command, Data: make([]byte, 8)})
+	writeSegment(t, dir, "open-1", encodeBatch(Entry{Term: 2, Type: Command, Data: make([]byte, 8)}), torn[:len(torn)-3])
+
+	index, term, err = LastEntry(dir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), index)
+	assert.Equal(t, uint64(2), term)
+}
+
+func TestLastEntry_Snapshot(t *testing.T) {
+	dir, cleanup := newDir(t)
+	defer cleanup()
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "snapshot-3-1024-123456"), nil, 0600))
+	writeSegment(t, dir, "open-1", make([]byte, 64))
+
+	index, term, err := LastEntry(dir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1024), index)
+	assert.Equal(t, uint64(3), term)
+}
+
+// Encode a batch of entries using the raft on-disk format.
+func encodeBatch(entries ...Entry) []byte {
+	header := make([]byte, wordSize+16*len(entries))
+	binary.LittleEndian.PutUint64(header, uint64(len(entries)))
+	data := []byte{}
+	for i, entry := range entries {
+		h := header[wordSize+i*16:]
+		binary.LittleEndian.PutUint64(h, entry.Term)
+		h[8] = byte(entry.Type)
+		binary.LittleEndian.PutUint32(h[12:], uint32(len(entry.Data)))
+		data = append(data, entry.Data...)
+		data = append(data, make([]byte, pad(len(entry.Data))-len(entry.Data))...)
+	}
+	checksums := make([]byte, wordSize)
+	binary.LittleEndian.PutUint32(checksums, crc32.ChecksumIEEE(header))
+	binary.LittleEndian.PutUint32(checksums[4:], crc32.ChecksumIEEE(data))
+	return append(append(checksums, header...), data...)
+}
+
+func writeSegment(t *testing.T, dir, name string, batches ...[]byte) {
+	data := make([]byte, wordSize)
+	binary.LittleEndian.PutUint64(data, diskFormat)
+	for _, batch := range batches {
+		data = append(data, batch...)
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0600))
+}
+
+func newDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "dqlite-raft-test-")
+	assert.NoError(t, err)
+
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	return dir, cleanup
+}
@@ -0,0 +1,178 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SplitStatements splits the given SQL text into individual statements,
+// honoring quotes, comments and trigger bodies. Dot-commands are returned as
+// separate statements when they appear at the beginning of a line. Empty
+// statements are dropped and the trailing semicolon is stripped.
+func SplitStatements(text string) []string {
+	statements := []string{}
+	current := &strings.Builder{}
+
+	flush := func() {
+		statement := strings.TrimSpace(current.String())
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		// Dot-commands span a single line.
+		if c == '.' && strings.TrimSpace(current.String()) == "" {
+			end := strings.IndexByte(text[i:], '\n')
+			if end == -1 {
+				end = len(text) - i
+			}
+			current.WriteString(text[i : i+end])
+			flush()
+			i += end
+			continue
+		}
+
+		switch c {
+		case '\'', '"', '`', '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(text[i+1:], closing)
+			if end == -1 {
+				end = len(text) - i - 1
+			} else {
+				end++
+			}
+			current.WriteString(text[i : i+end+1])
+			i += end
+			continue
+		case '-':
+			if i+1 < len(text) && text[i+1] == '-' {
+				end := strings.IndexByte(text[i:], '\n')
+				if end == -1 {
+					end = len(text) - i
+				}
+				i += end - 1
+				continue
+			}
+		case '/':
+			if i+1 < len(text) && text[i+1] == '*' {
+				end := strings.Index(text[i+2:], "*/")
+				if end == -1 {
+					end = len(text) - i
+				} else {
+					end += 4
+				}
+				i += end - 1
+				continue
+			}
+		case ';':
+			if isTriggerIncomplete(current.String()) {
+				break
+			}
+			flush()
+			continue
+		}
+
+		current.WriteByte(c)
+	}
+	flush()
+
+	return statements
+}
+
+// Return true if the given statement is a CREATE TRIGGER statement whose body
+// has not been terminated yet, that is, if it doesn't end with an END keyword
+// that isn't closing a CASE expression.
+func isTriggerIncomplete(statement string) bool {
+	words := sqlWords(statement)
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	isTrigger := words[1] == "TRIGGER" ||
+		(len(words) > 2 && (words[1] == "TEMP" || words[1] == "TEMPORARY") && words[2] == "TRIGGER")
+	if !isTrigger {
+		return false
+	}
+	cases := 0
+	ends := 0
+	for _, word := range words {
+		switch word {
+		case "CASE":
+			cases++
+		case "END":
+			ends++
+		}
+	}
+	return words[len(words)-1] != "END" || ends <= cases
+}
+
+// Return the upper-cased words of the given SQL text, skipping quoted
+// strings and identifiers.
+func sqlWords(text string) []string {
+	words := []string{}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(text[i+1:], closing)
+			if end == -1 {
+				return words
+			}
+			i += end + 1
+		case isWordChar(c):
+			start := i
+			for i+1 < len(text) && isWordChar(text[i+1]) {
+				i++
+			}
+			words = append(words, strings.ToUpper(text[start:i+1]))
+		}
+	}
+	return words
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Begin starts a transaction which will be used by all subsequent statements
+// until Commit or Rollback is called.
+func (s *Shell) Begin(ctx context.Context) error {
+	if s.tx != nil {
+		return fmt.Errorf("transaction already in progress")
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	s.tx = tx
+	return nil
+}
+
+// Commit the transaction started with Begin.
+func (s *Shell) Commit() error {
+	if s.tx == nil {
+		return fmt.Errorf("no transaction in progress")
+	}
+	defer func() { s.tx = nil }()
+	return s.tx.Commit()
+}
+
+// Rollback the transaction started with Begin.
+func (s *Shell) Rollback() error {
+	if s.tx == nil {
+		return fmt.Errorf("no transaction in progress")
+	}
+	defer func() { s.tx = nil }()
+	return s.tx.Rollback()
+}
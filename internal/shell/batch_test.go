@@ -0,0 +1,64 @@
+package shell_test
+
+import (
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		title      string
+		text       string
+		statements []string
+	}{
+		{
+			"simple",
+			"CREATE TABLE t (n INT); INSERT INTO t VALUES(1);\nSELECT * FROM t",
+			[]string{"CREATE TABLE t (n INT)", "INSERT INTO t VALUES(1)", "SELECT * FROM t"},
+		},
+		{
+			"quotes",
+			`INSERT INTO t VALUES('a;b', "c;d"); SELECT [x;y] FROM t`,
+			[]string{`INSERT INTO t VALUES('a;b', "c;d")`, `SELECT [x;y] FROM t`},
+		},
+		{
+			"comments",
+			"-- leading; comment\nSELECT 1; /* block; */ SELECT 2;",
+			[]string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			"trigger",
+			"CREATE TRIGGER tr AFTER INSERT ON t BEGIN DELETE FROM u; INSERT INTO u VALUES(1); END; SELECT 1",
+			[]string{
+				"CREATE TRIGGER tr AFTER INSERT ON t BEGIN DELETE FROM u; INSERT INTO u VALUES(1); END",
+				"SELECT 1",
+			},
+		},
+		{
+			"trigger with case",
+			"CREATE TRIGGER tr AFTER INSERT ON t BEGIN UPDATE u SET n = CASE WHEN new.n > 0 THEN 1 ELSE 0 END; INSERT INTO u VALUES('end'); END; SELECT 1",
+			[]string{
+				"CREATE TRIGGER tr AFTER INSERT ON t BEGIN UPDATE u SET n = CASE WHEN new.n > 0 THEN 1 ELSE 0 END; INSERT INTO u VALUES('end'); END",
+				"SELECT 1",
+			},
+		},
+		{
+			"dot-commands",
+			".tables\nSELECT 1;\n.schema t\n",
+			[]string{".tables", "SELECT 1", ".schema t"},
+		},
+		{
+			"empty",
+			" ;; \n",
+			[]string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			assert.Equal(t, c.statements, shell.SplitStatements(c.text))
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package shell
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SQL keywords offered for tab completion.
+var keywords = []string{
+	"ABORT", "ADD", "ALL", "ALTER", "ANALYZE", "AND", "AS", "ASC", "ATTACH",
+	"AUTOINCREMENT", "BEGIN", "BETWEEN", "BY", "CASCADE", "CASE", "CHECK",
+	"COLLATE", "COLUMN", "COMMIT", "CONFLICT", "CONSTRAINT", "CREATE", "CROSS",
+	"DEFAULT", "DELETE", "DESC", "DISTINCT", "DROP", "ELSE", "END", "ESCAPE",
+	"EXCEPT", "EXISTS", "EXPLAIN", "FOREIGN", "FROM", "GLOB", "GROUP",
+	"HAVING", "IF", "IGNORE", "IN", "INDEX", "INNER", "INSERT", "INTEGER",
+	"INTERSECT", "INTO", "IS", "JOIN", "KEY", "LEFT", "LIKE", "LIMIT", "NOT",
+	"NULL", "OFFSET", "ON", "OR", "ORDER", "OUTER", "PRAGMA", "PRIMARY",
+	"QUERY", "REFERENCES", "REINDEX", "RELEASE", "RENAME", "REPLACE",
+	"ROLLBACK", "ROWID", "SAVEPOINT", "SELECT", "SET", "TABLE", "TEXT", "THEN",
+	"TRANSACTION", "TRIGGER", "UNION", "UNIQUE", "UPDATE", "USING", "VACUUM",
+	"VALUES", "VIEW", "WHEN", "WHERE", "WITH", "WITHOUT",
+}
+
+// Meta-commands offered for tab completion.
+var metaCommands = []string{
+	".cluster", ".databases", ".dump", ".indexes", ".leader", ".mode",
+	".schema", ".stats", ".tables",
+}
+
+// Complete returns the possible completions of the given input line, each
+// being the full line with the last word completed. Candidates include SQL
+// keywords, table names and meta-commands.
+func (s *Shell) Complete(line string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	tables := []string{}
+	if rows, err := s.query(ctx, "SELECT name FROM sqlite_master WHERE type IN ('table', 'view')"); err == nil {
+		for _, row := range rows {
+			if name, ok := row[0].(string); ok {
+				tables = append(tables, name)
+			}
+		}
+	}
+
+	return complete(line, tables)
+}
+
+func complete(line string, tables []string) []string {
+	start := strings.LastIndexAny(line, " \t(,=") + 1
+	head, word := line[:start], line[start:]
+
+	var candidates []string
+	switch {
+	case strings.HasPrefix(line, ".") && start == 0:
+		candidates = metaCommands
+	case strings.HasPrefix(line, "."):
+		candidates = append(candidates, tables...)
+		candidates = append(candidates, formatList, formatTable, formatCSV, formatTSV, formatJSON)
+	default:
+		lower := word != "" && strings.ToLower(word) == word
+		for _, keyword := range keywords {
+			if lower {
+				keyword = strings.ToLower(keyword)
+			}
+			candidates = append(candidates, keyword)
+		}
+		candidates = append(candidates, tables...)
+	}
+
+	completions := []string{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(word)) {
+			completions = append(completions, head+candidate)
+		}
+	}
+	sort.Strings(completions)
+
+	return completions
+}
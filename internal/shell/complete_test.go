@@ -0,0 +1,30 @@
+package shell
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComplete(t *testing.T) {
+	tables := []string{"users", "uploads"}
+
+	cases := []struct {
+		line        string
+		completions []string
+	}{
+		{"SEL", []string{"SELECT"}},
+		{"sel", []string{"select"}},
+		{"SELECT * FROM U", []string{"SELECT * FROM UNION", "SELECT * FROM UNIQUE", "SELECT * FROM UPDATE", "SELECT * FROM USING", "SELECT * FROM uploads", "SELECT * FROM users"}},
+		{"SELECT * FROM us", []string{"SELECT * FROM users", "SELECT * FROM using"}},
+		{".ta", []string{".tables"}},
+		{".schema up", []string{".schema uploads"}},
+		{".mode cs", []string{".mode csv"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.line, func(t *testing.T) {
+			assert.Equal(t, c.completions, complete(c.line, tables))
+		})
+	}
+}
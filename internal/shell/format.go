@@ -0,0 +1,178 @@
+package shell
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Supported output formats for query results.
+const (
+	formatList  = "list"
+	formatTable = "table"
+	formatCSV   = "csv"
+	formatTSV   = "tsv"
+	formatJSON  = "json"
+)
+
+// Return an error if the given output format is not supported.
+func validateFormat(format string) error {
+	switch format {
+	case formatList, formatTable, formatCSV, formatTSV, formatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q (use list, table, csv, tsv or json)", format)
+}
+
+// Render query results according to the given format.
+func render(format string, columns []string, rows [][]interface{}) (string, error) {
+	switch format {
+	case formatList:
+		return renderList(rows), nil
+	case formatTable:
+		return renderTable(columns, rows), nil
+	case formatCSV:
+		return renderSeparated(',', columns, rows)
+	case formatTSV:
+		return renderSeparated('\t', columns, rows)
+	case formatJSON:
+		return renderJSON(columns, rows)
+	}
+	return "", validateFormat(format)
+}
+
+// Values separated by '|', without header, like the sqlite3 CLI default.
+func renderList(rows [][]interface{}) string {
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		values := make([]string, len(row))
+		for j, value := range row {
+			values[j] = fmt.Sprintf("%v", value)
+		}
+		lines[i] = strings.Join(values, "|")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Aligned columns with a header, enclosed in an ASCII box.
+func renderTable(columns []string, rows [][]interface{}) string {
+	widths := make([]int, len(columns))
+	for i, column := range columns {
+		widths[i] = len(column)
+	}
+	cells := make([][]string, len(rows))
+	for i, row := range rows {
+		cells[i] = make([]string, len(row))
+		for j, value := range row {
+			cell := formatValue(value)
+			if value == nil {
+				cell = "NULL"
+			}
+			cells[i][j] = cell
+			if len(cell) > widths[j] {
+				widths[j] = len(cell)
+			}
+		}
+	}
+
+	separator := "+"
+	for _, width := range widths {
+		separator += strings.Repeat("-", width+2) + "+"
+	}
+	line := func(values []string) string {
+		s := "|"
+		for i, value := range values {
+			s += " " + value + strings.Repeat(" ", widths[i]-len(value)) + " |"
+		}
+		return s
+	}
+
+	lines := []string{separator, line(columns), separator}
+	for _, row := range cells {
+		lines = append(lines, line(row))
+	}
+	lines = append(lines, separator)
+
+	return strings.Join(lines, "\n")
+}
+
+// RFC 4180 records with a header line, using the given field separator.
+func renderSeparated(comma rune, columns []string, rows [][]interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	writer.Comma = comma
+
+	if err := writer.Write(columns); err != nil {
+		return "", err
+	}
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			record[i] = formatValue(value)
+		}
+		if err := writer.Write(record); err != nil {
+			return "", err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
+
+// An array of objects, one per row, with keys in column order.
+func renderJSON(columns []string, rows [][]interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("[")
+	for i, row := range rows {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  {")
+		for j, value := range row {
+			if j > 0 {
+				buf.WriteString(", ")
+			}
+			key, err := json.Marshal(columns[j])
+			if err != nil {
+				return "", err
+			}
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			data, err := json.Marshal(value)
+			if err != nil {
+				return "", err
+			}
+			buf.Write(key)
+			buf.WriteString(": ")
+			buf.Write(data)
+		}
+		buf.WriteString("}")
+	}
+	if len(rows) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]")
+
+	return buf.String(), nil
+}
+
+// Convert a value to text, rendering NULL as an empty string.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
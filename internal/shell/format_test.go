@@ -0,0 +1,60 @@
+package shell
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	columns := []string{"id", "name"}
+	rows := [][]interface{}{
+		{int64(1), "foo, \"bar\""},
+		{int64(2), nil},
+	}
+
+	cases := []struct {
+		format string
+		output string
+	}{
+		{
+			formatList,
+			"1|foo, \"bar\"\n2|<nil>",
+		},
+		{
+			formatTable,
+			`+----+------------+
+| id | name       |
++----+------------+
+| 1  | foo, "bar" |
+| 2  | NULL       |
++----+------------+`,
+		},
+		{
+			formatCSV,
+			"id,name\n1,\"foo, \"\"bar\"\"\"\n2,",
+		},
+		{
+			formatTSV,
+			"id\tname\n1\t\"foo, \"\"bar\"\"\"\n2\t",
+		},
+		{
+			formatJSON,
+			"[\n  {\"id\": 1, \"name\": \"foo, \\\"bar\\\"\"},\n  {\"id\": 2, \"name\": null}\n]",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			output, err := render(c.format, columns, rows)
+			require.NoError(t, err)
+			assert.Equal(t, c.output, output)
+		})
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	assert.NoError(t, validateFormat("csv"))
+	assert.EqualError(t, validateFormat("xml"), `unknown format "xml" (use list, table, csv, tsv or json)`)
+}
@@ -0,0 +1,258 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Process a meta-command mirroring the ones of the sqlite3 CLI.
+func (s *Shell) processMeta(ctx context.Context, args []string) (string, error) {
+	var table string
+	if len(args) > 1 {
+		table = args[1]
+	}
+	if len(args) > 2 {
+		return "", fmt.Errorf("too many arguments for %s", args[0])
+	}
+
+	switch args[0] {
+	case ".tables":
+		return s.processTables(ctx)
+	case ".schema":
+		return s.processSchema(ctx, table)
+	case ".indexes":
+		return s.processIndexes(ctx, table)
+	case ".databases":
+		return s.processDatabases(ctx)
+	case ".dump":
+		return s.processDump(ctx, table)
+	case ".mode":
+		return s.processMode(table)
+	case ".stats":
+		return s.processStats(table)
+	}
+
+	return "", fmt.Errorf("unknown command %s", args[0])
+}
+
+// Show or change the output format.
+func (s *Shell) processMode(format string) (string, error) {
+	if format == "" {
+		return s.format, nil
+	}
+	if err := validateFormat(format); err != nil {
+		return "", err
+	}
+	s.format = format
+	return "", nil
+}
+
+// Show or reset the statistics of the statements executed by the shell.
+func (s *Shell) processStats(arg string) (string, error) {
+	switch arg {
+	case "":
+	case "reset":
+		s.driver.ResetQueryStats()
+		return "", nil
+	default:
+		return "", fmt.Errorf("unknown argument %s for .stats", arg)
+	}
+
+	columns := []string{"sql", "calls", "errors", "rows", "total", "mean", "p50", "p95", "p99", "max"}
+	rows := [][]interface{}{}
+	for _, stat := range s.driver.QueryStats() {
+		rows = append(rows, []interface{}{
+			stat.SQL, stat.Calls, stat.Errors, stat.Rows,
+			stat.Total.String(), stat.Mean().String(),
+			stat.P50.String(), stat.P95.String(), stat.P99.String(), stat.Max.String(),
+		})
+	}
+
+	return render(s.format, columns, rows)
+}
+
+func (s *Shell) processTables(ctx context.Context) (string, error) {
+	rows, err := s.query(ctx, `
+SELECT name FROM sqlite_master
+ WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%'
+ ORDER BY name`)
+	if err != nil {
+		return "", err
+	}
+	return joinColumn(rows), nil
+}
+
+func (s *Shell) processSchema(ctx context.Context, table string) (string, error) {
+	query := "SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'"
+	args := []interface{}{}
+	if table != "" {
+		query += " AND tbl_name = ?"
+		args = append(args, table)
+	}
+	query += " ORDER BY tbl_name, type DESC, name"
+
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return "", err
+	}
+
+	result := ""
+	for i, row := range rows {
+		if i > 0 {
+			result += "\n"
+		}
+		result += fmt.Sprintf("%s;", row[0])
+	}
+
+	return result, nil
+}
+
+func (s *Shell) processIndexes(ctx context.Context, table string) (string, error) {
+	query := "SELECT name FROM sqlite_master WHERE type = 'index'"
+	args := []interface{}{}
+	if table != "" {
+		query += " AND tbl_name = ?"
+		args = append(args, table)
+	}
+	query += " ORDER BY name"
+
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return "", err
+	}
+	return joinColumn(rows), nil
+}
+
+func (s *Shell) processDatabases(ctx context.Context) (string, error) {
+	rows, err := s.query(ctx, "PRAGMA database_list")
+	if err != nil {
+		return "", err
+	}
+
+	result := ""
+	for i, row := range rows {
+		if i > 0 {
+			result += "\n"
+		}
+		result += fmt.Sprintf("%v: %v", row[1], row[2])
+	}
+
+	return result, nil
+}
+
+// Dump the content of the database (or of a single table) as SQL text.
+func (s *Shell) processDump(ctx context.Context, table string) (string, error) {
+	query := `
+SELECT name, type, sql FROM sqlite_master
+ WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'`
+	args := []interface{}{}
+	if table != "" {
+		query += " AND tbl_name = ?"
+		args = append(args, table)
+	}
+	query += " ORDER BY CASE type WHEN 'table' THEN 0 ELSE 1 END, name"
+
+	objects, err := s.query(ctx, query, args...)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{"BEGIN TRANSACTION;"}
+	for _, object := range objects {
+		name := fmt.Sprintf("%v", object[0])
+		lines = append(lines, fmt.Sprintf("%v;", object[2]))
+		if object[1] != "table" {
+			continue
+		}
+		rows, err := s.query(ctx, fmt.Sprintf("SELECT * FROM %s", quoteIdentifier(name)))
+		if err != nil {
+			return "", err
+		}
+		for _, row := range rows {
+			values := make([]string, len(row))
+			for i, value := range row {
+				values[i] = quoteValue(value)
+			}
+			lines = append(lines, fmt.Sprintf(
+				"INSERT INTO %s VALUES(%s);", quoteIdentifier(name), strings.Join(values, ",")))
+		}
+	}
+	lines = append(lines, "COMMIT;")
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// Run a query and return all its rows.
+func (s *Shell) query(ctx context.Context, query string, args ...interface{}) ([][]interface{}, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("columns: %w", err)
+	}
+	n := len(columns)
+
+	result := [][]interface{}{}
+	for rows.Next() {
+		row := make([]interface{}, n)
+		rowPointers := make([]interface{}, n)
+		for i := range row {
+			rowPointers[i] = &row[i]
+		}
+		if err := rows.Scan(rowPointers...); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		result = append(result, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return result, nil
+}
+
+// Join the values of the first column of the given rows, one per line.
+func joinColumn(rows [][]interface{}) string {
+	values := make([]string, len(rows))
+	for i, row := range rows {
+		values[i] = fmt.Sprintf("%v", row[0])
+	}
+	return strings.Join(values, "\n")
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// Render a value as a SQL literal.
+func quoteValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		return fmt.Sprintf("X'%X'", v)
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05.999999999-07:00") + "'"
+	default:
+		return "'" + strings.Replace(fmt.Sprintf("%v", v), "'", "''", -1) + "'"
+	}
+}
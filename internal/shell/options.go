@@ -20,9 +20,18 @@ func WithDriverName(name string) Option {
 	}
 }
 
+// WithFormat sets the output format for query results. Supported formats are
+// "list" (the default), "table", "csv", "tsv" and "json".
+func WithFormat(format string) Option {
+	return func(options *options) {
+		options.Format = format
+	}
+}
+
 type options struct {
 	Dial       client.DialFunc
 	DriverName string
+	Format     string
 }
 
 // Create a client options object with sane defaults.
@@ -30,5 +39,6 @@ func defaultOptions() *options {
 	return &options{
 		Dial:       client.DefaultDialFunc,
 		DriverName: "dqlite",
+		Format:     formatList,
 	}
 }
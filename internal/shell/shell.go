@@ -13,9 +13,13 @@ import (
 // Shell can be used to implement interactive prompts for inspecting a dqlite
 // database.
 type Shell struct {
-	store client.NodeStore
-	dial  client.DialFunc
-	db    *sql.DB
+	store      client.NodeStore
+	dial       client.DialFunc
+	db         *sql.DB
+	driver     *driver.Driver
+	driverName string
+	format     string
+	tx         *sql.Tx // Transaction started with Begin, if any.
 }
 
 // New creates a new Shell connected to the given database.
@@ -26,26 +30,44 @@ func New(database string, store client.NodeStore, options ...Option) (*Shell, er
 		option(o)
 	}
 
-	driver, err := driver.New(store, driver.WithDialFunc(o.Dial))
+	if err := validateFormat(o.Format); err != nil {
+		return nil, err
+	}
+
+	drv, err := driver.New(store, driver.WithDialFunc(o.Dial), driver.WithQueryStats(true))
 	if err != nil {
 		return nil, err
 	}
-	sql.Register(o.DriverName, driver)
+	if err := driver.Register(o.DriverName, drv); err != nil {
+		return nil, err
+	}
 
 	db, err := sql.Open(o.DriverName, database)
 	if err != nil {
+		driver.Unregister(o.DriverName)
 		return nil, err
 	}
 
 	shell := &Shell{
-		store: store,
-		dial:  o.Dial,
-		db:    db,
+		store:      store,
+		dial:       o.Dial,
+		db:         db,
+		driver:     drv,
+		driverName: o.DriverName,
+		format:     o.Format,
 	}
 
 	return shell, nil
 }
 
+// Close the shell, releasing its database connections and the name of its
+// registered driver.
+func (s *Shell) Close() error {
+	err := s.db.Close()
+	driver.Unregister(s.driverName)
+	return err
+}
+
 // Process a single input line.
 func (s *Shell) Process(ctx context.Context, line string) (string, error) {
 	switch line {
@@ -54,6 +76,9 @@ func (s *Shell) Process(ctx context.Context, line string) (string, error) {
 	case ".leader":
 		return s.processLeader(ctx, line)
 	}
+	if strings.HasPrefix(strings.TrimSpace(line), ".") {
+		return s.processMeta(ctx, strings.Fields(line))
+	}
 	if strings.HasPrefix(strings.ToUpper(strings.TrimLeft(line, " ")), "SELECT") {
 		return s.processSelect(ctx, line)
 	} else {
@@ -97,9 +122,14 @@ func (s *Shell) processLeader(ctx context.Context, line string) (string, error)
 }
 
 func (s *Shell) processSelect(ctx context.Context, line string) (string, error) {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return "", fmt.Errorf("begin transaction: %w", err)
+	tx := s.tx
+	if tx == nil {
+		var err error
+		tx, err = s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return "", fmt.Errorf("begin transaction: %w", err)
+		}
+		defer tx.Rollback()
 	}
 
 	rows, err := tx.Query(line)
@@ -114,7 +144,7 @@ func (s *Shell) processSelect(ctx context.Context, line string) (string, error)
 	}
 	n := len(columns)
 
-	result := ""
+	result := [][]interface{}{}
 	for rows.Next() {
 		row := make([]interface{}, n)
 		rowPointers := make([]interface{}, n)
@@ -126,37 +156,35 @@ func (s *Shell) processSelect(ctx context.Context, line string) (string, error)
 			return "", fmt.Errorf("scan: %w", err)
 		}
 
-		for i, column := range row {
-			s := fmt.Sprintf("%v", column)
-			if i == 0 {
-				result += s
-			} else {
-				result += "|" + s
-			}
-
-		}
-		result += "\n"
+		result = append(result, row)
 	}
-	result = strings.TrimRight(result, "\n")
 
 	if err := rows.Err(); err != nil {
 		return "", fmt.Errorf("rows: %w", err)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return "", fmt.Errorf("commit: %w", err)
+	if s.tx == nil {
+		if err := tx.Commit(); err != nil {
+			return "", fmt.Errorf("commit: %w", err)
+		}
 	}
 
-	return result, nil
+	return render(s.format, columns, result)
 }
 
 func (s *Shell) processExec(ctx context.Context, line string) error {
+	if s.tx != nil {
+		_, err := s.tx.ExecContext(ctx, line)
+		return err
+	}
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
 	if _, err := tx.Exec(line); err != nil {
+		tx.Rollback()
 		return err
 	}
 
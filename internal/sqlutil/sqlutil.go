@@ -0,0 +1,70 @@
+// Package sqlutil implements helpers to inspect SQL text without parsing it.
+package sqlutil
+
+import "strings"
+
+// Keywords that might modify the database or the connection state.
+var writeKeywords = map[string]bool{
+	"INSERT":  true,
+	"UPDATE":  true,
+	"DELETE":  true,
+	"REPLACE": true,
+	"CREATE":  true,
+	"DROP":    true,
+	"ALTER":   true,
+	"ATTACH":  true,
+	"DETACH":  true,
+	"VACUUM":  true,
+	"REINDEX": true,
+	"ANALYZE": true,
+	"PRAGMA":  true,
+}
+
+// IsReadOnly returns true if the given SQL text contains no keyword that
+// might modify the database. This is conservative: for example, a read-only
+// statement using one of those keywords as an unquoted identifier is
+// rejected.
+func IsReadOnly(sql string) bool {
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			for i++; i < len(sql) && sql[i] != end; i++ {
+			}
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end == -1 {
+				return true
+			}
+			i += end + 3
+		case isWordChar(c):
+			start := i
+			for i+1 < len(sql) && isWordChar(sql[i+1]) {
+				i++
+			}
+			word := strings.ToUpper(sql[start : i+1])
+			if !writeKeywords[word] {
+				continue
+			}
+			// The replace() function is fine.
+			rest := strings.TrimLeft(sql[i+1:], " \t\r\n")
+			if word == "REPLACE" && strings.HasPrefix(rest, "(") {
+				continue
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
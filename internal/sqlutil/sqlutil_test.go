@@ -0,0 +1,29 @@
+package sqlutil_test
+
+import (
+	"testing"
+
+	"github.com/canonical/go-dqlite/internal/sqlutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReadOnly(t *testing.T) {
+	cases := []struct {
+		sql      string
+		readOnly bool
+	}{
+		{"SELECT * FROM t", true},
+		{"SELECT replace(name, 'a', 'b') FROM t", true},
+		{"SELECT 'DELETE' FROM t -- DROP", true},
+		{"SELECT \"update\" FROM t /* INSERT */", true},
+		{"INSERT INTO t VALUES(1)", false},
+		{"select 1; delete from t", false},
+		{"REPLACE INTO t VALUES(1)", false},
+		{"PRAGMA query_only=0", false},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			assert.Equal(t, c.readOnly, sqlutil.IsReadOnly(c.sql))
+		})
+	}
+}
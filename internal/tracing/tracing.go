@@ -0,0 +1,58 @@
+// Package tracing contains helpers to create OpenTelemetry spans, which are
+// no-ops when no tracer is configured.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name is the instrumentation name used to obtain tracers from a
+// TracerProvider.
+const Name = "github.com/canonical/go-dqlite"
+
+// Attribute keys attached to spans, following the OpenTelemetry semantic
+// conventions where applicable.
+const (
+	DBSystem    = attribute.Key("db.system")
+	DBName      = attribute.Key("db.name")
+	DBStatement = attribute.Key("db.statement")
+	PeerName    = attribute.Key("net.peer.name")
+	Request     = attribute.Key("dqlite.request")
+	Attempts    = attribute.Key("dqlite.connect.attempts")
+)
+
+// Tracer returns a tracer from the given provider, or nil if the provider is
+// nil.
+func Tracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		return nil
+	}
+	return provider.Tracer(Name)
+}
+
+// Start a new client span with the given name and attributes. If the tracer
+// is nil, the given context and a nil span are returned.
+func Start(ctx context.Context, tracer trace.Tracer, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	attributes = append(attributes, DBSystem.String("dqlite"))
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+}
+
+// End the given span, recording the given error if not nil. It's a no-op if
+// the span is nil.
+func End(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,121 @@
+package tracing_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/internal/protocol"
+	"github.com/canonical/go-dqlite/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStart_NilTracer(t *testing.T) {
+	ctx := context.Background()
+	spanCtx, span := tracing.Start(ctx, nil, "test")
+	assert.Equal(t, ctx, spanCtx)
+	assert.Nil(t, span)
+
+	tracing.End(span, errors.New("boom"))
+}
+
+func TestClient(t *testing.T) {
+	provider, exporter := newProvider()
+
+	server, err := fakeserver.New(1)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx := context.Background()
+	cli, err := client.New(ctx, server.Address(), client.WithTracerProvider(provider))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.Leader(ctx)
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "dqlite.leader", spans[0].Name)
+	assertAttribute(t, spans[0], tracing.PeerName, server.Address())
+	assertAttribute(t, spans[0], tracing.DBSystem, "dqlite")
+}
+
+func TestDriver(t *testing.T) {
+	provider, exporter := newProvider()
+
+	server, err := fakeserver.New(1)
+	require.NoError(t, err)
+	defer server.Close()
+	server.SetExec("INSERT INTO test VALUES(1)", 1, 1)
+	server.Fail(fakeserver.Failure{Type: protocol.RequestExecSQL, SQL: "INSERT INTO test VALUES(2)", Code: 1, Message: "boom"})
+
+	store := client.NewInmemNodeStore()
+	store.Set(context.Background(), []client.NodeInfo{{Address: server.Address()}})
+
+	drv, err := driver.New(store, driver.WithTracerProvider(provider))
+	require.NoError(t, err)
+	connector, err := drv.OpenConnector("test")
+	require.NoError(t, err)
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO test VALUES(1)")
+	require.NoError(t, err)
+
+	_, err = db.Exec("INSERT INTO test VALUES(2)")
+	require.Error(t, err)
+
+	byName := map[string][]*sdktrace.SpanSnapshot{}
+	for _, span := range exporter.GetSpans() {
+		byName[span.Name] = append(byName[span.Name], span)
+	}
+
+	require.Len(t, byName["dqlite.driver.connect"], 1)
+	connect := byName["dqlite.driver.connect"][0]
+	assertAttribute(t, connect, tracing.DBName, "test")
+
+	require.Len(t, byName["dqlite.connect"], 1)
+	assertAttribute(t, byName["dqlite.connect"][0], tracing.Attempts, int64(1))
+	assert.Equal(t, connect.SpanContext.SpanID(), byName["dqlite.connect"][0].Parent.SpanID())
+
+	execs := byName["dqlite.driver.exec"]
+	require.Len(t, execs, 2)
+	assertAttribute(t, execs[0], tracing.DBStatement, "INSERT INTO test VALUES(1)")
+	assert.Equal(t, codes.Unset, execs[0].StatusCode)
+	assertAttribute(t, execs[1], tracing.DBStatement, "INSERT INTO test VALUES(2)")
+	assert.Equal(t, codes.Error, execs[1].StatusCode)
+
+	// Protocol requests are children of the driver operation spans.
+	requests := byName["dqlite.exec-sql"]
+	require.Len(t, requests, 2)
+	for i, request := range requests {
+		assert.Equal(t, execs[i].SpanContext.SpanID(), request.Parent.SpanID())
+	}
+}
+
+func newProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return provider, exporter
+}
+
+func assertAttribute(t *testing.T, span *sdktrace.SpanSnapshot, key attribute.Key, value interface{}) {
+	t.Helper()
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			assert.Equal(t, value, kv.Value.AsInterface())
+			return
+		}
+	}
+	t.Errorf("span %s has no attribute %s", span.Name, key)
+}
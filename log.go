@@ -0,0 +1,167 @@
+package dqlite
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/internal/raft"
+)
+
+// LogEntryType identifies the type of a raft log entry.
+type LogEntryType = raft.EntryType
+
+// Raft log entry types.
+const (
+	LogCommand = raft.Command
+	LogBarrier = raft.Barrier
+	LogChange  = raft.Change
+)
+
+// LogEntry holds information about a single entry of the raft log.
+type LogEntry struct {
+	Index uint64
+	Term  uint64
+	Type  LogEntryType
+	Size  int
+
+	// Configuration holds the decoded cluster configuration, only for
+	// entries of type LogChange.
+	Configuration []NodeInfo
+}
+
+// ReadLog returns information about all entries of the raft log stored in
+// the given data directory.
+//
+// It's meant as a debugging tool for post-mortem analysis of replication
+// issues and should be run against the data directory of a stopped node, as
+// it doesn't synchronize with a running one.
+func ReadLog(dir string) ([]LogEntry, error) {
+	entries, err := raft.ReadLog(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	log := make([]LogEntry, len(entries))
+	for i, entry := range entries {
+		log[i] = LogEntry{
+			Index: entry.Index,
+			Term:  entry.Term,
+			Type:  entry.Type,
+			Size:  len(entry.Data),
+		}
+		if entry.Type != raft.Change {
+			continue
+		}
+		servers, err := raft.DecodeConfiguration(entry.Data)
+		if err != nil {
+			return nil, err
+		}
+		log[i].Configuration = nodesFromServers(servers)
+	}
+
+	return log, nil
+}
+
+// LastLogEntry returns the index and term of the last entry of the raft log
+// stored in the given data directory, or of the most recent snapshot if the
+// log is empty.
+//
+// Unlike ReadLog, it can be used on the data directory of a running node, in
+// which case entries being written are ignored. It might fail if the node
+// removes or renames a segment file concurrently, and should be retried.
+func LastLogEntry(dir string) (index uint64, term uint64, err error) {
+	return raft.LastEntry(dir)
+}
+
+// ReadConfiguration returns the most recent cluster configuration found in
+// the raft log or snapshots stored in the given data directory, or nil if
+// there's none.
+//
+// Like ReadLog, it doesn't synchronize with a running node.
+func ReadConfiguration(dir string) ([]NodeInfo, error) {
+	var servers []raft.Server
+	found := false
+	index := uint64(0)
+
+	snapshots, err := raft.ListSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) > 0 {
+		snapshot := snapshots[len(snapshots)-1]
+		meta, err := raft.ReadSnapshotMeta(filepath.Join(dir, snapshot.Meta()))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", snapshot.Meta(), err)
+		}
+		servers = meta.Configuration
+		index = meta.ConfigurationIndex
+		found = true
+	}
+
+	entries, err := raft.ReadLog(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Type != raft.Change || (found && entry.Index <= index) {
+			continue
+		}
+		servers, err = raft.DecodeConfiguration(entry.Data)
+		if err != nil {
+			return nil, err
+		}
+		index = entry.Index
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return nodesFromServers(servers), nil
+}
+
+// Convert raft servers to node information objects.
+func nodesFromServers(servers []raft.Server) []NodeInfo {
+	nodes := make([]NodeInfo, len(servers))
+	for i, server := range servers {
+		nodes[i] = NodeInfo{
+			ID:      server.ID,
+			Address: server.Address,
+			Role:    roleFromRaft(server.Role),
+		}
+	}
+	return nodes
+}
+
+// Convert a raft role to a dqlite node role.
+func roleFromRaft(role raft.Role) client.NodeRole {
+	switch role {
+	case raft.Voter:
+		return client.Voter
+	case raft.StandBy:
+		return client.StandBy
+	default:
+		return client.Spare
+	}
+}
+
+// DataDirReport is the outcome of CheckDataDir. It's meant to be serialized
+// as JSON.
+type DataDirReport = raft.Report
+
+// DataDirProblem describes an issue found by CheckDataDir, with either the
+// "error" or the "warning" severity.
+type DataDirProblem = raft.Problem
+
+// CheckDataDir verifies the integrity of the data directory of a node,
+// without starting it: the raft metadata files, the log segments and their
+// continuity with the snapshots, the snapshot metadata files and the free
+// disk space. The node can start if the report has no errors.
+//
+// Like ReadLog, it doesn't synchronize with a running node. It fails only if
+// the directory can't be read.
+func CheckDataDir(dir string) (*DataDirReport, error) {
+	return raft.Check(dir)
+}
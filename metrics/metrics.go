@@ -0,0 +1,275 @@
+// Package metrics provides Prometheus collectors for dqlite applications.
+//
+// A single Metrics object is created with New and then passed to the app,
+// client and driver packages using their WithMetrics options, so all of them
+// report to the same set of collectors:
+//
+//	m, err := metrics.New(prometheus.DefaultRegisterer)
+//	if err != nil {
+//		// ...
+//	}
+//	app, err := app.New(dir, app.WithMetrics(m))
+//
+// All methods of a nil *Metrics are no-ops.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the Prometheus collectors for dqlite.
+type Metrics struct {
+	requestDuration *prometheus.HistogramVec
+	queries         *prometheus.CounterVec
+	proxyActive     prometheus.Gauge
+	proxyTotal      prometheus.Counter
+	proxyDuration   prometheus.Histogram
+	proxyBytes      *prometheus.CounterVec
+	leader          prometheus.Gauge
+	leaderChanges   prometheus.Counter
+	nodes           *prometheus.GaugeVec
+	gatherer        prometheus.Gatherer // The registerer, if it's also a gatherer.
+
+	mu           sync.Mutex // Serialize cluster observations
+	lastLeaderID uint64     // Leader seen by the last observation, or 0
+}
+
+// Option that can be used to tweak metrics parameters.
+type Option func(*options)
+
+// WithNamespace sets the namespace of all metric names. The default is
+// "dqlite".
+func WithNamespace(namespace string) Option {
+	return func(options *options) {
+		options.Namespace = namespace
+	}
+}
+
+// WithConstLabels sets labels attached to all metrics, for example to tell
+// apart several nodes running in the same process.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(options *options) {
+		options.ConstLabels = labels
+	}
+}
+
+// WithBuckets sets the buckets of the request latency histogram, in seconds.
+func WithBuckets(buckets []float64) Option {
+	return func(options *options) {
+		options.Buckets = buckets
+	}
+}
+
+type options struct {
+	Namespace   string
+	ConstLabels prometheus.Labels
+	Buckets     []float64
+}
+
+// Create a metrics options object with sane defaults.
+func defaultOptions() *options {
+	return &options{
+		Namespace: "dqlite",
+		Buckets:   prometheus.DefBuckets,
+	}
+}
+
+// New creates the dqlite collectors and registers them with the given
+// registerer.
+func New(registerer prometheus.Registerer, options ...Option) (*Metrics, error) {
+	o := defaultOptions()
+
+	for _, option := range options {
+		option(o)
+	}
+
+	m := &Metrics{
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   "protocol",
+			Name:        "request_duration_seconds",
+			Help:        "Latency of requests sent to dqlite nodes, by request type and outcome.",
+			ConstLabels: o.ConstLabels,
+			Buckets:     o.Buckets,
+		}, []string{"request", "outcome"}),
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   "driver",
+			Name:        "queries_total",
+			Help:        "Statements executed through the driver, by kind (exec or query) and outcome.",
+			ConstLabels: o.ConstLabels,
+		}, []string{"kind", "outcome"}),
+		proxyActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   "proxy",
+			Name:        "connections",
+			Help:        "Connections currently proxied to the local node.",
+			ConstLabels: o.ConstLabels,
+		}),
+		proxyTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   "proxy",
+			Name:        "connections_total",
+			Help:        "Connections proxied to the local node since startup.",
+			ConstLabels: o.ConstLabels,
+		}),
+		proxyDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   "proxy",
+			Name:        "connection_duration_seconds",
+			Help:        "Lifetime of connections proxied to the local node.",
+			ConstLabels: o.ConstLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.1, 4, 10),
+		}),
+		proxyBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   "proxy",
+			Name:        "bytes_total",
+			Help:        "Bytes proxied to the local node, by direction (in or out).",
+			ConstLabels: o.ConstLabels,
+		}, []string{"direction"}),
+		leader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   "raft",
+			Name:        "leader",
+			Help:        "Whether the local node is the raft leader (1) or not (0).",
+			ConstLabels: o.ConstLabels,
+		}),
+		leaderChanges: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   "raft",
+			Name:        "leader_changes_total",
+			Help:        "Leader changes observed by the local node.",
+			ConstLabels: o.ConstLabels,
+		}),
+		nodes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   "raft",
+			Name:        "nodes",
+			Help:        "Cluster members, by role.",
+			ConstLabels: o.ConstLabels,
+		}, []string{"role"}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.requestDuration,
+		m.queries,
+		m.proxyActive,
+		m.proxyTotal,
+		m.proxyDuration,
+		m.proxyBytes,
+		m.leader,
+		m.leaderChanges,
+		m.nodes,
+	}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	if gatherer, ok := registerer.(prometheus.Gatherer); ok {
+		m.gatherer = gatherer
+	}
+
+	return m, nil
+}
+
+// Gatherer returns the registerer passed to New if it's also a gatherer, as
+// prometheus registries are, or nil.
+func (m *Metrics) Gatherer() prometheus.Gatherer {
+	if m == nil {
+		return nil
+	}
+	return m.gatherer
+}
+
+// ObserveRequest records the latency and outcome of a request sent to a
+// dqlite node. Its signature matches the one expected by the protocol layer.
+func (m *Metrics) ObserveRequest(request string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.requestDuration.WithLabelValues(request, outcome(err)).Observe(duration.Seconds())
+}
+
+// ObserveQuery records a statement executed through the driver. The kind is
+// either "exec" or "query".
+func (m *Metrics) ObserveQuery(kind string, err error) {
+	if m == nil {
+		return
+	}
+	m.queries.WithLabelValues(kind, outcome(err)).Inc()
+}
+
+// ProxyConnectionOpened records a new connection proxied to the local node.
+func (m *Metrics) ProxyConnectionOpened() {
+	if m == nil {
+		return
+	}
+	m.proxyActive.Inc()
+	m.proxyTotal.Inc()
+}
+
+// ProxyConnectionClosed records the end of a proxied connection.
+func (m *Metrics) ProxyConnectionClosed() {
+	if m == nil {
+		return
+	}
+	m.proxyActive.Dec()
+}
+
+// ObserveProxyConnection records the lifetime of a proxied connection and the
+// bytes it received from the client (in) and sent to it (out). It's meant to
+// be called when the connection is closed.
+func (m *Metrics) ObserveProxyConnection(duration time.Duration, in int64, out int64) {
+	if m == nil {
+		return
+	}
+	m.proxyDuration.Observe(duration.Seconds())
+	m.proxyBytes.WithLabelValues("in").Add(float64(in))
+	m.proxyBytes.WithLabelValues("out").Add(float64(out))
+}
+
+// ObserveCluster records the current leader and the members of the cluster
+// by role, as seen by the node with the given ID. A leader ID of zero means
+// that no leader is known.
+//
+// The dqlite engine does not expose raft terms and log indexes, so leader
+// changes are counted by comparing successive observations.
+func (m *Metrics) ObserveCluster(id uint64, leaderID uint64, roles map[string]int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if leaderID != 0 {
+		if m.lastLeaderID != 0 && leaderID != m.lastLeaderID {
+			m.leaderChanges.Inc()
+		}
+		m.lastLeaderID = leaderID
+	}
+
+	if leaderID != 0 && leaderID == id {
+		m.leader.Set(1)
+	} else {
+		m.leader.Set(0)
+	}
+
+	m.nodes.Reset()
+	for role, n := range roles {
+		m.nodes.WithLabelValues(role).Set(float64(n))
+	}
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
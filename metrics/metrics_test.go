@@ -0,0 +1,182 @@
+package metrics_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/canonical/go-dqlite/internal/fakeserver"
+	"github.com/canonical/go-dqlite/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_AlreadyRegistered(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	_, err := metrics.New(registry)
+	require.NoError(t, err)
+
+	_, err = metrics.New(registry)
+	assert.Error(t, err)
+
+	_, err = metrics.New(registry, metrics.WithConstLabels(prometheus.Labels{"node": "2"}))
+	assert.Error(t, err)
+
+	_, err = metrics.New(registry, metrics.WithNamespace("other"))
+	assert.NoError(t, err)
+}
+
+func TestNil(t *testing.T) {
+	var m *metrics.Metrics
+
+	m.ObserveRequest("leader", 0, nil)
+	m.ObserveQuery("exec", nil)
+	m.ProxyConnectionOpened()
+	m.ProxyConnectionClosed()
+	m.ObserveCluster(1, 1, nil)
+}
+
+func TestObserveCluster(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+
+	m.ObserveCluster(1, 1, map[string]int{"voter": 3, "spare": 1})
+	values := gather(t, registry)
+	assert.Equal(t, 1.0, values["dqlite_raft_leader"])
+	assert.Equal(t, 0.0, values["dqlite_raft_leader_changes_total"])
+	assert.Equal(t, 3.0, values["dqlite_raft_nodes{role=voter}"])
+	assert.Equal(t, 1.0, values["dqlite_raft_nodes{role=spare}"])
+
+	// An unknown leader is not counted as a change.
+	m.ObserveCluster(1, 0, map[string]int{"voter": 3})
+	m.ObserveCluster(1, 1, map[string]int{"voter": 3})
+	values = gather(t, registry)
+	assert.Equal(t, 0.0, values["dqlite_raft_leader_changes_total"])
+	assert.NotContains(t, values, "dqlite_raft_nodes{role=spare}")
+
+	m.ObserveCluster(1, 2, map[string]int{"voter": 3})
+	values = gather(t, registry)
+	assert.Equal(t, 0.0, values["dqlite_raft_leader"])
+	assert.Equal(t, 1.0, values["dqlite_raft_leader_changes_total"])
+}
+
+func TestProxyConnections(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+
+	m.ProxyConnectionOpened()
+	m.ProxyConnectionOpened()
+	m.ObserveProxyConnection(time.Second, 10, 20)
+	m.ProxyConnectionClosed()
+
+	values := gather(t, registry)
+	assert.Equal(t, 1.0, values["dqlite_proxy_connections"])
+	assert.Equal(t, 2.0, values["dqlite_proxy_connections_total"])
+	assert.Equal(t, 1.0, values["dqlite_proxy_connection_duration_seconds"])
+	assert.Equal(t, 10.0, values["dqlite_proxy_bytes_total{direction=in}"])
+	assert.Equal(t, 20.0, values["dqlite_proxy_bytes_total{direction=out}"])
+}
+
+func TestClient(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+
+	server, err := fakeserver.New(1)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx := context.Background()
+	cli, err := client.New(ctx, server.Address(), client.WithMetrics(m))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.Leader(ctx)
+	require.NoError(t, err)
+	_, err = cli.Cluster(ctx)
+	require.NoError(t, err)
+
+	values := gather(t, registry)
+	assert.Equal(t, 1.0, values["dqlite_protocol_request_duration_seconds{outcome=success,request=leader}"])
+	assert.Equal(t, 1.0, values["dqlite_protocol_request_duration_seconds{outcome=success,request=cluster}"])
+}
+
+func TestDriver(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+
+	server, err := fakeserver.New(1)
+	require.NoError(t, err)
+	defer server.Close()
+	server.SetExec("INSERT INTO test VALUES(1)", 1, 1)
+	server.SetQuery("SELECT n FROM test", fakeserver.Rows{Columns: []string{"n"}})
+
+	store := client.NewInmemNodeStore()
+	store.Set(context.Background(), []client.NodeInfo{{Address: server.Address()}})
+
+	drv, err := driver.New(store, driver.WithMetrics(m))
+	require.NoError(t, err)
+	connector, err := drv.OpenConnector("test")
+	require.NoError(t, err)
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO test VALUES(1)")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO test VALUES(1)")
+	require.NoError(t, err)
+
+	rows, err := db.Query("SELECT n FROM test")
+	require.NoError(t, err)
+	rows.Close()
+
+	values := gather(t, registry)
+	assert.Equal(t, 2.0, values["dqlite_driver_queries_total{kind=exec,outcome=success}"])
+	assert.Equal(t, 1.0, values["dqlite_driver_queries_total{kind=query,outcome=success}"])
+	assert.Equal(t, 1.0, values["dqlite_protocol_request_duration_seconds{outcome=success,request=open}"])
+}
+
+// Return the current value of all metrics in the registry, keyed by name and
+// labels. Histograms are reported with their sample count.
+func gather(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := []string{}
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%s", label.GetName(), label.GetValue()))
+			}
+			sort.Strings(labels)
+			key := family.GetName()
+			if len(labels) > 0 {
+				key += "{" + strings.Join(labels, ",") + "}"
+			}
+			switch {
+			case metric.Counter != nil:
+				values[key] = metric.Counter.GetValue()
+			case metric.Gauge != nil:
+				values[key] = metric.Gauge.GetValue()
+			case metric.Histogram != nil:
+				values[key] = float64(metric.Histogram.GetSampleCount())
+			}
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,43 @@
+//go:build go1.16
+// +build go1.16
+
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// FromFS loads the migrations stored as .sql files in the given directory of
+// the given file system, for example one embedded with go:embed. Files must be
+// named <version>_<name>.sql, like 0001_create_users.sql. Other files are
+// ignored.
+func FromFS(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+	for _, entry := range entries {
+		filename := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(filename, ".sql") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSuffix(filename, ".sql"), "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("migration file %s is not named <version>_<name>.sql", filename)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, filename))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: parts[1], SQL: string(data)})
+	}
+
+	return sorted(migrations)
+}
@@ -0,0 +1,31 @@
+//go:build go1.16
+// +build go1.16
+
+package migrate_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/canonical/go-dqlite/migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_add_email.sql":    {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT")},
+		"migrations/0001_create_users.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY)")},
+		"migrations/README":                {Data: []byte("ignored")},
+	}
+
+	migrations, err := migrate.FromFS(fsys, "migrations")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, migrate.Migration{Version: 1, Name: "create_users", SQL: "CREATE TABLE users (id INTEGER PRIMARY KEY)"}, migrations[0])
+	assert.Equal(t, int64(2), migrations[1].Version)
+
+	fsys["migrations/bad.sql"] = &fstest.MapFile{Data: []byte("SELECT 1")}
+	_, err = migrate.FromFS(fsys, "migrations")
+	assert.EqualError(t, err, "migration file bad.sql is not named <version>_<name>.sql")
+}
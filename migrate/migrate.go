@@ -0,0 +1,282 @@
+// Package migrate applies ordered SQL schema migrations to a dqlite
+// database, exactly once across the whole cluster.
+//
+// Applied migrations are tracked in a schema_migrations table. While applying
+// them, a node holds an advisory lock stored in the schema_migrations_lock
+// table: since all writes go through the cluster leader, at most one node at a
+// time can hold it, and nodes starting concurrently wait for the lock holder
+// to finish instead of racing it. The lock expires after a TTL, so a crashed
+// node doesn't block the others forever.
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Migration is a single schema change.
+type Migration struct {
+	Version int64  // Unique, positive version, defining the order of migrations.
+	Name    string // Human readable name.
+	SQL     string // One or more SQL statements.
+
+	// Function run after the SQL statements, if set, in the same
+	// transaction. It can be used for changes that are hard to express in
+	// SQL, such as initializing data.
+	Func func(ctx context.Context, tx *sql.Tx) error
+}
+
+// Option can be used to tweak migration parameters.
+type Option func(*options)
+
+// WithLockTTL sets how long the lock is held without being refreshed before
+// other nodes can take it over, 1 minute by default. The lock is refreshed
+// before applying each migration, so the TTL must be longer than the slowest
+// migration.
+func WithLockTTL(ttl time.Duration) Option {
+	return func(options *options) {
+		options.LockTTL = ttl
+	}
+}
+
+// WithRetryInterval sets how often a node waiting for the lock tries to take
+// it, 1 second by default.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(options *options) {
+		options.RetryInterval = interval
+	}
+}
+
+type options struct {
+	LockTTL       time.Duration
+	RetryInterval time.Duration
+}
+
+// Create a options object with sane defaults.
+func defaultOptions() *options {
+	return &options{
+		LockTTL:       time.Minute,
+		RetryInterval: time.Second,
+	}
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+    id         INTEGER PRIMARY KEY CHECK (id = 1),
+    holder     TEXT NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+`
+
+// Apply the given migrations that were not applied yet to the given database,
+// in version order, each in its own transaction. It returns the number of
+// migrations applied by this call.
+func Apply(ctx context.Context, db *sql.DB, migrations []Migration, options ...Option) (int, error) {
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+
+	migrations, err := sorted(migrations)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return 0, fmt.Errorf("create migration tables: %w", err)
+	}
+
+	l, err := newLock(db, o.LockTTL)
+	if err != nil {
+		return 0, err
+	}
+	if err := l.acquire(ctx, o.RetryInterval); err != nil {
+		return 0, err
+	}
+	defer l.release()
+
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		if err := l.refresh(ctx); err != nil {
+			return n, err
+		}
+		if err := apply(ctx, db, migration); err != nil {
+			return n, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// Return a copy of the given migrations sorted by version, checking that
+// versions are valid.
+func sorted(migrations []Migration) ([]Migration, error) {
+	migrations = append([]Migration{}, migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version <= 0 {
+			return nil, fmt.Errorf("migration %q has non-positive version %d", migration.Name, migration.Version)
+		}
+		if i > 0 && migrations[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migration.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Return the versions of the migrations already applied.
+func appliedVersions(ctx context.Context, db *sql.DB) (map[int64]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("query applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]bool{}
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// Apply a single migration and record it, atomically.
+func apply(ctx context.Context, db *sql.DB, migration Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if migration.SQL != "" {
+		if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+			return err
+		}
+	}
+	if migration.Func != nil {
+		if err := migration.Func(ctx, tx); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		migration.Version, migration.Name, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Advisory lock held while applying migrations.
+type lock struct {
+	db     *sql.DB
+	ttl    time.Duration
+	holder string
+}
+
+func newLock(db *sql.DB, ttl time.Duration) (*lock, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), hex.EncodeToString(id))
+	return &lock{db: db, ttl: ttl, holder: holder}, nil
+}
+
+// Take the lock, waiting for the current holder, if any, to release it or
+// let it expire.
+func (l *lock) acquire(ctx context.Context, retry time.Duration) error {
+	for {
+		ok, err := l.tryAcquire(ctx)
+		if err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("acquire migration lock: %w", ctx.Err())
+		case <-time.After(retry):
+		}
+	}
+}
+
+func (l *lock) tryAcquire(ctx context.Context) (bool, error) {
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations_lock WHERE expires_at < ?", now.UnixNano()); err != nil {
+		return false, err
+	}
+	result, err := tx.ExecContext(ctx,
+		"INSERT OR IGNORE INTO schema_migrations_lock (id, holder, expires_at) VALUES (1, ?, ?)",
+		l.holder, now.Add(l.ttl).UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// Extend the lock expiration, failing if it was lost.
+func (l *lock) refresh(ctx context.Context) error {
+	result, err := l.db.ExecContext(ctx,
+		"UPDATE schema_migrations_lock SET expires_at = ? WHERE id = 1 AND holder = ?",
+		time.Now().Add(l.ttl).UnixNano(), l.holder)
+	if err != nil {
+		return fmt.Errorf("refresh migration lock: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("refresh migration lock: %w", err)
+	}
+	if n != 1 {
+		return fmt.Errorf("migration lock expired")
+	}
+	return nil
+}
+
+// Release the lock, ignoring errors since it eventually expires anyway.
+func (l *lock) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l.db.ExecContext(ctx, "DELETE FROM schema_migrations_lock WHERE id = 1 AND holder = ?", l.holder)
+}
@@ -0,0 +1,147 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/migrate"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	migrations := []migrate.Migration{
+		{Version: 2, Name: "add_email", SQL: "ALTER TABLE users ADD COLUMN email TEXT"},
+		{Version: 1, Name: "create_users", SQL: "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"},
+	}
+
+	n, err := migrate.Apply(ctx, db, migrations)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	_, err = db.Exec("INSERT INTO users (name, email) VALUES ('x', 'x@example.com')")
+	require.NoError(t, err)
+
+	// Already applied migrations are skipped.
+	migrations = append(migrations, migrate.Migration{Version: 3, Name: "index", SQL: "CREATE INDEX users_email ON users (email)"})
+	n, err = migrate.Apply(ctx, db, migrations)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM schema_migrations").Scan(&count))
+	assert.Equal(t, 3, count)
+}
+
+// A failing migration is rolled back and not recorded.
+func TestApply_Failure(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	migrations := []migrate.Migration{
+		{Version: 1, Name: "create", SQL: "CREATE TABLE t (n INT)"},
+		{Version: 2, Name: "broken", SQL: "INSERT INTO t VALUES (1); INSERT INTO missing VALUES (1)"},
+	}
+
+	n, err := migrate.Apply(context.Background(), db, migrations)
+	assert.EqualError(t, err, "migration 2 (broken): no such table: missing")
+	assert.Equal(t, 1, n)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM t").Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+// Migration functions run in the migration transaction, exactly once.
+func TestApply_Func(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	calls := 0
+	migrations := []migrate.Migration{{
+		Version: 1,
+		Name:    "seed",
+		SQL:     "CREATE TABLE t (n INT)",
+		Func: func(ctx context.Context, tx *sql.Tx) error {
+			calls++
+			_, err := tx.ExecContext(ctx, "INSERT INTO t VALUES (1)")
+			return err
+		},
+	}}
+
+	for i := 0; i < 2; i++ {
+		_, err := migrate.Apply(context.Background(), db, migrations)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 1, calls)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM t").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+// Migrations are not applied while another node holds the lock.
+func TestApply_Locked(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	_, err := migrate.Apply(context.Background(), db, nil)
+	require.NoError(t, err)
+
+	expires := time.Now().Add(time.Hour).UnixNano()
+	_, err = db.Exec("INSERT INTO schema_migrations_lock VALUES (1, 'other', ?)", expires)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	migrations := []migrate.Migration{{Version: 1, Name: "create", SQL: "CREATE TABLE t (n INT)"}}
+	_, err = migrate.Apply(ctx, db, migrations, migrate.WithRetryInterval(10*time.Millisecond))
+	assert.EqualError(t, err, "acquire migration lock: context deadline exceeded")
+
+	// Expired locks are taken over.
+	_, err = db.Exec("UPDATE schema_migrations_lock SET expires_at = 0")
+	require.NoError(t, err)
+
+	n, err := migrate.Apply(context.Background(), db, migrations)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestApply_InvalidVersions(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	migrations := []migrate.Migration{
+		{Version: 1, Name: "a", SQL: "SELECT 1"},
+		{Version: 1, Name: "b", SQL: "SELECT 1"},
+	}
+	_, err := migrate.Apply(context.Background(), db, migrations)
+	assert.EqualError(t, err, "duplicate migration version 1")
+}
+
+func newDB(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "dqlite-migrate-test-")
+	require.NoError(t, err)
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	return db, cleanup
+}
@@ -1,10 +1,14 @@
 package dqlite
 
 import (
+	"fmt"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/canonical/go-dqlite/client"
 	"github.com/canonical/go-dqlite/internal/bindings"
+	"github.com/canonical/go-dqlite/internal/raft"
 	"github.com/pkg/errors"
 )
 
@@ -45,6 +49,69 @@ func WithNetworkLatency(latency time.Duration) Option {
 	}
 }
 
+// WithSnapshotCompression enables or disables compression of raft snapshots.
+//
+// When enabled, snapshots are compressed before being written to disk and the
+// compressed data is also what gets sent to followers that need to install a
+// snapshot, which trades some CPU time for smaller data directories and
+// faster catch-up of new members on large databases.
+func WithSnapshotCompression(enabled bool) Option {
+	return func(options *options) {
+		options.SnapshotCompression = &enabled
+	}
+}
+
+// WithAutoRecovery enables or disables the automatic repair of a raft log
+// segment that was only partially written, for example because the process
+// crashed in the middle of a write.
+//
+// When enabled, a copy of the damaged segment is saved in the data directory
+// and the invalid trailing data is dropped, so the node can start from the
+// last valid entry instead of refusing to start. Other kinds of damage are
+// never repaired, and make New fail.
+//
+// Since this drops data from the raft log, it must be asked for explicitly:
+// the default is false.
+func WithAutoRecovery(enabled bool) Option {
+	return func(options *options) {
+		options.AutoRecovery = enabled
+	}
+}
+
+// WithWorkerThreads sets the number of threads of the pool the engine uses to
+// write the raft log, take snapshots and do other blocking work off its event
+// loop, which serves all requests on a single thread. Hosts with fast disks and
+// many cores can raise it to sustain more concurrent writes, while constrained
+// devices can lower it to cap resource usage.
+//
+// The pool is shared by all the nodes of the process and sized when first
+// used, so this option only takes effect on the first node created, and
+// overrides the UV_THREADPOOL_SIZE environment variable. The number must be
+// between 1 and 1024, and the default is 4.
+func WithWorkerThreads(n int) Option {
+	return func(options *options) {
+		options.WorkerThreads = n
+	}
+}
+
+// WithBlockSize sets the size in bytes of the blocks the engine uses to write
+// the raft log to disk, which should match the block size of the underlying
+// device for best throughput. It must be a power of two.
+//
+// If not used, the engine detects the block size of the data directory.
+func WithBlockSize(size uint64) Option {
+	return func(options *options) {
+		options.BlockSize = size
+	}
+}
+
+// WithLogFunc sets a custom log function.
+func WithLogFunc(log client.LogFunc) Option {
+	return func(options *options) {
+		options.Log = log
+	}
+}
+
 // New creates a new Node instance.
 func New(id uint64, address string, dir string, options ...Option) (*Node, error) {
 	o := defaultOptions()
@@ -53,6 +120,29 @@ func New(id uint64, address string, dir string, options ...Option) (*Node, error
 		option(o)
 	}
 
+	if o.WorkerThreads != 0 {
+		if o.WorkerThreads < 1 || o.WorkerThreads > maxWorkerThreads {
+			return nil, fmt.Errorf("worker threads must be between 1 and %d", maxWorkerThreads)
+		}
+		if err := os.Setenv("UV_THREADPOOL_SIZE", strconv.Itoa(o.WorkerThreads)); err != nil {
+			return nil, errors.Wrap(err, "set worker threads")
+		}
+	}
+
+	if o.BlockSize != 0 && o.BlockSize&(o.BlockSize-1) != 0 {
+		return nil, fmt.Errorf("block size %d is not a power of two", o.BlockSize)
+	}
+
+	if o.AutoRecovery {
+		backup, err := raft.RepairTornSegment(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "repair torn segment")
+		}
+		if backup != "" {
+			o.Log(client.LogWarn, "repaired partially written raft segment, original saved as %s", backup)
+		}
+	}
+
 	server, err := bindings.NewNode(id, address, dir)
 	if err != nil {
 		return nil, err
@@ -72,7 +162,18 @@ func New(id uint64, address string, dir string, options ...Option) (*Node, error
 			return nil, err
 		}
 	}
+	if o.SnapshotCompression != nil {
+		if err := server.SetSnapshotCompression(*o.SnapshotCompression); err != nil {
+			return nil, err
+		}
+	}
+	if o.BlockSize != 0 {
+		if err := server.SetBlockSize(o.BlockSize); err != nil {
+			return nil, err
+		}
+	}
 	s := &Node{
+		log:         o.Log,
 		server:      server,
 		acceptCh:    make(chan error, 1),
 		id:          id,
@@ -101,12 +202,19 @@ func (s *Node) Recover(cluster []NodeInfo) error {
 	return s.server.Recover(cluster)
 }
 
+// Maximum size of the thread pool of the engine.
+const maxWorkerThreads = 1024
+
 // Hold configuration options for a dqlite server.
 type options struct {
-	Log            client.LogFunc
-	DialFunc       client.DialFunc
-	BindAddress    string
-	NetworkLatency uint64
+	Log                 client.LogFunc
+	DialFunc            client.DialFunc
+	BindAddress         string
+	NetworkLatency      uint64
+	SnapshotCompression *bool
+	AutoRecovery        bool
+	WorkerThreads       int
+	BlockSize           uint64
 }
 
 // Close the server, releasing all resources it created.
@@ -145,9 +253,97 @@ func ReconfigureMembership(dir string, cluster []NodeInfo) error {
 	return server.Recover(cluster)
 }
 
+// ReconfigureMembershipExt is like ReconfigureMembership, but also sets the
+// role of each node according to its Role field.
+func ReconfigureMembershipExt(dir string, cluster []NodeInfo) error {
+	server, err := bindings.NewNode(1, "1", dir)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	return server.RecoverExt(cluster)
+}
+
+// BootstrapCluster initializes the data directory of a brand new node with a
+// raft configuration containing all the given nodes and their roles.
+//
+// It must be called against the fresh, empty data directory of every node
+// listed in the given cluster, always passing the same list, before starting
+// any of them. Once started, the nodes will elect a leader among the ones with
+// the Voter role, without the need of adding them one by one.
+//
+// The C library has no bootstrap entry point taking more than one node, so
+// this uses the same recovery routine as ReconfigureMembershipExt, which
+// would overwrite the configuration of an existing node. For this reason a
+// directory holding any raft file, including an empty log or just the
+// metadata files, is refused.
+func BootstrapCluster(dir string, cluster []NodeInfo) error {
+	if err := validateCluster(cluster); err != nil {
+		return err
+	}
+	if err := checkPristine(dir); err != nil {
+		return err
+	}
+
+	return ReconfigureMembershipExt(dir, cluster)
+}
+
+// Check that the given cluster configuration is not empty, has at least one
+// voter and has no duplicate IDs or addresses.
+func validateCluster(cluster []NodeInfo) error {
+	if len(cluster) == 0 {
+		return fmt.Errorf("empty cluster")
+	}
+	ids := map[uint64]bool{}
+	addresses := map[string]bool{}
+	voters := 0
+	for _, node := range cluster {
+		if node.ID == 0 {
+			return fmt.Errorf("node %s has no ID", node.Address)
+		}
+		if ids[node.ID] {
+			return fmt.Errorf("duplicate node ID %d", node.ID)
+		}
+		if addresses[node.Address] {
+			return fmt.Errorf("duplicate node address %s", node.Address)
+		}
+		ids[node.ID] = true
+		addresses[node.Address] = true
+		if node.Role == client.Voter {
+			voters++
+		}
+	}
+	if voters == 0 {
+		return fmt.Errorf("cluster has no voters")
+	}
+	return nil
+}
+
+// Check that the given data directory holds no raft segments, snapshots or
+// metadata.
+func checkPristine(dir string) error {
+	segments, err := raft.ListSegments(dir)
+	if err != nil {
+		return err
+	}
+	snapshots, err := raft.ListSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	metadata, err := raft.ReadMetadata(dir)
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 || len(snapshots) > 0 || metadata.Version > 0 {
+		return fmt.Errorf("data directory %s already has raft data", dir)
+	}
+	return nil
+}
+
 // Create a options object with sane defaults.
 func defaultOptions() *options {
 	return &options{
+		Log:      client.DefaultLogFunc,
 		DialFunc: client.DefaultDialFunc,
 	}
 }
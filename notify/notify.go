@@ -0,0 +1,407 @@
+// Package notify delivers notifications about changes to the rows of dqlite
+// tables, so applications can for example invalidate caches without polling
+// the tables themselves.
+//
+// Changes are captured by triggers installed with Install, which record the
+// table, the kind of change and the rowid of each changed row in the
+// _dqlite_changes table. Since the table is replicated like any other, a
+// Listener running on any node of the cluster sees all changes, in commit
+// order, no matter which node made them.
+//
+// It's the same table the EnableChanges method of app.App sets up, so the
+// two can be used together.
+//
+// Only rowid tables are supported, WITHOUT ROWID tables can't be watched.
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// Op is the kind of a change.
+type Op string
+
+// Kinds of changes.
+const (
+	Insert Op = "insert"
+	Update Op = "update"
+	Delete Op = "delete"
+
+	// Reset is delivered when some changes were pruned before the listener
+	// could see them, for example because it was stopped for longer than
+	// the retention period. Subscribers should then assume that any row of
+	// any table might have changed.
+	Reset Op = "reset"
+)
+
+// Change describes a change to a single row.
+type Change struct {
+	Seq   int64  // Position of the change in the cluster-wide sequence.
+	Table string // Name of the changed table, empty for Reset.
+	Op    Op     // Kind of change.
+	RowID int64  // Rowid of the changed row, 0 for Reset.
+}
+
+// Name of the table holding the changes.
+const changesTable = "_dqlite_changes"
+
+const schema = `
+CREATE TABLE IF NOT EXISTS ` + changesTable + ` (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    tbl        TEXT NOT NULL,
+    op         TEXT NOT NULL,
+    row        INTEGER NOT NULL,
+    created_at INTEGER NOT NULL
+);
+`
+
+// Install creates the _dqlite_changes table, if needed, and the triggers
+// recording changes to the given tables. It's idempotent.
+func Install(ctx context.Context, db *sql.DB, tables ...string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("create changes table: %w", err)
+	}
+	for _, table := range tables {
+		for _, op := range []Op{Insert, Update, Delete} {
+			if _, err := tx.ExecContext(ctx, createTrigger(table, op)); err != nil {
+				return fmt.Errorf("create %s trigger on %s: %w", op, table, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Uninstall drops the triggers recording changes to the given tables.
+func Uninstall(ctx context.Context, db *sql.DB, tables ...string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		for _, op := range []Op{Insert, Update, Delete} {
+			stmt := fmt.Sprintf("DROP TRIGGER IF EXISTS %s", quoteIdent(triggerName(table, op)))
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("drop %s trigger on %s: %w", op, table, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func triggerName(table string, op Op) string {
+	return fmt.Sprintf("%s_%s_%s", changesTable, table, op)
+}
+
+func createTrigger(table string, op Op) string {
+	row := "NEW.rowid"
+	if op == Delete {
+		row = "OLD.rowid"
+	}
+	return fmt.Sprintf(`
+CREATE TRIGGER IF NOT EXISTS %s AFTER %s ON %s
+BEGIN
+    INSERT INTO %s (tbl, op, row, created_at)
+    VALUES (%s, '%s', %s, CAST(strftime('%%s', 'now') AS INTEGER));
+END`, quoteIdent(triggerName(table, op)), strings.ToUpper(string(op)), quoteIdent(table), changesTable, quoteString(table), op, row)
+}
+
+// Since returns the changes following the one with the given sequence
+// number, in commit order, at most limit of them, or all of them if limit is
+// negative. Use 0 to get all changes still in the table.
+func Since(ctx context.Context, db *sql.DB, seq int64, limit int) ([]Change, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT seq, tbl, op, row FROM "+changesTable+" WHERE seq > ? ORDER BY seq LIMIT ?", seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []Change{}
+	for rows.Next() {
+		change := Change{}
+		if err := rows.Scan(&change.Seq, &change.Table, &change.Op, &change.RowID); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+// Prune deletes the changes with a sequence number lower than or equal to the
+// given one.
+func Prune(ctx context.Context, db *sql.DB, seq int64) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM "+changesTable+" WHERE seq <= ?", seq)
+	return err
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteString(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
+
+// Option can be used to tweak listener parameters.
+type Option func(*options)
+
+// WithPollInterval sets how often the listener checks for new changes, 250
+// milliseconds by default.
+func WithPollInterval(interval time.Duration) Option {
+	return func(options *options) {
+		options.PollInterval = interval
+	}
+}
+
+// WithRetention sets how long changes are kept in the _dqlite_changes table
+// before being pruned, 1 hour by default. Listeners stopped for longer than
+// this get a Reset change when restarted.
+func WithRetention(retention time.Duration) Option {
+	return func(options *options) {
+		options.Retention = retention
+	}
+}
+
+// WithLogFunc sets a custom log function.
+func WithLogFunc(log client.LogFunc) Option {
+	return func(options *options) {
+		options.Log = log
+	}
+}
+
+type options struct {
+	PollInterval time.Duration
+	Retention    time.Duration
+	Log          client.LogFunc
+}
+
+// Create a options object with sane defaults.
+func defaultOptions() *options {
+	return &options{
+		PollInterval: 250 * time.Millisecond,
+		Retention:    time.Hour,
+		Log:          client.DefaultLogFunc,
+	}
+}
+
+// Maximum number of changes fetched by a single poll.
+const batchSize = 500
+
+// Listener watches the _dqlite_changes table and dispatches new changes to
+// subscriptions.
+type Listener struct {
+	db      *sql.DB
+	options *options
+	mu      sync.Mutex
+	subs    map[*Subscription]struct{}
+	seq     int64 // Last change seen.
+	pruned  time.Time
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Listen starts a listener delivering the changes made from now on. The
+// _dqlite_changes table must have been created with Install.
+func Listen(ctx context.Context, db *sql.DB, options ...Option) (*Listener, error) {
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+
+	l := &Listener{
+		db:      db,
+		options: o,
+		subs:    map[*Subscription]struct{}{},
+		done:    make(chan struct{}),
+	}
+
+	// Use the AUTOINCREMENT counter rather than max(seq), which would be
+	// lower if the latest changes were pruned.
+	row := db.QueryRowContext(ctx,
+		"SELECT coalesce((SELECT seq FROM sqlite_sequence WHERE name = ?), 0)", changesTable)
+	if err := row.Scan(&l.seq); err != nil {
+		return nil, fmt.Errorf("get last change: %w", err)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	go l.run(runCtx)
+
+	return l, nil
+}
+
+// Subscribe returns a subscription to the changes of the given tables, or of
+// all tables if none is given.
+//
+// Changes are delivered in order and the listener waits for each subscriber
+// to receive them, so subscribers must consume their changes promptly.
+func (l *Listener) Subscribe(tables ...string) *Subscription {
+	s := &Subscription{
+		listener: l,
+		tables:   map[string]bool{},
+		changes:  make(chan Change, 64),
+		closed:   make(chan struct{}),
+	}
+	for _, table := range tables {
+		s.tables[table] = true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-l.done:
+		close(s.changes)
+	default:
+		l.subs[s] = struct{}{}
+	}
+
+	return s
+}
+
+// Close stops the listener, closing the channels of all subscriptions.
+func (l *Listener) Close() error {
+	l.cancel()
+	<-l.done
+	return nil
+}
+
+func (l *Listener) run(ctx context.Context) {
+	defer func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for s := range l.subs {
+			close(s.changes)
+		}
+		l.subs = nil
+		close(l.done)
+	}()
+
+	for {
+		n, err := l.poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			l.options.Log(client.LogWarn, "notify: poll changes: %v", err)
+		}
+		if err == nil && n == batchSize {
+			continue // There might be more changes.
+		}
+
+		if err := l.prune(ctx); err != nil && ctx.Err() == nil {
+			l.options.Log(client.LogWarn, "notify: prune changes: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(l.options.PollInterval):
+		}
+	}
+}
+
+// Fetch and dispatch new changes, returning how many were found.
+func (l *Listener) poll(ctx context.Context) (int, error) {
+	changes, err := Since(ctx, l.db, l.seq, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	// Sequence numbers are contiguous, since rolled back transactions don't
+	// consume them, so a gap means changes were pruned.
+	if len(changes) > 0 && changes[0].Seq > l.seq+1 {
+		if !l.dispatch(ctx, Change{Seq: changes[0].Seq - 1, Op: Reset}) {
+			return 0, ctx.Err()
+		}
+	}
+	for _, change := range changes {
+		if !l.dispatch(ctx, change) {
+			return 0, ctx.Err()
+		}
+		l.seq = change.Seq
+	}
+
+	return len(changes), nil
+}
+
+// Deliver the given change to all interested subscriptions. Return false if
+// the listener was stopped.
+func (l *Listener) dispatch(ctx context.Context, change Change) bool {
+	l.mu.Lock()
+	subs := make([]*Subscription, 0, len(l.subs))
+	for s := range l.subs {
+		if s.wants(change) {
+			subs = append(subs, s)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, s := range subs {
+		select {
+		case s.changes <- change:
+		case <-s.closed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
+
+// Delete changes older than the retention period, at most once a minute.
+func (l *Listener) prune(ctx context.Context) error {
+	now := time.Now()
+	if now.Sub(l.pruned) < time.Minute {
+		return nil
+	}
+	l.pruned = now
+
+	_, err := l.db.ExecContext(ctx,
+		"DELETE FROM "+changesTable+" WHERE created_at < ?", now.Add(-l.options.Retention).Unix())
+	return err
+}
+
+// Subscription receives the changes of a set of tables.
+type Subscription struct {
+	listener *Listener
+	tables   map[string]bool
+	changes  chan Change
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// Changes returns the channel changes are delivered to. It's closed when the
+// listener is closed.
+func (s *Subscription) Changes() <-chan Change {
+	return s.changes
+}
+
+// Close stops delivering changes to the subscription.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.closed)
+		l := s.listener
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subs, s)
+	})
+}
+
+func (s *Subscription) wants(change Change) bool {
+	return change.Op == Reset || len(s.tables) == 0 || s.tables[change.Table]
+}
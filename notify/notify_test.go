@@ -0,0 +1,144 @@
+package notify_test
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/notify"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListener(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	_, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)")
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE other (n INT)")
+	require.NoError(t, err)
+	require.NoError(t, notify.Install(ctx, db, "items", "other"))
+
+	// Changes made before the listener starts are not delivered.
+	_, err = db.Exec("INSERT INTO items (id, name) VALUES (1, 'a')")
+	require.NoError(t, err)
+
+	listener, err := notify.Listen(ctx, db, notify.WithPollInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer listener.Close()
+
+	sub := listener.Subscribe("items")
+	defer sub.Close()
+
+	_, err = db.Exec("INSERT INTO items (id, name) VALUES (2, 'b')")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO other VALUES (1)")
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE items SET name = 'c' WHERE id = 1")
+	require.NoError(t, err)
+	_, err = db.Exec("DELETE FROM items WHERE id = 2")
+	require.NoError(t, err)
+
+	expected := []struct {
+		op    notify.Op
+		rowid int64
+	}{
+		{notify.Insert, 2},
+		{notify.Update, 1},
+		{notify.Delete, 2},
+	}
+	for _, e := range expected {
+		select {
+		case change := <-sub.Changes():
+			assert.Equal(t, "items", change.Table)
+			assert.Equal(t, e.op, change.Op)
+			assert.Equal(t, e.rowid, change.RowID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change delivered")
+		}
+	}
+}
+
+func TestListener_Close(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	require.NoError(t, notify.Install(ctx, db))
+
+	listener, err := notify.Listen(ctx, db)
+	require.NoError(t, err)
+
+	sub := listener.Subscribe()
+	require.NoError(t, listener.Close())
+
+	_, ok := <-sub.Changes()
+	assert.False(t, ok)
+}
+
+func TestUninstall(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	_, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	require.NoError(t, notify.Install(ctx, db, "items"))
+	require.NoError(t, notify.Uninstall(ctx, db, "items"))
+
+	_, err = db.Exec("INSERT INTO items VALUES (1)")
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM _dqlite_changes").Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestSince(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	_, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	require.NoError(t, notify.Install(ctx, db, "items"))
+
+	_, err = db.Exec("INSERT INTO items VALUES (1); INSERT INTO items VALUES (2); DELETE FROM items WHERE id = 1")
+	require.NoError(t, err)
+
+	changes, err := notify.Since(ctx, db, 0, -1)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	assert.Equal(t, notify.Change{Seq: 3, Table: "items", Op: notify.Delete, RowID: 1}, changes[2])
+
+	changes, err = notify.Since(ctx, db, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []notify.Change{{Seq: 2, Table: "items", Op: notify.Insert, RowID: 2}}, changes)
+
+	require.NoError(t, notify.Prune(ctx, db, 2))
+	changes, err = notify.Since(ctx, db, 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []notify.Change{{Seq: 3, Table: "items", Op: notify.Delete, RowID: 1}}, changes)
+}
+
+func newDB(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "dqlite-notify-test-")
+	require.NoError(t, err)
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	return db, cleanup
+}
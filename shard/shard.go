@@ -0,0 +1,275 @@
+// Package shard implements a routing layer on top of multiple independent
+// dqlite clusters (shards), for workloads whose write throughput exceeds what
+// a single raft group can sustain.
+//
+// Each shard is a separate dqlite cluster with its own leader. Data is
+// partitioned by routing either by database name or by an arbitrary key,
+// using rendezvous hashing, so that adding a shard only moves the keys that
+// end up being assigned to it.
+package shard
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+)
+
+// Shard describes a single dqlite cluster managed by a Router.
+type Shard struct {
+	Name  string           // Unique name of the shard.
+	Store client.NodeStore // Holds the addresses of the shard nodes.
+}
+
+// Member holds information about a single node of a shard.
+type Member struct {
+	Shard string
+	client.NodeInfo
+}
+
+// Router routes database operations to the shard owning the relevant data.
+type Router struct {
+	shards map[string]*shard
+	names  []string // Sorted shard names.
+	log    client.LogFunc
+	dial   client.DialFunc
+}
+
+type shard struct {
+	name   string
+	store  client.NodeStore
+	driver *driver.Driver
+	mu     sync.Mutex
+	dbs    map[string]*sql.DB // Cached database handles.
+}
+
+// Option can be used to tweak router parameters.
+type Option func(*options)
+
+// WithDialFunc sets a custom dial function for connecting to shard nodes.
+func WithDialFunc(dial client.DialFunc) Option {
+	return func(options *options) {
+		options.Dial = dial
+	}
+}
+
+// WithLogFunc sets a custom log function.
+func WithLogFunc(log client.LogFunc) Option {
+	return func(options *options) {
+		options.Log = log
+	}
+}
+
+type options struct {
+	Dial client.DialFunc
+	Log  client.LogFunc
+}
+
+func defaultOptions() *options {
+	return &options{
+		Dial: client.DefaultDialFunc,
+		Log:  client.DefaultLogFunc,
+	}
+}
+
+// New creates a new Router managing the given shards.
+func New(shards []Shard, options ...Option) (*Router, error) {
+	o := defaultOptions()
+	for _, option := range options {
+		option(o)
+	}
+
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no shards given")
+	}
+
+	r := &Router{
+		shards: map[string]*shard{},
+		log:    o.Log,
+		dial:   o.Dial,
+	}
+	for _, s := range shards {
+		if s.Name == "" {
+			return nil, fmt.Errorf("shard has no name")
+		}
+		if _, ok := r.shards[s.Name]; ok {
+			return nil, fmt.Errorf("duplicate shard %q", s.Name)
+		}
+		drv, err := driver.New(s.Store, driver.WithDialFunc(o.Dial), driver.WithLogFunc(o.Log))
+		if err != nil {
+			return nil, fmt.Errorf("create driver for shard %q: %w", s.Name, err)
+		}
+		r.shards[s.Name] = &shard{
+			name:   s.Name,
+			store:  s.Store,
+			driver: drv,
+			dbs:    map[string]*sql.DB{},
+		}
+		r.names = append(r.names, s.Name)
+	}
+	sort.Strings(r.names)
+
+	return r, nil
+}
+
+// Shards returns the names of all shards, in lexicographic order.
+func (r *Router) Shards() []string {
+	names := make([]string, len(r.names))
+	copy(names, r.names)
+	return names
+}
+
+// Route returns the name of the shard owning the given key.
+//
+// The same key is always routed to the same shard, as long as the set of
+// shards doesn't change.
+func (r *Router) Route(key string) string {
+	var owner string
+	var max uint64
+	for _, name := range r.names {
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := mix(h.Sum64()); owner == "" || score > max {
+			owner = name
+			max = score
+		}
+	}
+	return owner
+}
+
+// Improve the avalanche behavior of FNV, which is poor for short keys that
+// only differ in their last bytes (splitmix64 finalizer).
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// DB returns a handle to the given database on the shard owning it, routing
+// by database name.
+func (r *Router) DB(database string) (*sql.DB, error) {
+	return r.ShardDB(r.Route(database), database)
+}
+
+// KeyDB returns a handle to the given database on the shard owning the given
+// key. This makes it possible to partition a single logical database across
+// shards.
+func (r *Router) KeyDB(key string, database string) (*sql.DB, error) {
+	return r.ShardDB(r.Route(key), database)
+}
+
+// ShardDB returns a handle to the given database on the given shard.
+//
+// Handles are cached, so repeated calls return the same *sql.DB object,
+// which must not be closed by the caller. Use Close() to release them.
+func (r *Router) ShardDB(name string, database string) (*sql.DB, error) {
+	s, ok := r.shards[name]
+	if !ok {
+		return nil, fmt.Errorf("no shard named %q", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if db, ok := s.dbs[database]; ok {
+		return db, nil
+	}
+
+	connector, err := s.driver.OpenConnector(database)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	s.dbs[database] = db
+
+	return db, nil
+}
+
+// Leaders returns the current leader of each shard, indexed by shard name.
+//
+// Shards whose leader can't be found are not included, and the error
+// encountered for the first of them is returned along with the partial
+// result.
+func (r *Router) Leaders(ctx context.Context) (map[string]client.NodeInfo, error) {
+	leaders := map[string]client.NodeInfo{}
+	var firstErr error
+	for _, name := range r.names {
+		leader, err := r.leader(ctx, r.shards[name])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("shard %q: %w", name, err)
+			}
+			continue
+		}
+		leaders[name] = *leader
+	}
+	return leaders, firstErr
+}
+
+// Cluster returns the combined membership of all shards.
+//
+// Shards whose membership can't be retrieved are not included, and the
+// error encountered for the first of them is returned along with the partial
+// result.
+func (r *Router) Cluster(ctx context.Context) ([]Member, error) {
+	members := []Member{}
+	var firstErr error
+	for _, name := range r.names {
+		nodes, err := r.cluster(ctx, r.shards[name])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("shard %q: %w", name, err)
+			}
+			continue
+		}
+		for _, node := range nodes {
+			members = append(members, Member{Shard: name, NodeInfo: node})
+		}
+	}
+	return members, firstErr
+}
+
+// Close releases all cached database handles.
+func (r *Router) Close() error {
+	var firstErr error
+	for _, name := range r.names {
+		s := r.shards[name]
+		s.mu.Lock()
+		for database, db := range s.dbs {
+			if err := db.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			delete(s.dbs, database)
+		}
+		s.mu.Unlock()
+	}
+	return firstErr
+}
+
+func (r *Router) leader(ctx context.Context, s *shard) (*client.NodeInfo, error) {
+	cli, err := client.FindLeader(ctx, s.store, client.WithDialFunc(r.dial), client.WithLogFunc(r.log))
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	return cli.Leader(ctx)
+}
+
+func (r *Router) cluster(ctx context.Context, s *shard) ([]client.NodeInfo, error) {
+	cli, err := client.FindLeader(ctx, s.store, client.WithDialFunc(r.dial), client.WithLogFunc(r.log))
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	return cli.Cluster(ctx)
+}
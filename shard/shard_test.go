@@ -0,0 +1,65 @@
+package shard_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/shard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Keys are always routed to the same shard and spread across all of them.
+func TestRouter_Route(t *testing.T) {
+	router := newRouter(t, "a", "b", "c")
+
+	counts := map[string]int{}
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		name := router.Route(key)
+		assert.Equal(t, name, router.Route(key))
+		counts[name]++
+	}
+
+	assert.Len(t, counts, 3)
+	for _, count := range counts {
+		assert.True(t, count > 50)
+	}
+}
+
+// Adding a shard only moves keys to the new shard.
+func TestRouter_RouteAddShard(t *testing.T) {
+	before := newRouter(t, "a", "b")
+	after := newRouter(t, "a", "b", "c")
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if name := after.Route(key); name != "c" {
+			assert.Equal(t, before.Route(key), name)
+		}
+	}
+}
+
+func TestNew_DuplicateShard(t *testing.T) {
+	shards := []shard.Shard{
+		{Name: "a", Store: client.NewInmemNodeStore()},
+		{Name: "a", Store: client.NewInmemNodeStore()},
+	}
+	_, err := shard.New(shards)
+	assert.EqualError(t, err, `duplicate shard "a"`)
+}
+
+func newRouter(t *testing.T, names ...string) *shard.Router {
+	t.Helper()
+
+	shards := make([]shard.Shard, len(names))
+	for i, name := range names {
+		shards[i] = shard.Shard{Name: name, Store: client.NewInmemNodeStore()}
+	}
+
+	router, err := shard.New(shards)
+	require.NoError(t, err)
+
+	return router
+}
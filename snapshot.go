@@ -0,0 +1,309 @@
+package dqlite
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/canonical/go-dqlite/internal/raft"
+)
+
+// SnapshotArchiveVersion is the version of the snapshot archive format
+// written by ExportSnapshot.
+//
+// A snapshot archive is a tar stream holding the following entries, in order:
+//
+//   - "metadata.json": a JSON object describing the snapshot, see
+//     SnapshotArchiveInfo.
+//   - "snapshot": the raft snapshot data file, as found in the data directory.
+//   - "snapshot.meta": the raft snapshot metadata file, holding the cluster
+//     configuration at the time of the snapshot.
+//
+// The metadata object lists the SHA-256 checksums of the other entries, which
+// are verified on import.
+const SnapshotArchiveVersion = 1
+
+// Names of the entries of a snapshot archive.
+const (
+	snapshotArchiveMetadata = "metadata.json"
+	snapshotArchiveData     = "snapshot"
+	snapshotArchiveMeta     = "snapshot.meta"
+)
+
+// SnapshotArchiveInfo describes the snapshot held by a snapshot archive. It's
+// stored as the "metadata.json" entry of the archive.
+type SnapshotArchiveInfo struct {
+	Version            int                   `json:"version"`
+	Created            time.Time             `json:"created"`
+	Term               uint64                `json:"term"`
+	Index              uint64                `json:"index"`
+	Timestamp          uint64                `json:"timestamp"`
+	ConfigurationIndex uint64                `json:"configuration_index"`
+	Configuration      []SnapshotArchiveNode `json:"configuration"`
+	Checksums          map[string]string     `json:"checksums"` // SHA-256 of each entry.
+}
+
+// SnapshotArchiveNode describes a node in the configuration of a snapshot
+// archive.
+type SnapshotArchiveNode struct {
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+	Role    string `json:"role"` // Either "voter", "stand-by" or "spare".
+}
+
+// ExportSnapshot writes the most recent raft snapshot found in the given data
+// directory to the given writer, as a snapshot archive.
+//
+// It should be run against the data directory of a stopped node, or at least
+// of a node not taking new snapshots, since snapshot files are removed when
+// newer ones are taken.
+func ExportSnapshot(dir string, w io.Writer) (*SnapshotArchiveInfo, error) {
+	snapshots, err := raft.ListSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("no snapshot found in %s", dir)
+	}
+	snapshot := snapshots[len(snapshots)-1]
+
+	meta, err := raft.ReadSnapshotMeta(filepath.Join(dir, snapshot.Meta()))
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", snapshot.Meta(), err)
+	}
+
+	info := &SnapshotArchiveInfo{
+		Version:            SnapshotArchiveVersion,
+		Created:            time.Now().UTC(),
+		Term:               snapshot.Term,
+		Index:              snapshot.Index,
+		Timestamp:          snapshot.Timestamp,
+		ConfigurationIndex: meta.ConfigurationIndex,
+		Checksums:          map[string]string{},
+	}
+	for _, node := range nodesFromServers(meta.Configuration) {
+		info.Configuration = append(info.Configuration, SnapshotArchiveNode{
+			ID:      node.ID,
+			Address: node.Address,
+			Role:    node.Role.String(),
+		})
+	}
+
+	files := map[string]string{
+		snapshotArchiveData: filepath.Join(dir, snapshot.Filename),
+		snapshotArchiveMeta: filepath.Join(dir, snapshot.Meta()),
+	}
+	for name, path := range files {
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return nil, err
+		}
+		info.Checksums[name] = sum
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeTarEntry(tw, snapshotArchiveMetadata, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{snapshotArchiveData, snapshotArchiveMeta} {
+		if err := writeTarFile(tw, name, files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// ImportSnapshot seeds the given pristine data directory with the snapshot
+// held by the snapshot archive read from the given reader.
+//
+// If cluster is empty, the node started on the directory must be one of the
+// nodes in the configuration of the snapshot, for example to recover a
+// member of the original cluster from a cold standby. Otherwise the
+// configuration is replaced by the given one, which makes it possible to
+// clone an environment as a brand-new cluster: like with BootstrapCluster,
+// the same archive and cluster must then be imported into the data directory
+// of every node listed in it before starting any of them.
+func ImportSnapshot(dir string, r io.Reader, cluster []NodeInfo) (*SnapshotArchiveInfo, error) {
+	if len(cluster) > 0 {
+		if err := validateCluster(cluster); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkPristine(dir); err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("read archive: %w", err)
+	}
+	if header.Name != snapshotArchiveMetadata {
+		return nil, fmt.Errorf("archive doesn't start with %s", snapshotArchiveMetadata)
+	}
+	info := &SnapshotArchiveInfo{}
+	if err := json.NewDecoder(tr).Decode(info); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", snapshotArchiveMetadata, err)
+	}
+	if info.Version != SnapshotArchiveVersion {
+		return nil, fmt.Errorf("unsupported snapshot archive version %d", info.Version)
+	}
+
+	name := fmt.Sprintf("snapshot-%d-%d-%d", info.Term, info.Index, info.Timestamp)
+	files := map[string]string{
+		snapshotArchiveData: filepath.Join(dir, name),
+		snapshotArchiveMeta: filepath.Join(dir, name+".meta"),
+	}
+
+	// Extract the files under temporary names, and rename them only once
+	// all of them are verified, data file first, since raft ignores
+	// snapshots without metadata file.
+	defer func() {
+		for _, path := range files {
+			os.Remove(path + ".tmp")
+		}
+	}()
+	extracted := map[string]bool{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+		path, ok := files[header.Name]
+		if !ok || extracted[header.Name] {
+			return nil, fmt.Errorf("unexpected archive entry %s", header.Name)
+		}
+		sum, err := extractFile(path+".tmp", tr)
+		if err != nil {
+			return nil, err
+		}
+		if sum != info.Checksums[header.Name] {
+			return nil, fmt.Errorf("checksum mismatch for %s", header.Name)
+		}
+		extracted[header.Name] = true
+	}
+	for name := range files {
+		if !extracted[name] {
+			return nil, fmt.Errorf("archive has no %s entry", name)
+		}
+	}
+	if _, err := raft.ReadSnapshotMeta(files[snapshotArchiveMeta] + ".tmp"); err != nil {
+		return nil, fmt.Errorf("invalid snapshot metadata: %w", err)
+	}
+
+	for _, name := range []string{snapshotArchiveData, snapshotArchiveMeta} {
+		if err := os.Rename(files[name]+".tmp", files[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Make sure the node won't start with a term older than the snapshot.
+	metadata, err := raft.ReadMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Term < info.Term {
+		metadata.Version++
+		metadata.Term = info.Term
+		metadata.VotedFor = 0
+		if err := raft.WriteMetadata(dir, metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cluster) > 0 {
+		if err := ReconfigureMembershipExt(dir, cluster); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// Write a tar entry with the given name, holding the content of the given
+// file.
+func writeTarFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeTarEntry(tw, name, stat.Size(), f)
+}
+
+func writeTarEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// Write the content of the given reader to a new file, returning its
+// checksum.
+func extractFile(path string, r io.Reader) (string, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Return the SHA-256 checksum of the given file.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
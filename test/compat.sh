@@ -0,0 +1,203 @@
+#!/bin/bash -eu
+#
+# Test wire compatibility between the current client and servers from older
+# releases, and vice versa.
+#
+# Older go-dqlite releases are given as git refs in COMPAT_REFS (by default
+# the three most recent tags). For each of them, a cluster of dqlite-demo
+# nodes built from that ref is driven by the dqlite shell built from the
+# working tree, and a cluster built from the working tree is driven by the
+# shell built from that ref.
+#
+# Older releases of the dqlite C library can be tested by listing in
+# COMPAT_LIBS the directories holding their libdqlite.so builds: the current
+# dqlite-demo is then run against each of them via LD_LIBRARY_PATH.
+
+GO=${GO:-go}
+VERBOSE=${VERBOSE:-0}
+COMPAT_REFS=${COMPAT_REFS:-$(git tag --sort=-v:refname | head -3)}
+COMPAT_LIBS=${COMPAT_LIBS:-}
+
+DIR=$(mktemp -d)
+CLUSTER=127.0.0.1:9001,127.0.0.1:9002,127.0.0.1:9003
+
+# Build the dqlite-demo and dqlite binaries from the given git ref, or from
+# the working tree if the ref is "current".
+build() {
+    ref=$1
+    out="${DIR}/bin/${ref}"
+
+    echo "=> Build binaries from ${ref}"
+
+    mkdir -p "${out}"
+    if [ "${ref}" = "current" ]; then
+        $GO build -tags libsqlite3 -o "${out}/" ./cmd/dqlite-demo ./cmd/dqlite
+        return
+    fi
+
+    src="${DIR}/src/${ref}"
+    git worktree add --detach "${src}" "${ref}" > /dev/null
+    (cd "${src}" && $GO build -tags libsqlite3 -o "${out}/" ./cmd/dqlite-demo ./cmd/dqlite)
+    git worktree remove --force "${src}"
+}
+
+start_node() {
+    n="${1}"
+    bin="${2}"
+    libs="${3}"
+    pidfile="${DIR}/pid.${n}"
+    join=""
+    verbose=""
+
+    if [ $n -ne 1 ]; then
+        join=--join=127.0.0.1:9001
+    fi
+    if [ $VERBOSE -eq 1 ]; then
+        verbose="--verbose"
+    fi
+
+    mkdir -p "${DIR}/data/${n}"
+    LD_LIBRARY_PATH="${libs}" "${bin}/dqlite-demo" --dir "${DIR}/data/${n}" \
+        --api=127.0.0.1:800${n} --db=127.0.0.1:900${n} $join $verbose &
+    echo "${!}" > "${pidfile}"
+
+    i=0
+    while ! nc -z 127.0.0.1 800${n} 2>/dev/null; do
+        i=$(expr $i + 1)
+        sleep 0.2
+        if [ $i -eq 25 ]; then
+            echo "Error: node ${n} not yet up after 5 seconds"
+            exit 1
+        fi
+    done
+}
+
+kill_node() {
+    n=$1
+    pidfile="${DIR}/pid.${n}"
+
+    if ! [ -e $pidfile ]; then
+        return
+    fi
+
+    pid=$(cat ${pidfile})
+
+    kill -TERM $pid
+    wait $pid || true
+
+    rm ${pidfile}
+}
+
+# Run the dqlite shell from the given binaries against the demo database,
+# failing if it does not return within 30 seconds.
+shell() {
+    bin=$1
+    shift
+    timeout 30 "${bin}/dqlite" -s $CLUSTER demo "$@"
+}
+
+# Start a cluster from the server binaries and exercise it with the client
+# binaries.
+check() {
+    server=$1
+    client=$2
+    libs=${3:-}
+    label="${server} server, ${client} client"
+    if [ -n "${libs}" ]; then
+        label="${label}, libdqlite from ${libs}"
+    fi
+
+    echo "=> Check ${label}"
+
+    for n in 1 2 3; do
+        start_node $n "${DIR}/bin/${server}" "${libs}"
+    done
+
+    # Data written by the server side must be readable by the client.
+    if [ "$(curl -s -X PUT -d server-value http://127.0.0.1:8001/server-key)" != "done" ]; then
+        echo "Error: put key with ${label}"
+        exit 1
+    fi
+    if [ "$(shell "${DIR}/bin/${client}" "SELECT value FROM model WHERE key = 'server-key'")" != "server-value" ]; then
+        echo "Error: read key with ${label}"
+        exit 1
+    fi
+
+    # Data written by the client must be readable by the server side.
+    shell "${DIR}/bin/${client}" "INSERT INTO model(key, value) VALUES('client-key', 'client-value')"
+    if [ "$(curl -s http://127.0.0.1:8002/client-key)" != "client-value" ]; then
+        echo "Error: write key with ${label}"
+        exit 1
+    fi
+
+    # Membership requests must be understood.
+    if [ "$(shell "${DIR}/bin/${client}" .cluster | wc -l)" -ne 3 ]; then
+        echo "Error: list cluster with ${label}"
+        exit 1
+    fi
+    shell "${DIR}/bin/${client}" .leader > /dev/null
+
+    # Requests that the other side might not support must fail cleanly
+    # rather than hang or crash the server.
+    set +e
+    shell "${DIR}/bin/${client}" .dump > /dev/null 2>&1
+    if [ $? -eq 124 ]; then
+        echo "Error: dump hangs with ${label}"
+        exit 1
+    fi
+    set -e
+    for n in 1 2 3; do
+        if ! kill -0 "$(cat "${DIR}/pid.${n}")" 2>/dev/null; then
+            echo "Error: node ${n} crashed with ${label}"
+            exit 1
+        fi
+    done
+
+    for n in 3 2 1; do
+        kill_node $n
+    done
+    rm -rf "${DIR}/data"
+}
+
+tear_down() {
+    err=$?
+    trap '' HUP INT TERM
+
+    echo "=> Tear down"
+    for n in 3 2 1; do
+        kill_node $n
+    done
+    git worktree prune
+
+    rm -rf $DIR
+
+    exit $err
+}
+
+sig_handler() {
+    trap '' EXIT
+    tear_down
+}
+
+trap tear_down EXIT
+trap sig_handler HUP INT TERM
+
+build current
+for ref in $COMPAT_REFS; do
+    build "${ref}"
+done
+
+echo "=> Start test"
+
+check current current
+
+for ref in $COMPAT_REFS; do
+    check "${ref}" current
+    check current "${ref}"
+done
+
+for libs in $COMPAT_LIBS; do
+    check current current "${libs}"
+done
+
+echo "=> Test successful"